@@ -0,0 +1,743 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ann maintains an approximate nearest neighbor index over vectors stored in Badger.
+//
+// The index is an inverted file (IVF) index: vectors are grouped into lists around centroids
+// computed by k-means, and searches only scan the lists whose centroids are the closest to the
+// query. Vectors are added and deleted as part of the caller's transactions, so the index is
+// always consistent with the data it describes:
+//
+//	ix, err := ann.Open(db, []byte("emb/"), ann.DefaultOptions(384))
+//	...
+//	err = db.Update(func(txn *badger.Txn) error {
+//		if err := txn.Set(docKey, doc); err != nil {
+//			return err
+//		}
+//		return ix.Add(txn, docKey, embedding)
+//	})
+//	...
+//	err = ix.Train() // Once enough vectors got added, and again as they change.
+//	...
+//	err = db.View(func(txn *badger.Txn) error {
+//		results, err = ix.Search(txn, query, 10)
+//		return err
+//	})
+//
+// Until Train gets called, searches scan all the vectors, and return exact results.
+package ann
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// Key layout, under the index prefix. Generations are bumped by Train, each one having its own
+// centroids and lists.
+const (
+	genTag      = 'g' // Current generation.
+	pendingTag  = 'p' // Generation being built by Train, if any.
+	centroidTag = 'c' // Centroids, followed by the big-endian generation.
+	vectorTag   = 'v' // Vectors, followed by their ID.
+	listTag     = 'l' // Copies of the vectors, followed by generation, list and vector ID.
+)
+
+// Metric is the distance used to compare vectors.
+type Metric int
+
+const (
+	// Euclidean compares vectors with their squared euclidean distance.
+	Euclidean Metric = iota
+	// Cosine compares vectors with one minus their cosine similarity.
+	Cosine
+	// DotProduct compares vectors with the opposite of their dot product.
+	DotProduct
+)
+
+// Options are the options of an index.
+type Options struct {
+	// Dimensions is the number of dimensions of the vectors.
+	Dimensions int
+	Metric     Metric
+	// Lists is the number of lists the vectors get grouped into by Train.
+	Lists int
+	// Probes is the number of lists scanned by Search. Higher values give better results, at the
+	// expense of speed.
+	Probes int
+	// TrainingSample is the maximum number of vectors used by Train to compute the centroids.
+	TrainingSample int
+	// Vectorize computes the vector of a value for AddValue.
+	Vectorize func(value []byte) ([]float32, error)
+}
+
+// DefaultOptions returns the default options for vectors with the given number of dimensions.
+func DefaultOptions(dimensions int) Options {
+	return Options{
+		Dimensions:     dimensions,
+		Metric:         Euclidean,
+		Lists:          256,
+		Probes:         8,
+		TrainingSample: 20000,
+	}
+}
+
+// Result is a vector found by Search.
+type Result struct {
+	ID       []byte
+	Distance float32
+}
+
+// Index is an approximate nearest neighbor index keeping its data under a key prefix.
+type Index struct {
+	db     *badger.DB
+	prefix []byte
+	opt    Options
+
+	sync.Mutex
+	centroids map[uint32][][]float32 // By generation.
+	trainMu   sync.Mutex
+}
+
+// Open returns the index keeping its data in db under prefix. The prefix must not be used for
+// anything else.
+func Open(db *badger.DB, prefix []byte, opt Options) (*Index, error) {
+	if opt.Dimensions <= 0 {
+		return nil, errors.New("The number of dimensions must be positive")
+	}
+	if opt.Lists <= 0 || opt.Probes <= 0 {
+		return nil, errors.New("The number of lists and probes must be positive")
+	}
+	return &Index{
+		db:        db,
+		prefix:    append([]byte{}, prefix...),
+		opt:       opt,
+		centroids: make(map[uint32][][]float32),
+	}, nil
+}
+
+func (ix *Index) key(tag byte, suffix ...byte) []byte {
+	key := make([]byte, 0, len(ix.prefix)+1+len(suffix))
+	key = append(key, ix.prefix...)
+	key = append(key, tag)
+	return append(key, suffix...)
+}
+
+func (ix *Index) listPrefix(gen, list uint32) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], gen)
+	binary.BigEndian.PutUint32(buf[4:], list)
+	return ix.key(listTag, buf[:]...)
+}
+
+func encodeVector(vec []float32) []byte {
+	buf := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vec := make([]float32, len(buf)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vec
+}
+
+// getUint32 reads the big-endian uint32 stored at key, 0 if there's none.
+func getUint32(txn *badger.Txn, key []byte) (uint32, bool, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	var v uint32
+	err = item.Value(func(val []byte) error {
+		if len(val) != 4 {
+			return errors.Errorf("Invalid value of size %d for key %q", len(val), key)
+		}
+		v = binary.BigEndian.Uint32(val)
+		return nil
+	})
+	return v, true, err
+}
+
+func setUint32(txn *badger.Txn, key []byte, v uint32) error {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return txn.Set(key, buf)
+}
+
+// generations returns the generations whose lists must be kept up to date by writes in txn: the
+// current one, and the one being built by Train if any. Reading them makes txn conflict with
+// Train switching generations.
+func (ix *Index) generations(txn *badger.Txn) ([]uint32, error) {
+	gen, _, err := getUint32(txn, ix.key(genTag))
+	if err != nil {
+		return nil, err
+	}
+	gens := []uint32{gen}
+	pending, ok, err := getUint32(txn, ix.key(pendingTag))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		gens = append(gens, pending)
+	}
+	return gens, nil
+}
+
+// centroidsOf returns the centroids of generation gen, none for an untrained index.
+func (ix *Index) centroidsOf(txn *badger.Txn, gen uint32) ([][]float32, error) {
+	ix.Lock()
+	cs, ok := ix.centroids[gen]
+	ix.Unlock()
+	if ok {
+		return cs, nil
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], gen)
+	item, err := txn.Get(ix.key(centroidTag, buf[:]...))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = item.Value(func(val []byte) error {
+		size := 4 * ix.opt.Dimensions
+		if len(val)%size != 0 {
+			return errors.Errorf("Invalid centroids of size %d for generation %d", len(val), gen)
+		}
+		for ; len(val) > 0; val = val[size:] {
+			cs = append(cs, decodeVector(val[:size]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ix.Lock()
+	ix.centroids[gen] = cs
+	ix.Unlock()
+	return cs, nil
+}
+
+// prepare checks the dimensions of vec, and normalizes it for the cosine metric.
+func (ix *Index) prepare(vec []float32) ([]float32, error) {
+	if len(vec) != ix.opt.Dimensions {
+		return nil, errors.Errorf("Vector has %d dimensions instead of %d",
+			len(vec), ix.opt.Dimensions)
+	}
+	if ix.opt.Metric != Cosine {
+		return vec, nil
+	}
+	var norm float64
+	for _, f := range vec {
+		norm += float64(f) * float64(f)
+	}
+	if norm == 0 {
+		return nil, errors.New("Cannot index a zero vector with the cosine metric")
+	}
+	norm = math.Sqrt(norm)
+	res := make([]float32, len(vec))
+	for i, f := range vec {
+		res[i] = float32(float64(f) / norm)
+	}
+	return res, nil
+}
+
+func (ix *Index) distance(a, b []float32) float32 {
+	var d float32
+	switch ix.opt.Metric {
+	case Euclidean:
+		for i := range a {
+			x := a[i] - b[i]
+			d += x * x
+		}
+	case Cosine:
+		// Vectors are normalized already.
+		for i := range a {
+			d += a[i] * b[i]
+		}
+		d = 1 - d
+	case DotProduct:
+		for i := range a {
+			d -= a[i] * b[i]
+		}
+	}
+	return d
+}
+
+// nearestLists returns the n lists whose centroids are the nearest to vec.
+func (ix *Index) nearestLists(cs [][]float32, vec []float32, n int) []uint32 {
+	if len(cs) == 0 {
+		return []uint32{0}
+	}
+	lists := make([]uint32, len(cs))
+	dists := make([]float32, len(cs))
+	for i, c := range cs {
+		lists[i] = uint32(i)
+		dists[i] = ix.distance(c, vec)
+	}
+	sort.Slice(lists, func(i, j int) bool { return dists[lists[i]] < dists[lists[j]] })
+	if n < len(lists) {
+		lists = lists[:n]
+	}
+	return lists
+}
+
+// Add adds the vector vec with the given ID to the index as part of txn, replacing the previous
+// vector with the same ID. Add conflicts with Train switching to new centroids, in which case txn
+// fails to commit with badger.ErrConflict and should be retried.
+func (ix *Index) Add(txn *badger.Txn, id []byte, vec []float32) error {
+	vec, err := ix.prepare(vec)
+	if err != nil {
+		return err
+	}
+	if err := ix.Delete(txn, id); err != nil {
+		return err
+	}
+	gens, err := ix.generations(txn)
+	if err != nil {
+		return err
+	}
+	buf := encodeVector(vec)
+	for _, gen := range gens {
+		cs, err := ix.centroidsOf(txn, gen)
+		if err != nil {
+			return err
+		}
+		list := ix.nearestLists(cs, vec, 1)[0]
+		if err := txn.Set(append(ix.listPrefix(gen, list), id...), buf); err != nil {
+			return err
+		}
+	}
+	return txn.Set(ix.key(vectorTag, id...), buf)
+}
+
+// AddValue adds the vector of value, as computed by Options.Vectorize, with the given ID to the
+// index as part of txn. See Add.
+func (ix *Index) AddValue(txn *badger.Txn, id, value []byte) error {
+	if ix.opt.Vectorize == nil {
+		return errors.New("AddValue needs Options.Vectorize to be set")
+	}
+	vec, err := ix.opt.Vectorize(value)
+	if err != nil {
+		return errors.Wrapf(err, "while computing vector of %q", id)
+	}
+	return ix.Add(txn, id, vec)
+}
+
+// Delete removes the vector with the given ID from the index as part of txn. Deleting a missing
+// vector is a no-op. Like Add, Delete conflicts with Train switching to new centroids.
+func (ix *Index) Delete(txn *badger.Txn, id []byte) error {
+	vkey := ix.key(vectorTag, id...)
+	item, err := txn.Get(vkey)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	buf, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	vec := decodeVector(buf)
+	gens, err := ix.generations(txn)
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		cs, err := ix.centroidsOf(txn, gen)
+		if err != nil {
+			return err
+		}
+		list := ix.nearestLists(cs, vec, 1)[0]
+		if err := txn.Delete(append(ix.listPrefix(gen, list), id...)); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(vkey)
+}
+
+// resultHeap keeps the nearest vectors found so far, the farthest one on top.
+type resultHeap []Result
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return h[i].Distance > h[j].Distance }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(Result)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// Search returns up to k vectors among the nearest to query, as of the snapshot of txn, nearest
+// first. Only the Options.Probes lists with the nearest centroids get scanned, so vectors nearer
+// than the ones returned may be missed.
+func (ix *Index) Search(txn *badger.Txn, query []float32, k int) ([]Result, error) {
+	query, err := ix.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+	gen, _, err := getUint32(txn, ix.key(genTag))
+	if err != nil {
+		return nil, err
+	}
+	cs, err := ix.centroidsOf(txn, gen)
+	if err != nil {
+		return nil, err
+	}
+	h := make(resultHeap, 0, k)
+	for _, list := range ix.nearestLists(cs, query, ix.opt.Probes) {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = ix.listPrefix(gen, list)
+		it := txn.NewIterator(opts)
+		for it.Rewind(); it.Valid(); it.Next() {
+			var d float32
+			err := it.Item().Value(func(val []byte) error {
+				if len(val) != 4*ix.opt.Dimensions {
+					return errors.Errorf("Invalid vector of size %d", len(val))
+				}
+				d = ix.distance(query, decodeVector(val))
+				return nil
+			})
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			if len(h) == k && d >= h[0].Distance {
+				continue
+			}
+			id := append([]byte{}, it.Item().Key()[len(opts.Prefix):]...)
+			heap.Push(&h, Result{ID: id, Distance: d})
+			if len(h) > k {
+				heap.Pop(&h)
+			}
+		}
+		it.Close()
+	}
+	res := make([]Result, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		res[i] = heap.Pop(&h).(Result)
+	}
+	return res, nil
+}
+
+// trainBatchSize is the number of vectors assigned to the lists of a new generation per
+// transaction by Train.
+const trainBatchSize = 1000
+
+// kmeansIterations is the number of iterations of k-means run by Train.
+const kmeansIterations = 10
+
+// Train computes new centroids from a sample of the vectors in the index, and regroups all the
+// vectors around them. It should be called once the index holds enough vectors for the
+// centroids to be meaningful, i.e. a few times Options.Lists, and again whenever the vectors
+// changed enough for searches to lose accuracy.
+//
+// Searches and writes can go on while Train runs, searches using the previous centroids until
+// Train is done. Writes committed around the time Train switches to the new centroids may fail
+// with badger.ErrConflict.
+func (ix *Index) Train() error {
+	ix.trainMu.Lock()
+	defer ix.trainMu.Unlock()
+
+	sample, err := ix.sample()
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return nil
+	}
+	cs := ix.kmeans(sample)
+
+	// Start the new generation. From then on, writes update its lists too.
+	var oldGens []uint32
+	var gen uint32
+	err = ix.db.Update(func(txn *badger.Txn) error {
+		cur, _, err := getUint32(txn, ix.key(genTag))
+		if err != nil {
+			return err
+		}
+		// A pending generation is left over by a Train that didn't finish.
+		pending, ok, err := getUint32(txn, ix.key(pendingTag))
+		if err != nil {
+			return err
+		}
+		oldGens, gen = []uint32{cur}, cur+1
+		if ok {
+			oldGens = append(oldGens, pending)
+			if pending >= gen {
+				gen = pending + 1
+			}
+		}
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], gen)
+		var encoded []byte
+		for _, c := range cs {
+			encoded = append(encoded, encodeVector(c)...)
+		}
+		if err := txn.Set(ix.key(centroidTag, buf[:]...), encoded); err != nil {
+			return err
+		}
+		return setUint32(txn, ix.key(pendingTag), gen)
+	})
+	if err != nil {
+		return errors.Wrap(err, "while starting new index generation")
+	}
+	if err := ix.fill(gen); err != nil {
+		return err
+	}
+	err = ix.db.Update(func(txn *badger.Txn) error {
+		if err := setUint32(txn, ix.key(genTag), gen); err != nil {
+			return err
+		}
+		return txn.Delete(ix.key(pendingTag))
+	})
+	if err != nil {
+		return errors.Wrap(err, "while switching to new index generation")
+	}
+	for _, old := range oldGens {
+		if err := ix.dropGeneration(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sample returns up to Options.TrainingSample vectors picked at random from the index.
+func (ix *Index) sample() ([][]float32, error) {
+	var sample [][]float32
+	err := ix.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = ix.key(vectorTag)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		var seen int
+		for it.Rewind(); it.Valid(); it.Next() {
+			seen++
+			// Reservoir sampling.
+			i := len(sample)
+			if len(sample) == ix.opt.TrainingSample {
+				if i = rand.Intn(seen); i >= len(sample) {
+					continue
+				}
+			}
+			buf, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if i == len(sample) {
+				sample = append(sample, decodeVector(buf))
+			} else {
+				sample[i] = decodeVector(buf)
+			}
+		}
+		return nil
+	})
+	return sample, err
+}
+
+// kmeans returns up to Options.Lists centroids for the vectors in sample.
+func (ix *Index) kmeans(sample [][]float32) [][]float32 {
+	k := ix.opt.Lists
+	if k > len(sample) {
+		k = len(sample)
+	}
+	// k-means++ initialization: each centroid is picked with a probability proportional to its
+	// distance to the nearest centroid picked already.
+	cs := [][]float32{append([]float32{}, sample[rand.Intn(len(sample))]...)}
+	dists := make([]float64, len(sample))
+	for len(cs) < k {
+		var total float64
+		for i, v := range sample {
+			d := math.Inf(1)
+			for _, c := range cs {
+				d = math.Min(d, float64(ix.spread(c, v)))
+			}
+			dists[i] = d
+			total += d
+		}
+		if total == 0 {
+			break // Fewer distinct vectors than lists.
+		}
+		r := rand.Float64() * total
+		i := 0
+		for ; i < len(sample)-1 && r >= dists[i]; i++ {
+			r -= dists[i]
+		}
+		cs = append(cs, append([]float32{}, sample[i]...))
+	}
+
+	assign := make([]int, len(sample))
+	for iter := 0; iter < kmeansIterations; iter++ {
+		for i, v := range sample {
+			assign[i] = int(ix.nearestLists(cs, v, 1)[0])
+		}
+		sums := make([][]float64, len(cs))
+		counts := make([]int, len(cs))
+		for i, v := range sample {
+			c := assign[i]
+			if sums[c] == nil {
+				sums[c] = make([]float64, ix.opt.Dimensions)
+			}
+			for j, f := range v {
+				sums[c][j] += float64(f)
+			}
+			counts[c]++
+		}
+		for c := range cs {
+			if counts[c] == 0 {
+				continue // Keep the previous centroid of empty lists.
+			}
+			mean := make([]float32, ix.opt.Dimensions)
+			for j := range mean {
+				mean[j] = float32(sums[c][j] / float64(counts[c]))
+			}
+			if ix.opt.Metric == Cosine {
+				if normalized, err := ix.prepare(mean); err == nil {
+					mean = normalized
+				}
+			}
+			cs[c] = mean
+		}
+	}
+	return cs
+}
+
+// spread is the squared euclidean distance used to spread the initial centroids, whatever the
+// metric: vectors are normalized already for the cosine metric.
+func (ix *Index) spread(a, b []float32) float32 {
+	var d float32
+	for i := range a {
+		x := a[i] - b[i]
+		d += x * x
+	}
+	return d
+}
+
+// fill adds all the vectors to the lists of generation gen. Every batch reads the vectors it
+// assigns, so that it conflicts with concurrent writes to them instead of undoing them.
+func (ix *Index) fill(gen uint32) error {
+	var ids [][]byte
+	err := ix.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = ix.key(vectorTag)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			ids = append(ids, append([]byte{}, it.Item().Key()[len(opts.Prefix):]...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > trainBatchSize {
+			batch = batch[:trainBatchSize]
+		}
+		for {
+			err = ix.db.Update(func(txn *badger.Txn) error {
+				cs, err := ix.centroidsOf(txn, gen)
+				if err != nil {
+					return err
+				}
+				for _, id := range batch {
+					item, err := txn.Get(ix.key(vectorTag, id...))
+					if err == badger.ErrKeyNotFound {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					buf, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					list := ix.nearestLists(cs, decodeVector(buf), 1)[0]
+					if err := txn.Set(append(ix.listPrefix(gen, list), id...), buf); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if errors.Cause(err) != badger.ErrConflict {
+				break
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "while filling new index generation")
+		}
+		ids = ids[len(batch):]
+	}
+	return nil
+}
+
+// dropGeneration deletes the centroids and lists of generation gen.
+func (ix *Index) dropGeneration(gen uint32) error {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], gen)
+	keys := [][]byte{ix.key(centroidTag, buf[:]...)}
+	err := ix.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = ix.key(listTag, buf[:]...)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	wb := ix.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return errors.Wrapf(err, "while dropping index generation %d", gen)
+	}
+	ix.Lock()
+	delete(ix.centroids, gen)
+	ix.Unlock()
+	return nil
+}
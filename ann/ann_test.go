@@ -0,0 +1,173 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ann
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func search(t *testing.T, db *badger.DB, ix *Index, query []float32, k int) []string {
+	var ids []string
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		res, err := ix.Search(txn, query, k)
+		for _, r := range res {
+			ids = append(ids, string(r.ID))
+		}
+		return err
+	}))
+	return ids
+}
+
+func TestIndexExact(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ix, err := Open(db, []byte("ann/"), DefaultOptions(2))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i := 0; i < 10; i++ {
+			if err := ix.Add(txn, []byte(fmt.Sprint(i)), []float32{float32(i), 0}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"3", "4", "2"}, search(t, db, ix, []float32{3.2, 1}, 3))
+
+	// Moving and deleting vectors.
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := ix.Add(txn, []byte("9"), []float32{3.3, 1}); err != nil {
+			return err
+		}
+		return ix.Delete(txn, []byte("4"))
+	}))
+	require.Equal(t, []string{"9", "3", "2"}, search(t, db, ix, []float32{3.2, 1}, 3))
+
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := ix.Search(txn, []float32{1, 2, 3}, 3)
+		require.Error(t, err)
+		return nil
+	}))
+}
+
+func TestIndexTrain(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	opt := DefaultOptions(8)
+	opt.Lists = 16
+	opt.Probes = 4
+	opt.Metric = Cosine
+	ix, err := Open(db, []byte("ann/"), opt)
+	require.NoError(t, err)
+
+	// Vectors around 16 random directions.
+	rng := rand.New(rand.NewSource(1))
+	vecs := make(map[string][]float32)
+	for c := 0; c < 16; c++ {
+		center := make([]float32, 8)
+		for i := range center {
+			center[i] = rng.Float32()*2 - 1
+		}
+		for n := 0; n < 50; n++ {
+			v := make([]float32, 8)
+			for i := range v {
+				v[i] = center[i] + 0.05*(rng.Float32()*2-1)
+			}
+			vecs[fmt.Sprintf("%d-%d", c, n)] = v
+		}
+	}
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for id, v := range vecs {
+			if err := ix.Add(txn, []byte(id), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	exact := search(t, db, ix, vecs["3-7"], 10)
+	require.Equal(t, "3-7", exact[0])
+
+	require.NoError(t, ix.Train())
+	approx := search(t, db, ix, vecs["3-7"], 10)
+	require.Equal(t, "3-7", approx[0])
+	var found int
+	for _, id := range approx {
+		for _, e := range exact {
+			if id == e {
+				found++
+			}
+		}
+	}
+	require.True(t, found >= 8, "recall too low: %d/10", found)
+
+	// Writes after training go to the lists of the new centroids, and training again works.
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := ix.Delete(txn, []byte("3-7")); err != nil {
+			return err
+		}
+		return ix.Add(txn, []byte("new"), vecs["3-7"])
+	}))
+	require.Equal(t, "new", search(t, db, ix, vecs["3-7"], 1)[0])
+	require.NoError(t, ix.Train())
+	require.Equal(t, "new", search(t, db, ix, vecs["3-7"], 1)[0])
+
+	// Only the lists of the current generation remain.
+	var lists int
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = ix.key(listTag)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			lists++
+		}
+		return nil
+	}))
+	require.Equal(t, len(vecs), lists)
+}
+
+func TestIndexAddValue(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	opt := DefaultOptions(2)
+	ix, err := Open(db, []byte("ann/"), opt)
+	require.NoError(t, err)
+	require.Error(t, db.Update(func(txn *badger.Txn) error {
+		return ix.AddValue(txn, []byte("a"), []byte("ab"))
+	}))
+
+	opt.Vectorize = func(val []byte) ([]float32, error) {
+		return []float32{float32(len(val)), float32(val[0])}, nil
+	}
+	ix, err = Open(db, []byte("ann/"), opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for _, v := range []string{"a", "abc", "bbbbb"} {
+			if err := ix.AddValue(txn, []byte(v), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"abc"}, search(t, db, ix, []float32{3, 'a'}, 1))
+}
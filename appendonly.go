@@ -0,0 +1,113 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/pkg/errors"
+)
+
+// newKeys holds the fingerprints of the keys being written for the first time to an append-only
+// DB, from the time they're found not to exist until they're written to the memtable. Keys sharing
+// a fingerprint share a claim.
+type newKeys struct {
+	sync.Mutex
+	claimed map[uint64]struct{}
+}
+
+// claimNewKey claims key for a first write. It returns ErrKeyExists if key exists or is claimed
+// already, so that of two concurrent writes of a new key, the second fails, even if they come from
+// write batches or a KVLoader, which don't detect conflicts. The claim must be released once the
+// write is in the memtable, or won't happen.
+func (db *DB) claimNewKey(key []byte) (uint64, error) {
+	fp := z.MemHash(key)
+	nk := &db.newKeys
+	nk.Lock()
+	_, claimed := nk.claimed[fp]
+	if !claimed {
+		if nk.claimed == nil {
+			nk.claimed = make(map[uint64]struct{})
+		}
+		nk.claimed[fp] = struct{}{}
+	}
+	nk.Unlock()
+	if claimed {
+		return 0, errors.Wrapf(ErrKeyExists, "Cannot overwrite key %q in append-only mode", key)
+	}
+
+	// The key is looked up once claimed: a previous write of it released its claim after reaching
+	// the memtable.
+	vs, err := db.get(y.KeyWithTs(db.storedKey(key), math.MaxUint64))
+	switch {
+	case err != nil:
+		err = errors.Wrapf(err, "DB::Get key: %q", key)
+	case isLive(vs):
+		err = errors.Wrapf(ErrKeyExists, "Cannot overwrite key %q in append-only mode", key)
+	default:
+		return fp, nil
+	}
+	db.releaseNewKeys([]uint64{fp})
+	return 0, err
+}
+
+// releaseNewKeys releases the claims taken by claimNewKey.
+func (db *DB) releaseNewKeys(fps []uint64) {
+	nk := &db.newKeys
+	nk.Lock()
+	defer nk.Unlock()
+	for _, fp := range fps {
+		delete(nk.claimed, fp)
+	}
+}
+
+// checkAppendOnly returns an error if e would delete or overwrite a key, which an append-only DB
+// doesn't allow. Entries expiring count as deletes. The latest version of the key is looked up,
+// whatever the read timestamp of txn, and the key is claimed until txn is committed or discarded,
+// see claimNewKey.
+func (txn *Txn) checkAppendOnly(e *Entry) error {
+	if e.meta&bitDelete > 0 || e.ExpiresAt > 0 {
+		return ErrAppendOnly
+	}
+	if _, has := txn.pendingWrites[string(e.Key)]; has {
+		// Replacing a write of txn doesn't overwrite anything committed.
+		return nil
+	}
+	fp, err := txn.db.claimNewKey(e.Key)
+	if err != nil {
+		return err
+	}
+	txn.newKeys = append(txn.newKeys, fp)
+	return nil
+}
+
+// checkAppendOnly is Txn.checkAppendOnly for the entries of l, which have the given key and
+// meta. The claims are released once the entries are written.
+func (l *KVLoader) checkAppendOnly(key []byte, meta byte, expiresAt uint64) error {
+	if meta&bitDelete > 0 || expiresAt > 0 {
+		return ErrAppendOnly
+	}
+	fp, err := l.db.claimNewKey(key)
+	if err != nil {
+		return err
+	}
+	l.newKeys = append(l.newKeys, fp)
+	return nil
+}
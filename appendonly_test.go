@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendOnly(t *testing.T) {
+	opt := getTestOptions("").WithAppendOnly(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Set([]byte("a"), []byte("1")); err != nil {
+				return err
+			}
+			// Rewriting a pending write is fine.
+			return txn.Set([]byte("a"), []byte("2"))
+		}))
+
+		err := db.Update(func(txn *Txn) error { return txn.Set([]byte("a"), []byte("3")) })
+		require.Equal(t, ErrKeyExists, errors.Cause(err))
+		err = db.Update(func(txn *Txn) error { return txn.Delete([]byte("a")) })
+		require.Equal(t, ErrAppendOnly, err)
+		err = db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("b"), nil).WithTTL(time.Hour))
+		})
+		require.Equal(t, ErrAppendOnly, err)
+		require.Equal(t, ErrAppendOnly, db.DropAll())
+		require.Equal(t, ErrAppendOnly, db.DropPrefix([]byte("a")))
+		_, err = db.PurgeKey([]byte("a"))
+		require.Equal(t, ErrAppendOnly, err)
+
+		wb := db.NewWriteBatch()
+		require.Equal(t, ErrKeyExists, errors.Cause(wb.Set([]byte("a"), []byte("4"))))
+		require.NoError(t, wb.Set([]byte("c"), []byte("1")))
+		require.NoError(t, wb.Flush())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("a"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("2"), getItemValue(t, item))
+			_, err = txn.Get([]byte("c"))
+			return err
+		}))
+	})
+}
+
+func TestAppendOnlyConflict(t *testing.T) {
+	opt := getTestOptions("").WithAppendOnly(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn1 := db.NewTransaction(true)
+		defer txn1.Discard()
+		txn2 := db.NewTransaction(true)
+		defer txn2.Discard()
+		require.NoError(t, txn1.Set([]byte("a"), []byte("1")))
+		require.Equal(t, ErrKeyExists, errors.Cause(txn2.Set([]byte("a"), []byte("2"))))
+		// The key is free again once txn1 is discarded.
+		txn1.Discard()
+		require.NoError(t, txn2.Set([]byte("a"), []byte("2")))
+
+		// Write batches don't detect conflicts, but can't write the same new key either.
+		wb1 := db.NewWriteBatch()
+		defer wb1.Cancel()
+		wb2 := db.NewWriteBatch()
+		defer wb2.Cancel()
+		require.NoError(t, wb1.Set([]byte("b"), []byte("1")))
+		require.Equal(t, ErrKeyExists, errors.Cause(wb2.Set([]byte("b"), []byte("2"))))
+		require.NoError(t, wb1.Flush())
+		require.Equal(t, ErrKeyExists, errors.Cause(wb2.Set([]byte("b"), []byte("2"))))
+		require.NoError(t, txn2.Commit())
+	})
+}
+
+func TestAppendOnlyLoad(t *testing.T) {
+	opt := getTestOptions("").WithAppendOnly(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for _, key := range []string{"a", "c"} {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key), []byte("1"))
+			}))
+		}
+		ldr := db.NewKVLoader(1)
+		err := ldr.Set(&pb.KV{Key: []byte("a"), Value: []byte("2"), Version: 10})
+		require.Equal(t, ErrKeyExists, errors.Cause(err))
+		err = ldr.Set(&pb.KV{Key: []byte("b"), Meta: []byte{bitDelete}, Version: 10})
+		require.Equal(t, ErrAppendOnly, err)
+		err = ldr.Set(&pb.KV{Key: []byte("b"), Value: []byte("1"), ExpiresAt: 1, Version: 10})
+		require.Equal(t, ErrAppendOnly, err)
+
+		require.NoError(t, ldr.Set(&pb.KV{Key: []byte("b"), Value: []byte("2"), Version: 2}))
+		// An older version of a loaded key overwrites it.
+		err = ldr.Set(&pb.KV{Key: []byte("b"), Value: []byte("1"), Version: 1})
+		require.Equal(t, ErrKeyExists, errors.Cause(err))
+		require.NoError(t, ldr.Finish())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("b"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("2"), getItemValue(t, item))
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+// AutoTxn is a read-write transaction which commits itself when full, and carries on in a new
+// transaction, instead of failing with ErrTxnTooBig. Each of these chunks is committed on its own,
+// so an AutoTxn isn't atomic: when a chunk fails to commit, e.g. with ErrConflict, the chunks
+// committed before remain, and every following operation returns the error. Reads see the writes
+// of the chunks committed before, as of the start of the current chunk.
+//
+// Like a Txn, AutoTxn isn't thread safe, and it must be committed or discarded.
+type AutoTxn struct {
+	db       *DB
+	txn      *Txn
+	versions []uint64 // versions are the commit timestamps of the chunks committed.
+	err      error
+}
+
+// NewAutoTxn returns an AutoTxn. It can't be used in the managed mode, where TxnWriter commits
+// writes at many timestamps.
+func (db *DB) NewAutoTxn() *AutoTxn {
+	if db.opt.managedTxns {
+		panic("cannot use NewAutoTxn with managedDB=true. Use NewTxnWriter instead")
+	}
+	return &AutoTxn{db: db, txn: db.NewTransaction(true)}
+}
+
+// Get is the equivalent of Txn.Get.
+func (at *AutoTxn) Get(key []byte) (*Item, error) {
+	if at.err != nil {
+		return nil, at.err
+	}
+	return at.txn.Get(key)
+}
+
+// SetEntry is the equivalent of Txn.SetEntry.
+func (at *AutoTxn) SetEntry(e *Entry) error {
+	return at.write(func(txn *Txn) error {
+		return txn.SetEntry(e)
+	})
+}
+
+// Set is the equivalent of Txn.Set.
+func (at *AutoTxn) Set(key, val []byte) error {
+	return at.SetEntry(NewEntry(key, val))
+}
+
+// Delete is the equivalent of Txn.Delete.
+func (at *AutoTxn) Delete(key []byte) error {
+	return at.write(func(txn *Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (at *AutoTxn) write(fn func(txn *Txn) error) error {
+	if at.err != nil {
+		return at.err
+	}
+	if err := fn(at.txn); err != ErrTxnTooBig {
+		return err
+	}
+	// The chunk is full, commit it and retry in a new one.
+	if err := at.commitChunk(); err != nil {
+		return err
+	}
+	// This time the error must not be ErrTxnTooBig, otherwise, we make the error permanent.
+	if err := fn(at.txn); err != nil {
+		at.err = err
+		return err
+	}
+	return nil
+}
+
+// commitChunk commits the current chunk, and starts the next one.
+func (at *AutoTxn) commitChunk() error {
+	if err := at.txn.Commit(); err != nil {
+		at.err = err
+		return err
+	}
+	if at.txn.commitTs > 0 {
+		at.versions = append(at.versions, at.txn.commitTs)
+	}
+	at.txn = at.db.NewTransaction(true)
+	return nil
+}
+
+// Commit commits the last chunk, and calls report, if it isn't nil, with the versions assigned to
+// the chunks committed, in order, whether the commit succeeded or not. A chunk without writes
+// doesn't get a version. Commit returns the error of the first chunk which failed to commit.
+func (at *AutoTxn) Commit(report func(versions []uint64)) error {
+	err := at.err
+	if err == nil {
+		err = at.commitChunk()
+	}
+	at.Discard()
+	if report != nil {
+		report(at.versions)
+	}
+	return err
+}
+
+// Discard discards the current chunk. The chunks committed before remain.
+func (at *AutoTxn) Discard() {
+	at.txn.Discard()
+}
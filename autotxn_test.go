@@ -0,0 +1,78 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoTxn(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		numKeys := int(db.opt.maxBatchCount) * 2
+		at := db.NewAutoTxn()
+		for i := 0; i < numKeys; i++ {
+			require.NoError(t, at.Set([]byte(fmt.Sprintf("key%d", i)), []byte("val")))
+		}
+		// The writes of the chunks committed already are visible.
+		item, err := at.Get([]byte("key0"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("val"), getItemValue(t, item))
+		require.NoError(t, at.Delete([]byte("key0")))
+
+		var versions []uint64
+		require.NoError(t, at.Commit(func(v []uint64) { versions = v }))
+		require.True(t, len(versions) >= 3)
+		for i := 1; i < len(versions); i++ {
+			require.True(t, versions[i] > versions[i-1])
+		}
+
+		seen := make(map[uint64]bool)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("key0"))
+			require.Equal(t, ErrKeyNotFound, err)
+			for i := 1; i < numKeys; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+				require.NoError(t, err)
+				seen[item.Version()] = true
+			}
+			return nil
+		}))
+		for version := range seen {
+			require.Contains(t, versions, version)
+		}
+
+		// A chunk failing to commit stops the AutoTxn.
+		at = db.NewAutoTxn()
+		_, err = at.Get([]byte("key1"))
+		require.NoError(t, err)
+		require.NoError(t, at.Set([]byte("key1"), []byte("val2")))
+		txnSet(t, db, []byte("key1"), []byte("val3"), 0)
+		versions = nil
+		require.Equal(t, ErrConflict, at.Commit(func(v []uint64) { versions = v }))
+		require.Empty(t, versions)
+		require.Equal(t, ErrConflict, at.Set([]byte("key2"), nil))
+
+		opt := getTestOptions("")
+		opt.managedTxns = true
+		require.Panics(t, func() {
+			(&DB{opt: opt}).NewAutoTxn()
+		})
+	})
+}
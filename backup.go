@@ -70,10 +70,20 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 				}
 			}
 
+			// The deletions of hashed keys don't store the original key, so they're left out:
+			// incremental backups don't delete the keys deleted since the previous backup.
+			origKey, err := item.OriginalKey()
+			if err == ErrNoOriginalKey {
+				return list, nil
+			} else if err != nil {
+				return nil, err
+			}
+			origKey = y.SafeCopy(nil, origKey)
+
 			// clear txn bits
 			meta := item.meta &^ (bitTxn | bitFinTxn)
 			kv := &pb.KV{
-				Key:       item.KeyCopy(nil),
+				Key:       origKey,
 				Value:     valCopy,
 				UserMeta:  []byte{item.UserMeta()},
 				Version:   item.Version(),
@@ -87,7 +97,7 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 				// If we need to discard earlier versions of this item, add a delete
 				// marker just below the current version.
 				list.Kv = append(list.Kv, &pb.KV{
-					Key:     item.KeyCopy(nil),
+					Key:     origKey,
 					Version: item.Version() - 1,
 					Meta:    []byte{bitDelete},
 				})
@@ -117,13 +127,14 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 }
 
 func writeTo(list *pb.KVList, w io.Writer) error {
-	if err := binary.Write(w, binary.LittleEndian, uint64(proto.Size(list))); err != nil {
-		return err
-	}
-	buf, err := proto.Marshal(list)
+	// Write the size and the list at once, ChunkedBackupWriter cuts chunks between writes.
+	data, err := proto.Marshal(list)
 	if err != nil {
 		return err
 	}
+	buf := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint64(buf, uint64(len(data)))
+	copy(buf[8:], data)
 	_, err = w.Write(buf)
 	return err
 }
@@ -134,6 +145,9 @@ type KVLoader struct {
 	throttle    *y.Throttle
 	entries     []*Entry
 	entriesSize int64
+	// newKeys are the fingerprints of the keys of entries in an append-only DB, see
+	// DB.claimNewKey.
+	newKeys []uint64
 }
 
 // NewKVLoader returns a new instance of KVLoader.
@@ -147,6 +161,9 @@ func (db *DB) NewKVLoader(maxPendingWrites int) *KVLoader {
 
 // Set writes the key-value pair to the database.
 func (l *KVLoader) Set(kv *pb.KV) error {
+	if err := checkReservedKey(kv.Key); err != nil {
+		return err
+	}
 	var userMeta, meta byte
 	if len(kv.UserMeta) > 0 {
 		userMeta = kv.UserMeta[0]
@@ -161,6 +178,11 @@ func (l *KVLoader) Set(kv *pb.KV) error {
 		ExpiresAt: kv.ExpiresAt,
 		meta:      meta,
 	}
+	key, err := l.db.encodeEntry(kv.Key, e)
+	if err != nil {
+		return err
+	}
+	e.Key = y.KeyWithTs(key, kv.Version)
 	estimatedSize := int64(e.estimateSize(l.db.opt.ValueThreshold))
 	// Flush entries if inserting the next entry would overflow the transactional limits.
 	if int64(len(l.entries))+1 >= l.db.opt.maxBatchCount ||
@@ -169,18 +191,29 @@ func (l *KVLoader) Set(kv *pb.KV) error {
 			return err
 		}
 	}
+	if l.db.opt.AppendOnly {
+		if err := l.checkAppendOnly(kv.Key, meta, kv.ExpiresAt); err != nil {
+			return err
+		}
+	}
 	l.entries = append(l.entries, e)
 	l.entriesSize += estimatedSize
 	return nil
 }
 
 func (l *KVLoader) send() error {
+	// The keys are claimed until the entries are in the memtable.
+	newKeys := l.newKeys
+	l.newKeys = nil
 	if err := l.throttle.Do(); err != nil {
+		l.db.releaseNewKeys(newKeys)
 		return err
 	}
 	if err := l.db.batchSetAsync(l.entries, func(err error) {
+		l.db.releaseNewKeys(newKeys)
 		l.throttle.Done(err)
 	}); err != nil {
+		l.db.releaseNewKeys(newKeys)
 		return err
 	}
 
@@ -207,17 +240,118 @@ func (l *KVLoader) Finish() error {
 // DB.Load() should be called on a database that is not running any other
 // concurrent transactions while it is running.
 func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
+	_, err := db.LoadWithOptions(r, maxPendingWrites, LoadOptions{})
+	return err
+}
+
+// LoadOptions select the keys restored by DB.LoadWithOptions.
+type LoadOptions struct {
+	// IncludePrefixes restricts the keys restored to the ones with one of these prefixes. All the
+	// keys are restored if it's empty.
+	IncludePrefixes [][]byte
+	// ExcludePrefixes skips the keys with one of these prefixes.
+	ExcludePrefixes [][]byte
+	// DryRun only counts the keys which would be restored, without writing anything.
+	DryRun bool
+	// BackupKey decrypts backups written by an EncryptedBackupWriter.
+	BackupKey []byte
+}
+
+func (opt *LoadOptions) match(key []byte) bool {
+	for _, p := range opt.ExcludePrefixes {
+		if bytes.HasPrefix(key, p) {
+			return false
+		}
+	}
+	if len(opt.IncludePrefixes) == 0 {
+		return true
+	}
+	for _, p := range opt.IncludePrefixes {
+		if bytes.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadStats counts the data restored, or matched in dry-run mode, by DB.LoadWithOptions.
+type LoadStats struct {
+	Keys     int
+	Versions int
+	// Bytes is the total size of the keys and values of all the versions.
+	Bytes int64
+}
+
+// LoadWithOptions is like Load, but only restores the keys selected by opt. It returns the
+// number of keys, versions and bytes restored, or which would be restored in dry-run mode.
+func (db *DB) LoadWithOptions(r io.Reader, maxPendingWrites int,
+	opt LoadOptions) (LoadStats, error) {
+	if opt.DryRun {
+		return CountBackup(r, opt)
+	}
+	ldr := db.NewKVLoader(maxPendingWrites)
+	stats, err := readBackup(r, opt, func(kv *pb.KV) error {
+		if err := ldr.Set(kv); err != nil {
+			return err
+		}
+
+		// Update nextTxnTs, memtable stores this
+		// timestamp in badger head when flushed.
+		if kv.Version >= db.orc.nextTxnTs {
+			db.orc.nextTxnTs = kv.Version + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if err := ldr.Finish(); err != nil {
+		return stats, err
+	}
+	db.orc.txnMark.Done(db.orc.nextTxnTs - 1)
+	return stats, nil
+}
+
+// CountBackup reads a backup from r and returns the number of keys, versions and bytes that
+// DB.LoadWithOptions would restore with the given options, without needing a DB.
+func CountBackup(r io.Reader, opt LoadOptions) (LoadStats, error) {
+	return readBackup(r, opt, func(*pb.KV) error { return nil })
+}
+
+// readBackup calls fn for every entry of the backup read from r selected by opt.
+func readBackup(r io.Reader, opt LoadOptions, fn func(kv *pb.KV) error) (LoadStats, error) {
 	br := bufio.NewReaderSize(r, 16<<10)
+	// Encrypted backups may hold chunked ones, and the other way around.
+	for unwrapped := false; !unwrapped; {
+		magic, err := br.Peek(len(chunkedBackupMagic))
+		switch {
+		case err == io.EOF:
+			unwrapped = true
+		case err != nil:
+			return LoadStats{}, err
+		case string(magic) == chunkedBackupMagic:
+			br = bufio.NewReaderSize(NewChunkedBackupReader(br, false), 16<<10)
+		case string(magic) == encryptedBackupMagic:
+			if len(opt.BackupKey) == 0 {
+				return LoadStats{}, ErrBackupKeyMissing
+			}
+			br = bufio.NewReaderSize(NewEncryptedBackupReader(br, opt.BackupKey), 16<<10)
+		default:
+			unwrapped = true
+		}
+	}
 	unmarshalBuf := make([]byte, 1<<10)
 
-	ldr := db.NewKVLoader(maxPendingWrites)
+	var stats LoadStats
+	var lastKey []byte
 	for {
 		var sz uint64
 		err := binary.Read(br, binary.LittleEndian, &sz)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return err
+			return stats, err
 		}
 
 		if cap(unmarshalBuf) < int(sz) {
@@ -225,30 +359,29 @@ func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
 		}
 
 		if _, err = io.ReadFull(br, unmarshalBuf[:sz]); err != nil {
-			return err
+			return stats, err
 		}
 
 		list := &pb.KVList{}
 		if err := proto.Unmarshal(unmarshalBuf[:sz], list); err != nil {
-			return err
+			return stats, err
 		}
 
 		for _, kv := range list.Kv {
-			if err := ldr.Set(kv); err != nil {
-				return err
+			if !opt.match(kv.Key) {
+				continue
 			}
-
-			// Update nextTxnTs, memtable stores this
-			// timestamp in badger head when flushed.
-			if kv.Version >= db.orc.nextTxnTs {
-				db.orc.nextTxnTs = kv.Version + 1
+			// The versions of a key are next to each other.
+			if !bytes.Equal(kv.Key, lastKey) {
+				stats.Keys++
+				lastKey = kv.Key
+			}
+			stats.Versions++
+			stats.Bytes += int64(len(kv.Key) + len(kv.Value))
+			if err := fn(kv); err != nil {
+				return stats, err
 			}
 		}
 	}
-
-	if err := ldr.Finish(); err != nil {
-		return err
-	}
-	db.orc.txnMark.Done(db.orc.nextTxnTs - 1)
-	return nil
+	return stats, nil
 }
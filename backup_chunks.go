@@ -0,0 +1,284 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// Chunked backups start with chunkedBackupMagic, followed by chunks made of a header and the data
+// of the chunk, possibly compressed. The header is:
+//
+// | Index (4B) | Compression (1B) | Raw Length (4B) | Data Length (4B) | Data CRC (4B) | CRC (4B) |
+//
+// where the last CRC covers the rest of the header. Chunks only hold whole KV lists, so that the
+// chunks following a corrupted one can still be read.
+const (
+	chunkedBackupMagic = "BDGRCHK1"
+	chunkHeaderSize    = 21
+)
+
+// ChunkedBackupWriter writes a backup made by DB.Backup or Stream.Backup as independently
+// compressed and checksummed chunks. DB.Load and the other readers of backups recognize chunked
+// backups by themselves.
+//
+// Chunks are written to the underlying writer one at a time, so an upload of the backup which
+// got interrupted can be resumed at the end of the last complete chunk, see ValidBackupPrefix.
+type ChunkedBackupWriter struct {
+	w           io.Writer
+	chunkSize   int
+	compression options.CompressionType
+	level       int
+	buf         []byte
+	index       uint32
+	started     bool
+}
+
+// NewChunkedBackupWriter returns a ChunkedBackupWriter writing to w chunks of about chunkSize
+// bytes before compression, compressed with the given algorithm. Close must be called once the
+// backup is done.
+func NewChunkedBackupWriter(w io.Writer, chunkSize int,
+	compression options.CompressionType) *ChunkedBackupWriter {
+	return &ChunkedBackupWriter{
+		w:           w,
+		chunkSize:   chunkSize,
+		compression: compression,
+		level:       1,
+	}
+}
+
+// Write buffers p, writing a chunk once enough data is buffered. Chunks end on the boundaries of
+// calls to Write, which Stream.Backup makes once per KV list.
+func (cw *ChunkedBackupWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.chunkSize {
+		if err := cw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes the data buffered, if any. It doesn't close the underlying writer.
+func (cw *ChunkedBackupWriter) Close() error {
+	return cw.flush()
+}
+
+func (cw *ChunkedBackupWriter) flush() error {
+	if !cw.started {
+		if _, err := io.WriteString(cw.w, chunkedBackupMagic); err != nil {
+			return err
+		}
+		cw.started = true
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	data := cw.buf
+	switch cw.compression {
+	case options.None:
+	case options.ZSTD:
+		var err error
+		if data, err = y.ZSTDCompress(nil, cw.buf, cw.level); err != nil {
+			return errors.Wrapf(err, "while compressing backup chunk %d", cw.index)
+		}
+	default:
+		return errors.Errorf("Unsupported compression for backup chunks: %d", cw.compression)
+	}
+
+	var hdr [chunkHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], cw.index)
+	hdr[4] = byte(cw.compression)
+	binary.LittleEndian.PutUint32(hdr[5:9], uint32(len(cw.buf)))
+	binary.LittleEndian.PutUint32(hdr[9:13], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[13:17], crc32.Checksum(data, y.CastagnoliCrcTable))
+	binary.LittleEndian.PutUint32(hdr[17:21], crc32.Checksum(hdr[:17], y.CastagnoliCrcTable))
+	if _, err := cw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(data); err != nil {
+		return err
+	}
+	cw.index++
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+// chunkHeader is the decoded header of a chunk.
+type chunkHeader struct {
+	index       uint32
+	compression options.CompressionType
+	rawLen      uint32
+	dataLen     uint32
+	dataCRC     uint32
+}
+
+// readChunkHeader reads the header of the next chunk. It returns io.EOF if there's no chunk left.
+func readChunkHeader(r io.Reader) (chunkHeader, error) {
+	var hdr [chunkHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return chunkHeader{}, err
+	}
+	if crc32.Checksum(hdr[:17], y.CastagnoliCrcTable) != binary.LittleEndian.Uint32(hdr[17:21]) {
+		return chunkHeader{}, errors.New("Corrupted backup chunk header")
+	}
+	return chunkHeader{
+		index:       binary.LittleEndian.Uint32(hdr[0:4]),
+		compression: options.CompressionType(hdr[4]),
+		rawLen:      binary.LittleEndian.Uint32(hdr[5:9]),
+		dataLen:     binary.LittleEndian.Uint32(hdr[9:13]),
+		dataCRC:     binary.LittleEndian.Uint32(hdr[13:17]),
+	}, nil
+}
+
+// ChunkedBackupReader reads the backup written by a ChunkedBackupWriter.
+type ChunkedBackupReader struct {
+	r           io.Reader
+	skipCorrupt bool
+	buf         []byte
+	pos         int
+	index       uint32
+	started     bool
+	corrupt     []uint32
+}
+
+// NewChunkedBackupReader returns a ChunkedBackupReader reading a chunked backup from r. Reading
+// fails on the first chunk whose checksum doesn't match, unless skipCorrupt is true, in which
+// case such chunks are skipped and reported by CorruptChunks. Chunks with a corrupted header
+// can't be skipped.
+func NewChunkedBackupReader(r io.Reader, skipCorrupt bool) *ChunkedBackupReader {
+	return &ChunkedBackupReader{r: r, skipCorrupt: skipCorrupt}
+}
+
+// CorruptChunks returns the indexes of the chunks skipped because their checksum didn't match.
+func (cr *ChunkedBackupReader) CorruptChunks() []uint32 {
+	return cr.corrupt
+}
+
+// Read reads the uncompressed backup.
+func (cr *ChunkedBackupReader) Read(p []byte) (int, error) {
+	for cr.pos == len(cr.buf) {
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf[cr.pos:])
+	cr.pos += n
+	return n, nil
+}
+
+// next reads the next chunk into cr.buf.
+func (cr *ChunkedBackupReader) next() error {
+	if !cr.started {
+		var magic [len(chunkedBackupMagic)]byte
+		if _, err := io.ReadFull(cr.r, magic[:]); err != nil {
+			return errors.Wrap(err, "while reading chunked backup header")
+		}
+		if string(magic[:]) != chunkedBackupMagic {
+			return errors.New("Not a chunked backup")
+		}
+		cr.started = true
+	}
+	hdr, err := readChunkHeader(cr.r)
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case err == io.ErrUnexpectedEOF:
+		return errors.Errorf("Truncated header of backup chunk %d", cr.index)
+	case err != nil:
+		return errors.Wrapf(err, "at backup chunk %d", cr.index)
+	}
+	if hdr.index != cr.index {
+		return errors.Errorf("Found backup chunk %d instead of chunk %d", hdr.index, cr.index)
+	}
+	data := make([]byte, hdr.dataLen)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return errors.Wrapf(err, "while reading backup chunk %d", cr.index)
+	}
+	cr.index++
+	cr.buf, cr.pos = nil, 0
+	if crc32.Checksum(data, y.CastagnoliCrcTable) != hdr.dataCRC {
+		if cr.skipCorrupt {
+			cr.corrupt = append(cr.corrupt, hdr.index)
+			return nil
+		}
+		return errors.Errorf("Checksum mismatch in backup chunk %d", hdr.index)
+	}
+	switch hdr.compression {
+	case options.None:
+		cr.buf = data
+	case options.ZSTD:
+		if cr.buf, err = y.ZSTDDecompress(make([]byte, 0, hdr.rawLen), data); err != nil {
+			return errors.Wrapf(err, "while decompressing backup chunk %d", hdr.index)
+		}
+	default:
+		return errors.Errorf("Unsupported compression %d in backup chunk %d",
+			hdr.compression, hdr.index)
+	}
+	if len(cr.buf) != int(hdr.rawLen) {
+		return errors.Errorf("Backup chunk %d has %d bytes instead of %d", hdr.index,
+			len(cr.buf), hdr.rawLen)
+	}
+	return nil
+}
+
+// ValidBackupPrefix reads a chunked backup, possibly partially written, from r and returns the
+// offset of the end of its last complete and valid chunk, along with the number of chunks up to
+// there. An interrupted copy or upload of a chunked backup can be resumed from that offset.
+func ValidBackupPrefix(r io.Reader) (offset int64, chunks int, err error) {
+	br := bufio.NewReader(r)
+	var magic [len(chunkedBackupMagic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	if string(magic[:]) != chunkedBackupMagic {
+		return 0, 0, errors.New("Not a chunked backup")
+	}
+	offset = int64(len(magic))
+	for {
+		hdr, err := readChunkHeader(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, chunks, nil
+		}
+		if err != nil || hdr.index != uint32(chunks) {
+			// Garbage after the last valid chunk, e.g. from an earlier attempt.
+			return offset, chunks, nil
+		}
+		data := make([]byte, hdr.dataLen)
+		if _, err := io.ReadFull(br, data); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, chunks, nil
+			}
+			return offset, chunks, err
+		}
+		if crc32.Checksum(data, y.CastagnoliCrcTable) != hdr.dataCRC {
+			return offset, chunks, nil
+		}
+		offset += chunkHeaderSize + int64(hdr.dataLen)
+		chunks++
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedBackup(t *testing.T) {
+	compression := options.ZSTD
+	if !y.CgoEnabled {
+		compression = options.None
+	}
+	const n = 1000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	var plain, chunked bytes.Buffer
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		// Chunks only end between lists of keys, write a few incremental backups to get several
+		// chunks.
+		cw := NewChunkedBackupWriter(&chunked, 4<<10, compression)
+		var since uint64
+		for i := 0; i < n; i += 100 {
+			for j := i; j < i+100; j += 10 {
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					for k := j; k < j+10; k++ {
+						if err := txn.Set(key(k), bytes.Repeat([]byte("v"), 100)); err != nil {
+							return err
+						}
+					}
+					return nil
+				}))
+			}
+			version, err := db.Backup(cw, since)
+			require.NoError(t, err)
+			since = version + 1
+		}
+		require.NoError(t, cw.Close())
+		_, err := db.Backup(&plain, 0)
+		require.NoError(t, err)
+	})
+	if compression == options.ZSTD {
+		require.True(t, chunked.Len() < plain.Len())
+	}
+
+	offset, chunks, err := ValidBackupPrefix(bytes.NewReader(chunked.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, int64(chunked.Len()), offset)
+	require.True(t, chunks > 1)
+
+	// An interrupted upload resumes at the end of the last complete chunk.
+	partial := chunked.Bytes()[:offset-1]
+	resumeAt, resumeChunks, err := ValidBackupPrefix(bytes.NewReader(partial))
+	require.NoError(t, err)
+	require.Equal(t, chunks-1, resumeChunks)
+	resumed := append(append([]byte{}, partial[:resumeAt]...), chunked.Bytes()[resumeAt:]...)
+	require.Equal(t, chunked.Bytes(), resumed)
+
+	// Chunked backups get restored like plain ones.
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Load(bytes.NewReader(chunked.Bytes()), 16))
+		stats, err := CountBackup(bytes.NewReader(plain.Bytes()), LoadOptions{})
+		require.NoError(t, err)
+		require.Equal(t, n, stats.Keys)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				if _, err := txn.Get(key(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	})
+
+	// Corrupt the data of the first chunk.
+	corrupted := append([]byte{}, chunked.Bytes()...)
+	corrupted[len(chunkedBackupMagic)+chunkHeaderSize+1] ^= 0xff
+	stats, err := CountBackup(bytes.NewReader(corrupted), LoadOptions{})
+	require.Error(t, err)
+	require.Equal(t, 0, stats.Keys)
+
+	cr := NewChunkedBackupReader(bytes.NewReader(corrupted), true)
+	stats, err = CountBackup(cr, LoadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0}, cr.CorruptChunks())
+	require.True(t, stats.Keys > 0 && stats.Keys < n)
+}
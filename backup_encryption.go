@@ -0,0 +1,261 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// Encrypted backups start with encryptedBackupMagic, followed by a header:
+//
+// | Algorithm (1B) | Key ID (8B) | Nonce (12B) | CRC (4B) |
+//
+// where the key ID is derived from the backup key, so that a wrong key is told apart from
+// corrupted data, and the CRC covers the rest of the header. The data comes next, as segments
+// sealed with AES-GCM:
+//
+// | Length (4B) | Sealed Data (Length bytes) |
+//
+// The high bit of the length is set on the last segment, which is empty, so that a truncated
+// backup doesn't go unnoticed. The nonce of the n-th segment is the nonce of the header XORed with
+// n, and the length is authenticated along with the data.
+const (
+	encryptedBackupMagic      = "BDGRENC1"
+	encryptedBackupHeaderSize = 25
+	lastSegmentBit            = 1 << 31
+)
+
+// backupKeyID returns the ID of a backup key, stored in the header of the backups it encrypts.
+func backupKeyID(key []byte) uint64 {
+	h := sha256.New()
+	_, _ = io.WriteString(h, "badger backup key")
+	_, _ = h.Write(key)
+	return binary.LittleEndian.Uint64(h.Sum(nil))
+}
+
+// segmentNonce returns the nonce of the segment with the given index.
+func segmentNonce(base []byte, index uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	n := len(nonce)
+	binary.BigEndian.PutUint64(nonce[n-8:], binary.BigEndian.Uint64(nonce[n-8:])^index)
+	return nonce
+}
+
+// EncryptedBackupWriter encrypts a backup made by DB.Backup or Stream.Backup with a backup key of
+// its own, unrelated to the encryption key of the DB: backups are plain text otherwise, even when
+// the DB is encrypted. DB.Load and DB.LoadWithOptions read encrypted backups given the key in
+// LoadOptions.BackupKey, and NewEncryptedBackupReader decrypts them for the other readers.
+//
+// The backup is encrypted with AES-GCM, so that it can't be altered without being noticed. It
+// can be chunked before being encrypted, by writing to a ChunkedBackupWriter on top of the
+// EncryptedBackupWriter.
+type EncryptedBackupWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	keyID   uint64
+	nonce   []byte
+	index   uint64
+	started bool
+	closed  bool
+}
+
+// NewEncryptedBackupWriter returns an EncryptedBackupWriter writing to w the backup encrypted with
+// key, which must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256. Close must be
+// called once the backup is done.
+func NewEncryptedBackupWriter(w io.Writer, key []byte) (*EncryptedBackupWriter, error) {
+	aead, err := y.NewGCM(key)
+	if err != nil {
+		return nil, y.Wrapf(err, "while setting up backup encryption")
+	}
+	nonce, err := y.GenerateNonce()
+	if err != nil {
+		return nil, y.Wrapf(err, "while generating backup nonce")
+	}
+	return &EncryptedBackupWriter{w: w, aead: aead, keyID: backupKeyID(key), nonce: nonce}, nil
+}
+
+// Write encrypts p as a segment of its own and writes it.
+func (ew *EncryptedBackupWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("Write on closed EncryptedBackupWriter")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > math.MaxInt32-ew.aead.Overhead() {
+		return 0, errors.Errorf("Backup write of %d bytes is too large to be encrypted", len(p))
+	}
+	if err := ew.seal(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the last segment, marking the end of the backup. It doesn't close the underlying
+// writer.
+func (ew *EncryptedBackupWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(nil, true)
+}
+
+func (ew *EncryptedBackupWriter) seal(p []byte, last bool) error {
+	var buf []byte
+	if !ew.started {
+		buf = append(buf, encryptedBackupMagic...)
+		var hdr [encryptedBackupHeaderSize]byte
+		hdr[0] = byte(pb.EncryptionAlgo_aes_gcm)
+		binary.LittleEndian.PutUint64(hdr[1:9], ew.keyID)
+		copy(hdr[9:21], ew.nonce)
+		binary.LittleEndian.PutUint32(hdr[21:25], crc32.Checksum(hdr[:21], y.CastagnoliCrcTable))
+		buf = append(buf, hdr[:]...)
+		ew.started = true
+	}
+	length := uint32(len(p) + ew.aead.Overhead())
+	if last {
+		length |= lastSegmentBit
+	}
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], length)
+	buf = append(buf, lenBuf[:]...)
+	buf = ew.aead.Seal(buf, segmentNonce(ew.nonce, ew.index), p, lenBuf[:])
+	ew.index++
+	// Write the segment at once, ChunkedBackupWriter cuts chunks between writes.
+	_, err := ew.w.Write(buf)
+	return err
+}
+
+// EncryptedBackupReader decrypts a backup written by an EncryptedBackupWriter.
+type EncryptedBackupReader struct {
+	r       io.Reader
+	key     []byte
+	aead    cipher.AEAD
+	nonce   []byte
+	index   uint64
+	buf     []byte
+	pos     int
+	started bool
+	done    bool
+	err     error // Sticky, so that retrying a failed read doesn't read past the failure.
+}
+
+// NewEncryptedBackupReader returns an EncryptedBackupReader decrypting with key the backup read
+// from r. Reading fails with ErrBackupKeyMismatch if the backup was encrypted with another key.
+func NewEncryptedBackupReader(r io.Reader, key []byte) *EncryptedBackupReader {
+	return &EncryptedBackupReader{r: r, key: key}
+}
+
+// Read reads the decrypted backup.
+func (er *EncryptedBackupReader) Read(p []byte) (int, error) {
+	for er.pos == len(er.buf) {
+		if er.done {
+			return 0, io.EOF
+		}
+		if er.err != nil {
+			return 0, er.err
+		}
+		er.err = er.next()
+	}
+	n := copy(p, er.buf[er.pos:])
+	er.pos += n
+	return n, nil
+}
+
+// readHeader reads the magic and the header of the backup, and sets up its cipher.
+func (er *EncryptedBackupReader) readHeader() error {
+	var buf [len(encryptedBackupMagic) + encryptedBackupHeaderSize]byte
+	if _, err := io.ReadFull(er.r, buf[:]); err != nil {
+		return errors.Wrap(err, "while reading encrypted backup header")
+	}
+	if string(buf[:len(encryptedBackupMagic)]) != encryptedBackupMagic {
+		return errors.New("Not an encrypted backup")
+	}
+	hdr := buf[len(encryptedBackupMagic):]
+	if crc32.Checksum(hdr[:21], y.CastagnoliCrcTable) != binary.LittleEndian.Uint32(hdr[21:25]) {
+		return errors.New("Corrupted encrypted backup header")
+	}
+	if algo := pb.EncryptionAlgo(hdr[0]); algo != pb.EncryptionAlgo_aes_gcm {
+		return errors.Errorf("Unsupported backup encryption algorithm: %s", algo)
+	}
+	if binary.LittleEndian.Uint64(hdr[1:9]) != backupKeyID(er.key) {
+		return ErrBackupKeyMismatch
+	}
+	aead, err := y.NewGCM(er.key)
+	if err != nil {
+		return y.Wrapf(err, "while setting up backup decryption")
+	}
+	er.aead = aead
+	er.nonce = append([]byte(nil), hdr[9:21]...)
+	er.started = true
+	return nil
+}
+
+// next reads and decrypts the next segment into er.buf.
+func (er *EncryptedBackupReader) next() error {
+	if !er.started {
+		if err := er.readHeader(); err != nil {
+			return err
+		}
+	}
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(er.r, lenBuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.Errorf("Truncated encrypted backup at segment %d", er.index)
+		}
+		return errors.Wrapf(err, "while reading encrypted backup segment %d", er.index)
+	}
+	length := binary.LittleEndian.Uint32(lenBuf[:])
+	sealed := make([]byte, length&^lastSegmentBit)
+	if _, err := io.ReadFull(er.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.Errorf("Truncated encrypted backup at segment %d", er.index)
+		}
+		return errors.Wrapf(err, "while reading encrypted backup segment %d", er.index)
+	}
+	data, err := er.aead.Open(sealed[:0], segmentNonce(er.nonce, er.index), sealed, lenBuf[:])
+	if err != nil {
+		return errors.Errorf("Encrypted backup segment %d failed authentication", er.index)
+	}
+	er.index++
+	er.buf, er.pos = data, 0
+	er.done = length&lastSegmentBit != 0
+	return nil
+}
+
+// BackupWithKey is like Backup, but encrypts the backup with key, see EncryptedBackupWriter.
+func (db *DB) BackupWithKey(w io.Writer, since uint64, key []byte) (uint64, error) {
+	ew, err := NewEncryptedBackupWriter(w, key)
+	if err != nil {
+		return 0, err
+	}
+	maxVersion, err := db.Backup(ew, since)
+	if err != nil {
+		return 0, err
+	}
+	return maxVersion, ew.Close()
+}
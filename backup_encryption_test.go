@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedBackup(t *testing.T) {
+	const n = 500
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	value := []byte("secret value")
+	backupKey := bytes.Repeat([]byte("b"), 32)
+
+	// The DB is encrypted with another key than the backup.
+	opt := getTestOptions("").WithEncryptionKey(bytes.Repeat([]byte("k"), 32)).
+		WithMaxCacheSize(1 << 20)
+	var encrypted, chunked bytes.Buffer
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < n; i++ {
+			txnSet(t, db, key(i), value, 0)
+		}
+		_, err := db.BackupWithKey(&encrypted, 0, backupKey)
+		require.NoError(t, err)
+
+		// Chunks compressed, then encrypted.
+		ew, err := NewEncryptedBackupWriter(&chunked, backupKey)
+		require.NoError(t, err)
+		cw := NewChunkedBackupWriter(ew, 4<<10, options.None)
+		_, err = db.Backup(cw, 0)
+		require.NoError(t, err)
+		require.NoError(t, cw.Close())
+		require.NoError(t, ew.Close())
+	})
+	require.False(t, bytes.Contains(encrypted.Bytes(), value))
+	require.False(t, bytes.Contains(encrypted.Bytes(), key(0)))
+
+	for _, backup := range [][]byte{encrypted.Bytes(), chunked.Bytes()} {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			stats, err := db.LoadWithOptions(bytes.NewReader(backup), 16,
+				LoadOptions{BackupKey: backupKey})
+			require.NoError(t, err)
+			require.Equal(t, n, stats.Keys)
+			require.NoError(t, db.View(func(txn *Txn) error {
+				for i := 0; i < n; i++ {
+					item, err := txn.Get(key(i))
+					if err != nil {
+						return err
+					}
+					require.Equal(t, value, getItemValue(t, item))
+				}
+				return nil
+			}))
+		})
+	}
+
+	// Other readers decrypt through an EncryptedBackupReader.
+	br, err := OpenBackup(NewEncryptedBackupReader(bytes.NewReader(encrypted.Bytes()), backupKey))
+	require.NoError(t, err)
+	require.NoError(t, br.View(0, func(txn *Txn) error {
+		_, err := txn.Get(key(n - 1))
+		return err
+	}))
+	require.NoError(t, br.Close())
+
+	count := func(backup []byte, key []byte) error {
+		_, err := CountBackup(bytes.NewReader(backup), LoadOptions{BackupKey: key})
+		return errors.Cause(err)
+	}
+	require.Equal(t, ErrBackupKeyMissing, count(encrypted.Bytes(), nil))
+	require.Equal(t, ErrBackupKeyMismatch, count(encrypted.Bytes(), bytes.Repeat([]byte("x"), 32)))
+
+	// Tampering with the data or cutting the backup short is noticed.
+	tampered := append([]byte{}, encrypted.Bytes()...)
+	tampered[len(encryptedBackupMagic)+encryptedBackupHeaderSize+10] ^= 0xff
+	err = count(tampered, backupKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed authentication")
+
+	// Drop the last segment, which is empty.
+	truncated := encrypted.Bytes()[:encrypted.Len()-4-16]
+	err = count(truncated, backupKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Truncated")
+}
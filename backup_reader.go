@@ -0,0 +1,134 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io"
+	"math"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+)
+
+// BackupReader serves reads from a set of backups, without restoring them to a DB on disk. The
+// backups are loaded into an in-memory DB, so they must fit in memory.
+type BackupReader struct {
+	db         *DB
+	maxVersion uint64
+}
+
+// OpenBackup loads the backups read from rs, made by DB.Backup or Stream.Backup, and returns a
+// BackupReader serving reads from them. Incremental backups must come after the backup they were
+// made on top of. The BackupReader must be closed once done with it.
+func OpenBackup(rs ...io.Reader) (*BackupReader, error) {
+	opt := DefaultOptions("").WithInMemory(true).WithLogger(nil)
+	db, err := OpenManaged(opt)
+	if err != nil {
+		return nil, errors.Wrap(err, "while opening in-memory DB for backup")
+	}
+	br := &BackupReader{db: db}
+	for i, r := range rs {
+		if err := db.Load(r, 16); err != nil {
+			_ = db.Close()
+			return nil, errors.Wrapf(err, "while loading backup %d", i)
+		}
+	}
+	br.maxVersion = db.orc.nextTxnTs - 1
+	return br, nil
+}
+
+// MaxVersion returns the highest version found in the backups, which is the version of the last
+// backup, as returned by DB.Backup.
+func (br *BackupReader) MaxVersion() uint64 {
+	return br.maxVersion
+}
+
+// View runs fn in a read-only transaction reading the backups as of version readTs. Zero reads
+// the latest versions.
+func (br *BackupReader) View(readTs uint64, fn func(txn *Txn) error) error {
+	if readTs == 0 {
+		readTs = math.MaxUint64
+	}
+	txn := br.db.NewTransactionAt(readTs, false)
+	defer txn.Discard()
+	return fn(txn)
+}
+
+// Restore writes all the versions of the given keys found in the backups to db, along with their
+// deletion markers. An empty list of keys restores everything.
+func (br *BackupReader) Restore(db *DB, keys ...[]byte) error {
+	ldr := db.NewKVLoader(16)
+	txn := br.db.NewTransactionAt(math.MaxUint64, false)
+	defer txn.Discard()
+	opt := DefaultIteratorOptions
+	opt.AllVersions = true
+
+	restore := func(it *Iterator) error {
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			kv := &pb.KV{
+				Key:       item.KeyCopy(nil),
+				UserMeta:  []byte{item.UserMeta()},
+				Version:   item.Version(),
+				ExpiresAt: item.ExpiresAt(),
+				Meta:      []byte{item.meta &^ (bitTxn | bitFinTxn)},
+			}
+			if !item.IsDeletedOrExpired() {
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				kv.Value = val
+			}
+			if err := ldr.Set(kv); err != nil {
+				return err
+			}
+			if kv.Version >= db.orc.nextTxnTs && !db.opt.managedTxns {
+				db.orc.nextTxnTs = kv.Version + 1
+			}
+		}
+		return nil
+	}
+	if len(keys) == 0 {
+		it := txn.NewIterator(opt)
+		err := restore(it)
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		it := txn.NewKeyIterator(key, opt)
+		err := restore(it)
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := ldr.Finish(); err != nil {
+		return err
+	}
+	if !db.opt.managedTxns {
+		db.orc.txnMark.Done(db.orc.nextTxnTs - 1)
+	}
+	return nil
+}
+
+// Close releases the memory used by the backups.
+func (br *BackupReader) Close() error {
+	return br.db.Close()
+}
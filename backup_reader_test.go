@@ -0,0 +1,97 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupReader(t *testing.T) {
+	var full, incr bytes.Buffer
+	var v1, v2 uint64
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(key, val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key), []byte(val))
+			}))
+		}
+		set("a", "a1")
+		set("b", "b1")
+		var err error
+		v1, err = db.Backup(&full, 0)
+		require.NoError(t, err)
+
+		set("a", "a2")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("b"))
+		}))
+		set("c", "c1")
+		v2, err = db.Backup(&incr, v1+1)
+		require.NoError(t, err)
+	})
+
+	br, err := OpenBackup(&full, &incr)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, br.Close()) }()
+	require.Equal(t, v2, br.MaxVersion())
+
+	read := func(readTs uint64) map[string]string {
+		kvs := make(map[string]string)
+		require.NoError(t, br.View(readTs, func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				val, err := it.Item().ValueCopy(nil)
+				require.NoError(t, err)
+				kvs[string(it.Item().Key())] = string(val)
+			}
+			return nil
+		}))
+		return kvs
+	}
+	require.Equal(t, map[string]string{"a": "a1", "b": "b1"}, read(v1))
+	require.Equal(t, map[string]string{"a": "a2", "c": "c1"}, read(0))
+
+	// Restore a single key to another DB, with its history.
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, br.Restore(db, []byte("a"), []byte("b")))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("a"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("a2"), getItemValue(t, item))
+			_, err = txn.Get([]byte("b"))
+			require.Equal(t, ErrKeyNotFound, err)
+			_, err = txn.Get([]byte("c"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		opt := DefaultIteratorOptions
+		opt.AllVersions = true
+		it := txn.NewKeyIterator([]byte("a"), opt)
+		defer it.Close()
+		var versions int
+		for it.Rewind(); it.Valid(); it.Next() {
+			versions++
+		}
+		require.Equal(t, 2, versions)
+	})
+}
@@ -501,3 +501,64 @@ func TestBackupLoadIncremental(t *testing.T) {
 	})
 	require.NoError(t, err, "%v %v", updates, actual)
 }
+
+func TestBackupLoadWithOptions(t *testing.T) {
+	var bb bytes.Buffer
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for _, tenant := range []string{"t1/", "t2/", "t3/"} {
+			for i := 0; i < 10; i++ {
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					return txn.Set([]byte(fmt.Sprintf("%skey%d", tenant, i)), []byte("value"))
+				}))
+			}
+		}
+		// A second version of one key.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("t1/key0"), []byte("value2"))
+		}))
+		_, err := db.Backup(&bb, 0)
+		require.NoError(t, err)
+	})
+
+	opt := LoadOptions{
+		IncludePrefixes: [][]byte{[]byte("t1/"), []byte("t2/")},
+		ExcludePrefixes: [][]byte{[]byte("t2/key1")},
+	}
+	stats, err := CountBackup(bytes.NewReader(bb.Bytes()), opt)
+	require.NoError(t, err)
+	// t2/key1 excluded, t1/key0 with 2 versions.
+	require.Equal(t, LoadStats{Keys: 19, Versions: 20, Bytes: 20*7 + 19*5 + 6}, stats)
+
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		opt.DryRun = true
+		dryStats, err := db.LoadWithOptions(bytes.NewReader(bb.Bytes()), 16, opt)
+		require.NoError(t, err)
+		require.Equal(t, stats, dryStats)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			it.Rewind()
+			require.False(t, it.Valid())
+			return nil
+		}))
+
+		opt.DryRun = false
+		loadStats, err := db.LoadWithOptions(bytes.NewReader(bb.Bytes()), 16, opt)
+		require.NoError(t, err)
+		require.Equal(t, stats, loadStats)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for key, found := range map[string]bool{
+				"t1/key0": true, "t1/key9": true, "t2/key0": true,
+				"t2/key1": false, "t3/key0": false,
+			} {
+				_, err := txn.Get([]byte(key))
+				if found {
+					require.NoError(t, err, key)
+				} else {
+					require.Equal(t, ErrKeyNotFound, err, key)
+				}
+			}
+			return nil
+		}))
+	})
+}
@@ -18,15 +18,19 @@ package cmd
 
 import (
 	"bufio"
+	"io"
 	"os"
 
 	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/spf13/cobra"
 )
 
 var backupFile string
 var truncate bool
+var backupChunkSize int
+var backupKeyFile string
 
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
@@ -37,7 +41,12 @@ var backupCmd = &cobra.Command{
 Iterates over each key-value pair, encodes it along with its metadata and
 version in protocol buffers and writes them to a file. This file can later be
 used by the restore command to create an identical copy of the
-database.`,
+database.
+
+With --chunk-size, the backup is written as independently compressed and
+checksummed chunks. With --backup-key-file, the backup is encrypted with the
+given key, independently of the encryption of the DB. The restore command reads
+such backups as well.`,
 	RunE: doBackup,
 }
 
@@ -47,6 +56,10 @@ func init() {
 		"badger.bak", "File to backup to")
 	backupCmd.Flags().BoolVarP(&truncate, "truncate", "t",
 		false, "Allow value log truncation if required.")
+	backupCmd.Flags().IntVar(&backupChunkSize, "chunk-size", 0,
+		"Size of the chunks of a chunked backup, before compression. 0 writes a plain backup.")
+	backupCmd.Flags().StringVar(&backupKeyFile, "backup-key-file", "",
+		"Path of the key to encrypt the backup with. Leave empty for a plain text backup.")
 }
 
 func doBackup(cmd *cobra.Command, args []string) error {
@@ -66,9 +79,40 @@ func doBackup(cmd *cobra.Command, args []string) error {
 	}
 
 	bw := bufio.NewWriterSize(f, 64<<20)
-	if _, err = db.Backup(bw, 0); err != nil {
+	var w io.Writer = bw
+	var ew *badger.EncryptedBackupWriter
+	if backupKeyFile != "" {
+		key, err := getKey(backupKeyFile)
+		if err != nil {
+			return err
+		}
+		if ew, err = badger.NewEncryptedBackupWriter(bw, key); err != nil {
+			return err
+		}
+		w = ew
+	}
+	var cw *badger.ChunkedBackupWriter
+	if backupChunkSize > 0 {
+		compression := options.ZSTD
+		if !y.CgoEnabled {
+			compression = options.None
+		}
+		cw = badger.NewChunkedBackupWriter(w, backupChunkSize, compression)
+		w = cw
+	}
+	if _, err = db.Backup(w, 0); err != nil {
 		return err
 	}
+	if cw != nil {
+		if err = cw.Close(); err != nil {
+			return err
+		}
+	}
+	if ew != nil {
+		if err = ew.Close(); err != nil {
+			return err
+		}
+	}
 
 	if err = bw.Flush(); err != nil {
 		return err
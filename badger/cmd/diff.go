@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var diffOpt struct {
+	otherDir      string
+	otherVlogDir  string
+	backupFile    string
+	backupKeyFile string
+	limit         int
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the database with another one, or with a backup.",
+	Long: `
+This command lists the keys whose latest version differs between the database and the one in
+--other-dir, or the backup in --backup-file, which gets loaded in memory. The key ranges of the
+databases are compared by their digests, only the ranges which differ being read. A "-" line is a
+key only in the database, a "+" line a key only in the other one, and a "~" line a key having
+different values.
+`,
+	RunE: doDiff,
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffOpt.otherDir, "other-dir", "",
+		"Directory of the database to compare with.")
+	diffCmd.Flags().StringVar(&diffOpt.otherVlogDir, "other-vlog-dir", "",
+		"Directory of the value log of the database to compare with. Defaults to --other-dir.")
+	diffCmd.Flags().StringVar(&diffOpt.backupFile, "backup-file", "",
+		"Backup to compare with, instead of a database.")
+	diffCmd.Flags().StringVar(&diffOpt.backupKeyFile, "backup-key-file", "",
+		"Path of the key the backup was encrypted with. Leave empty for a plain text backup.")
+	diffCmd.Flags().IntVar(&diffOpt.limit, "limit", 0,
+		"Stop after this number of differing keys. 0 lists them all.")
+}
+
+func doDiff(cmd *cobra.Command, args []string) error {
+	if (diffOpt.otherDir == "") == (diffOpt.backupFile == "") {
+		return errors.New("exactly one of --other-dir and --backup-file must be set")
+	}
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithReadOnly(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+
+	other, err := openDiffOther()
+	if err != nil {
+		return err
+	}
+	defer other.Close()
+
+	var n int
+	errLimit := errors.New("limit reached")
+	err = badger.Diff(db, other, func(d badger.Difference) error {
+		sign := "~"
+		switch {
+		case !d.InB:
+			sign = "-"
+		case !d.InA:
+			sign = "+"
+		}
+		fmt.Printf("%s %q\n", sign, d.Key)
+		n++
+		if n == diffOpt.limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return err
+	}
+	fmt.Printf("%d differing keys.\n", n)
+	return nil
+}
+
+// openDiffOther opens the database or loads the backup to compare with.
+func openDiffOther() (*badger.DB, error) {
+	if diffOpt.otherDir != "" {
+		vlogDir := diffOpt.otherVlogDir
+		if vlogDir == "" {
+			vlogDir = diffOpt.otherDir
+		}
+		db, err := badger.Open(badger.DefaultOptions(diffOpt.otherDir).
+			WithValueDir(vlogDir).
+			WithReadOnly(true))
+		return db, errors.Wrap(err, "failed to open the other database")
+	}
+
+	var opt badger.LoadOptions
+	if diffOpt.backupKeyFile != "" {
+		key, err := getKey(diffOpt.backupKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opt.BackupKey = key
+	}
+	f, err := os.Open(diffOpt.backupFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.LoadWithOptions(f, 256, opt); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to load the backup")
+	}
+	return db, nil
+}
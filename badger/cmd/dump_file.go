@@ -0,0 +1,353 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/format"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var dumpFileOpt struct {
+	keyPath     string
+	compression string
+	entries     bool
+	limit       int
+}
+
+var dumpFileCmd = &cobra.Command{
+	Use:   "dump-file <path>",
+	Short: "Decode a single table or value log file.",
+	Long: `
+This command decodes a .sst or .vlog file without opening the DB, printing its layout, the status of
+its checksums, its entries and some stats. The compression and data key of a table are looked up in
+the MANIFEST of --dir, and the data keys in its key registry, which needs the encryption key if the
+DB is encrypted. Corrupted blocks and entries are reported, and the dump goes on with the rest of
+the file when possible.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: doDumpFile,
+}
+
+func init() {
+	RootCmd.AddCommand(dumpFileCmd)
+	dumpFileCmd.Flags().StringVarP(&dumpFileOpt.keyPath, "encryption-key-file", "k", "",
+		"Path of the encryption key. Leave empty for a DB which isn't encrypted.")
+	dumpFileCmd.Flags().StringVar(&dumpFileOpt.compression, "compression", "",
+		"Compression of the table blocks: none, snappy or zstd. Leave empty to look it up in "+
+			"the MANIFEST.")
+	dumpFileCmd.Flags().BoolVar(&dumpFileOpt.entries, "entries", true,
+		"Print the entries of the file, not just its layout and stats.")
+	dumpFileCmd.Flags().IntVar(&dumpFileOpt.limit, "limit", 0,
+		"Print at most this many entries. 0 prints all of them.")
+}
+
+func doDumpFile(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File: %s, size %s\n", path, humanize.IBytes(uint64(fi.Size())))
+
+	switch filepath.Ext(path) {
+	case ".sst":
+		return dumpTable(f, fi.Size())
+	case ".vlog":
+		return dumpValueLog(f, fi.Size())
+	}
+	return errors.Errorf("%s is neither a table nor a value log file", path)
+}
+
+// dumpKeyRegistry opens the key registry of --dir.
+func dumpKeyRegistry() (*badger.KeyRegistry, error) {
+	key, err := getKey(dumpFileOpt.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	kr, err := badger.OpenKeyRegistry(badger.KeyRegistryOptions{
+		Dir:           sstDir,
+		ReadOnly:      true,
+		EncryptionKey: key,
+	})
+	return kr, errors.Wrap(err, "failed to open key registry")
+}
+
+// dumpDataKey returns the data key with the given ID from the key registry of --dir.
+func dumpDataKey(id uint64) (*pb.DataKey, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	kr, err := dumpKeyRegistry()
+	if err != nil {
+		return nil, err
+	}
+	defer kr.Close()
+	return kr.DataKey(id)
+}
+
+// dumpTableManifest returns the manifest entry of the table with the given ID.
+func dumpTableManifest(id uint64) (badger.TableManifest, bool, error) {
+	fp, err := os.Open(filepath.Join(sstDir, badger.ManifestFilename))
+	if err != nil {
+		return badger.TableManifest{}, false, err
+	}
+	defer fp.Close()
+	kr, err := dumpKeyRegistry()
+	if err != nil {
+		return badger.TableManifest{}, false, err
+	}
+	defer kr.Close()
+	manifest, _, err := badger.ReplayManifestFileWithKeys(fp, kr)
+	if err != nil {
+		return badger.TableManifest{}, false, errors.Wrap(err, "failed to read MANIFEST")
+	}
+	tm, ok := manifest.Tables[id]
+	return tm, ok, nil
+}
+
+var compressionNames = map[options.CompressionType]string{
+	options.None:   "none",
+	options.Snappy: "snappy",
+	options.ZSTD:   "zstd",
+}
+
+func dumpTable(f *os.File, size int64) error {
+	id, ok := table.ParseFileID(f.Name())
+	if !ok {
+		return errors.Errorf("invalid table file name %s", f.Name())
+	}
+	tm, ok, err := dumpTableManifest(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("Table %d isn't in the MANIFEST, assuming it's plain text.\n", id)
+	}
+	if dumpFileOpt.compression != "" {
+		var found bool
+		for c, name := range compressionNames {
+			if strings.EqualFold(dumpFileOpt.compression, name) {
+				tm.Compression, found = c, true
+			}
+		}
+		if !found {
+			return errors.Errorf("invalid compression %q", dumpFileOpt.compression)
+		}
+	}
+	dk, err := dumpDataKey(tm.KeyID)
+	if err != nil {
+		return err
+	}
+	encryption := ""
+	if tm.ValuesOnly {
+		// The blocks and the index are in plain text, only the values are encrypted.
+		dk, encryption = nil, " (values only)"
+	}
+	decrypt, err := tableDecrypter(dk)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Table %d: level %d, compression %s, data key %d%s\n", id, tm.Level,
+		compressionNames[tm.Compression], tm.KeyID, encryption)
+
+	footer, err := format.ReadTableFooter(f, size)
+	if err != nil {
+		return err
+	}
+	data, err := format.ReadTableIndex(f, footer)
+	checksumStatus := "OK"
+	if err != nil {
+		if errors.Cause(err) != y.ErrChecksumMismatch {
+			return err
+		}
+		checksumStatus = "MISMATCH"
+	}
+	fmt.Printf("Index: offset %d, length %d, checksum %s %s\n", footer.IndexOffset,
+		footer.IndexLen, footer.Checksum.Algo, checksumStatus)
+	if data == nil {
+		// The index failed its checksum, read it anyway to try and parse it.
+		data = make([]byte, footer.IndexLen)
+		if _, err := f.ReadAt(data, footer.IndexOffset); err != nil {
+			return err
+		}
+	}
+	if data, err = decrypt(data); err != nil {
+		return errors.Wrap(err, "failed to decrypt table index")
+	}
+	index, err := format.ParseTableIndex(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Format version %d, estimated size %s, bloom filter %s, %d blocks\n",
+		index.FormatVersion, humanize.IBytes(index.EstimatedSize),
+		humanize.IBytes(uint64(len(index.BloomFilter))), len(index.Offsets))
+
+	var entries, badBlocks, printed int
+	var keyBytes, valueBytes uint64
+	for i, ko := range index.Offsets {
+		blk, err := dumpBlock(f, ko, tm.Compression, decrypt)
+		if err != nil {
+			badBlocks++
+			fmt.Printf("Block %d: offset %d, length %d, CORRUPTED: %v\n", i, ko.Offset, ko.Len, err)
+			continue
+		}
+		fmt.Printf("Block %d: offset %d, length %d, %d entries, checksum %s OK\n", i, ko.Offset,
+			ko.Len, len(blk.Entries), blk.Checksum.Algo)
+		for _, e := range blk.Entries {
+			entries++
+			keyBytes += uint64(len(e.Key))
+			valueBytes += uint64(len(e.Value))
+			if !dumpFileOpt.entries || (dumpFileOpt.limit > 0 && printed >= dumpFileOpt.limit) {
+				continue
+			}
+			printed++
+			fmt.Printf("  %X v%d meta 0x%02x user meta 0x%02x expires %d value %d bytes\n",
+				y.ParseKey(e.Key), y.ParseTs(e.Key), e.Meta, e.UserMeta, e.ExpiresAt,
+				len(e.Value))
+		}
+	}
+	fmt.Printf("\n%d blocks, %d corrupted, %d entries, %s of keys, %s of values.\n",
+		len(index.Offsets), badBlocks, entries, humanize.IBytes(keyBytes),
+		humanize.IBytes(valueBytes))
+	return nil
+}
+
+// tableDecrypter returns a function decrypting the blocks and index of a table encrypted with
+// the given data key, which are followed by their IV or nonce.
+func tableDecrypter(dk *pb.DataKey) (func([]byte) ([]byte, error), error) {
+	if dk == nil {
+		return func(data []byte) ([]byte, error) { return data, nil }, nil
+	}
+	if dk.Algo == pb.EncryptionAlgo_aes_gcm {
+		aead, err := y.NewGCM(dk.Data)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			n := len(data) - aead.NonceSize()
+			if n < 0 {
+				return nil, format.ErrCorrupt
+			}
+			return aead.Open(nil, data[n:], data[:n], nil)
+		}, nil
+	}
+	c, err := y.NewCipher(dk.Data)
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) ([]byte, error) {
+		n := len(data) - aes.BlockSize
+		if n < 0 {
+			return nil, format.ErrCorrupt
+		}
+		return y.XORBlockWithCipher(data[:n], c, data[n:]), nil
+	}, nil
+}
+
+func dumpBlock(f *os.File, ko *pb.BlockOffset, compression options.CompressionType,
+	decrypt func([]byte) ([]byte, error)) (*format.Block, error) {
+	data, err := format.ReadBlock(f, ko)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decrypt(data); err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	switch compression {
+	case options.Snappy:
+		data, err = snappy.Decode(nil, data)
+	case options.ZSTD:
+		data, err = y.ZSTDDecompress(nil, data)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress")
+	}
+	return format.ParseBlock(data)
+}
+
+func dumpValueLog(f *os.File, size int64) error {
+	vr, err := format.NewValueLogReader(f)
+	if err != nil {
+		return err
+	}
+	h := vr.Header()
+	dk, err := dumpDataKey(h.KeyID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Value log: header version %d, checksum %s, data key %d\n", h.Version, h.Checksum,
+		h.KeyID)
+	var c cipher.Block
+	if dk != nil {
+		if c, err = y.NewCipher(dk.Data); err != nil {
+			return err
+		}
+	}
+
+	var entries, printed int
+	var keyBytes, valueBytes uint64
+	for {
+		e, err := vr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("CORRUPTED: %v\n", err)
+			break
+		}
+		entries++
+		keyBytes += uint64(len(e.Key))
+		valueBytes += uint64(len(e.Value))
+		if !dumpFileOpt.entries || (dumpFileOpt.limit > 0 && printed >= dumpFileOpt.limit) {
+			continue
+		}
+		printed++
+		key := e.Key
+		if c != nil {
+			// The key and value are encrypted as one stream, which starts with the key.
+			key = y.XORBlockWithCipher(e.Key, c, h.IV(e.Offset))
+		}
+		fmt.Printf("  offset %d: %X v%d meta 0x%02x user meta 0x%02x expires %d value %d bytes\n",
+			e.Offset, y.ParseKey(key), y.ParseTs(key), e.Header.Meta, e.Header.UserMeta,
+			e.Header.ExpiresAt, len(e.Value))
+	}
+	fmt.Printf("\n%d entries, %s of keys, %s of values, valid up to offset %d, %s after it.\n",
+		entries, humanize.IBytes(keyBytes), humanize.IBytes(valueBytes), vr.Offset(),
+		humanize.IBytes(uint64(size-int64(vr.Offset()))))
+	return nil
+}
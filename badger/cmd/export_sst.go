@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/rocksdbsst"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var exportSstOpt struct {
+	output   string
+	prefix   string
+	start    string
+	end      string
+	readOnly bool
+}
+
+var exportSstCmd = &cobra.Command{
+	Use:   "export-sst",
+	Short: "Export keys to a RocksDB SST file.",
+	Long: `
+This command writes the latest version of the keys of a range to an SST file in the block-based
+table format of RocksDB, which RocksDB based systems can bulk load with IngestExternalFile. Deleted
+and expired keys are left out, and so are user metadata and expiration times.
+`,
+	RunE: doExportSst,
+}
+
+func init() {
+	RootCmd.AddCommand(exportSstCmd)
+	exportSstCmd.Flags().StringVarP(&exportSstOpt.output, "output", "o", "badger.sst",
+		"File to write to.")
+	exportSstCmd.Flags().StringVar(&exportSstOpt.prefix, "prefix", "",
+		"Only export the keys with this prefix.")
+	exportSstCmd.Flags().StringVar(&exportSstOpt.start, "start", "",
+		"Only export the keys from this one.")
+	exportSstCmd.Flags().StringVar(&exportSstOpt.end, "end", "",
+		"Only export the keys before this one. Leave empty to export up to the last key.")
+	exportSstCmd.Flags().BoolVar(&exportSstOpt.readOnly, "read-only", true,
+		"If set to true, DB will be opened in read only mode.")
+}
+
+func doExportSst(cmd *cobra.Command, args []string) error {
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithReadOnly(exportSstOpt.readOnly))
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+
+	f, err := os.Create(exportSstOpt.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 4<<20)
+	w := rocksdbsst.NewWriter(bw, rocksdbsst.DefaultOptions)
+
+	var count int
+	err = db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = []byte(exportSstOpt.prefix)
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		start := []byte(exportSstOpt.start)
+		if bytes.Compare(start, opt.Prefix) < 0 {
+			start = opt.Prefix
+		}
+		end := []byte(exportSstOpt.end)
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if len(end) > 0 && bytes.Compare(item.Key(), end) >= 0 {
+				break
+			}
+			err := item.Value(func(val []byte) error {
+				return w.Add(item.Key(), val)
+			})
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("no keys to export")
+	}
+	if err := w.Finish(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := y.FileSync(f); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d keys to %s (%d bytes).\n", count, exportSstOpt.output, w.Size())
+	return nil
+}
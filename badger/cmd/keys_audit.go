@@ -0,0 +1,213 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/format"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var auditOpt struct {
+	keyPath          string
+	rotationDuration time.Duration
+}
+
+var keysAuditCmd = &cobra.Command{
+	Use:   "keys-audit",
+	Short: "Audit the data keys used by the files of an encrypted DB.",
+	Long: `
+This command lists every table and value log file along with the ID of the data key it's encrypted
+with. Plain text files in an encrypted DB, files using data keys older than the rotation duration
+and files using data keys missing from the key registry are flagged. The DB doesn't get opened, so
+the command can run while it's in use.
+`,
+	RunE: doKeysAudit,
+}
+
+func init() {
+	RootCmd.AddCommand(keysAuditCmd)
+	keysAuditCmd.Flags().StringVarP(&auditOpt.keyPath, "encryption-key-file", "k", "",
+		"Path of the encryption key. Leave empty for a DB which isn't encrypted.")
+	keysAuditCmd.Flags().DurationVar(&auditOpt.rotationDuration, "rotation-duration",
+		10*24*time.Hour, "Data keys older than this are flagged.")
+}
+
+// auditFile is a table or value log file along with the ID of its data key.
+type auditFile struct {
+	name  string
+	level int // Level of the table, -1 for value log files.
+	keyID uint64
+}
+
+func doKeysAudit(cmd *cobra.Command, args []string) error {
+	key, err := getKey(auditOpt.keyPath)
+	if err != nil {
+		return err
+	}
+	kr, err := badger.OpenKeyRegistry(badger.KeyRegistryOptions{
+		Dir:           sstDir,
+		ReadOnly:      true,
+		EncryptionKey: key,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to open key registry")
+	}
+	defer kr.Close()
+	keys := make(map[uint64]badger.DataKeyInfo)
+	for _, dk := range kr.DataKeys() {
+		keys[dk.KeyID] = dk
+	}
+
+	files, err := auditTables(sstDir, kr)
+	if err != nil {
+		return err
+	}
+	vlogFiles, err := auditValueLogFiles(vlogDir)
+	if err != nil {
+		return err
+	}
+	files = append(files, vlogFiles...)
+
+	encrypted := len(key) > 0
+	now := time.Now()
+	var issues int
+	fmt.Printf("[Data keys]\n")
+	for _, dk := range kr.DataKeys() {
+		var extra string
+		if len(dk.Prefix) > 0 {
+			extra += fmt.Sprintf(", prefix %q", dk.Prefix)
+		}
+		if dk.Current {
+			extra += ", current"
+		}
+		fmt.Printf("Key %d: purpose %s, algorithm %s, created %s (%s ago)%s\n", dk.KeyID,
+			dk.Purpose, pb.EncryptionAlgo(dk.Algorithm), dk.CreatedAt.Format(time.RFC3339),
+			now.Sub(dk.CreatedAt).Round(time.Second), extra)
+	}
+	fmt.Printf("\n[Files]\n")
+	for _, f := range files {
+		var flags []string
+		dk, ok := keys[f.keyID]
+		switch {
+		case f.keyID == 0 && encrypted:
+			flags = append(flags, "PLAINTEXT")
+		case f.keyID != 0 && !ok:
+			flags = append(flags, "MISSING KEY")
+		case f.keyID != 0 && now.Sub(dk.CreatedAt) > auditOpt.rotationDuration:
+			flags = append(flags, "OLD KEY")
+		}
+		level := "vlog"
+		if f.level >= 0 {
+			level = fmt.Sprintf("L%d", f.level)
+		}
+		var flagString string
+		if len(flags) > 0 {
+			issues++
+			flagString = " [" + strings.Join(flags, ", ") + "]"
+		}
+		fmt.Printf("%-14s %-5s key %d%s\n", f.name, level, f.keyID, flagString)
+	}
+	fmt.Printf("\n%d files, %d data keys, %d issues found.\n", len(files), len(keys), issues)
+	return nil
+}
+
+// auditTables returns the tables listed in the MANIFEST in dir, which may be encrypted with a data
+// key of kr.
+func auditTables(dir string, kr *badger.KeyRegistry) ([]auditFile, error) {
+	fp, err := os.Open(filepath.Join(dir, badger.ManifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	manifest, _, err := badger.ReplayManifestFileWithKeys(fp, kr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read MANIFEST")
+	}
+	var files []auditFile
+	for id, tm := range manifest.Tables {
+		files = append(files, auditFile{
+			name:  table.IDToFilename(id),
+			level: int(tm.Level),
+			keyID: tm.KeyID,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].level != files[j].level {
+			return files[i].level < files[j].level
+		}
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+// auditValueLogFiles returns the value log files in dir, reading their data key ID from their
+// header.
+func auditValueLogFiles(dir string) ([]auditFile, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []auditFile
+	for _, fi := range fileInfos {
+		if !strings.HasSuffix(fi.Name(), ".vlog") {
+			continue
+		}
+		keyID, err := readValueLogKeyID(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, auditFile{name: fi.Name(), level: -1, keyID: keyID})
+	}
+	// ReadDir returns the files sorted by name, which sorts them by file ID.
+	return files, nil
+}
+
+// readValueLogKeyID reads the data key ID stored in the header of a value log file.
+func readValueLogKeyID(path string) (uint64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	buf := make([]byte, format.ValueLogHeaderSize)
+	n, err := io.ReadFull(fp, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, errors.Wrapf(err, "failed to read header of %s", path)
+	}
+	if n < format.ValueLogHeaderSizeV0 {
+		// The header hasn't been written yet, nothing is encrypted.
+		return 0, nil
+	}
+	h, err := format.ParseValueLogHeader(buf[:n])
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read header of %s", path)
+	}
+	return h.KeyID, nil
+}
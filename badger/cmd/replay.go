@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/spf13/cobra"
+)
+
+var replayFile string
+var replayRealtime bool
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay an access trace against a Badger database.",
+	Long: `Replay re-executes an access trace recorded with Options.AccessTracePath
+against the database in --dir.
+
+Traces only contain key hashes and sizes, so keys are synthesized from the hash
+and values are zero filled. Writes are grouped into transactions by the commit
+records found in the trace. Use a scratch directory, the replayed writes are
+persisted.`,
+	RunE: doReplay,
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVarP(&replayFile, "trace-file", "f", "badger.trace",
+		"Access trace to replay")
+	replayCmd.Flags().BoolVar(&replayRealtime, "realtime", false,
+		"Honor the original timing between operations instead of replaying as fast as possible")
+}
+
+type replayStats struct {
+	count   int
+	errs    int
+	latency time.Duration
+}
+
+func doReplay(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(replayFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := badger.NewTraceReader(f)
+	if err != nil {
+		return err
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(sstDir).WithValueDir(vlogDir))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats := make(map[badger.TraceOp]*replayStats)
+	var val []byte
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	commit := func() error {
+		err := txn.Commit()
+		txn = db.NewTransaction(true)
+		return err
+	}
+
+	start := time.Now()
+	for {
+		rec, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if replayRealtime {
+			time.Sleep(rec.Offset - time.Since(start))
+		}
+		if int(rec.ValueSize) > len(val) {
+			val = make([]byte, rec.ValueSize)
+		}
+
+		key := replayKey(rec)
+		opStart := time.Now()
+		switch rec.Op {
+		case badger.TraceGet:
+			_, err = txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				err = nil
+			}
+		case badger.TraceSet:
+			err = txn.Set(key, val[:rec.ValueSize])
+			if err == badger.ErrTxnTooBig {
+				if err = commit(); err == nil {
+					err = txn.Set(key, val[:rec.ValueSize])
+				}
+			}
+		case badger.TraceDelete:
+			err = txn.Delete(key)
+			if err == badger.ErrTxnTooBig {
+				if err = commit(); err == nil {
+					err = txn.Delete(key)
+				}
+			}
+		case badger.TraceCommit:
+			err = commit()
+		default:
+			return fmt.Errorf("unknown operation %d in trace", rec.Op)
+		}
+
+		s, ok := stats[rec.Op]
+		if !ok {
+			s = &replayStats{}
+			stats[rec.Op] = s
+		}
+		s.count++
+		s.latency += time.Since(opStart)
+		if err != nil {
+			s.errs++
+		}
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed trace %s in %s\n", replayFile, time.Since(start).Round(time.Millisecond))
+	for _, op := range []badger.TraceOp{badger.TraceGet, badger.TraceSet, badger.TraceDelete,
+		badger.TraceCommit} {
+		s, ok := stats[op]
+		if !ok {
+			continue
+		}
+		fmt.Printf("%-7s count: %-10d errors: %-8d avg latency: %s\n", op, s.count, s.errs,
+			s.latency/time.Duration(s.count))
+	}
+	return nil
+}
+
+// replayKey synthesizes a key of the recorded size from the recorded key hash, so that
+// operations on the same key in the original trace hit the same key on replay.
+func replayKey(rec badger.TraceRecord) []byte {
+	if rec.Op == badger.TraceCommit {
+		return nil
+	}
+	var hash [8]byte
+	binary.BigEndian.PutUint64(hash[:], rec.KeyHash)
+	key := make([]byte, rec.KeySize)
+	copy(key, hash[:])
+	return key
+}
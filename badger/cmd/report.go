@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var reportOpt struct {
+	prefixLen int
+	separator string
+	keyPath   string
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report how efficiently the DB uses its storage, as JSON.",
+	Long: `
+This command reads every version of every key and every table of the DB, and prints as JSON the
+live and dead bytes of the keys under each prefix, the compression ratio of the tables, the space
+taken by encryption and the garbage in the value log. Keys are grouped by their first bytes, or up
+to the first separator when one is given.
+`,
+	RunE: doReport,
+}
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().IntVar(&reportOpt.prefixLen, "prefix-len", 1,
+		"Number of bytes of the keys to group them by.")
+	reportCmd.Flags().StringVar(&reportOpt.separator, "separator", "",
+		"Group the keys by their bytes up to and including this separator instead. Keys without it "+
+			"are grouped by their first prefix-len bytes.")
+	reportCmd.Flags().StringVarP(&reportOpt.keyPath, "encryption-key-file", "k", "",
+		"Path of the encryption key. Leave empty for a DB which isn't encrypted.")
+}
+
+// reportPrefix returns the prefix a key gets grouped by.
+func reportPrefix(key []byte) []byte {
+	if sep := []byte(reportOpt.separator); len(sep) > 0 {
+		if i := bytes.Index(key, sep); i >= 0 {
+			return key[:i+len(sep)]
+		}
+	}
+	if len(key) > reportOpt.prefixLen {
+		return key[:reportOpt.prefixLen]
+	}
+	return key
+}
+
+func doReport(cmd *cobra.Command, args []string) error {
+	if reportOpt.prefixLen < 0 {
+		return errors.New("--prefix-len can't be negative")
+	}
+	key, err := getKey(reportOpt.keyPath)
+	if err != nil {
+		return err
+	}
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithReadOnly(true).
+		WithEncryptionKey(key).
+		WithLogger(nil))
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+
+	report, err := db.StorageReport(reportPrefix)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path"
 
@@ -27,6 +28,12 @@ import (
 
 var restoreFile string
 var maxPendingWrites int
+var restoreOpt struct {
+	includePrefixes []string
+	excludePrefixes []string
+	dryRun          bool
+	keyFile         string
+}
 
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
@@ -39,7 +46,11 @@ DB.Backup() API method) and writes each key-value pair found in the file to
 the Badger database.
 
 Restore creates a new database, and currently does not work on an already
-existing database.`,
+existing database.
+
+Only part of the backup can be restored with the --include-prefix and
+--exclude-prefix flags, and --dry-run reports how much data would be restored,
+without creating the database. Encrypted backups need --backup-key-file.`,
 	RunE: doRestore,
 }
 
@@ -51,9 +62,46 @@ func init() {
 	// and overall finish time.
 	restoreCmd.Flags().IntVarP(&maxPendingWrites, "max-pending-writes", "w",
 		256, "Max number of pending writes at any time while restore")
+	restoreCmd.Flags().StringSliceVar(&restoreOpt.includePrefixes, "include-prefix", nil,
+		"Only restore the keys with one of these prefixes")
+	restoreCmd.Flags().StringSliceVar(&restoreOpt.excludePrefixes, "exclude-prefix", nil,
+		"Skip the keys with one of these prefixes")
+	restoreCmd.Flags().BoolVar(&restoreOpt.dryRun, "dry-run", false,
+		"Only report the number of keys and bytes which would be restored")
+	restoreCmd.Flags().StringVar(&restoreOpt.keyFile, "backup-key-file", "",
+		"Path of the key the backup was encrypted with. Leave empty for a plain text backup.")
 }
 
 func doRestore(cmd *cobra.Command, args []string) error {
+	var opt badger.LoadOptions
+	if restoreOpt.keyFile != "" {
+		key, err := getKey(restoreOpt.keyFile)
+		if err != nil {
+			return err
+		}
+		opt.BackupKey = key
+	}
+	for _, p := range restoreOpt.includePrefixes {
+		opt.IncludePrefixes = append(opt.IncludePrefixes, []byte(p))
+	}
+	for _, p := range restoreOpt.excludePrefixes {
+		opt.ExcludePrefixes = append(opt.ExcludePrefixes, []byte(p))
+	}
+	if restoreOpt.dryRun {
+		f, err := os.Open(restoreFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stats, err := badger.CountBackup(f, opt)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d keys, %d versions, %d bytes would be restored.\n",
+			stats.Keys, stats.Versions, stats.Bytes)
+		return nil
+	}
+
 	// Check if the DB already exists
 	manifestFile := path.Join(sstDir, badger.ManifestFilename)
 	if _, err := os.Stat(manifestFile); err == nil { // No error. File already exists.
@@ -79,5 +127,10 @@ func doRestore(cmd *cobra.Command, args []string) error {
 	defer f.Close()
 
 	// Run restore
-	return db.Load(f, maxPendingWrites)
+	stats, err := db.LoadWithOptions(f, maxPendingWrites, opt)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d keys, %d versions, %d bytes.\n", stats.Keys, stats.Versions, stats.Bytes)
+	return nil
 }
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/spf13/cobra"
+)
+
+var rotateDataKeyCmd = &cobra.Command{
+	Use:   "rotate-datakey",
+	Short: "Generate new data keys right away.",
+	Long: `
+This command generates new data keys for the files written from now on, whatever the rotation
+duration, e.g. when a data key is suspected to be compromised. With --reencrypt, the tables and
+value log files encrypted with the older data keys are rewritten with the new ones too.
+`,
+	RunE: rotateDataKey,
+}
+
+var rotateDataKeyOpt = struct {
+	keyPath   string
+	reencrypt bool
+}{}
+
+func init() {
+	RootCmd.AddCommand(rotateDataKeyCmd)
+	rotateDataKeyCmd.Flags().StringVarP(&rotateDataKeyOpt.keyPath, "encryption-key-file", "k",
+		"", "Path of the encryption key of the DB.")
+	rotateDataKeyCmd.Flags().BoolVar(&rotateDataKeyOpt.reencrypt, "reencrypt", false,
+		"Rewrite the files encrypted with the older data keys.")
+}
+
+func rotateDataKey(cmd *cobra.Command, args []string) error {
+	key, err := getKey(rotateDataKeyOpt.keyPath)
+	if err != nil {
+		return err
+	}
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithTruncate(truncate).
+		WithEncryptionKey(key).
+		WithNumCompactors(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keyID, err := db.ForceDataKeyRotation()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Generated new data keys, starting with key %d\n", keyID)
+	if !rotateDataKeyOpt.reencrypt {
+		return nil
+	}
+	err = db.ReencryptAll(context.Background(), keyID, func(p badger.ReencryptProgress) {
+		fmt.Printf("Reencrypted %d/%d tables and %d/%d value log files\n", p.TablesDone,
+			p.TablesTotal, p.ValueLogFilesDone, p.ValueLogFilesTotal)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reencrypted the files written with data keys older than key %d\n", keyID)
+	return nil
+}
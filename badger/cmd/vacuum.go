@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/spf13/cobra"
+)
+
+var vacuumCmd = &cobra.Command{
+	Use:   "vacuum",
+	Short: "Reclaim the space of the DB.",
+	Long: `
+This command runs the value log GC, flattens the LSM tree, rewrites the MANIFEST and compacts the
+key registry, one after the other.
+`,
+	RunE: vacuum,
+}
+
+var vacuumOpt = struct {
+	keyPath        string
+	discardRatio   float64
+	numWorkers     int
+	maxCompactions int
+}{}
+
+func init() {
+	RootCmd.AddCommand(vacuumCmd)
+	vacuumCmd.Flags().StringVarP(&vacuumOpt.keyPath, "encryption-key-file", "k", "",
+		"Path of the encryption key of the DB.")
+	vacuumCmd.Flags().Float64Var(&vacuumOpt.discardRatio, "discard-ratio", 0.5,
+		"Discard ratio of the value log GC. Zero skips the value log GC.")
+	vacuumCmd.Flags().IntVarP(&vacuumOpt.numWorkers, "num-workers", "w", 1,
+		"Number of concurrent compactors flattening the LSM tree. Zero skips flattening.")
+	vacuumCmd.Flags().IntVar(&vacuumOpt.maxCompactions, "max-compactions", 0,
+		"Maximum number of compactions flattening the LSM tree. Zero flattens it entirely.")
+}
+
+func vacuum(cmd *cobra.Command, args []string) error {
+	key, err := getKey(vacuumOpt.keyPath)
+	if err != nil {
+		return err
+	}
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithTruncate(truncate).
+		WithEncryptionKey(key).
+		WithNumCompactors(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Vacuum(context.Background(), badger.VacuumOptions{
+		DiscardRatio:          vacuumOpt.discardRatio,
+		FlattenWorkers:        vacuumOpt.numWorkers,
+		MaxFlattenCompactions: vacuumOpt.maxCompactions,
+		RewriteManifest:       true,
+		CompactKeyRegistry:    true,
+		Progress: func(p badger.VacuumProgress) {
+			if p.Done {
+				fmt.Printf("Done with %s: %d value log files rewritten, %d compactions\n",
+					p.Step, p.ValueLogFilesRewritten, p.Compactions)
+			}
+		},
+	})
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var verifyOpt struct {
+	deep     bool
+	readOnly bool
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the integrity of the database files.",
+	Long: `
+This command verifies the checksums of the blocks of all the SST files. With --deep, it also checks
+the SST and value log files against the SHA-256 digests recorded in the MANIFEST when they were
+written, which catches any modification of the files, including ones keeping the checksums valid.
+Only the files written with the FileDigests option have a digest.
+`,
+	RunE: doVerify,
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyOpt.deep, "deep", false,
+		"Also check the files against the digests recorded in the MANIFEST.")
+	verifyCmd.Flags().BoolVar(&verifyOpt.readOnly, "read-only", true,
+		"If set to true, DB will be opened in read only mode.")
+}
+
+func doVerify(cmd *cobra.Command, args []string) error {
+	db, err := badger.Open(badger.DefaultOptions(sstDir).
+		WithValueDir(vlogDir).
+		WithReadOnly(verifyOpt.readOnly))
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+
+	if err := db.VerifyChecksum(); err != nil {
+		return err
+	}
+	fmt.Println("Checksums of the SST files verified.")
+	if !verifyOpt.deep {
+		return nil
+	}
+	n, err := db.VerifyFiles()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Digests of %d files verified.\n", n)
+	return nil
+}
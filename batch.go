@@ -30,6 +30,7 @@ type WriteBatch struct {
 	throttle *y.Throttle
 	err      error
 	commitTs uint64
+	dedupe   bool
 }
 
 // NewWriteBatch creates a new WriteBatch. This provides a way to conveniently do a lot of writes,
@@ -45,11 +46,13 @@ func (db *DB) NewWriteBatch() *WriteBatch {
 }
 
 func (db *DB) newWriteBatch() *WriteBatch {
-	return &WriteBatch{
+	wb := &WriteBatch{
 		db:       db,
 		txn:      db.newTransaction(true, true),
 		throttle: y.NewThrottle(16),
 	}
+	wb.txn.batch = true
+	return wb
 }
 
 // SetMaxPendingTxns sets a limit on maximum number of pending transactions while writing batches.
@@ -59,6 +62,17 @@ func (wb *WriteBatch) SetMaxPendingTxns(max int) {
 	wb.throttle = y.NewThrottle(max)
 }
 
+// SetDedupe sets whether writes to a key already written in the batch replace the pending write,
+// instead of adding to it. Since only the last write of a key in a transaction is committed, the
+// batch then fills its transactions with distinct keys, and commits fewer of them for loads
+// writing the same keys repeatedly, such as upserts. A key written again after its transaction was
+// committed gets written once more. This function should be called before using WriteBatch.
+// Dedupe is disabled by default.
+func (wb *WriteBatch) SetDedupe(dedupe bool) {
+	wb.dedupe = dedupe
+	wb.txn.dedupe = dedupe
+}
+
 // Cancel function must be called if there's a chance that Flush might not get
 // called. If neither Flush or Cancel is called, the transaction oracle would
 // never get a chance to clear out the row commit timestamp map, thus causing an
@@ -144,6 +158,8 @@ func (wb *WriteBatch) commit() error {
 	wb.txn.CommitWith(wb.callback)
 	wb.txn = wb.db.newTransaction(true, true)
 	wb.txn.readTs = 0 // We're not reading anything.
+	wb.txn.batch = true
+	wb.txn.dedupe = wb.dedupe
 	wb.txn.commitTs = wb.commitTs
 	return wb.err
 }
@@ -80,3 +80,37 @@ func TestWriteBatch(t *testing.T) {
 		require.NoError(t, db.Close())
 	})
 }
+
+func TestWriteBatchDedupe(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		N := int(db.opt.maxBatchCount) * 2
+		// upsert writes 10 keys repeatedly, and returns the number of transactions committed.
+		upsert := func(dedupe bool) uint64 {
+			wb := db.NewWriteBatch()
+			defer wb.Cancel()
+			wb.SetDedupe(dedupe)
+			before := db.orc.readTs()
+			for i := 0; i < N; i++ {
+				key, val := []byte(fmt.Sprintf("key%d", i%10)), []byte(fmt.Sprintf("%d", i))
+				require.NoError(t, wb.Set(key, val))
+			}
+			require.NoError(t, wb.Flush())
+			return db.orc.readTs() - before
+		}
+		// Without dedupe, every write counts towards the transaction limits.
+		require.True(t, upsert(false) > 1)
+		// With dedupe, a single transaction holds the 10 keys.
+		require.Equal(t, uint64(1), upsert(true))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := N - 10; i < N; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("key%d", i%10)))
+				require.NoError(t, err)
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%d", i), string(val))
+			}
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// EvictionPolicy decides which keys a BoundedKeyspace evicts first.
+type EvictionPolicy int
+
+const (
+	// EvictLeastRecentlyWritten evicts the keys written the longest time ago first.
+	EvictLeastRecentlyWritten EvictionPolicy = iota
+	// EvictNearestExpiry evicts the keys closest to expiring first. Keys without a TTL come last,
+	// least recently written first.
+	EvictNearestExpiry
+)
+
+// evictionBatchSize is the number of keys deleted per transaction by BoundedKeyspace.
+const evictionBatchSize = 1000
+
+// BoundedKeyspace keeps the size of the keys under a prefix within a byte budget, deleting keys
+// once the budget is exceeded. This turns the prefix into a persistent cache of bounded size.
+type BoundedKeyspace struct {
+	db     *DB
+	prefix []byte
+	budget int64
+	policy EvictionPolicy
+	closer *y.Closer
+}
+
+// NewBoundedKeyspace returns a BoundedKeyspace for the keys under prefix. It also fires off a
+// goroutine which evicts keys every interval, until Stop is called. The size of a key is the
+// estimated size of its latest version, key and value included. Older versions and deleted keys
+// are left to compactions and value log GC to reclaim, as usual.
+//
+// Every run of the eviction iterates over all the keys under prefix, without their values.
+// BoundedKeyspace can't be used in managed mode.
+func (db *DB) NewBoundedKeyspace(prefix []byte, budget int64, policy EvictionPolicy,
+	interval time.Duration) *BoundedKeyspace {
+	bk := &BoundedKeyspace{
+		db:     db,
+		prefix: y.SafeCopy(nil, prefix),
+		budget: budget,
+		policy: policy,
+		closer: y.NewCloser(1),
+	}
+	go bk.runEvictions(interval)
+	return bk
+}
+
+func (bk *BoundedKeyspace) runEvictions(interval time.Duration) {
+	defer bk.closer.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bk.closer.HasBeenClosed():
+			return
+		case <-ticker.C:
+		}
+		if _, err := bk.Evict(); err != nil {
+			bk.db.opt.Errorf("failure while evicting keys with prefix %q: %s", bk.prefix, err)
+		}
+	}
+}
+
+// Stop stops the background evictions.
+func (bk *BoundedKeyspace) Stop() {
+	bk.closer.SignalAndWait()
+}
+
+// evictionCandidate is a key considered for eviction.
+type evictionCandidate struct {
+	key       []byte
+	size      int64
+	version   uint64
+	expiresAt uint64
+}
+
+// Evict deletes keys under the prefix until their total size is within the budget, and returns
+// the number of keys deleted. It's called periodically in the background, and can be called
+// directly to evict keys right away.
+func (bk *BoundedKeyspace) Evict() (int, error) {
+	if bk.db.opt.managedTxns {
+		return 0, errors.New("BoundedKeyspace can't be used in managed mode")
+	}
+	var candidates []evictionCandidate
+	var total int64
+	err := bk.db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.Prefix = bk.prefix
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			c := evictionCandidate{
+				key:       item.KeyCopy(nil),
+				size:      item.EstimatedSize(),
+				version:   item.Version(),
+				expiresAt: item.ExpiresAt(),
+			}
+			total += c.size
+			candidates = append(candidates, c)
+		}
+		return nil
+	})
+	if err != nil || total <= bk.budget {
+		return 0, err
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if bk.policy == EvictNearestExpiry && ci.expiresAt != cj.expiresAt {
+			if ci.expiresAt == 0 || cj.expiresAt == 0 {
+				return cj.expiresAt == 0
+			}
+			return ci.expiresAt < cj.expiresAt
+		}
+		return ci.version < cj.version
+	})
+	var victims []evictionCandidate
+	for _, c := range candidates {
+		if total <= bk.budget {
+			break
+		}
+		victims = append(victims, c)
+		total -= c.size
+	}
+
+	var evicted int
+	for len(victims) > 0 {
+		batch := victims
+		if len(batch) > evictionBatchSize {
+			batch = batch[:evictionBatchSize]
+		}
+		victims = victims[len(batch):]
+		var n int
+		err := bk.db.Update(func(txn *Txn) error {
+			n = 0
+			for _, c := range batch {
+				item, err := txn.Get(c.key)
+				if err == ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				// Leave the keys written since they were picked alone.
+				if item.Version() != c.version {
+					continue
+				}
+				if err := txn.Delete(c.key); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		switch {
+		case errors.Cause(err) == ErrConflict:
+			// Some of the keys got written to in the meantime. The next run takes care of them.
+		case err != nil:
+			return evicted, errors.Wrapf(err, "while evicting keys with prefix %q", bk.prefix)
+		default:
+			evicted += n
+		}
+	}
+	return evicted, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func boundedKeyspaceTestOptions() *Options {
+	// Keep values in the LSM tree, so that keys take exactly the size of their key and value.
+	opt := getTestOptions("").WithValueThreshold(1 << 10)
+	return &opt
+}
+
+func TestBoundedKeyspace(t *testing.T) {
+	runBadgerTest(t, boundedKeyspaceTestOptions(), func(t *testing.T, db *DB) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("cache/%04d", i)) }
+		val := make([]byte, 90)
+		// Every key takes 100 bytes.
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				e := NewEntry(key(i), val)
+				if i%2 == 0 {
+					// The TTLs of even keys decrease, the last one expires first.
+					e = e.WithTTL(time.Duration(1000-i) * time.Hour)
+				}
+				return txn.SetEntry(e)
+			}))
+		}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("other"), make([]byte, 1000))
+		}))
+		exists := func(k []byte) bool {
+			err := db.View(func(txn *Txn) error {
+				_, err := txn.Get(k)
+				return err
+			})
+			if err == ErrKeyNotFound {
+				return false
+			}
+			require.NoError(t, err)
+			return true
+		}
+
+		bk := db.NewBoundedKeyspace([]byte("cache/"), 8000, EvictLeastRecentlyWritten, time.Hour)
+		defer bk.Stop()
+		n, err := bk.Evict()
+		require.NoError(t, err)
+		require.Equal(t, 20, n)
+		for i := 0; i < 100; i++ {
+			require.Equal(t, i >= 20, exists(key(i)), "key %d", i)
+		}
+		require.True(t, exists([]byte("other")))
+		n, err = bk.Evict()
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		bk = db.NewBoundedKeyspace([]byte("cache/"), 7000, EvictNearestExpiry, time.Hour)
+		defer bk.Stop()
+		n, err = bk.Evict()
+		require.NoError(t, err)
+		require.Equal(t, 10, n)
+		for i := 20; i < 100; i++ {
+			require.Equal(t, i%2 == 1 || i < 80, exists(key(i)), "key %d", i)
+		}
+	})
+}
+
+func TestBoundedKeyspaceBackground(t *testing.T) {
+	runBadgerTest(t, boundedKeyspaceTestOptions(), func(t *testing.T, db *DB) {
+		bk := db.NewBoundedKeyspace([]byte("cache/"), 1000, EvictLeastRecentlyWritten,
+			10*time.Millisecond)
+		defer bk.Stop()
+		for i := 0; i < 50; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(fmt.Sprintf("cache/%04d", i)), make([]byte, 90))
+			}))
+		}
+		waitFor(t, func() bool {
+			var count int
+			require.NoError(t, db.View(func(txn *Txn) error {
+				opt := DefaultIteratorOptions
+				opt.Prefix = []byte("cache/")
+				it := txn.NewIterator(opt)
+				defer it.Close()
+				for it.Rewind(); it.Valid(); it.Next() {
+					count++
+				}
+				return nil
+			}))
+			return count == 10
+		})
+	})
+}
@@ -47,17 +47,17 @@ func (r keyRange) equals(dst keyRange) bool {
 		r.inf == dst.inf
 }
 
-func (r keyRange) overlapsWith(dst keyRange) bool {
+func (r keyRange) overlapsWith(dst keyRange, cmp y.Comparator) bool {
 	if r.inf || dst.inf {
 		return true
 	}
 
 	// If my left is greater than dst right, we have no overlap.
-	if y.CompareKeys(r.left, dst.right) > 0 {
+	if y.CompareKeysWith(cmp, r.left, dst.right) > 0 {
 		return false
 	}
 	// If my right is less than dst left, we have no overlap.
-	if y.CompareKeys(r.right, dst.left) < 0 {
+	if y.CompareKeysWith(cmp, r.right, dst.left) < 0 {
 		return false
 	}
 	// We have overlap.
@@ -71,10 +71,10 @@ func getKeyRange(tables ...*table.Table) keyRange {
 	smallest := tables[0].Smallest()
 	biggest := tables[0].Biggest()
 	for i := 1; i < len(tables); i++ {
-		if y.CompareKeys(tables[i].Smallest(), smallest) < 0 {
+		if tables[i].CompareKeys(tables[i].Smallest(), smallest) < 0 {
 			smallest = tables[i].Smallest()
 		}
-		if y.CompareKeys(tables[i].Biggest(), biggest) > 0 {
+		if tables[i].CompareKeys(tables[i].Biggest(), biggest) > 0 {
 			biggest = tables[i].Biggest()
 		}
 	}
@@ -100,9 +100,9 @@ func (lcs *levelCompactStatus) debug() string {
 	return b.String()
 }
 
-func (lcs *levelCompactStatus) overlapsWith(dst keyRange) bool {
+func (lcs *levelCompactStatus) overlapsWith(dst keyRange, cmp y.Comparator) bool {
 	for _, r := range lcs.ranges {
-		if r.overlapsWith(dst) {
+		if r.overlapsWith(dst, cmp) {
 			return true
 		}
 	}
@@ -126,6 +126,7 @@ func (lcs *levelCompactStatus) remove(dst keyRange) bool {
 type compactStatus struct {
 	sync.RWMutex
 	levels []*levelCompactStatus
+	cmp    y.Comparator
 }
 
 func (cs *compactStatus) toLog(tr trace.Trace) {
@@ -146,7 +147,7 @@ func (cs *compactStatus) overlapsWith(level int, this keyRange) bool {
 	defer cs.RUnlock()
 
 	thisLevel := cs.levels[level]
-	return thisLevel.overlapsWith(this)
+	return thisLevel.overlapsWith(this, cs.cmp)
 }
 
 func (cs *compactStatus) delSize(l int) int64 {
@@ -169,10 +170,10 @@ func (cs *compactStatus) compareAndAdd(_ thisAndNextLevelRLocked, cd compactDef)
 	thisLevel := cs.levels[level]
 	nextLevel := cs.levels[level+1]
 
-	if thisLevel.overlapsWith(cd.thisRange) {
+	if thisLevel.overlapsWith(cd.thisRange, cs.cmp) {
 		return false
 	}
-	if nextLevel.overlapsWith(cd.nextRange) {
+	if nextLevel.overlapsWith(cd.nextRange, cs.cmp) {
 		return false
 	}
 	// Check whether this level really needs compaction or not. Otherwise, we'll end up
@@ -186,6 +187,28 @@ func (cs *compactStatus) compareAndAdd(_ thisAndNextLevelRLocked, cd compactDef)
 	return true
 }
 
+// compareAndAddLevel marks kr as being rewritten in place on level l, unless it overlaps with a
+// range being compacted on that level.
+func (cs *compactStatus) compareAndAddLevel(_ levelHandlerRLocked, l int, kr keyRange) bool {
+	cs.Lock()
+	defer cs.Unlock()
+
+	thisLevel := cs.levels[l]
+	if thisLevel.overlapsWith(kr, cs.cmp) {
+		return false
+	}
+	thisLevel.ranges = append(thisLevel.ranges, kr)
+	return true
+}
+
+// deleteLevel removes kr added by compareAndAddLevel.
+func (cs *compactStatus) deleteLevel(l int, kr keyRange) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	y.AssertTruef(cs.levels[l].remove(kr), "keyRange %s not found at level %d", kr, l)
+}
+
 func (cs *compactStatus) delete(cd compactDef) {
 	cs.Lock()
 	defer cs.Unlock()
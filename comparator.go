@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// ComparatorFileName is the file name for the file storing the name of the comparator the DB was
+// created with. It only exists for DBs using a custom comparator.
+const ComparatorFileName = "COMPARATOR"
+
+// Comparator defines a custom order for keys. See Options.WithComparator.
+type Comparator = y.Comparator
+
+// compareKeys compares two keys without timestamps using the comparator in opt.
+func (opt *Options) compareKeys(key1, key2 []byte) int {
+	if opt.Comparator == nil {
+		return bytes.Compare(key1, key2)
+	}
+	return opt.Comparator.Compare(key1, key2)
+}
+
+// comparatorName returns the name to persist for c, the empty string standing for the default
+// byte-wise ordering.
+func comparatorName(c Comparator) string {
+	if c == nil {
+		return ""
+	}
+	return c.Name()
+}
+
+// checkComparator verifies that the comparator in opt is the one the DB in opt.Dir was created
+// with. For a new DB, it persists the name of the comparator instead. It must run before the
+// MANIFEST is created, so that a new DB never exists without its comparator file.
+func checkComparator(opt Options) error {
+	if opt.InMemory {
+		return nil
+	}
+	name := comparatorName(opt.Comparator)
+	if opt.Comparator != nil && name == "" {
+		return errors.New("Comparator name cannot be empty")
+	}
+
+	path := filepath.Join(opt.Dir, ComparatorFileName)
+	stored, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		stored = bytes.TrimSpace(stored)
+	case os.IsNotExist(err):
+		stored = nil
+	default:
+		return y.Wrapf(err, "while reading comparator file: %s", path)
+	}
+
+	isNew, err := isNewDB(opt)
+	if err != nil {
+		return err
+	}
+	if isNew && len(stored) == 0 {
+		if name == "" || opt.ReadOnly {
+			return nil
+		}
+		return writeComparatorFile(path, name)
+	}
+	if string(stored) != name {
+		return errors.Wrapf(ErrComparatorMismatch, "DB was created with %q, opened with %q",
+			comparatorDisplayName(string(stored)), comparatorDisplayName(name))
+	}
+	return nil
+}
+
+func comparatorDisplayName(name string) string {
+	if name == "" {
+		return "byte-wise"
+	}
+	return name
+}
+
+// isNewDB returns true if opt.Dir doesn't hold a MANIFEST yet.
+func isNewDB(opt Options) (bool, error) {
+	ok, err := exists(filepath.Join(opt.Dir, ManifestFilename))
+	return !ok, err
+}
+
+func writeComparatorFile(path, name string) error {
+	fp, err := y.OpenTruncFile(path, true)
+	if err != nil {
+		return y.Wrapf(err, "while creating comparator file: %s", path)
+	}
+	if _, err := fp.WriteString(name); err != nil {
+		fp.Close()
+		return y.Wrapf(err, "while writing comparator file: %s", path)
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// reverseComparator orders keys in descending byte-wise order.
+type reverseComparator struct{}
+
+func (reverseComparator) Name() string                  { return "test.reverse" }
+func (reverseComparator) Compare(key1, key2 []byte) int { return bytes.Compare(key2, key1) }
+
+func TestCustomComparator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// Keep values in the LSM tree to get several tables.
+	opt := getTestOptions(dir).WithComparator(reverseComparator{}).WithValueThreshold(1 << 10)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	const n = 5000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%06d", i)) }
+	vals := make([][]byte, n)
+	for i := range vals {
+		vals[i] = make([]byte, 100)
+		rand.Read(vals[i])
+	}
+	for i := 0; i < n; i += 10 {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for j := i; j < i+10; j++ {
+				if err := txn.Set(key(j), vals[j]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			i := n - 1
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Equal(t, key(i), it.Item().Key())
+				i--
+			}
+			require.Equal(t, -1, i)
+
+			for _, i := range []int{0, 1, 2500, n - 1} {
+				item, err := txn.Get(key(i))
+				require.NoError(t, err)
+				require.Equal(t, vals[i], getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	_, err = Open(opt.WithComparator(nil))
+	require.Equal(t, ErrComparatorMismatch, errors.Cause(err))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.True(t, len(db.Tables(false)) > 1)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestComparatorOnExistingDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(getTestOptions(dir).WithComparator(reverseComparator{}))
+	require.Equal(t, ErrComparatorMismatch, errors.Cause(err))
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/pkg/errors"
+)
+
+// writeCondition is a condition on the version of a key checked by a transaction.
+type writeCondition struct {
+	fp  uint64 // Fingerprint of the key.
+	err error  // Error to report if the key changes before the transaction commits.
+}
+
+// SetIfAbsent sets key to val only if key doesn't exist. It returns ErrKeyExists if key exists as
+// of the read timestamp of txn, and Commit returns ErrKeyExists if key gets set by another
+// transaction before txn commits.
+func (txn *Txn) SetIfAbsent(key, val []byte) error {
+	return txn.SetEntryIfAbsent(NewEntry(key, val))
+}
+
+// SetEntryIfAbsent is like SetIfAbsent, for an Entry.
+func (txn *Txn) SetEntryIfAbsent(e *Entry) error {
+	_, err := txn.Get(e.Key)
+	switch {
+	case err == nil:
+		return ErrKeyExists
+	case err != ErrKeyNotFound:
+		return err
+	}
+	return txn.setIf(e, ErrKeyExists)
+}
+
+// CompareAndSet sets key to val only if the latest version of key is version, as returned by
+// Item.Version. It returns ErrVersionMismatch if key has another version or doesn't exist as of
+// the read timestamp of txn, and Commit returns ErrVersionMismatch if key gets written by another
+// transaction before txn commits.
+func (txn *Txn) CompareAndSet(key, val []byte, version uint64) error {
+	return txn.CompareAndSetEntry(NewEntry(key, val), version)
+}
+
+// CompareAndSetEntry is like CompareAndSet, for an Entry.
+func (txn *Txn) CompareAndSetEntry(e *Entry, version uint64) error {
+	item, err := txn.Get(e.Key)
+	switch {
+	case err == ErrKeyNotFound:
+		return ErrVersionMismatch
+	case err != nil:
+		return err
+	case item.Version() != version:
+		return ErrVersionMismatch
+	}
+	return txn.setIf(e, ErrVersionMismatch)
+}
+
+// setIf sets e, recording that committing txn must fail with err if e.Key gets written by another
+// transaction in the meantime, which the oracle checks along with the conflicts of txn.
+func (txn *Txn) setIf(e *Entry, err error) error {
+	if err := txn.SetEntry(e); err != nil {
+		return err
+	}
+	txn.conditions = append(txn.conditions, writeCondition{fp: z.MemHash(e.Key), err: err})
+	return nil
+}
+
+// conflictErr returns the error txn fails to commit with because of a conflict: the error of the
+// first condition of txn broken by another transaction, ErrConflict or a *ConflictError if there's
+// none.
+func (o *oracle) conflictErr(txn *Txn) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, c := range txn.conditions {
+		if ts, has := o.commits[c.fp]; has && ts > txn.readTs {
+			return c.err
+		}
+	}
+	return o.conflictOf(txn)
+}
+
+// CAS sets key to value only if the latest version of key is expectedVersion, as returned by
+// Item.Version. It returns ErrVersionMismatch if key has another version or doesn't exist,
+// including when another transaction writes it concurrently. Unlike Txn.CompareAndSet, CAS doesn't
+// track any read: the condition is checked when the write gets its commit timestamp, see setIf.
+// CAS can only be used with managedDB=false.
+func (db *DB) CAS(key []byte, expectedVersion uint64, value []byte) error {
+	return db.setIf(NewEntry(key, value), ErrVersionMismatch, func(vs y.ValueStruct) bool {
+		return isLive(vs) && vs.Version == expectedVersion
+	})
+}
+
+// SetIfAbsent sets key to value only if key doesn't exist. It returns ErrKeyExists if key exists,
+// including when another transaction sets it concurrently. Like CAS, it doesn't track any read.
+// SetIfAbsent can only be used with managedDB=false.
+func (db *DB) SetIfAbsent(key, value []byte) error {
+	return db.setIf(NewEntry(key, value), ErrKeyExists, func(vs y.ValueStruct) bool {
+		return !isLive(vs)
+	})
+}
+
+// isLive returns true if vs, as returned by DB.get, is a live version of its key.
+func isLive(vs y.ValueStruct) bool {
+	return (vs.Value != nil || vs.Meta != 0) && !isDeletedOrExpired(vs.Meta, vs.ExpiresAt)
+}
+
+// setIf writes e if ok accepts the latest version of e.Key, and fails with err otherwise. The
+// version is looked up straight in the LSM tree at the read timestamp of the write, which then
+// only carries the condition: the oracle checks it under its lock when handing out the commit
+// timestamp, failing the write with err if e.Key got committed after the read timestamp.
+func (db *DB) setIf(e *Entry, err error, ok func(vs y.ValueStruct) bool) error {
+	if db.opt.managedTxns {
+		panic("Conditional writes of the DB can only be used with managedDB=false.")
+	}
+	txn := db.newTransaction(true, false)
+	defer txn.Discard()
+	key := e.Key
+	if err := txn.SetEntry(e); err != nil {
+		return err
+	}
+	vs, rerr := db.get(y.KeyWithTs(db.storedKey(key), txn.readTs))
+	if rerr != nil {
+		return errors.Wrapf(rerr, "DB::Get key: %q", key)
+	}
+	if !ok(vs) {
+		return err
+	}
+	txn.conditions = append(txn.conditions, writeCondition{fp: z.MemHash(e.Key), err: err})
+	return txn.Commit()
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetIfAbsent(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetIfAbsent(key, []byte("first"))
+		}))
+		require.Equal(t, ErrKeyExists, db.Update(func(txn *Txn) error {
+			return txn.SetIfAbsent(key, []byte("second"))
+		}))
+
+		// The key gets set by another transaction before commit.
+		other := []byte("other")
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.SetIfAbsent(other, []byte("a")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(other, []byte("b"))
+		}))
+		require.Equal(t, ErrKeyExists, txn.Commit())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, []byte("first"), getItemValue(t, item))
+			item, err = txn.Get(other)
+			require.NoError(t, err)
+			require.Equal(t, []byte("b"), getItemValue(t, item))
+			return nil
+		}))
+	})
+}
+
+func TestCompareAndSet(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		require.Equal(t, ErrVersionMismatch, db.Update(func(txn *Txn) error {
+			return txn.CompareAndSet(key, []byte("val"), 1)
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("v1"))
+		}))
+		var version uint64
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			version = item.Version()
+			return err
+		}))
+		require.Equal(t, ErrVersionMismatch, db.Update(func(txn *Txn) error {
+			return txn.CompareAndSet(key, []byte("val"), version-1)
+		}))
+
+		// Another transaction writes the key after the check.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.CompareAndSet(key, []byte("v2"), version))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("v3"))
+		}))
+		require.Equal(t, ErrVersionMismatch, txn.Commit())
+
+		// Conflicts on other keys are reported as such.
+		var v3 uint64
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			v3 = item.Version()
+			return err
+		}))
+		txn = db.NewTransaction(true)
+		defer txn.Discard()
+		_, err := txn.Get([]byte("read"))
+		require.Equal(t, ErrKeyNotFound, err)
+		require.NoError(t, txn.CompareAndSet(key, []byte("v4"), v3))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("read"), nil)
+		}))
+		require.Equal(t, ErrConflict, txn.Commit())
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.CompareAndSet(key, []byte("v4"), v3)
+		}))
+	})
+}
+
+func TestDBConditionalWrites(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		require.Equal(t, ErrVersionMismatch, db.CAS(key, 1, []byte("v0")))
+		require.NoError(t, db.SetIfAbsent(key, []byte("v1")))
+		require.Equal(t, ErrKeyExists, db.SetIfAbsent(key, []byte("v2")))
+
+		version := func() uint64 {
+			var version uint64
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				version = item.Version()
+				return err
+			}))
+			return version
+		}
+		v1 := version()
+		require.NoError(t, db.CAS(key, v1, []byte("v2")))
+		require.Equal(t, ErrVersionMismatch, db.CAS(key, v1, []byte("v3")))
+		require.NoError(t, db.CAS(key, version(), []byte("v3")))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, []byte("v3"), getItemValue(t, item))
+			return nil
+		}))
+
+		// A deleted key is absent.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete(key)
+		}))
+		require.NoError(t, db.SetIfAbsent(key, []byte("v4")))
+
+		// Only one of concurrent writes of a new key succeeds.
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- db.SetIfAbsent([]byte("new"), []byte(fmt.Sprint(i)))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		var written int
+		for err := range errs {
+			if err == nil {
+				written++
+				continue
+			}
+			require.Equal(t, ErrKeyExists, err)
+		}
+		require.Equal(t, 1, written)
+
+		opt := getTestOptions("")
+		opt.managedTxns = true
+		require.Panics(t, func() {
+			_ = (&DB{opt: opt}).CAS(key, 1, nil)
+		})
+	})
+}
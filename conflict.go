@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// ConflictError is the error of the transactions failing with ErrConflict when
+// Options.ConflictDiagnostics is set, telling which key caused the conflict. Its cause, as given by
+// errors.Cause, is ErrConflict, so the conflicts have to be told apart with errors.Cause or
+// errors.Is rather than by comparing the error with ErrConflict.
+type ConflictError struct {
+	// Key is a key read by the transaction, or written by it with SnapshotIsolation, and written
+	// by another transaction which committed after the transaction started.
+	Key []byte
+	// Fingerprint is the fingerprint of Key the conflicts get detected with.
+	Fingerprint uint64
+	// ReadTs is the read timestamp of the transaction, and CommitTs the commit timestamp of the
+	// write of Key conflicting with it.
+	ReadTs, CommitTs uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: key %q read at %d was written at %d",
+		ErrConflict, e.Key, e.ReadTs, e.CommitTs)
+}
+
+// Cause returns ErrConflict.
+func (e *ConflictError) Cause() error {
+	return ErrConflict
+}
+
+// Unwrap returns ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// recordReadKey records the key read with fingerprint fp for ConflictError, with
+// Options.ConflictDiagnostics.
+func (txn *Txn) recordReadKey(fp uint64, key []byte) {
+	if !txn.db.opt.ConflictDiagnostics {
+		return
+	}
+	if txn.readKeys == nil {
+		txn.readKeys = make(map[uint64][]byte)
+	}
+	if _, ok := txn.readKeys[fp]; !ok {
+		txn.readKeys[fp] = y.SafeCopy(nil, key)
+	}
+}
+
+// conflictOf returns the error of txn failing with a conflict on one of the keys it read: a
+// *ConflictError with Options.ConflictDiagnostics, ErrConflict otherwise. It must be called while
+// having the lock.
+func (o *oracle) conflictOf(txn *Txn) error {
+	if !txn.db.opt.ConflictDiagnostics {
+		return ErrConflict
+	}
+	for _, fp := range txn.conflictKeys() {
+		if ts, has := o.commits[fp]; has && ts > txn.readTs {
+			return &ConflictError{
+				Key:         txn.conflictKey(fp),
+				Fingerprint: fp,
+				ReadTs:      txn.readTs,
+				CommitTs:    ts,
+			}
+		}
+	}
+	return ErrConflict
+}
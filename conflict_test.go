@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictDiagnostics(t *testing.T) {
+	conflict := func(db *DB) error {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		for _, k := range []string{"a", "b"} {
+			_, err := txn.Get([]byte(k))
+			require.Equal(t, ErrKeyNotFound, err)
+		}
+		require.NoError(t, txn.Set([]byte("c"), []byte("c")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("b"), []byte("b"))
+		}))
+		return txn.Commit()
+	}
+
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.Equal(t, ErrConflict, conflict(db))
+	})
+
+	opt := getTestOptions("").WithConflictDiagnostics(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		err := conflict(db)
+		require.Equal(t, ErrConflict, errors.Cause(err))
+		cerr, ok := err.(*ConflictError)
+		require.True(t, ok)
+		require.Equal(t, []byte("b"), cerr.Key)
+		require.Equal(t, cerr.ReadTs+1, cerr.CommitTs)
+		require.Contains(t, err.Error(), `key "b"`)
+	})
+}
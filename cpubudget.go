@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"runtime"
+)
+
+// cpuBudget limits the number of goroutines doing compaction work at a time, and lowers the
+// scheduling priority of their threads.
+type cpuBudget struct {
+	tokens   chan struct{} // Nil if the number of goroutines isn't limited.
+	niceness int
+	log      Logger
+}
+
+func newCPUBudget(opt Options) *cpuBudget {
+	b := &cpuBudget{niceness: opt.CompactionNiceness, log: opt.Logger}
+	if opt.CompactionCPUs > 0 {
+		// Leave a CPU to the foreground work whenever there's more than one.
+		n := opt.CompactionCPUs
+		if max := runtime.GOMAXPROCS(0) - 1; n > max {
+			n = max
+		}
+		if n < 1 {
+			n = 1
+		}
+		b.tokens = make(chan struct{}, n)
+	}
+	return b
+}
+
+// acquire blocks until the calling goroutine may use a CPU.
+func (b *cpuBudget) acquire() {
+	if b.tokens != nil {
+		b.tokens <- struct{}{}
+	}
+}
+
+// release gives back the CPU taken by acquire.
+func (b *cpuBudget) release() {
+	if b.tokens != nil {
+		<-b.tokens
+	}
+}
+
+// lowerPriority locks the calling goroutine to its thread and renices the thread. The priority
+// of a thread can't be raised back without privileges, so the thread exits along with the
+// goroutine. lowerPriority must only be called by goroutines started by Badger.
+func (b *cpuBudget) lowerPriority() {
+	if b.niceness == 0 {
+		return
+	}
+	runtime.LockOSThread()
+	if err := setThreadNiceness(b.niceness); err != nil && b.log != nil {
+		b.log.Warningf("Unable to set the niceness of a compaction thread: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUBudgetSize(t *testing.T) {
+	opt := DefaultOptions("")
+	require.Nil(t, newCPUBudget(opt).tokens)
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	require.Equal(t, 2, cap(newCPUBudget(opt.WithCompactionCPUs(2)).tokens))
+	require.Equal(t, 3, cap(newCPUBudget(opt.WithCompactionCPUs(8)).tokens))
+	runtime.GOMAXPROCS(1)
+	require.Equal(t, 1, cap(newCPUBudget(opt.WithCompactionCPUs(2)).tokens))
+}
+
+func TestCompactionCPUs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithCompactionCPUs(1).WithCompactionNiceness(10)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	for i := 0; i < 5000; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%05d", i)), make([]byte, 64), 0)
+	}
+	require.NoError(t, db.Flatten(2))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 5000; i += 97 {
+			if _, err := txn.Get([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Empty(t, db.compactionCPU.tokens)
+}
@@ -21,7 +21,9 @@ import (
 	"context"
 	"encoding/binary"
 	"expvar"
+	"fmt"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
@@ -36,17 +38,19 @@ import (
 	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 	"golang.org/x/net/trace"
 )
 
 var (
-	badgerPrefix      = []byte("!badger!")        // Prefix for internal keys used by badger.
+	badgerPrefix      = []byte(InternalKeyPrefix) // Prefix for internal keys used by badger.
 	head              = []byte("!badger!head")    // For storing value offset for replay.
 	txnKey            = []byte("!badger!txn")     // For indicating end of entries in txn.
 	badgerMove        = []byte("!badger!move")    // For key-value pairs which got moved during GC.
 	lfDiscardStatsKey = []byte("!badger!discard") // For storing lfDiscardStats
+	badgerAlias       = []byte("!badger!alias")   // For values shared by renamed keys.
 )
 
 type closers struct {
@@ -56,6 +60,7 @@ type closers struct {
 	writes     *y.Closer
 	valueGC    *y.Closer
 	pub        *y.Closer
+	plaintext  *y.Closer // nil unless files written in plain text are being encrypted.
 }
 
 // DB provides the various functions required to interact with Badger.
@@ -71,6 +76,8 @@ type DB struct {
 	elog      trace.EventLog
 	mt        *skl.Skiplist   // Our latest (actively written) in-memory table
 	imm       []*skl.Skiplist // Add here only AFTER pushing to flushChan.
+	// immFlushed is closed and replaced every time the flusher removes a memtable from imm.
+	immFlushed chan struct{}
 	opt       Options
 	manifest  *manifestFile
 	lc        *levelsController
@@ -89,9 +96,35 @@ type DB struct {
 
 	orc *oracle
 
-	pub        *publisher
-	registry   *KeyRegistry
-	blockCache *ristretto.Cache
+	pub           *publisher
+	registry      *KeyRegistry
+	blockCache    *ristretto.Cache
+	recorder      *accessRecorder // nil unless opt.AccessTracePath is set.
+	versions      *versionTracker
+	negCache      *negativeCache // nil unless opt.NegativeCacheSize is set.
+	ops           *opLog         // nil if opt.OpLogSize is 0.
+	jobs          *jobTimeline   // nil if opt.JobTimelineSize is 0.
+	snapshots     *snapshotTags
+	retention     *versionRetention
+	transformers  valueTransformers
+	limits        prefixLimits
+	hasher        *keyHasher   // nil unless opt.KeyHashSecret is set.
+	tableEvents   *tableEvents // nil unless opt.TableListener is set.
+	freezer       freezer
+	compactionCPU *cpuBudget
+	// compactionWrites paces the table writes of compactions. Nil unless opt.Runtime limits them.
+	compactionWrites *rateLimiter
+	// dropPending holds the tables a canceled DropAll removed from the LSM tree without deleting
+	// them. Guarded by the lock.
+	dropPending []*table.Table
+	plaintext   plaintextEncryption
+	keyLocks    keyLocks
+	newKeys     newKeys
+	noBloom     noBloomKeys
+	// rangeDigests caches the digests of the tables for RangeDigest.
+	rangeDigests tableRangeDigests
+	// tablesSize is the size of the tables in the LSM tree, see diskSize. Atomic.
+	tablesSize int64
 }
 
 const (
@@ -102,12 +135,16 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 	type txnEntry struct {
 		nk []byte
 		v  y.ValueStruct
+		re *ReplayedEntry // nil unless opt.ReplayFilter is set.
 	}
 
 	var txn []txnEntry
 	var lastCommit uint64
 
-	toLSM := func(nk []byte, vs y.ValueStruct) {
+	toLSM := func(nk []byte, vs y.ValueStruct, re *ReplayedEntry) {
+		if re != nil && !db.opt.ReplayFilter(*re) {
+			return
+		}
 		for err := db.ensureRoomForWrite(); err != nil; err = db.ensureRoomForWrite() {
 			db.elog.Printf("Replay: Making room for writes")
 			time.Sleep(10 * time.Millisecond)
@@ -145,6 +182,10 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 			UserMeta:  e.UserMeta,
 			ExpiresAt: e.ExpiresAt,
 		}
+		var re *ReplayedEntry
+		if db.opt.ReplayFilter != nil && e.meta&bitFinTxn == 0 {
+			re = newReplayedEntry(e, vp)
+		}
 
 		if e.meta&bitFinTxn > 0 {
 			txnTs, err := strconv.ParseUint(string(e.Value), 10, 64)
@@ -155,7 +196,7 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 			y.AssertTrue(len(txn) > 0)
 			// Got the end of txn. Now we can store them.
 			for _, t := range txn {
-				toLSM(t.nk, t.v)
+				toLSM(t.nk, t.v, t.re)
 			}
 			txn = txn[:0]
 			lastCommit = 0
@@ -171,12 +212,12 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 				txn = txn[:0]
 				lastCommit = txnTs
 			}
-			te := txnEntry{nk: nk, v: v}
+			te := txnEntry{nk: nk, v: v, re: re}
 			txn = append(txn, te)
 
 		} else {
 			// This entry is from a rewrite.
-			toLSM(nk, v)
+			toLSM(nk, v, re)
 
 			// We shouldn't get this entry in the middle of a transaction.
 			y.AssertTrue(lastCommit == 0)
@@ -206,6 +247,17 @@ func Open(opt Options) (db *DB, err error) {
 		return nil, errors.Errorf("Valuethreshold greater than max batch size of %d. Either "+
 			"reduce opt.ValueThreshold or increase opt.MaxTableSize.", opt.maxBatchSize)
 	}
+	if opt.MaxKeySize <= 0 || opt.MaxKeySize > maxKeySizeLimit {
+		return nil, errors.Errorf("Invalid MaxKeySize, must be between 1 and %d", maxKeySizeLimit)
+	}
+	if opt.MaxKeySize > defaultMaxKeySize {
+		opt.Warningf("MaxKeySize is %d. Keys of 64KB or more slow down reads and compactions, "+
+			"and older versions of Badger can't open the tables holding them.", opt.MaxKeySize)
+	}
+	if opt.TableChecksumAlgorithm > options.NoChecksum ||
+		opt.ValueLogChecksumAlgorithm > options.NoChecksum {
+		return nil, errors.New("Invalid checksum algorithm")
+	}
 	if !(opt.ValueLogFileSize <= 2<<30 && opt.ValueLogFileSize >= 1<<20) {
 		return nil, ErrValueLogSize
 	}
@@ -214,6 +266,22 @@ func Open(opt Options) (db *DB, err error) {
 		return nil, ErrInvalidLoadingMode
 	}
 
+	if opt.SingleVersion {
+		opt.NumVersionsToKeep = 1
+	}
+	for _, w := range opt.MaintenanceWindows {
+		if err := w.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(opt.KeyHashSecret) > 0 && opt.Comparator != nil {
+		return nil, errors.New("KeyHashSecret can't be used with a Comparator")
+	}
+	opt.counters = y.NewCounters(opt.MaxLevels)
+	if opt.InstanceLabel != "" && opt.Logger != nil {
+		opt.Logger = &labeledLogger{Logger: opt.Logger, prefix: "[" + opt.InstanceLabel + "] "}
+	}
+
 	// Compact L0 on close if either it is set or if KeepL0InMemory is set. When
 	// keepL0InMemory is set we need to compact L0 on close otherwise we might lose data.
 	opt.CompactL0OnClose = opt.CompactL0OnClose || opt.KeepL0InMemory
@@ -263,38 +331,29 @@ func Open(opt Options) (db *DB, err error) {
 		}
 	}
 
-	manifestFile, manifest, err := openOrCreateManifestFile(opt)
-	if err != nil {
+	if err := checkComparator(opt); err != nil {
 		return nil, err
 	}
-	defer func() {
-		if manifestFile != nil {
-			_ = manifestFile.close()
-		}
-	}()
-
 	elog := y.NoEventLog
 	if opt.EventLogging {
-		elog = trace.NewEventLog("Badger", "DB")
+		elog = trace.NewEventLog("Badger", opt.traceTitle("DB"))
 	}
 
-	config := ristretto.Config{
-		// Use 5% of cache memory for storing counters.
-		NumCounters: int64(float64(opt.MaxCacheSize) * 0.05 * 2),
-		MaxCost:     int64(float64(opt.MaxCacheSize) * 0.95),
-		BufferItems: 64,
-		Metrics:     true,
-	}
-	cache, err := ristretto.NewCache(&config)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cache")
+	var cache *ristretto.Cache
+	if opt.Runtime != nil {
+		cache = opt.Runtime.blockCache
+		// The table IDs of the DBs sharing the cache overlap, so the keys of their blocks get
+		// mixed with an ID of the DB.
+		opt.cacheID = rand.Uint64()
+	} else if cache, err = newBlockCache(opt.MaxCacheSize); err != nil {
+		return nil, err
 	}
 	db = &DB{
 		imm:           make([]*skl.Skiplist, 0, opt.NumMemtables),
+		immFlushed:    make(chan struct{}),
 		flushChan:     make(chan flushTask, opt.NumMemtables),
 		writeCh:       make(chan *request, kvWriteChCapacity),
 		opt:           opt,
-		manifest:      manifestFile,
 		elog:          elog,
 		dirLockGuard:  dirLockGuard,
 		valueDirGuard: valueDirLockGuard,
@@ -307,22 +366,79 @@ func Open(opt Options) (db *DB, err error) {
 		db.opt.SyncWrites = false
 		db.opt.ValueThreshold = maxValueThreshold
 	}
+	db.versions = newVersionTracker(&db.opt)
+	db.negCache = newNegativeCache(opt.NegativeCacheSize)
+	db.ops = newOpLog(opt.OpLogSize)
+	db.jobs = newJobTimeline(opt.JobTimelineSize)
 	krOpt := KeyRegistryOptions{
 		ReadOnly:                      opt.ReadOnly,
 		Dir:                           opt.Dir,
 		EncryptionKey:                 opt.EncryptionKey,
 		EncryptionKeyRotationDuration: opt.EncryptionKeyRotationDuration,
 		InMemory:                      opt.InMemory,
+		KeyProvider:                   opt.KeyProvider,
+		KeyWrapper:                    opt.KeyWrapper,
+		EncryptionPassphrase:          opt.EncryptionPassphrase,
+		EncryptionAlgorithm:           opt.EncryptionAlgorithm,
+
+		ValueLogEncryptionKeyRotationDuration: opt.ValueLogEncryptionKeyRotationDuration,
+		DisableValueLogEncryption:             opt.DisableValueLogEncryption,
+		DisableTableEncryption:                opt.DisableTableEncryption,
+		EncryptionKeyPrefixes:                 opt.EncryptionKeyPrefixes,
+		LockKeyMemory:                         opt.LockKeyMemory,
+		SecondaryEncryptionKeys:               opt.SecondaryEncryptionKeys,
+		FIPSMode:                              opt.FIPSMode,
+		KeyAuditLog:                           opt.KeyAuditLog,
+	}
+
+	if opt.EncryptPlaintextFiles && db.shouldEncrypt() && !opt.ReadOnly && !opt.InMemory {
+		if err := encryptKeyRegistry(krOpt); err != nil {
+			return nil, err
+		}
 	}
-
 	if db.registry, err = OpenKeyRegistry(krOpt); err != nil {
 		return nil, err
 	}
+	// The MANIFEST is encrypted with a data key of the registry.
+	manifestFile, manifest, err := openOrCreateManifestFile(opt, db.registry)
+	if err != nil {
+		_ = db.registry.Close()
+		return nil, err
+	}
+	defer func() {
+		if manifestFile != nil {
+			_ = manifestFile.close()
+		}
+	}()
+	db.manifest = manifestFile
+	if db.snapshots, err = openSnapshotTags(opt.Dir); err != nil {
+		return nil, err
+	}
+	if db.retention, err = openVersionRetention(opt); err != nil {
+		return nil, err
+	}
+	db.transformers = newValueTransformers(opt.ValueTransformers)
+	db.limits = newPrefixLimits(opt.PrefixLimits)
+	if db.hasher, err = newKeyHasher(opt.KeyHashSecret); err != nil {
+		return nil, err
+	}
+	db.pub.decode = db.decodeValue
+	db.compactionCPU = newCPUBudget(opt)
+	if opt.Runtime != nil {
+		db.compactionCPU.tokens = opt.Runtime.compactorCPU
+		db.compactionWrites = opt.Runtime.writeLimiter
+	}
+	if opt.AccessTracePath != "" {
+		if db.recorder, err = openAccessRecorder(opt.AccessTracePath); err != nil {
+			return nil, err
+		}
+	}
 	db.calculateSize()
 	db.closers.updateSize = y.NewCloser(1)
 	go db.updateSize(db.closers.updateSize)
-	db.mt = skl.NewSkiplist(arenaSize(opt))
+	db.mt = newSkiplist(opt)
 
+	db.tableEvents = newTableEvents(opt.TableListener)
 	// newLevelsController potentially loads files in directory.
 	if db.lc, err = newLevelsController(db, &manifest); err != nil {
 		return nil, err
@@ -339,7 +455,7 @@ func Open(opt Options) (db *DB, err error) {
 	}
 
 	headKey := y.KeyWithTs(head, math.MaxUint64)
-	// Need to pass with timestamp, lsm get removes the last 8 bytes and compares key
+	// Need to pass with timestamp, lsm get removes the timestamp suffix and compares key
 	vs, err := db.get(headKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "Retrieving head")
@@ -365,6 +481,7 @@ func Open(opt Options) (db *DB, err error) {
 	// compaction when run in offline mode via the flatten tool.
 	db.orc.readMark.Done(db.orc.nextTxnTs)
 	db.orc.incrementNextTs()
+	db.retention.recordTs(db.orc.nextTs() - 1)
 
 	db.writeCh = make(chan *request, kvWriteChCapacity)
 	db.closers.writes = y.NewCloser(1)
@@ -378,13 +495,27 @@ func Open(opt Options) (db *DB, err error) {
 	db.closers.pub = y.NewCloser(1)
 	go db.pub.listenForUpdates(db.closers.pub)
 
+	if opt.EncryptPlaintextFiles && db.shouldEncrypt() && !opt.ReadOnly && !opt.InMemory {
+		db.startPlaintextEncryption()
+	}
+
+	if opt.Runtime != nil {
+		atomic.AddInt32(&opt.Runtime.numDBs, 1)
+	}
+	registerInstance(db)
 	valueDirLockGuard = nil
 	dirLockGuard = nil
 	manifestFile = nil
+
+	if err := db.checkReservedKeys(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
-// CacheMetrics returns the metrics for the underlying cache.
+// CacheMetrics returns the metrics for the underlying cache. The cache of a Runtime is shared by
+// all its DBs.
 func (db *DB) CacheMetrics() *ristretto.Metrics {
 	return db.blockCache.Metrics
 }
@@ -401,6 +532,17 @@ func (db *DB) Close() error {
 
 func (db *DB) close() (err error) {
 	db.elog.Printf("Closing database")
+	unregisterInstance(db)
+
+	// Resume the work paused by Freeze, so that it can be stopped.
+	if thawErr := db.Thaw(); thawErr != nil && thawErr != ErrNotFrozen {
+		err = errors.Wrap(thawErr, "DB.Close")
+	}
+
+	// Stop rewriting the files written in plain text, which writes to the value log.
+	if db.closers.plaintext != nil {
+		db.closers.plaintext.SignalAndWait()
+	}
 
 	atomic.StoreInt32(&db.blockWrites, 1)
 
@@ -471,13 +613,30 @@ func (db *DB) close() (err error) {
 		}
 	}
 
+	db.tableEvents.close()
 	if lcErr := db.lc.close(); err == nil {
 		err = errors.Wrap(lcErr, "DB.Close")
 	}
+	// Finish deleting the tables left by a canceled DropAll.
+	for _, t := range db.dropPending {
+		if tErr := t.DecrRef(); err == nil {
+			err = errors.Wrap(tErr, "DB.Close")
+		}
+	}
 	db.elog.Printf("Waiting for closer")
 	db.closers.updateSize.SignalAndWait()
 	db.orc.Stop()
-	db.blockCache.Close()
+	if db.opt.Runtime != nil {
+		atomic.AddInt32(&db.opt.Runtime.numDBs, -1)
+	} else {
+		db.blockCache.Close()
+	}
+	if recErr := db.recorder.close(); err == nil {
+		err = errors.Wrap(recErr, "DB.Close")
+	}
+	if retErr := db.retention.close(); err == nil {
+		err = errors.Wrap(retErr, "DB.Close")
+	}
 
 	db.elog.Finish()
 	if db.opt.InMemory {
@@ -573,7 +732,11 @@ func (db *DB) getMemTables() ([]*skl.Skiplist, func()) {
 func (db *DB) get(key []byte) (y.ValueStruct, error) {
 	tables, decr := db.getMemTables() // Lock should be released.
 	defer decr()
+	return db.getFrom(tables, key)
+}
 
+// getFrom is get, given the memtables.
+func (db *DB) getFrom(tables []*skl.Skiplist, key []byte) (y.ValueStruct, error) {
 	var maxVs *y.ValueStruct
 	var version uint64
 	if bytes.HasPrefix(key, badgerMove) {
@@ -584,10 +747,10 @@ func (db *DB) get(key []byte) (y.ValueStruct, error) {
 		version = y.ParseTs(key)
 	}
 
-	y.NumGets.Add(1)
+	db.opt.counters.AddGet()
 	for i := 0; i < len(tables); i++ {
 		vs := tables[i].Get(key)
-		y.NumMemtableGets.Add(1)
+		db.opt.counters.AddMemtableGet()
 		if vs.Meta == 0 && vs.Value == nil {
 			continue
 		}
@@ -629,7 +792,8 @@ var requestPool = sync.Pool{
 }
 
 func (db *DB) shouldWriteValueToLSM(e Entry) bool {
-	return len(e.Value) < db.opt.ValueThreshold
+	// Entries written by Txn.Rename hold a pointer to a value in the value log already.
+	return len(e.Value) < db.opt.ValueThreshold || e.meta&bitValuePointer > 0
 }
 
 func (db *DB) writeToLSM(b *request) error {
@@ -644,6 +808,9 @@ func (db *DB) writeToLSM(b *request) error {
 		if entry.meta&bitFinTxn != 0 {
 			continue
 		}
+		if entry.noBloom {
+			db.noBloom.add(db.mt, entry.Key)
+		}
 		if db.shouldWriteValueToLSM(*entry) { // Will include deletion / tombstone case.
 			db.mt.Put(entry.Key,
 				y.ValueStruct{
@@ -661,6 +828,9 @@ func (db *DB) writeToLSM(b *request) error {
 					ExpiresAt: entry.ExpiresAt,
 				})
 		}
+		// Invalidate only once the entry is in the memtable, so that a miss recorded concurrently,
+		// which could have looked the key up before the Put, doesn't make it to the cache.
+		db.negCache.invalidate(y.ParseKey(entry.Key))
 	}
 	return nil
 }
@@ -740,7 +910,7 @@ func (db *DB) sendToWriteCh(entries []*Entry) (*request, error) {
 	req.Wg.Add(1)
 	req.IncrRef()     // for db write
 	db.writeCh <- req // Handled in doWrites.
-	y.NumPuts.Add(int64(len(entries)))
+	db.opt.counters.AddPuts(int64(len(entries)))
 
 	return req, nil
 }
@@ -880,7 +1050,7 @@ func (db *DB) ensureRoomForWrite() error {
 			db.mt.MemSize(), len(db.flushChan))
 		// We manage to push this task. Let's modify imm.
 		db.imm = append(db.imm, db.mt)
-		db.mt = skl.NewSkiplist(arenaSize(db.opt))
+		db.mt = newSkiplist(db.opt)
 		// New memtable is empty. We certainly have room.
 		return nil
 	default:
@@ -889,34 +1059,280 @@ func (db *DB) ensureRoomForWrite() error {
 	}
 }
 
+// FlushMemtable flushes the active memtable to L0 and returns once it, and every memtable
+// waiting to be flushed before it, is durably written to disk, along with the MANIFEST and the
+// value log it points to. Writes aren't blocked in the meantime, they go to a new memtable. This is
+// useful to get a known LSM state before taking a checkpoint, or before spawning a read-only
+// process on the same directory. If ctx is done first, its error is returned, but the memtable
+// still gets flushed in the background.
+//
+// With KeepL0InMemory, the L0 tables aren't written to disk, so FlushMemtable compacts L0 into L1
+// once the memtable is part of it. FlushMemtable returns ErrFrozen while the DB is frozen.
+func (db *DB) FlushMemtable(ctx context.Context) error {
+	if db.opt.ReadOnly {
+		return errors.New("FlushMemtable cannot be called in read-only mode")
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// Push the active memtable to the flusher. Like ensureRoomForWrite, we can't block on
+	// flushChan while holding the lock, since the flusher needs it to advance db.imm.
+	var mt *skl.Skiplist
+	for {
+		pushed, flushed, err := db.pushMemtable()
+		if err != nil {
+			return err
+		}
+		if mt = pushed; mt != nil {
+			break
+		}
+		// flushChan is full, it has room again once the flusher is done with a memtable.
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// db.imm is flushed in order, so mt is part of L0 once it's no longer part of db.imm.
+	for {
+		db.RLock()
+		pending := false
+		for _, imm := range db.imm {
+			if imm == mt {
+				pending = true
+				break
+			}
+		}
+		flushed := db.immFlushed
+		db.RUnlock()
+		if !pending {
+			break
+		}
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !db.opt.KeepL0InMemory {
+		return nil
+	}
+	return errors.Wrap(db.lc.compactLevelRange(0, infRange), "while persisting L0 in FlushMemtable")
+}
+
+// pushMemtable sends the active memtable to the flusher, and returns it. If it's empty, the last
+// memtable waiting to be flushed is returned instead, or a new empty one if there is none. It
+// returns nil if flushChan is full, along with db.immFlushed to wait for room.
+func (db *DB) pushMemtable() (*skl.Skiplist, <-chan struct{}, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.mt == nil || atomic.LoadInt32(&db.blockWrites) == 1 {
+		// The DB is being closed, or flushChan is about to be closed by prepareToDrop.
+		return nil, nil, ErrBlockedWrites
+	}
+	if db.mt.Empty() {
+		if n := len(db.imm); n > 0 {
+			return db.imm[n-1], nil, nil
+		}
+		return db.mt, nil, nil
+	}
+	select {
+	case db.flushChan <- flushTask{mt: db.mt, vptr: db.vhead}:
+		atomic.StoreInt32(&db.logRotates, 0)
+		if err := db.vlog.sync(db.vhead.Fid); err != nil {
+			return nil, nil, err
+		}
+		mt := db.mt
+		db.imm = append(db.imm, mt)
+		db.mt = newSkiplist(db.opt)
+		return mt, nil, nil
+	default:
+		return nil, db.immFlushed, nil
+	}
+}
+
 func arenaSize(opt Options) int64 {
 	return opt.MaxTableSize + opt.maxBatchSize + opt.maxBatchCount*int64(skl.MaxNodeSize)
 }
 
-// buildL0Table builds a new table from the memtable.
-func buildL0Table(ft flushTask, bopts table.Options) []byte {
+// newSkiplist returns a new memtable sized and ordered as configured in opt.
+func newSkiplist(opt Options) *skl.Skiplist {
+	return skl.NewSkiplistWithComparator(arenaSize(opt), opt.Comparator)
+}
+
+// buildL0Table builds new tables from the memtable, in the order they have to be added to level 0.
+// The short-lived entries go to a table of their own, the entries which mustn't be indexed in bloom
+// filters to another one, and so do the keys of every prefix having its own data keys. The last
+// table holds the other entries, including the head pointer.
+func buildL0Table(ft flushTask, bopts table.Options) ([]l0Table, error) {
 	iter := ft.mt.NewIterator()
 	defer iter.Close()
 	b := table.NewTableBuilder(bopts)
 	defer b.Close()
+	var slb, nbb *table.Builder
+	defer func() {
+		if slb != nil {
+			slb.Close()
+		}
+		if nbb != nil {
+			nbb.Close()
+		}
+	}()
+	// The keys of a prefix having its own data keys are contiguous, and go to a table of their
+	// own, built by sb.
+	var tables []l0Table
+	var sb *table.Builder
+	var scope []byte
+	var sopts table.Options
+	finishScoped := func() {
+		if sb != nil {
+			tables = append(tables, l0Table{data: sb.Finish(), opts: sopts})
+			sb.Close()
+		}
+		sb, scope = nil, nil
+	}
 	var vp valuePointer
+	var lastKey []byte
+	var keptBelowDiscardTs, retained bool
+	vc := versionCounter{vt: ft.versions}
+	defer vc.done()
 	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
 		if len(ft.dropPrefix) > 0 && bytes.HasPrefix(iter.Key(), ft.dropPrefix) {
 			continue
 		}
+		vc.add(iter.Key())
 		vs := iter.Value()
 		if vs.Meta&bitValuePointer > 0 {
 			vp.Decode(vs.Value)
 		}
+		if ft.singleVersion {
+			if !y.SameKey(iter.Key(), lastKey) {
+				lastKey = y.SafeCopy(lastKey, iter.Key())
+				keptBelowDiscardTs = false
+				// Keys with a retention policy are left to compactions.
+				retained = ft.retention.policyFor(y.ParseKey(iter.Key())) != nil
+			}
+			// Versions above discardTs might still be read by running transactions. Below it,
+			// only the latest version is visible, even if it's a delete marker, which we have to
+			// keep to shadow the versions in the lower levels.
+			if y.ParseTs(iter.Key()) <= ft.discardTs && vs.Meta&bitMergeEntry == 0 && !retained {
+				if keptBelowDiscardTs {
+					if vs.Meta&bitValuePointer > 0 {
+						ft.discardStats[vp.Fid] += int64(vp.Len)
+					}
+					continue
+				}
+				// The version deleted by a soft delete marker is kept until it can't be
+				// undeleted anymore.
+				keptBelowDiscardTs = !isSoftDeleted(vs.Meta, vs.ExpiresAt)
+			}
+		}
+		if s := ft.registry.keyScope(y.ParseKey(iter.Key())); s != nil {
+			if !bytes.Equal(s, scope) {
+				finishScoped()
+				dk, err := ft.registry.latestDataKey(s)
+				if err != nil {
+					return nil, err
+				}
+				sopts = bopts
+				sopts.DataKey = dk
+				sb, scope = table.NewTableBuilder(sopts), s
+			}
+			// Short-lived and unindexed entries aren't separated from the others of the prefix.
+			sb.Add(iter.Key(), vs, vp.Len)
+			continue
+		}
+		if _, ok := ft.noBloom[z.MemHash(iter.Key())]; ok {
+			if nbb == nil {
+				nbOpts := bopts
+				nbOpts.NoBloom = true
+				nbb = table.NewTableBuilder(nbOpts)
+			}
+			nbb.Add(iter.Key(), vs, vp.Len)
+			continue
+		}
+		if vs.Meta&bitShortLived > 0 {
+			if slb == nil {
+				slb = table.NewTableBuilder(bopts)
+			}
+			slb.Add(iter.Key(), vs, vp.Len)
+			continue
+		}
 		b.Add(iter.Key(), iter.Value(), vp.Len)
 	}
-	return b.Finish()
+	finishScoped()
+	// Add the separated entries before the table holding the head pointer, which marks the
+	// memtable as flushed.
+	if slb != nil {
+		tables = append(tables, l0Table{data: slb.Finish(), opts: bopts})
+	}
+	if nbb != nil {
+		tables = append(tables, l0Table{data: nbb.Finish(), opts: bopts})
+	}
+	return append(tables, l0Table{data: b.Finish(), opts: bopts}), nil
+}
+
+// noBloomKeys holds the fingerprints of the keys, with timestamps, written with
+// Entry.WithoutBloom to every memtable not flushed yet.
+type noBloomKeys struct {
+	sync.Mutex
+	keys map[*skl.Skiplist]map[uint64]struct{}
+}
+
+func (nb *noBloomKeys) add(mt *skl.Skiplist, key []byte) {
+	nb.Lock()
+	defer nb.Unlock()
+	if nb.keys == nil {
+		nb.keys = make(map[*skl.Skiplist]map[uint64]struct{})
+	}
+	fps, ok := nb.keys[mt]
+	if !ok {
+		fps = make(map[uint64]struct{})
+		nb.keys[mt] = fps
+	}
+	fps[z.MemHash(key)] = struct{}{}
+}
+
+// get returns the fingerprints of the keys of mt, which mustn't be modified.
+func (nb *noBloomKeys) get(mt *skl.Skiplist) map[uint64]struct{} {
+	nb.Lock()
+	defer nb.Unlock()
+	return nb.keys[mt]
+}
+
+// forget forgets the keys of mt, once flushed.
+func (nb *noBloomKeys) forget(mt *skl.Skiplist) {
+	nb.Lock()
+	defer nb.Unlock()
+	delete(nb.keys, mt)
+}
+
+// l0Table is a table built from a memtable, along with the options to open it.
+type l0Table struct {
+	data []byte
+	opts table.Options
 }
 
 type flushTask struct {
 	mt         *skl.Skiplist
 	vptr       valuePointer
 	dropPrefix []byte
+
+	// When singleVersion is set, versions shadowed by a newer version at or below discardTs are
+	// dropped instead of being flushed, and the value log space they use is added to
+	// discardStats.
+	singleVersion bool
+	discardTs     uint64
+	discardStats  map[uint32]int64
+	retention     *versionRetention
+
+	versions *versionTracker
+	registry *KeyRegistry
+	// noBloom are the fingerprints of the keys of mt not to index in bloom filters.
+	noBloom map[uint64]struct{}
 }
 
 // handleFlushTask must be run serially.
@@ -926,7 +1342,16 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	if ft.mt.Empty() {
 		return nil
 	}
+	return db.jobs.run(JobFlush, func(job *BackgroundJob) error {
+		return db.writeFlushTask(ft, job)
+	})
+}
 
+// writeFlushTask writes the memtable of ft to level 0, filling in job.
+func (db *DB) writeFlushTask(ft flushTask, job *BackgroundJob) error {
+	start := time.Now()
+	job.Detail = fmt.Sprintf("head %d:%d", ft.vptr.Fid, ft.vptr.Offset)
+	job.BytesRead = ft.mt.MemSize()
 	// Store badger head even if vptr is zero, need it for readTs
 	db.opt.Debugf("Storing value log head: %+v\n", ft.vptr)
 	db.elog.Printf("Storing offset: %+v\n", ft.vptr)
@@ -936,8 +1361,9 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	// commits.
 	headTs := y.KeyWithTs(head, db.orc.nextTs())
 	ft.mt.Put(headTs, y.ValueStruct{Value: val})
+	db.retention.recordTs(y.ParseTs(headTs) - 1)
 
-	dk, err := db.registry.latestDataKey()
+	dk, err := db.registry.latestDataKey(nil)
 	if err != nil {
 		return y.Wrapf(err, "failed to get datakey in db.handleFlushTask")
 	}
@@ -945,8 +1371,37 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	bopts.DataKey = dk
 	// Builder does not need cache but the same options are used for opening table.
 	bopts.Cache = db.blockCache
-	tableData := buildL0Table(ft, bopts)
+	if db.opt.SingleVersion {
+		ft.singleVersion = true
+		ft.discardTs = db.discardAtOrBelow()
+		ft.discardStats = make(map[uint32]int64)
+		ft.retention = db.retention
+	}
+	ft.versions = db.versions
+	ft.registry = db.registry
+	ft.noBloom = db.noBloom.get(ft.mt)
+	tables, err := buildL0Table(ft, bopts)
+	if err != nil {
+		return y.Wrapf(err, "failed to get datakey in db.handleFlushTask")
+	}
+	if len(ft.discardStats) > 0 {
+		db.vlog.updateDiscardStats(ft.discardStats)
+	}
+	for _, t := range tables {
+		job.BytesWritten += int64(len(t.data))
+		if err := db.writeLevel0Table(t.data, t.opts); err != nil {
+			db.ops.record(opFlush, "memtable of %d bytes failed: %v", ft.mt.MemSize(), err)
+			return err
+		}
+	}
+	db.noBloom.forget(ft.mt)
+	db.ops.record(opFlush, "memtable of %d bytes to %d L0 table(s), head %d:%d, took %v",
+		ft.mt.MemSize(), len(tables), ft.vptr.Fid, ft.vptr.Offset, time.Since(start))
+	return nil
+}
 
+// writeLevel0Table writes a table built from a memtable, and adds it to level 0.
+func (db *DB) writeLevel0Table(tableData []byte, bopts table.Options) error {
 	fileID := db.lc.reserveFileID()
 	if db.opt.KeepL0InMemory {
 		tbl, err := table.OpenInMemoryTable(tableData, fileID, &bopts)
@@ -979,6 +1434,7 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 		db.elog.Printf("ERROR while opening table: %v", err)
 		return err
 	}
+	tbl.Digest = db.tableDigest(tableData)
 	// We own a ref on tbl.
 	err = db.lc.addLevel0Table(tbl) // This will incrRef
 	_ = tbl.DecrRef()               // Releases our ref.
@@ -1008,6 +1464,8 @@ func (db *DB) flushMemtable(lc *y.Closer) error {
 				y.AssertTrue(ft.mt == db.imm[0])
 				db.imm = db.imm[1:]
 				ft.mt.DecrRef() // Return memory.
+				close(db.immFlushed)
+				db.immFlushed = make(chan struct{})
 				db.Unlock()
 
 				break
@@ -1114,7 +1572,8 @@ func (db *DB) updateSize(lc *y.Closer) {
 // ErrInvalidRequest is returned.
 //
 // Only one GC is allowed at a time. If another value log GC is running, or DB
-// has been closed, this would return an ErrRejected.
+// has been closed, this would return an ErrRejected. Outside of the
+// Options.MaintenanceWindows, it returns ErrOutsideMaintenanceWindow.
 //
 // Note: Every time GC is run, it would produce a spike of activity on the LSM
 // tree.
@@ -1125,7 +1584,26 @@ func (db *DB) RunValueLogGC(discardRatio float64) error {
 	if discardRatio >= 1.0 || discardRatio <= 0.0 {
 		return ErrInvalidRequest
 	}
+	if !db.inMaintenanceWindow() {
+		return ErrOutsideMaintenanceWindow
+	}
 
+	head, err := db.durableHead()
+	if err != nil {
+		return err
+	}
+
+	// Pick a log file and run GC
+	if err := db.vlog.runGC(discardRatio, head); err != nil {
+		return err
+	}
+	db.dropUnusedDataKeys()
+	return nil
+}
+
+// durableHead returns the value log head stored in the tables of the LSM tree on disk, which Open
+// replays the value log from.
+func (db *DB) durableHead() (valuePointer, error) {
 	// startLevel is the level from which we should search for the head key. When badger is running
 	// with KeepL0InMemory flag, all tables on L0 are kept in memory. This means we should pick head
 	// key from Level 1 onwards because if we pick the headkey from Level 0 we might end up losing
@@ -1136,19 +1614,17 @@ func (db *DB) RunValueLogGC(discardRatio float64) error {
 	}
 	// Find head on disk
 	headKey := y.KeyWithTs(head, math.MaxUint64)
-	// Need to pass with timestamp, lsm get removes the last 8 bytes and compares key
+	// Need to pass with timestamp, lsm get removes the timestamp suffix and compares key
 	val, err := db.lc.get(headKey, nil, startLevel)
 	if err != nil {
-		return errors.Wrap(err, "Retrieving head from on-disk LSM")
+		return valuePointer{}, errors.Wrap(err, "Retrieving head from on-disk LSM")
 	}
 
-	var head valuePointer
+	var vp valuePointer
 	if len(val.Value) > 0 {
-		head.Decode(val.Value)
+		vp.Decode(val.Value)
 	}
-
-	// Pick a log file and run GC
-	return db.vlog.runGC(discardRatio, head)
+	return vp, nil
 }
 
 // Size returns the size of lsm and value log files in bytes. It can be used to decide how often to
@@ -1171,6 +1647,24 @@ type Sequence struct {
 	next      uint64
 	leased    uint64
 	bandwidth uint64
+
+	leases uint64
+	gap    uint64
+}
+
+// SequenceStats describes the state of a Sequence.
+type SequenceStats struct {
+	// Next is the next integer returned by Next.
+	Next uint64
+	// Leased is the end of the current lease, up to which integers are served from memory.
+	Leased    uint64
+	Bandwidth uint64
+	// Leases is the number of leases taken by the Sequence.
+	Leases uint64
+	// MaxGap is the number of integers leased by a previous Sequence on the same key which didn't
+	// release them. Up to that many integers might have been skipped when the Sequence got
+	// created.
+	MaxGap uint64
 }
 
 // Next would return the next integer in the sequence, updating the lease by running a transaction
@@ -1188,6 +1682,59 @@ func (seq *Sequence) Next() (uint64, error) {
 	return val, nil
 }
 
+// Peek returns the integer the next call to Next would return, without using it up. The lease
+// gets updated if needed, as for Next.
+func (seq *Sequence) Peek() (uint64, error) {
+	seq.Lock()
+	defer seq.Unlock()
+	if seq.next >= seq.leased {
+		if err := seq.updateLease(); err != nil {
+			return 0, err
+		}
+	}
+	return seq.next, nil
+}
+
+// SetNext moves the sequence forward, so that next is the next integer returned by Next. The
+// integers leased up to next are released. It returns ErrSequenceRewind if next was returned
+// already, or if another Sequence on the same key leased the integers up to next since.
+func (seq *Sequence) SetNext(next uint64) error {
+	seq.Lock()
+	defer seq.Unlock()
+	err := seq.db.Update(func(txn *Txn) error {
+		stored, _, err := seq.load(txn)
+		if err != nil {
+			return err
+		}
+		// The integers of our own lease, past seq.next, weren't returned yet.
+		if stored == seq.leased {
+			stored = seq.next
+		}
+		if next < stored || next < seq.next {
+			return ErrSequenceRewind
+		}
+		return seq.store(txn, next, next)
+	})
+	if err != nil {
+		return err
+	}
+	seq.next, seq.leased = next, next
+	return nil
+}
+
+// Stats returns the state of the sequence.
+func (seq *Sequence) Stats() SequenceStats {
+	seq.Lock()
+	defer seq.Unlock()
+	return SequenceStats{
+		Next:      seq.next,
+		Leased:    seq.leased,
+		Bandwidth: seq.bandwidth,
+		Leases:    seq.leases,
+		MaxGap:    seq.gap,
+	}
+}
+
 // Release the leased sequence to avoid wasted integers. This should be done right
 // before closing the associated DB. However it is valid to use the sequence after
 // it was released, causing a new lease with full bandwidth.
@@ -1195,9 +1742,7 @@ func (seq *Sequence) Release() error {
 	seq.Lock()
 	defer seq.Unlock()
 	err := seq.db.Update(func(txn *Txn) error {
-		var buf [8]byte
-		binary.BigEndian.PutUint64(buf[:], seq.next)
-		return txn.SetEntry(NewEntry(seq.key, buf[:]))
+		return seq.store(txn, seq.next, seq.next)
 	})
 	if err != nil {
 		return err
@@ -1206,31 +1751,53 @@ func (seq *Sequence) Release() error {
 	return nil
 }
 
+// load reads the stored sequence. next is the first integer which wasn't leased yet, start is the
+// first integer of the last lease, equal to next if the lease got released.
+func (seq *Sequence) load(txn *Txn) (next, start uint64, err error) {
+	item, err := txn.Get(seq.key)
+	if err == ErrKeyNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	err = item.Value(func(v []byte) error {
+		next = binary.BigEndian.Uint64(v)
+		start = next
+		// Sequences stored by older versions don't record the start of their lease.
+		if len(v) >= 16 {
+			start = binary.BigEndian.Uint64(v[8:])
+		}
+		return nil
+	})
+	return next, start, err
+}
+
+// store stores the sequence. The first 8 bytes are the first integer which wasn't leased yet, the
+// next 8 bytes the first integer of the lease.
+func (seq *Sequence) store(txn *Txn, lease, start uint64) error {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], lease)
+	binary.BigEndian.PutUint64(buf[8:], start)
+	return txn.SetEntry(NewEntry(seq.key, buf[:]))
+}
+
 func (seq *Sequence) updateLease() error {
 	return seq.db.Update(func(txn *Txn) error {
-		item, err := txn.Get(seq.key)
-		if err == ErrKeyNotFound {
-			seq.next = 0
-		} else if err != nil {
+		next, start, err := seq.load(txn)
+		if err != nil {
 			return err
-		} else {
-			var num uint64
-			if err := item.Value(func(v []byte) error {
-				num = binary.BigEndian.Uint64(v)
-				return nil
-			}); err != nil {
-				return err
-			}
-			seq.next = num
 		}
+		if seq.leases == 0 {
+			seq.gap = next - start
+		}
+		seq.next = next
 
 		lease := seq.next + seq.bandwidth
-		var buf [8]byte
-		binary.BigEndian.PutUint64(buf[:], lease)
-		if err = txn.SetEntry(NewEntry(seq.key, buf[:])); err != nil {
+		if err = seq.store(txn, lease, seq.next); err != nil {
 			return err
 		}
 		seq.leased = lease
+		seq.leases++
 		return nil
 	})
 }
@@ -1281,7 +1848,9 @@ func (db *DB) KeySplits(prefix []byte) []string {
 			splits = append(splits, string(ti.Right))
 		}
 	}
-	sort.Strings(splits)
+	sort.Slice(splits, func(i, j int) bool {
+		return db.opt.compareKeys([]byte(splits[i]), []byte(splits[j])) < 0
+	})
 	return splits
 }
 
@@ -1298,7 +1867,10 @@ func (db *DB) MaxBatchSize() int64 {
 func (db *DB) stopMemoryFlush() {
 	// Stop memtable flushes.
 	if db.closers.memtable != nil {
+		// FlushMemtable pushes to flushChan under the lock.
+		db.Lock()
 		close(db.flushChan)
+		db.Unlock()
 		db.closers.memtable.SignalAndWait()
 	}
 }
@@ -1321,7 +1893,9 @@ func (db *DB) startCompactions() {
 func (db *DB) startMemoryFlush() {
 	// Start memory fluhser.
 	if db.closers.memtable != nil {
+		db.Lock()
 		db.flushChan = make(chan flushTask, db.opt.NumMemtables)
+		db.Unlock()
 		db.closers.memtable = y.NewCloser(1)
 		go func() {
 			_ = db.flushMemtable(db.closers.memtable)
@@ -1335,6 +1909,21 @@ func (db *DB) startMemoryFlush() {
 // stopped. Ideally, no writes are going on during Flatten. Otherwise, it would create competition
 // between flattening the tree and new tables being created at level zero.
 func (db *DB) Flatten(workers int) error {
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return db.flatten(context.Background(), workers, 0, nil)
+}
+
+// flatten runs Flatten until ctx is done or maxCompactions compactions ran, if it's positive.
+// compacted is called after every compaction if it isn't nil. The caller must have called
+// startRewrite.
+func (db *DB) flatten(ctx context.Context, workers, maxCompactions int, compacted func()) error {
+	if !db.inMaintenanceWindow() {
+		return ErrOutsideMaintenanceWindow
+	}
 	db.stopCompactions()
 	defer db.startCompactions()
 
@@ -1370,7 +1959,26 @@ func (db *DB) Flatten(workers int) error {
 		return humanize.Bytes(uint64(sz))
 	}
 
+	var n int
+	compact := func(cp compactionPriority) error {
+		if err := compactAway(cp); err != nil {
+			return err
+		}
+		n++
+		if compacted != nil {
+			compacted()
+		}
+		return nil
+	}
 	for {
+		if maxCompactions > 0 && n >= maxCompactions {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
 		db.opt.Infof("\n")
 		var levels []int
 		for i, l := range db.lc.levels {
@@ -1387,19 +1995,36 @@ func (db *DB) Flatten(workers int) error {
 				db.opt.Infof("All tables consolidated into one level. Flattening done.\n")
 				return nil
 			}
-			if err := compactAway(prios[0]); err != nil {
+			if err := compact(prios[0]); err != nil {
 				return err
 			}
 			continue
 		}
 		// Create an artificial compaction priority, to ensure that we compact the level.
 		cp := compactionPriority{level: levels[0], score: 1.71}
-		if err := compactAway(cp); err != nil {
+		if err := compact(cp); err != nil {
 			return err
 		}
 	}
 }
 
+// CompactOnce runs a single compaction synchronously, the one the compactors would pick next: that
+// of the level which exceeds its size the most, or level zero if it holds too many tables. It
+// returns false if no level needs to be compacted. Along with Options.ManualCompactions and
+// FlushMemtable, it steps the LSM tree through the same states on every run, for reproducible
+// tests of applications sensitive to it.
+func (db *DB) CompactOnce() (bool, error) {
+	if db.opt.ReadOnly {
+		return false, errors.New("CompactOnce cannot be called in read-only mode")
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	return db.lc.compactOnce()
+}
+
 func (db *DB) blockWrite() {
 	// Stop accepting new writes.
 	atomic.StoreInt32(&db.blockWrites, 1)
@@ -1456,15 +2081,38 @@ func (db *DB) prepareToDrop() func() {
 // any reads while DropAll is going on, otherwise they may result in panics. Ideally, both reads and
 // writes are paused before running DropAll, and resumed after it is finished.
 func (db *DB) DropAll() error {
-	f, err := db.dropAll()
+	return db.DropAllWithProgress(context.Background(), nil)
+}
+
+// DropAllProgress reports the progress of DB.DropAllWithProgress.
+type DropAllProgress struct {
+	TablesDeleted, TablesTotal               int
+	ValueLogFilesDeleted, ValueLogFilesTotal int
+}
+
+// DropAllWithProgress is DropAll, calling progress after every table and value log file deleted
+// if it isn't nil.
+//
+// It returns early with the error of ctx if it gets canceled. The DB is empty all the same: the
+// tables are removed from the MANIFEST before any gets deleted, and a value log head pointing past
+// the files left is persisted, so they never get replayed. Calling DropAll again deletes them.
+func (db *DB) DropAllWithProgress(ctx context.Context, progress func(DropAllProgress)) error {
+	f, err := db.dropAll(ctx, progress)
 	defer f()
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (db *DB) dropAll() (func(), error) {
+func (db *DB) dropAll(ctx context.Context, progress func(DropAllProgress)) (func(), error) {
+	if db.opt.AppendOnly {
+		return func() {}, ErrAppendOnly
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return func() {}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return func() {}, err
+	}
 	db.opt.Infof("DropAll called. Blocking writes...")
 	f := db.prepareToDrop()
 	// prepareToDrop will stop all the incomming write and flushes any pending flush tasks.
@@ -1474,36 +2122,112 @@ func (db *DB) dropAll() (func(), error) {
 	resume := func() {
 		db.startCompactions()
 		f()
+		done()
 	}
 	// Block all foreign interactions with memory tables.
 	db.Lock()
 	defer db.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return resume, err
+	}
 	// Remove inmemory tables. Calling DecrRef for safety. Not sure if they're absolutely needed.
 	db.mt.DecrRef()
 	for _, mt := range db.imm {
 		mt.DecrRef()
 	}
 	db.imm = db.imm[:0]
-	db.mt = skl.NewSkiplist(arenaSize(db.opt)) // Set it up for future writes.
+	db.mt = newSkiplist(db.opt) // Set it up for future writes.
 
-	num, err := db.lc.dropTree()
+	tables, err := db.lc.dropTree()
 	if err != nil {
 		return resume, err
 	}
-	db.opt.Infof("Deleted %d SSTables. Now deleting value logs...\n", num)
+	tables = append(db.dropPending, tables...)
+	db.dropPending = nil
 
-	num, err = db.vlog.dropAll()
-	if err != nil {
-		return resume, err
+	var fids []uint32
+	if !db.opt.InMemory {
+		db.vlog.filesLock.RLock()
+		for fid := range db.vlog.filesMap {
+			fids = append(fids, fid)
+		}
+		db.vlog.filesLock.RUnlock()
+		sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	}
+	p := DropAllProgress{TablesTotal: len(tables), ValueLogFilesTotal: len(fids)}
+	report := func() {
+		if progress != nil {
+			progress(p)
+		}
+	}
+	report()
+
+	for i, t := range tables {
+		if err := ctx.Err(); err != nil {
+			db.dropPending = tables[i:]
+			return resume, db.dropAllCanceled(err)
+		}
+		if err := t.DecrRef(); err != nil {
+			db.dropPending = tables[i+1:]
+			return resume, err
+		}
+		p.TablesDeleted++
+		report()
+	}
+	db.opt.Infof("Deleted %d SSTables. Now deleting value logs...\n", p.TablesDeleted)
+
+	// The files are deleted in order, so the last one, which is being written to, is left if
+	// DropAll gets canceled.
+	for _, fid := range fids {
+		if err := ctx.Err(); err != nil {
+			return resume, db.dropAllCanceled(err)
+		}
+		if err := db.vlog.dropFile(fid); err != nil {
+			return resume, err
+		}
+		p.ValueLogFilesDeleted++
+		report()
+	}
+	if !db.opt.InMemory {
+		db.opt.Infof("Value logs deleted. Creating value log file: 0")
+		if _, err := db.vlog.createVlogFile(0); err != nil {
+			return resume, err
+		}
+		atomic.StoreUint32(&db.vlog.maxFid, 0)
 	}
 	db.vhead = valuePointer{} // Zero it out.
 	db.lc.nextFileID = 1
-	db.opt.Infof("Deleted %d value log files. DropAll done.\n", num)
+	db.opt.Infof("Deleted %d value log files. DropAll done.\n", p.ValueLogFilesDeleted)
 	db.blockCache.Clear()
 	return resume, nil
 }
 
+// dropAllCanceled persists a value log head pointing to a new value log file, so that the ones a
+// canceled DropAll didn't delete are never replayed. It returns err, the error of the context,
+// unless persisting the head fails.
+func (db *DB) dropAllCanceled(err error) error {
+	db.opt.Infof("DropAll canceled: %v", err)
+	db.blockCache.Clear()
+	if db.opt.InMemory {
+		return err
+	}
+	atomic.StoreInt32(&db.vlog.rotateHead, 1)
+	if err := db.vlog.write(nil); err != nil {
+		return err
+	}
+	vptr := valuePointer{Fid: atomic.LoadUint32(&db.vlog.maxFid), Offset: db.vlog.woffset()}
+	db.vhead = vptr
+	// An empty memtable doesn't get flushed, make sure the head gets persisted anyway.
+	db.mt.Put(y.KeyWithTs(head, db.orc.nextTs()), y.ValueStruct{Value: vptr.Encode()})
+	if err := db.handleFlushTask(flushTask{mt: db.mt, vptr: vptr}); err != nil {
+		return err
+	}
+	db.mt.DecrRef()
+	db.mt = newSkiplist(db.opt)
+	return err
+}
+
 // DropPrefix would drop all the keys with the provided prefix. It does this in the following way:
 // - Stop accepting new writes.
 // - Stop memtable flushes before acquiring lock. Because we're acquring lock here
@@ -1516,6 +2240,14 @@ func (db *DB) dropAll() (func(), error) {
 // - Compact rest of the levels, Li->Li, picking tables which have Kp.
 // - Resume memtable flushes, compactions and writes.
 func (db *DB) DropPrefix(prefix []byte) error {
+	if db.opt.AppendOnly {
+		return ErrAppendOnly
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
 	f := db.prepareToDrop()
 	defer f()
 	// Block all foreign interactions with memory tables.
@@ -1544,7 +2276,7 @@ func (db *DB) DropPrefix(prefix []byte) error {
 	db.stopCompactions()
 	defer db.startCompactions()
 	db.imm = db.imm[:0]
-	db.mt = skl.NewSkiplist(arenaSize(db.opt))
+	db.mt = newSkiplist(db.opt)
 
 	// Drop prefixes from the levels.
 	if err := db.lc.dropPrefix(prefix); err != nil {
@@ -1562,58 +2294,91 @@ type KVList = pb.KVList
 // You can use an empty prefix to monitor all changes to the DB.
 // This function blocks until the given context is done or an error occurs.
 // The given function will be called with a new KVList containing the modified keys and the
-// corresponding values.
+// corresponding values. A callback which doesn't keep up with the writes eventually blocks them,
+// see SubscribeWithOptions for other policies.
 func (db *DB) Subscribe(ctx context.Context, cb func(kv *KVList) error, prefixes ...[]byte) error {
+	return db.SubscribeWithOptions(ctx, cb, SubscribeOptions{Prefixes: prefixes})
+}
+
+// SubscribeWithOptions is like Subscribe, with the prefixes watched and the way updates are
+// buffered for the callback given by opt. Once the buffer is full, opt.Policy either waits for
+// the callback, drops the oldest updates, or cancels the subscription, in which case
+// SubscribeWithOptions returns ErrSubscriptionLagging. See DB.Subscriptions to monitor how far
+// behind the subscriptions are.
+func (db *DB) SubscribeWithOptions(ctx context.Context, cb func(kv *KVList) error,
+	opt SubscribeOptions) error {
 	if cb == nil {
 		return ErrNilCallback
 	}
-	if len(prefixes) == 0 {
+	if len(opt.Prefixes) == 0 {
 		return ErrNoPrefixes
 	}
 	c := y.NewCloser(1)
-	recvCh, id := db.pub.newSubscriber(c, prefixes...)
-	slurp := func(batch *pb.KVList) error {
+	s := db.pub.newSubscriber(c, opt)
+	if opt.Started != nil {
+		opt.Started()
+	}
+	slurp := func(u subscriberUpdate) error {
+		batch, oldest := u.kvs, u.at
 		for {
 			select {
-			case kvs := <-recvCh:
-				batch.Kv = append(batch.Kv, kvs.Kv...)
+			case u := <-s.sendCh:
+				batch.Kv = append(batch.Kv, u.kvs.Kv...)
 			default:
 				if len(batch.GetKv()) > 0 {
+					atomic.StoreInt64(&s.lag, int64(time.Since(oldest)))
+					atomic.AddUint64(&s.delivered, uint64(len(batch.Kv)))
 					return cb(batch)
 				}
 				return nil
 			}
 		}
 	}
+	// Stop publishing to the subscriber before deleting it, which waits for publishing.
+	stop := func() {
+		close(s.done)
+		c.Done()
+	}
 	for {
 		select {
 		case <-c.HasBeenClosed():
 			// No need to delete here. Closer will be called only while
 			// closing DB. Subscriber will be deleted by cleanSubscribers.
-			err := slurp(new(pb.KVList))
+			err := slurp(subscriberUpdate{kvs: new(pb.KVList), at: time.Now()})
 			// Drain if any pending updates.
-			c.Done()
+			stop()
 			return err
+		case <-s.lagging:
+			// The publisher deleted the subscriber already.
+			stop()
+			return ErrSubscriptionLagging
 		case <-ctx.Done():
-			c.Done()
-			db.pub.deleteSubscriber(id)
+			stop()
+			db.pub.deleteSubscriber(s.id)
 			// Delete the subscriber to avoid further updates.
 			return ctx.Err()
-		case batch := <-recvCh:
-			err := slurp(batch)
+		case u := <-s.sendCh:
+			err := slurp(u)
 			if err != nil {
-				c.Done()
+				stop()
 				// Delete the subsriber if there is an error by the callback.
-				db.pub.deleteSubscriber(id)
+				db.pub.deleteSubscriber(s.id)
 				return err
 			}
 		}
 	}
 }
 
+// Subscriptions describes the running subscriptions of the DB, sorted by ID, along with how far
+// behind their callbacks are.
+func (db *DB) Subscriptions() []SubscriptionStats {
+	return db.pub.stats()
+}
+
 // shouldEncrypt returns bool, which tells whether to encrypt or not.
 func (db *DB) shouldEncrypt() bool {
-	return len(db.opt.EncryptionKey) > 0
+	return len(db.opt.EncryptionKey) > 0 || db.opt.KeyProvider != nil ||
+		db.opt.KeyWrapper != nil || db.opt.EncryptionPassphrase != ""
 }
 
 func (db *DB) syncDir(dir string) error {
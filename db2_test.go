@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"flag"
 	"fmt"
@@ -761,3 +762,277 @@ func TestWindowsDataLoss(t *testing.T) {
 	}
 	require.ElementsMatch(t, keyList, result)
 }
+
+func TestSingleVersionFlush(t *testing.T) {
+	countVersions := func(db *DB) (count int) {
+		opts := DefaultIteratorOptions
+		opts.AllVersions = true
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				count++
+			}
+			return nil
+		}))
+		return count
+	}
+
+	for _, single := range []bool{false, true} {
+		t.Run(fmt.Sprintf("single=%v", single), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "badger-test")
+			require.NoError(t, err)
+			defer removeDir(dir)
+
+			// Keep compactions out of the way, versions must be dropped by the flush itself.
+			opt := getTestOptions(dir).
+				WithSingleVersion(single).
+				WithKeepL0InMemory(false).
+				WithCompactL0OnClose(false).
+				WithNumVersionsToKeep(10)
+			db, err := OpenManaged(opt)
+			require.NoError(t, err)
+			for i := 1; i <= 20; i++ {
+				txn := db.NewTransactionAt(uint64(i), true)
+				require.NoError(t, txn.Set([]byte("key"), []byte(fmt.Sprintf("val%d", i))))
+				require.NoError(t, txn.CommitAt(uint64(i), nil))
+			}
+			// Versions newer than the discard ts must survive the flush.
+			db.SetDiscardTs(18)
+			require.NoError(t, db.Close())
+
+			db, err = OpenManaged(opt)
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, 1, db.lc.levels[0].numTables())
+			if single {
+				require.Equal(t, 3, countVersions(db))
+			} else {
+				require.Equal(t, 20, countVersions(db))
+			}
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get([]byte("key"))
+				require.NoError(t, err)
+				require.Equal(t, []byte("val20"), getItemValue(t, item))
+				return nil
+			}))
+		})
+	}
+}
+
+func TestShortLivedEntries(t *testing.T) {
+	opt := getTestOptions("").WithKeepL0InMemory(false)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 10; i++ {
+				if err := txn.Set([]byte(fmt.Sprintf("data%d", i)), []byte("value")); err != nil {
+					return err
+				}
+				e := NewEntry([]byte(fmt.Sprintf("lock%d", i)), []byte("owner")).WithShortLived()
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+				e = NewEntry([]byte(fmt.Sprintf("log%d", i)), []byte("line")).WithoutBloom()
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.flushHead())
+
+		tables := db.lc.levels[0].tables
+		require.Len(t, tables, 3)
+		// The short-lived table comes first, then the table without bloom filter.
+		require.Equal(t, "lock0", string(y.ParseKey(tables[0].Smallest())))
+		require.Equal(t, "lock9", string(y.ParseKey(tables[0].Biggest())))
+		require.Equal(t, "log0", string(y.ParseKey(tables[1].Smallest())))
+		require.Equal(t, "log9", string(y.ParseKey(tables[1].Biggest())))
+		require.Equal(t, "data9", string(y.ParseKey(tables[2].Biggest())))
+		for i := 0; i < 10; i++ {
+			require.False(t, tables[1].DoesNotHave(uint64(i)))
+		}
+		require.Empty(t, db.noBloom.keys)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, k := range []string{"data3", "lock3", "log3"} {
+				if _, err := txn.Get([]byte(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	})
+}
+
+func TestFlushMemtable(t *testing.T) {
+	opt := getTestOptions("").WithKeepL0InMemory(false)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("foo"), []byte("bar"))
+		}))
+		require.NoError(t, db.FlushMemtable(context.Background()))
+		require.Equal(t, 1, db.lc.levels[0].numTables())
+		db.RLock()
+		require.Empty(t, db.imm)
+		require.True(t, db.mt.Empty())
+		db.RUnlock()
+
+		// An empty memtable isn't flushed.
+		require.NoError(t, db.FlushMemtable(context.Background()))
+		require.Equal(t, 1, db.lc.levels[0].numTables())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("foo"))
+			return err
+		}))
+	})
+}
+
+func TestFlushMemtableKeepL0InMemory(t *testing.T) {
+	opt := getTestOptions("").WithKeepL0InMemory(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("foo"), []byte("bar"))
+		}))
+		require.NoError(t, db.FlushMemtable(context.Background()))
+		// The in-memory L0 tables got compacted into table files.
+		require.Equal(t, 0, db.lc.levels[0].numTables())
+		require.Equal(t, 1, db.lc.levels[1].numTables())
+		db.manifest.appendLock.Lock()
+		require.Len(t, db.manifest.manifest.Tables, 1)
+		db.manifest.appendLock.Unlock()
+
+		require.NoError(t, db.Freeze())
+		require.Equal(t, ErrFrozen, db.FlushMemtable(context.Background()))
+		require.NoError(t, db.Thaw())
+	})
+}
+
+func TestMaxKeySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithMaxTableSize(8 << 20).WithKeepL0InMemory(false)
+
+	_, err = Open(opt.WithMaxKeySize(maxKeySizeLimit + 1))
+	require.Error(t, err)
+
+	opt = opt.WithMaxKeySize(1 << 20)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	prefix := bytes.Repeat([]byte("k"), 200<<10)
+	key := func(i int) []byte {
+		return append(prefix[:len(prefix):len(prefix)], fmt.Sprintf("%04d", i)...)
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key(i), []byte(fmt.Sprintf("%d", i)))
+		}))
+	}
+	require.Error(t, db.Update(func(txn *Txn) error {
+		return txn.Set(make([]byte, 1<<20+1), nil)
+	}))
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			var i int
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Equal(t, key(i), it.Item().Key())
+				val, err := it.Item().ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%d", i), string(val))
+				i++
+			}
+			require.Equal(t, 20, i)
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	require.Equal(t, 1, db.lc.levels[0].numTables())
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestEncryptValuesOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithEncryptionKey(make([]byte, 32)).WithEncryptValuesOnly(true)
+	opt.KeepL0InMemory = false
+	// tables returns the number of tables encrypting their values only, and of the others.
+	tables := func(db *DB) (valuesOnly, whole int) {
+		db.manifest.appendLock.Lock()
+		defer db.manifest.appendLock.Unlock()
+		for _, tm := range db.manifest.manifest.Tables {
+			if tm.ValuesOnly {
+				valuesOnly++
+			} else {
+				whole++
+			}
+		}
+		return valuesOnly, whole
+	}
+	read := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("key%04d", i)))
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("secret-%04d", i), string(getItemValue(t, item)))
+			}
+			return nil
+		}))
+	}
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%04d", i)),
+			[]byte(fmt.Sprintf("secret-%04d", i))))
+	}
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	valuesOnly, whole := tables(db)
+	require.NotZero(t, valuesOnly)
+	require.Zero(t, whole)
+	read(db)
+	require.NoError(t, db.Close())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var keysFound bool
+	for _, fi := range files {
+		if path.Ext(fi.Name()) != ".sst" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(dir, fi.Name()))
+		require.NoError(t, err)
+		keysFound = keysFound || bytes.Contains(data, []byte("key0"))
+		require.False(t, bytes.Contains(data, []byte("secret-")))
+	}
+	require.True(t, keysFound)
+
+	// The tables keep their mode once the option is turned off, until they're rewritten.
+	opt.EncryptValuesOnly = false
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	read(db)
+	valuesOnly, _ = tables(db)
+	require.NotZero(t, valuesOnly)
+	require.NoError(t, db.ReencryptAll(context.Background(), db.registry.nextKeyID+1, nil))
+	valuesOnly, whole = tables(db)
+	require.Zero(t, valuesOnly)
+	require.NotZero(t, whole)
+	read(db)
+}
@@ -35,6 +35,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dgraph-io/badger/v2/options"
@@ -113,6 +114,16 @@ func txnDelete(t *testing.T, kv *DB, key []byte) {
 	require.NoError(t, txn.Commit())
 }
 
+// waitFor polls cond until it holds, failing the test after ten seconds. It replaces
+// require.Eventually, whose goroutine may send on a closed channel in the testify version used.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		require.True(t, time.Now().Before(deadline), "condition not met")
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // Opens a badger db and runs a a test on it.
 func runBadgerTest(t *testing.T, opts *Options, test func(t *testing.T, db *DB)) {
 	dir, err := ioutil.TempDir("", "badger-test")
@@ -703,6 +714,25 @@ func TestLoad(t *testing.T) {
 		opt.Compression = options.ZSTD
 		testLoad(t, opt)
 	})
+	t.Run("TestLoad With values only Encryption", func(t *testing.T) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		opt := getTestOptions("")
+		opt.EncryptionKey = key
+		opt.EncryptValuesOnly = true
+		testLoad(t, opt)
+	})
+	t.Run("TestLoad With authenticated Encryption and compression", func(t *testing.T) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		opt := getTestOptions("")
+		opt.EncryptionKey = key
+		opt.EncryptionAlgorithm = options.AESGCM
+		opt.Compression = options.ZSTD
+		testLoad(t, opt)
+	})
 	t.Run("TestLoad without Encryption and with compression", func(t *testing.T) {
 		opt := getTestOptions("")
 		opt.Compression = options.ZSTD
@@ -872,10 +902,10 @@ func TestInvalidKey(t *testing.T) {
 	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
 		err := db.Update(func(txn *Txn) error {
 			err := txn.SetEntry(NewEntry([]byte("!badger!head"), nil))
-			require.Equal(t, ErrInvalidKey, err)
+			require.Equal(t, ErrInvalidKey, errors.Cause(err))
 
 			err = txn.SetEntry(NewEntry([]byte("!badger!"), nil))
-			require.Equal(t, ErrInvalidKey, err)
+			require.Equal(t, ErrInvalidKey, errors.Cause(err))
 
 			err = txn.SetEntry(NewEntry([]byte("!badger"), []byte("BadgerDB")))
 			require.NoError(t, err)
@@ -1464,6 +1494,69 @@ func TestSequence_Release(t *testing.T) {
 	})
 }
 
+func TestSequencePeekAndSetNext(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		seq, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		for i := uint64(0); i < 3; i++ {
+			num, err := seq.Peek()
+			require.NoError(t, err)
+			require.Equal(t, i, num)
+			num, err = seq.Next()
+			require.NoError(t, err)
+			require.Equal(t, i, num)
+		}
+
+		require.Equal(t, ErrSequenceRewind, seq.SetNext(2))
+		// Moving forward within the current lease.
+		require.NoError(t, seq.SetNext(5))
+		num, err := seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), num)
+		require.NoError(t, seq.SetNext(100))
+		num, err = seq.Peek()
+		require.NoError(t, err)
+		require.Equal(t, uint64(100), num)
+		num, err = seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(100), num)
+		require.Equal(t, SequenceStats{Next: 101, Leased: 110, Bandwidth: 10, Leases: 3},
+			seq.Stats())
+
+		// Another sequence on the same key can't go back before the lease either.
+		seq2, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		require.Equal(t, ErrSequenceRewind, seq2.SetNext(105))
+	})
+}
+
+func TestSequenceGap(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		seq, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		_, err = seq.Next()
+		require.NoError(t, err)
+
+		// The first sequence didn't release its lease.
+		seq2, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), seq2.Stats().MaxGap)
+		num, err := seq2.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), num)
+		require.NoError(t, seq2.Release())
+
+		seq3, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), seq3.Stats().MaxGap)
+		num, err = seq3.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(11), num)
+	})
+}
+
 func TestReadOnly(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
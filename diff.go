@@ -0,0 +1,217 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// Difference is a key whose latest version differs between two DBs, as reported by Diff.
+type Difference struct {
+	Key []byte
+	// InA and InB tell which of the DBs hold the key, both being set if they hold different values
+	// or user metadata.
+	InA, InB bool
+}
+
+// Diff calls fn with the keys whose latest version differs between DBs a and b, in key order,
+// stopping at the first error of fn. It compares the digests of the key ranges, see
+// DB.RangeDigest, narrowing down the ranges which differ by halves until they only span a table,
+// and only reads the keys of those, so that replicas which barely diverged get compared without
+// reading all their data. The bounds of the ranges are the key ranges of the tables of both DBs,
+// which must use the same key order, and be written to by no one meanwhile.
+//
+// To compare a DB with a backup, the backup can be loaded into a DB opened with
+// Options.InMemory.
+func Diff(a, b *DB, fn func(d Difference) error) error {
+	// The ranges compared are between these keys, excluding the lower bound.
+	var bounds [][]byte
+	for _, db := range []*DB{a, b} {
+		for _, ti := range db.Tables(false) {
+			bounds = append(bounds, y.ParseKey(ti.Left), y.ParseKey(ti.Right))
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return a.opt.compareKeys(bounds[i], bounds[j]) < 0 })
+	n := 0
+	for _, k := range bounds {
+		if n == 0 || !bytes.Equal(bounds[n-1], k) {
+			bounds[n] = k
+			n++
+		}
+	}
+	// The first range starts at the first key, and the last one ends at the last key.
+	bounds = append([][]byte{nil}, append(bounds[:n], nil)...)
+
+	d := differ{a: newPrefixDigests(a, len(bounds)), b: newPrefixDigests(b, len(bounds)),
+		bounds: bounds, fn: fn}
+	return d.diff(0, len(bounds)-1)
+}
+
+// prefixDigests are the digests of the keys of a DB up to the bounds of Diff, computed lazily.
+type prefixDigests struct {
+	db      *DB
+	digests []*rangeDigest
+}
+
+func newPrefixDigests(db *DB, n int) prefixDigests {
+	return prefixDigests{db: db, digests: make([]*rangeDigest, n)}
+}
+
+// upTo returns the digest of the keys up to bounds[i]: none for the first one, all of them for the
+// last one.
+func (pd prefixDigests) upTo(bounds [][]byte, i int) (rangeDigest, error) {
+	if i == 0 {
+		return rangeDigest{}, nil
+	}
+	if pd.digests[i] == nil {
+		d, err := pd.db.digestRange(nil, bounds[i])
+		if err != nil {
+			return rangeDigest{}, err
+		}
+		pd.digests[i] = &d
+	}
+	return *pd.digests[i], nil
+}
+
+// between returns the digest of the keys in (bounds[i], bounds[j]].
+func (pd prefixDigests) between(bounds [][]byte, i, j int) (rangeDigest, error) {
+	d, err := pd.upTo(bounds, j)
+	if err != nil {
+		return rangeDigest{}, err
+	}
+	lower, err := pd.upTo(bounds, i)
+	if err != nil {
+		return rangeDigest{}, err
+	}
+	d.sub(lower)
+	return d, nil
+}
+
+type differ struct {
+	a, b   prefixDigests
+	bounds [][]byte
+	fn     func(d Difference) error
+}
+
+// diff reports the differences in (bounds[i], bounds[j]].
+func (d *differ) diff(i, j int) error {
+	da, err := d.a.between(d.bounds, i, j)
+	if err != nil {
+		return err
+	}
+	db, err := d.b.between(d.bounds, i, j)
+	if err != nil {
+		return err
+	}
+	switch {
+	case da == db:
+		return nil
+	case j == i+1:
+		return d.diffKeys(d.bounds[i], d.bounds[j])
+	}
+	mid := (i + j) / 2
+	if err := d.diff(i, mid); err != nil {
+		return err
+	}
+	return d.diff(mid, j)
+}
+
+// diffKeys reports the differences in (lower, upper] by reading the keys, the range being
+// unbounded on the side of an empty bound.
+func (d *differ) diffKeys(lower, upper []byte) error {
+	ita, closeA := newDiffIterator(d.a.db, lower)
+	defer closeA()
+	itb, closeB := newDiffIterator(d.b.db, lower)
+	defer closeB()
+	cmp := d.a.db.opt.compareKeys
+	valid := func(it *Iterator) bool {
+		return it.Valid() && (len(upper) == 0 || cmp(it.item.key, upper) <= 0)
+	}
+	for valid(ita) || valid(itb) {
+		var c int
+		switch {
+		case !valid(itb):
+			c = -1
+		case !valid(ita):
+			c = 1
+		default:
+			c = cmp(ita.item.key, itb.item.key)
+		}
+		diff := Difference{InA: c <= 0, InB: c >= 0}
+		if c == 0 {
+			same, err := sameItems(ita.item, itb.item)
+			if err != nil {
+				return err
+			}
+			if !same {
+				diff.Key = ita.item.KeyCopy(nil)
+			}
+		} else if c < 0 {
+			diff.Key = ita.item.KeyCopy(nil)
+		} else {
+			diff.Key = itb.item.KeyCopy(nil)
+		}
+		if diff.Key != nil {
+			if err := d.fn(diff); err != nil {
+				return err
+			}
+		}
+		if c <= 0 {
+			ita.Next()
+		}
+		if c >= 0 {
+			itb.Next()
+		}
+	}
+	return nil
+}
+
+// newDiffIterator returns an iterator over the latest version of the keys of db after lower, and
+// the function closing it.
+func newDiffIterator(db *DB, lower []byte) (*Iterator, func()) {
+	txn := db.latestTxn()
+	it := txn.NewIterator(IteratorOptions{})
+	it.Seek(lower)
+	if len(lower) > 0 && it.Valid() && bytes.Equal(it.item.key, lower) {
+		it.Next()
+	}
+	return it, func() {
+		it.Close()
+		txn.Discard()
+	}
+}
+
+// sameItems tells whether items ia and ib have the same user metadata and stored value.
+func sameItems(ia, ib *Item) (bool, error) {
+	if ia.userMeta != ib.userMeta {
+		return false, nil
+	}
+	va, cba, err := ia.yieldItemValue()
+	defer runCallback(cba)
+	if err != nil {
+		return false, err
+	}
+	vb, cbb, err := ib.yieldItemValue()
+	defer runCallback(cbb)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(va, vb), nil
+}
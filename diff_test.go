@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	write := func(db *DB, skip int, changed int) {
+		wb := db.NewWriteBatch()
+		for i := 0; i < 2000; i++ {
+			val := []byte(fmt.Sprintf("value%04d", i))
+			if i == changed {
+				val = []byte("changed")
+			}
+			if i != skip {
+				require.NoError(t, wb.Set(key(i), val))
+			}
+		}
+		require.NoError(t, wb.Flush())
+	}
+
+	runBadgerTest(t, nil, func(t *testing.T, a *DB) {
+		write(a, 10, -1)
+		require.NoError(t, a.Flatten(1))
+		runBadgerTest(t, nil, func(t *testing.T, b *DB) {
+			write(b, 1500, 700)
+			require.NoError(t, b.Update(func(txn *Txn) error {
+				return txn.Set([]byte("zzz"), []byte("only in b"))
+			}))
+
+			var diffs []Difference
+			require.NoError(t, Diff(a, b, func(d Difference) error {
+				diffs = append(diffs, d)
+				return nil
+			}))
+			require.Equal(t, []Difference{
+				{Key: key(10), InB: true},
+				{Key: key(700), InA: true, InB: true},
+				{Key: key(1500), InA: true},
+				{Key: []byte("zzz"), InB: true},
+			}, diffs)
+
+			require.NoError(t, Diff(a, a, func(d Difference) error {
+				return fmt.Errorf("unexpected difference %q", d.Key)
+			}))
+		})
+	})
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/pkg/errors"
+)
+
+// tableDigest returns the digest of a new table file holding data, or nil if the digests of the
+// files aren't recorded.
+func (db *DB) tableDigest(data []byte) []byte {
+	if !db.opt.FileDigests {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// fileSHA256 returns the SHA-256 of the file at path.
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "while reading %q", path)
+	}
+	return h.Sum(nil), nil
+}
+
+// initDigest starts hashing lf, which holds size bytes already, so that its digest is known
+// without reading it again once it's rotated.
+func (lf *logFile) initDigest(size int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(lf.fd, 0, size)); err != nil {
+		return errors.Wrapf(err, "while reading %q", lf.path)
+	}
+	lf.digest = h
+	return nil
+}
+
+// recordDigest records the digest of lf in the MANIFEST, once nothing gets written to it anymore.
+func (vlog *valueLog) recordDigest(lf *logFile) error {
+	digest := lf.digest.Sum(nil)
+	return vlog.db.manifest.addChanges([]*pb.ManifestChange{newCreateVlogChange(lf.fid, digest)})
+}
+
+// VerifyFiles checks that the SST and value log files didn't change since they were written, by
+// comparing their SHA-256 with the digests recorded in the MANIFEST. Only the files written with
+// Options.FileDigests set have a digest, the others are skipped. It returns the number of files
+// checked. If some of them changed, the error lists them and its cause is ErrFileDigestMismatch.
+//
+// Every file gets read entirely, so this can take a while on large databases.
+func (db *DB) VerifyFiles() (int, error) {
+	if db.opt.InMemory {
+		return 0, nil
+	}
+	tableDigests, vlogDigests := db.manifest.digests()
+
+	// Hold references to the tables, and block the deletion of value log files the way iterators
+	// do, so that compactions and garbage collection don't remove the files while they get read.
+	var tables []*table.Table
+	for _, l := range db.lc.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			if _, ok := tableDigests[t.ID()]; ok {
+				t.IncrRef()
+				tables = append(tables, t)
+			}
+		}
+		l.RUnlock()
+	}
+	defer func() { _ = decrRefs(tables) }()
+
+	db.vlog.incrIteratorCount()
+	var fids []uint32
+	db.vlog.filesLock.RLock()
+	for fid := range vlogDigests {
+		if _, ok := db.vlog.filesMap[fid]; ok {
+			fids = append(fids, fid)
+		}
+	}
+	db.vlog.filesLock.RUnlock()
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+
+	var checked int
+	var changed []string
+	check := func(path string, digest []byte) error {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		checked++
+		if !bytes.Equal(sum, digest) {
+			db.opt.Errorf("File %s doesn't match its digest in the MANIFEST", path)
+			changed = append(changed, path)
+		}
+		return nil
+	}
+	err := func() error {
+		for _, t := range tables {
+			if err := check(t.Filename(), tableDigests[t.ID()]); err != nil {
+				return err
+			}
+		}
+		for _, fid := range fids {
+			if err := check(db.vlog.fpath(fid), vlogDigests[fid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if decrErr := db.vlog.decrIteratorCount(); err == nil {
+		err = decrErr
+	}
+	if err != nil {
+		return checked, err
+	}
+	if len(changed) > 0 {
+		return checked, errors.Wrapf(ErrFileDigestMismatch, "%s", strings.Join(changed, ", "))
+	}
+	db.opt.Infof("Verified the digests of %d files", checked)
+	return checked, nil
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithFileDigests(true).WithValueLogMaxEntries(100)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%04d", i)), make([]byte, 100), 0)
+	}
+	require.NoError(t, db.Close())
+
+	// The digests survive reopening the DB.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	// Rotate the value log file written to before reopening, whose digest covers both sessions.
+	for i := 0; i < 150; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("more%04d", i)), make([]byte, 100), 0)
+	}
+	tables, vlogs := db.manifest.digests()
+	require.NotEmpty(t, tables)
+	require.NotEmpty(t, vlogs)
+	n, err := db.VerifyFiles()
+	require.NoError(t, err)
+	require.Equal(t, len(tables)+len(vlogs), n)
+
+	// Modify a value without touching the size of the file.
+	path := db.vlog.fpath(0)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, 100)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = db.VerifyFiles()
+	require.Equal(t, ErrFileDigestMismatch, errors.Cause(err))
+	require.Contains(t, err.Error(), path)
+}
+
+func TestVerifyFilesWithoutDigests(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for i := 0; i < 1000; i++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%04d", i)), make([]byte, 100), 0)
+		}
+		n, err := db.VerifyFiles()
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+}
+
+func TestManifestFileDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	mf, _, err := helpOpenOrCreateManifestFile(dir, false, manifestDeletionsRewriteThreshold, nil)
+	require.NoError(t, err)
+	table := newCreateChange(1, 0, 0, 0)
+	table.Sha256 = []byte("table digest")
+	require.NoError(t, mf.addChanges([]*pb.ManifestChange{
+		table,
+		newCreateVlogChange(1, []byte("vlog 1 digest")),
+		newCreateVlogChange(2, []byte("vlog 2 digest")),
+	}))
+	require.NoError(t, mf.addChanges([]*pb.ManifestChange{newDeleteVlogChange(1)}))
+	require.Error(t, mf.addChanges([]*pb.ManifestChange{newDeleteVlogChange(1)}))
+	require.True(t, mf.hasVlogDigest(2))
+	require.False(t, mf.hasVlogDigest(1))
+
+	check := func(m Manifest) {
+		require.Equal(t, []byte("table digest"), m.Tables[1].SHA256)
+		require.Equal(t, map[uint32][]byte{2: []byte("vlog 2 digest")}, m.VlogDigests)
+	}
+	require.NoError(t, mf.close())
+	mf, m, err := helpOpenOrCreateManifestFile(dir, false, manifestDeletionsRewriteThreshold, nil)
+	require.NoError(t, err)
+	check(m)
+
+	// The digests are kept when the MANIFEST gets rewritten.
+	mf.appendLock.Lock()
+	require.NoError(t, mf.rewrite())
+	mf.appendLock.Unlock()
+	require.NoError(t, mf.close())
+	mf, m, err = helpOpenOrCreateManifestFile(dir, false, manifestDeletionsRewriteThreshold, nil)
+	require.NoError(t, err)
+	check(m)
+	require.NoError(t, mf.close())
+}
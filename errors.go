@@ -51,8 +51,8 @@ var (
 	// ErrEmptyKey is returned if an empty key is passed on an update function.
 	ErrEmptyKey = errors.New("Key cannot be empty")
 
-	// ErrInvalidKey is returned if the key has a special !badger! prefix,
-	// reserved for internal usage.
+	// ErrInvalidKey is the cause of the *ReservedKeyError returned if the key has a special
+	// !badger! prefix, reserved for internal usage. See InternalKeyPrefix.
 	ErrInvalidKey = errors.New("Key is using a reserved !badger! prefix")
 
 	// ErrRetry is returned when a log file containing the value is not found.
@@ -113,6 +113,10 @@ var (
 	// ErrNoPrefixes is returned when subscriber doesn't provide any prefix.
 	ErrNoPrefixes = errors.New("At least one key prefix is required")
 
+	// ErrSubscriptionLagging is returned by DB.SubscribeWithOptions when the subscription gets
+	// canceled because its callback doesn't keep up with the writes.
+	ErrSubscriptionLagging = errors.New("Subscription canceled, its callback is lagging behind")
+
 	// ErrEncryptionKeyMismatch is returned when the storage key is not
 	// matched with the key previously given.
 	ErrEncryptionKeyMismatch = errors.New("Encryption key mismatch")
@@ -124,4 +128,80 @@ var (
 		"either 16, 24, or 32 bytes")
 
 	ErrGCInMemoryMode = errors.New("Cannot run value log GC when DB is opened in InMemory mode")
+
+	// ErrComparatorMismatch is returned when a DB is opened with a comparator different from the
+	// one it was created with.
+	ErrComparatorMismatch = errors.New("Comparator mismatch")
+
+	// ErrNotSoftDeleted is returned by Txn.Undelete when the key can't be undeleted.
+	ErrNotSoftDeleted = errors.New("Key is not soft deleted")
+
+	// ErrKeyExists is returned by conditional writes when the key is expected not to exist.
+	ErrKeyExists = errors.New("Key already exists")
+
+	// ErrVersionMismatch is returned by conditional writes when the key doesn't have the version
+	// expected.
+	ErrVersionMismatch = errors.New("Key version mismatch")
+
+	// ErrSequenceRewind is returned by Sequence.SetNext when moving the sequence backwards.
+	ErrSequenceRewind = errors.New("Sequence cannot be moved backwards")
+
+	// ErrFileDigestMismatch is returned by DB.VerifyFiles when files don't match the digests
+	// recorded in the MANIFEST.
+	ErrFileDigestMismatch = errors.New("File doesn't match its digest in the MANIFEST")
+
+	// ErrAppendOnly is returned when deleting keys, writing keys which expire, or dropping or
+	// purging keys from a DB opened with Options.AppendOnly set.
+	ErrAppendOnly = errors.New("Keys cannot be deleted from an append-only DB")
+
+	// ErrFrozen is returned by DB.Freeze if the DB is frozen already, and by the operations which
+	// can't run while it's frozen.
+	ErrFrozen = errors.New("DB is frozen")
+
+	// ErrNotFrozen is returned by DB.Thaw if the DB isn't frozen.
+	ErrNotFrozen = errors.New("DB is not frozen")
+
+	// ErrOutsideMaintenanceWindow is returned by DB.Flatten and DB.RunValueLogGC when called
+	// outside of the Options.MaintenanceWindows.
+	ErrOutsideMaintenanceWindow = errors.New("Not within a maintenance window")
+
+	// ErrBackupKeyMismatch is returned when reading an encrypted backup with another key than the
+	// one it was encrypted with.
+	ErrBackupKeyMismatch = errors.New("Backup key doesn't match the key the backup was " +
+		"encrypted with")
+
+	// ErrBackupKeyMissing is returned when loading an encrypted backup without a backup key.
+	ErrBackupKeyMissing = errors.New("Backup is encrypted, LoadOptions.BackupKey must be set")
+
+	// ErrEncryptedManifest is returned by ReplayManifestFile for a MANIFEST encrypted with a data
+	// key, which ReplayManifestFileWithKeys reads.
+	ErrEncryptedManifest = errors.New("MANIFEST is encrypted, it can't be read without the " +
+		"key registry")
+
+	// ErrNoOriginalKey is returned by Item.OriginalKey for the deletions of hashed keys, which
+	// don't store the original key.
+	ErrNoOriginalKey = errors.New("The original key isn't stored with deletions")
+
+	// ErrFIPSModeMismatch is returned when opening a key registry created in FIPS mode without
+	// it, or the other way round.
+	ErrFIPSModeMismatch = errors.New("Key registry wasn't created in the same FIPS mode")
+
+	// ErrNoValueLog is returned by DB.ReplayState when the DB is opened in InMemory mode, which
+	// doesn't write a value log.
+	ErrNoValueLog = errors.New("DB opened in InMemory mode has no value log")
+
+	// ErrInvalidSavepoint is returned by Txn.RollbackTo for a savepoint taken by another
+	// transaction, or released by rolling back to an earlier savepoint.
+	ErrInvalidSavepoint = errors.New("Invalid savepoint")
+
+	// ErrSnapshotReleased is returned when reading a snapshot of DB.NewSnapshot after its release.
+	ErrSnapshotReleased = errors.New("Snapshot was released")
+
+	// ErrDatabaseFull is the cause of the *DatabaseFullError of setting keys while the DB is
+	// bigger than Options.MaxDatabaseSize.
+	ErrDatabaseFull = errors.New("Database is full")
+
+	// ErrPrefixLimit is the cause of the *PrefixLimitError of committing a transaction breaking
+	// one of Options.PrefixLimits.
+	ErrPrefixLimit = errors.New("Prefix limit exceeded")
 )
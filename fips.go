@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// fipsKeySize is the size of the master keys in FIPS mode, which rejects shorter keys.
+const fipsKeySize = 32
+
+// fipsSanityText and fipsWrappedSanityText replace sanityText and wrappedSanityText in the key
+// registries created in FIPS mode, so that they can't be opened without it.
+var (
+	fipsSanityText        = []byte("FIPS: Badger")
+	fipsWrappedSanityText = []byte("FIPS wrapped")
+)
+
+// registrySanityText returns the sanity text of the key registries, depending on whether their
+// data keys are wrapped by a KeyWrapper and whether they're created in FIPS mode.
+func registrySanityText(wrapped, fips bool) []byte {
+	switch {
+	case wrapped && fips:
+		return fipsWrappedSanityText
+	case wrapped:
+		return wrappedSanityText
+	case fips:
+		return fipsSanityText
+	default:
+		return sanityText
+	}
+}
+
+// validateFIPS checks that opt only involves FIPS-approved primitives in FIPS mode, and sets it up
+// for them.
+func validateFIPS(opt *KeyRegistryOptions) error {
+	if fipsBuild {
+		opt.FIPSMode = true
+	}
+	if !opt.FIPSMode {
+		return nil
+	}
+	if !opt.encrypted() {
+		return errors.New("FIPS mode requires encryption")
+	}
+	for _, key := range opt.SecondaryEncryptionKeys {
+		if len(key) != fipsKeySize {
+			return y.Wrapf(ErrInvalidEncryptionKey, "FIPS mode requires %d bytes keys",
+				fipsKeySize)
+		}
+	}
+	// The data keys always use AES-GCM, AES-CTR isn't authenticated.
+	opt.EncryptionAlgorithm = options.AESGCM
+	return nil
+}
+
+// checkFIPSKey checks the size of the master key key in FIPS mode.
+func (opt KeyRegistryOptions) checkFIPSKey(key []byte) error {
+	if opt.FIPSMode && len(key) > 0 && len(key) != fipsKeySize {
+		return y.Wrapf(ErrInvalidEncryptionKey, "FIPS mode requires %d bytes keys", fipsKeySize)
+	}
+	return nil
+}
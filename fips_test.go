@@ -0,0 +1,124 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIPSMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	key := bytes.Repeat([]byte("k"), 32)
+	opt := getTestOptions(dir).WithFIPSMode(true).WithEncryptionKey(key).
+		WithEncryptionAlgorithm(options.AESCTR)
+
+	// FIPS mode requires encryption, with 32 bytes keys.
+	_, err = Open(getTestOptions(dir).WithFIPSMode(true))
+	require.Error(t, err)
+	_, err = Open(opt.WithEncryptionKey(key[:16]))
+	require.Equal(t, ErrInvalidEncryptionKey, errors.Cause(err))
+	_, err = Open(opt.WithSecondaryEncryptionKeys([][]byte{key[:24]}))
+	require.Equal(t, ErrInvalidEncryptionKey, errors.Cause(err))
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	dk, err := db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, pb.EncryptionAlgo_aes_gcm, dk.Algo)
+	require.Equal(t, ErrInvalidEncryptionKey,
+		errors.Cause(db.RotateMasterKey(key, bytes.Repeat([]byte("n"), 16))))
+	require.NoError(t, db.Close())
+
+	// The mode is recorded in the key registry, the key alone doesn't open it.
+	require.NoError(t, VerifyEncryptionKey(dir, key))
+	_, err = Open(opt.WithFIPSMode(false))
+	require.Equal(t, ErrFIPSModeMismatch, errors.Cause(err))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("foo"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), getItemValue(t, item))
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	// Nor the other way round.
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db, err = Open(getTestOptions(dir2).WithEncryptionKey(key))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = Open(opt.WithDir(dir2).WithValueDir(dir2))
+	require.Equal(t, ErrFIPSModeMismatch, errors.Cause(err))
+}
+
+func TestFIPSModePassphrase(t *testing.T) {
+	defer func(p, f passphraseKDF) {
+		passphraseParams, fipsPassphraseParams = p, f
+	}(passphraseParams, fipsPassphraseParams)
+	passphraseParams = passphraseKDF{time: 1, memory: 64, threads: 1}
+	fipsPassphraseParams = passphraseKDF{time: 1000}
+
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithFIPSMode(true).
+		WithEncryptionPassphrase("correct horse battery staple")
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	require.NoError(t, db.Close())
+
+	// The key is derived with PBKDF2.
+	buf, err := ioutil.ReadFile(filepath.Join(dir, KeyRegistryFileName))
+	require.NoError(t, err)
+	kdf, err := readPassphraseKDF(bytes.NewReader(buf))
+	require.NoError(t, err)
+	require.True(t, kdf.pbkdf2())
+	require.Equal(t, uint32(1000), kdf.time)
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = Open(opt.WithFIPSMode(false))
+	require.Equal(t, ErrFIPSModeMismatch, errors.Cause(err))
+
+	// A key registry derived with Argon2id isn't opened in FIPS mode.
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db, err = Open(opt.WithFIPSMode(false).WithDir(dir2).WithValueDir(dir2))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = Open(opt.WithDir(dir2).WithValueDir(dir2))
+	require.Equal(t, ErrFIPSModeMismatch, errors.Cause(err))
+}
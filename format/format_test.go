@@ -0,0 +1,283 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package format_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/format"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	numKeys = 1000
+	// bitFinTxn is the meta bit of the entries marking the end of a transaction.
+	bitFinTxn = 1 << 7
+)
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+// writeDB writes numKeys keys to a new DB in dir, with every other value in the value log.
+func writeDB(t *testing.T, opt badger.Options) {
+	db, err := badger.Open(opt.WithValueThreshold(32).WithLogger(nil))
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	for i := 0; i < numKeys; i++ {
+		val := make([]byte, 8+(i%2)*64)
+		require.NoError(t, wb.Set(key(i), val))
+	}
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.Close())
+}
+
+func TestFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeDB(t, badger.DefaultOptions(dir).WithCompression(options.None))
+
+	t.Run("manifest", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(dir, badger.ManifestFilename))
+		require.NoError(t, err)
+		defer f.Close()
+		mr, err := format.NewManifestReader(f)
+		require.NoError(t, err)
+		require.Equal(t, uint32(format.ManifestVersion), mr.Version())
+
+		tables := make(map[uint64]bool)
+		for {
+			cs, err := mr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			for _, c := range cs.Changes {
+				tables[c.Id] = c.Op == pb.ManifestChange_CREATE
+			}
+		}
+		ssts, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+		require.NoError(t, err)
+		require.NotEmpty(t, ssts)
+		for _, sst := range ssts {
+			var id uint64
+			_, err := fmt.Sscanf(filepath.Base(sst), "%06d.sst", &id)
+			require.NoError(t, err)
+			require.True(t, tables[id], "table %d", id)
+		}
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		require.Equal(t, fi.Size(), mr.Offset())
+	})
+
+	t.Run("tables", func(t *testing.T) {
+		ssts, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+		require.NoError(t, err)
+		var count int
+		for _, sst := range ssts {
+			f, err := os.Open(sst)
+			require.NoError(t, err)
+			fi, err := f.Stat()
+			require.NoError(t, err)
+			footer, err := format.ReadTableFooter(f, fi.Size())
+			require.NoError(t, err)
+			data, err := format.ReadTableIndex(f, footer)
+			require.NoError(t, err)
+			index, err := format.ParseTableIndex(data)
+			require.NoError(t, err)
+			require.NotEmpty(t, index.Offsets)
+
+			for _, ko := range index.Offsets {
+				data, err := format.ReadBlock(f, ko)
+				require.NoError(t, err)
+				blk, err := format.ParseBlock(data)
+				require.NoError(t, err)
+				require.Equal(t, len(blk.EntryOffsets), len(blk.Entries))
+				require.Equal(t, ko.Key, blk.Entries[0].Key)
+				for _, e := range blk.Entries {
+					if bytes.HasPrefix(e.Key, []byte("!badger!")) {
+						continue
+					}
+					require.Equal(t, key(count), y.ParseKey(e.Key))
+					count++
+				}
+			}
+			require.NoError(t, f.Close())
+		}
+		require.Equal(t, numKeys, count)
+	})
+
+	t.Run("value log", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(dir, "000000.vlog"))
+		require.NoError(t, err)
+		defer f.Close()
+		vr, err := format.NewValueLogReader(f)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), vr.Header().KeyID)
+
+		keys := make(map[string]int)
+		for {
+			e, err := vr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			if e.Header.Meta&bitFinTxn == 0 {
+				keys[string(y.ParseKey(e.Key))] = len(e.Value)
+			}
+		}
+		require.Len(t, keys, numKeys)
+		for i := 0; i < numKeys; i++ {
+			require.Equal(t, 8+(i%2)*64, keys[string(key(i))])
+		}
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		require.Equal(t, fi.Size(), int64(vr.Offset()))
+	})
+
+	t.Run("key registry", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(dir, badger.KeyRegistryFileName))
+		require.NoError(t, err)
+		defer f.Close()
+		kr, err := format.NewKeyRegistryReader(f)
+		require.NoError(t, err)
+		require.False(t, kr.Encrypted())
+		_, err = kr.Next()
+		require.Equal(t, io.EOF, err)
+	})
+}
+
+func TestFormatEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeDB(t, badger.DefaultOptions(dir).WithEncryptionKey(make([]byte, 32)).
+		WithMaxCacheSize(1<<20))
+
+	f, err := os.Open(filepath.Join(dir, badger.KeyRegistryFileName))
+	require.NoError(t, err)
+	defer f.Close()
+	kr, err := format.NewKeyRegistryReader(f)
+	require.NoError(t, err)
+	require.True(t, kr.Encrypted())
+	keyIDs := make(map[uint64]bool)
+	for {
+		dk, err := kr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		keyIDs[dk.KeyId] = true
+	}
+	require.NotEmpty(t, keyIDs)
+
+	vf, err := os.Open(filepath.Join(dir, "000000.vlog"))
+	require.NoError(t, err)
+	defer vf.Close()
+	vr, err := format.NewValueLogReader(vf)
+	require.NoError(t, err)
+	require.True(t, keyIDs[vr.Header().KeyID])
+	e, err := vr.Next()
+	require.NoError(t, err)
+	require.Len(t, vr.Header().IV(e.Offset), 16)
+}
+
+func TestValueLogReaderCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeDB(t, badger.DefaultOptions(dir))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "000000.vlog"))
+	require.NoError(t, err)
+	vr, err := format.NewValueLogReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	e, err := vr.Next()
+	require.NoError(t, err)
+
+	// Corrupt the value of the second entry, and truncate the file in the middle of the third.
+	second := e.Offset + e.Size()
+	_, err = vr.Next()
+	require.NoError(t, err)
+	third := vr.Offset()
+	data[third-5]++
+	vr, err = format.NewValueLogReader(bytes.NewReader(data[:third+3]))
+	require.NoError(t, err)
+	_, err = vr.Next()
+	require.NoError(t, err)
+	_, err = vr.Next()
+	require.Equal(t, y.ErrChecksumMismatch, errors.Cause(err))
+	require.Equal(t, second, vr.Offset())
+
+	data[third-5]--
+	vr, err = format.NewValueLogReader(bytes.NewReader(data[:third+3]))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = vr.Next()
+		require.NoError(t, err)
+	}
+	_, err = vr.Next()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Equal(t, third, vr.Offset())
+}
+
+func TestValueLogReaderHeaderV0(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// With CRC32C, the header is the one of older versions, the key ID and the base IV only.
+	writeDB(t, badger.DefaultOptions(dir))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "000000.vlog"))
+	require.NoError(t, err)
+	vr, err := format.NewValueLogReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, byte(0), vr.Header().Version)
+	require.Equal(t, pb.Checksum_CRC32C, vr.Header().Checksum)
+	require.Equal(t, uint32(format.ValueLogHeaderSizeV0), vr.Offset())
+	_, err = vr.Next()
+	require.NoError(t, err)
+}
+
+func TestValueLogReaderHeaderV1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeDB(t, badger.DefaultOptions(dir).WithValueLogChecksumAlgorithm(options.XXHash64))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "000000.vlog"))
+	require.NoError(t, err)
+	vr, err := format.NewValueLogReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, format.ValueLogHeaderVersion, vr.Header().Version)
+	require.Equal(t, pb.Checksum_XXHash64, vr.Header().Checksum)
+	require.Equal(t, uint32(format.ValueLogHeaderSize), vr.Offset())
+	_, err = vr.Next()
+	require.NoError(t, err)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package format
+
+import (
+	"bufio"
+	"crypto/aes"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+)
+
+// KeyRegistrySanityText is the text at the start of the key registry, XORed with the master
+// key if there is one, used to check the master key.
+var KeyRegistrySanityText = []byte("Hello Badger")
+
+// KeyRegistryReader reads the data keys of a key registry file in order.
+//
+// The key registry starts with an IV and the sanity text, and is followed by records of
+// +------------+--------------+---------------------------+
+// | length     | crc32c       | pb.DataKey                |
+// +------------+--------------+---------------------------+
+// | 4 bytes BE | 4 bytes BE   | length bytes              |
+// +------------+--------------+---------------------------+
+// The data of every key is encrypted with the master key, per the algorithm of the key. The key
+// registry of a DB encrypted with a passphrase starts with 29 more bytes, holding the salt and
+// the parameters of the Argon2id derivation of the master key, which must be skipped first.
+type KeyRegistryReader struct {
+	r          *bufio.Reader
+	iv         []byte
+	sanityText []byte
+	offset     int64
+}
+
+// NewKeyRegistryReader reads the IV and sanity text of the key registry read by r, and returns
+// a reader for its data keys.
+func NewKeyRegistryReader(r io.Reader) (*KeyRegistryReader, error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, aes.BlockSize+len(KeyRegistrySanityText))
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, errors.Wrapf(err, "while reading key registry header")
+	}
+	return &KeyRegistryReader{
+		r:          br,
+		iv:         buf[:aes.BlockSize],
+		sanityText: buf[aes.BlockSize:],
+		offset:     int64(len(buf)),
+	}, nil
+}
+
+// IV returns the IV the sanity text is XORed with.
+func (kr *KeyRegistryReader) IV() []byte {
+	return kr.iv
+}
+
+// SanityText returns the sanity text as stored. It equals KeyRegistrySanityText if the
+// registry isn't encrypted.
+func (kr *KeyRegistryReader) SanityText() []byte {
+	return kr.sanityText
+}
+
+// Encrypted returns whether the registry is encrypted with a master key.
+func (kr *KeyRegistryReader) Encrypted() bool {
+	return string(kr.sanityText) != string(KeyRegistrySanityText)
+}
+
+// Offset returns the offset of the next record. After Next fails, it is the offset the valid
+// part of the registry ends at.
+func (kr *KeyRegistryReader) Offset() int64 {
+	return kr.offset
+}
+
+// Next returns the next data key of the registry, with its data as stored. It returns io.EOF
+// at the end of the registry, and io.ErrUnexpectedEOF if the last record is truncated.
+func (kr *KeyRegistryReader) Next() (*pb.DataKey, error) {
+	data, err := readRecord(kr.r)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return nil, err
+	case err != nil:
+		return nil, errors.Wrapf(err, "while reading data key at offset %d", kr.offset)
+	}
+	dk := &pb.DataKey{}
+	if err := dk.Unmarshal(data); err != nil {
+		return nil, errors.Wrapf(err, "while unmarshalling data key at offset %d", kr.offset)
+	}
+	kr.offset += int64(8 + len(data))
+	return dk, nil
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// ManifestMagic is the magic text at the start of the manifest file.
+var ManifestMagic = [4]byte{'B', 'd', 'g', 'r'}
+
+// ManifestVersion is the version of the manifest format described here.
+const ManifestVersion = 7
+
+// ManifestVersionEncrypted is the version of the manifests encrypted with a data key.
+const ManifestVersionEncrypted = 8
+
+// ManifestReader reads the change sets of a manifest file in order.
+//
+// The manifest starts with the magic text followed by the version as a big endian uint32,
+// and is followed by records of
+// +------------+--------------+---------------------------+
+// | length     | crc32c       | pb.ManifestChangeSet      |
+// +------------+--------------+---------------------------+
+// | 4 bytes BE | 4 bytes BE   | length bytes              |
+// +------------+--------------+---------------------------+
+// Encrypted manifests have the ID of their data key after the version, as a big endian uint64.
+// Their change sets are encrypted like the blocks of tables, followed by their IV or nonce, and
+// the crc32c is that of the encrypted change set.
+type ManifestReader struct {
+	r       *bufio.Reader
+	version uint32
+	keyID   uint64
+	offset  int64
+}
+
+// NewManifestReader reads the magic text and version of the manifest read by r, and returns a
+// reader for its change sets. The version isn't checked, so older manifests can be read too.
+func NewManifestReader(r io.Reader) (*ManifestReader, error) {
+	br := bufio.NewReader(r)
+	var buf [8]byte
+	if _, err := io.ReadFull(br, buf[:]); err != nil {
+		return nil, errors.Wrapf(err, "while reading manifest magic")
+	}
+	if !bytes.Equal(buf[:4], ManifestMagic[:]) {
+		return nil, errors.Wrapf(ErrCorrupt, "bad manifest magic %q", buf[:4])
+	}
+	mr := &ManifestReader{r: br, version: y.BytesToU32(buf[4:]), offset: 8}
+	if mr.version == ManifestVersionEncrypted {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return nil, errors.Wrapf(unexpectedEOF(err), "while reading manifest data key ID")
+		}
+		mr.keyID, mr.offset = binary.BigEndian.Uint64(buf[:]), 16
+	}
+	return mr, nil
+}
+
+// Version returns the version of the manifest.
+func (mr *ManifestReader) Version() uint32 {
+	return mr.version
+}
+
+// KeyID returns the ID of the data key the manifest is encrypted with, zero if it isn't.
+func (mr *ManifestReader) KeyID() uint64 {
+	return mr.keyID
+}
+
+// Offset returns the offset of the next record. After Next fails, it is the offset the valid
+// part of the manifest ends at.
+func (mr *ManifestReader) Offset() int64 {
+	return mr.offset
+}
+
+// Next returns the next change set of the manifest. It returns io.EOF at the end of the
+// manifest, and io.ErrUnexpectedEOF if the last record is truncated. Encrypted manifests are read
+// with NextRecord instead.
+func (mr *ManifestReader) Next() (*pb.ManifestChangeSet, error) {
+	if mr.keyID != 0 {
+		return nil, errors.Errorf("manifest is encrypted with data key %d", mr.keyID)
+	}
+	offset := mr.offset
+	data, err := mr.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	changeSet := &pb.ManifestChangeSet{}
+	if err := proto.Unmarshal(data, changeSet); err != nil {
+		return nil, errors.Wrapf(err, "while unmarshalling manifest record at offset %d",
+			offset)
+	}
+	return changeSet, nil
+}
+
+// NextRecord returns the next change set of the manifest as stored, encrypted if the manifest
+// is. It returns the same errors as Next.
+func (mr *ManifestReader) NextRecord() ([]byte, error) {
+	data, err := readRecord(mr.r)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return nil, err
+	case err != nil:
+		return nil, errors.Wrapf(err, "while reading manifest record at offset %d", mr.offset)
+	}
+	mr.offset += int64(8 + len(data))
+	return data, nil
+}
+
+// maxRecordSize bounds the records read by readRecord, so that a corrupted length doesn't
+// make it allocate a huge buffer.
+const maxRecordSize = 1 << 30
+
+// readRecord reads a record made of its length and crc32c as big endian uint32s, followed by
+// the data. io.EOF is only returned if there are no more records.
+func readRecord(r io.Reader) ([]byte, error) {
+	var lenCrcBuf [8]byte
+	if _, err := io.ReadFull(r, lenCrcBuf[:]); err != nil {
+		return nil, err
+	}
+	length := y.BytesToU32(lenCrcBuf[0:4])
+	if length > maxRecordSize {
+		return nil, errors.Wrapf(ErrCorrupt, "record length %d is too large", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if crc32.Checksum(data, y.CastagnoliCrcTable) != y.BytesToU32(lenCrcBuf[4:8]) {
+		return nil, y.ErrChecksumMismatch
+	}
+	return data, nil
+}
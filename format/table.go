@@ -0,0 +1,239 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package format exposes the on-disk structures of Badger, so that external tools can parse
+// tables, value log files, the manifest and the key registry without opening a DB.
+//
+// The readers in this package don't decrypt or decompress anything. Encrypted or compressed
+// data is returned as stored on disk, along with what is needed to decrypt it.
+package format
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// ErrCorrupt is returned when a structure can't be parsed out of the given data.
+var ErrCorrupt = errors.New("format: data is corrupted")
+
+// TableFooter is the footer at the end of every table. It locates the table index and holds
+// its checksum.
+//
+// The layout of a table is
+// +---------+-----+---------+-------+-----------+----------+--------------+
+// | block 1 | ... | block n | index | index len | checksum | checksum len |
+// +---------+-----+---------+-------+-----------+----------+--------------+
+// where both lengths are big endian uint32s, and the index is a pb.TableIndex, encrypted if
+// the table is. Tables whose MANIFEST entry has values_only set keep the blocks and the index in
+// plain text, and encrypt every non-empty value instead, followed by its IV or nonce.
+type TableFooter struct {
+	IndexOffset int64
+	IndexLen    uint32
+	Checksum    pb.Checksum
+}
+
+// ReadTableFooter reads the footer of the table of the given size.
+func ReadTableFooter(r io.ReaderAt, size int64) (*TableFooter, error) {
+	readPos := size - 4
+	buf, err := readAt(r, readPos, 4)
+	if err != nil {
+		return nil, err
+	}
+	checksumLen := int64(y.BytesToU32(buf))
+	readPos -= checksumLen
+	if readPos < 4 {
+		return nil, errors.Wrapf(ErrCorrupt, "invalid table checksum length %d", checksumLen)
+	}
+	if buf, err = readAt(r, readPos, int(checksumLen)); err != nil {
+		return nil, err
+	}
+	f := &TableFooter{}
+	if err := proto.Unmarshal(buf, &f.Checksum); err != nil {
+		return nil, errors.Wrapf(err, "while unmarshalling table checksum")
+	}
+	readPos -= 4
+	if buf, err = readAt(r, readPos, 4); err != nil {
+		return nil, err
+	}
+	f.IndexLen = y.BytesToU32(buf)
+	f.IndexOffset = readPos - int64(f.IndexLen)
+	if f.IndexOffset < 0 {
+		return nil, errors.Wrapf(ErrCorrupt, "invalid table index length %d", f.IndexLen)
+	}
+	return f, nil
+}
+
+// ReadTableIndex reads the index located by the footer and verifies its checksum. The index
+// is returned as stored, call ParseTableIndex on it once it is decrypted.
+func ReadTableIndex(r io.ReaderAt, f *TableFooter) ([]byte, error) {
+	data, err := readAt(r, f.IndexOffset, int(f.IndexLen))
+	if err != nil {
+		return nil, err
+	}
+	if err := y.VerifyChecksum(data, &f.Checksum); err != nil {
+		return nil, errors.Wrapf(err, "while verifying table index")
+	}
+	return data, nil
+}
+
+// ParseTableIndex unmarshals a plaintext table index.
+func ParseTableIndex(data []byte) (*pb.TableIndex, error) {
+	index := &pb.TableIndex{}
+	if err := proto.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrapf(err, "while unmarshalling table index")
+	}
+	return index, nil
+}
+
+// ReadBlock reads the block at the given offset of the table, as stored.
+func ReadBlock(r io.ReaderAt, ko *pb.BlockOffset) ([]byte, error) {
+	return readAt(r, int64(ko.Offset), int(ko.Len))
+}
+
+// Block is a parsed table block.
+//
+// The layout of a block is
+// +-----------+-----+-----------+---------------+-------------+----------+--------------+
+// | entry 1   | ... | entry n   | entry offsets | num entries | checksum | checksum len |
+// +-----------+-----+-----------+---------------+-------------+----------+--------------+
+// where the entry offsets are uint32s in native byte order, and the checksum covers everything
+// before it.
+type Block struct {
+	Entries      []BlockEntry
+	EntryOffsets []uint32
+	Checksum     pb.Checksum
+}
+
+// BlockEntry is an entry of a block. The key of an entry shares its first Overlap bytes with
+// the first key of the block, which are followed by Diff bytes of its own.
+type BlockEntry struct {
+	Overlap   uint32
+	Diff      uint32
+	Key       []byte
+	Meta      byte
+	UserMeta  byte
+	ExpiresAt uint64
+	Value     []byte
+}
+
+const (
+	headerSize     = 4
+	largeKeyMarker = 0xFFFF
+)
+
+// ParseBlock parses a decrypted and decompressed block, and verifies its checksum.
+func ParseBlock(data []byte) (*Block, error) {
+	readPos := len(data) - 4
+	if readPos < 0 {
+		return nil, errors.Wrapf(ErrCorrupt, "block of %d bytes is too short", len(data))
+	}
+	chkLen := int(y.BytesToU32(data[readPos:]))
+	readPos -= chkLen
+	if readPos < 4 {
+		return nil, errors.Wrapf(ErrCorrupt, "invalid block checksum length %d", chkLen)
+	}
+	b := &Block{}
+	if err := proto.Unmarshal(data[readPos:readPos+chkLen], &b.Checksum); err != nil {
+		return nil, errors.Wrapf(err, "while unmarshalling block checksum")
+	}
+	if err := y.VerifyChecksum(data[:readPos], &b.Checksum); err != nil {
+		return nil, errors.Wrapf(err, "while verifying block")
+	}
+	readPos -= 4
+	numEntries := int(y.BytesToU32(data[readPos:]))
+	entriesEnd := readPos - numEntries*4
+	if numEntries > readPos/4 {
+		return nil, errors.Wrapf(ErrCorrupt, "invalid number of block entries %d", numEntries)
+	}
+	b.EntryOffsets = append([]uint32{}, y.BytesToU32Slice(data[entriesEnd:readPos])...)
+
+	var baseKey []byte
+	for i, offset := range b.EntryOffsets {
+		end := uint32(entriesEnd)
+		if i+1 < len(b.EntryOffsets) {
+			end = b.EntryOffsets[i+1]
+		}
+		if offset > end || end > uint32(entriesEnd) {
+			return nil, errors.Wrapf(ErrCorrupt, "invalid offset %d of block entry %d", offset, i)
+		}
+		e, err := parseBlockEntry(data[offset:end], baseKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while parsing block entry %d", i)
+		}
+		if i == 0 {
+			baseKey = e.Key
+		}
+		b.Entries = append(b.Entries, e)
+	}
+	return b, nil
+}
+
+func parseBlockEntry(buf, baseKey []byte) (BlockEntry, error) {
+	var e BlockEntry
+	if len(buf) < headerSize {
+		return e, ErrCorrupt
+	}
+	// The header holds the overlap and diff as uint16s in native byte order. Copy it over
+	// instead of casting buf to avoid pointer alignment issues.
+	var h [2]uint16
+	copy((*[headerSize]byte)(unsafe.Pointer(&h))[:], buf)
+	overlap, diff := h[0], h[1]
+	e.Overlap, e.Diff = uint32(overlap), uint32(diff)
+	buf = buf[headerSize:]
+	if overlap == largeKeyMarker && diff == largeKeyMarker {
+		if len(buf) < 8 {
+			return e, ErrCorrupt
+		}
+		e.Overlap, e.Diff = y.BytesToU32(buf), y.BytesToU32(buf[4:])
+		buf = buf[8:]
+	}
+	if int(e.Overlap) > len(baseKey) || int(e.Diff) > len(buf) {
+		return e, ErrCorrupt
+	}
+	e.Key = make([]byte, 0, e.Overlap+e.Diff)
+	e.Key = append(e.Key, baseKey[:e.Overlap]...)
+	e.Key = append(e.Key, buf[:e.Diff]...)
+	buf = buf[e.Diff:]
+
+	// The rest is the encoded y.ValueStruct.
+	if len(buf) < 3 {
+		return e, ErrCorrupt
+	}
+	e.Meta, e.UserMeta = buf[0], buf[1]
+	var sz int
+	if e.ExpiresAt, sz = binary.Uvarint(buf[2:]); sz <= 0 {
+		return e, ErrCorrupt
+	}
+	e.Value = buf[2+sz:]
+	return e, nil
+}
+
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, errors.Wrapf(ErrCorrupt, "invalid read of %d bytes at offset %d", n, off)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil, errors.Wrapf(err, "while reading %d bytes at offset %d", n, off)
+	}
+	return buf, nil
+}
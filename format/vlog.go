@@ -0,0 +1,296 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package format
+
+import (
+	"bufio"
+	"crypto/aes"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// ValueLogHeaderSize is the size of the header at the start of the value log files, and
+// ValueLogHeaderSizeV0 the size of the header of the files using CRC32C or written by older
+// versions.
+const (
+	ValueLogHeaderSize   = 24
+	ValueLogHeaderSizeV0 = 20
+)
+
+// ValueLogMagic is the magic number the header of a value log file starts with, and
+// ValueLogHeaderVersion the latest version of the header.
+const (
+	ValueLogMagic         uint16 = 0xbadc
+	ValueLogHeaderVersion byte   = 1
+)
+
+// ValueLogHeader is the header of a value log file.
+//
+// The layout of the header is
+// +---------+---------+--------------------+--------------+----------+
+// | magic   | version | checksum algorithm | key id       | base IV  |
+// +---------+---------+--------------------+--------------+----------+
+// | 2 bytes | 1 byte  | 1 byte             | 8 bytes (BE) | 12 bytes |
+// +---------+---------+--------------------+--------------+----------+
+// The files using CRC32C, and those written by older versions, have neither magic nor version nor
+// checksum algorithm, their header is version 0:
+// +--------------+----------+
+// | key id       | base IV  |
+// +--------------+----------+
+// | 8 bytes (BE) | 12 bytes |
+// +--------------+----------+
+type ValueLogHeader struct {
+	Version  byte
+	Checksum pb.Checksum_Algorithm
+	// KeyID is the ID of the data key the entries are encrypted with, zero if they aren't.
+	KeyID  uint64
+	BaseIV []byte
+}
+
+// ParseValueLogHeader parses the header at the start of buf, the first bytes of a value log file.
+func ParseValueLogHeader(buf []byte) (*ValueLogHeader, error) {
+	if len(buf) < ValueLogHeaderSizeV0 {
+		return nil, errors.Wrapf(ErrCorrupt, "value log header of %d bytes is too short", len(buf))
+	}
+	if binary.BigEndian.Uint16(buf) != ValueLogMagic {
+		return &ValueLogHeader{
+			Checksum: pb.Checksum_CRC32C,
+			KeyID:    binary.BigEndian.Uint64(buf[:8]),
+			BaseIV:   append([]byte{}, buf[8:ValueLogHeaderSizeV0]...),
+		}, nil
+	}
+	if len(buf) < ValueLogHeaderSize {
+		return nil, errors.Wrapf(ErrCorrupt, "value log header of %d bytes is too short", len(buf))
+	}
+	h := &ValueLogHeader{
+		Version:  buf[2],
+		Checksum: pb.Checksum_Algorithm(buf[3]),
+		KeyID:    binary.BigEndian.Uint64(buf[4:12]),
+		BaseIV:   append([]byte{}, buf[12:ValueLogHeaderSize]...),
+	}
+	if h.Version != ValueLogHeaderVersion {
+		return nil, errors.Wrapf(ErrCorrupt, "unknown value log header version %d", h.Version)
+	}
+	if h.Checksum > pb.Checksum_NONE {
+		return nil, errors.Wrapf(ErrCorrupt, "unknown checksum algorithm %d", buf[3])
+	}
+	return h, nil
+}
+
+// Size returns the size of the header in the value log file.
+func (h *ValueLogHeader) Size() uint32 {
+	if h.Version == 0 {
+		return ValueLogHeaderSizeV0
+	}
+	return ValueLogHeaderSize
+}
+
+// IV returns the IV the key and value of the entry at the given offset are encrypted with,
+// using AES in CTR mode.
+func (h *ValueLogHeader) IV(offset uint32) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, h.BaseIV)
+	binary.BigEndian.PutUint32(iv[12:], offset)
+	return iv
+}
+
+// EntryHeader is the header of a value log entry.
+//
+// The layout of an entry is
+// +--------+-----+-------+----------+
+// | header | key | value | checksum |
+// +--------+-----+-------+----------+
+// where the header holds the meta and user meta bytes followed by the key length, value
+// length and expiry as uvarints, and the checksum is a big endian uint32 over the rest.
+type EntryHeader struct {
+	Meta      byte
+	UserMeta  byte
+	KeyLen    uint32
+	ValueLen  uint32
+	ExpiresAt uint64
+}
+
+// MaxEntryHeaderSize is the maximum size of an encoded EntryHeader.
+const MaxEntryHeaderSize = 21
+
+// DecodeEntryHeader decodes the entry header at the start of buf, returning its size.
+func DecodeEntryHeader(buf []byte) (EntryHeader, int, error) {
+	var h EntryHeader
+	if len(buf) < 2 {
+		return h, 0, ErrCorrupt
+	}
+	h.Meta, h.UserMeta = buf[0], buf[1]
+	index := 2
+	var vals [3]uint64
+	for i := range vals {
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return h, 0, ErrCorrupt
+		}
+		vals[i] = v
+		index += n
+	}
+	h.KeyLen, h.ValueLen, h.ExpiresAt = uint32(vals[0]), uint32(vals[1]), vals[2]
+	return h, index, nil
+}
+
+// ValueLogEntry is an entry read from a value log file. The key and value are returned as
+// stored, so they are encrypted if the file is.
+type ValueLogEntry struct {
+	Offset   uint32
+	Header   EntryHeader
+	Key      []byte
+	Value    []byte
+	Checksum uint32
+}
+
+// Size returns the size of the entry in the value log file.
+func (e *ValueLogEntry) Size() uint32 {
+	var buf [MaxEntryHeaderSize]byte
+	sz := 2 + binary.PutUvarint(buf[:], uint64(e.Header.KeyLen)) +
+		binary.PutUvarint(buf[:], uint64(e.Header.ValueLen)) +
+		binary.PutUvarint(buf[:], e.Header.ExpiresAt)
+	return uint32(sz) + e.Header.KeyLen + e.Header.ValueLen + 4
+}
+
+// ValueLogReader reads the entries of a value log file in order.
+type ValueLogReader struct {
+	r      *bufio.Reader
+	header *ValueLogHeader
+	offset uint32
+	// MaxKeySize bounds the key length of entries, so that garbage at the end of a file isn't
+	// read as a huge entry. It defaults to the largest key size Badger accepts.
+	MaxKeySize uint32
+}
+
+// NewValueLogReader reads the header of the value log file read by r, and returns a reader
+// for its entries.
+func NewValueLogReader(r io.Reader) (*ValueLogReader, error) {
+	br := bufio.NewReader(r)
+	buf, err := br.Peek(ValueLogHeaderSize)
+	if err != nil && len(buf) < ValueLogHeaderSizeV0 {
+		if len(buf) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.Wrapf(err, "while reading value log header")
+	}
+	h, err := ParseValueLogHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := br.Discard(int(h.Size())); err != nil {
+		return nil, errors.Wrapf(err, "while reading value log header")
+	}
+	return &ValueLogReader{
+		r:          br,
+		header:     h,
+		offset:     h.Size(),
+		MaxKeySize: 1<<20 + 1<<10,
+	}, nil
+}
+
+// Header returns the header of the value log file.
+func (vr *ValueLogReader) Header() *ValueLogHeader {
+	return vr.header
+}
+
+// Offset returns the offset of the next entry. After Next fails, it is the offset the valid
+// part of the file ends at.
+func (vr *ValueLogReader) Offset() uint32 {
+	return vr.offset
+}
+
+// Next returns the next entry of the file. It returns io.EOF at the end of the file, and
+// io.ErrUnexpectedEOF or y.ErrChecksumMismatch if the entry at Offset is truncated or corrupt.
+func (vr *ValueLogReader) Next() (*ValueLogEntry, error) {
+	hash := y.NewHash32(vr.header.Checksum)
+	tee := &hashByteReader{r: vr.r, h: hash}
+	e := &ValueLogEntry{Offset: vr.offset}
+	var err error
+	if e.Header, err = readEntryHeader(tee); err != nil {
+		return nil, err
+	}
+	if e.Header.KeyLen > vr.MaxKeySize {
+		return nil, errors.Wrapf(ErrCorrupt, "key length %d of entry at offset %d is too large",
+			e.Header.KeyLen, e.Offset)
+	}
+	buf := make([]byte, int(e.Header.KeyLen)+int(e.Header.ValueLen))
+	if _, err := io.ReadFull(tee, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	e.Key, e.Value = buf[:e.Header.KeyLen], buf[e.Header.KeyLen:]
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(vr.r, crcBuf[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if e.Checksum = y.BytesToU32(crcBuf[:]); e.Checksum != hash.Sum32() {
+		return nil, errors.Wrapf(y.ErrChecksumMismatch, "entry at offset %d", e.Offset)
+	}
+	vr.offset += e.Size()
+	return e, nil
+}
+
+func readEntryHeader(r *hashByteReader) (EntryHeader, error) {
+	var h EntryHeader
+	var err error
+	if h.Meta, err = r.ReadByte(); err != nil {
+		return h, err
+	}
+	if h.UserMeta, err = r.ReadByte(); err != nil {
+		return h, unexpectedEOF(err)
+	}
+	var vals [3]uint64
+	for i := range vals {
+		if vals[i], err = binary.ReadUvarint(r); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	h.KeyLen, h.ValueLen, h.ExpiresAt = uint32(vals[0]), uint32(vals[1]), vals[2]
+	return h, nil
+}
+
+// hashByteReader hashes everything read through it.
+type hashByteReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (t *hashByteReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	y.Check2(t.h.Write(p[:n]))
+	return n, err
+}
+
+func (t *hashByteReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		y.Check2(t.h.Write([]byte{b}))
+	}
+	return b, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
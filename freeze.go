@@ -0,0 +1,153 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync"
+)
+
+// freezer holds the state of DB.Freeze.
+type freezer struct {
+	sync.Mutex
+	thaw     func() error   // Resumes the work paused by Freeze. Nil unless the DB is frozen.
+	freezing bool           // Set while Freeze waits for the running rewrites to finish.
+	rewrites sync.WaitGroup // The running operations rewriting files, see startRewrite.
+}
+
+// startRewrite must be called before operations rewriting or deleting files on demand, like
+// DropAll or Flatten. It returns ErrFrozen if the DB is frozen, and otherwise keeps Freeze from
+// proceeding until the returned function is called.
+func (db *DB) startRewrite() (func(), error) {
+	db.freezer.Lock()
+	defer db.freezer.Unlock()
+	if db.freezer.thaw != nil || db.freezer.freezing {
+		return nil, ErrFrozen
+	}
+	db.freezer.rewrites.Add(1)
+	return db.freezer.rewrites.Done, nil
+}
+
+// Freeze brings the files of the DB to a consistent state and keeps them unchanged until Thaw is
+// called, so that a snapshot of the volume holding them, e.g. with LVM or EBS, can be taken in
+// the meantime and opened as is.
+//
+// Freeze waits for the running operations rewriting files on demand, such as DropAll, Flatten,
+// CompactRange or StreamWriter, blocks new writes, waits for the pending ones, flushes the
+// memtables to level 0 and syncs the files. Compactions stop, value log GC waits for Thaw, and no
+// file gets deleted. Writes fail with ErrBlockedWrites while the DB is frozen, and the operations
+// rewriting files fail with ErrFrozen, but reads aren't affected. Freeze returns ErrFrozen if the
+// DB is frozen already. Close thaws the DB.
+func (db *DB) Freeze() error {
+	if db.opt.InMemory {
+		return ErrInvalidRequest
+	}
+	db.freezer.Lock()
+	if db.freezer.thaw != nil || db.freezer.freezing {
+		db.freezer.Unlock()
+		return ErrFrozen
+	}
+	db.freezer.freezing = true
+	db.freezer.Unlock()
+	db.freezer.rewrites.Wait()
+
+	db.freezer.Lock()
+	defer func() {
+		db.freezer.freezing = false
+		db.freezer.Unlock()
+	}()
+	if db.opt.ReadOnly {
+		// Nothing changes the files of a read-only DB.
+		db.freezer.thaw = func() error { return nil }
+		return nil
+	}
+
+	db.opt.Infof("Freezing DB. Blocking writes...")
+	resume := db.prepareToDrop()
+	if err := db.flushMemtables(); err != nil {
+		resume()
+		return err
+	}
+	db.stopCompactions()
+	// Wait for a running value log GC, and keep the next ones from running.
+	db.vlog.garbageCh <- struct{}{}
+	// Value log files only get deleted once no iterator needs them.
+	db.vlog.incrIteratorCount()
+	db.freezer.thaw = func() error {
+		err := db.vlog.decrIteratorCount()
+		<-db.vlog.garbageCh
+		db.startCompactions()
+		resume()
+		return err
+	}
+
+	if err := db.vlog.sync(math.MaxUint32); err != nil {
+		db.opt.Errorf("While syncing value log when freezing DB: %v", err)
+		_ = db.thaw()
+		return err
+	}
+	for _, dir := range []string{db.opt.Dir, db.opt.ValueDir} {
+		if err := db.syncDir(dir); err != nil {
+			_ = db.thaw()
+			return err
+		}
+	}
+	db.opt.Infof("DB frozen")
+	return nil
+}
+
+// Thaw resumes the writes and the background work paused by Freeze. It returns ErrNotFrozen if the
+// DB isn't frozen.
+func (db *DB) Thaw() error {
+	db.freezer.Lock()
+	defer db.freezer.Unlock()
+	if db.freezer.thaw == nil {
+		return ErrNotFrozen
+	}
+	db.opt.Infof("Thawing DB")
+	return db.thaw()
+}
+
+// thaw must be called with the freezer locked.
+func (db *DB) thaw() error {
+	err := db.freezer.thaw()
+	db.freezer.thaw = nil
+	return err
+}
+
+// flushMemtables writes all the memtables to level 0. Memtable flushes must be stopped.
+func (db *DB) flushMemtables() error {
+	db.Lock()
+	defer db.Unlock()
+	// The flushes stopped after writing the memtables queued, so db.imm is most likely empty.
+	for len(db.imm) > 0 {
+		// The head of the value log is past the entries of the memtable being written, so a zero
+		// head gets stored instead: the whole value log gets replayed if Badger crashes before
+		// the latest memtable gets written.
+		if err := db.handleFlushTask(flushTask{mt: db.imm[0]}); err != nil {
+			return err
+		}
+		db.imm[0].DecrRef()
+		db.imm = db.imm[1:]
+	}
+	if err := db.handleFlushTask(flushTask{mt: db.mt, vptr: db.vhead}); err != nil {
+		return err
+	}
+	db.mt.DecrRef()
+	db.mt = newSkiplist(db.opt)
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	snapshot, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(snapshot)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%03d", i)), []byte("value"), 0)
+	}
+
+	require.NoError(t, db.Freeze())
+	require.True(t, db.mt.Empty())
+	require.NotEmpty(t, db.Tables(false))
+	require.Equal(t, ErrFrozen, db.Freeze())
+	err = db.Update(func(txn *Txn) error { return txn.Set([]byte("frozen"), nil) })
+	require.Equal(t, ErrBlockedWrites, err)
+	require.Equal(t, ErrFrozen, db.DropAll())
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("key042"))
+		return err
+	}))
+
+	// Copy the files, like a snapshot of the volume would.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range files {
+		if f.Name() == lockFile {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(snapshot, f.Name()), data, 0666))
+	}
+
+	require.NoError(t, db.Thaw())
+	require.Equal(t, ErrNotFrozen, db.Thaw())
+	txnSet(t, db, []byte("thawed"), nil, 0)
+	require.NoError(t, db.Freeze())
+	require.NoError(t, db.Close())
+
+	db, err = Open(getTestOptions(snapshot))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if _, err := txn.Get([]byte(fmt.Sprintf("key%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func TestFreezeWaitsForRewrites(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		done, err := db.startRewrite()
+		require.NoError(t, err)
+		frozen := make(chan error, 1)
+		go func() { frozen <- db.Freeze() }()
+
+		// Freeze doesn't proceed while a rewrite runs, and no other rewrite can start.
+		select {
+		case err := <-frozen:
+			t.Fatalf("Freeze returned %v while a rewrite was running", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		require.Equal(t, ErrFrozen, db.Flatten(1))
+		done()
+		require.NoError(t, <-frozen)
+		require.Equal(t, ErrFrozen, db.DropPrefix([]byte("key")))
+		require.NoError(t, db.Thaw())
+		require.NoError(t, db.Flatten(1))
+	})
+}
@@ -0,0 +1,354 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package geo encodes locations into keys which sort by geohash, and finds the keys located in a
+// box or within a distance of a point with a few range scans.
+//
+// A location is encoded as the 64 bits of its geohash, interleaving the bits of its quantized
+// longitude and latitude, so that nearby locations mostly get nearby keys. Queries cover the
+// area they search with geohash cells, scan the ranges of keys of these cells, and filter out the
+// locations outside the area:
+//
+//	err := db.Update(func(txn *badger.Txn) error {
+//		return txn.Set(geo.Key([]byte("shops/"), geo.Point{Lat: 48.85, Lng: 2.35}, shopID), nil)
+//	})
+//	...
+//	err = db.View(func(txn *badger.Txn) error {
+//		return geo.WithinRadius(txn, []byte("shops/"), here, 1000,
+//			func(item *badger.Item, p geo.Point, id []byte) error {
+//				...
+//			})
+//	})
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// EarthRadius is the mean radius of the Earth in meters, used to compute distances.
+const EarthRadius = 6371008.8
+
+// DefaultMaxCells is the maximum number of cells used to cover the area of a query.
+const DefaultMaxCells = 16
+
+// Point is a location in degrees.
+type Point struct {
+	Lat, Lng float64
+}
+
+// Box is the area between two latitudes and two longitudes, in degrees. Boxes crossing the
+// antimeridian have MinLng greater than MaxLng.
+type Box struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// Contains returns whether p is in b.
+func (b Box) Contains(p Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+}
+
+func quantize(v, min, max float64) uint32 {
+	f := (v - min) / (max - min) * (1 << 32)
+	if f <= 0 {
+		return 0
+	}
+	if f >= 1<<32-1 {
+		return 1<<32 - 1
+	}
+	return uint32(f)
+}
+
+// interleave returns the bits of x and y interleaved, starting with the most significant bit of x.
+func interleave(x, y uint32) uint64 {
+	var h uint64
+	for i := 31; i >= 0; i-- {
+		h = h<<2 | uint64(x>>uint(i)&1)<<1 | uint64(y>>uint(i)&1)
+	}
+	return h
+}
+
+func deinterleave(h uint64) (x, y uint32) {
+	for i := 31; i >= 0; i-- {
+		x = x<<1 | uint32(h>>uint(2*i+1)&1)
+		y = y<<1 | uint32(h>>uint(2*i)&1)
+	}
+	return x, y
+}
+
+// Encode returns the 64 bits geohash of p, which is precise to about a centimeter.
+func Encode(p Point) uint64 {
+	return interleave(quantize(p.Lng, -180, 180), quantize(p.Lat, -90, 90))
+}
+
+// Decode returns the location at the center of the cell of the 64 bits geohash h.
+func Decode(h uint64) Point {
+	lng, lat := deinterleave(h)
+	return Point{
+		Lat: (float64(lat)+0.5)/(1<<32)*180 - 90,
+		Lng: (float64(lng)+0.5)/(1<<32)*360 - 180,
+	}
+}
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Geohash returns the geohash of p as a string of the given number of characters, at most 12.
+func Geohash(p Point, precision int) string {
+	if precision > 12 {
+		precision = 12
+	}
+	h := Encode(p)
+	buf := make([]byte, precision)
+	for i := range buf {
+		buf[i] = base32[h>>uint(59-5*i)&31]
+	}
+	return string(buf)
+}
+
+// Key returns the key of the location p for the given ID under prefix. The same ID may be located
+// at several points.
+func Key(prefix []byte, p Point, id []byte) []byte {
+	key := make([]byte, len(prefix)+8+len(id))
+	n := copy(key, prefix)
+	binary.BigEndian.PutUint64(key[n:], Encode(p))
+	copy(key[n+8:], id)
+	return key
+}
+
+// ParseKey returns the location and ID of key, a key returned by Key for the given prefix.
+func ParseKey(prefix, key []byte) (Point, []byte, error) {
+	if !bytes.HasPrefix(key, prefix) || len(key) < len(prefix)+8 {
+		return Point{}, nil, errors.Errorf("Invalid location key %q", key)
+	}
+	p := Decode(binary.BigEndian.Uint64(key[len(prefix):]))
+	return p, key[len(prefix)+8:], nil
+}
+
+// Range is a range of geohashes, First and Last included.
+type Range struct {
+	First, Last uint64
+}
+
+// cell is a geohash cell: the geohashes starting with the level*2 bits of prefix.
+type cell struct {
+	prefix uint64
+	level  uint
+}
+
+func (c cell) rng() Range {
+	if c.level == 0 {
+		return Range{0, math.MaxUint64}
+	}
+	shift := 64 - 2*c.level
+	return Range{c.prefix << shift, c.prefix<<shift | (1<<shift - 1)}
+}
+
+// bounds returns the quantized longitudes and latitudes of c, inclusive.
+func (c cell) bounds() (minX, maxX, minY, maxY uint32) {
+	r := c.rng()
+	minX, minY = deinterleave(r.First)
+	maxX, maxY = deinterleave(r.Last)
+	return
+}
+
+// qbox is a box of quantized longitudes and latitudes, inclusive.
+type qbox struct {
+	minX, maxX, minY, maxY uint32
+}
+
+func (b qbox) intersects(c cell) bool {
+	minX, maxX, minY, maxY := c.bounds()
+	return minX <= b.maxX && maxX >= b.minX && minY <= b.maxY && maxY >= b.minY
+}
+
+func (b qbox) contains(c cell) bool {
+	minX, maxX, minY, maxY := c.bounds()
+	return minX >= b.minX && maxX <= b.maxX && minY >= b.minY && maxY <= b.maxY
+}
+
+// qboxes returns the quantized boxes of b, two of them if it crosses the antimeridian.
+func qboxes(b Box) []qbox {
+	minY, maxY := quantize(b.MinLat, -90, 90), quantize(b.MaxLat, -90, 90)
+	if b.MinLng <= b.MaxLng {
+		return []qbox{{quantize(b.MinLng, -180, 180), quantize(b.MaxLng, -180, 180), minY, maxY}}
+	}
+	return []qbox{
+		{quantize(b.MinLng, -180, 180), 1<<32 - 1, minY, maxY},
+		{0, quantize(b.MaxLng, -180, 180), minY, maxY},
+	}
+}
+
+// Cover returns sorted, disjoint ranges of geohashes holding all the locations in b, from at most
+// maxCells cells. More cells give a tighter cover, scanning fewer locations outside of b.
+func Cover(b Box, maxCells int) []Range {
+	if maxCells < 4 {
+		maxCells = 4
+	}
+	boxes := qboxes(b)
+	intersects := func(c cell) bool {
+		for _, qb := range boxes {
+			if qb.intersects(c) {
+				return true
+			}
+		}
+		return false
+	}
+	contained := func(c cell) bool {
+		for _, qb := range boxes {
+			if qb.contains(c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Split the cells, largest first, for as long as there's room for their children.
+	var done []cell
+	todo := []cell{{}}
+	for len(todo) > 0 {
+		c := todo[0]
+		if contained(c) || c.level == 32 {
+			done = append(done, c)
+			todo = todo[1:]
+			continue
+		}
+		var children []cell
+		for i := uint64(0); i < 4; i++ {
+			if child := (cell{c.prefix<<2 | i, c.level + 1}); intersects(child) {
+				children = append(children, child)
+			}
+		}
+		if len(done)+len(todo)-1+len(children) > maxCells {
+			break
+		}
+		todo = append(todo[1:], children...)
+	}
+	done = append(done, todo...)
+
+	ranges := make([]Range, len(done))
+	for i, c := range done {
+		ranges[i] = c.rng()
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].First < ranges[j].First })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if last.Last+1 == r.First {
+			last.Last = r.Last
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// Distance returns the great-circle distance between a and b in meters.
+func Distance(a, b Point) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLng := (b.Lng - a.Lng) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBox returns a box holding all the locations within meters of center.
+func BoundingBox(center Point, meters float64) Box {
+	dLat := meters / EarthRadius * 180 / math.Pi
+	b := Box{
+		MinLat: math.Max(center.Lat-dLat, -90),
+		MaxLat: math.Min(center.Lat+dLat, 90),
+		MinLng: -180,
+		MaxLng: 180,
+	}
+	if b.MinLat == -90 || b.MaxLat == 90 {
+		return b // The box holds a pole, and all the longitudes.
+	}
+	// The longitude span is the widest at the latitude farthest from the equator.
+	lat := math.Max(math.Abs(b.MinLat), math.Abs(b.MaxLat)) * math.Pi / 180
+	dLng := dLat / math.Cos(lat)
+	if dLng >= 180 {
+		return b
+	}
+	b.MinLng, b.MaxLng = center.Lng-dLng, center.Lng+dLng
+	if b.MinLng < -180 {
+		b.MinLng += 360
+	}
+	if b.MaxLng > 180 {
+		b.MaxLng -= 360
+	}
+	return b
+}
+
+// scan calls fn with the locations under prefix within ranges and accepted by filter.
+func scan(txn *badger.Txn, prefix []byte, ranges []Range, filter func(Point) bool,
+	fn func(item *badger.Item, p Point, id []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	start := make([]byte, len(prefix)+8)
+	copy(start, prefix)
+	for _, r := range ranges {
+		binary.BigEndian.PutUint64(start[len(prefix):], r.First)
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if len(key) < len(prefix)+8 {
+				continue
+			}
+			h := binary.BigEndian.Uint64(key[len(prefix):])
+			if h > r.Last {
+				break
+			}
+			if p := Decode(h); filter(p) {
+				if err := fn(item, p, key[len(prefix)+8:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WithinBox calls fn with the locations under prefix in b, along with their ID and item, in
+// geohash order. It stops and returns the error of fn if it fails. Items are only valid until fn
+// returns.
+func WithinBox(txn *badger.Txn, prefix []byte, b Box,
+	fn func(item *badger.Item, p Point, id []byte) error) error {
+	return scan(txn, prefix, Cover(b, DefaultMaxCells), b.Contains, fn)
+}
+
+// WithinRadius calls fn with the locations under prefix within meters of center, along with their
+// ID and item, in geohash order. It stops and returns the error of fn if it fails. Items are only
+// valid until fn returns.
+func WithinRadius(txn *badger.Txn, prefix []byte, center Point, meters float64,
+	fn func(item *badger.Item, p Point, id []byte) error) error {
+	within := func(p Point) bool { return Distance(center, p) <= meters }
+	return scan(txn, prefix, Cover(BoundingBox(center, meters), DefaultMaxCells), within, fn)
+}
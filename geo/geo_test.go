@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package geo
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoding(t *testing.T) {
+	p := Point{Lat: 57.64911, Lng: 10.40744}
+	require.Equal(t, "u4pruydqqvj", Geohash(p, 11))
+	got := Decode(Encode(p))
+	require.InDelta(t, p.Lat, got.Lat, 1e-7)
+	require.InDelta(t, p.Lng, got.Lng, 1e-7)
+
+	key := Key([]byte("p/"), p, []byte("id"))
+	got, id, err := ParseKey([]byte("p/"), key)
+	require.NoError(t, err)
+	require.Equal(t, "id", string(id))
+	require.InDelta(t, p.Lat, got.Lat, 1e-7)
+	_, _, err = ParseKey([]byte("q/"), key)
+	require.Error(t, err)
+
+	// Paris to London.
+	d := Distance(Point{48.8566, 2.3522}, Point{51.5074, -0.1278})
+	require.InDelta(t, 343.5e3, d, 1e3)
+}
+
+func TestCover(t *testing.T) {
+	b := Box{MinLat: 10, MinLng: 20, MaxLat: 11, MaxLng: 22}
+	ranges := Cover(b, 8)
+	require.True(t, len(ranges) <= 8)
+	for i := 1; i < len(ranges); i++ {
+		require.True(t, ranges[i-1].Last < ranges[i].First)
+	}
+	inRanges := func(h uint64) bool {
+		for _, r := range ranges {
+			if h >= r.First && h <= r.Last {
+				return true
+			}
+		}
+		return false
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		p := Point{Lat: 10 + rng.Float64(), Lng: 20 + 2*rng.Float64()}
+		require.True(t, inRanges(Encode(p)), "%+v not covered", p)
+	}
+	// The cover is tight enough to leave out most of the world.
+	require.False(t, inRanges(Encode(Point{Lat: -40, Lng: -100})))
+	require.Equal(t, []Range{{0, 1<<64 - 1}}, Cover(Box{-90, -180, 90, 180}, 8))
+}
+
+func TestWithin(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+
+	rng := rand.New(rand.NewSource(2))
+	points := make(map[string]Point)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i := 0; i < 2000; i++ {
+			p := Point{Lat: rng.Float64()*20 + 40, Lng: rng.Float64()*20 - 10}
+			if i%10 == 0 {
+				// Some points around the antimeridian.
+				p.Lng = rng.Float64()*20 - 190
+				if p.Lng < -180 {
+					p.Lng += 360
+				}
+			}
+			id := fmt.Sprint(i)
+			points[id] = p
+			if err := txn.Set(Key([]byte("loc/"), p, []byte(id)), nil); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte("other"), nil)
+	}))
+
+	check := func(query func(txn *badger.Txn, fn func(*badger.Item, Point, []byte) error) error,
+		want func(Point) bool) {
+		var got []string
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			return query(txn, func(_ *badger.Item, _ Point, id []byte) error {
+				got = append(got, string(id))
+				return nil
+			})
+		}))
+		var expected []string
+		for id, p := range points {
+			if want(Decode(Encode(p))) {
+				expected = append(expected, id)
+			}
+		}
+		require.NotEmpty(t, expected)
+		require.ElementsMatch(t, expected, got)
+	}
+
+	boxes := []Box{
+		{MinLat: 45, MinLng: 0, MaxLat: 47, MaxLng: 3},
+		{MinLat: 40, MinLng: 175, MaxLat: 60, MaxLng: -175},
+	}
+	for _, b := range boxes {
+		check(func(txn *badger.Txn, fn func(*badger.Item, Point, []byte) error) error {
+			return WithinBox(txn, []byte("loc/"), b, fn)
+		}, b.Contains)
+	}
+	for _, c := range []Point{{50, 0}, {50, 179.9}} {
+		check(func(txn *badger.Txn, fn func(*badger.Item, Point, []byte) error) error {
+			return WithinRadius(txn, []byte("loc/"), c, 300e3, fn)
+		}, func(p Point) bool { return Distance(c, p) <= 300e3 })
+	}
+}
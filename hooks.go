@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// PreCommitHook is called with the entries of every transaction and write batch being committed,
+// before they get a commit timestamp. The keys are without timestamp, and deleted keys have a
+// nil Value. It returns the entries to write instead, which may be the same ones altered in
+// place, fewer or more, or an error which fails the commit without writing anything.
+type PreCommitHook func(entries []*Entry) ([]*Entry, error)
+
+// PostCommitHook is called with the commit timestamp of every transaction and write batch once
+// its entries have been written, so that they're durable as far as SyncWrites goes.
+type PostCommitHook func(commitTs uint64)
+
+// errRenameAltered is returned when a PreCommitHook alters an entry set by Txn.Rename.
+var errRenameAltered = errors.New("PreCommitHook cannot alter the keys renamed by Txn.Rename")
+
+// runPreCommitHook passes the pending writes of txn to the PreCommitHook of the DB, and replaces
+// them with the entries it returns, checked like the ones passed to Txn.SetEntry. The internal
+// entries of txn are kept as they are.
+func (txn *Txn) runPreCommitHook() error {
+	hook := txn.db.opt.PreCommitHook
+	if hook == nil {
+		return nil
+	}
+	var internal []*Entry
+	entries := make([]*Entry, 0, len(txn.pendingWrites))
+	// The entries of renamed keys point to the value of the old key in the value log, so they
+	// can be dropped but not altered.
+	renamed := make(map[*Entry]Entry)
+	for _, e := range txn.pendingWrites {
+		switch {
+		case bytes.HasPrefix(e.Key, badgerPrefix):
+			internal = append(internal, e)
+		case e.meta&bitValuePointer > 0:
+			renamed[e] = *e
+			entries = append(entries, e)
+		default:
+			entries = append(entries, e)
+		}
+	}
+	entries, err := hook(entries)
+	if err != nil {
+		return err
+	}
+
+	txn.pendingWrites = make(map[string]*Entry, len(entries)+len(internal))
+	txn.writes = txn.writes[:0]
+	txn.count, txn.size = 0, 0
+	for _, e := range entries {
+		if old, ok := renamed[e]; ok && (!bytes.Equal(old.Key, e.Key) ||
+			!bytes.Equal(old.Value, e.Value) || old.meta != e.meta) {
+			return errRenameAltered
+		}
+		if err := txn.checkEntry(e); err != nil {
+			return err
+		}
+		txn.addPendingWrite(e)
+	}
+	for _, e := range internal {
+		if err := txn.checkSize(e); err != nil {
+			return err
+		}
+		txn.addPendingWrite(e)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreCommitHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	errNaming := errors.New("keys must start with app/")
+	opt := getTestOptions(dir).WithPreCommitHook(func(entries []*Entry) ([]*Entry, error) {
+		out := entries[:0]
+		for _, e := range entries {
+			switch {
+			case !bytes.HasPrefix(e.Key, []byte("app/")):
+				return nil, errNaming
+			case bytes.Equal(e.Key, []byte("app/skip")):
+				continue
+			}
+			// Inject a tenant prefix and strip the values marked as secret.
+			e.Key = append([]byte("t1/"), e.Key...)
+			if e.UserMeta == 1 {
+				e.Value = []byte("redacted")
+			}
+			out = append(out, e)
+		}
+		return out, nil
+	})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		if err := txn.Set([]byte("app/a"), []byte("a")); err != nil {
+			return err
+		}
+		if err := txn.Set([]byte("app/skip"), []byte("skip")); err != nil {
+			return err
+		}
+		return txn.SetEntry(NewEntry([]byte("app/b"), []byte("secret")).WithMeta(1))
+	}))
+	err = db.Update(func(txn *Txn) error {
+		if err := txn.Set([]byte("app/c"), []byte("c")); err != nil {
+			return err
+		}
+		return txn.Set([]byte("other"), []byte("other"))
+	})
+	require.Equal(t, errNaming, err)
+
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Set([]byte("app/d"), []byte("d")))
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Delete([]byte("app/a"))
+	}))
+
+	var keys []string
+	require.NoError(t, db.View(func(txn *Txn) error {
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		item, err := txn.Get([]byte("t1/app/b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("redacted"), getItemValue(t, item))
+		return nil
+	}))
+	require.Equal(t, []string{"t1/app/b", "t1/app/d"}, keys)
+}
+
+func TestPreCommitHookChecks(t *testing.T) {
+	var hook PreCommitHook
+	opt := getTestOptions("").WithPreCommitHook(func(entries []*Entry) ([]*Entry, error) {
+		return hook(entries)
+	})
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		hook = func(entries []*Entry) ([]*Entry, error) { return entries, nil }
+		txnSet(t, db, []byte("a"), make([]byte, db.opt.ValueThreshold+100), 0)
+
+		// The entries returned are checked like the ones set.
+		hook = func(entries []*Entry) ([]*Entry, error) {
+			return append(entries, NewEntry(badgerPrefix, nil)), nil
+		}
+		err := db.Update(func(txn *Txn) error { return txn.Set([]byte("b"), nil) })
+		require.Equal(t, ErrInvalidKey, errors.Cause(err))
+
+		// The keys moved by Rename can't be altered.
+		hook = func(entries []*Entry) ([]*Entry, error) {
+			for _, e := range entries {
+				e.Key = append([]byte("x"), e.Key...)
+			}
+			return entries, nil
+		}
+		err = db.Update(func(txn *Txn) error { return txn.Rename([]byte("a"), []byte("c")) })
+		require.Equal(t, errRenameAltered, err)
+
+		// Dropping all the entries commits nothing.
+		hook = func(entries []*Entry) ([]*Entry, error) { return nil, nil }
+		err = db.Update(func(txn *Txn) error { return txn.Rename([]byte("a"), []byte("c")) })
+		require.NoError(t, err)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("a"))
+			require.NoError(t, err)
+			_, err = txn.Get([]byte("c"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
+
+func TestPostCommitHook(t *testing.T) {
+	var mu sync.Mutex
+	var commits []uint64
+	var db *DB
+	opt := getTestOptions("").WithPostCommitHook(func(commitTs uint64) {
+		// The writes are visible at their commit timestamp by then.
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		require.True(t, txn.ReadTs() >= commitTs)
+
+		mu.Lock()
+		defer mu.Unlock()
+		commits = append(commits, commitTs)
+	})
+	runBadgerTest(t, &opt, func(t *testing.T, d *DB) {
+		db = d
+		txnSet(t, db, []byte("a"), []byte("a"), 0)
+		txnSet(t, db, []byte("b"), []byte("b"), 0)
+
+		// Failed commits aren't reported.
+		txn := db.NewTransaction(true)
+		_, err := txn.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("c"), []byte("c")))
+		txnSet(t, db, []byte("a"), []byte("a2"), 0)
+		require.Equal(t, ErrConflict, txn.Commit())
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte("d"), []byte("d")))
+		txn.CommitWith(func(err error) {
+			require.NoError(t, err)
+			wg.Done()
+		})
+		wg.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, []uint64{1, 2, 3, 4}, commits)
+	})
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package hsm provides a badger.KeyWrapper keeping the master key in an HSM, used through
+// PKCS#11, so that the master key never gets in the memory of the process:
+//
+//	w, err := hsm.OpenPKCS11(hsm.PKCS11Config{
+//		Module:     "/usr/lib/softhsm/libsofthsm2.so",
+//		TokenLabel: "badger",
+//		PIN:        pin,
+//		KeyLabel:   "badger-master-1",
+//	})
+//	...
+//	defer w.Close()
+//	db, err := badger.Open(badger.DefaultOptions(dir).WithKeyWrapper(w))
+//
+// The PKCS#11 provider needs cgo and is only built with the pkcs11 build tag. The package is a
+// module of its own, so that the badger module doesn't require the PKCS#11 bindings.
+package hsm
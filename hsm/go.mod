@@ -0,0 +1,12 @@
+module github.com/dgraph-io/badger/v2/hsm
+
+go 1.12
+
+require (
+	github.com/dgraph-io/badger/v2 v2.0.0
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/pkg/errors v0.8.1
+	github.com/stretchr/testify v1.4.0
+)
+
+replace github.com/dgraph-io/badger/v2 => ../
@@ -0,0 +1,208 @@
+// +build pkcs11
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hsm
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+const (
+	gcmIVSize  = 12
+	gcmTagBits = 128
+)
+
+var _ badger.KeyWrapper = (*PKCS11)(nil)
+
+// PKCS11Config tells OpenPKCS11 how to reach the key of the HSM.
+type PKCS11Config struct {
+	// Module is the path of the PKCS#11 library of the HSM.
+	Module string
+	// TokenLabel is the label of the token holding the key, and PIN the PIN of its user.
+	TokenLabel string
+	PIN        string
+	// KeyLabel is the label of the AES key wrapping new data keys. The keys which wrapped data
+	// keys still in use must remain in the token, so that they get unwrapped.
+	KeyLabel string
+}
+
+// PKCS11 is a badger.KeyWrapper encrypting the data keys with AES-GCM in a PKCS#11 token. The
+// wrapped data keys are made of the IV followed by the sealed key.
+type PKCS11 struct {
+	sync.Mutex
+	p11      *pkcs11.Ctx
+	session  pkcs11.SessionHandle
+	keyLabel string
+	keys     map[string]pkcs11.ObjectHandle // Handles of the keys found so far, by label.
+}
+
+// OpenPKCS11 loads the PKCS#11 library of cfg and logs into its token. The returned PKCS11 must
+// be closed once the DB using it is closed.
+func OpenPKCS11(cfg PKCS11Config) (*PKCS11, error) {
+	if cfg.KeyLabel == "" {
+		return nil, errors.New("A key label is needed to wrap data keys")
+	}
+	p11 := pkcs11.New(cfg.Module)
+	if p11 == nil {
+		return nil, errors.Errorf("Unable to load PKCS#11 library %q", cfg.Module)
+	}
+	if err := p11.Initialize(); err != nil {
+		p11.Destroy()
+		return nil, errors.Wrapf(err, "While initializing PKCS#11 library %q", cfg.Module)
+	}
+	w := &PKCS11{p11: p11, keyLabel: cfg.KeyLabel, keys: make(map[string]pkcs11.ObjectHandle)}
+	if err := w.login(cfg); err != nil {
+		_ = p11.Finalize()
+		p11.Destroy()
+		return nil, err
+	}
+	// Make sure the key for new data keys exists, rather than failing on the first one.
+	if _, err := w.key(cfg.KeyLabel); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *PKCS11) login(cfg PKCS11Config) error {
+	slots, err := w.p11.GetSlotList(true)
+	if err != nil {
+		return errors.Wrap(err, "While listing PKCS#11 slots")
+	}
+	for _, slot := range slots {
+		info, err := w.p11.GetTokenInfo(slot)
+		if err != nil {
+			return errors.Wrapf(err, "While reading the token of PKCS#11 slot %d", slot)
+		}
+		if strings.TrimSpace(info.Label) != cfg.TokenLabel {
+			continue
+		}
+		w.session, err = w.p11.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return errors.Wrapf(err, "While opening a session on token %q", cfg.TokenLabel)
+		}
+		if err := w.p11.Login(w.session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+			_ = w.p11.CloseSession(w.session)
+			return errors.Wrapf(err, "While logging into token %q", cfg.TokenLabel)
+		}
+		return nil
+	}
+	return errors.Errorf("No PKCS#11 token labeled %q", cfg.TokenLabel)
+}
+
+// key returns the handle of the AES key with the given label. w must be locked or not shared yet.
+func (w *PKCS11) key(label string) (pkcs11.ObjectHandle, error) {
+	if h, ok := w.keys[label]; ok {
+		return h, nil
+	}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := w.p11.FindObjectsInit(w.session, template); err != nil {
+		return 0, errors.Wrapf(err, "While looking for key %q", label)
+	}
+	handles, _, err := w.p11.FindObjects(w.session, 2)
+	if finalErr := w.p11.FindObjectsFinal(w.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "While looking for key %q", label)
+	}
+	switch len(handles) {
+	case 0:
+		return 0, errors.Errorf("No AES key labeled %q in the token", label)
+	case 1:
+	default:
+		return 0, errors.Errorf("Several AES keys labeled %q in the token", label)
+	}
+	w.keys[label] = handles[0]
+	return handles[0], nil
+}
+
+// WrapKey encrypts key with the key labeled PKCS11Config.KeyLabel.
+func (w *PKCS11) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	w.Lock()
+	defer w.Unlock()
+	h, err := w.key(w.keyLabel)
+	if err != nil {
+		return nil, "", err
+	}
+	iv := make([]byte, gcmIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, "", errors.Wrap(err, "While generating IV")
+	}
+	params := pkcs11.NewGCMParams(iv, nil, gcmTagBits)
+	defer params.Free()
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := w.p11.EncryptInit(w.session, mech, h); err != nil {
+		return nil, "", errors.Wrapf(err, "While wrapping with key %q", w.keyLabel)
+	}
+	sealed, err := w.p11.Encrypt(w.session, key)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "While wrapping with key %q", w.keyLabel)
+	}
+	return append(iv, sealed...), w.keyLabel, nil
+}
+
+// UnwrapKey decrypts wrapped with the key of the given label.
+func (w *PKCS11) UnwrapKey(ctx context.Context, label string, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < gcmIVSize+gcmTagBits/8 {
+		return nil, errors.Errorf("Wrapped key of size %d is too short", len(wrapped))
+	}
+	w.Lock()
+	defer w.Unlock()
+	h, err := w.key(label)
+	if err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(wrapped[:gcmIVSize], nil, gcmTagBits)
+	defer params.Free()
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := w.p11.DecryptInit(w.session, mech, h); err != nil {
+		return nil, errors.Wrapf(err, "While unwrapping with key %q", label)
+	}
+	key, err := w.p11.Decrypt(w.session, wrapped[gcmIVSize:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "While unwrapping with key %q", label)
+	}
+	return key, nil
+}
+
+// Close logs out of the token and unloads the PKCS#11 library.
+func (w *PKCS11) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	err := w.p11.Logout(w.session)
+	if closeErr := w.p11.CloseSession(w.session); err == nil {
+		err = closeErr
+	}
+	if finalErr := w.p11.Finalize(); err == nil {
+		err = finalErr
+	}
+	w.p11.Destroy()
+	return errors.Wrap(err, "While closing PKCS#11 session")
+}
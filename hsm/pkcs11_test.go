@@ -0,0 +1,82 @@
+// +build pkcs11
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hsm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// The test needs a token holding an AES key, e.g. created with SoftHSM:
+//
+//	softhsm2-util --init-token --free --label badger --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $MODULE --login --pin 1234 --token-label badger \
+//		--keygen --key-type AES:32 --label badger-master
+//	BADGER_PKCS11_MODULE=$MODULE go test -tags pkcs11 ./hsm
+func testConfig(t *testing.T) PKCS11Config {
+	module := os.Getenv("BADGER_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("BADGER_PKCS11_MODULE isn't set")
+	}
+	return PKCS11Config{Module: module, TokenLabel: "badger", PIN: "1234", KeyLabel: "badger-master"}
+}
+
+func TestPKCS11(t *testing.T) {
+	cfg := testConfig(t)
+	w, err := OpenPKCS11(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, w.Close()) }()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, label, err := w.WrapKey(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, cfg.KeyLabel, label)
+	require.NotContains(t, string(wrapped), string(key))
+	unwrapped, err := w.UnwrapKey(context.Background(), label, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, key, unwrapped)
+	wrapped[len(wrapped)-1] ^= 1
+	_, err = w.UnwrapKey(context.Background(), label, wrapped)
+	require.Error(t, err)
+	_, err = w.UnwrapKey(context.Background(), "missing", wrapped)
+	require.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opt := badger.DefaultOptions(dir).WithKeyWrapper(w)
+	db, err := badger.Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.Close())
+	db, err = badger.Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("foo"))
+		return err
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sort"
+	"sync"
+)
+
+// Instance describes a DB open in the process.
+type Instance struct {
+	// Label is the InstanceLabel the DB was opened with.
+	Label    string
+	Dir      string
+	ValueDir string
+	DB       *DB
+}
+
+// instances holds the DBs open in the process.
+var instances struct {
+	sync.Mutex
+	dbs map[*DB]struct{}
+}
+
+func registerInstance(db *DB) {
+	instances.Lock()
+	defer instances.Unlock()
+	if instances.dbs == nil {
+		instances.dbs = make(map[*DB]struct{})
+	}
+	instances.dbs[db] = struct{}{}
+}
+
+func unregisterInstance(db *DB) {
+	instances.Lock()
+	defer instances.Unlock()
+	delete(instances.dbs, db)
+}
+
+// OpenInstances returns the DBs open in the process, sorted by label and directory.
+func OpenInstances() []Instance {
+	instances.Lock()
+	defer instances.Unlock()
+	list := make([]Instance, 0, len(instances.dbs))
+	for db := range instances.dbs {
+		list = append(list, Instance{
+			Label:    db.opt.InstanceLabel,
+			Dir:      db.opt.Dir,
+			ValueDir: db.opt.ValueDir,
+			DB:       db,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Label != list[j].Label {
+			return list[i].Label < list[j].Label
+		}
+		return list[i].Dir < list[j].Dir
+	})
+	return list
+}
+
+// labeledLogger prefixes the messages of a DB with its label.
+type labeledLogger struct {
+	Logger
+	prefix string
+}
+
+func (l *labeledLogger) Errorf(f string, v ...interface{}) {
+	l.Logger.Errorf(l.prefix+f, v...)
+}
+
+func (l *labeledLogger) Warningf(f string, v ...interface{}) {
+	l.Logger.Warningf(l.prefix+f, v...)
+}
+
+func (l *labeledLogger) Infof(f string, v ...interface{}) {
+	l.Logger.Infof(l.prefix+f, v...)
+}
+
+func (l *labeledLogger) Debugf(f string, v ...interface{}) {
+	l.Logger.Debugf(l.prefix+f, v...)
+}
+
+// traceTitle returns the title of the traces and event logs of the DB.
+func (opt *Options) traceTitle(title string) string {
+	if opt.InstanceLabel == "" {
+		return title
+	}
+	return title + " " + opt.InstanceLabel
+}
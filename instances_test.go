@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenInstances(t *testing.T) {
+	openLabeled := func(label string) (*DB, string) {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		db, err := Open(getTestOptions(dir).WithInstanceLabel(label))
+		require.NoError(t, err)
+		return db, dir
+	}
+	dbB, dirB := openLabeled("tenant-b")
+	defer removeDir(dirB)
+	dbA, dirA := openLabeled("tenant-a")
+	defer removeDir(dirA)
+
+	var labeled []Instance
+	for _, inst := range OpenInstances() {
+		if inst.DB == dbA || inst.DB == dbB {
+			labeled = append(labeled, inst)
+		}
+	}
+	require.Equal(t, []Instance{
+		{Label: "tenant-a", Dir: dirA, ValueDir: dirA, DB: dbA},
+		{Label: "tenant-b", Dir: dirB, ValueDir: dirB, DB: dbB},
+	}, labeled)
+	require.Equal(t, "tenant-a", dbA.Metrics().Instance)
+
+	require.NoError(t, dbA.Close())
+	for _, inst := range OpenInstances() {
+		require.NotEqual(t, dbA, inst.DB)
+	}
+	require.NoError(t, dbB.Close())
+}
+
+func TestLabeledLogger(t *testing.T) {
+	l := &mockLogger{}
+	opt := Options{Logger: &labeledLogger{Logger: l, prefix: "[tenant] "}}
+	opt.Errorf("test %d", 1)
+	require.Equal(t, "ERROR: [tenant] test 1", l.output)
+	opt.Infof("test")
+	require.Equal(t, "INFO: [tenant] test", l.output)
+	require.Equal(t, "DB", opt.traceTitle("DB"))
+	opt.InstanceLabel = "tenant"
+	require.Equal(t, "DB tenant", opt.traceTitle("DB"))
+}
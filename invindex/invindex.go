@@ -0,0 +1,366 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package invindex maintains inverted indexes of documents stored in Badger.
+//
+// Documents are split into terms by a tokenizer, and the index keeps the list of documents
+// holding each term, as a roaring bitmap of document IDs. Documents are indexed as part of the
+// caller's transactions, so the index is always consistent with the data it describes:
+//
+//	ix, err := invindex.Open(db, []byte("idx/"), invindex.Words)
+//	...
+//	err = db.Update(func(txn *badger.Txn) error {
+//		if err := txn.Set(docKey, doc); err != nil {
+//			return err
+//		}
+//		return ix.Add(txn, docKey, doc)
+//	})
+//	...
+//	err = db.View(func(txn *badger.Txn) error {
+//		docs, err := ix.And(txn, "badger", "compaction")
+//		if err != nil {
+//			return err
+//		}
+//		keys, err = ix.Keys(txn, docs)
+//		return err
+//	})
+package invindex
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/roaring"
+	"github.com/pkg/errors"
+)
+
+// Key layout, under the index prefix. Documents are identified by a uint32 ID, so that they can
+// be held by roaring bitmaps.
+const (
+	seqTag  = 's' // Sequence used to number documents.
+	idTag   = 'i' // Big-endian document IDs, followed by the document key.
+	docTag  = 'd' // Document keys and terms, followed by the big-endian document ID.
+	termTag = 't' // Bitmaps of the IDs of the documents holding a term, followed by the term.
+)
+
+// Tokenizer splits the text of a document into terms. Terms may be repeated.
+type Tokenizer func(text []byte) []string
+
+// Words is a Tokenizer returning the lowercase words of a text, words being sequences of letters
+// and digits.
+func Words(text []byte) []string {
+	return strings.FieldsFunc(strings.ToLower(string(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Index is an inverted index keeping its data under a key prefix.
+type Index struct {
+	db       *badger.DB
+	prefix   []byte
+	tokenize Tokenizer
+	seq      *badger.Sequence
+}
+
+// Open returns the index keeping its data in db under prefix, splitting documents into terms
+// with tokenize. The prefix must not be used for anything else. Close must be called once done
+// with the index.
+func Open(db *badger.DB, prefix []byte, tokenize Tokenizer) (*Index, error) {
+	ix := &Index{db: db, prefix: append([]byte{}, prefix...), tokenize: tokenize}
+	seq, err := db.GetSequence(ix.key(seqTag), 100)
+	if err != nil {
+		return nil, errors.Wrap(err, "while opening index sequence")
+	}
+	ix.seq = seq
+	return ix, nil
+}
+
+// Close releases the document IDs leased by the index.
+func (ix *Index) Close() error {
+	return ix.seq.Release()
+}
+
+func (ix *Index) key(tag byte, suffix ...byte) []byte {
+	key := make([]byte, 0, len(ix.prefix)+1+len(suffix))
+	key = append(key, ix.prefix...)
+	key = append(key, tag)
+	return append(key, suffix...)
+}
+
+func (ix *Index) docKey(id uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], id)
+	return ix.key(docTag, buf[:]...)
+}
+
+func (ix *Index) termKey(term string) []byte {
+	return ix.key(termTag, []byte(term)...)
+}
+
+// encodeDoc encodes the key and terms of a document as a sequence of uvarint-prefixed strings.
+func encodeDoc(key []byte, terms []string) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	res := make([]byte, 0, n+len(key))
+	res = append(append(res, buf[:n]...), key...)
+	for _, term := range terms {
+		n := binary.PutUvarint(buf[:], uint64(len(term)))
+		res = append(append(res, buf[:n]...), term...)
+	}
+	return res
+}
+
+func decodeDoc(data []byte) (key []byte, terms []string, err error) {
+	for first := true; len(data) > 0; first = false {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, nil, errors.New("Corrupt indexed document")
+		}
+		s := data[n : n+int(l)]
+		data = data[n+int(l):]
+		if first {
+			key = s
+		} else {
+			terms = append(terms, string(s))
+		}
+	}
+	return key, terms, nil
+}
+
+// lookup returns the ID and terms of the document with the given key, if it's indexed.
+func (ix *Index) lookup(txn *badger.Txn, key []byte) (uint32, []string, bool, error) {
+	item, err := txn.Get(ix.key(idTag, key...))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	var id uint32
+	err = item.Value(func(val []byte) error {
+		if len(val) != 4 {
+			return errors.Errorf("Invalid document ID of size %d for %q", len(val), key)
+		}
+		id = binary.BigEndian.Uint32(val)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, false, err
+	}
+	item, err = txn.Get(ix.docKey(id))
+	if err != nil {
+		return 0, nil, false, errors.Wrapf(err, "while reading indexed document %q", key)
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	_, terms, err := decodeDoc(data)
+	return id, terms, true, err
+}
+
+// bitmap returns the bitmap of the documents holding term.
+func (ix *Index) bitmap(txn *badger.Txn, term string) (*roaring.Bitmap, error) {
+	item, err := txn.Get(ix.termKey(term))
+	if err == badger.ErrKeyNotFound {
+		return &roaring.Bitmap{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bm := &roaring.Bitmap{}
+	err = item.Value(bm.UnmarshalBinary)
+	return bm, errors.Wrapf(err, "while reading documents of term %q", term)
+}
+
+// update adds or removes id from the bitmap of the documents holding term.
+func (ix *Index) update(txn *badger.Txn, term string, id uint32, add bool) error {
+	bm, err := ix.bitmap(txn, term)
+	if err != nil {
+		return err
+	}
+	if add {
+		bm.Add(id)
+	} else {
+		bm.Remove(id)
+	}
+	if bm.IsEmpty() {
+		return txn.Delete(ix.termKey(term))
+	}
+	return txn.Set(ix.termKey(term), bm.AppendTo(nil))
+}
+
+// Add indexes the document with the given key and text as part of txn, replacing the previous
+// version of the document if it was indexed already.
+//
+// Add updates the bitmap of every term of the document, so concurrent transactions indexing
+// documents sharing terms conflict with each other, and all but one of them fail to commit with
+// badger.ErrConflict. They should be retried.
+func (ix *Index) Add(txn *badger.Txn, key, text []byte) error {
+	id, oldTerms, ok, err := ix.lookup(txn, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		next, err := ix.seq.Next()
+		if err != nil {
+			return errors.Wrap(err, "while allocating document ID")
+		}
+		if next > 1<<32-1 {
+			return errors.New("Index is out of document IDs")
+		}
+		id = uint32(next)
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], id)
+		if err := txn.Set(ix.key(idTag, key...), buf[:]); err != nil {
+			return err
+		}
+	}
+
+	terms := ix.tokenize(text)
+	sort.Strings(terms)
+	uniq := terms[:0]
+	for i, term := range terms {
+		if i == 0 || term != terms[i-1] {
+			uniq = append(uniq, term)
+		}
+	}
+	terms = uniq
+	// Both term lists are sorted, only the differences need to be updated.
+	i, j := 0, 0
+	for i < len(oldTerms) || j < len(terms) {
+		switch {
+		case j == len(terms) || (i < len(oldTerms) && oldTerms[i] < terms[j]):
+			err = ix.update(txn, oldTerms[i], id, false)
+			i++
+		case i == len(oldTerms) || oldTerms[i] > terms[j]:
+			err = ix.update(txn, terms[j], id, true)
+			j++
+		default:
+			i++
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Set(ix.docKey(id), encodeDoc(key, terms))
+}
+
+// Delete removes the document with the given key from the index as part of txn. Deleting a
+// document which isn't indexed is a no-op.
+func (ix *Index) Delete(txn *badger.Txn, key []byte) error {
+	id, terms, ok, err := ix.lookup(txn, key)
+	if err != nil || !ok {
+		return err
+	}
+	for _, term := range terms {
+		if err := ix.update(txn, term, id, false); err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete(ix.docKey(id)); err != nil {
+		return err
+	}
+	return txn.Delete(ix.key(idTag, key...))
+}
+
+// Term returns the IDs of the documents holding term.
+func (ix *Index) Term(txn *badger.Txn, term string) (*roaring.Bitmap, error) {
+	return ix.bitmap(txn, term)
+}
+
+// And returns the IDs of the documents holding all the given terms. Terms are matched as is, so
+// they should be normalized the way the tokenizer does it.
+func (ix *Index) And(txn *badger.Txn, terms ...string) (*roaring.Bitmap, error) {
+	var res *roaring.Bitmap
+	for _, term := range terms {
+		bm, err := ix.bitmap(txn, term)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = bm
+		} else {
+			res.And(bm)
+		}
+		if res.IsEmpty() {
+			break
+		}
+	}
+	if res == nil {
+		res = &roaring.Bitmap{}
+	}
+	return res, nil
+}
+
+// Or returns the IDs of the documents holding any of the given terms.
+func (ix *Index) Or(txn *badger.Txn, terms ...string) (*roaring.Bitmap, error) {
+	res := &roaring.Bitmap{}
+	for _, term := range terms {
+		bm, err := ix.bitmap(txn, term)
+		if err != nil {
+			return nil, err
+		}
+		res.Or(bm)
+	}
+	return res, nil
+}
+
+// Prefix returns the IDs of the documents holding any term starting with prefix.
+func (ix *Index) Prefix(txn *badger.Txn, prefix string) (*roaring.Bitmap, error) {
+	res := &roaring.Bitmap{}
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = ix.termKey(prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		bm := &roaring.Bitmap{}
+		if err := it.Item().Value(bm.UnmarshalBinary); err != nil {
+			return nil, errors.Wrapf(err, "while reading documents of term %q",
+				it.Item().Key()[len(ix.prefix)+1:])
+		}
+		res.Or(bm)
+	}
+	return res, nil
+}
+
+// Keys returns the keys of the documents with the given IDs, in order of ID.
+func (ix *Index) Keys(txn *badger.Txn, ids *roaring.Bitmap) ([][]byte, error) {
+	keys := make([][]byte, 0, ids.Cardinality())
+	var err error
+	ids.ForEach(func(id uint32) bool {
+		var item *badger.Item
+		if item, err = txn.Get(ix.docKey(id)); err != nil {
+			err = errors.Wrapf(err, "while reading document %d", id)
+			return false
+		}
+		var data []byte
+		if data, err = item.ValueCopy(nil); err != nil {
+			return false
+		}
+		var key []byte
+		if key, _, err = decodeDoc(data); err != nil {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys, err
+}
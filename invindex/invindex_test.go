@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package invindex
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/dgraph-io/badger/v2/roaring"
+	"github.com/stretchr/testify/require"
+)
+
+func query(t *testing.T, db *badger.DB, ix *Index,
+	fn func(txn *badger.Txn) (*roaring.Bitmap, error)) []string {
+	var res []string
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		bm, err := fn(txn)
+		if err != nil {
+			return err
+		}
+		keys, err := ix.Keys(txn, bm)
+		for _, key := range keys {
+			res = append(res, string(key))
+		}
+		return err
+	}))
+	return res
+}
+
+func TestInvertedIndex(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ix, err := Open(db, []byte("idx/"), Words)
+	require.NoError(t, err)
+	defer ix.Close()
+
+	docs := map[string]string{
+		"a": "The quick brown fox",
+		"b": "A quick, QUICK badger!",
+		"c": "Brown badgers dig",
+	}
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for key, text := range docs {
+			if err := ix.Add(txn, []byte(key), []byte(text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	and := func(terms ...string) []string {
+		return query(t, db, ix, func(txn *badger.Txn) (*roaring.Bitmap, error) {
+			return ix.And(txn, terms...)
+		})
+	}
+	or := func(terms ...string) []string {
+		return query(t, db, ix, func(txn *badger.Txn) (*roaring.Bitmap, error) {
+			return ix.Or(txn, terms...)
+		})
+	}
+	prefix := func(p string) []string {
+		return query(t, db, ix, func(txn *badger.Txn) (*roaring.Bitmap, error) {
+			return ix.Prefix(txn, p)
+		})
+	}
+	require.ElementsMatch(t, []string{"a", "b"}, and("quick"))
+	require.ElementsMatch(t, []string{"a"}, and("quick", "brown"))
+	require.Empty(t, and("quick", "dig"))
+	require.Empty(t, and())
+	require.ElementsMatch(t, []string{"a", "c"}, or("fox", "dig"))
+	require.ElementsMatch(t, []string{"b", "c"}, prefix("badger"))
+
+	// Updating and deleting documents.
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := ix.Add(txn, []byte("a"), []byte("Slow brown dog")); err != nil {
+			return err
+		}
+		return ix.Delete(txn, []byte("c"))
+	}))
+	require.ElementsMatch(t, []string{"b"}, and("quick"))
+	require.ElementsMatch(t, []string{"a"}, and("brown"))
+	require.ElementsMatch(t, []string{"b"}, prefix("badger"))
+	require.Empty(t, or("fox", "dig"))
+
+	// Terms nobody holds anymore are gone.
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(ix.termKey("fox"))
+		require.Equal(t, badger.ErrKeyNotFound, err)
+		return nil
+	}))
+
+	// Changes of discarded transactions aren't seen.
+	txn := db.NewTransaction(true)
+	require.NoError(t, ix.Add(txn, []byte("d"), []byte("quick")))
+	txn.Discard()
+	require.ElementsMatch(t, []string{"b"}, and("quick"))
+}
+
+func TestInvertedIndexConflict(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ix, err := Open(db, []byte("idx/"), Words)
+	require.NoError(t, err)
+	defer ix.Close()
+
+	txn1 := db.NewTransaction(true)
+	defer txn1.Discard()
+	txn2 := db.NewTransaction(true)
+	defer txn2.Discard()
+	require.NoError(t, ix.Add(txn1, []byte("a"), []byte("shared one")))
+	require.NoError(t, ix.Add(txn2, []byte("b"), []byte("shared two")))
+	require.NoError(t, txn1.Commit())
+	require.Equal(t, badger.ErrConflict, txn2.Commit())
+}
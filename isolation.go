@@ -0,0 +1,64 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// Isolation is the isolation level of a read-write transaction, see Txn.SetIsolation.
+type Isolation int
+
+const (
+	// Serializable is the default isolation level, serializable snapshot isolation: transactions
+	// track the keys they read, and fail to commit with ErrConflict if another transaction wrote
+	// one of them after they started.
+	Serializable Isolation = iota
+	// SnapshotIsolation only detects write-write conflicts: transactions don't track the keys they
+	// read, and fail to commit with ErrConflict if another transaction wrote one of the keys they
+	// write after they started. It saves the memory and commit time of the reads, but lets write
+	// skew happen, two transactions each writing keys read by the other committing both.
+	SnapshotIsolation
+)
+
+// SetIsolation sets the isolation level of txn, Serializable by default. It must be called before
+// txn reads anything, and only matters to read-write transactions.
+func (txn *Txn) SetIsolation(iso Isolation) {
+	txn.isolation = iso
+}
+
+// conflictKeys returns the fingerprints of the keys whose writes by other transactions conflict
+// with txn: the keys it read, or the keys it writes with SnapshotIsolation.
+func (txn *Txn) conflictKeys() []uint64 {
+	if txn.isolation == SnapshotIsolation {
+		return txn.writes
+	}
+	return txn.reads
+}
+
+// conflictKey returns the key with fingerprint fp causing a conflict, recorded for ConflictError.
+func (txn *Txn) conflictKey(fp uint64) []byte {
+	if txn.isolation != SnapshotIsolation {
+		return txn.readKeys[fp]
+	}
+	for k := range txn.pendingWrites {
+		if z.MemHash([]byte(k)) == fp {
+			return []byte(k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		a, b := []byte("a"), []byte("b")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Set(a, []byte("0")); err != nil {
+				return err
+			}
+			return txn.Set(b, []byte("0"))
+		}))
+		// newTxn returns a transaction having read both keys, and written to key.
+		newTxn := func(iso Isolation, key []byte) *Txn {
+			txn := db.NewTransaction(true)
+			txn.SetIsolation(iso)
+			for _, k := range [][]byte{a, b} {
+				_, err := txn.Get(k)
+				require.NoError(t, err)
+			}
+			require.NoError(t, txn.Set(key, []byte("1")))
+			return txn
+		}
+
+		// Write skew is only allowed with snapshot isolation.
+		for _, iso := range []Isolation{Serializable, SnapshotIsolation} {
+			t1, t2 := newTxn(iso, a), newTxn(iso, b)
+			require.NoError(t, t1.Commit())
+			if iso == Serializable {
+				require.Equal(t, ErrConflict, t2.Commit())
+			} else {
+				require.Empty(t, t2.reads)
+				require.NoError(t, t2.Commit())
+			}
+		}
+
+		// Write-write conflicts are detected all the same.
+		t1, t2 := newTxn(SnapshotIsolation, a), newTxn(SnapshotIsolation, a)
+		require.NoError(t, t1.Commit())
+		require.Equal(t, ErrConflict, t2.Commit())
+	})
+}
+
+func TestSnapshotIsolationConflictDiagnostics(t *testing.T) {
+	opt := getTestOptions("").WithConflictDiagnostics(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		txn.SetIsolation(SnapshotIsolation)
+		require.NoError(t, txn.Set(key, []byte("a")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("b"))
+		}))
+		err := txn.Commit()
+		cerr, ok := err.(*ConflictError)
+		require.True(t, ok, "%v", err)
+		require.Equal(t, key, cerr.Key)
+	})
+}
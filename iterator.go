@@ -55,6 +55,11 @@ type Item struct {
 	next      *Item
 	version   uint64
 	txn       *Txn
+	// pending is set for the writes of txn which aren't committed yet.
+	pending bool
+	// origKey is the key as it was written, if known, when key is the hash it's stored under. See
+	// Options.KeyHashSecret.
+	origKey []byte
 }
 
 // String returns a string representation of Item
@@ -65,8 +70,12 @@ func (item *Item) String() string {
 // Key returns the key.
 //
 // Key is only valid as long as item is valid, or transaction is valid.  If you need to use it
-// outside its validity, please use KeyCopy.
+// outside its validity, please use KeyCopy. With Options.KeyHashSecret, it's the hash the key is
+// stored under for the items of iterators other than key iterators, see OriginalKey.
 func (item *Item) Key() []byte {
+	if item.origKey != nil {
+		return item.origKey
+	}
 	return item.key
 }
 
@@ -74,7 +83,7 @@ func (item *Item) Key() []byte {
 // If nil is passed, or capacity of dst isn't sufficient, a new slice would be allocated and
 // returned.
 func (item *Item) KeyCopy(dst []byte) []byte {
-	return y.SafeCopy(dst, item.key)
+	return y.SafeCopy(dst, item.Key())
 }
 
 // Version returns the commit timestamp of the item.
@@ -102,7 +111,7 @@ func (item *Item) Value(fn func(val []byte) error) error {
 		}
 		return item.err
 	}
-	buf, cb, err := item.yieldItemValue()
+	buf, cb, err := item.yieldValue()
 	defer runCallback(cb)
 	if err != nil {
 		return err
@@ -124,7 +133,7 @@ func (item *Item) ValueCopy(dst []byte) ([]byte, error) {
 	if item.status == prefetched {
 		return y.SafeCopy(dst, item.val), item.err
 	}
-	buf, cb, err := item.yieldItemValue()
+	buf, cb, err := item.yieldValue()
 	defer runCallback(cb)
 	return y.SafeCopy(dst, buf), err
 }
@@ -149,7 +158,7 @@ func (item *Item) DiscardEarlierVersions() bool {
 }
 
 func (item *Item) yieldItemValue() ([]byte, func(), error) {
-	key := item.Key() // No need to copy.
+	key := item.key // No need to copy.
 	for {
 		if !item.hasValue() {
 			return nil, nil, nil
@@ -182,7 +191,7 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 		// move key and read that instead.
 		runCallback(cb)
 		// Do not put badgerMove on the left in append. It seems to cause some sort of manipulation.
-		keyTs := y.KeyWithTs(item.Key(), item.Version())
+		keyTs := y.KeyWithTs(item.key, item.Version())
 		key = make([]byte, len(badgerMove)+len(keyTs))
 		n := copy(key, badgerMove)
 		copy(key[n:], keyTs)
@@ -214,7 +223,7 @@ func runCallback(cb func()) {
 }
 
 func (item *Item) prefetchValue() {
-	val, cb, err := item.yieldItemValue()
+	val, cb, err := item.yieldValue()
 	defer runCallback(cb)
 
 	item.err = err
@@ -249,7 +258,7 @@ func (item *Item) EstimatedSize() int64 {
 }
 
 // KeySize returns the size of the key.
-// Exact size of the key is key + 8 bytes of timestamp
+// Exact size of the key is key + y.TsSize bytes of timestamp
 func (item *Item) KeySize() int64 {
 	return int64(len(item.key))
 }
@@ -268,7 +277,7 @@ func (item *Item) ValueSize() int64 {
 	var vp valuePointer
 	vp.Decode(item.vptr)
 
-	klen := int64(len(item.key) + 8) // 8 bytes for timestamp.
+	klen := int64(len(item.key) + y.TsSize)
 	// 6 bytes are for the approximate length of the header. Since header is encoded in varint, we
 	// cannot find the exact length of header without fetching it.
 	return int64(vp.Len) - klen - 6 - crc32.Size
@@ -331,6 +340,9 @@ type IteratorOptions struct {
 	PrefetchSize int
 	Reverse      bool // Direction of iteration. False is forward, true is backward.
 	AllVersions  bool // Fetch all valid versions of the same key.
+	// IncludeSoftDeleted returns keys deleted by Txn.SoftDelete which can still be undeleted, as
+	// soft delete markers. Their value is empty, Item.IsSoftDeleted tells them apart.
+	IncludeSoftDeleted bool
 
 	// The following option is used to narrow down the SSTables that iterator picks up. If
 	// Prefix is specified, only tables which could have this prefix are picked based on their range
@@ -338,6 +350,10 @@ type IteratorOptions struct {
 	Prefix      []byte // Only iterate over this given prefix.
 	prefixIsKey bool   // If set, use the prefix for bloom filter lookup.
 
+	// cmp is the comparator of the DB. Table key ranges can only be matched against Prefix
+	// under the default byte-wise order.
+	cmp Comparator
+
 	InternalAccess bool // Used to allow internal access to badger keys.
 }
 
@@ -354,10 +370,10 @@ func (opt *IteratorOptions) pickTable(t table.TableInterface) bool {
 	if len(opt.Prefix) == 0 {
 		return true
 	}
-	if opt.compareToPrefix(t.Smallest()) > 0 {
+	if opt.cmp == nil && opt.compareToPrefix(t.Smallest()) > 0 {
 		return false
 	}
-	if opt.compareToPrefix(t.Biggest()) < 0 {
+	if opt.cmp == nil && opt.compareToPrefix(t.Biggest()) < 0 {
 		return false
 	}
 	// Bloom filter lookup would only work if opt.Prefix does NOT have the read
@@ -376,6 +392,15 @@ func (opt *IteratorOptions) pickTables(all []*table.Table) []*table.Table {
 		copy(out, all)
 		return out
 	}
+	if opt.cmp != nil {
+		var out []*table.Table
+		for _, t := range all {
+			if opt.pickTable(t) {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
 	sIdx := sort.Search(len(all), func(i int) bool {
 		return opt.compareToPrefix(all[i].Biggest()) >= 0
 	})
@@ -425,6 +450,10 @@ type Iterator struct {
 	iitr   y.Iterator
 	txn    *Txn
 	readTs uint64
+	// pending iterates over the writes of txn, nil if it has none.
+	pending *pendingWritesIterator
+	// origKey is the key of a key iterator, whose prefix is the hash of it, with KeyHashSecret.
+	origKey []byte
 
 	opt   IteratorOptions
 	item  *Item
@@ -447,6 +476,9 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 	if txn.discarded {
 		panic("Transaction has already been discarded")
 	}
+	if txn.db.hasher != nil && len(opt.Prefix) > 0 && !opt.prefixIsKey && !opt.InternalAccess {
+		panic("Prefix iteration isn't supported with KeyHashSecret, keys are stored as hashes.")
+	}
 	// Do not change the order of the next if. We must track the number of running iterators.
 	if atomic.AddInt32(&txn.numIterators, 1) > 1 && txn.update {
 		atomic.AddInt32(&txn.numIterators, -1)
@@ -459,19 +491,22 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 	defer decr()
 	txn.db.vlog.incrIteratorCount()
 	var iters []y.Iterator
-	if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil {
-		iters = append(iters, itr)
+	pending := txn.newPendingWritesIterator(opt.Reverse)
+	if pending != nil {
+		iters = append(iters, pending)
 	}
 	for i := 0; i < len(tables); i++ {
 		iters = append(iters, tables[i].NewUniIterator(opt.Reverse))
 	}
+	opt.cmp = txn.db.opt.Comparator
 	iters = txn.db.lc.appendIterators(iters, &opt) // This will increment references.
 
 	res := &Iterator{
-		txn:    txn,
-		iitr:   table.NewMergeIterator(iters, opt.Reverse),
-		opt:    opt,
-		readTs: txn.readTs,
+		txn:     txn,
+		iitr:    table.NewMergeIteratorWithComparator(iters, opt.Reverse, txn.db.opt.Comparator),
+		opt:     opt,
+		readTs:  txn.readTs,
+		pending: pending,
 	}
 	return res
 }
@@ -483,10 +518,14 @@ func (txn *Txn) NewKeyIterator(key []byte, opt IteratorOptions) *Iterator {
 	if len(opt.Prefix) > 0 {
 		panic("opt.Prefix should be nil for NewKeyIterator.")
 	}
-	opt.Prefix = key // This key must be without the timestamp.
+	opt.Prefix = txn.db.storedKey(key) // This key must be without the timestamp.
 	opt.prefixIsKey = true
 	opt.AllVersions = true
-	return txn.NewIterator(opt)
+	it := txn.NewIterator(opt)
+	if txn.db.hasher != nil {
+		it.origKey = key
+	}
+	return it
 }
 
 func (it *Iterator) newItem() *Item {
@@ -635,7 +674,8 @@ func (it *Iterator) parseItem() bool {
 FILL:
 	// If deleted, advance and return.
 	vs := mi.Value()
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) &&
+		!(it.opt.IncludeSoftDeleted && isSoftDeleted(vs.Meta, vs.ExpiresAt)) {
 		mi.Next()
 		return false
 	}
@@ -674,6 +714,19 @@ func (it *Iterator) fill(item *Item) {
 
 	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.val = nil
+	// The merge iterator prefers the pending writes over the committed versions with the same
+	// timestamp, so the pending iterator is positioned on the key only if it comes from there.
+	pi := it.pending
+	item.pending = pi != nil && item.version == it.readTs && pi.Valid() &&
+		bytes.Equal(pi.entries[pi.nextIdx].Key, item.key)
+	switch {
+	case it.origKey != nil:
+		item.origKey = it.origKey
+	case item.pending:
+		item.origKey = pi.entries[pi.nextIdx].origKey
+	default:
+		item.origKey = nil
+	}
 	if it.opt.PrefetchValues {
 		item.wg.Add(1)
 		go func() {
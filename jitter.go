@@ -0,0 +1,39 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math/rand"
+	"time"
+)
+
+// jitterTTL pushes back the expiration time of e by up to Options.TTLJitter percent of its time to
+// live, so that the entries written with the same TTL don't all expire at once. Entries never
+// expire before the time they were given.
+func (db *DB) jitterTTL(e *Entry) {
+	if db.opt.TTLJitter <= 0 || e.ExpiresAt == 0 || e.meta&bitDelete > 0 {
+		return
+	}
+	now := uint64(time.Now().Unix())
+	if e.ExpiresAt <= now {
+		return
+	}
+	// Expiration times are in seconds.
+	if max := int64(float64(e.ExpiresAt-now) * db.opt.TTLJitter / 100); max > 0 {
+		e.ExpiresAt += uint64(rand.Int63n(max + 1))
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLJitter(t *testing.T) {
+	opt := getTestOptions("").WithTTLJitter(10)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		ttl := time.Hour
+		expiresAt := uint64(time.Now().Add(ttl).Unix())
+		wb := db.NewWriteBatch()
+		for i := 0; i < 100; i++ {
+			e := NewEntry([]byte(fmt.Sprintf("key%d", i)), []byte("val")).WithTTL(ttl)
+			require.NoError(t, wb.SetEntry(e))
+		}
+		require.NoError(t, wb.SetEntry(NewEntry([]byte("forever"), []byte("val"))))
+		require.NoError(t, wb.Flush())
+
+		spread := make(map[uint64]bool)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				if string(item.Key()) == "forever" {
+					require.Zero(t, item.ExpiresAt())
+					continue
+				}
+				require.True(t, item.ExpiresAt() >= expiresAt)
+				require.True(t, item.ExpiresAt() <= expiresAt+361)
+				spread[item.ExpiresAt()] = true
+			}
+			return nil
+		}))
+		require.True(t, len(spread) > 10, "%d expiration times", len(spread))
+	})
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobKind is the kind of a background job of the DB.
+type JobKind string
+
+// The kinds of background jobs recorded in the timeline of the DB.
+const (
+	JobFlush      JobKind = "flush"
+	JobCompaction JobKind = "compaction"
+	JobValueLogGC JobKind = "vlog-gc"
+)
+
+// JobLabel is the pprof label set to the kind of the background job running, in the profiles
+// of the DB. Foreground work has no such label.
+const JobLabel = "badger_job"
+
+// BackgroundJob is a background job of the DB, see DB.BackgroundJobs.
+type BackgroundJob struct {
+	Kind     JobKind
+	Start    time.Time
+	Duration time.Duration
+	// Detail tells the job apart from the others of its kind, e.g. the levels of a compaction.
+	Detail       string
+	BytesRead    int64
+	BytesWritten int64
+	// Err is the error the job failed with, if any.
+	Err error
+}
+
+// jobTimeline is a ring buffer of the last background jobs of the DB, like opLog.
+type jobTimeline struct {
+	sync.Mutex
+	jobs []BackgroundJob
+	next int  // index of the slot the next job is recorded in.
+	full bool // true once jobs wrapped around.
+}
+
+// newJobTimeline returns a jobTimeline remembering the last size jobs. It returns nil if size
+// isn't positive, which is a valid, disabled, jobTimeline.
+func newJobTimeline(size int) *jobTimeline {
+	if size <= 0 {
+		return nil
+	}
+	return &jobTimeline{jobs: make([]BackgroundJob, size)}
+}
+
+// run runs the job fn of the given kind with the JobLabel pprof label, and records it. fn fills
+// in the details of the job.
+func (t *jobTimeline) run(kind JobKind, fn func(job *BackgroundJob) error) error {
+	job := BackgroundJob{Kind: kind, Start: time.Now()}
+	pprof.Do(context.Background(), pprof.Labels(JobLabel, string(kind)), func(context.Context) {
+		job.Err = fn(&job)
+	})
+	job.Duration = time.Since(job.Start)
+	t.record(job)
+	return job.Err
+}
+
+func (t *jobTimeline) record(job BackgroundJob) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.jobs[t.next] = job
+	t.next++
+	if t.next == len(t.jobs) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// records returns the jobs in the timeline, by start time.
+func (t *jobTimeline) records() []BackgroundJob {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	var jobs []BackgroundJob
+	if !t.full {
+		jobs = append(jobs, t.jobs[:t.next]...)
+	} else {
+		jobs = append(jobs, t.jobs[t.next:]...)
+		jobs = append(jobs, t.jobs[:t.next]...)
+	}
+	t.Unlock()
+	// Jobs are recorded once done.
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Start.Before(jobs[j].Start) })
+	return jobs
+}
+
+// BackgroundJobs returns the last background jobs of the DB, up to Options.JobTimelineSize, by
+// start time: the memtable flushes, the compactions and the value log GC runs, along with their
+// durations and the bytes they read and wrote. Jobs are recorded once done.
+func (db *DB) BackgroundJobs() []BackgroundJob {
+	return db.jobs.records()
+}
+
+// traceEvent is an event of the Chrome trace event format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"` // In microseconds.
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteJobTrace writes the BackgroundJobs of the DB to w in the Chrome trace event format, which
+// chrome://tracing and Perfetto display as a timeline, one track per kind of job and concurrent
+// job. It can be lined up with the CPU profiles of the process, where the background jobs have
+// the JobLabel label.
+func (db *DB) WriteJobTrace(w io.Writer) error {
+	jobs := db.BackgroundJobs()
+	events := []traceEvent{{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]interface{}{"name": "badger " + db.opt.InstanceLabel},
+	}}
+	// Every job gets the first track of its kind free at its start.
+	type track struct {
+		tid int
+		end time.Time
+	}
+	tracks := make(map[JobKind][]*track)
+	var numTracks int
+	for _, job := range jobs {
+		var tr *track
+		for _, t := range tracks[job.Kind] {
+			if !t.end.After(job.Start) {
+				tr = t
+				break
+			}
+		}
+		if tr == nil {
+			numTracks++
+			tr = &track{tid: numTracks}
+			tracks[job.Kind] = append(tracks[job.Kind], tr)
+			events = append(events, traceEvent{
+				Name: "thread_name",
+				Ph:   "M",
+				Pid:  1,
+				Tid:  tr.tid,
+				Args: map[string]interface{}{
+					"name": fmt.Sprintf("%s %d", job.Kind, len(tracks[job.Kind])),
+				},
+			})
+		}
+		tr.end = job.Start.Add(job.Duration)
+
+		args := map[string]interface{}{
+			"bytes_read":    job.BytesRead,
+			"bytes_written": job.BytesWritten,
+		}
+		if job.Detail != "" {
+			args["detail"] = job.Detail
+		}
+		if job.Err != nil {
+			args["error"] = job.Err.Error()
+		}
+		events = append(events, traceEvent{
+			Name: string(job.Kind),
+			Cat:  "badger",
+			Ph:   "X",
+			Ts:   job.Start.UnixNano() / 1e3,
+			Dur:  int64(job.Duration / time.Microsecond),
+			Pid:  1,
+			Tid:  tr.tid,
+			Args: args,
+		})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
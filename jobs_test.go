@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackgroundJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir).WithKeepL0InMemory(false).WithManualCompactions(true).
+		WithNumLevelZeroTables(1))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%03d", j)), []byte("val"), 0)
+		}
+		require.NoError(t, db.FlushMemtable(context.Background()))
+	}
+	compacted, err := db.CompactOnce()
+	require.NoError(t, err)
+	require.True(t, compacted)
+
+	jobs := db.BackgroundJobs()
+	var flushes, compactions int
+	for i, job := range jobs {
+		if i > 0 {
+			require.False(t, job.Start.Before(jobs[i-1].Start))
+		}
+		require.NoError(t, job.Err)
+		require.True(t, job.BytesRead > 0)
+		require.True(t, job.BytesWritten > 0)
+		switch job.Kind {
+		case JobFlush:
+			flushes++
+		case JobCompaction:
+			compactions++
+			require.Equal(t, "L0->L1", job.Detail)
+		}
+	}
+	require.Equal(t, 2, flushes)
+	require.Equal(t, 1, compactions)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.WriteJobTrace(&buf))
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &trace))
+	var spans int
+	for _, ev := range trace.TraceEvents {
+		if ev.Ph == "X" {
+			spans++
+			require.True(t, ev.Tid > 0)
+		}
+	}
+	require.Equal(t, len(jobs), spans)
+}
+
+func TestJobTimeline(t *testing.T) {
+	var nilTimeline *jobTimeline
+	require.Nil(t, newJobTimeline(0))
+	require.Equal(t, errFillTables, nilTimeline.run(JobFlush, func(*BackgroundJob) error {
+		return errFillTables
+	}))
+	require.Nil(t, nilTimeline.records())
+
+	tl := newJobTimeline(2)
+	for i := 0; i < 3; i++ {
+		i := i
+		require.NoError(t, tl.run(JobCompaction, func(job *BackgroundJob) error {
+			job.BytesRead = int64(i)
+			return nil
+		}))
+	}
+	jobs := tl.records()
+	require.Len(t, jobs, 2)
+	require.Equal(t, int64(1), jobs[0].BytesRead)
+	require.Equal(t, int64(2), jobs[1].BytesRead)
+}
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/rand"
 	"encoding/binary"
@@ -25,11 +26,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -37,19 +42,59 @@ const (
 	KeyRegistryFileName = "KEYREGISTRY"
 	// KeyRegistryRewriteFileName is the file name for the rewrite key registry file.
 	KeyRegistryRewriteFileName = "REWRITE-KEYREGISTRY"
+	// keyRegistryRewriteRatio is the number of records per data key the key registry file can
+	// hold before it gets rewritten on open.
+	keyRegistryRewriteRatio = 2
 )
 
 // SanityText is used to check whether the given user provided storage key is valid or not
 var sanityText = []byte("Hello Badger")
 
+// wrappedSanityText replaces sanityText in key registries whose data keys are wrapped by a
+// KeyWrapper, so that they can't be opened without one.
+var wrappedSanityText = []byte("Wrapped keys")
+
 // KeyRegistry used to maintain all the data keys.
 type KeyRegistry struct {
 	sync.RWMutex
 	dataKeys    map[uint64]*pb.DataKey
-	lastCreated int64 //lastCreated is the timestamp(seconds) of the last data key generated.
-	nextKeyID   uint64
+	lastCreated int64  //lastCreated is the timestamp(seconds) of the last data key generated.
+	lastKeyID   uint64 // lastKeyID is the ID of the last data key generated.
+	nextKeyID   uint64 // nextKeyID is the largest data key ID generated so far.
+	numRecords  int    // numRecords is the number of data key records in the file.
 	fp          *os.File
-	opt         KeyRegistryOptions
+	opt         KeyRegistryOptions // opt.EncryptionKey is guarded by the lock.
+	encrypted   bool               // Set if the data keys are encrypted with a master key.
+
+	// The last data key generated for value log files, if they don't share keys with tables.
+	vlogLastCreated int64
+	vlogLastKeyID   uint64
+
+	// retired holds the data keys removed from the file by retireKeys, until the DB is closed.
+	retired map[uint64]*pb.DataKey
+
+	// wrapped holds the key material of the data keys wrapped by opt.KeyWrapper, as wrapped.
+	wrapped map[uint64][]byte
+
+	// kdf holds the parameters deriving the master key from opt.EncryptionPassphrase, into
+	// opt.EncryptionKey. Nil if there's no passphrase.
+	kdf *passphraseKDF
+
+	// prefixes holds opt.EncryptionKeyPrefixes, sorted. Tables of keys having one of them are
+	// encrypted with data keys of their own, whose state is in scopes.
+	prefixes [][]byte
+	scopes   map[string]*keyScope
+
+	// mem holds the master key and the key material of the data keys if opt.LockKeyMemory is
+	// set, nil otherwise.
+	mem *keyMemory
+
+	// keys holds a copy of dataKeys, replaced whenever a key is added. It's used to look up data
+	// keys without taking the lock, since that happens every time a file is opened.
+	keys atomic.Value // map[uint64]*pb.DataKey
+
+	// audit records the events of the data keys if opt.KeyAuditLog is set, nil otherwise.
+	audit *keyAudit
 }
 
 type KeyRegistryOptions struct {
@@ -58,32 +103,137 @@ type KeyRegistryOptions struct {
 	EncryptionKey                 []byte
 	EncryptionKeyRotationDuration time.Duration
 	InMemory                      bool
+	// KeyProvider supplies the master key in place of EncryptionKey. See Options.WithKeyProvider.
+	KeyProvider KeyProvider
+	// KeyWrapper wraps the data keys in place of a master key. See Options.WithKeyWrapper.
+	KeyWrapper KeyWrapper
+	// EncryptionPassphrase derives the master key in place of EncryptionKey. See
+	// Options.WithEncryptionPassphrase.
+	EncryptionPassphrase string
+	// EncryptionAlgorithm is the algorithm of the new data keys. See
+	// Options.WithEncryptionAlgorithm.
+	EncryptionAlgorithm options.EncryptionAlgorithm
+
+	ValueLogEncryptionKeyRotationDuration time.Duration
+	DisableValueLogEncryption             bool
+	DisableTableEncryption                bool
+
+	// EncryptionKeyPrefixes are the key prefixes having their own data keys. See
+	// Options.WithEncryptionKeyPrefixes.
+	EncryptionKeyPrefixes [][]byte
+
+	// LockKeyMemory keeps the master key and the data keys in locked memory, zeroized on Close.
+	// See Options.WithLockKeyMemory.
+	LockKeyMemory bool
+
+	// SecondaryEncryptionKeys are tried in turn if EncryptionKey doesn't open the registry, which
+	// then gets encrypted with EncryptionKey. See Options.WithSecondaryEncryptionKeys.
+	SecondaryEncryptionKeys [][]byte
+	// FIPSMode restricts the encryption to FIPS-approved primitives. See Options.WithFIPSMode.
+	FIPSMode bool
+	// KeyAuditLog records the events of the data keys in an audit log. See
+	// Options.WithKeyAuditLog.
+	KeyAuditLog bool
 }
 
 // newKeyRegistry returns KeyRegistry.
 func newKeyRegistry(opt KeyRegistryOptions) *KeyRegistry {
-	return &KeyRegistry{
+	kr := &KeyRegistry{
 		dataKeys:  make(map[uint64]*pb.DataKey),
 		nextKeyID: 0,
 		opt:       opt,
+		encrypted: opt.encrypted(),
+		prefixes:  sortedKeyPrefixes(opt.EncryptionKeyPrefixes),
+		scopes:    make(map[string]*keyScope),
+		wrapped:   make(map[uint64][]byte),
+	}
+	for _, p := range kr.prefixes {
+		kr.scopes[string(p)] = &keyScope{}
+	}
+	if opt.LockKeyMemory {
+		kr.mem = &keyMemory{}
+	}
+	kr.keys.Store(map[uint64]*pb.DataKey{})
+	return kr
+}
+
+// publishKeys makes the data keys available to dataKey. kr must be locked or not shared yet.
+func (kr *KeyRegistry) publishKeys() {
+	keys := make(map[uint64]*pb.DataKey, len(kr.dataKeys)+len(kr.retired))
+	for id, dk := range kr.retired {
+		keys[id] = dk
 	}
+	for id, dk := range kr.dataKeys {
+		keys[id] = dk
+	}
+	kr.keys.Store(keys)
 }
 
 // OpenKeyRegistry opens key registry if it exists, otherwise it'll create key registry
 // and returns key registry.
 func OpenKeyRegistry(opt KeyRegistryOptions) (*KeyRegistry, error) {
-	// sanity check the encryption key length.
-	if len(opt.EncryptionKey) > 0 {
-		switch len(opt.EncryptionKey) {
-		default:
-			return nil, y.Wrapf(ErrInvalidEncryptionKey, "During OpenKeyRegistry")
+	kr, err := openKeyRegistry(opt)
+	if err != nil || kr.mem == nil {
+		return kr, err
+	}
+	// The master key derived from a passphrase is only referred to by the registry, unlike
+	// EncryptionKey.
+	if opt.EncryptionPassphrase != "" {
+		kr.opt.EncryptionKey, err = kr.mem.take(kr.opt.EncryptionKey)
+	} else {
+		kr.opt.EncryptionKey, err = kr.mem.copy(kr.opt.EncryptionKey)
+	}
+	if err != nil {
+		kr.Close()
+		return nil, err
+	}
+	return kr, nil
+}
+
+func openKeyRegistry(opt KeyRegistryOptions) (*KeyRegistry, error) {
+	if opt.KeyProvider != nil && len(opt.EncryptionKey) > 0 {
+		return nil, errors.New("EncryptionKey and KeyProvider cannot both be set")
+	}
+	if opt.KeyWrapper != nil && (opt.KeyProvider != nil || len(opt.EncryptionKey) > 0) {
+		return nil, errors.New("KeyWrapper cannot be set along with EncryptionKey or KeyProvider")
+	}
+	if opt.EncryptionPassphrase != "" &&
+		(opt.KeyProvider != nil || opt.KeyWrapper != nil || len(opt.EncryptionKey) > 0) {
+		return nil, errors.New("EncryptionPassphrase cannot be set along with EncryptionKey, " +
+			"KeyProvider or KeyWrapper")
+	}
+	if len(opt.SecondaryEncryptionKeys) > 0 && len(opt.EncryptionKey) == 0 {
+		return nil, errors.New("SecondaryEncryptionKeys require EncryptionKey")
+	}
+	for _, key := range opt.SecondaryEncryptionKeys {
+		switch len(key) {
 		case 16, 24, 32:
-			break
+		default:
+			return nil, y.Wrapf(ErrInvalidEncryptionKey, "Invalid secondary encryption key")
 		}
 	}
+	if err := validateKeyPrefixes(opt); err != nil {
+		return nil, err
+	}
+	if err := validateFIPS(&opt); err != nil {
+		return nil, err
+	}
+	// Get the master key, which also sanity checks its length.
+	masterKey, err := opt.masterKey(context.Background())
+	if err != nil {
+		return nil, y.Wrapf(err, "During OpenKeyRegistry")
+	}
 	// If db is opened in InMemory mode, we don't need to write key registry to the disk.
 	if opt.InMemory {
-		return newKeyRegistry(opt), nil
+		kr := newKeyRegistry(opt)
+		if opt.EncryptionPassphrase != "" {
+			// The master key isn't stored anywhere, any key does.
+			if kr.kdf, err = newPassphraseKDF(opt.FIPSMode); err != nil {
+				return nil, err
+			}
+			kr.opt.EncryptionKey = kr.kdf.deriveKey(opt.EncryptionPassphrase)
+		}
+		return kr, nil
 	}
 	path := filepath.Join(opt.Dir, KeyRegistryFileName)
 	var flags uint32
@@ -92,6 +242,7 @@ func OpenKeyRegistry(opt KeyRegistryOptions) (*KeyRegistry, error) {
 	} else {
 		flags |= y.Sync
 	}
+	var kdf *passphraseKDF
 	fp, err := y.OpenExistingFile(path, flags)
 	// OpenExistingFile just open file.
 	// So checking whether the file exist or not. If not
@@ -102,8 +253,15 @@ func OpenKeyRegistry(opt KeyRegistryOptions) (*KeyRegistry, error) {
 		if opt.ReadOnly {
 			return kr, nil
 		}
+		if opt.EncryptionPassphrase != "" {
+			if kdf, err = newPassphraseKDF(opt.FIPSMode); err != nil {
+				return nil, err
+			}
+			masterKey = kdf.deriveKey(opt.EncryptionPassphrase)
+		}
 		// Writing the key registry to the file.
-		if err := WriteKeyRegistry(kr, opt); err != nil {
+		err = writeKeyRegistry(kr, opt.Dir, masterKey, opt.KeyWrapper != nil, opt.FIPSMode, kdf)
+		if err != nil {
 			return nil, y.Wrapf(err, "Error while writing key registry.")
 		}
 		fp, err = y.OpenExistingFile(path, flags)
@@ -113,21 +271,111 @@ func OpenKeyRegistry(opt KeyRegistryOptions) (*KeyRegistry, error) {
 	} else if err != nil {
 		return nil, y.Wrapf(err, "Error while opening key registry.")
 	}
-	kr, err := readKeyRegistry(fp, opt)
+	if opt.EncryptionPassphrase != "" {
+		// The file starts with the parameters deriving the master key. They're known already if
+		// the file was just created.
+		read, err := readPassphraseKDF(fp)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		if opt.FIPSMode && !read.pbkdf2() {
+			// Don't even derive the key with Argon2id.
+			fp.Close()
+			return nil, y.Wrapf(ErrFIPSModeMismatch, "Key registry passphrase uses Argon2id")
+		}
+		if kdf == nil || *kdf != *read {
+			kdf = read
+			masterKey = kdf.deriveKey(opt.EncryptionPassphrase)
+		}
+		opt.EncryptionKey = masterKey
+	}
+	kr, err := readKeyRegistry(fp, opt, masterKey)
+	// During a rotation of the master key, the registry may still be encrypted with a previous
+	// key. It gets encrypted with the current one then, unless it's opened in read-only mode.
+	var rekey bool
+	for _, key := range opt.SecondaryEncryptionKeys {
+		if errors.Cause(err) != ErrEncryptionKeyMismatch {
+			break
+		}
+		if _, err = fp.Seek(0, io.SeekStart); err != nil {
+			err = y.Wrapf(err, "Error while seeking key registry.")
+			break
+		}
+		kr, err = readKeyRegistry(fp, opt, key)
+		rekey = err == nil
+	}
 	if err != nil {
 		// This case happens only if the file is opened properly and
 		// not able to read.
 		fp.Close()
 		return nil, err
 	}
+	kr.kdf = kdf
 	if opt.ReadOnly {
 		// We'll close the file in readonly mode.
 		return kr, fp.Close()
 	}
 	kr.fp = fp
+	if rekey || kr.numRecords > keyRegistryRewriteRatio*len(kr.dataKeys) {
+		// Most of the records are duplicates, or encrypted with a secondary key, rewrite them.
+		if err := kr.rewrite(masterKey); err != nil {
+			kr.Close()
+			return nil, err
+		}
+	}
+	if opt.KeyAuditLog && kr.encrypted {
+		if kr.audit, err = openKeyAudit(opt.Dir); err != nil {
+			kr.Close()
+			return nil, err
+		}
+	}
 	return kr, nil
 }
 
+// VerifyEncryptionKey checks that key is the encryption key of the DB in dir, without opening the
+// DB: it only reads the sanity text at the start of the key registry, so it's cheap, and it can be
+// called while the DB is open. It returns ErrEncryptionKeyMismatch if key isn't the encryption key,
+// and an error satisfying os.IsNotExist if dir has no key registry. An empty key checks that the
+// DB isn't encrypted. The key registries protected by a passphrase or a KeyWrapper have no such
+// key, and give errors.
+func VerifyEncryptionKey(dir string, key []byte) error {
+	switch len(key) {
+	case 0, 16, 24, 32:
+	default:
+		return ErrInvalidEncryptionKey
+	}
+	fp, err := y.OpenExistingFile(filepath.Join(dir, KeyRegistryFileName), y.ReadOnly)
+	if os.IsNotExist(err) {
+		return err
+	} else if err != nil {
+		return y.Wrapf(err, "Error while opening key registry.")
+	}
+	defer fp.Close()
+	switch err := validRegistry(fp, key, false, false); err {
+	case ErrFIPSModeMismatch:
+		// The key matches all the same.
+		return nil
+	case ErrEncryptionKeyMismatch:
+	default:
+		return err
+	}
+	// Tell the key registries which aren't encrypted with a master key apart.
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return y.Wrapf(err, "Error while seeking key registry.")
+	}
+	if err := validRegistry(fp, nil, true, false); err == nil || err == ErrFIPSModeMismatch {
+		return errors.New("Key registry is protected by a KeyWrapper")
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return y.Wrapf(err, "Error while seeking key registry.")
+	}
+	if _, err := readPassphraseKDF(fp); err == nil {
+		return errors.New("Key registry is protected by a passphrase")
+	}
+	return ErrEncryptionKeyMismatch
+}
+
 // keyRegistryIterator reads all the datakey from the key registry
 type keyRegistryIterator struct {
 	encryptionKey []byte
@@ -137,17 +385,21 @@ type keyRegistryIterator struct {
 }
 
 // newKeyRegistryIterator returns iterator which will allow you to iterate
-// over the data key of the key registry.
-func newKeyRegistryIterator(fp *os.File, encryptionKey []byte) (*keyRegistryIterator, error) {
+// over the data key of the key registry. wrapped is set if the data keys are wrapped by a
+// KeyWrapper, which the iterator leaves to the caller to unwrap, and fips if the registry is
+// expected to be created in FIPS mode.
+func newKeyRegistryIterator(fp *os.File, encryptionKey []byte,
+	wrapped, fips bool) (*keyRegistryIterator, error) {
 	return &keyRegistryIterator{
 		encryptionKey: encryptionKey,
 		fp:            fp,
 		lenCrcBuf:     [8]byte{},
-	}, validRegistry(fp, encryptionKey)
+	}, validRegistry(fp, encryptionKey, wrapped, fips)
 }
 
-// validRegistry checks that given encryption key is valid or not.
-func validRegistry(fp *os.File, encryptionKey []byte) error {
+// validRegistry checks that given encryption key is valid or not. It returns ErrFIPSModeMismatch
+// if the key is valid, but the registry was created with the other FIPS mode.
+func validRegistry(fp *os.File, encryptionKey []byte, wrapped, fips bool) error {
 	iv := make([]byte, aes.BlockSize)
 	var err error
 	if _, err = fp.Read(iv); err != nil {
@@ -164,10 +416,14 @@ func validRegistry(fp *os.File, encryptionKey []byte) error {
 		}
 	}
 	// Check the given key is valid or not.
-	if !bytes.Equal(eSanityText, sanityText) {
+	switch {
+	case bytes.Equal(eSanityText, registrySanityText(wrapped, fips)):
+		return nil
+	case bytes.Equal(eSanityText, registrySanityText(wrapped, !fips)):
+		return ErrFIPSModeMismatch
+	default:
 		return ErrEncryptionKeyMismatch
 	}
-	return nil
 }
 
 func (kri *keyRegistryIterator) next() (*pb.DataKey, error) {
@@ -198,9 +454,9 @@ func (kri *keyRegistryIterator) next() (*pb.DataKey, error) {
 	if err = dataKey.Unmarshal(data); err != nil {
 		return nil, y.Wrapf(err, "While unmarshal of datakey in keyRegistryIterator.next")
 	}
-	if len(kri.encryptionKey) > 0 {
+	if len(kri.encryptionKey) > 0 && dataKey.WrappingKey == "" {
 		// Decrypt the key if the storage key exists.
-		if dataKey.Data, err = y.XORBlock(dataKey.Data, kri.encryptionKey, dataKey.Iv); err != nil {
+		if dataKey.Data, err = openDataKey(kri.encryptionKey, dataKey); err != nil {
 			return nil, y.Wrapf(err, "While decrypting datakey in keyRegistryIterator.next")
 		}
 	}
@@ -208,8 +464,9 @@ func (kri *keyRegistryIterator) next() (*pb.DataKey, error) {
 }
 
 // readKeyRegistry will read the key registry file and build the key registry struct.
-func readKeyRegistry(fp *os.File, opt KeyRegistryOptions) (*KeyRegistry, error) {
-	itr, err := newKeyRegistryIterator(fp, opt.EncryptionKey)
+func readKeyRegistry(fp *os.File, opt KeyRegistryOptions, masterKey []byte) (*KeyRegistry,
+	error) {
+	itr, err := newKeyRegistryIterator(fp, masterKey, opt.KeyWrapper != nil, opt.FIPSMode)
 	if err != nil {
 		return nil, err
 	}
@@ -217,16 +474,37 @@ func readKeyRegistry(fp *os.File, opt KeyRegistryOptions) (*KeyRegistry, error)
 	var dk *pb.DataKey
 	dk, err = itr.next()
 	for err == nil && dk != nil {
+		if dk.WrappingKey != "" {
+			if err = kr.unwrapDataKey(context.Background(), dk); err != nil {
+				return nil, err
+			}
+		}
+		if dk.Data, err = kr.mem.take(dk.Data); err != nil {
+			return nil, err
+		}
+		if opt.FIPSMode && dk.Algo != pb.EncryptionAlgo_aes_gcm {
+			return nil, errors.Errorf("Data key %d isn't AES-GCM in FIPS mode", dk.KeyId)
+		}
 		if dk.KeyId > kr.nextKeyID {
 			// Set the maximum key ID for next key ID generation.
 			kr.nextKeyID = dk.KeyId
 		}
-		if dk.CreatedAt > kr.lastCreated {
-			// Set the last generated key timestamp.
-			kr.lastCreated = dk.CreatedAt
+		// Keys are generated in ID order, so the key with the largest ID is the last generated.
+		if len(dk.Prefix) > 0 {
+			// Keys of prefixes which aren't configured anymore are only used to read tables.
+			if sc, ok := kr.scopes[string(dk.Prefix)]; ok && dk.KeyId > sc.lastKeyID {
+				sc.lastKeyID, sc.lastCreated = dk.KeyId, dk.CreatedAt
+			}
+		} else if dk.Purpose == pb.DataKey_VLOG {
+			if dk.KeyId > kr.vlogLastKeyID {
+				kr.vlogLastKeyID, kr.vlogLastCreated = dk.KeyId, dk.CreatedAt
+			}
+		} else if dk.KeyId > kr.lastKeyID {
+			kr.lastKeyID, kr.lastCreated = dk.KeyId, dk.CreatedAt
 		}
 		// No need to lock since we are building the initial state.
 		kr.dataKeys[dk.KeyId] = dk
+		kr.numRecords++
 		// Forward the iterator.
 		dk, err = itr.next()
 	}
@@ -234,6 +512,7 @@ func readKeyRegistry(fp *os.File, opt KeyRegistryOptions) (*KeyRegistry, error)
 	if err == io.EOF {
 		err = nil
 	}
+	kr.publishKeys()
 	return kr, err
 }
 
@@ -242,19 +521,48 @@ Structure of Key Registry.
 +-------------------+---------------------+--------------------+--------------+------------------+
 |     IV            | Sanity Text         | DataKey1           | DataKey2     | ...              |
 +-------------------+---------------------+--------------------+--------------+------------------+
+
+The key registry of a DB encrypted with a passphrase starts with the parameters deriving its
+master key, see passphraseKDF.
 */
 
 // WriteKeyRegistry will rewrite the existing key registry file with new one.
 // It is okay to give closed key registry. Since, it's using only the datakey.
 func WriteKeyRegistry(reg *KeyRegistry, opt KeyRegistryOptions) error {
+	if err := validateFIPS(&opt); err != nil {
+		return y.Wrapf(err, "During WriteKeyRegistry")
+	}
+	masterKey, err := opt.masterKey(context.Background())
+	if err != nil {
+		return y.Wrapf(err, "During WriteKeyRegistry")
+	}
+	var kdf *passphraseKDF
+	if opt.EncryptionPassphrase != "" {
+		if kdf, err = newPassphraseKDF(opt.FIPSMode); err != nil {
+			return y.Wrapf(err, "During WriteKeyRegistry")
+		}
+		masterKey = kdf.deriveKey(opt.EncryptionPassphrase)
+	}
+	return writeKeyRegistry(reg, opt.Dir, masterKey, opt.KeyWrapper != nil, opt.FIPSMode, kdf)
+}
+
+// writeKeyRegistry writes the key registry file in dir, with the data keys encrypted with
+// masterKey. wrapped is set if the data keys are wrapped by a KeyWrapper instead, fips if the
+// registry is in FIPS mode, and kdf is set if masterKey is derived from a passphrase with these
+// parameters.
+func writeKeyRegistry(reg *KeyRegistry, dir string, masterKey []byte, wrapped, fips bool,
+	kdf *passphraseKDF) error {
 	buf := &bytes.Buffer{}
+	if kdf != nil {
+		y.Check2(buf.Write(kdf.encode()))
+	}
 	iv, err := y.GenerateIV()
 	y.Check(err)
 	// Encrypt sanity text if the encryption key is presents.
-	eSanity := sanityText
-	if len(opt.EncryptionKey) > 0 {
+	eSanity := registrySanityText(wrapped, fips)
+	if !wrapped && len(masterKey) > 0 {
 		var err error
-		eSanity, err = y.XORBlock(eSanity, opt.EncryptionKey, iv)
+		eSanity, err = y.XORBlock(eSanity, masterKey, iv)
 		if err != nil {
 			return y.Wrapf(err, "Error while encrpting sanity text in WriteKeyRegistry")
 		}
@@ -264,11 +572,11 @@ func WriteKeyRegistry(reg *KeyRegistry, opt KeyRegistryOptions) error {
 	// Write all the datakeys to the buf.
 	for _, k := range reg.dataKeys {
 		// Writing the datakey to the given buffer.
-		if err := storeDataKey(buf, opt.EncryptionKey, k); err != nil {
+		if err := reg.storeKey(buf, masterKey, k); err != nil {
 			return y.Wrapf(err, "Error while storing datakey in WriteKeyRegistry")
 		}
 	}
-	tmpPath := filepath.Join(opt.Dir, KeyRegistryRewriteFileName)
+	tmpPath := filepath.Join(dir, KeyRegistryRewriteFileName)
 	// Open temporary file to write the data and do atomic rename.
 	fp, err := y.OpenTruncFile(tmpPath, true)
 	if err != nil {
@@ -287,43 +595,133 @@ func WriteKeyRegistry(reg *KeyRegistry, opt KeyRegistryOptions) error {
 		return y.Wrapf(err, "Error while closing tmp file in WriteKeyRegistry")
 	}
 	// Rename to the original file.
-	if err = os.Rename(tmpPath, filepath.Join(opt.Dir, KeyRegistryFileName)); err != nil {
+	if err = os.Rename(tmpPath, filepath.Join(dir, KeyRegistryFileName)); err != nil {
 		return y.Wrapf(err, "Error while renaming file in WriteKeyRegistry")
 	}
 	// Sync Dir.
-	return syncDir(opt.Dir)
+	return syncDir(dir)
 }
 
 // dataKey returns datakey of the given key id.
 func (kr *KeyRegistry) dataKey(id uint64) (*pb.DataKey, error) {
-	kr.RLock()
-	defer kr.RUnlock()
 	if id == 0 {
 		// nil represent plain text.
 		return nil, nil
 	}
-	dk, ok := kr.dataKeys[id]
+	dk, ok := kr.keys.Load().(map[uint64]*pb.DataKey)[id]
 	if !ok {
 		return nil, y.Wrapf(ErrInvalidDataKeyID, "Error for the KEY ID %d", id)
 	}
 	return dk, nil
 }
 
-// latestDataKey will give you the latest generated datakey based on the rotation
+// separateVlogKeys returns true if value log files don't share data keys with tables.
+func (kr *KeyRegistry) separateVlogKeys() bool {
+	return kr.opt.ValueLogEncryptionKeyRotationDuration > 0
+}
+
+// latestDataKey will give you the latest generated datakey for tables based on the rotation
 // period. If the last generated datakey lifetime exceeds the rotation period.
-// It'll create new datakey.
-func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
-	if len(kr.opt.EncryptionKey) == 0 {
+// It'll create new datakey. key is any key of the table, without timestamp, or nil if the table
+// only holds keys without a prefix of their own. All the keys of a table must have the same
+// scope, see keyScope.
+func (kr *KeyRegistry) latestDataKey(key []byte) (*pb.DataKey, error) {
+	if kr.opt.DisableTableEncryption {
+		return nil, nil
+	}
+	if prefix := kr.keyScope(key); prefix != nil {
+		sc := kr.scopes[string(prefix)]
+		return kr.rotatedDataKey(pb.DataKey_TABLE, prefix, &sc.lastKeyID, &sc.lastCreated,
+			kr.opt.EncryptionKeyRotationDuration)
+	}
+	purpose := pb.DataKey_ANY
+	if kr.separateVlogKeys() {
+		purpose = pb.DataKey_TABLE
+	}
+	return kr.rotatedDataKey(purpose, nil, &kr.lastKeyID, &kr.lastCreated,
+		kr.opt.EncryptionKeyRotationDuration)
+}
+
+// vlogDataKey is like latestDataKey, but for value log files.
+func (kr *KeyRegistry) vlogDataKey() (*pb.DataKey, error) {
+	if kr.opt.DisableValueLogEncryption {
+		return nil, nil
+	}
+	if !kr.separateVlogKeys() {
+		return kr.rotatedDataKey(pb.DataKey_ANY, nil, &kr.lastKeyID, &kr.lastCreated,
+			kr.opt.EncryptionKeyRotationDuration)
+	}
+	return kr.rotatedDataKey(pb.DataKey_VLOG, nil, &kr.vlogLastKeyID, &kr.vlogLastCreated,
+		kr.opt.ValueLogEncryptionKeyRotationDuration)
+}
+
+// rotateOlderThan makes sure the data keys used for new files have IDs of at least keyID, by
+// generating new data keys on the next use if needed.
+func (kr *KeyRegistry) rotateOlderThan(keyID uint64) error {
+	kr.Lock()
+	defer kr.Unlock()
+	// New keys get ID nextKeyID+1, so a larger ID can't be reached.
+	if keyID > kr.nextKeyID+1 {
+		return y.Wrapf(ErrInvalidDataKeyID, "Cannot rotate data keys to the KEY ID %d", keyID)
+	}
+	if kr.lastKeyID < keyID {
+		kr.lastCreated = 0
+	}
+	if kr.vlogLastKeyID < keyID {
+		kr.vlogLastCreated = 0
+	}
+	for _, sc := range kr.scopes {
+		if sc.lastKeyID < keyID {
+			sc.lastCreated = 0
+		}
+	}
+	return nil
+}
+
+// forceRotation generates new data keys for the new files right away, whatever the rotation
+// durations, and returns the ID of the first one. Every data key used for new files then has an
+// ID of at least that.
+func (kr *KeyRegistry) forceRotation() (uint64, error) {
+	kr.RLock()
+	keyID := kr.nextKeyID + 1
+	kr.RUnlock()
+	if err := kr.rotateOlderThan(keyID); err != nil {
+		return 0, err
+	}
+	if _, err := kr.latestDataKey(nil); err != nil {
+		return 0, err
+	}
+	// kr.prefixes doesn't change once the registry is open.
+	for _, prefix := range kr.prefixes {
+		if _, err := kr.latestDataKey(prefix); err != nil {
+			return 0, err
+		}
+	}
+	if kr.separateVlogKeys() {
+		if _, err := kr.vlogDataKey(); err != nil {
+			return 0, err
+		}
+	}
+	return keyID, nil
+}
+
+// rotatedDataKey returns the data key with ID *lastKeyID, unless it was created more than
+// rotation ago. In that case, it generates a new data key for the given purpose and key prefix,
+// and updates *lastKeyID and *lastCreated. Both must only be accessed with kr locked.
+func (kr *KeyRegistry) rotatedDataKey(purpose pb.DataKey_Purpose, prefix []byte,
+	lastKeyID *uint64, lastCreated *int64, rotation time.Duration) (*pb.DataKey, error) {
+	if !kr.encrypted {
 		// nil is for no encryption.
 		return nil, nil
 	}
+	algo := pb.EncryptionAlgo(kr.opt.EncryptionAlgorithm)
 	// validKey return datakey if the last generated key duration less than
-	// rotation duration.
+	// rotation duration, and it uses the configured algorithm.
 	validKey := func() (*pb.DataKey, bool) {
 		// Time diffrence from the last generated time.
-		diff := time.Since(time.Unix(kr.lastCreated, 0))
-		if diff < kr.opt.EncryptionKeyRotationDuration {
-			return kr.dataKeys[kr.nextKeyID], true
+		diff := time.Since(time.Unix(*lastCreated, 0))
+		if dk := kr.dataKeys[*lastKeyID]; diff < rotation && dk != nil && dk.Algo == algo {
+			return dk, true
 		}
 		return nil, false
 	}
@@ -342,7 +740,15 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 	if valid {
 		return key, nil
 	}
-	k := make([]byte, len(kr.opt.EncryptionKey))
+	masterKey, err := kr.opt.masterKey(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	keySize := len(masterKey)
+	if kr.opt.KeyWrapper != nil {
+		keySize = wrappedKeySize
+	}
+	k := make([]byte, keySize)
 	iv, err := y.GenerateIV()
 	if err != nil {
 		return nil, err
@@ -351,6 +757,9 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	if k, err = kr.mem.take(k); err != nil {
+		return nil, err
+	}
 	// Otherwise Increment the KeyID and generate new datakey.
 	kr.nextKeyID++
 	dk := &pb.DataKey{
@@ -358,12 +767,20 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 		Data:      k,
 		CreatedAt: time.Now().Unix(),
 		Iv:        iv,
+		Purpose:   purpose,
+		Algo:      algo,
+		Prefix:    prefix,
+	}
+	if kr.opt.KeyWrapper != nil {
+		if err = kr.wrapDataKey(context.Background(), dk); err != nil {
+			return nil, err
+		}
 	}
 	// Don't store the datakey on file if badger is running in InMemory mode.
 	if !kr.opt.InMemory {
 		// Store the datekey.
 		buf := &bytes.Buffer{}
-		if err = storeDataKey(buf, kr.opt.EncryptionKey, dk); err != nil {
+		if err = kr.storeKey(buf, masterKey, dk); err != nil {
 			return nil, err
 		}
 		// Persist the datakey to the disk
@@ -373,52 +790,184 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 	}
 	// storeDatakey encrypts the datakey So, placing un-encrypted key in the memory.
 	dk.Data = k
-	kr.lastCreated = dk.CreatedAt
+	events := []KeyEvent{{Kind: KeyCreated, KeyID: dk.KeyId}}
+	if *lastKeyID != 0 {
+		events = append(events, KeyEvent{Kind: KeyRotated, KeyID: dk.KeyId,
+			PreviousKeyID: *lastKeyID})
+	}
+	*lastKeyID, *lastCreated = dk.KeyId, dk.CreatedAt
 	kr.dataKeys[kr.nextKeyID] = dk
+	kr.numRecords++
+	kr.publishKeys()
+	if err := kr.audit.record(events...); err != nil {
+		return nil, err
+	}
 	return dk, nil
 }
 
-// Close closes the key registry.
+// Compact rewrites the key registry file, keeping a single record for every data key. It's done
+// automatically on open if the file holds more than twice as many records as data keys.
+func (kr *KeyRegistry) Compact() error {
+	if kr.opt.ReadOnly || kr.opt.InMemory {
+		return nil
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	masterKey, err := kr.opt.masterKey(context.Background())
+	if err != nil {
+		return err
+	}
+	return kr.rewrite(masterKey)
+}
+
+// rewrite replaces the key registry file with one holding the data keys in memory, encrypted with
+// masterKey, and reopens it for appending. kr must be locked or not shared yet.
+func (kr *KeyRegistry) rewrite(masterKey []byte) error {
+	// In Windows the file should be closed before it gets replaced.
+	if err := kr.fp.Close(); err != nil {
+		return y.Wrapf(err, "Error while closing key registry.")
+	}
+	werr := writeKeyRegistry(kr, kr.opt.Dir, masterKey, kr.opt.KeyWrapper != nil, kr.opt.FIPSMode,
+		kr.kdf)
+	// Reopen the file even if the rewrite failed, the old file is still in place in that case.
+	fp, err := y.OpenExistingFile(filepath.Join(kr.opt.Dir, KeyRegistryFileName), y.Sync)
+	if err != nil {
+		return y.Wrapf(err, "Error while reopening key registry.")
+	}
+	kr.fp = fp
+	if _, err := fp.Seek(0, io.SeekEnd); err != nil {
+		return y.Wrapf(err, "Error while seeking to the end of key registry.")
+	}
+	if werr != nil {
+		return y.Wrapf(werr, "Error while rewriting key registry.")
+	}
+	kr.numRecords = len(kr.dataKeys)
+	return nil
+}
+
+// DataKeyInfo describes a data key, without its key material.
+type DataKeyInfo struct {
+	KeyID     uint64
+	CreatedAt time.Time
+	Purpose   pb.DataKey_Purpose
+	// Algorithm encrypts the key with the master key, and the table blocks with the key.
+	Algorithm options.EncryptionAlgorithm
+	// Prefix is the key prefix of the tables the key encrypts, if it's scoped to one. See
+	// Options.WithEncryptionKeyPrefixes.
+	Prefix []byte
+	// Current is set for the latest key of its purpose and prefix, which new files get encrypted
+	// with until it's older than the rotation duration. Keys are rotated when they're needed, so
+	// a current key can be older than that while nothing gets written.
+	Current bool
+	// WrappingKey is the label of the KeyWrapper key the key is wrapped with, if it's wrapped.
+	WrappingKey string
+}
+
+// DataKeys returns the description of all the data keys in the registry, sorted by key ID.
+func (kr *KeyRegistry) DataKeys() []DataKeyInfo {
+	kr.RLock()
+	defer kr.RUnlock()
+	current := map[uint64]bool{kr.lastKeyID: true, kr.vlogLastKeyID: true}
+	for _, sc := range kr.scopes {
+		current[sc.lastKeyID] = true
+	}
+	res := make([]DataKeyInfo, 0, len(kr.dataKeys))
+	for _, dk := range kr.dataKeys {
+		res = append(res, DataKeyInfo{
+			KeyID:       dk.KeyId,
+			CreatedAt:   time.Unix(dk.CreatedAt, 0),
+			Purpose:     dk.Purpose,
+			Algorithm:   options.EncryptionAlgorithm(dk.Algo),
+			Prefix:      y.SafeCopy(nil, dk.Prefix),
+			Current:     current[dk.KeyId],
+			WrappingKey: dk.WrappingKey,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].KeyID < res[j].KeyID })
+	return res
+}
+
+// DataKey returns the data key with the given ID along with its key material, for tools which
+// decrypt files without opening the DB. The ID zero stands for plain text, for which it returns
+// nil.
+func (kr *KeyRegistry) DataKey(id uint64) (*pb.DataKey, error) {
+	return kr.dataKey(id)
+}
+
+// Close closes the key registry. With LockKeyMemory, the master key and the key material of the
+// data keys get zeroized, including the data keys returned by DataKey.
 func (kr *KeyRegistry) Close() error {
+	var err error
 	if !(kr.opt.ReadOnly || kr.opt.InMemory) {
-		return kr.fp.Close()
+		err = kr.fp.Close()
 	}
-	return nil
+	if aerr := kr.audit.close(); err == nil {
+		err = aerr
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	if merr := kr.mem.release(); err == nil {
+		err = merr
+	}
+	return err
 }
 
 // storeDataKey stores datakey in an encrypted format in the given buffer. If storage key preset.
 func storeDataKey(buf *bytes.Buffer, storageKey []byte, k *pb.DataKey) error {
-	// xor will encrypt the IV and xor with the given data.
-	// It'll used for both encryption and decryption.
-	xor := func() error {
-		if len(storageKey) == 0 {
-			return nil
-		}
-		var err error
-		k.Data, err = y.XORBlock(k.Data, storageKey, k.Iv)
-		return err
-	}
-	// In memory datakey will be plain text so encrypting before storing to the disk.
+	// In memory datakey will be plain text so encrypting a copy before storing to the disk.
+	stored := *k
 	var err error
-	if err = xor(); err != nil {
-		return y.Wrapf(err, "Error while encrypting datakey in storeDataKey")
+	if len(storageKey) > 0 {
+		if stored.Data, err = sealDataKey(storageKey, k); err != nil {
+			return y.Wrapf(err, "Error while encrypting datakey in storeDataKey")
+		}
 	}
 	var data []byte
-	if data, err = k.Marshal(); err != nil {
-		err = y.Wrapf(err, "Error while marshaling datakey in storeDataKey")
-		var err2 error
-		// decrypting the datakey back.
-		if err2 = xor(); err2 != nil {
-			return y.Wrapf(err,
-				y.Wrapf(err2, "Error while decrypting datakey in storeDataKey").Error())
-		}
-		return err
+	if data, err = stored.Marshal(); err != nil {
+		return y.Wrapf(err, "Error while marshaling datakey in storeDataKey")
 	}
 	var lenCrcBuf [8]byte
 	binary.BigEndian.PutUint32(lenCrcBuf[0:4], uint32(len(data)))
 	binary.BigEndian.PutUint32(lenCrcBuf[4:8], crc32.Checksum(data, y.CastagnoliCrcTable))
 	y.Check2(buf.Write(lenCrcBuf[:]))
 	y.Check2(buf.Write(data))
-	// Decrypting the datakey back since we're using the pointer.
-	return xor()
+	return nil
+}
+
+// sealDataKey returns the key material of k encrypted with storageKey, using the algorithm of k.
+// With AES-GCM, the authentication tag follows the encrypted key, and covers its ID too.
+func sealDataKey(storageKey []byte, k *pb.DataKey) ([]byte, error) {
+	if k.Algo != pb.EncryptionAlgo_aes_gcm {
+		return y.XORBlock(k.Data, storageKey, k.Iv)
+	}
+	aead, err := y.NewGCM(storageKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, k.Iv[:aead.NonceSize()], k.Data, keyIDBytes(k.KeyId)), nil
+}
+
+// openDataKey returns the key material of k, as read from the registry, decrypted with
+// storageKey. It fails if k was encrypted with AES-GCM and got tampered with.
+func openDataKey(storageKey []byte, k *pb.DataKey) ([]byte, error) {
+	if k.Algo != pb.EncryptionAlgo_aes_gcm {
+		return y.XORBlock(k.Data, storageKey, k.Iv)
+	}
+	aead, err := y.NewGCM(storageKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, k.Iv[:aead.NonceSize()], k.Data, keyIDBytes(k.KeyId))
+	if err != nil {
+		return nil, y.Wrapf(ErrEncryptionKeyMismatch, "Authentication of data key %d failed",
+			k.KeyId)
+	}
+	return data, nil
+}
+
+// keyIDBytes returns the additional data authenticated along with a data key.
+func keyIDBytes(id uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], id)
+	return b[:]
 }
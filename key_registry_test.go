@@ -16,10 +16,17 @@
 package badger
 
 import (
+	"bytes"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -40,12 +47,12 @@ func TestBuildRegistry(t *testing.T) {
 	opt := getRegistryTestOptions(dir, encryptionKey)
 	kr, err := OpenKeyRegistry(opt)
 	require.NoError(t, err)
-	dk, err := kr.latestDataKey()
+	dk, err := kr.latestDataKey(nil)
 	require.NoError(t, err)
 	// We're resetting the last created timestamp. So, it creates
 	// new datakey.
 	kr.lastCreated = 0
-	dk1, err := kr.latestDataKey()
+	dk1, err := kr.latestDataKey(nil)
 	// We generated two key. So, checking the length.
 	require.Equal(t, 2, len(kr.dataKeys))
 	require.NoError(t, err)
@@ -69,12 +76,12 @@ func TestRewriteRegistry(t *testing.T) {
 	opt := getRegistryTestOptions(dir, encryptionKey)
 	kr, err := OpenKeyRegistry(opt)
 	require.NoError(t, err)
-	_, err = kr.latestDataKey()
+	_, err = kr.latestDataKey(nil)
 	require.NoError(t, err)
 	// We're resetting the last created timestamp. So, it creates
 	// new datakey.
 	kr.lastCreated = 0
-	_, err = kr.latestDataKey()
+	_, err = kr.latestDataKey(nil)
 	require.NoError(t, err)
 	require.NoError(t, kr.Close())
 	delete(kr.dataKeys, 1)
@@ -110,6 +117,93 @@ func TestMismatch(t *testing.T) {
 	require.EqualError(t, err, ErrEncryptionKeyMismatch.Error())
 }
 
+func TestVerifyEncryptionKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	require.True(t, os.IsNotExist(VerifyEncryptionKey(dir, nil)))
+
+	encryptionKey := make([]byte, 32)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+	kr, err := OpenKeyRegistry(getRegistryTestOptions(dir, encryptionKey))
+	require.NoError(t, err)
+	// The registry is readable while it's open.
+	require.NoError(t, VerifyEncryptionKey(dir, encryptionKey))
+	require.NoError(t, kr.Close())
+
+	otherKey := make([]byte, 32)
+	_, err = rand.Read(otherKey)
+	require.NoError(t, err)
+	require.Equal(t, ErrEncryptionKeyMismatch, VerifyEncryptionKey(dir, otherKey))
+	require.Equal(t, ErrEncryptionKeyMismatch, VerifyEncryptionKey(dir, nil))
+	require.Equal(t, ErrInvalidEncryptionKey, VerifyEncryptionKey(dir, []byte("short")))
+
+	// A DB which isn't encrypted only verifies with an empty key.
+	plainDir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(plainDir)
+	kr, err = OpenKeyRegistry(getRegistryTestOptions(plainDir, nil))
+	require.NoError(t, err)
+	require.NoError(t, kr.Close())
+	require.NoError(t, VerifyEncryptionKey(plainDir, nil))
+	require.Equal(t, ErrEncryptionKeyMismatch, VerifyEncryptionKey(plainDir, encryptionKey))
+}
+
+func TestSecondaryEncryptionKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	oldKey, newKey := make([]byte, 32), make([]byte, 16)
+	_, err = rand.Read(oldKey)
+	require.NoError(t, err)
+	_, err = rand.Read(newKey)
+	require.NoError(t, err)
+
+	kr, err := OpenKeyRegistry(getRegistryTestOptions(dir, oldKey))
+	require.NoError(t, err)
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.NoError(t, kr.Close())
+
+	// The primary key is tried first, then the secondary keys in turn.
+	opt := getRegistryTestOptions(dir, newKey)
+	_, err = OpenKeyRegistry(opt)
+	require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+	opt.SecondaryEncryptionKeys = [][]byte{make([]byte, 24), oldKey}
+	opt.ReadOnly = true
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	require.NoError(t, kr.Close())
+	// Read-only mode leaves the registry encrypted with the secondary key.
+	require.NoError(t, VerifyEncryptionKey(dir, oldKey))
+
+	opt.ReadOnly = false
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	read, err := kr.dataKey(dk.KeyId)
+	require.NoError(t, err)
+	require.Equal(t, dk.Data, read.Data)
+	require.NoError(t, kr.Close())
+
+	// The registry got encrypted with the primary key.
+	require.NoError(t, VerifyEncryptionKey(dir, newKey))
+	kr, err = OpenKeyRegistry(getRegistryTestOptions(dir, newKey))
+	require.NoError(t, err)
+	read, err = kr.dataKey(dk.KeyId)
+	require.NoError(t, err)
+	require.Equal(t, dk.Data, read.Data)
+	require.NoError(t, kr.Close())
+
+	opt.SecondaryEncryptionKeys = [][]byte{[]byte("short")}
+	_, err = OpenKeyRegistry(opt)
+	require.Equal(t, ErrInvalidEncryptionKey, errors.Cause(err))
+	opt.EncryptionKey = nil
+	opt.SecondaryEncryptionKeys = [][]byte{oldKey}
+	_, err = OpenKeyRegistry(opt)
+	require.Error(t, err)
+}
+
 func TestEncryptionAndDecryption(t *testing.T) {
 	encryptionKey := make([]byte, 32)
 	dir, err := ioutil.TempDir("", "badger-test")
@@ -120,7 +214,7 @@ func TestEncryptionAndDecryption(t *testing.T) {
 	opt := getRegistryTestOptions(dir, encryptionKey)
 	kr, err := OpenKeyRegistry(opt)
 	require.NoError(t, err)
-	dk, err := kr.latestDataKey()
+	dk, err := kr.latestDataKey(nil)
 	require.NoError(t, err)
 	require.NoError(t, kr.Close())
 	// Checking the correctness of the datakey after closing and
@@ -143,14 +237,218 @@ func TestKeyRegistryInMemory(t *testing.T) {
 
 	kr, err := OpenKeyRegistry(opt)
 	require.NoError(t, err)
-	_, err = kr.latestDataKey()
+	_, err = kr.latestDataKey(nil)
 	require.NoError(t, err)
 	// We're resetting the last created timestamp. So, it creates
 	// new datakey.
 	kr.lastCreated = 0
-	_, err = kr.latestDataKey()
+	_, err = kr.latestDataKey(nil)
 	// We generated two key. So, checking the length.
 	require.Equal(t, 2, len(kr.dataKeys))
 	require.NoError(t, err)
 	require.NoError(t, kr.Close())
 }
+
+func TestCompactRegistry(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+	opt := getRegistryTestOptions(dir, encryptionKey)
+	kr, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+
+	// Append duplicate records of the data key.
+	fileSize := func() int64 {
+		fi, err := os.Stat(filepath.Join(dir, KeyRegistryFileName))
+		require.NoError(t, err)
+		return fi.Size()
+	}
+	size := fileSize()
+	for i := 0; i < 5; i++ {
+		buf := &bytes.Buffer{}
+		require.NoError(t, storeDataKey(buf, encryptionKey, dk))
+		_, err = kr.fp.Write(buf.Bytes())
+		require.NoError(t, err)
+	}
+	require.True(t, fileSize() > size)
+
+	// Compact gets rid of the duplicates and keeps the registry usable.
+	require.NoError(t, kr.Compact())
+	require.Equal(t, size, fileSize())
+	kr.lastCreated = 0
+	dk2, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.NoError(t, kr.Close())
+
+	kr2, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	require.Equal(t, 2, kr2.numRecords)
+	require.Equal(t, dk.Data, kr2.dataKeys[dk.KeyId].Data)
+	require.Equal(t, dk2.Data, kr2.dataKeys[dk2.KeyId].Data)
+
+	// Duplicates get removed on open as well.
+	for i := 0; i < 5; i++ {
+		buf := &bytes.Buffer{}
+		require.NoError(t, storeDataKey(buf, encryptionKey, dk2))
+		_, err = kr2.fp.Write(buf.Bytes())
+		require.NoError(t, err)
+	}
+	require.NoError(t, kr2.Close())
+	size = fileSize()
+
+	kr3, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	require.Equal(t, 2, kr3.numRecords)
+	require.True(t, fileSize() < size)
+	require.Equal(t, dk2.Data, kr3.dataKeys[dk2.KeyId].Data)
+	require.NoError(t, kr3.Close())
+}
+
+func TestRegistryDataKeyLookup(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+	opt := getRegistryTestOptions(dir, encryptionKey)
+	kr, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	got, err := kr.dataKey(dk.KeyId)
+	require.NoError(t, err)
+	require.Equal(t, dk, got)
+	_, err = kr.dataKey(dk.KeyId + 1)
+	require.Equal(t, ErrInvalidDataKeyID, errors.Cause(err))
+	require.NoError(t, kr.Close())
+
+	// The keys read from the file can be looked up as well.
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	got, err = kr.dataKey(dk.KeyId)
+	require.NoError(t, err)
+	require.Equal(t, dk.Data, got.Data)
+	require.NoError(t, kr.Close())
+}
+
+func TestSeparateVlogKeys(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+	opt := getRegistryTestOptions(dir, encryptionKey)
+	opt.EncryptionKeyRotationDuration = time.Hour
+	opt.ValueLogEncryptionKeyRotationDuration = time.Hour
+	kr, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+
+	tdk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, pb.DataKey_TABLE, tdk.Purpose)
+	vdk, err := kr.vlogDataKey()
+	require.NoError(t, err)
+	require.Equal(t, pb.DataKey_VLOG, vdk.Purpose)
+	require.NotEqual(t, tdk.KeyId, vdk.KeyId)
+
+	// Rotating the value log key leaves the table key alone.
+	kr.vlogLastCreated = 0
+	vdk2, err := kr.vlogDataKey()
+	require.NoError(t, err)
+	require.NotEqual(t, vdk.KeyId, vdk2.KeyId)
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, tdk.KeyId, dk.KeyId)
+	require.NoError(t, kr.Close())
+
+	// The last key of every purpose is found again after reopening.
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	dk, err = kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, tdk.KeyId, dk.KeyId)
+	dk, err = kr.vlogDataKey()
+	require.NoError(t, err)
+	require.Equal(t, vdk2.KeyId, dk.KeyId)
+	require.Equal(t, 3, len(kr.dataKeys))
+	infos := kr.DataKeys()
+	require.Len(t, infos, 3)
+	require.Equal(t, tdk.KeyId, infos[0].KeyID)
+	require.Equal(t, pb.DataKey_TABLE, infos[0].Purpose)
+	require.Equal(t, vdk2.KeyId, infos[2].KeyID)
+	require.Equal(t, pb.DataKey_VLOG, infos[2].Purpose)
+	require.Equal(t, vdk2.CreatedAt, infos[2].CreatedAt.Unix())
+	require.NoError(t, kr.Close())
+
+	opt.DisableValueLogEncryption = true
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	dk, err = kr.vlogDataKey()
+	require.NoError(t, err)
+	require.Nil(t, dk)
+	dk, err = kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, tdk.KeyId, dk.KeyId)
+	require.NoError(t, kr.Close())
+}
+
+func TestAuthenticatedRegistry(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+	opt := getRegistryTestOptions(dir, encryptionKey)
+	opt.EncryptionKeyRotationDuration = time.Hour
+	kr, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	ctrKey, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, pb.EncryptionAlgo_aes, ctrKey.Algo)
+	require.NoError(t, kr.Close())
+
+	// Changing the algorithm generates a new data key.
+	opt.EncryptionAlgorithm = options.AESGCM
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	gcmKey, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, pb.EncryptionAlgo_aes_gcm, gcmKey.Algo)
+	require.NotEqual(t, ctrKey.KeyId, gcmKey.KeyId)
+	require.NoError(t, kr.Close())
+
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, gcmKey.KeyId, dk.KeyId)
+	require.Equal(t, gcmKey.Data, dk.Data)
+	dk, err = kr.dataKey(ctrKey.KeyId)
+	require.NoError(t, err)
+	require.Equal(t, ctrKey.Data, dk.Data)
+	require.NoError(t, kr.Close())
+
+	// A tampered data key doesn't decrypt.
+	sealed := *gcmKey
+	sealed.Data, err = sealDataKey(encryptionKey, gcmKey)
+	require.NoError(t, err)
+	data, err := openDataKey(encryptionKey, &sealed)
+	require.NoError(t, err)
+	require.Equal(t, gcmKey.Data, data)
+	sealed.Data[0] ^= 1
+	_, err = openDataKey(encryptionKey, &sealed)
+	require.Error(t, err)
+	sealed.Data[0] ^= 1
+	sealed.KeyId++
+	_, err = openDataKey(encryptionKey, &sealed)
+	require.Error(t, err)
+}
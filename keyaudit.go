@@ -0,0 +1,310 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// KeyAuditFileName is the name of the audit log of the data keys, in the DB directory. See
+// Options.WithKeyAuditLog.
+const KeyAuditFileName = "KEYAUDIT"
+
+// The audit log starts with keyAuditMagic and keyAuditVersion, followed by the events:
+//
+// | Length (4B) | CRC (4B) | Kind (1B) | Time (8B) | KeyID (8B) | PreviousKeyID (8B) |
+// | FileType (1B) | FileID (8B) |
+//
+// Length is that of the event after the CRC, so that fields can be added, and the CRC is the
+// Castagnoli checksum of the event. The time is in nanoseconds since the Unix epoch.
+var keyAuditMagic = []byte("BdgA")
+
+const (
+	keyAuditVersion   = 1
+	keyAuditEventSize = 34
+)
+
+// KeyEventKind is the kind of a KeyEvent.
+type KeyEventKind byte
+
+const (
+	// KeyCreated is recorded when a data key gets generated.
+	KeyCreated KeyEventKind = iota + 1
+	// KeyRotated is recorded after KeyCreated when the new data key replaces PreviousKeyID for
+	// new files.
+	KeyRotated
+	// KeyFirstUse is recorded the first time a data key encrypts a file of type FileType.
+	KeyFirstUse
+	// KeyRetired is recorded when a data key no file uses anymore leaves the key registry.
+	KeyRetired
+	// MasterKeyRotated is recorded when the data keys get encrypted with a new master key.
+	MasterKeyRotated
+)
+
+func (k KeyEventKind) String() string {
+	switch k {
+	case KeyCreated:
+		return "created"
+	case KeyRotated:
+		return "rotated"
+	case KeyFirstUse:
+		return "first-use"
+	case KeyRetired:
+		return "retired"
+	case MasterKeyRotated:
+		return "master-key-rotated"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyFileType is the type of the files encrypted with a data key.
+type KeyFileType byte
+
+const (
+	// TableFile is for the SSTables.
+	TableFile KeyFileType = iota + 1
+	// ValueLogFile is for the value log files.
+	ValueLogFile
+	// ManifestFile is for the MANIFEST.
+	ManifestFile
+)
+
+func (t KeyFileType) String() string {
+	switch t {
+	case TableFile:
+		return "table"
+	case ValueLogFile:
+		return "vlog"
+	case ManifestFile:
+		return "manifest"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyEvent is an event of the life of a data key, as recorded in the audit log. It holds no key
+// material.
+type KeyEvent struct {
+	Kind KeyEventKind
+	At   time.Time
+	// KeyID is the ID of the data key, 0 for MasterKeyRotated.
+	KeyID uint64
+	// PreviousKeyID is the ID of the data key replaced by KeyID, for KeyRotated.
+	PreviousKeyID uint64
+	// FileType and FileID are the type and ID of the file encrypted with KeyID, for KeyFirstUse.
+	// The ID of the MANIFEST is 0.
+	FileType KeyFileType
+	FileID   uint64
+}
+
+// encode appends the event, with its length and CRC, to buf.
+func (ev *KeyEvent) encode(buf []byte) []byte {
+	var b [8 + keyAuditEventSize]byte
+	e := b[8:]
+	e[0] = byte(ev.Kind)
+	binary.BigEndian.PutUint64(e[1:9], uint64(ev.At.UnixNano()))
+	binary.BigEndian.PutUint64(e[9:17], ev.KeyID)
+	binary.BigEndian.PutUint64(e[17:25], ev.PreviousKeyID)
+	e[25] = byte(ev.FileType)
+	binary.BigEndian.PutUint64(e[26:34], ev.FileID)
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(e)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(e, y.CastagnoliCrcTable))
+	return append(buf, b[:]...)
+}
+
+// ReadKeyAuditLog reads the audit log of the data keys of the DB in dir, which can be open. It
+// returns an error satisfying os.IsNotExist if there's none, see Options.WithKeyAuditLog.
+func ReadKeyAuditLog(dir string) ([]KeyEvent, error) {
+	fp, err := os.Open(filepath.Join(dir, KeyAuditFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	events, _, err := readKeyAudit(fp)
+	return events, err
+}
+
+// KeyAuditLog returns the events of the audit log of the data keys, oldest first. See
+// ReadKeyAuditLog.
+func (db *DB) KeyAuditLog() ([]KeyEvent, error) {
+	return ReadKeyAuditLog(db.opt.Dir)
+}
+
+// readKeyAudit reads the events of an audit log, and returns the offset of the end of the last
+// complete one. An event cut short, by a crash while writing it, ends the log.
+func readKeyAudit(r io.Reader) ([]KeyEvent, int64, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(keyAuditMagic)+1)
+	if _, err := io.ReadFull(br, hdr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, errors.Wrap(err, "while reading key audit log header")
+	}
+	if !bytes.Equal(hdr[:len(keyAuditMagic)], keyAuditMagic) {
+		return nil, 0, errors.New("invalid key audit log: bad magic")
+	}
+	if hdr[len(keyAuditMagic)] != keyAuditVersion {
+		return nil, 0, errors.Errorf("unsupported key audit log version: %d",
+			hdr[len(keyAuditMagic)])
+	}
+	var events []KeyEvent
+	offset := int64(len(hdr))
+	var lenCrcBuf [8]byte
+	for {
+		if _, err := io.ReadFull(br, lenCrcBuf[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return events, offset, nil
+		} else if err != nil {
+			return nil, 0, errors.Wrap(err, "while reading key audit log")
+		}
+		length := binary.BigEndian.Uint32(lenCrcBuf[0:4])
+		if length < keyAuditEventSize || length > 1<<20 {
+			return nil, 0, errors.Errorf("invalid key audit event length %d at offset %d",
+				length, offset)
+		}
+		e := make([]byte, length)
+		if _, err := io.ReadFull(br, e); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return events, offset, nil
+		} else if err != nil {
+			return nil, 0, errors.Wrap(err, "while reading key audit log")
+		}
+		if crc32.Checksum(e, y.CastagnoliCrcTable) != binary.BigEndian.Uint32(lenCrcBuf[4:8]) {
+			return nil, 0, errors.Errorf("checksum mismatch of key audit event at offset %d",
+				offset)
+		}
+		events = append(events, KeyEvent{
+			Kind:          KeyEventKind(e[0]),
+			At:            time.Unix(0, int64(binary.BigEndian.Uint64(e[1:9]))),
+			KeyID:         binary.BigEndian.Uint64(e[9:17]),
+			PreviousKeyID: binary.BigEndian.Uint64(e[17:25]),
+			FileType:      KeyFileType(e[25]),
+			FileID:        binary.BigEndian.Uint64(e[26:34]),
+		})
+		offset += int64(len(lenCrcBuf)) + int64(length)
+	}
+}
+
+type keyUse struct {
+	keyID    uint64
+	fileType KeyFileType
+}
+
+// keyAudit appends the events of the data keys to the audit log. A nil keyAudit records nothing.
+type keyAudit struct {
+	sync.Mutex
+	fp *os.File
+	// used holds the file types every data key was used for, so that only the first use is
+	// recorded.
+	used map[keyUse]struct{}
+}
+
+// openKeyAudit opens the audit log in dir for appending, creating it if needed.
+func openKeyAudit(dir string) (*keyAudit, error) {
+	path := filepath.Join(dir, KeyAuditFileName)
+	fp, err := y.OpenSyncedFile(path, true)
+	if err != nil {
+		return nil, y.Wrapf(err, "Error while opening key audit log.")
+	}
+	events, end, err := readKeyAudit(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	// Drop any event cut short, and write the header of a new log.
+	if err := fp.Truncate(end); err != nil {
+		fp.Close()
+		return nil, y.Wrapf(err, "Error while truncating key audit log.")
+	}
+	if _, err := fp.Seek(end, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, y.Wrapf(err, "Error while seeking key audit log.")
+	}
+	if end == 0 {
+		hdr := append(append([]byte{}, keyAuditMagic...), keyAuditVersion)
+		if _, err := fp.Write(hdr); err != nil {
+			fp.Close()
+			return nil, y.Wrapf(err, "Error while writing key audit log header.")
+		}
+	}
+	a := &keyAudit{fp: fp, used: make(map[keyUse]struct{})}
+	for _, ev := range events {
+		if ev.Kind == KeyFirstUse {
+			a.used[keyUse{keyID: ev.KeyID, fileType: ev.FileType}] = struct{}{}
+		}
+	}
+	return a, nil
+}
+
+// record appends the given events to the log.
+func (a *keyAudit) record(events ...KeyEvent) error {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	return a.write(events)
+}
+
+// write appends events to the log. a must be locked.
+func (a *keyAudit) write(events []KeyEvent) error {
+	var buf []byte
+	now := time.Now()
+	for _, ev := range events {
+		ev.At = now
+		buf = ev.encode(buf)
+	}
+	_, err := a.fp.Write(buf)
+	return y.Wrapf(err, "Error while writing key audit log.")
+}
+
+// use records the first use of the data key keyID for a file of the given type and ID. keyID is 0
+// for files in plain text.
+func (a *keyAudit) use(keyID uint64, fileType KeyFileType, fileID uint64) error {
+	if a == nil || keyID == 0 {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	u := keyUse{keyID: keyID, fileType: fileType}
+	if _, ok := a.used[u]; ok {
+		return nil
+	}
+	if err := a.write([]KeyEvent{{Kind: KeyFirstUse, KeyID: keyID, FileType: fileType,
+		FileID: fileID}}); err != nil {
+		return err
+	}
+	a.used[u] = struct{}{}
+	return nil
+}
+
+func (a *keyAudit) close() error {
+	if a == nil {
+		return nil
+	}
+	return a.fp.Close()
+}
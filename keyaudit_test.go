@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	key, newKey := bytes.Repeat([]byte("k"), 32), bytes.Repeat([]byte("n"), 32)
+	opt := getTestOptions(dir).WithKeyAuditLog(true).WithKeepL0InMemory(false)
+
+	// count returns the number of events like ev, whatever their time.
+	count := func(events []KeyEvent, ev KeyEvent) int {
+		n := 0
+		for _, e := range events {
+			e.At = ev.At
+			if e == ev {
+				n++
+			}
+		}
+		return n
+	}
+
+	db, err := Open(opt.WithEncryptionKey(key))
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	dk, err := db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	first := dk.KeyId
+	events, err := db.KeyAuditLog()
+	require.NoError(t, err)
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyCreated, KeyID: first}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyFirstUse, KeyID: first,
+		FileType: ManifestFile}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyFirstUse, KeyID: first,
+		FileType: ValueLogFile, FileID: 0}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyFirstUse, KeyID: first,
+		FileType: TableFile, FileID: 1}))
+	for _, ev := range events {
+		require.False(t, ev.At.IsZero())
+	}
+
+	id, err := db.ForceDataKeyRotation()
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("baz"), 0)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	require.NoError(t, db.RotateMasterKey(key, newKey))
+	n, err := db.DropUnusedDataKeys()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	events, err = ReadKeyAuditLog(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyCreated, KeyID: id}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyRotated, KeyID: id,
+		PreviousKeyID: first}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: KeyFirstUse, KeyID: id,
+		FileType: TableFile, FileID: 2}))
+	require.Equal(t, 1, count(events, KeyEvent{Kind: MasterKeyRotated}))
+	retired := 0
+	for _, ev := range events {
+		if ev.Kind == KeyRetired {
+			retired++
+		}
+	}
+	require.Equal(t, n, retired)
+
+	// Reopening doesn't record the uses again, and an event cut short is dropped.
+	path := filepath.Join(dir, KeyAuditFileName)
+	buf, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, append(buf, 0, 0, 0, 34, 1, 2), 0600))
+	db, err = Open(opt.WithEncryptionKey(newKey))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	reopened, err := ReadKeyAuditLog(dir)
+	require.NoError(t, err)
+	require.Equal(t, events, reopened)
+
+	// The events are checksummed.
+	buf, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 1
+	require.NoError(t, ioutil.WriteFile(path, buf, 0600))
+	_, err = ReadKeyAuditLog(dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum mismatch")
+
+	// There's no audit log without encryption.
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db, err = Open(getTestOptions(dir2).WithKeyAuditLog(true))
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	require.NoError(t, db.Close())
+	_, err = ReadKeyAuditLog(dir2)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+)
+
+// retireKeys removes the data keys whose IDs aren't in used from the key registry file, except
+// the ones used for new files, including those of key prefixes, and the latest one, which keeps
+// key IDs increasing across restarts. Retired keys are kept in memory, in case a file being
+// written uses one, see keep. It returns the number of keys retired.
+func (kr *KeyRegistry) retireKeys(used map[uint64]struct{}) (int, error) {
+	kr.Lock()
+	defer kr.Unlock()
+	var ids []uint64
+	for id := range kr.dataKeys {
+		if _, ok := used[id]; ok || id == kr.lastKeyID || id == kr.vlogLastKeyID ||
+			id == kr.nextKeyID {
+			continue
+		}
+		if dk := kr.dataKeys[id]; len(dk.Prefix) > 0 {
+			if sc, ok := kr.scopes[string(dk.Prefix)]; ok && sc.lastKeyID == id {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	masterKey, err := kr.opt.masterKey(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	if kr.retired == nil {
+		kr.retired = make(map[uint64]*pb.DataKey)
+	}
+	for _, id := range ids {
+		kr.retired[id] = kr.dataKeys[id]
+		delete(kr.dataKeys, id)
+	}
+	if err := kr.rewrite(masterKey); err != nil {
+		for _, id := range ids {
+			kr.dataKeys[id] = kr.retired[id]
+			delete(kr.retired, id)
+		}
+		return 0, err
+	}
+	events := make([]KeyEvent, len(ids))
+	for i, id := range ids {
+		events[i] = KeyEvent{Kind: KeyRetired, KeyID: id}
+	}
+	return len(ids), kr.audit.record(events...)
+}
+
+// keep stores the given data keys in the key registry file again if they got retired, because a
+// file using them was being written in the meantime.
+func (kr *KeyRegistry) keep(ids ...uint64) error {
+	kr.RLock()
+	n := len(kr.retired)
+	kr.RUnlock()
+	if n == 0 {
+		return nil
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	buf := &bytes.Buffer{}
+	var kept []*pb.DataKey
+	for _, id := range ids {
+		if dk, ok := kr.retired[id]; ok {
+			kept = append(kept, dk)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	masterKey, err := kr.opt.masterKey(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, dk := range kept {
+		if err := kr.storeKey(buf, masterKey, dk); err != nil {
+			return err
+		}
+	}
+	if _, err := kr.fp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, dk := range kept {
+		kr.dataKeys[dk.KeyId] = dk
+		delete(kr.retired, dk.KeyId)
+		kr.numRecords++
+	}
+	return nil
+}
+
+// DropUnusedDataKeys removes the data keys which no table or value log file is encrypted with
+// anymore from the key registry, and returns how many got removed. It's called after every
+// compaction and value log GC, so data keys don't accumulate in the key registry as they rotate.
+// It returns ErrFrozen while the DB is frozen.
+func (db *DB) DropUnusedDataKeys() (int, error) {
+	if db.opt.ReadOnly {
+		return 0, errors.New("DropUnusedDataKeys cannot be called in read-only mode")
+	}
+	if db.opt.InMemory || !db.shouldEncrypt() {
+		return 0, nil
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	// Tables and value log files can't be added in the meantime. Those written with a data key
+	// retired below get it back in the key registry file when they're added, see keep.
+	mf := db.manifest
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	used := make(map[uint64]struct{})
+	for _, tm := range mf.manifest.Tables {
+		used[tm.KeyID] = struct{}{}
+	}
+	if mf.dataKey != nil {
+		used[mf.dataKey.KeyId] = struct{}{}
+	}
+	db.vlog.filesLock.RLock()
+	defer db.vlog.filesLock.RUnlock()
+	for _, lf := range db.vlog.filesMap {
+		if lf.dataKey != nil {
+			used[lf.dataKey.KeyId] = struct{}{}
+		}
+	}
+	return db.registry.retireKeys(used)
+}
+
+// ForceDataKeyRotation generates new data keys right away, whatever EncryptionKeyRotationDuration,
+// e.g. when a data key is suspected to be compromised, and returns the ID of the first one. The
+// tables and value log files written from then on use the new keys, except the value log file
+// being written, which keeps its data key until it's full. The existing files aren't rewritten:
+// call ReencryptAll with the ID returned for that. It returns ErrFrozen while the DB is frozen, as
+// the key registry file isn't rewritten then.
+func (db *DB) ForceDataKeyRotation() (uint64, error) {
+	if db.opt.ReadOnly {
+		return 0, errors.New("ForceDataKeyRotation cannot be called in read-only mode")
+	}
+	if !db.shouldEncrypt() {
+		return 0, errors.New("ForceDataKeyRotation cannot be called without encryption")
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return db.registry.forceRotation()
+}
+
+// dropUnusedDataKeys calls DropUnusedDataKeys, logging the outcome.
+func (db *DB) dropUnusedDataKeys() {
+	if db.opt.InMemory || !db.shouldEncrypt() {
+		return
+	}
+	n, err := db.DropUnusedDataKeys()
+	if err == ErrFrozen {
+		// Freeze is waiting for the compaction or value log GC calling this, try again next time.
+		return
+	}
+	if err != nil {
+		db.opt.Warningf("While dropping unused data keys: %v", err)
+	} else if n > 0 {
+		db.opt.Infof("Dropped %d unused data keys from the key registry", n)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropUnusedDataKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithEncryptionKey(make([]byte, 32)).WithKeepL0InMemory(false).
+		WithCompactL0OnClose(false)
+
+	keyIDs := func(db *DB) []uint64 {
+		var ids []uint64
+		for _, dk := range db.registry.DataKeys() {
+			ids = append(ids, dk.KeyID)
+		}
+		return ids
+	}
+	// rotate generates a new data key, used for the tables written from then on.
+	rotate := func(db *DB) uint64 {
+		db.registry.Lock()
+		db.registry.lastCreated = 0
+		db.registry.Unlock()
+		dk, err := db.registry.latestDataKey(nil)
+		require.NoError(t, err)
+		return dk.KeyId
+	}
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	require.Equal(t, []uint64{1}, keyIDs(db))
+
+	// Key 2 never gets used.
+	rotate(db)
+	rotate(db)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo2"), []byte("bar2"))
+	}))
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	require.Equal(t, []uint64{1, 2, 3}, keyIDs(db))
+
+	n, err := db.DropUnusedDataKeys()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []uint64{1, 3}, keyIDs(db))
+	n, err = db.DropUnusedDataKeys()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// A key retired while a file using it was written gets stored again once it's added.
+	rotate(db)
+	rotate(db)
+	n, err = db.DropUnusedDataKeys()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []uint64{1, 3, 5}, keyIDs(db))
+	require.NoError(t, db.registry.keep(4))
+	require.Equal(t, []uint64{1, 3, 4, 5}, keyIDs(db))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3, 4, 5}, keyIDs(db))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for _, k := range []string{"foo", "foo2"} {
+			if _, err := txn.Get([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// Compactions drop the keys of the tables they replace once unused.
+	require.NoError(t, db.lc.doCompact(compactionPriority{level: 0, score: 1.73}))
+	require.Equal(t, []uint64{1, 5}, keyIDs(db))
+	require.NoError(t, db.Close())
+}
+
+func TestDropUnusedDataKeysFrozen(t *testing.T) {
+	opt := getTestOptions("").WithEncryptionKey(make([]byte, 32))
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Freeze())
+		_, err := db.DropUnusedDataKeys()
+		require.Equal(t, ErrFrozen, err)
+		require.NoError(t, db.Thaw())
+		_, err = db.DropUnusedDataKeys()
+		require.NoError(t, err)
+	})
+}
+
+func TestForceDataKeyRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithEncryptionKey(make([]byte, 32)).WithKeepL0InMemory(false).
+		WithValueLogEncryptionKeyRotationDuration(time.Hour).
+		WithEncryptionKeyPrefixes([][]byte{[]byte("p/")})
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	txnSet(t, db, []byte("p/foo"), []byte("bar"), 0)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+
+	keyID, err := db.ForceDataKeyRotation()
+	require.NoError(t, err)
+	require.True(t, keyID > 1)
+	// The data keys of tables, value log files and key prefixes all got rotated.
+	dk, err := db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	require.True(t, dk.KeyId >= keyID)
+	dk, err = db.registry.latestDataKey([]byte("p/foo"))
+	require.NoError(t, err)
+	require.True(t, dk.KeyId >= keyID)
+	dk, err = db.registry.vlogDataKey()
+	require.NoError(t, err)
+	require.True(t, dk.KeyId >= keyID)
+	require.Equal(t, keyID+2, db.registry.nextKeyID)
+
+	// The existing files get rewritten with the new keys by ReencryptAll.
+	require.NoError(t, db.ReencryptAll(context.Background(), keyID, nil))
+	for _, tm := range db.manifest.manifest.Tables {
+		require.True(t, tm.KeyID >= keyID)
+	}
+	require.Equal(t, keyID+2, db.registry.nextKeyID)
+
+	db2, err := Open(getTestOptions("").WithInMemory(true))
+	require.NoError(t, err)
+	defer db2.Close()
+	_, err = db2.ForceDataKeyRotation()
+	require.Error(t, err)
+}
+
+func TestForceDataKeyRotationFrozen(t *testing.T) {
+	opt := getTestOptions("").WithEncryptionKey(make([]byte, 32))
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Freeze())
+		nextKeyID := db.registry.nextKeyID
+		_, err := db.ForceDataKeyRotation()
+		require.Equal(t, ErrFrozen, err)
+		require.Equal(t, nextKeyID, db.registry.nextKeyID)
+		require.NoError(t, db.Thaw())
+		keyID, err := db.ForceDataKeyRotation()
+		require.NoError(t, err)
+		require.True(t, keyID >= nextKeyID)
+	})
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// minKeyHashSecretSize is the minimum size of Options.KeyHashSecret.
+const minKeyHashSecretSize = 16
+
+var errBadSealedKey = errors.New("Value doesn't start with a valid sealed key")
+
+// keyHasher stores the keys of a DB as keyed hashes, see Options.KeyHashSecret. The original keys
+// are sealed at the start of their values, encrypted with AES-CTR.
+type keyHasher struct {
+	hashKey []byte
+	block   cipher.Block
+}
+
+func newKeyHasher(secret []byte) (*keyHasher, error) {
+	if len(secret) == 0 {
+		return nil, nil
+	}
+	if len(secret) < minKeyHashSecretSize {
+		return nil, errors.Errorf("KeyHashSecret must be at least %d bytes long",
+			minKeyHashSecretSize)
+	}
+	// Hashing and encryption use keys of their own, derived from the secret.
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("badger key encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &keyHasher{hashKey: derive("badger key hash"), block: block}, nil
+}
+
+// hash returns the key key is stored under. The internal keys of Badger aren't hashed.
+func (kh *keyHasher) hash(key []byte) []byte {
+	if bytes.HasPrefix(key, badgerPrefix) {
+		return key
+	}
+	mac := hmac.New(sha256.New, kh.hashKey)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// seal returns val with key encrypted in front of it: the size of key as a uvarint, a random IV,
+// and key encrypted with it.
+func (kh *keyHasher) seal(key, val []byte) ([]byte, error) {
+	iv, err := y.GenerateIV()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(iv)+len(key)+len(val))
+	buf = buf[:binary.PutUvarint(buf, uint64(len(key)))]
+	buf = append(buf, iv...)
+	buf = append(buf, y.XORBlockWithCipher(key, kh.block, iv)...)
+	return append(buf, val...), nil
+}
+
+// unseal returns the key sealed in front of val, and the rest of val.
+func (kh *keyHasher) unseal(val []byte) ([]byte, []byte, error) {
+	sz, n := binary.Uvarint(val)
+	if n <= 0 || sz > uint64(len(val)) || uint64(len(val)-n) < aes.BlockSize+sz {
+		return nil, nil, errBadSealedKey
+	}
+	iv := val[n : n+aes.BlockSize]
+	sealed := val[n+aes.BlockSize : n+aes.BlockSize+int(sz)]
+	return y.XORBlockWithCipher(sealed, kh.block, iv), val[n+aes.BlockSize+int(sz):], nil
+}
+
+// storedKey returns the key key is stored under, which is key itself unless KeyHashSecret is set.
+func (db *DB) storedKey(key []byte) []byte {
+	if db.hasher == nil {
+		return key
+	}
+	return db.hasher.hash(key)
+}
+
+// encodeEntry encodes the value of e, whose key is key without timestamp, with the transformer of
+// key and seals key in front of it if KeyHashSecret is set. It returns the key to store e under.
+func (db *DB) encodeEntry(key []byte, e *Entry) ([]byte, error) {
+	if err := db.transformers.encode(key, e); err != nil {
+		return nil, err
+	}
+	if db.hasher == nil || bytes.HasPrefix(key, badgerPrefix) {
+		return key, nil
+	}
+	if e.meta&(bitDelete|bitValuePointer) == 0 {
+		val, err := db.hasher.seal(key, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		e.Value = val
+	}
+	return db.hasher.hash(key), nil
+}
+
+// decodeValue reverses encodeEntry for the value val stored under key, without timestamp. It
+// returns the original key along with the decoded value.
+func (db *DB) decodeValue(key, val []byte) ([]byte, []byte, error) {
+	if db.hasher != nil && len(val) > 0 && !bytes.HasPrefix(key, badgerPrefix) {
+		orig, rest, err := db.hasher.unseal(val)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "while reading the key hashed into %x", key)
+		}
+		key, val = orig, rest
+	}
+	val, err := db.transformers.decode(key, val)
+	return key, val, err
+}
+
+// OriginalKey returns the key of the item as it was written. It's the same as Key, except with
+// Options.KeyHashSecret for the items of iterators other than key iterators: their keys are the
+// hashes the keys are stored under, and OriginalKey decrypts the key stored along with the value.
+// It returns ErrNoOriginalKey for deletions, which have no value.
+func (item *Item) OriginalKey() ([]byte, error) {
+	if item.origKey != nil || item.db == nil || item.db.hasher == nil {
+		return item.Key(), nil
+	}
+	if item.meta&bitDelete > 0 {
+		return nil, ErrNoOriginalKey
+	}
+	val, cb, err := item.yieldItemValue()
+	defer runCallback(cb)
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := item.db.hasher.unseal(val)
+	return key, err
+}
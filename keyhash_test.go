@@ -0,0 +1,186 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testKeyHashSecret = []byte("0123456789abcdef")
+
+func TestHashedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithKeyHashSecret(testKeyHashSecret)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	big := bytes.Repeat([]byte("v"), db.opt.ValueThreshold+10)
+	txnSet(t, db, []byte("patient-alice"), []byte("v1"), 0)
+	txnSet(t, db, []byte("patient-bob"), big, 0)
+	txnSet(t, db, []byte("patient-carol"), nil, 0)
+	txnSet(t, db, []byte("patient-dave"), []byte("v4"), 0)
+	txnDelete(t, db, []byte("patient-dave"))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.Equal(t, ErrKeyExists, txn.SetIfAbsent([]byte("patient-alice"), []byte("x")))
+		require.NoError(t, txn.SetIfAbsent([]byte("patient-erin"), []byte("v5")))
+		return txn.Rename([]byte("patient-bob"), []byte("patient-bobby"))
+	}))
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			expected := map[string][]byte{"patient-alice": []byte("v1"), "patient-bobby": big,
+				"patient-carol": nil, "patient-erin": []byte("v5")}
+			for key, val := range expected {
+				item, err := txn.Get([]byte(key))
+				require.NoError(t, err)
+				require.Equal(t, []byte(key), item.Key())
+				require.Equal(t, val, getItemValue(t, item), "key %s", key)
+			}
+			for _, key := range []string{"patient-bob", "patient-dave"} {
+				_, err := txn.Get([]byte(key))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+
+			// Iterators return the hashes, from which the keys can be recovered.
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			it := txn.NewIterator(iopt)
+			defer it.Close()
+			keys := make(map[string]bool)
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				require.Len(t, item.Key(), 32)
+				key, err := item.OriginalKey()
+				if item.meta&bitDelete > 0 {
+					require.Equal(t, ErrNoOriginalKey, err)
+					continue
+				}
+				require.NoError(t, err)
+				keys[string(key)] = true
+			}
+			for key := range expected {
+				_, ok := keys[key]
+				require.True(t, ok, "key %s", key)
+			}
+			// The older versions of the keys are there until compactions drop them.
+			for key := range keys {
+				require.True(t, strings.HasPrefix(key, "patient-"), "key %s", key)
+			}
+			require.Panics(t, func() {
+				txn.NewIterator(IteratorOptions{Prefix: []byte("patient")})
+			})
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// The keys don't appear in the files.
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		buf, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(buf, []byte("patient")), "file %s", path)
+		return nil
+	}))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+
+	// Another secret reads other keys.
+	_, err = Open(opt.WithKeyHashSecret([]byte("short")))
+	require.Error(t, err)
+}
+
+func TestHashedKeysPending(t *testing.T) {
+	opt := getTestOptions("").WithKeyHashSecret(testKeyHashSecret)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("a"), []byte("a1"), 0)
+		txnSet(t, db, []byte("c"), []byte("c1"), 0)
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set([]byte("a"), []byte("a2")))
+		require.NoError(t, txn.Set([]byte("b"), []byte("b1")))
+		item, err := txn.Get([]byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("b"), item.Key())
+		require.Equal(t, []byte("b1"), getItemValue(t, item))
+
+		// Pending writes are merged with the versions committed, under the same hashes.
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewKeyIterator([]byte("a"), iopt)
+		var values []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			require.Equal(t, []byte("a"), it.Item().Key())
+			values = append(values, string(getItemValue(t, it.Item())))
+		}
+		it.Close()
+		require.Equal(t, []string{"a2", "a1"}, values)
+
+		it = txn.NewIterator(DefaultIteratorOptions)
+		values = values[:0]
+		for it.Rewind(); it.Valid(); it.Next() {
+			key, err := it.Item().OriginalKey()
+			require.NoError(t, err)
+			values = append(values, string(key)+"="+string(getItemValue(t, it.Item())))
+		}
+		it.Close()
+		sort.Strings(values)
+		require.Equal(t, []string{"a=a2", "b=b1", "c=c1"}, values)
+	})
+}
+
+func TestHashedKeysBackup(t *testing.T) {
+	opt := getTestOptions("").WithKeyHashSecret(testKeyHashSecret)
+	var buf bytes.Buffer
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("a"), []byte("a1"), 0)
+		txnSet(t, db, []byte("b"), []byte("b1"), 0)
+		txnDelete(t, db, []byte("b"))
+		_, err := db.Backup(&buf, 0)
+		require.NoError(t, err)
+	})
+
+	// Backups hold the original keys, which get hashed again when loaded.
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Load(&buf, 16))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("a"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("a1"), getItemValue(t, item))
+			_, err = txn.Get([]byte("b"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
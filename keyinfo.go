@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v2/options"
+)
+
+// EncryptionInfo describes how a DB is encrypted, without any key material. See
+// DB.EncryptionInfo.
+type EncryptionInfo struct {
+	// Encrypted is set if the DB has a master key, from EncryptionKey, KeyProvider, KeyWrapper or
+	// EncryptionPassphrase.
+	Encrypted bool
+	// TableEncryption and ValueLogEncryption are set if new tables and value log files are
+	// encrypted respectively.
+	TableEncryption    bool
+	ValueLogEncryption bool
+	// Algorithm is the encryption algorithm of new data keys.
+	Algorithm options.EncryptionAlgorithm
+	// RotationDuration is the lifetime of the data keys of new files, and ValueLogRotationDuration
+	// that of value log files if they're rotated separately, 0 otherwise.
+	RotationDuration         time.Duration
+	ValueLogRotationDuration time.Duration
+	// DataKeyPrefixes are the key prefixes having data keys of their own.
+	DataKeyPrefixes [][]byte
+	// ManifestKeyID is the ID of the data key the MANIFEST is encrypted with, 0 if it's in plain
+	// text.
+	ManifestKeyID uint64
+	// DataKeys describes the data keys of the key registry, sorted by key ID.
+	DataKeys []DataKeyInfo
+}
+
+// EncryptionInfo returns the encryption settings of the DB along with the description of its data
+// keys, so that one can audit how often they get rotated. It's empty if the DB isn't encrypted.
+func (db *DB) EncryptionInfo() EncryptionInfo {
+	kr := db.registry
+	if !kr.encrypted {
+		return EncryptionInfo{}
+	}
+	return EncryptionInfo{
+		Encrypted:                true,
+		TableEncryption:          !kr.opt.DisableTableEncryption,
+		ValueLogEncryption:       !kr.opt.DisableValueLogEncryption,
+		Algorithm:                kr.opt.EncryptionAlgorithm,
+		RotationDuration:         kr.opt.EncryptionKeyRotationDuration,
+		ValueLogRotationDuration: kr.opt.ValueLogEncryptionKeyRotationDuration,
+		DataKeyPrefixes:          sortedKeyPrefixes(kr.prefixes),
+		ManifestKeyID:            db.manifest.keyID(),
+		DataKeys:                 kr.DataKeys(),
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	require.Equal(t, EncryptionInfo{}, db.EncryptionInfo())
+	require.NoError(t, db.Close())
+	removeDir(dir)
+
+	opt := getTestOptions(dir).WithEncryptionKey(make([]byte, 32)).
+		WithEncryptionKeyRotationDuration(time.Hour).
+		WithValueLogEncryptionKeyRotationDuration(time.Minute).
+		WithEncryptionAlgorithm(options.AESGCM).
+		WithEncryptionKeyPrefixes([][]byte{[]byte("t/")})
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("t/1"), []byte("foo"))
+	}))
+	_, err = db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	_, err = db.registry.latestDataKey([]byte("t/1"))
+	require.NoError(t, err)
+
+	info := db.EncryptionInfo()
+	require.True(t, info.Encrypted)
+	require.True(t, info.TableEncryption)
+	require.True(t, info.ValueLogEncryption)
+	require.Equal(t, options.AESGCM, info.Algorithm)
+	require.Equal(t, time.Hour, info.RotationDuration)
+	require.Equal(t, time.Minute, info.ValueLogRotationDuration)
+	require.Equal(t, [][]byte{[]byte("t/")}, info.DataKeyPrefixes)
+	require.Len(t, info.DataKeys, 3)
+	purposes := make(map[pb.DataKey_Purpose]DataKeyInfo)
+	for _, dk := range info.DataKeys {
+		require.True(t, dk.Current)
+		require.Equal(t, options.AESGCM, dk.Algorithm)
+		require.WithinDuration(t, time.Now(), dk.CreatedAt, time.Minute)
+		if len(dk.Prefix) > 0 {
+			require.Equal(t, []byte("t/"), dk.Prefix)
+			continue
+		}
+		purposes[dk.Purpose] = dk
+	}
+	require.Contains(t, purposes, pb.DataKey_TABLE)
+	require.Contains(t, purposes, pb.DataKey_VLOG)
+
+	// Rotated keys aren't current anymore.
+	db.registry.Lock()
+	db.registry.lastCreated = 0
+	db.registry.Unlock()
+	_, err = db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	info = db.EncryptionInfo()
+	require.Len(t, info.DataKeys, 4)
+	for _, dk := range info.DataKeys {
+		require.Equal(t, dk.KeyID != purposes[pb.DataKey_TABLE].KeyID, dk.Current)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// keyLocks holds the key locks taken by Txn.Lock, by key fingerprint. Keys sharing a fingerprint
+// share a lock.
+type keyLocks struct {
+	sync.Mutex
+	locks map[uint64]*keyLock
+}
+
+type keyLock struct {
+	ch   chan struct{} // ch holds a value while the lock is held.
+	refs int           // refs counts the holder and the waiters, guarded by keyLocks.
+}
+
+func (kl *keyLocks) lock(fp uint64) {
+	kl.Lock()
+	if kl.locks == nil {
+		kl.locks = make(map[uint64]*keyLock)
+	}
+	l, ok := kl.locks[fp]
+	if !ok {
+		l = &keyLock{ch: make(chan struct{}, 1)}
+		kl.locks[fp] = l
+	}
+	l.refs++
+	kl.Unlock()
+	l.ch <- struct{}{}
+}
+
+func (kl *keyLocks) unlock(fps []uint64) {
+	kl.Lock()
+	defer kl.Unlock()
+	for _, fp := range fps {
+		l := kl.locks[fp]
+		<-l.ch
+		if l.refs--; l.refs == 0 {
+			delete(kl.locks, fp)
+		}
+	}
+}
+
+// Lock takes an exclusive lock on key for txn, waiting for any other transaction holding it to be
+// committed or discarded. The lock is released when txn is committed or discarded. Transactions
+// locking the keys they update are serialized instead of failing with ErrConflict, which suits hot
+// keys like counters, the locks being held by the process only.
+//
+// Unless the DB is in managed mode, txn then reads the latest committed data, as if it was created
+// after the previous holder of the lock committed. Lock returns ErrConflict if that isn't possible
+// because the keys read by txn before were written in the meantime, the lock being taken still, so
+// keys should be locked before being read. Transactions locking several keys must lock them in the
+// same order, otherwise they can deadlock.
+func (txn *Txn) Lock(key []byte) error {
+	switch {
+	case !txn.update:
+		return ErrReadOnlyTxn
+	case txn.discarded:
+		return ErrDiscardedTxn
+	case len(key) == 0:
+		return ErrEmptyKey
+	}
+	fp := z.MemHash(key)
+	for _, held := range txn.lockedKeys {
+		if held == fp {
+			return nil
+		}
+	}
+	txn.db.keyLocks.lock(fp)
+	txn.lockedKeys = append(txn.lockedKeys, fp)
+	if txn.db.orc.isManaged {
+		return nil
+	}
+	return txn.refreshReadTs()
+}
+
+// refreshReadTs moves the read timestamp of txn to the latest commit, unless a key read by txn
+// was written after its read timestamp.
+func (txn *Txn) refreshReadTs() error {
+	o := txn.db.orc
+	o.Lock()
+	if o.hasConflict(txn) {
+		o.Unlock()
+		return o.conflictErr(txn)
+	}
+	readTs := o.nextTxnTs - 1
+	o.readMark.Begin(readTs)
+	o.Unlock()
+
+	o.readMark.Done(txn.markTs)
+	txn.readTs, txn.markTs = readTs, readTs
+	return o.txnMark.WaitForMark(context.Background(), readTs)
+}
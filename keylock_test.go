@@ -0,0 +1,94 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnLock(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("counter")
+		incr := func() error {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			// Created before the lock is taken, the txn still reads the latest value.
+			if err := txn.Lock(key); err != nil {
+				return err
+			}
+			var n uint64
+			item, err := txn.Get(key)
+			switch {
+			case err == ErrKeyNotFound:
+			case err != nil:
+				return err
+			default:
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				n = binary.BigEndian.Uint64(val)
+			}
+			val := make([]byte, 8)
+			binary.BigEndian.PutUint64(val, n+1)
+			if err := txn.Set(key, val); err != nil {
+				return err
+			}
+			return txn.Commit()
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					require.NoError(t, incr())
+				}
+			}()
+		}
+		wg.Wait()
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, uint64(200), binary.BigEndian.Uint64(getItemValue(t, item)))
+			return nil
+		}))
+		require.Empty(t, db.keyLocks.locks)
+
+		// Locking after reading a key written in the meantime fails.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		_, err := txn.Get([]byte("other"))
+		require.Equal(t, ErrKeyNotFound, err)
+		txnSet(t, db, []byte("other"), []byte("v"), 0)
+		require.Equal(t, ErrConflict, txn.Lock(key))
+		// The lock is held by txn regardless, and locking again is a no-op.
+		require.NoError(t, txn.Lock(key))
+		require.Len(t, db.keyLocks.locks, 1)
+		txn.Discard()
+		require.Empty(t, db.keyLocks.locks)
+
+		ro := db.NewTransaction(false)
+		defer ro.Discard()
+		require.Equal(t, ErrReadOnlyTxn, ro.Lock(key))
+	})
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// keyMemoryChunkSize is the size of the chunks of locked memory the keys are allocated from. Keys
+// are small, and the pages of a chunk are locked once for all of them since locks don't nest.
+const keyMemoryChunkSize = 4 << 10
+
+// keyMemory holds the key material of a KeyRegistry in memory locked with mlock, so that it
+// doesn't get swapped to disk, and zeroizes it on release, see Options.LockKeyMemory. A nil
+// keyMemory leaves keys in the regular heap.
+type keyMemory struct {
+	sync.Mutex
+	chunks [][]byte
+	free   []byte // free is what remains of the last chunk.
+}
+
+// copy returns a copy of b in locked memory.
+func (km *keyMemory) copy(b []byte) ([]byte, error) {
+	if km == nil || len(b) == 0 {
+		return b, nil
+	}
+	km.Lock()
+	defer km.Unlock()
+	if len(b) > len(km.free) {
+		size := keyMemoryChunkSize
+		if len(b) > size {
+			size = len(b)
+		}
+		chunk := make([]byte, size)
+		if err := y.Mlock(chunk); err != nil {
+			return nil, y.Wrapf(err, "Error while locking key memory, see RLIMIT_MEMLOCK")
+		}
+		km.chunks = append(km.chunks, chunk)
+		km.free = chunk
+	}
+	dst := km.free[:len(b):len(b)]
+	km.free = km.free[len(b):]
+	copy(dst, b)
+	return dst, nil
+}
+
+// take is like copy, but zeroizes b once copied. It's for the keys which are only referred to by
+// the KeyRegistry, such as those decrypted from its file.
+func (km *keyMemory) take(b []byte) ([]byte, error) {
+	if km == nil {
+		return b, nil
+	}
+	dst, err := km.copy(b)
+	zeroize(b)
+	return dst, err
+}
+
+// forget zeroizes b, a key allocated by copy which isn't used anymore. Its memory isn't reused.
+func (km *keyMemory) forget(b []byte) {
+	if km != nil {
+		zeroize(b)
+	}
+}
+
+// release zeroizes all the keys and unlocks their memory.
+func (km *keyMemory) release() error {
+	if km == nil {
+		return nil
+	}
+	km.Lock()
+	defer km.Unlock()
+	var rerr error
+	for _, chunk := range km.chunks {
+		zeroize(chunk)
+		if err := y.Munlock(chunk); err != nil && rerr == nil {
+			rerr = y.Wrapf(err, "Error while unlocking key memory")
+		}
+	}
+	km.chunks, km.free = nil, nil
+	return rerr
+}
+
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyMemory(t *testing.T) {
+	km := &keyMemory{}
+	var keys [][]byte
+	for i := 0; i < 200; i++ {
+		src := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		key, err := km.take(src)
+		require.NoError(t, err)
+		require.Equal(t, bytes.Repeat([]byte{byte(i + 1)}, 32), key)
+		require.Equal(t, make([]byte, 32), src)
+		keys = append(keys, key)
+	}
+	// Keys are allocated from chunks, and can't grow into their neighbours.
+	require.Len(t, km.chunks, 2)
+	keys[0] = append(keys[0], 0xff)
+	require.Equal(t, byte(2), keys[1][0])
+
+	require.NoError(t, km.release())
+	for _, key := range keys[1:] {
+		require.Equal(t, make([]byte, 32), key)
+	}
+
+	// A nil keyMemory leaves the keys as they are.
+	var nilKm *keyMemory
+	src := []byte("key")
+	key, err := nilKm.take(src)
+	require.NoError(t, err)
+	require.Equal(t, []byte("key"), src)
+	require.Equal(t, []byte("key"), key)
+	require.NoError(t, nilKm.release())
+}
+
+func TestLockKeyMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	key, newKey := make([]byte, 32), make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	_, err = rand.Read(newKey)
+	require.NoError(t, err)
+	opt := getTestOptions(dir).WithLockKeyMemory(true)
+
+	db, err := Open(opt.WithEncryptionKey(append([]byte{}, key...)))
+	require.NoError(t, err)
+	txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+	dk, err := db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, make([]byte, 32), dk.Data)
+	masterKey := db.registry.opt.EncryptionKey
+	require.Equal(t, key, masterKey)
+
+	// The old master key gets zeroized once replaced.
+	require.NoError(t, db.RotateMasterKey(key, newKey))
+	require.Equal(t, make([]byte, 32), masterKey)
+	masterKey = db.registry.opt.EncryptionKey
+	require.Equal(t, newKey, masterKey)
+	require.NoError(t, db.Close())
+	require.Equal(t, make([]byte, 32), dk.Data)
+	require.Equal(t, make([]byte, 32), masterKey)
+
+	// The keys read from the registry are locked too, and the DB reads back fine.
+	db, err = Open(opt.WithEncryptionKey(newKey))
+	require.NoError(t, err)
+	require.NotNil(t, db.registry.mem)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("foo"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), getItemValue(t, item))
+		return nil
+	}))
+	dk, err = db.registry.dataKey(dk.KeyId)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.Equal(t, make([]byte, 32), dk.Data)
+	// The caller's copy of the key is left alone.
+	require.NotEqual(t, make([]byte, 32), newKey)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// keyScope holds the state of the data keys scoped to a key prefix, like lastKeyID and
+// lastCreated of KeyRegistry do for the other keys. It's guarded by the lock of the registry.
+type keyScope struct {
+	lastCreated int64
+	lastKeyID   uint64
+}
+
+// validateKeyPrefixes checks the key prefixes having their own data keys.
+func validateKeyPrefixes(opt KeyRegistryOptions) error {
+	if len(opt.EncryptionKeyPrefixes) == 0 {
+		return nil
+	}
+	if !opt.encrypted() {
+		return errors.New("EncryptionKeyPrefixes requires an encryption key")
+	}
+	prefixes := sortedKeyPrefixes(opt.EncryptionKeyPrefixes)
+	for i, p := range prefixes {
+		switch {
+		case len(p) == 0:
+			return errors.New("EncryptionKeyPrefixes cannot hold an empty prefix")
+		case bytes.HasPrefix(p, badgerPrefix):
+			return errors.Errorf("Encryption key prefix %q is reserved", p)
+		case i > 0 && bytes.HasPrefix(p, prefixes[i-1]):
+			// Sorting puts a prefix right before the ones it's a prefix of.
+			return errors.Errorf("Encryption key prefix %q contains prefix %q", p, prefixes[i-1])
+		}
+	}
+	return nil
+}
+
+// sortedKeyPrefixes returns a sorted copy of prefixes.
+func sortedKeyPrefixes(prefixes [][]byte) [][]byte {
+	res := make([][]byte, 0, len(prefixes))
+	for _, p := range prefixes {
+		res = append(res, append([]byte{}, p...))
+	}
+	sort.Slice(res, func(i, j int) bool { return bytes.Compare(res[i], res[j]) < 0 })
+	return res
+}
+
+// keyScope returns the prefix of key having its own data keys, or nil if key uses the data keys
+// shared by the other keys. key must not have a timestamp. Since the prefixes don't contain each
+// other, the keys having the same one are contiguous, so tables can be split wherever the result
+// changes from one key to the next.
+func (kr *KeyRegistry) keyScope(key []byte) []byte {
+	if len(kr.prefixes) == 0 {
+		return nil
+	}
+	// The prefix of key, if any, is the largest prefix lower than or equal to it.
+	i := sort.Search(len(kr.prefixes), func(i int) bool {
+		return bytes.Compare(kr.prefixes[i], key) > 0
+	})
+	if i > 0 && bytes.HasPrefix(key, kr.prefixes[i-1]) {
+		return kr.prefixes[i-1]
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getRegistryTestOptions(dir, make([]byte, 32))
+	opt.EncryptionKeyRotationDuration = time.Hour
+	for _, prefixes := range [][][]byte{
+		{[]byte("a"), []byte("")},
+		{[]byte("a/"), []byte("a/b")},
+		{[]byte("!badger!x")},
+	} {
+		opt.EncryptionKeyPrefixes = prefixes
+		_, err := OpenKeyRegistry(opt)
+		require.Error(t, err, "%q", prefixes)
+	}
+	_, err = OpenKeyRegistry(KeyRegistryOptions{
+		Dir:                   dir,
+		EncryptionKeyPrefixes: [][]byte{[]byte("a/")},
+	})
+	require.Error(t, err)
+
+	opt.EncryptionKeyPrefixes = [][]byte{[]byte("b/"), []byte("a/"), []byte("ab")}
+	kr, err := OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	defer kr.Close()
+	for key, scope := range map[string]string{
+		"":     "",
+		"a":    "",
+		"a/":   "a/",
+		"a/x":  "a/",
+		"a0":   "",
+		"ab":   "ab",
+		"abc":  "ab",
+		"b":    "",
+		"b/x":  "b/",
+		"zzzz": "",
+	} {
+		require.Equal(t, scope, string(kr.keyScope([]byte(key))), "key %q", key)
+	}
+
+	dk, err := kr.latestDataKey(nil)
+	require.NoError(t, err)
+	require.Empty(t, dk.Prefix)
+	adk, err := kr.latestDataKey([]byte("a/x"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a/"), adk.Prefix)
+	require.NotEqual(t, dk.KeyId, adk.KeyId)
+	adk2, err := kr.latestDataKey([]byte("a/y"))
+	require.NoError(t, err)
+	require.Equal(t, adk.KeyId, adk2.KeyId)
+	bdk, err := kr.latestDataKey([]byte("b/"))
+	require.NoError(t, err)
+	require.NotEqual(t, adk.KeyId, bdk.KeyId)
+	require.NoError(t, kr.Close())
+
+	// The latest keys of every prefix are used again after reopening.
+	kr, err = OpenKeyRegistry(opt)
+	require.NoError(t, err)
+	for _, k := range []*struct {
+		key []byte
+		id  uint64
+	}{{nil, dk.KeyId}, {[]byte("a/"), adk.KeyId}, {[]byte("b/z"), bdk.KeyId}} {
+		got, err := kr.latestDataKey(k.key)
+		require.NoError(t, err)
+		require.Equal(t, k.id, got.KeyId)
+	}
+}
+
+// requireScopedTables checks that every table of db holds keys of a single scope, encrypted with a
+// data key of that scope, and returns the number of tables.
+func requireScopedTables(t *testing.T, db *DB) int {
+	var n int
+	for _, lh := range db.lc.levels {
+		lh.RLock()
+		for _, tbl := range lh.tables {
+			n++
+			dk, err := db.registry.dataKey(tbl.KeyID())
+			require.NoError(t, err)
+			it := tbl.NewIterator(false)
+			for it.Rewind(); it.Valid(); it.Next() {
+				scope := db.registry.keyScope(y.ParseKey(it.Key()))
+				require.True(t, bytes.Equal(scope, dk.Prefix), "key %q in table %d of key %d",
+					it.Key(), tbl.ID(), dk.KeyId)
+			}
+			it.Close()
+		}
+		lh.RUnlock()
+	}
+	return n
+}
+
+func TestEncryptionKeyPrefixes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	key := fmt.Sprintf("%032d", 0)
+	opt := getTestOptions(dir).WithEncryptionKey([]byte(key))
+	write := func(db *DB, round int) {
+		wb := db.NewWriteBatch()
+		for i := 0; i < 100; i++ {
+			for _, p := range []string{"0", "a/", "m", "b/", "z"} {
+				k := fmt.Sprintf("%s%03d", p, i)
+				require.NoError(t, wb.Set([]byte(k), []byte(fmt.Sprintf("%s-%d", k, round))))
+			}
+		}
+		require.NoError(t, wb.Flush())
+	}
+	read := func(db *DB, round int) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				for _, p := range []string{"0", "a/", "m", "b/", "z"} {
+					k := fmt.Sprintf("%s%03d", p, i)
+					item, err := txn.Get([]byte(k))
+					if err != nil {
+						return err
+					}
+					v, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					require.Equal(t, fmt.Sprintf("%s-%d", k, round), string(v))
+				}
+			}
+			return nil
+		}))
+	}
+
+	// Tables written before the prefixes are configured mix them.
+	db, err := Open(opt)
+	require.NoError(t, err)
+	write(db, 0)
+	require.NoError(t, db.Close())
+
+	opt = opt.WithEncryptionKeyPrefixes([][]byte{[]byte("a/"), []byte("b/")})
+	db, err = Open(opt)
+	require.NoError(t, err)
+	read(db, 0)
+	// Rewriting the tables splits them.
+	require.NoError(t, db.ReencryptAll(context.Background(), db.registry.nextKeyID+1, nil))
+	require.True(t, requireScopedTables(t, db) >= 3)
+	read(db, 0)
+
+	// So do flushes and compactions.
+	write(db, 1)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	requireScopedTables(t, db)
+	require.NoError(t, db.Flatten(1))
+	requireScopedTables(t, db)
+	read(db, 1)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	requireScopedTables(t, db)
+	read(db, 1)
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// KeyProvider supplies the master key, which encrypts the data keys in the key registry, from a
+// key management service such as AWS KMS, GCP KMS or Azure Key Vault. The key registry asks for
+// it when it's opened, and whenever it stores a data key, instead of getting it in the options.
+type KeyProvider interface {
+	// GetMasterKey returns the current master key, which must be 16, 24 or 32 bytes long.
+	GetMasterKey(ctx context.Context) ([]byte, error)
+}
+
+// KeyRewrapper is a KeyProvider able to rotate the master key, see DB.RewrapMasterKey.
+type KeyRewrapper interface {
+	KeyProvider
+	// NewMasterKey returns a new master key, of the same length as the current one, without
+	// making it current yet. It should be kept by the key management service before returning,
+	// since the data keys are rewrapped with it right after.
+	NewMasterKey(ctx context.Context) ([]byte, error)
+	// MasterKeyRewrapped is called once the data keys are encrypted with newKey, which
+	// GetMasterKey must return from then on. If it fails, newKey must still be made current for
+	// the key registry to be opened again.
+	MasterKeyRewrapped(ctx context.Context, newKey []byte) error
+}
+
+// KeyWrapper wraps the data keys with a master key it keeps to itself, such as a key stored in an
+// HSM and used through PKCS#11, see package hsm. Unlike with a KeyProvider, the master key never
+// gets in the memory of the process. The key registry stores the data keys as wrapped, along with
+// the label of the key they are wrapped with, and unwraps them when it's opened.
+type KeyWrapper interface {
+	// WrapKey wraps the data key, returning it wrapped along with the label of the key it's wrapped
+	// with, which identifies it to UnwrapKey.
+	WrapKey(ctx context.Context, key []byte) (wrapped []byte, label string, err error)
+	// UnwrapKey returns the data key wrapped with the key of the given label. Keys which wrapped
+	// data keys still in use must remain available to unwrap them.
+	UnwrapKey(ctx context.Context, label string, wrapped []byte) ([]byte, error)
+}
+
+// wrappedKeySize is the size of the data keys wrapped by a KeyWrapper, whose master key size
+// isn't known.
+const wrappedKeySize = 32
+
+// wrapDataKey wraps the key material of dk with the KeyWrapper of the registry. kr must be locked
+// or not shared yet.
+func (kr *KeyRegistry) wrapDataKey(ctx context.Context, dk *pb.DataKey) error {
+	wrapped, label, err := kr.opt.KeyWrapper.WrapKey(ctx, dk.Data)
+	if err != nil {
+		return errors.Wrapf(err, "Error while wrapping data key %d", dk.KeyId)
+	}
+	if label == "" {
+		return errors.Errorf("KeyWrapper returned an empty label for data key %d", dk.KeyId)
+	}
+	dk.WrappingKey = label
+	kr.wrapped[dk.KeyId] = wrapped
+	return nil
+}
+
+// unwrapDataKey replaces the key material of dk, as read from the registry, with the one unwrapped
+// by the KeyWrapper of the registry. kr must be locked or not shared yet.
+func (kr *KeyRegistry) unwrapDataKey(ctx context.Context, dk *pb.DataKey) error {
+	if kr.opt.KeyWrapper == nil {
+		return y.Wrapf(ErrEncryptionKeyMismatch, "Data key %d is wrapped with %q, which "+
+			"requires a KeyWrapper", dk.KeyId, dk.WrappingKey)
+	}
+	key, err := kr.opt.KeyWrapper.UnwrapKey(ctx, dk.WrappingKey, dk.Data)
+	if err != nil {
+		return errors.Wrapf(err, "Error while unwrapping data key %d with %q", dk.KeyId,
+			dk.WrappingKey)
+	}
+	kr.wrapped[dk.KeyId] = dk.Data
+	dk.Data = key
+	return nil
+}
+
+// storeKey stores k in buf like storeDataKey, as wrapped if it was wrapped by a KeyWrapper.
+func (kr *KeyRegistry) storeKey(buf *bytes.Buffer, masterKey []byte, k *pb.DataKey) error {
+	if k.WrappingKey == "" {
+		return storeDataKey(buf, masterKey, k)
+	}
+	stored := *k
+	stored.Data = kr.wrapped[k.KeyId]
+	return storeDataKey(buf, nil, &stored)
+}
+
+// masterKey returns the master key, from the KeyProvider if there is one. It's empty if the data
+// keys aren't encrypted, or are wrapped by a KeyWrapper.
+func (opt KeyRegistryOptions) masterKey(ctx context.Context) ([]byte, error) {
+	key := opt.EncryptionKey
+	if opt.KeyProvider != nil {
+		var err error
+		if key, err = opt.KeyProvider.GetMasterKey(ctx); err != nil {
+			return nil, errors.Wrap(err, "Error while getting master key from KeyProvider")
+		}
+		if len(key) == 0 {
+			return nil, errors.Wrap(ErrInvalidEncryptionKey, "KeyProvider returned an empty key")
+		}
+	}
+	switch len(key) {
+	case 0, 16, 24, 32:
+		return key, opt.checkFIPSKey(key)
+	default:
+		return nil, ErrInvalidEncryptionKey
+	}
+}
+
+// encrypted returns true if the data keys are encrypted with a master key.
+func (opt KeyRegistryOptions) encrypted() bool {
+	return len(opt.EncryptionKey) > 0 || opt.KeyProvider != nil || opt.KeyWrapper != nil ||
+		opt.EncryptionPassphrase != ""
+}
+
+// RewrapMasterKey encrypts all the data keys with a new master key, obtained from the
+// KeyRewrapper of the registry. Data keys aren't generated in the meantime.
+func (kr *KeyRegistry) RewrapMasterKey(ctx context.Context) error {
+	rw, ok := kr.opt.KeyProvider.(KeyRewrapper)
+	if !ok {
+		return errors.New("RewrapMasterKey requires a KeyProvider implementing KeyRewrapper")
+	}
+	if kr.opt.ReadOnly {
+		return errors.New("RewrapMasterKey cannot be called in read-only mode")
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	oldKey, err := kr.opt.masterKey(ctx)
+	if err != nil {
+		return err
+	}
+	newKey, err := rw.NewMasterKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Error while getting new master key from KeyProvider")
+	}
+	if len(newKey) != len(oldKey) {
+		return y.Wrapf(ErrInvalidEncryptionKey,
+			"New master key has %d bytes instead of %d", len(newKey), len(oldKey))
+	}
+	if !kr.opt.InMemory {
+		if err := kr.rewrite(newKey); err != nil {
+			return err
+		}
+	}
+	if err := kr.audit.record(KeyEvent{Kind: MasterKeyRotated}); err != nil {
+		return err
+	}
+	return errors.Wrap(rw.MasterKeyRewrapped(ctx, newKey),
+		"Error while making the new master key current")
+}
+
+// RotateMasterKey encrypts all the data keys with newKey instead of oldKey, which must be the
+// current EncryptionKey. The key registry file is replaced atomically, so it holds the data keys
+// encrypted with either key if the process crashes in the middle.
+func (kr *KeyRegistry) RotateMasterKey(oldKey, newKey []byte) error {
+	if kr.opt.KeyProvider != nil {
+		return errors.New("RotateMasterKey cannot be used with a KeyProvider, use RewrapMasterKey")
+	}
+	if kr.opt.KeyWrapper != nil {
+		return errors.New("RotateMasterKey cannot be used with a KeyWrapper, whose keys are " +
+			"rotated by the KeyWrapper itself")
+	}
+	if kr.opt.EncryptionPassphrase != "" {
+		return errors.New("RotateMasterKey cannot be used with an EncryptionPassphrase")
+	}
+	if kr.opt.ReadOnly {
+		return errors.New("RotateMasterKey cannot be called in read-only mode")
+	}
+	if len(kr.opt.EncryptionKey) == 0 {
+		return errors.New("RotateMasterKey requires an encrypted DB")
+	}
+	switch len(newKey) {
+	case 16, 24, 32:
+	default:
+		return y.Wrapf(ErrInvalidEncryptionKey, "During RotateMasterKey")
+	}
+	if err := kr.opt.checkFIPSKey(newKey); err != nil {
+		return err
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	if subtle.ConstantTimeCompare(oldKey, kr.opt.EncryptionKey) != 1 {
+		return ErrEncryptionKeyMismatch
+	}
+	newKey, err := kr.mem.copy(newKey)
+	if err != nil {
+		return err
+	}
+	if !kr.opt.InMemory {
+		if err := kr.rewrite(newKey); err != nil {
+			kr.mem.forget(newKey)
+			return err
+		}
+	}
+	kr.mem.forget(kr.opt.EncryptionKey)
+	kr.opt.EncryptionKey = newKey
+	return kr.audit.record(KeyEvent{Kind: MasterKeyRotated})
+}
+
+// RewrapMasterKey encrypts the data keys with a new master key, obtained from Options.KeyProvider
+// which must implement KeyRewrapper. The data itself isn't rewritten, since it's encrypted with
+// the data keys. See ReencryptAll to rotate these. It returns ErrFrozen while the DB is frozen.
+func (db *DB) RewrapMasterKey(ctx context.Context) error {
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return db.registry.RewrapMasterKey(ctx)
+}
+
+// RotateMasterKey encrypts the data keys with newKey instead of oldKey, the EncryptionKey the DB
+// was opened with, while the DB keeps running. Once it returns, the DB must be opened with newKey.
+// Like RewrapMasterKey, it doesn't rewrite the data, only the key registry. It returns ErrFrozen
+// while the DB is frozen.
+func (db *DB) RotateMasterKey(oldKey, newKey []byte) error {
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return db.registry.RotateMasterKey(oldKey, newKey)
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"crypto/aes"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyProvider keeps its master keys in memory, like a key management service would.
+type testKeyProvider struct {
+	sync.Mutex
+	current, pending []byte
+	gets             int
+}
+
+func newTestKeyProvider(t *testing.T) *testKeyProvider {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return &testKeyProvider{current: key}
+}
+
+func (p *testKeyProvider) GetMasterKey(ctx context.Context) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.gets++
+	return p.current, nil
+}
+
+func (p *testKeyProvider) NewMasterKey(ctx context.Context) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.pending = make([]byte, len(p.current))
+	_, err := rand.Read(p.pending)
+	return p.pending, err
+}
+
+func (p *testKeyProvider) MasterKeyRewrapped(ctx context.Context, newKey []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	p.current, p.pending = newKey, nil
+	return nil
+}
+
+func TestKeyProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	kp := newTestKeyProvider(t)
+	opt := getTestOptions(dir).WithKeyProvider(kp)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.True(t, db.shouldEncrypt())
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.Close())
+	require.True(t, kp.gets > 0)
+
+	// The data keys are encrypted with the master key of the provider.
+	_, err = Open(getTestOptions(dir))
+	require.Error(t, err)
+	db, err = Open(getTestOptions(dir).WithEncryptionKey(kp.current))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Both can't be set.
+	_, err = Open(opt.WithEncryptionKey(kp.current))
+	require.Error(t, err)
+}
+
+func TestRewrapMasterKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	kp := newTestKeyProvider(t)
+	opt := getTestOptions(dir).WithKeyProvider(kp)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	oldKey := kp.current
+	require.NoError(t, db.Freeze())
+	require.Equal(t, ErrFrozen, db.RewrapMasterKey(context.Background()))
+	require.NoError(t, db.Thaw())
+	require.Equal(t, oldKey, kp.current)
+	require.NoError(t, db.RewrapMasterKey(context.Background()))
+	require.NotEqual(t, oldKey, kp.current)
+	require.Nil(t, kp.pending)
+	require.NoError(t, db.Close())
+
+	_, err = Open(getTestOptions(dir).WithEncryptionKey(oldKey))
+	require.Error(t, err)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("foo"))
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		require.Equal(t, []byte("bar"), val)
+		return err
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestRewrapMasterKeyWithoutRewrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	key := make([]byte, 16)
+	db, err := Open(getTestOptions(dir).WithEncryptionKey(key))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Error(t, db.RewrapMasterKey(context.Background()))
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	oldKey, newKey := make([]byte, 32), make([]byte, 16)
+	_, err = rand.Read(oldKey)
+	require.NoError(t, err)
+	_, err = rand.Read(newKey)
+	require.NoError(t, err)
+
+	db, err := Open(getTestOptions(dir).WithEncryptionKey(oldKey))
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.Freeze())
+	require.Equal(t, ErrFrozen, db.RotateMasterKey(oldKey, newKey))
+	require.NoError(t, db.Thaw())
+	require.Equal(t, ErrEncryptionKeyMismatch, db.RotateMasterKey(newKey, oldKey))
+	require.Error(t, db.RotateMasterKey(oldKey, newKey[:10]))
+	require.NoError(t, db.RotateMasterKey(oldKey, newKey))
+	// The DB keeps running, and new data keys are encrypted with the new master key.
+	db.registry.lastCreated = 0
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo2"), []byte("bar2"))
+	}))
+	_, err = db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(getTestOptions(dir).WithEncryptionKey(oldKey))
+	require.Error(t, err)
+	db, err = Open(getTestOptions(dir).WithEncryptionKey(newKey))
+	require.NoError(t, err)
+	require.Len(t, db.registry.DataKeys(), 2)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for _, k := range []string{"foo", "foo2"} {
+			if _, err := txn.Get([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+// testKeyWrapper keeps labeled wrapping keys to itself, like an HSM would.
+type testKeyWrapper struct {
+	sync.Mutex
+	keys    map[string][]byte
+	current string
+}
+
+func newTestKeyWrapper() *testKeyWrapper {
+	w := &testKeyWrapper{keys: make(map[string][]byte)}
+	w.rotate("key-1")
+	return w
+}
+
+func (w *testKeyWrapper) rotate(label string) {
+	w.Lock()
+	defer w.Unlock()
+	key := make([]byte, 32)
+	rand.Read(key)
+	w.keys[label], w.current = key, label
+}
+
+func (w *testKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	w.Lock()
+	defer w.Unlock()
+	wrapped, err := y.XORBlock(key, w.keys[w.current], make([]byte, aes.BlockSize))
+	return wrapped, w.current, err
+}
+
+func (w *testKeyWrapper) UnwrapKey(ctx context.Context, label string,
+	wrapped []byte) ([]byte, error) {
+	w.Lock()
+	defer w.Unlock()
+	key, ok := w.keys[label]
+	if !ok {
+		return nil, errors.Errorf("no key labeled %q", label)
+	}
+	return y.XORBlock(wrapped, key, make([]byte, aes.BlockSize))
+}
+
+func TestKeyWrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	kw := newTestKeyWrapper()
+	opt := getTestOptions(dir).WithKeyWrapper(kw).WithEncryptionKeyRotationDuration(time.Hour)
+
+	set := func(db *DB, key string) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key), []byte("bar"))
+		}))
+	}
+	check := func(db *DB, keys ...string) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, key := range keys {
+				item, err := txn.Get([]byte(key))
+				require.NoError(t, err)
+				require.Equal(t, []byte("bar"), getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.True(t, db.shouldEncrypt())
+	set(db, "foo")
+	require.NoError(t, db.Close())
+
+	// Data keys generated after the rotation are wrapped with the new key, while the older ones
+	// keep being unwrapped with the old one.
+	kw.rotate("key-2")
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db, "foo")
+	require.NoError(t, db.registry.rotateOlderThan(db.registry.nextKeyID+1))
+	_, err = db.registry.latestDataKey(nil)
+	require.NoError(t, err)
+	set(db, "baz")
+	var labels []string
+	for _, dk := range db.registry.DataKeys() {
+		labels = append(labels, dk.WrappingKey)
+	}
+	require.Equal(t, []string{"key-1", "key-2"}, labels)
+	require.Error(t, db.RotateMasterKey(nil, make([]byte, 32)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db, "foo", "baz")
+	require.NoError(t, db.Close())
+
+	// The registry can't be opened without the KeyWrapper.
+	_, err = Open(opt.WithKeyWrapper(nil))
+	require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+	_, err = Open(opt.WithKeyWrapper(nil).WithEncryptionKey(make([]byte, 32)))
+	require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+	_, err = Open(opt.WithEncryptionKey(make([]byte, 32)))
+	require.Error(t, err)
+}
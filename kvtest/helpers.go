@@ -0,0 +1,33 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kvtest
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// OpenInMemory opens an in-memory DB which doesn't log, for the tests of packages built on top of
+// Badger. It fails t if the DB can't be opened.
+func OpenInMemory(t testing.TB) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Unable to open in-memory DB: %v", err)
+	}
+	return db
+}
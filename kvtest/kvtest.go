@@ -0,0 +1,460 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kvtest contains a randomized model-checking suite for the Badger key-value contract.
+//
+// The suite runs random transactions against a store and an in-memory model side by side and
+// fails as soon as they disagree. It checks reads inside and outside of transactions, conflict
+// detection between overlapping transactions, forward and reverse iteration, and durability
+// across restarts and simulated process crashes. Applications wrapping Badger, or running it with
+// unusual options, can run it from their own tests:
+//
+//	func TestStore(t *testing.T) {
+//		kvtest.Run(t, func(dir string) (kvtest.Store, error) {
+//			return badger.Open(badger.DefaultOptions(dir).WithSyncWrites(true))
+//		}, kvtest.DefaultConfig())
+//	}
+package kvtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// Store is the part of *badger.DB exercised by the suite. *badger.DB satisfies it, wrappers can
+// satisfy it by delegating to the DB they wrap.
+type Store interface {
+	NewTransaction(update bool) *badger.Txn
+	Update(fn func(txn *badger.Txn) error) error
+	View(fn func(txn *badger.Txn) error) error
+	Close() error
+}
+
+// OpenFunc opens a Store keeping its data in dir. It is called once at the start of the run and
+// again after every restart or crash.
+type OpenFunc func(dir string) (Store, error)
+
+// Config controls the shape of a run.
+type Config struct {
+	// Seed seeds the random generator. Failures report the seed, so a failing run can be
+	// reproduced by setting it.
+	Seed int64
+	// NumTxns is the number of random transactions to run.
+	NumTxns int
+	// MaxOpsPerTxn is the maximum number of operations in a single transaction.
+	MaxOpsPerTxn int
+	// NumKeys is the size of the key space. A small key space makes conflicts and
+	// overwrites more likely.
+	NumKeys int
+	// MaxValueSize is the maximum size of a value. Values are spread over the whole range, so
+	// both values stored in the LSM tree and in the value log are exercised.
+	MaxValueSize int
+	// RestartEvery closes and reopens the store every RestartEvery transactions. Zero disables
+	// restarts.
+	RestartEvery int
+	// CrashEvery simulates a process crash every CrashEvery transactions by copying the files of
+	// the live store to a new directory, without closing it, and opening the copy. Committed
+	// writes are expected to survive, so this requires the store to be opened with SyncWrites.
+	// Zero disables crashes.
+	CrashEvery int
+	// IterateEvery checks full and prefix iteration every IterateEvery transactions. Zero
+	// disables iteration checks.
+	IterateEvery int
+}
+
+// DefaultConfig returns a Config which runs in a few seconds.
+func DefaultConfig() Config {
+	return Config{
+		Seed:         1,
+		NumTxns:      2000,
+		MaxOpsPerTxn: 10,
+		NumKeys:      200,
+		MaxValueSize: 256,
+		RestartEvery: 500,
+		CrashEvery:   700,
+		IterateEvery: 100,
+	}
+}
+
+// Run runs the suite described by cfg against stores opened with open.
+func Run(t testing.TB, open OpenFunc, cfg Config) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "badger-kvtest")
+	if err != nil {
+		t.Fatalf("while creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	h := &harness{
+		t:     t,
+		cfg:   cfg,
+		open:  open,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+		model: make(map[string][]byte),
+		root:  root,
+	}
+	h.dir = h.nextDir()
+	if h.store, err = open(h.dir); err != nil {
+		h.fatalf("open: %v", err)
+	}
+	defer func() {
+		if h.store != nil {
+			h.store.Close()
+		}
+	}()
+
+	for i := 1; i <= cfg.NumTxns; i++ {
+		h.txnNum = i
+		if h.rng.Intn(4) == 0 {
+			h.runConflictingTxns()
+		} else {
+			h.runTxn()
+		}
+		if cfg.IterateEvery > 0 && i%cfg.IterateEvery == 0 {
+			h.checkIteration()
+		}
+		if cfg.RestartEvery > 0 && i%cfg.RestartEvery == 0 {
+			h.restart()
+			h.checkAll()
+		}
+		if cfg.CrashEvery > 0 && i%cfg.CrashEvery == 0 {
+			h.crash()
+			h.checkAll()
+		}
+	}
+	h.checkAll()
+	h.checkIteration()
+}
+
+type harness struct {
+	t      testing.TB
+	cfg    Config
+	open   OpenFunc
+	rng    *rand.Rand
+	model  map[string][]byte
+	store  Store
+	root   string
+	dir    string
+	numDir int
+	txnNum int
+}
+
+func (h *harness) fatalf(format string, args ...interface{}) {
+	h.t.Helper()
+	h.t.Fatalf("kvtest (seed %d, txn %d): %s", h.cfg.Seed, h.txnNum, fmt.Sprintf(format, args...))
+}
+
+func (h *harness) nextDir() string {
+	h.numDir++
+	return filepath.Join(h.root, fmt.Sprintf("db-%d", h.numDir))
+}
+
+func (h *harness) randKey() []byte {
+	return []byte(fmt.Sprintf("key-%06d", h.rng.Intn(h.cfg.NumKeys)))
+}
+
+func (h *harness) randValue() []byte {
+	val := make([]byte, h.rng.Intn(h.cfg.MaxValueSize+1))
+	h.rng.Read(val)
+	return val
+}
+
+// checkGet verifies that txn sees want (nil meaning absent) for key.
+func (h *harness) checkGet(txn *badger.Txn, key, want []byte) {
+	h.t.Helper()
+	item, err := txn.Get(key)
+	switch {
+	case err == badger.ErrKeyNotFound:
+		if want != nil {
+			h.fatalf("Get(%q): key not found, want value of size %d", key, len(want))
+		}
+		return
+	case err != nil:
+		h.fatalf("Get(%q): %v", key, err)
+	}
+	got, err := item.ValueCopy(nil)
+	if err != nil {
+		h.fatalf("ValueCopy(%q): %v", key, err)
+	}
+	if want == nil {
+		h.fatalf("Get(%q): found value of size %d, want key not found", key, len(got))
+	}
+	if !bytes.Equal(got, want) {
+		h.fatalf("Get(%q): value mismatch, got size %d want size %d", key, len(got), len(want))
+	}
+}
+
+// fill runs random operations on txn, checking reads against the model overlaid with the
+// writes done so far. It returns the writes done, nil values meaning deletes, and the keys read.
+func (h *harness) fill(txn *badger.Txn) (map[string][]byte, map[string]bool) {
+	h.t.Helper()
+	writes := make(map[string][]byte)
+	reads := make(map[string]bool)
+	n := 1 + h.rng.Intn(h.cfg.MaxOpsPerTxn)
+	for i := 0; i < n; i++ {
+		key := h.randKey()
+		switch h.rng.Intn(3) {
+		case 0:
+			want, ok := writes[string(key)]
+			if !ok {
+				want = h.model[string(key)]
+			}
+			h.checkGet(txn, key, want)
+			reads[string(key)] = true
+		case 1:
+			val := h.randValue()
+			if err := txn.Set(key, val); err != nil {
+				h.fatalf("Set(%q): %v", key, err)
+			}
+			writes[string(key)] = val
+		case 2:
+			if err := txn.Delete(key); err != nil {
+				h.fatalf("Delete(%q): %v", key, err)
+			}
+			writes[string(key)] = nil
+		}
+	}
+	return writes, reads
+}
+
+func (h *harness) apply(writes map[string][]byte) {
+	for k, v := range writes {
+		if v == nil {
+			delete(h.model, k)
+		} else {
+			h.model[k] = v
+		}
+	}
+}
+
+func (h *harness) runTxn() {
+	h.t.Helper()
+	txn := h.store.NewTransaction(true)
+	defer txn.Discard()
+	writes, _ := h.fill(txn)
+	if h.rng.Intn(10) == 0 {
+		// Discarded transactions must not leave any trace.
+		txn.Discard()
+		return
+	}
+	if err := txn.Commit(); err != nil {
+		h.fatalf("Commit: %v", err)
+	}
+	h.apply(writes)
+}
+
+// runConflictingTxns runs two overlapping transactions. The second one to commit must fail
+// with ErrConflict if and only if it read a key written by the first one.
+func (h *harness) runConflictingTxns() {
+	h.t.Helper()
+	txn1 := h.store.NewTransaction(true)
+	defer txn1.Discard()
+	txn2 := h.store.NewTransaction(true)
+	defer txn2.Discard()
+
+	writes1, _ := h.fill(txn1)
+	writes2, reads2 := h.fill(txn2)
+
+	if err := txn1.Commit(); err != nil {
+		h.fatalf("Commit of first overlapping txn: %v", err)
+	}
+	h.apply(writes1)
+
+	wantConflict := false
+	for k := range reads2 {
+		if _, ok := writes1[k]; ok {
+			wantConflict = true
+			break
+		}
+	}
+	err := txn2.Commit()
+	switch {
+	case len(writes2) == 0 && err != nil:
+		h.fatalf("Commit of read-only overlapping txn: %v", err)
+	case len(writes2) == 0:
+	case wantConflict && errors.Cause(err) != badger.ErrConflict:
+		h.fatalf("Commit of overlapping txn: got %v, want ErrConflict", err)
+	case !wantConflict && err != nil:
+		h.fatalf("Commit of overlapping txn: got %v, want no error", err)
+	case err == nil:
+		h.apply(writes2)
+	}
+}
+
+func (h *harness) sortedModelKeys(prefix []byte) []string {
+	var keys []string
+	for k := range h.model {
+		if bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkAll verifies every key of the key space against the model.
+func (h *harness) checkAll() {
+	h.t.Helper()
+	err := h.store.View(func(txn *badger.Txn) error {
+		for i := 0; i < h.cfg.NumKeys; i++ {
+			key := []byte(fmt.Sprintf("key-%06d", i))
+			h.checkGet(txn, key, h.model[string(key)])
+		}
+		return nil
+	})
+	if err != nil {
+		h.fatalf("View: %v", err)
+	}
+}
+
+// checkIteration verifies forward and reverse iteration over the whole key space and over a
+// random prefix.
+func (h *harness) checkIteration() {
+	h.t.Helper()
+	prefix := []byte(fmt.Sprintf("key-%05d", h.rng.Intn(h.cfg.NumKeys/10+1)))
+	for _, p := range [][]byte{nil, prefix} {
+		want := h.sortedModelKeys(p)
+		for _, reverse := range []bool{false, true} {
+			err := h.store.View(func(txn *badger.Txn) error {
+				opt := badger.DefaultIteratorOptions
+				opt.Prefix = p
+				opt.Reverse = reverse
+				opt.PrefetchValues = h.rng.Intn(2) == 0
+				it := txn.NewIterator(opt)
+				defer it.Close()
+
+				// Reverse iteration over a prefix has to start past the last key having it.
+				var seek []byte
+				if reverse && len(p) > 0 {
+					seek = append(append(seek, p...), 0xff)
+				}
+				var got []string
+				for it.Seek(seek); it.Valid(); it.Next() {
+					item := it.Item()
+					val, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					if !bytes.Equal(val, h.model[string(item.Key())]) {
+						h.fatalf("iteration (prefix %q, reverse %v): value mismatch for %q",
+							p, reverse, item.Key())
+					}
+					got = append(got, string(item.Key()))
+				}
+				if reverse {
+					for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+						got[i], got[j] = got[j], got[i]
+					}
+				}
+				if len(got) != len(want) {
+					h.fatalf("iteration (prefix %q, reverse %v): got %d keys, want %d",
+						p, reverse, len(got), len(want))
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						h.fatalf("iteration (prefix %q, reverse %v): key %d is %q, want %q",
+							p, reverse, i, got[i], want[i])
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				h.fatalf("View: %v", err)
+			}
+		}
+	}
+}
+
+func (h *harness) restart() {
+	h.t.Helper()
+	if err := h.store.Close(); err != nil {
+		h.fatalf("Close: %v", err)
+	}
+	h.store = nil
+	var err error
+	if h.store, err = h.open(h.dir); err != nil {
+		h.fatalf("reopen: %v", err)
+	}
+}
+
+// crash copies the files of the running store into a fresh directory and continues with a store
+// opened on the copy. The copy sees exactly what a new process would see had this one been
+// killed, minus the directory lock.
+func (h *harness) crash() {
+	h.t.Helper()
+	dir := h.nextDir()
+	if err := copyDir(h.dir, dir); err != nil {
+		h.fatalf("while copying %s: %v", h.dir, err)
+	}
+	if err := h.store.Close(); err != nil {
+		h.fatalf("Close: %v", err)
+	}
+	h.store = nil
+	os.RemoveAll(h.dir)
+
+	h.dir = dir
+	var err error
+	if h.store, err = h.open(h.dir); err != nil {
+		h.fatalf("open after crash: %v", err)
+	}
+}
+
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || info.Name() == "LOCK" {
+			continue
+		}
+		err := copyFile(filepath.Join(src, info.Name()), filepath.Join(dst, info.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kvtest
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+func TestBadger(t *testing.T) {
+	Run(t, func(dir string) (Store, error) {
+		opt := badger.DefaultOptions(dir).
+			WithMaxTableSize(1 << 15).
+			WithValueLogFileSize(1 << 20).
+			WithValueThreshold(64).
+			WithLogger(nil)
+		return badger.Open(opt)
+	}, DefaultConfig())
+}
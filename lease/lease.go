@@ -0,0 +1,263 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package lease implements TTL based leases on top of Badger, to coordinate workers sharing a DB.
+//
+// A lease is held by a single owner until it's released or its TTL runs out without being renewed.
+// Every time a lease is acquired it gets a new fencing token, higher than all the previous ones for
+// the same name. Writes done on behalf of a lease holder should be guarded with Check, in the same
+// transaction, or pass the token along to other systems, so that a holder which lost its lease
+// (e.g. after a long pause) can't step on the new one:
+//
+//	m := lease.New(db, []byte("leases/"))
+//	l, err := m.Acquire("compactor", workerID, 10*time.Second)
+//	if err == lease.ErrHeld {
+//		// Someone else is doing the work.
+//	}
+//	...
+//	err = db.Update(func(txn *badger.Txn) error {
+//		if err := m.Check(txn, l); err != nil {
+//			return err
+//		}
+//		return txn.Set(key, val)
+//	})
+package lease
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrHeld is returned by Acquire when the lease is held by another owner.
+	ErrHeld = errors.New("Lease is held by another owner")
+
+	// ErrNotHeld is returned when a lease has expired, or was released or acquired by someone else.
+	ErrNotHeld = errors.New("Lease is not held")
+)
+
+// Key layout, under the prefix of the Manager.
+const (
+	leaseTag = 'l' // Current lease, followed by its name.
+	tokenTag = 't' // Last fencing token handed out, followed by the name of the lease.
+)
+
+// maxConflictRetries is the number of times an operation is retried when its transaction
+// conflicts with another one on the same lease.
+const maxConflictRetries = 10
+
+// Lease is a lease held by an owner.
+type Lease struct {
+	Name  string
+	Owner string
+	// Token is the fencing token of the lease. It's increased every time the lease is acquired, and
+	// stays the same when the lease is renewed.
+	Token   uint64
+	Expires time.Time
+}
+
+// Manager manages leases stored under a key prefix.
+type Manager struct {
+	db     *badger.DB
+	prefix []byte
+}
+
+// New returns a Manager storing leases in db under prefix. The prefix must not be used for
+// anything else.
+func New(db *badger.DB, prefix []byte) *Manager {
+	return &Manager{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+func (m *Manager) key(tag byte, name string) []byte {
+	key := make([]byte, 0, len(m.prefix)+1+len(name))
+	key = append(key, m.prefix...)
+	key = append(key, tag)
+	return append(key, name...)
+}
+
+// The value of a lease key is the fencing token, followed by the expiration time in nanoseconds
+// since the epoch and the owner.
+func encodeLease(l *Lease) []byte {
+	buf := make([]byte, 16+len(l.Owner))
+	binary.BigEndian.PutUint64(buf[0:8], l.Token)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(l.Expires.UnixNano()))
+	copy(buf[16:], l.Owner)
+	return buf
+}
+
+func decodeLease(name string, buf []byte) (*Lease, error) {
+	if len(buf) < 16 {
+		return nil, errors.Errorf("Invalid lease of size %d for %q", len(buf), name)
+	}
+	return &Lease{
+		Name:    name,
+		Owner:   string(buf[16:]),
+		Token:   binary.BigEndian.Uint64(buf[0:8]),
+		Expires: time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:16]))),
+	}, nil
+}
+
+// get returns the current lease of the given name, nil if there's none or it has expired.
+func (m *Manager) get(txn *badger.Txn, name string) (*Lease, error) {
+	item, err := txn.Get(m.key(leaseTag, name))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l *Lease
+	err = item.Value(func(val []byte) error {
+		l, err = decodeLease(name, val)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(l.Expires) {
+		return nil, nil
+	}
+	return l, nil
+}
+
+func (m *Manager) set(txn *badger.Txn, l *Lease) error {
+	e := badger.NewEntry(m.key(leaseTag, l.Name), encodeLease(l))
+	// Let Badger get rid of expired leases. The TTL is rounded up, Badger only works with seconds.
+	return txn.SetEntry(e.WithTTL(time.Until(l.Expires) + time.Second))
+}
+
+// update runs fn in a read-write transaction, retrying it if the transaction conflicts.
+func (m *Manager) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = m.db.Update(fn); errors.Cause(err) != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// Acquire acquires the lease of the given name for owner, for ttl. It returns ErrHeld if the lease
+// is held by another owner. If owner already holds the lease, it gets renewed, keeping its token.
+func (m *Manager) Acquire(name, owner string, ttl time.Duration) (*Lease, error) {
+	var l *Lease
+	err := m.update(func(txn *badger.Txn) error {
+		cur, err := m.get(txn, name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case cur == nil:
+			tokenKey := m.key(tokenTag, name)
+			var token uint64
+			item, err := txn.Get(tokenKey)
+			switch {
+			case err == badger.ErrKeyNotFound:
+			case err != nil:
+				return err
+			default:
+				if err := item.Value(func(val []byte) error {
+					if len(val) != 8 {
+						return errors.Errorf("Invalid fencing token for %q", name)
+					}
+					token = binary.BigEndian.Uint64(val)
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+			token++
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, token)
+			if err := txn.Set(tokenKey, buf); err != nil {
+				return err
+			}
+			l = &Lease{Name: name, Owner: owner, Token: token}
+		case cur.Owner == owner:
+			l = cur
+		default:
+			return ErrHeld
+		}
+		l.Expires = time.Now().Add(ttl)
+		return m.set(txn, l)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Renew extends l for ttl from now. It returns ErrNotHeld if l isn't the current lease anymore.
+func (m *Manager) Renew(l *Lease, ttl time.Duration) (*Lease, error) {
+	var renewed Lease
+	err := m.update(func(txn *badger.Txn) error {
+		if err := m.Check(txn, l); err != nil {
+			return err
+		}
+		renewed = *l
+		renewed.Expires = time.Now().Add(ttl)
+		return m.set(txn, &renewed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &renewed, nil
+}
+
+// Release releases l, so that the lease can be acquired right away. It returns ErrNotHeld if l
+// isn't the current lease anymore.
+func (m *Manager) Release(l *Lease) error {
+	return m.update(func(txn *badger.Txn) error {
+		if err := m.Check(txn, l); err != nil {
+			return err
+		}
+		return txn.Delete(m.key(leaseTag, l.Name))
+	})
+}
+
+// Get returns the current lease of the given name. It returns ErrNotHeld if nobody holds it.
+func (m *Manager) Get(name string) (*Lease, error) {
+	var l *Lease
+	err := m.db.View(func(txn *badger.Txn) error {
+		var err error
+		l, err = m.get(txn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return nil, ErrNotHeld
+	}
+	return l, nil
+}
+
+// Check returns ErrNotHeld if l isn't the current lease. Called in a read-write transaction, the
+// transaction conflicts with any change of the lease committed before it, so the writes of txn
+// only get committed if l is still held.
+func (m *Manager) Check(txn *badger.Txn, l *Lease) error {
+	cur, err := m.get(txn, l.Name)
+	if err != nil {
+		return err
+	}
+	if cur == nil || cur.Token != l.Token {
+		return ErrNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lease
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	m := New(db, []byte("leases/"))
+
+	_, err := m.Get("job")
+	require.Equal(t, ErrNotHeld, err)
+
+	l1, err := m.Acquire("job", "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), l1.Token)
+	_, err = m.Acquire("job", "b", time.Minute)
+	require.Equal(t, ErrHeld, err)
+	// Other leases are independent.
+	other, err := m.Acquire("other", "b", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), other.Token)
+
+	// Acquiring a lease again renews it.
+	l1, err = m.Acquire("job", "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), l1.Token)
+	l1, err = m.Renew(l1, time.Minute)
+	require.NoError(t, err)
+	cur, err := m.Get("job")
+	require.NoError(t, err)
+	require.Equal(t, "a", cur.Owner)
+
+	require.NoError(t, m.Release(l1))
+	require.Equal(t, ErrNotHeld, m.Release(l1))
+	l2, err := m.Acquire("job", "b", 100*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), l2.Token)
+
+	// Once expired, the lease can't be renewed and goes to the next owner.
+	time.Sleep(150 * time.Millisecond)
+	_, err = m.Renew(l2, time.Minute)
+	require.Equal(t, ErrNotHeld, err)
+	l3, err := m.Acquire("job", "c", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), l3.Token)
+
+	// Writes guarded by a lost lease don't get committed.
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := m.Check(txn, l2); err != nil {
+			return err
+		}
+		return txn.Set([]byte("key"), []byte("val"))
+	})
+	require.Equal(t, ErrNotHeld, err)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return m.Check(txn, l3)
+	}))
+}
+
+func TestLeaseConcurrentAcquire(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	m := New(db, []byte("leases/"))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var held []*Lease
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l, err := m.Acquire("job", fmt.Sprintf("worker%d", i), time.Minute)
+			if err == ErrHeld {
+				return
+			}
+			require.NoError(t, err)
+			mu.Lock()
+			held = append(held, l)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	require.Len(t, held, 1)
+	require.Equal(t, uint64(1), held[0].Token)
+}
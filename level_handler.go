@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dgryski/go-farm"
 
@@ -45,21 +46,33 @@ type levelHandler struct {
 	db           *DB
 }
 
+// compareKeys compares two keys with timestamps using the comparator of the DB.
+func (s *levelHandler) compareKeys(key1, key2 []byte) int {
+	return y.CompareKeysWith(s.db.opt.Comparator, key1, key2)
+}
+
 func (s *levelHandler) getTotalSize() int64 {
 	s.RLock()
 	defer s.RUnlock()
 	return s.totalSize
 }
 
+// addSize adds delta to the size of the level, and to the size of the tables of the DB. You must
+// hold the lock.
+func (s *levelHandler) addSize(delta int64) {
+	s.totalSize += delta
+	atomic.AddInt64(&s.db.tablesSize, delta)
+}
+
 // initTables replaces s.tables with given tables. This is done during loading.
 func (s *levelHandler) initTables(tables []*table.Table) {
 	s.Lock()
 	defer s.Unlock()
 
 	s.tables = tables
-	s.totalSize = 0
+	s.addSize(-s.totalSize)
 	for _, t := range tables {
-		s.totalSize += t.Size()
+		s.addSize(t.Size())
 	}
 
 	if s.level == 0 {
@@ -71,7 +84,7 @@ func (s *levelHandler) initTables(tables []*table.Table) {
 	} else {
 		// Sort tables by keys.
 		sort.Slice(s.tables, func(i, j int) bool {
-			return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+			return s.compareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 		})
 	}
 }
@@ -93,7 +106,7 @@ func (s *levelHandler) deleteTables(toDel []*table.Table) error {
 			newTables = append(newTables, t)
 			continue
 		}
-		s.totalSize -= t.Size()
+		s.addSize(-t.Size())
 	}
 	s.tables = newTables
 
@@ -121,12 +134,12 @@ func (s *levelHandler) replaceTables(toDel, toAdd []*table.Table) error {
 			newTables = append(newTables, t)
 			continue
 		}
-		s.totalSize -= t.Size()
+		s.addSize(-t.Size())
 	}
 
 	// Increase totalSize first.
 	for _, t := range toAdd {
-		s.totalSize += t.Size()
+		s.addSize(t.Size())
 		t.IncrRef()
 		newTables = append(newTables, t)
 	}
@@ -134,7 +147,7 @@ func (s *levelHandler) replaceTables(toDel, toAdd []*table.Table) error {
 	// Assign tables.
 	s.tables = newTables
 	sort.Slice(s.tables, func(i, j int) bool {
-		return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+		return s.compareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 	})
 	s.Unlock() // s.Unlock before we DecrRef tables -- that can be slow.
 	return decrRefs(toDel)
@@ -149,7 +162,7 @@ func (s *levelHandler) addTable(t *table.Table) {
 	s.Lock()
 	defer s.Unlock()
 
-	s.totalSize += t.Size() // Increase totalSize first.
+	s.addSize(t.Size()) // Increase totalSize first.
 	t.IncrRef()
 	s.tables = append(s.tables, t)
 }
@@ -161,7 +174,7 @@ func (s *levelHandler) sortTables() {
 	defer s.RUnlock()
 
 	sort.Slice(s.tables, func(i, j int) bool {
-		return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+		return s.compareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 	})
 }
 
@@ -194,7 +207,7 @@ func (s *levelHandler) tryAddLevel0Table(t *table.Table) bool {
 
 	s.tables = append(s.tables, t)
 	t.IncrRef()
-	s.totalSize += t.Size()
+	s.addSize(t.Size())
 
 	return true
 }
@@ -242,7 +255,7 @@ func (s *levelHandler) getTableForKey(key []byte) ([]*table.Table, func() error)
 	}
 	// For level >= 1, we can do a binary search as key range does not overlap.
 	idx := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(s.tables[i].Biggest(), key) >= 0
+		return s.compareKeys(s.tables[i].Biggest(), key) >= 0
 	})
 	if idx >= len(s.tables) {
 		// Given key is strictly > than every element we have.
@@ -262,14 +275,14 @@ func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
 	var maxVs y.ValueStruct
 	for _, th := range tables {
 		if th.DoesNotHave(hash) {
-			y.NumLSMBloomHits.Add(s.strLevel, 1)
+			s.db.opt.counters.AddLSMBloomHit(s.level, s.strLevel)
 			continue
 		}
 
 		it := th.NewIterator(false)
 		defer it.Close()
 
-		y.NumLSMGets.Add(s.strLevel, 1)
+		s.db.opt.counters.AddLSMGet(s.level, s.strLevel)
 		it.Seek(key)
 		if !it.Valid() {
 			continue
@@ -320,10 +333,10 @@ func (s *levelHandler) overlappingTables(_ levelHandlerRLocked, kr keyRange) (in
 		return 0, 0
 	}
 	left := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(kr.left, s.tables[i].Biggest()) <= 0
+		return s.compareKeys(kr.left, s.tables[i].Biggest()) <= 0
 	})
 	right := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(kr.right, s.tables[i].Smallest()) < 0
+		return s.compareKeys(kr.right, s.tables[i].Smallest()) < 0
 	})
 	return left, right
 }
@@ -44,6 +44,8 @@ type levelsController struct {
 	kv     *DB
 
 	cstatus compactStatus
+
+	stalled int32 // Atomic. 1 while addLevel0Table waits for level 0 to get compacted.
 }
 
 var (
@@ -84,6 +86,7 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 		levels: make([]*levelHandler, db.opt.MaxLevels),
 	}
 	s.cstatus.levels = make([]*levelCompactStatus, db.opt.MaxLevels)
+	s.cstatus.cmp = db.opt.Comparator
 
 	for i := 0; i < db.opt.MaxLevels; i++ {
 		s.levels[i] = newLevelHandler(db, i)
@@ -161,6 +164,7 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 			// Set compression from table manifest.
 			topt.Compression = tf.Compression
 			topt.DataKey = dk
+			topt.EncryptValuesOnly = tf.ValuesOnly
 			topt.Cache = db.blockCache
 			t, err := table.OpenTable(fd, topt)
 			if err != nil {
@@ -228,9 +232,9 @@ func (s *levelsController) cleanupLevels() error {
 }
 
 // dropTree picks all tables from all levels, creates a manifest changeset,
-// applies it, and then decrements the refs of these tables, which would result
-// in their deletion.
-func (s *levelsController) dropTree() (int, error) {
+// applies it, and then removes the tables from the levels. It returns them, so
+// that the caller decrements their refs, which would result in their deletion.
+func (s *levelsController) dropTree() ([]*table.Table, error) {
 	// First pick all tables, so we can create a manifest changelog.
 	var all []*table.Table
 	for _, l := range s.levels {
@@ -239,7 +243,7 @@ func (s *levelsController) dropTree() (int, error) {
 		l.RUnlock()
 	}
 	if len(all) == 0 {
-		return 0, nil
+		return nil, nil
 	}
 
 	// Generate the manifest changes.
@@ -252,22 +256,25 @@ func (s *levelsController) dropTree() (int, error) {
 	}
 	changeSet := pb.ManifestChangeSet{Changes: changes}
 	if err := s.kv.manifest.addChanges(changeSet.Changes); err != nil {
-		return 0, err
+		return nil, err
 	}
+	var removed []tableChange
+	for _, l := range s.levels {
+		l.RLock()
+		removed = append(removed, tableChanges(l.level, false, l.tables...)...)
+		l.RUnlock()
+	}
+	s.kv.tableEvents.send(removed)
 
-	// Now that manifest has been successfully written, we can delete the tables.
+	// Now that manifest has been successfully written, we can remove the tables. They get deleted
+	// once the caller decrements their references.
 	for _, l := range s.levels {
 		l.Lock()
-		l.totalSize = 0
+		l.addSize(-l.totalSize)
 		l.tables = l.tables[:0]
 		l.Unlock()
 	}
-	for _, table := range all {
-		if err := table.DecrRef(); err != nil {
-			return 0, err
-		}
-	}
-	return len(all), nil
+	return all, nil
 }
 
 // dropPrefix runs a L0->L1 compaction, and then runs same level compaction on the rest of the
@@ -304,6 +311,8 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 		for _, table := range l.tables {
 			var absent bool
 			switch {
+			case s.kv.opt.Comparator != nil:
+				// Key ranges of tables say nothing about prefixes under a custom order.
 			case bytes.HasPrefix(table.Smallest(), prefix):
 			case bytes.HasPrefix(table.Biggest(), prefix):
 			case bytes.Compare(prefix, table.Smallest()) > 0 &&
@@ -321,7 +330,7 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 		}
 
 		cd := compactDef{
-			elog:       trace.New(fmt.Sprintf("Badger.L%d", l.level), "Compact"),
+			elog:       trace.New(fmt.Sprintf("Badger.L%d", l.level), s.kv.opt.traceTitle("Compact")),
 			thisLevel:  l,
 			nextLevel:  l,
 			top:        []*table.Table{},
@@ -338,6 +347,9 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 
 func (s *levelsController) startCompact(lc *y.Closer) {
 	n := s.kv.opt.NumCompactors
+	if s.kv.opt.ManualCompactions {
+		n = 0
+	}
 	lc.AddRunning(n - 1)
 	for i := 0; i < n; i++ {
 		go s.runWorker(lc)
@@ -346,6 +358,7 @@ func (s *levelsController) startCompact(lc *y.Closer) {
 
 func (s *levelsController) runWorker(lc *y.Closer) {
 	defer lc.Done()
+	s.kv.compactionCPU.lowerPriority()
 
 	randomDelay := time.NewTimer(time.Duration(rand.Int31n(1000)) * time.Millisecond)
 	select {
@@ -362,6 +375,9 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 		select {
 		// Can add a done channel or other stuff.
 		case <-ticker.C:
+			if !s.kv.inMaintenanceWindow() && !s.underWritePressure() {
+				continue
+			}
 			prios := s.pickCompactLevels()
 			for _, p := range prios {
 				if err := s.doCompact(p); err == nil {
@@ -378,6 +394,20 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 	}
 }
 
+// compactOnce runs the compaction of the highest priority, as the compactors do. It returns false
+// if no level needs to be compacted.
+func (s *levelsController) compactOnce() (bool, error) {
+	for _, p := range s.pickCompactLevels() {
+		err := s.doCompact(p)
+		if err == nil {
+			return true, nil
+		} else if err != errFillTables {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
 // Returns true if level zero may be compacted, without accounting for compactions that already
 // might be happening.
 func (s *levelsController) isLevel0Compactable() bool {
@@ -490,7 +520,7 @@ func (s *levelsController) compactBuildTables(
 		valid = append(valid, table)
 	}
 	iters = append(iters, table.NewConcatIterator(valid, false))
-	it := table.NewMergeIterator(iters, false)
+	it := table.NewMergeIteratorWithComparator(iters, false, s.kv.opt.Comparator)
 	defer it.Close() // Important to close the iterator to do ref counting.
 
 	it.Rewind()
@@ -498,7 +528,7 @@ func (s *levelsController) compactBuildTables(
 	// Pick a discard ts, so we can discard versions below this ts. We should
 	// never discard any versions starting from above this timestamp, because
 	// that would affect the snapshot view guarantee provided by transactions.
-	discardTs := s.kv.orc.discardAtOrBelow()
+	discardTs := s.kv.discardAtOrBelow()
 
 	// Start generating new tables.
 	type newTableResult struct {
@@ -508,10 +538,25 @@ func (s *levelsController) compactBuildTables(
 	resultCh := make(chan newTableResult)
 	var numBuilds, numVersions int
 	var lastKey, skipKey []byte
+	var keepUndeletable bool
 	var vp valuePointer
+	vc := versionCounter{vt: s.kv.versions}
+	defer vc.done()
+	rc := retentionCursor{vr: s.kv.retention}
+	// Merging the tables takes a CPU of the compaction budget, and so does every table build.
+	cpu := s.kv.compactionCPU
+	cpu.acquire()
+	holdsCPU := true
+	defer func() {
+		if holdsCPU {
+			cpu.release()
+		}
+	}()
 	for it.Valid() {
 		timeStart := time.Now()
-		dk, err := s.kv.registry.latestDataKey()
+		// Keys of a prefix having its own data keys get tables of their own.
+		scope := s.kv.registry.keyScope(y.ParseKey(it.Key()))
+		dk, err := s.kv.registry.latestDataKey(scope)
 		if err != nil {
 			return nil, nil,
 				y.Wrapf(err, "Error while retrieving datakey in levelsController.compactBuildTables")
@@ -521,8 +566,13 @@ func (s *levelsController) compactBuildTables(
 		// Builder does not need cache but the same options are used for opening table.
 		bopts.Cache = s.kv.blockCache
 		builder := table.NewTableBuilder(bopts)
+		var digest *tableDigestBuilder
+		if s.kv.opt.RangeDigests {
+			digest = &tableDigestBuilder{db: s.kv, inline: true}
+		}
 		var numKeys, numSkips uint64
 		for ; it.Valid(); it.Next() {
+			vc.add(it.Key())
 			// See if we need to skip the prefix.
 			if len(cd.dropPrefix) > 0 && bytes.HasPrefix(it.Key(), cd.dropPrefix) {
 				numSkips++
@@ -542,18 +592,27 @@ func (s *levelsController) compactBuildTables(
 			}
 
 			if !y.SameKey(it.Key(), lastKey) {
-				if builder.ReachedCapacity(s.kv.opt.MaxTableSize) {
-					// Only break if we are on a different key, and have reached capacity. We want
-					// to ensure that all versions of the key are stored in the same sstable, and
-					// not divided across multiple tables at the same level.
+				if builder.ReachedCapacity(s.kv.opt.MaxTableSize) ||
+					!bytes.Equal(s.kv.registry.keyScope(y.ParseKey(it.Key())), scope) {
+					// Only break if we are on a different key, and have reached capacity or
+					// another key scope. We want to ensure that all versions of the key are
+					// stored in the same sstable, and not divided across multiple tables at the
+					// same level.
 					break
 				}
 				lastKey = y.SafeCopy(lastKey, it.Key())
 				numVersions = 0
+				rc.nextKey(y.ParseKey(lastKey))
+				keepUndeletable = false
 			}
 
 			vs := it.Value()
 			version := y.ParseTs(it.Key())
+			retained := rc.keep(version) || keepUndeletable
+			// A soft delete marker has to be kept along with the version it deleted, until the
+			// version can't be undeleted anymore.
+			keepUndeletable = isSoftDeleted(vs.Meta, vs.ExpiresAt)
+			retained = retained || keepUndeletable
 			// Do not discard entries inserted by merge operator. These entries will be
 			// discarded once they're merged
 			if version <= discardTs && vs.Meta&bitMergeEntry == 0 {
@@ -562,9 +621,10 @@ func (s *levelsController) compactBuildTables(
 				// only valid version for a running transaction.
 				numVersions++
 				lastValidVersion := vs.Meta&bitDiscardEarlierVersions > 0
-				if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) ||
-					numVersions > s.kv.opt.NumVersionsToKeep ||
-					lastValidVersion {
+				// Versions kept by the retention policy of the key are kept whatever they are.
+				if !retained && (isDeletedOrExpired(vs.Meta, vs.ExpiresAt) ||
+					numVersions > rc.numVersionsToKeep(&s.kv.opt) ||
+					lastValidVersion) {
 					// If this version of the key is deleted or expired, skip all the rest of the
 					// versions. Ensure that we're only removing versions below readTs.
 					skipKey = y.SafeCopy(skipKey, it.Key())
@@ -589,6 +649,10 @@ func (s *levelsController) compactBuildTables(
 				vp.Decode(vs.Value)
 			}
 			builder.Add(it.Key(), vs, vp.Len)
+			if digest != nil && digest.add(it.Key(), vs) != nil {
+				// DB.RangeDigest computes the digest of the table when it needs it.
+				digest = nil
+			}
 		}
 		// It was true that it.Valid() at least once in the loop above, which means we
 		// called Add() at least once, and builder is not Empty().
@@ -600,20 +664,32 @@ func (s *levelsController) compactBuildTables(
 				return nil, errors.Wrapf(err, "While opening new table: %d", fileID)
 			}
 
-			if _, err := fd.Write(builder.Finish()); err != nil {
+			data := builder.Finish()
+			s.kv.compactionWrites.wait(len(data))
+			if _, err := fd.Write(data); err != nil {
 				return nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)
 			}
 			tbl, err := table.OpenTable(fd, bopts)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Unable to open table: %q", fd.Name())
+			}
 			// decrRef is added below.
-			return tbl, errors.Wrapf(err, "Unable to open table: %q", fd.Name())
+			tbl.Digest = s.kv.tableDigest(data)
+			return tbl, nil
 		}
 		if builder.Empty() {
 			continue
 		}
 		numBuilds++
 		fileID := s.reserveFileID()
+		if digest != nil {
+			s.kv.rangeDigests.set(fileID, digest.d)
+		}
 		go func(builder *table.Builder) {
 			defer builder.Close()
+			cpu.lowerPriority()
+			cpu.acquire()
+			defer cpu.release()
 			var (
 				tbl *table.Table
 				err error
@@ -627,6 +703,10 @@ func (s *levelsController) compactBuildTables(
 		}(builder)
 	}
 
+	// Let the table builds have the CPU while waiting for them.
+	cpu.release()
+	holdsCPU = false
+
 	newTables := make([]*table.Table, 0, 20)
 	// Wait for all table builders to finish.
 	var firstErr error
@@ -658,7 +738,7 @@ func (s *levelsController) compactBuildTables(
 	}
 
 	sort.Slice(newTables, func(i, j int) bool {
-		return y.CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
+		return newTables[i].CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
 	})
 	s.kv.vlog.updateDiscardStats(discardStats)
 	s.kv.opt.Debugf("Discard stats: %v", discardStats)
@@ -668,8 +748,7 @@ func (s *levelsController) compactBuildTables(
 func buildChangeSet(cd *compactDef, newTables []*table.Table) pb.ManifestChangeSet {
 	changes := []*pb.ManifestChange{}
 	for _, table := range newTables {
-		changes = append(changes,
-			newCreateChange(table.ID(), cd.nextLevel.level, table.KeyID(), table.CompressionType()))
+		changes = append(changes, newTableCreateChange(table, cd.nextLevel.level))
 	}
 	for _, table := range cd.top {
 		// Add a delete change only if the table is not in memory.
@@ -807,8 +886,19 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 	return false
 }
 
-func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
+func (s *levelsController) runCompactDef(l int, cd compactDef) error {
+	return s.kv.jobs.run(JobCompaction, func(job *BackgroundJob) error {
+		return s.compact(l, cd, job)
+	})
+}
+
+// compact runs the compaction cd of level l, filling in job.
+func (s *levelsController) compact(l int, cd compactDef, job *BackgroundJob) (err error) {
 	timeStart := time.Now()
+	job.Detail = fmt.Sprintf("L%d->L%d", l, cd.nextLevel.level)
+	for _, t := range append(cd.top[:len(cd.top):len(cd.top)], cd.bot...) {
+		job.BytesRead += t.Size()
+	}
 
 	thisLevel := cd.thisLevel
 	nextLevel := cd.nextLevel
@@ -826,12 +916,18 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 			err = decErr
 		}
 	}()
+	for _, t := range newTables {
+		job.BytesWritten += t.Size()
+	}
 	changeSet := buildChangeSet(&cd, newTables)
 
 	// We write to the manifest _before_ we delete files (and after we created files)
 	if err := s.kv.manifest.addChanges(changeSet.Changes); err != nil {
 		return err
 	}
+	events := tableChanges(thisLevel.level, false, cd.top...)
+	events = append(events, tableChanges(nextLevel.level, false, cd.bot...)...)
+	s.kv.tableEvents.send(append(events, tableChanges(nextLevel.level, true, newTables...)...))
 
 	// See comment earlier in this function about the ordering of these ops, and the order in which
 	// we access levels when reading.
@@ -848,6 +944,9 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 	s.kv.opt.Infof("LOG Compact %d->%d, del %d tables, add %d tables, took %v\n",
 		thisLevel.level, nextLevel.level, len(cd.top)+len(cd.bot),
 		len(newTables), time.Since(timeStart))
+	s.kv.ops.record(opCompact, "L%d->L%d done, del %d tables, add %d tables, took %v",
+		thisLevel.level, nextLevel.level, len(cd.top)+len(cd.bot),
+		len(newTables), time.Since(timeStart))
 	return nil
 }
 
@@ -859,7 +958,7 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 	y.AssertTrue(l+1 < s.kv.opt.MaxLevels) // Sanity check.
 
 	cd := compactDef{
-		elog:       trace.New(fmt.Sprintf("Badger.L%d", l), "Compact"),
+		elog:       trace.New(fmt.Sprintf("Badger.L%d", l), s.kv.opt.traceTitle("Compact")),
 		thisLevel:  s.levels[l],
 		nextLevel:  s.levels[l+1],
 		dropPrefix: p.dropPrefix,
@@ -884,15 +983,19 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 	defer s.cstatus.delete(cd) // Remove the ranges from compaction status.
 
 	s.kv.opt.Infof("Running for level: %d\n", cd.thisLevel.level)
+	s.kv.ops.record(opCompact, "picked L%d->L%d, score %.2f, %d+%d tables",
+		l, l+1, p.score, len(cd.top), len(cd.bot))
 	s.cstatus.toLog(cd.elog)
 	if err := s.runCompactDef(l, cd); err != nil {
 		// This compaction couldn't be done successfully.
 		s.kv.opt.Warningf("LOG Compact FAILED with error: %+v: %+v", err, cd)
+		s.kv.ops.record(opCompact, "L%d->L%d failed: %v", l, l+1, err)
 		return err
 	}
 
 	s.cstatus.toLog(cd.elog)
 	s.kv.opt.Infof("Compaction for level: %d DONE", cd.thisLevel.level)
+	s.kv.dropUnusedDataKeys()
 	return nil
 }
 
@@ -904,17 +1007,17 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 		// point it could get used in some compaction.  This ensures the manifest file gets updated in
 		// the proper order. (That means this update happens before that of some compaction which
 		// deletes the table.)
-		err := s.kv.manifest.addChanges([]*pb.ManifestChange{
-			newCreateChange(t.ID(), 0, t.KeyID(), t.CompressionType()),
-		})
+		err := s.kv.manifest.addChanges([]*pb.ManifestChange{newTableCreateChange(t, 0)})
 		if err != nil {
 			return err
 		}
 	}
+	s.kv.tableEvents.send(tableChanges(0, true, t))
 
 	for !s.levels[0].tryAddLevel0Table(t) {
 		// Stall. Make sure all levels are healthy before we unstall.
 		var timeStart time.Time
+		atomic.StoreInt32(&s.stalled, 1)
 		{
 			s.elog.Printf("STALLED STALLED STALLED: %v\n", time.Since(lastUnstalled))
 			s.cstatus.RLock()
@@ -924,6 +1027,7 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 			}
 			s.cstatus.RUnlock()
 			timeStart = time.Now()
+			s.kv.ops.record(opStall, "writes stalled, %d tables in L0", s.levels[0].numTables())
 		}
 		// Before we unstall, we need to make sure that level 0 and 1 are healthy. Otherwise, we
 		// will very quickly fill up level 0 again and if the compaction strategy favors level 0,
@@ -936,6 +1040,14 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 			if !s.isLevel0Compactable() && !s.levels[1].isCompactable(0) {
 				break
 			}
+			if s.kv.opt.ManualCompactions {
+				// There are no compactors to wait for, compact right away instead.
+				if ok, err := s.compactOnce(); ok {
+					continue
+				} else if err != nil {
+					s.kv.opt.Warningf("While compacting to unstall: %v\n", err)
+				}
+			}
 			time.Sleep(10 * time.Millisecond)
 			if i%100 == 0 {
 				prios := s.pickCompactLevels()
@@ -943,8 +1055,10 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 				i = 0
 			}
 		}
+		atomic.StoreInt32(&s.stalled, 0)
 		{
 			s.elog.Printf("UNSTALLED UNSTALLED UNSTALLED: %v\n", time.Since(timeStart))
+			s.kv.ops.record(opStall, "writes resumed after %v", time.Since(timeStart))
 			lastUnstalled = time.Now()
 		}
 	}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManualCompactions(t *testing.T) {
+	// run writes the same data in a new DB, compacting it step by step, and returns the tables
+	// after every step.
+	run := func() [][]TableInfo {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		opt := getTestOptions(dir).WithManualCompactions(true).WithKeepL0InMemory(false).
+			WithNumLevelZeroTables(2).WithNumLevelZeroTablesStall(4)
+		db, err := Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+
+		var states [][]TableInfo
+		for i := 0; i < 6; i++ {
+			for j := 0; j < 100; j++ {
+				txnSet(t, db, []byte(fmt.Sprintf("key%03d", (i*37+j)%300)), []byte("v"), 0)
+			}
+			// Flushes which would stall get compacted rather than waiting.
+			require.NoError(t, db.FlushMemtable(context.Background()))
+		}
+		// The compactors don't run.
+		time.Sleep(1500 * time.Millisecond)
+		states = append(states, db.Tables(false))
+		require.True(t, db.lc.levels[0].numTables() >= 2)
+
+		for {
+			compacted, err := db.CompactOnce()
+			require.NoError(t, err)
+			if !compacted {
+				break
+			}
+			states = append(states, db.Tables(false))
+		}
+		require.True(t, len(states) > 1)
+		require.True(t, db.lc.levels[0].numTables() < 2)
+		return states
+	}
+	require.Equal(t, run(), run())
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaintenanceWindow is a daily time range in which compactions, Flatten and value log GC may run.
+type MaintenanceWindow struct {
+	// Start and End are offsets from midnight, local time, in [0, 24h). A window ending before it
+	// starts spans midnight, and a window ending when it starts lasts the whole day.
+	Start time.Duration
+	End   time.Duration
+	// Days restricts the window to the given days of the week, those it starts on. Empty means
+	// every day.
+	Days []time.Weekday
+}
+
+func (w MaintenanceWindow) validate() error {
+	if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End >= 24*time.Hour {
+		return errors.Errorf("Invalid MaintenanceWindow %v-%v, offsets must be in [0, 24h)",
+			w.Start, w.End)
+	}
+	return nil
+}
+
+func (w MaintenanceWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if t falls within the window.
+func (w MaintenanceWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	day := t.Weekday()
+	switch {
+	case w.Start == w.End:
+		return w.onDay(day)
+	case w.Start < w.End:
+		return w.Start <= offset && offset < w.End && w.onDay(day)
+	default:
+		// The window spans midnight. Before End, it started the day before.
+		if offset >= w.Start {
+			return w.onDay(day)
+		}
+		return offset < w.End && w.onDay((day+6)%7)
+	}
+}
+
+// inMaintenanceWindow returns true if heavy background work may run now. That's always the case
+// without windows.
+func (db *DB) inMaintenanceWindow() bool {
+	if len(db.opt.MaintenanceWindows) == 0 {
+		return true
+	}
+	now := time.Now()
+	for _, w := range db.opt.MaintenanceWindows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// underWritePressure returns true if writes are stalled, or a flush away from being stalled, in
+// which case compactions run regardless of maintenance windows.
+func (s *levelsController) underWritePressure() bool {
+	return atomic.LoadInt32(&s.stalled) == 1 ||
+		s.levels[0].numTables() >= s.kv.opt.NumLevelZeroTablesStall-1
+}
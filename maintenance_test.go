@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2020-03-02 is a Monday.
+	at := func(day, hour int) time.Time { return time.Date(2020, 3, day, hour, 30, 0, 0, time.UTC) }
+	night := MaintenanceWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	require.True(t, night.contains(at(2, 23)))
+	require.True(t, night.contains(at(3, 5)))
+	require.False(t, night.contains(at(3, 6)))
+	require.False(t, night.contains(at(3, 12)))
+
+	// Sunday night only.
+	sunday := MaintenanceWindow{Start: 22 * time.Hour, End: 6 * time.Hour,
+		Days: []time.Weekday{time.Sunday}}
+	require.True(t, sunday.contains(at(1, 23)))
+	require.True(t, sunday.contains(at(2, 1)))
+	require.False(t, sunday.contains(at(2, 23)))
+	require.False(t, sunday.contains(at(1, 1)))
+
+	lunch := MaintenanceWindow{Start: 12 * time.Hour, End: 14 * time.Hour}
+	require.True(t, lunch.contains(at(4, 13)))
+	require.False(t, lunch.contains(at(4, 14)))
+
+	allDay := MaintenanceWindow{Days: []time.Weekday{time.Saturday}}
+	require.True(t, allDay.contains(at(7, 0)))
+	require.False(t, allDay.contains(at(8, 0)))
+
+	require.Error(t, MaintenanceWindow{Start: 24 * time.Hour}.validate())
+}
+
+func TestMaintenanceWindowsBlockWork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// A one minute window half a day away from now.
+	now := time.Now()
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	start := (offset + 12*time.Hour) % (24 * time.Hour)
+	window := MaintenanceWindow{Start: start, End: (start + time.Minute) % (24 * time.Hour)}
+	opt := getTestOptions(dir).WithMaintenanceWindows([]MaintenanceWindow{window})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	require.Equal(t, ErrOutsideMaintenanceWindow, db.RunValueLogGC(0.5))
+	require.Equal(t, ErrOutsideMaintenanceWindow, db.Flatten(1))
+
+	db.opt.MaintenanceWindows = nil
+	require.NotEqual(t, ErrOutsideMaintenanceWindow, db.RunValueLogGC(0.5))
+	require.NoError(t, db.Flatten(1))
+}
@@ -1,6 +1,7 @@
 package badger
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -179,6 +180,71 @@ func TestDropAllTwice(t *testing.T) {
 	})
 }
 
+func TestDropAllCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opts := getTestOptions(dir)
+	opts.ValueLogFileSize = 5 << 20
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	populate := func(db *DB, n int) {
+		writer := db.NewWriteBatch()
+		for i := 0; i < n; i++ {
+			require.NoError(t, writer.Set([]byte(key("key", i)), val(true)))
+		}
+		require.NoError(t, writer.Flush())
+	}
+	// cancelAt returns a progress function canceling ctx once done returns true.
+	cancelAt := func(done func(p DropAllProgress) bool) (context.Context, func(DropAllProgress)) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, func(p DropAllProgress) {
+			if done(p) {
+				cancel()
+			}
+		}
+	}
+
+	populate(db, 10000)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	ctx, progress := cancelAt(func(p DropAllProgress) bool { return p.TablesDeleted == 1 })
+	require.Equal(t, context.Canceled, db.DropAllWithProgress(ctx, progress))
+	require.Equal(t, 0, numKeys(db))
+	require.NotEmpty(t, db.dropPending)
+	require.NoError(t, db.Close())
+
+	// The value log files left aren't replayed.
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, numKeys(db))
+	populate(db, 100)
+
+	ctx, progress = cancelAt(func(p DropAllProgress) bool { return p.ValueLogFilesDeleted == 1 })
+	require.Equal(t, context.Canceled, db.DropAllWithProgress(ctx, progress))
+	require.Equal(t, 0, numKeys(db))
+	populate(db, 10)
+	require.Equal(t, 10, numKeys(db))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, 10, numKeys(db))
+	// Calling DropAll again deletes the files left.
+	var last DropAllProgress
+	require.NoError(t, db.DropAllWithProgress(context.Background(), func(p DropAllProgress) {
+		last = p
+	}))
+	require.Greater(t, last.ValueLogFilesTotal, 1)
+	require.Equal(t, last.ValueLogFilesTotal, last.ValueLogFilesDeleted)
+	require.Equal(t, last.TablesTotal, last.TablesDeleted)
+	require.Equal(t, 0, numKeys(db))
+	db.vlog.filesLock.RLock()
+	require.Len(t, db.vlog.filesMap, 1)
+	db.vlog.filesLock.RUnlock()
+	require.NoError(t, db.Close())
+}
+
 func TestDropAllWithPendingTxn(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
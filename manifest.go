@@ -19,6 +19,7 @@ package badger
 import (
 	"bufio"
 	"bytes"
+	"crypto/aes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -45,6 +47,9 @@ import (
 type Manifest struct {
 	Levels []levelManifest
 	Tables map[uint64]TableManifest
+	// VlogDigests holds the SHA-256 digests of the value log files, by file ID. Only the files
+	// written with Options.FileDigests set have one.
+	VlogDigests map[uint32][]byte
 
 	// Contains total number of creation and deletion changes in the manifest -- used to compute
 	// whether it'd be useful to rewrite the manifest.
@@ -55,8 +60,9 @@ type Manifest struct {
 func createManifest() Manifest {
 	levels := make([]levelManifest, 0)
 	return Manifest{
-		Levels: levels,
-		Tables: make(map[uint64]TableManifest),
+		Levels:      levels,
+		Tables:      make(map[uint64]TableManifest),
+		VlogDigests: make(map[uint32][]byte),
 	}
 }
 
@@ -72,6 +78,8 @@ type TableManifest struct {
 	Level       uint8
 	KeyID       uint64
 	Compression options.CompressionType
+	SHA256      []byte // Digest of the file. Nil unless it was written with Options.FileDigests.
+	ValuesOnly  bool   // Only the values are encrypted, see Options.EncryptValuesOnly.
 }
 
 // manifestFile holds the file pointer (and other info) about the manifest file, which is a log
@@ -90,6 +98,14 @@ type manifestFile struct {
 
 	// Used to indicate if badger was opened in InMemory mode.
 	inMemory bool
+
+	// registry gets the data keys of the tables created kept, see KeyRegistry.keep. It's nil
+	// if the MANIFEST is opened without the key registry, which is then never encrypted.
+	registry *KeyRegistry
+
+	// dataKey encrypts the change sets appended to the file, nil if it's in plain text. It's the
+	// latest data key as of the last time the file got created or rewritten.
+	dataKey *pb.DataKey
 }
 
 const (
@@ -103,9 +119,15 @@ const (
 // asChanges returns a sequence of changes that could be used to recreate the Manifest in its
 // present state.
 func (m *Manifest) asChanges() []*pb.ManifestChange {
-	changes := make([]*pb.ManifestChange, 0, len(m.Tables))
+	changes := make([]*pb.ManifestChange, 0, len(m.Tables)+len(m.VlogDigests))
 	for id, tm := range m.Tables {
-		changes = append(changes, newCreateChange(id, int(tm.Level), tm.KeyID, tm.Compression))
+		c := newCreateChange(id, int(tm.Level), tm.KeyID, tm.Compression)
+		c.Sha256 = tm.SHA256
+		c.ValuesOnly = tm.ValuesOnly
+		changes = append(changes, c)
+	}
+	for fid, digest := range m.VlogDigests {
+		changes = append(changes, newCreateVlogChange(fid, digest))
 	}
 	return changes
 }
@@ -119,16 +141,20 @@ func (m *Manifest) clone() Manifest {
 
 // openOrCreateManifestFile opens a Badger manifest file if it exists, or creates one if
 // doesn’t exists.
-func openOrCreateManifestFile(opt Options) (
+func openOrCreateManifestFile(opt Options, kr *KeyRegistry) (
 	ret *manifestFile, result Manifest, err error) {
 	if opt.InMemory {
-		return &manifestFile{inMemory: true}, Manifest{}, nil
+		return &manifestFile{inMemory: true, registry: kr}, Manifest{}, nil
 	}
-	return helpOpenOrCreateManifestFile(opt.Dir, opt.ReadOnly, manifestDeletionsRewriteThreshold)
+	return helpOpenOrCreateManifestFile(opt.Dir, opt.ReadOnly, manifestDeletionsRewriteThreshold,
+		kr)
 }
 
-func helpOpenOrCreateManifestFile(dir string, readOnly bool, deletionsThreshold int) (
-	*manifestFile, Manifest, error) {
+// helpOpenOrCreateManifestFile opens the MANIFEST in dir, creating it if it doesn't exist. If the
+// key registry kr encrypts tables, a MANIFEST in plain text gets rewritten encrypted with the
+// latest data key.
+func helpOpenOrCreateManifestFile(dir string, readOnly bool, deletionsThreshold int,
+	kr *KeyRegistry) (*manifestFile, Manifest, error) {
 
 	path := filepath.Join(dir, ManifestFilename)
 	var flags uint32
@@ -144,21 +170,25 @@ func helpOpenOrCreateManifestFile(dir string, readOnly bool, deletionsThreshold
 			return nil, Manifest{}, fmt.Errorf("no manifest found, required for read-only db")
 		}
 		m := createManifest()
-		fp, netCreations, err := helpRewrite(dir, &m)
-		if err != nil {
-			return nil, Manifest{}, err
-		}
-		y.AssertTrue(netCreations == 0)
 		mf := &manifestFile{
-			fp:                        fp,
 			directory:                 dir,
-			manifest:                  m.clone(),
 			deletionsRewriteThreshold: deletionsThreshold,
+			registry:                  kr,
 		}
+		dk, err := mf.latestDataKey()
+		if err != nil {
+			return nil, Manifest{}, err
+		}
+		fp, netCreations, err := helpRewrite(dir, &m, dk)
+		if err != nil {
+			return nil, Manifest{}, err
+		}
+		y.AssertTrue(netCreations == 0)
+		mf.fp, mf.manifest, mf.dataKey = fp, m.clone(), dk
 		return mf, m, nil
 	}
 
-	manifest, truncOffset, err := ReplayManifestFile(fp)
+	manifest, truncOffset, dk, err := replayManifestFile(fp, kr)
 	if err != nil {
 		_ = fp.Close()
 		return nil, Manifest{}, err
@@ -181,10 +211,92 @@ func helpOpenOrCreateManifestFile(dir string, readOnly bool, deletionsThreshold
 		directory:                 dir,
 		manifest:                  manifest.clone(),
 		deletionsRewriteThreshold: deletionsThreshold,
+		registry:                  kr,
+		dataKey:                   dk,
+	}
+	if !readOnly && dk == nil {
+		// Encrypt the MANIFEST of a DB written before it got encrypted.
+		if latest, err := mf.latestDataKey(); err != nil || latest != nil {
+			if err == nil {
+				err = mf.rewrite()
+			}
+			if err != nil {
+				_ = mf.fp.Close()
+				return nil, Manifest{}, err
+			}
+		}
 	}
 	return mf, manifest, nil
 }
 
+// latestDataKey returns the data key to encrypt the MANIFEST with when it's created or
+// rewritten, nil if it isn't to be encrypted. The key is kept in the key registry file.
+func (mf *manifestFile) latestDataKey() (*pb.DataKey, error) {
+	if mf.registry == nil {
+		return nil, nil
+	}
+	dk, err := mf.registry.latestDataKey(nil)
+	if err != nil || dk == nil {
+		return nil, err
+	}
+	if err := mf.registry.audit.use(dk.KeyId, ManifestFile, 0); err != nil {
+		return nil, err
+	}
+	return dk, mf.registry.keep(dk.KeyId)
+}
+
+// keyID returns the ID of the data key the MANIFEST is encrypted with, zero if it's in plain text.
+func (mf *manifestFile) keyID() uint64 {
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	if mf.dataKey == nil {
+		return 0
+	}
+	return mf.dataKey.KeyId
+}
+
+// rekey rewrites the MANIFEST with the latest data key if keyID(the ID of its data key) holds.
+func (mf *manifestFile) rekey(keyID func(id uint64) bool) error {
+	if mf.inMemory {
+		return nil
+	}
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	var id uint64
+	if mf.dataKey != nil {
+		id = mf.dataKey.KeyId
+	}
+	if !keyID(id) {
+		return nil
+	}
+	return mf.rewrite()
+}
+
+// digests returns the digests of the tables and the value log files recorded in the MANIFEST.
+func (mf *manifestFile) digests() (map[uint64][]byte, map[uint32][]byte) {
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	tables := make(map[uint64][]byte)
+	for id, tm := range mf.manifest.Tables {
+		if tm.SHA256 != nil {
+			tables[id] = tm.SHA256
+		}
+	}
+	vlogs := make(map[uint32][]byte, len(mf.manifest.VlogDigests))
+	for fid, digest := range mf.manifest.VlogDigests {
+		vlogs[fid] = digest
+	}
+	return tables, vlogs
+}
+
+// hasVlogDigest returns whether the MANIFEST records the digest of the value log file fid.
+func (mf *manifestFile) hasVlogDigest(fid uint32) bool {
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	_, ok := mf.manifest.VlogDigests[fid]
+	return ok
+}
+
 func (mf *manifestFile) close() error {
 	if mf.inMemory {
 		return nil
@@ -196,6 +308,24 @@ func (mf *manifestFile) close() error {
 // we replay the MANIFEST file, we'll either replay all the changes or none of them.  (The truth of
 // this depends on the filesystem -- some might append garbage data if a system crash happens at
 // the wrong time.)
+// keepDataKeys makes sure the data keys of the tables created by changes are in the key registry
+// file. mf.appendLock must be held.
+func (mf *manifestFile) keepDataKeys(changes []*pb.ManifestChange) error {
+	if mf.registry == nil {
+		return nil
+	}
+	var ids []uint64
+	for _, c := range changes {
+		if c.Op == pb.ManifestChange_CREATE && c.KeyId != 0 {
+			ids = append(ids, c.KeyId)
+			if err := mf.registry.audit.use(c.KeyId, TableFile, c.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return mf.registry.keep(ids...)
+}
+
 func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
 	if mf.inMemory {
 		return nil
@@ -208,6 +338,10 @@ func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
 
 	// Maybe we could use O_APPEND instead (on certain file systems)
 	mf.appendLock.Lock()
+	if err := mf.keepDataKeys(changesParam); err != nil {
+		mf.appendLock.Unlock()
+		return err
+	}
 	if err := applyChangeSet(&mf.manifest, &changes); err != nil {
 		mf.appendLock.Unlock()
 		return err
@@ -221,6 +355,12 @@ func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
 		}
 	} else {
 		var lenCrcBuf [8]byte
+		if mf.dataKey != nil {
+			if buf, err = encryptChangeSet(buf, mf.dataKey); err != nil {
+				mf.appendLock.Unlock()
+				return err
+			}
+		}
 		binary.BigEndian.PutUint32(lenCrcBuf[0:4], uint32(len(buf)))
 		binary.BigEndian.PutUint32(lenCrcBuf[4:8], crc32.Checksum(buf, y.CastagnoliCrcTable))
 		buf = append(lenCrcBuf[:], buf...)
@@ -240,7 +380,63 @@ var magicText = [4]byte{'B', 'd', 'g', 'r'}
 // The magic version number.
 const magicVersion = 7
 
-func helpRewrite(dir string, m *Manifest) (*os.File, int, error) {
+// magicVersionEncrypted is the magic version number of the MANIFESTs encrypted with a data key,
+// whose ID follows as a big endian uint64. Their change sets are encrypted like the blocks of
+// tables, followed by their IV or nonce, and checksummed once encrypted.
+const magicVersionEncrypted = 8
+
+// encryptChangeSet encrypts a marshalled change set with dk, appending its IV or nonce.
+func encryptChangeSet(buf []byte, dk *pb.DataKey) ([]byte, error) {
+	if dk.Algo == pb.EncryptionAlgo_aes_gcm {
+		aead, err := y.NewGCM(dk.Data)
+		if err != nil {
+			return nil, y.Wrapf(err, "Error while encrypting MANIFEST change set")
+		}
+		nonce, err := y.GenerateNonce()
+		if err != nil {
+			return nil, y.Wrapf(err, "Error while generating nonce for MANIFEST change set")
+		}
+		return append(aead.Seal(nil, nonce, buf, nil), nonce...), nil
+	}
+	iv, err := y.GenerateIV()
+	if err != nil {
+		return nil, y.Wrapf(err, "Error while generating IV for MANIFEST change set")
+	}
+	data, err := y.XORBlock(buf, dk.Data, iv)
+	if err != nil {
+		return nil, y.Wrapf(err, "Error while encrypting MANIFEST change set")
+	}
+	return append(data, iv...), nil
+}
+
+// decryptChangeSet decrypts a change set encrypted by encryptChangeSet.
+func decryptChangeSet(buf []byte, dk *pb.DataKey) ([]byte, error) {
+	if dk.Algo == pb.EncryptionAlgo_aes_gcm {
+		aead, err := y.NewGCM(dk.Data)
+		if err != nil {
+			return nil, y.Wrapf(err, "Error while decrypting MANIFEST change set")
+		}
+		n := len(buf) - aead.NonceSize()
+		if n < 0 {
+			return nil, errBadChecksum
+		}
+		data, err := aead.Open(nil, buf[n:], buf[:n], nil)
+		if err != nil {
+			return nil, y.Wrapf(err, "Authentication of MANIFEST change set failed")
+		}
+		return data, nil
+	}
+	n := len(buf) - aes.BlockSize
+	if n < 0 {
+		return nil, errBadChecksum
+	}
+	data, err := y.XORBlock(buf[:n], dk.Data, buf[n:])
+	return data, y.Wrapf(err, "Error while decrypting MANIFEST change set")
+}
+
+// helpRewrite writes m to a new MANIFEST in dir, encrypted with dk unless it's nil, and returns
+// it opened for appends along with the number of tables and value log files it creates.
+func helpRewrite(dir string, m *Manifest, dk *pb.DataKey) (*os.File, int, error) {
 	rewritePath := filepath.Join(dir, manifestRewriteFilename)
 	// We explicitly sync.
 	fp, err := y.OpenTruncFile(rewritePath, false)
@@ -251,8 +447,12 @@ func helpRewrite(dir string, m *Manifest) (*os.File, int, error) {
 	buf := make([]byte, 8)
 	copy(buf[0:4], magicText[:])
 	binary.BigEndian.PutUint32(buf[4:8], magicVersion)
+	if dk != nil {
+		binary.BigEndian.PutUint32(buf[4:8], magicVersionEncrypted)
+		buf = append(buf, keyIDBytes(dk.KeyId)...)
+	}
 
-	netCreations := len(m.Tables)
+	netCreations := len(m.Tables) + len(m.VlogDigests)
 	changes := m.asChanges()
 	set := pb.ManifestChangeSet{Changes: changes}
 
@@ -261,6 +461,12 @@ func helpRewrite(dir string, m *Manifest) (*os.File, int, error) {
 		fp.Close()
 		return nil, 0, err
 	}
+	if dk != nil {
+		if changeBuf, err = encryptChangeSet(changeBuf, dk); err != nil {
+			fp.Close()
+			return nil, 0, err
+		}
+	}
 	var lenCrcBuf [8]byte
 	binary.BigEndian.PutUint32(lenCrcBuf[0:4], uint32(len(changeBuf)))
 	binary.BigEndian.PutUint32(lenCrcBuf[4:8], crc32.Checksum(changeBuf, y.CastagnoliCrcTable))
@@ -299,17 +505,22 @@ func helpRewrite(dir string, m *Manifest) (*os.File, int, error) {
 	return fp, netCreations, nil
 }
 
+// rewrite rewrites the MANIFEST, encrypted with the latest data key.
 // Must be called while appendLock is held.
 func (mf *manifestFile) rewrite() error {
+	dk, err := mf.latestDataKey()
+	if err != nil {
+		return err
+	}
 	// In Windows the files should be closed before doing a Rename.
 	if err := mf.fp.Close(); err != nil {
 		return err
 	}
-	fp, netCreations, err := helpRewrite(mf.directory, &mf.manifest)
+	fp, netCreations, err := helpRewrite(mf.directory, &mf.manifest, dk)
 	if err != nil {
 		return err
 	}
-	mf.fp = fp
+	mf.fp, mf.dataKey = fp, dk
 	mf.manifest.Creations = netCreations
 	mf.manifest.Deletions = 0
 
@@ -345,19 +556,53 @@ var (
 // Also, returns the last offset after a completely read manifest entry -- the file must be
 // truncated at that point before further appends are made (if there is a partial entry after
 // that).  In normal conditions, truncOffset is the file size.
+//
+// Encrypted manifests fail with ErrEncryptedManifest, see ReplayManifestFileWithKeys.
 func ReplayManifestFile(fp *os.File) (Manifest, int64, error) {
+	m, truncOffset, _, err := replayManifestFile(fp, nil)
+	return m, truncOffset, err
+}
+
+// ReplayManifestFileWithKeys is like ReplayManifestFile, but reads manifests encrypted with a data
+// key of kr too.
+func ReplayManifestFileWithKeys(fp *os.File, kr *KeyRegistry) (Manifest, int64, error) {
+	m, truncOffset, _, err := replayManifestFile(fp, kr)
+	return m, truncOffset, err
+}
+
+// replayManifestFile replays the manifest, and returns the data key it's encrypted with too, nil
+// if it's in plain text.
+func replayManifestFile(fp *os.File, kr *KeyRegistry) (Manifest, int64, *pb.DataKey, error) {
 	r := countingReader{wrapped: bufio.NewReader(fp)}
 
 	var magicBuf [8]byte
 	if _, err := io.ReadFull(&r, magicBuf[:]); err != nil {
-		return Manifest{}, 0, errBadMagic
+		return Manifest{}, 0, nil, errBadMagic
 	}
 	if !bytes.Equal(magicBuf[0:4], magicText[:]) {
-		return Manifest{}, 0, errBadMagic
+		return Manifest{}, 0, nil, errBadMagic
 	}
 	version := y.BytesToU32(magicBuf[4:8])
-	if version != magicVersion {
-		return Manifest{}, 0,
+	var dk *pb.DataKey
+	if version == magicVersionEncrypted {
+		var idBuf [8]byte
+		if _, err := io.ReadFull(&r, idBuf[:]); err != nil {
+			return Manifest{}, 0, nil, errBadMagic
+		}
+		id := binary.BigEndian.Uint64(idBuf[:])
+		if kr == nil {
+			return Manifest{}, 0, nil, y.Wrapf(ErrEncryptedManifest, "data key %d", id)
+		}
+		var err error
+		if dk, err = kr.dataKey(id); err != nil {
+			return Manifest{}, 0, nil,
+				y.Wrapf(err, "while getting data key %d of the manifest", id)
+		}
+		if dk == nil {
+			return Manifest{}, 0, nil, errBadMagic
+		}
+	} else if version != magicVersion {
+		return Manifest{}, 0, nil,
 			//nolint:lll
 			fmt.Errorf("manifest has unsupported version: %d (we support %d).\n"+
 				"Please see https://github.com/dgraph-io/badger/blob/master/README.md#i-see-manifest-has-unsupported-version-x-we-support-y-error"+
@@ -367,7 +612,7 @@ func ReplayManifestFile(fp *os.File) (Manifest, int64, error) {
 
 	stat, err := fp.Stat()
 	if err != nil {
-		return Manifest{}, 0, err
+		return Manifest{}, 0, nil, err
 	}
 
 	build := createManifest()
@@ -380,12 +625,12 @@ func ReplayManifestFile(fp *os.File) (Manifest, int64, error) {
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				break
 			}
-			return Manifest{}, 0, err
+			return Manifest{}, 0, nil, err
 		}
 		length := y.BytesToU32(lenCrcBuf[0:4])
 		// Sanity check to ensure we don't over-allocate memory.
 		if length > uint32(stat.Size()) {
-			return Manifest{}, 0, errors.Errorf(
+			return Manifest{}, 0, nil, errors.Errorf(
 				"Buffer length: %d greater than file size: %d. Manifest file might be corrupted",
 				length, stat.Size())
 		}
@@ -394,23 +639,28 @@ func ReplayManifestFile(fp *os.File) (Manifest, int64, error) {
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				break
 			}
-			return Manifest{}, 0, err
+			return Manifest{}, 0, nil, err
 		}
 		if crc32.Checksum(buf, y.CastagnoliCrcTable) != y.BytesToU32(lenCrcBuf[4:8]) {
-			return Manifest{}, 0, errBadChecksum
+			return Manifest{}, 0, nil, errBadChecksum
+		}
+		if dk != nil {
+			if buf, err = decryptChangeSet(buf, dk); err != nil {
+				return Manifest{}, 0, nil, err
+			}
 		}
 
 		var changeSet pb.ManifestChangeSet
 		if err := proto.Unmarshal(buf, &changeSet); err != nil {
-			return Manifest{}, 0, err
+			return Manifest{}, 0, nil, err
 		}
 
 		if err := applyChangeSet(&build, &changeSet); err != nil {
-			return Manifest{}, 0, err
+			return Manifest{}, 0, nil, err
 		}
 	}
 
-	return build, offset, nil
+	return build, offset, dk, nil
 }
 
 func applyManifestChange(build *Manifest, tc *pb.ManifestChange) error {
@@ -423,6 +673,8 @@ func applyManifestChange(build *Manifest, tc *pb.ManifestChange) error {
 			Level:       uint8(tc.Level),
 			KeyID:       tc.KeyId,
 			Compression: options.CompressionType(tc.Compression),
+			SHA256:      tc.Sha256,
+			ValuesOnly:  tc.ValuesOnly,
 		}
 		for len(build.Levels) <= int(tc.Level) {
 			build.Levels = append(build.Levels, levelManifest{make(map[uint64]struct{})})
@@ -437,6 +689,21 @@ func applyManifestChange(build *Manifest, tc *pb.ManifestChange) error {
 		delete(build.Levels[tm.Level].Tables, tc.Id)
 		delete(build.Tables, tc.Id)
 		build.Deletions++
+	case pb.ManifestChange_CREATE_VLOG:
+		if _, ok := build.VlogDigests[uint32(tc.Id)]; ok {
+			return fmt.Errorf("MANIFEST invalid, value log file %d exists", tc.Id)
+		}
+		if build.VlogDigests == nil {
+			build.VlogDigests = make(map[uint32][]byte)
+		}
+		build.VlogDigests[uint32(tc.Id)] = tc.Sha256
+		build.Creations++
+	case pb.ManifestChange_DELETE_VLOG:
+		if _, ok := build.VlogDigests[uint32(tc.Id)]; !ok {
+			return fmt.Errorf("MANIFEST removes non-existing value log file %d", tc.Id)
+		}
+		delete(build.VlogDigests, uint32(tc.Id))
+		build.Deletions++
 	default:
 		return fmt.Errorf("MANIFEST file has invalid manifestChange op")
 	}
@@ -473,3 +740,26 @@ func newDeleteChange(id uint64) *pb.ManifestChange {
 		Op: pb.ManifestChange_DELETE,
 	}
 }
+
+// newTableCreateChange returns the change creating t at the given level, along with its digest.
+func newTableCreateChange(t *table.Table, level int) *pb.ManifestChange {
+	c := newCreateChange(t.ID(), level, t.KeyID(), t.CompressionType())
+	c.Sha256 = t.Digest
+	c.ValuesOnly = t.EncryptsValuesOnly()
+	return c
+}
+
+func newCreateVlogChange(fid uint32, digest []byte) *pb.ManifestChange {
+	return &pb.ManifestChange{
+		Id:     uint64(fid),
+		Op:     pb.ManifestChange_CREATE_VLOG,
+		Sha256: digest,
+	}
+}
+
+func newDeleteVlogChange(fid uint32) *pb.ManifestChange {
+	return &pb.ManifestChange{
+		Id: uint64(fid),
+		Op: pb.ManifestChange_DELETE_VLOG,
+	}
+}
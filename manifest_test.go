@@ -17,13 +17,16 @@
 package badger
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"golang.org/x/net/trace"
 
@@ -31,6 +34,7 @@ import (
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -214,7 +218,7 @@ func TestManifestRewrite(t *testing.T) {
 	require.NoError(t, err)
 	defer removeDir(dir)
 	deletionsThreshold := 10
-	mf, m, err := helpOpenOrCreateManifestFile(dir, false, deletionsThreshold)
+	mf, m, err := helpOpenOrCreateManifestFile(dir, false, deletionsThreshold, nil)
 	defer func() {
 		if mf != nil {
 			mf.close()
@@ -240,9 +244,96 @@ func TestManifestRewrite(t *testing.T) {
 	err = mf.close()
 	require.NoError(t, err)
 	mf = nil
-	mf, m, err = helpOpenOrCreateManifestFile(dir, false, deletionsThreshold)
+	mf, m, err = helpOpenOrCreateManifestFile(dir, false, deletionsThreshold, nil)
 	require.NoError(t, err)
 	require.Equal(t, map[uint64]TableManifest{
 		uint64(deletionsThreshold * 3): {Level: 0},
 	}, m.Tables)
 }
+
+func TestEncryptedManifestRewrite(t *testing.T) {
+	for _, algo := range []options.EncryptionAlgorithm{options.AESCTR, options.AESGCM} {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		kopt := getRegistryTestOptions(dir, make([]byte, 32))
+		kopt.EncryptionAlgorithm = algo
+		kr, err := OpenKeyRegistry(kopt)
+		require.NoError(t, err)
+		defer kr.Close()
+
+		deletionsThreshold := 10
+		mf, _, err := helpOpenOrCreateManifestFile(dir, false, deletionsThreshold, kr)
+		require.NoError(t, err)
+		require.NotZero(t, mf.keyID())
+		require.NoError(t, mf.addChanges([]*pb.ManifestChange{newCreateChange(0, 0, 0, 0)}))
+		for i := uint64(0); i < uint64(deletionsThreshold*3); i++ {
+			require.NoError(t, mf.addChanges([]*pb.ManifestChange{
+				newCreateChange(i+1, 0, 0, 0),
+				newDeleteChange(i),
+			}))
+		}
+		require.NoError(t, mf.close())
+
+		fp, err := os.Open(filepath.Join(dir, ManifestFilename))
+		require.NoError(t, err)
+		defer fp.Close()
+		_, _, err = ReplayManifestFile(fp)
+		require.Equal(t, ErrEncryptedManifest, errors.Cause(err))
+		_, err = fp.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		m, _, err := ReplayManifestFileWithKeys(fp, kr)
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]TableManifest{
+			uint64(deletionsThreshold * 3): {Level: 0},
+		}, m.Tables)
+
+		mf, m, err = helpOpenOrCreateManifestFile(dir, false, deletionsThreshold, kr)
+		require.NoError(t, err)
+		require.Len(t, m.Tables, 1)
+		require.NoError(t, mf.close())
+	}
+}
+
+func TestEncryptedManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// The MANIFEST of a DB written in plain text gets encrypted along with it.
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("key"), []byte("value"), 0)
+	require.NoError(t, db.Close())
+
+	opt = opt.WithEncryptionKey(make([]byte, 32)).WithEncryptPlaintextFiles(true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	for running := true; running; {
+		time.Sleep(10 * time.Millisecond)
+		_, running = db.PlaintextEncryptionProgress()
+	}
+	keyID := db.EncryptionInfo().ManifestKeyID
+	require.NotZero(t, keyID)
+
+	// Rewriting the files with newer data keys rewrites the MANIFEST too, and its data key is
+	// kept until then.
+	_, err = db.DropUnusedDataKeys()
+	require.NoError(t, err)
+	_, err = db.registry.DataKey(keyID)
+	require.NoError(t, err)
+	require.NoError(t, db.ReencryptAll(context.Background(), db.registry.nextKeyID+1, nil))
+	require.True(t, db.EncryptionInfo().ManifestKeyID > keyID)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt.WithEncryptPlaintextFiles(false))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), getItemValue(t, item))
+		return nil
+	}))
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"expvar"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// Metrics is a snapshot of the counters of a DB, returned by DB.Metrics. The JSON names of the
+// fields are those of the global expvars, which add up the counters of all the DBs of the process.
+type Metrics struct {
+	// Instance is the InstanceLabel of the DB.
+	Instance     string `json:"instance,omitempty"`
+	DiskReads    int64  `json:"badger_disk_reads_total"`
+	DiskWrites   int64  `json:"badger_disk_writes_total"`
+	BytesRead    int64  `json:"badger_read_bytes"`
+	BytesWritten int64  `json:"badger_written_bytes"`
+	Gets         int64  `json:"badger_gets_total"`
+	Puts         int64  `json:"badger_puts_total"`
+	BlockedPuts  int64  `json:"badger_blocked_puts_total"`
+	MemtableGets int64  `json:"badger_memtable_gets_total"`
+	// LSMLevelGets and LSMBloomHits are keyed by level, named "l0", "l1" and so on.
+	LSMLevelGets map[string]int64 `json:"badger_lsm_level_gets_total"`
+	LSMBloomHits map[string]int64 `json:"badger_lsm_bloom_hits_total"`
+	// LSMSize and VlogSize are updated once a minute.
+	LSMSize       int64 `json:"badger_lsm_size_bytes"`
+	VlogSize      int64 `json:"badger_vlog_size_bytes"`
+	PendingWrites int64 `json:"badger_pending_writes_total"`
+}
+
+// Metrics returns the counters of the DB since it was opened, and its current sizes. Unlike the
+// expvars exported by Badger, they don't include the operations of the other DBs of the process.
+// The disk reads and writes count those of the value log and the tables.
+func (db *DB) Metrics() Metrics {
+	c := db.opt.counters
+	m := Metrics{
+		Instance:     db.opt.InstanceLabel,
+		DiskReads:    atomic.LoadInt64(&c.Reads),
+		DiskWrites:   atomic.LoadInt64(&c.Writes),
+		BytesRead:    atomic.LoadInt64(&c.BytesRead),
+		BytesWritten: atomic.LoadInt64(&c.BytesWritten),
+		Gets:         atomic.LoadInt64(&c.Gets),
+		Puts:         atomic.LoadInt64(&c.Puts),
+		MemtableGets: atomic.LoadInt64(&c.MemtableGets),
+		LSMLevelGets: make(map[string]int64),
+		LSMBloomHits: make(map[string]int64),
+	}
+	for level := range c.LSMGets {
+		name := fmt.Sprintf("l%d", level)
+		m.LSMLevelGets[name] = atomic.LoadInt64(&c.LSMGets[level])
+		m.LSMBloomHits[name] = atomic.LoadInt64(&c.LSMBloomHits[level])
+	}
+	m.LSMSize, m.VlogSize = db.Size()
+	if pending, ok := y.PendingWrites.Get(db.opt.Dir).(*expvar.Int); ok {
+		m.PendingWrites = pending.Value()
+	}
+	return m
+}
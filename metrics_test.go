@@ -0,0 +1,58 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		runBadgerTest(t, nil, func(t *testing.T, other *DB) {
+			for i := 0; i < 10; i++ {
+				txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+			}
+			gets := db.Metrics().Gets
+			require.NoError(t, db.View(func(txn *Txn) error {
+				_, err := txn.Get([]byte("key3"))
+				return err
+			}))
+
+			m := db.Metrics()
+			// Every commit writes a transaction marker along with the key.
+			require.Equal(t, int64(20), m.Puts)
+			require.Equal(t, gets+1, m.Gets)
+			require.NotZero(t, m.MemtableGets)
+			require.NotZero(t, m.DiskWrites)
+			require.NotZero(t, m.BytesWritten)
+			require.Len(t, m.LSMLevelGets, db.opt.MaxLevels)
+
+			require.Zero(t, other.Metrics().Puts)
+
+			buf, err := json.Marshal(m)
+			require.NoError(t, err)
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(buf, &fields))
+			require.Equal(t, float64(20), fields["badger_puts_total"])
+			require.Contains(t, fields["badger_lsm_level_gets_total"], "l0")
+		})
+	})
+}
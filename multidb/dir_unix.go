@@ -0,0 +1,40 @@
+// +build !windows
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multidb
+
+import (
+	"os"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// syncDir syncs dir, so that the entries renamed in it stay renamed if the system crashes.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to open directory %q", dir)
+	}
+	err = y.FileSync(f)
+	closeErr := f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to sync directory %q", dir)
+	}
+	return errors.Wrapf(closeErr, "Unable to close directory %q", dir)
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package multidb manages one Badger DB per tenant, each in a directory of its own.
+//
+// DBs are opened the first time their tenant is accessed, and the least recently used ones get
+// closed to keep at most MaxOpen of them open, bounding the file descriptors and memory they use.
+// A DB is never closed while it's being used by a call of the Manager:
+//
+//	m, err := multidb.New(multidb.DefaultOptions("/data/tenants"))
+//	...
+//	err = m.Update(tenantID, func(txn *badger.Txn) error {
+//		return txn.Set(key, val)
+//	})
+//
+// DBs opened by a Manager should share a badger.Runtime, set in the DB options, so that the block
+// cache and the compactions are sized for all the tenants together.
+package multidb
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrClosed is returned when using a Manager which was closed.
+	ErrClosed = errors.New("Manager is closed")
+
+	// ErrInvalidTenant is returned for tenant IDs which aren't valid directory names.
+	ErrInvalidTenant = errors.New("Invalid tenant ID")
+
+	// ErrInUse is returned by DropKeyspace when the DB of the tenant is being used.
+	ErrInUse = errors.New("DB of the tenant is in use")
+)
+
+// trashPrefix starts the names of the directories of the dropped DBs, which get removed. It can't
+// start a tenant ID.
+const trashPrefix = ".dropped-"
+
+// Options are the options of a Manager.
+type Options struct {
+	// Dir is the directory holding the directories of the tenants.
+	Dir string
+	// MaxOpen is the number of DBs kept open. More DBs stay open while all of them are in use.
+	MaxOpen int
+	// DB are the options the DBs get opened with. Their Dir and ValueDir are set to the directory
+	// of the tenant, and their InstanceLabel to its ID.
+	DB badger.Options
+}
+
+// DefaultOptions returns the options of a Manager keeping the DBs of the tenants in dir.
+func DefaultOptions(dir string) Options {
+	return Options{
+		Dir:     dir,
+		MaxOpen: 64,
+		DB:      badger.DefaultOptions(""),
+	}
+}
+
+// handle is a DB of a tenant, open or being opened.
+type handle struct {
+	tenant string
+	ready  chan struct{} // Closed once the DB is open, or failed to open.
+	db     *badger.DB
+	err    error
+	refs   int           // Number of calls using the DB. Guarded by the Manager.
+	elem   *list.Element // Position in the LRU list.
+}
+
+// Manager opens and closes the DBs of the tenants.
+type Manager struct {
+	opt Options
+
+	sync.Mutex
+	handles map[string]*handle
+	lru     *list.List               // Handles, most recently used first.
+	closing map[string]chan struct{} // Closed once the DB of the tenant is closed.
+	closed  bool
+}
+
+// New returns a Manager of the tenant DBs under opt.Dir, creating it if needed.
+func New(opt Options) (*Manager, error) {
+	if opt.MaxOpen < 1 {
+		return nil, errors.Errorf("Invalid MaxOpen %d, must be at least 1", opt.MaxOpen)
+	}
+	if err := os.MkdirAll(opt.Dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "Unable to create directory %q", opt.Dir)
+	}
+	m := &Manager{
+		opt:     opt,
+		handles: make(map[string]*handle),
+		lru:     list.New(),
+		closing: make(map[string]chan struct{}),
+	}
+	entries, err := ioutil.ReadDir(opt.Dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to list directory %q", opt.Dir)
+	}
+	if err := m.removeTrash(entries); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func validTenant(tenant string) bool {
+	return tenant != "" && tenant != "." && tenant != ".." &&
+		!strings.ContainsAny(tenant, `/\`) && !strings.HasPrefix(tenant, trashPrefix)
+}
+
+// dir returns the directory of the DB of a tenant.
+func (m *Manager) dir(tenant string) string {
+	return filepath.Join(m.opt.Dir, tenant)
+}
+
+// waitClosingLocked waits until the DB of a tenant isn't being closed or dropped anymore, since it
+// can't be opened in the meantime. The Manager must be locked.
+func (m *Manager) waitClosingLocked(tenant string) error {
+	for {
+		if m.closed {
+			return ErrClosed
+		}
+		ch, ok := m.closing[tenant]
+		if !ok {
+			return nil
+		}
+		m.Unlock()
+		<-ch
+		m.Lock()
+	}
+}
+
+// acquire returns the handle of the DB of a tenant, opening the DB if needed. The handle must be
+// released.
+func (m *Manager) acquire(tenant string) (*handle, error) {
+	if !validTenant(tenant) {
+		return nil, ErrInvalidTenant
+	}
+	m.Lock()
+	if err := m.waitClosingLocked(tenant); err != nil {
+		m.Unlock()
+		return nil, err
+	}
+	h, ok := m.handles[tenant]
+	if ok {
+		h.refs++
+		m.lru.MoveToFront(h.elem)
+		m.Unlock()
+		<-h.ready
+	} else {
+		h = &handle{tenant: tenant, ready: make(chan struct{}), refs: 1}
+		h.elem = m.lru.PushFront(h)
+		m.handles[tenant] = h
+		evicted := m.evictLocked()
+		m.Unlock()
+		m.closeHandles(evicted)
+
+		opt := m.opt.DB
+		opt.Dir = m.dir(tenant)
+		opt.ValueDir = opt.Dir
+		opt.InstanceLabel = tenant
+		h.db, h.err = badger.Open(opt)
+		close(h.ready)
+	}
+	if h.err != nil {
+		m.release(h)
+		return nil, h.err
+	}
+	return h, nil
+}
+
+// release gives back a handle returned by acquire.
+func (m *Manager) release(h *handle) {
+	m.Lock()
+	h.refs--
+	if h.err != nil && m.handles[h.tenant] == h {
+		// Let the next call try to open the DB again.
+		delete(m.handles, h.tenant)
+		m.lru.Remove(h.elem)
+	}
+	evicted := m.evictLocked()
+	m.Unlock()
+	m.closeHandles(evicted)
+}
+
+// evictLocked removes the least recently used handles which aren't in use, until at most MaxOpen
+// are left. The DBs of the handles returned must be closed with closeHandles.
+func (m *Manager) evictLocked() []*handle {
+	var evicted []*handle
+	for e := m.lru.Back(); e != nil && m.lru.Len() > m.opt.MaxOpen; {
+		h := e.Value.(*handle)
+		e = e.Prev()
+		if h.refs > 0 {
+			continue
+		}
+		m.lru.Remove(h.elem)
+		delete(m.handles, h.tenant)
+		m.closing[h.tenant] = make(chan struct{})
+		evicted = append(evicted, h)
+	}
+	return evicted
+}
+
+func (m *Manager) closeHandles(handles []*handle) {
+	for _, h := range handles {
+		if err := h.db.Close(); err != nil {
+			m.opt.DB.Errorf("Unable to close DB of tenant %q: %v", h.tenant, err)
+		}
+		m.Lock()
+		close(m.closing[h.tenant])
+		delete(m.closing, h.tenant)
+		m.Unlock()
+	}
+}
+
+// Do calls fn with the DB of a tenant, opening it if needed. The DB stays open until fn returns.
+// It must not be closed by fn.
+func (m *Manager) Do(tenant string, fn func(db *badger.DB) error) error {
+	h, err := m.acquire(tenant)
+	if err != nil {
+		return err
+	}
+	defer m.release(h)
+	return fn(h.db)
+}
+
+// View runs fn in a read-only transaction on the DB of a tenant.
+func (m *Manager) View(tenant string, fn func(txn *badger.Txn) error) error {
+	return m.Do(tenant, func(db *badger.DB) error { return db.View(fn) })
+}
+
+// Update runs fn in a read-write transaction on the DB of a tenant.
+func (m *Manager) Update(tenant string, fn func(txn *badger.Txn) error) error {
+	return m.Do(tenant, func(db *badger.DB) error { return db.Update(fn) })
+}
+
+// DropKeyspace deletes the DB of a tenant, closing it first if it's open. The whole directory of
+// the DB is removed, which is much faster than deleting every key, and doesn't leave deletion
+// markers or value log garbage behind. Calls for the tenant wait until it's done, and then start
+// with an empty DB. DropKeyspace returns ErrInUse if the DB is being used.
+//
+// The directory is renamed before being removed, so that a crash can't leave a partly removed DB
+// to be opened. The directories left behind by a crash are removed by New and Tenants.
+func (m *Manager) DropKeyspace(tenant string) error {
+	if !validTenant(tenant) {
+		return ErrInvalidTenant
+	}
+	m.Lock()
+	if err := m.waitClosingLocked(tenant); err != nil {
+		m.Unlock()
+		return err
+	}
+	h, open := m.handles[tenant]
+	if open {
+		if h.refs > 0 {
+			m.Unlock()
+			return ErrInUse
+		}
+		m.lru.Remove(h.elem)
+		delete(m.handles, tenant)
+	}
+	// Keep the DB from being opened until it's deleted.
+	done := make(chan struct{})
+	m.closing[tenant] = done
+	m.Unlock()
+	defer func() {
+		m.Lock()
+		delete(m.closing, tenant)
+		close(done)
+		m.Unlock()
+	}()
+
+	if open {
+		if err := h.db.Close(); err != nil {
+			return errors.Wrapf(err, "Unable to close DB of tenant %q", tenant)
+		}
+	}
+	trash := fmt.Sprintf("%s%s.%d", trashPrefix, tenant, time.Now().UnixNano())
+	trash = filepath.Join(m.opt.Dir, trash)
+	if err := os.Rename(m.dir(tenant), trash); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "Unable to move DB of tenant %q", tenant)
+	}
+	if err := syncDir(m.opt.Dir); err != nil {
+		return err
+	}
+	return errors.Wrapf(os.RemoveAll(trash), "Unable to remove DB of tenant %q", tenant)
+}
+
+// removeTrash removes the directories of the dropped DBs among entries, the entries of the
+// directory of the Manager.
+func (m *Manager) removeTrash(entries []os.FileInfo) error {
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), trashPrefix) {
+			continue
+		}
+		dir := filepath.Join(m.opt.Dir, e.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.Wrapf(err, "Unable to remove dropped DB %q", dir)
+		}
+	}
+	return nil
+}
+
+// Tenants returns the IDs of the tenants having a DB, open or not, in lexical order. It removes the
+// directories of dropped DBs left behind by a crash.
+func (m *Manager) Tenants() ([]string, error) {
+	entries, err := ioutil.ReadDir(m.opt.Dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to list directory %q", m.opt.Dir)
+	}
+	if err := m.removeTrash(entries); err != nil {
+		return nil, err
+	}
+	var tenants []string
+	for _, e := range entries {
+		if e.IsDir() && validTenant(e.Name()) {
+			tenants = append(tenants, e.Name())
+		}
+	}
+	return tenants, nil
+}
+
+// NumOpen returns the number of DBs open or being opened.
+func (m *Manager) NumOpen() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.lru.Len()
+}
+
+// Close closes all the DBs. It must only be called once no call of the Manager is running.
+func (m *Manager) Close() error {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return nil
+	}
+	m.closed = true
+	handles := m.handles
+	m.handles = nil
+	m.lru.Init()
+	var closing []chan struct{}
+	for _, ch := range m.closing {
+		closing = append(closing, ch)
+	}
+	m.Unlock()
+
+	for _, ch := range closing {
+		<-ch
+	}
+	var firstErr error
+	for _, h := range handles {
+		<-h.ready
+		if h.err != nil {
+			continue
+		}
+		if err := h.db.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "Unable to close DB of tenant %q", h.tenant)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multidb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func newManager(t *testing.T, maxOpen int) (*Manager, string) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	opt := DefaultOptions(dir)
+	opt.MaxOpen = maxOpen
+	opt.DB = opt.DB.WithLogger(nil).WithMaxCacheSize(1 << 20)
+	m, err := New(opt)
+	require.NoError(t, err)
+	return m, dir
+}
+
+func get(m *Manager, tenant, key string) (string, error) {
+	var val []byte
+	err := m.View(tenant, func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	return string(val), err
+}
+
+func TestManager(t *testing.T) {
+	m, dir := newManager(t, 2)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 5; i++ {
+		tenant := fmt.Sprintf("tenant%d", i)
+		require.NoError(t, m.Update(tenant, func(txn *badger.Txn) error {
+			return txn.Set([]byte("name"), []byte(tenant))
+		}))
+		require.True(t, m.NumOpen() <= 2)
+	}
+	// The DBs closed get opened again.
+	for i := 0; i < 5; i++ {
+		val, err := get(m, fmt.Sprintf("tenant%d", i), "name")
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("tenant%d", i), val)
+	}
+	tenants, err := m.Tenants()
+	require.NoError(t, err)
+	require.Equal(t, []string{"tenant0", "tenant1", "tenant2", "tenant3", "tenant4"}, tenants)
+
+	require.Equal(t, ErrInvalidTenant, m.Update("../escape", nil))
+	require.Equal(t, ErrInvalidTenant, m.Update("", nil))
+
+	require.NoError(t, m.Close())
+	_, err = get(m, "tenant0", "name")
+	require.Equal(t, ErrClosed, err)
+}
+
+func TestManagerKeepsDBsInUse(t *testing.T) {
+	m, dir := newManager(t, 1)
+	defer os.RemoveAll(dir)
+	defer m.Close()
+
+	require.NoError(t, m.Do("a", func(a *badger.DB) error {
+		// a can't be closed while in use, so both are open until b isn't used anymore.
+		require.NoError(t, m.Update("b", func(txn *badger.Txn) error {
+			require.Equal(t, 2, m.NumOpen())
+			return txn.Set([]byte("k"), []byte("b"))
+		}))
+		require.Equal(t, 1, m.NumOpen())
+		return a.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte("k"), []byte("a"))
+		})
+	}))
+	require.Equal(t, 1, m.NumOpen())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tenant := []string{"a", "b"}[i%2]
+			val, err := get(m, tenant, "k")
+			require.NoError(t, err)
+			require.Equal(t, tenant, val)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDropKeyspace(t *testing.T) {
+	m, dir := newManager(t, 2)
+	defer os.RemoveAll(dir)
+	defer m.Close()
+
+	for _, tenant := range []string{"a", "b"} {
+		require.NoError(t, m.Update(tenant, func(txn *badger.Txn) error {
+			return txn.Set([]byte("k"), []byte(tenant))
+		}))
+	}
+	require.NoError(t, m.Do("a", func(*badger.DB) error {
+		require.Equal(t, ErrInUse, m.DropKeyspace("a"))
+		return nil
+	}))
+	require.NoError(t, m.DropKeyspace("a"))
+	require.Equal(t, 1, m.NumOpen())
+	tenants, err := m.Tenants()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, tenants)
+
+	_, err = get(m, "a", "k")
+	require.Equal(t, badger.ErrKeyNotFound, err)
+	val, err := get(m, "b", "k")
+	require.NoError(t, err)
+	require.Equal(t, "b", val)
+
+	// Dropping a tenant without a DB does nothing.
+	require.NoError(t, m.DropKeyspace("none"))
+	require.Equal(t, ErrInvalidTenant, m.DropKeyspace(trashPrefix+"b"))
+}
+
+func TestDropKeyspaceCrash(t *testing.T) {
+	m, dir := newManager(t, 2)
+	defer os.RemoveAll(dir)
+	require.NoError(t, m.Update("a", func(txn *badger.Txn) error {
+		return txn.Set([]byte("k"), []byte("a"))
+	}))
+	require.NoError(t, m.Close())
+
+	// A crash while removing the DB leaves its renamed directory behind.
+	trash := filepath.Join(dir, trashPrefix+"a.1")
+	require.NoError(t, os.Rename(filepath.Join(dir, "a"), trash))
+	m, err := New(m.opt)
+	require.NoError(t, err)
+	defer m.Close()
+	_, err = os.Stat(trash)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, os.Mkdir(trash, 0700))
+	tenants, err := m.Tenants()
+	require.NoError(t, err)
+	require.Empty(t, tenants)
+	_, err = os.Stat(trash)
+	require.True(t, os.IsNotExist(err))
+}
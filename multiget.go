@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// multiGetConcurrency is the number of values MultiGet reads from the value log at once.
+const multiGetConcurrency = 16
+
+// MultiGet looks up keys like Get, and returns their items in the same order, with nil for the
+// keys not found. The lookups share a single reference to the memtables, and the values stored in
+// the value log are then read in parallel, so that they're ready to be used from the items
+// returned. Errors reading them are returned by Item.Value, like for the items of iterators
+// prefetching values.
+//
+// MultiGet returns an error if one of the lookups fails, other than with ErrKeyNotFound.
+func (txn *Txn) MultiGet(keys [][]byte) ([]*Item, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	tables, decr := txn.db.getMemTables()
+	defer decr()
+	lookup := func(key []byte) (y.ValueStruct, error) {
+		return txn.db.getFrom(tables, key)
+	}
+
+	items := make([]*Item, len(keys))
+	var fetch []*Item
+	for i, key := range keys {
+		start := time.Now()
+		item, err := txn.get(key, lookup)
+		switch {
+		case err == ErrKeyNotFound:
+			item = nil
+		case err != nil:
+			return nil, err
+		case item.status != prefetched && item.meta&bitValuePointer > 0:
+			fetch = append(fetch, item)
+		}
+		if txn.db.recorder != nil {
+			var sz int
+			if item != nil {
+				sz = int(item.ValueSize())
+			}
+			txn.db.recorder.record(TraceGet, key, len(key), sz, start)
+		}
+		items[i] = item
+	}
+
+	// Read the values from the value log.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, multiGetConcurrency)
+	for _, item := range fetch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *Item) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			item.prefetchValue()
+		}(item)
+	}
+	wg.Wait()
+	return items, nil
+}
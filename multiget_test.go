@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnMultiGet(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		var keys [][]byte
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			keys = append(keys, key)
+			if i%3 == 0 {
+				continue
+			}
+			// Every other value goes to the value log.
+			val := []byte(fmt.Sprintf("val%d", i))
+			if i%2 == 0 {
+				val = append(val, make([]byte, db.opt.ValueThreshold)...)
+			}
+			txnSet(t, db, key, val, 0)
+		}
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set([]byte("key000"), []byte("pending")))
+		require.NoError(t, txn.Delete([]byte("key001")))
+		items, err := txn.MultiGet(keys)
+		require.NoError(t, err)
+		require.Len(t, items, len(keys))
+		for i, item := range items {
+			switch {
+			case i == 0:
+				require.Equal(t, []byte("pending"), getItemValue(t, item))
+			case i%3 == 0 || i == 1:
+				require.Nil(t, item)
+			default:
+				require.Equal(t, keys[i], item.Key())
+				want := []byte(fmt.Sprintf("val%d", i))
+				if i%2 == 0 {
+					want = append(want, make([]byte, db.opt.ValueThreshold)...)
+				}
+				require.Equal(t, want, getItemValue(t, item))
+			}
+		}
+
+		_, err = txn.MultiGet([][]byte{[]byte("key002"), nil})
+		require.Equal(t, ErrEmptyKey, err)
+		txn.Discard()
+		_, err = txn.MultiGet(keys)
+		require.Equal(t, ErrDiscardedTxn, err)
+	})
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"math"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/dgryski/go-farm"
+)
+
+// negativeCacheLocks is the number of locks protecting the slots of a negativeCache.
+const negativeCacheLocks = 64
+
+// negativeCache remembers keys recently looked up and not found, so that looking them up again
+// doesn't need to go through the memtables and the bloom filters of every level.
+//
+// It's a direct-mapped cache: every key can only be in the slot picked by its hash, and replaces
+// the key found there. Slots hold full keys rather than fingerprints, so the cache never claims a
+// key is absent when it isn't. Writes invalidate the slots of the keys they touch.
+type negativeCache struct {
+	slots []negativeSlot
+	mask  uint64
+	locks [negativeCacheLocks]sync.Mutex
+}
+
+type negativeSlot struct {
+	key []byte
+	// since is the lowest read timestamp for which key is absent. The latest version of key is a
+	// deletion or an expired entry with this version, or key has no version at all if it's zero.
+	since uint64
+	valid bool
+	// gen is increased by every write to a key mapped to this slot.
+	gen uint64
+}
+
+// newNegativeCache returns a negativeCache holding up to size keys, rounded up to a power of two.
+// It returns nil if size isn't positive, which is a valid, disabled, cache.
+func newNegativeCache(size int) *negativeCache {
+	if size <= 0 {
+		return nil
+	}
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &negativeCache{slots: make([]negativeSlot, n), mask: uint64(n - 1)}
+}
+
+func (nc *negativeCache) slot(key []byte) (*negativeSlot, *sync.Mutex) {
+	idx := farm.Fingerprint64(key) & nc.mask
+	return &nc.slots[idx], &nc.locks[idx%negativeCacheLocks]
+}
+
+// absent returns true if key is known to be absent at readTs.
+func (nc *negativeCache) absent(key []byte, readTs uint64) bool {
+	if nc == nil {
+		return false
+	}
+	s, mu := nc.slot(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return s.valid && readTs >= s.since && bytes.Equal(s.key, key)
+}
+
+// generation returns the write generation of the slot of key. It must be read before looking up
+// the latest version of key, and passed to add.
+func (nc *negativeCache) generation(key []byte) uint64 {
+	s, mu := nc.slot(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return s.gen
+}
+
+// add records that key is absent for read timestamps from since onwards, unless the slot of key
+// got written to after gen was read.
+func (nc *negativeCache) add(key []byte, since, gen uint64) {
+	s, mu := nc.slot(key)
+	mu.Lock()
+	defer mu.Unlock()
+	if s.gen != gen {
+		return
+	}
+	s.key = y.SafeCopy(s.key, key)
+	s.since = since
+	s.valid = true
+}
+
+// invalidate forgets about key, which has just been written to. It must be called after the write
+// is visible to lookups, or a concurrent recordMiss could add key back.
+func (nc *negativeCache) invalidate(key []byte) {
+	if nc == nil {
+		return
+	}
+	s, mu := nc.slot(key)
+	mu.Lock()
+	defer mu.Unlock()
+	s.gen++
+	if s.valid && bytes.Equal(s.key, key) {
+		s.valid = false
+	}
+}
+
+// clear forgets about all the keys, for when data gets added without going through the memtables.
+func (nc *negativeCache) clear() {
+	if nc == nil {
+		return
+	}
+	for i := range nc.slots {
+		mu := &nc.locks[uint64(i)%negativeCacheLocks]
+		mu.Lock()
+		nc.slots[i].gen++
+		nc.slots[i].valid = false
+		mu.Unlock()
+	}
+}
+
+// recordMiss is called after key wasn't found by a read, and adds key to the negative cache if its
+// latest version, visible to future reads, is absent too.
+func (db *DB) recordMiss(key []byte) {
+	nc := db.negCache
+	if nc == nil || bytes.HasPrefix(key, badgerPrefix) {
+		return
+	}
+	gen := nc.generation(key)
+	vs, err := db.get(y.KeyWithTs(key, math.MaxUint64))
+	if err != nil {
+		return
+	}
+	var since uint64
+	switch {
+	case vs.Value == nil && vs.Meta == 0:
+	case isDeletedOrExpired(vs.Meta, vs.ExpiresAt):
+		since = vs.Version
+	default:
+		// A newer version exists.
+		return
+	}
+	nc.add(key, since, gen)
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegativeCache(t *testing.T) {
+	opt := getTestOptions("").WithNegativeCacheSize(100)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.Equal(t, 128, len(db.negCache.slots))
+		key := []byte("key")
+		get := func() error {
+			return db.View(func(txn *Txn) error {
+				_, err := txn.Get(key)
+				return err
+			})
+		}
+
+		require.Equal(t, ErrKeyNotFound, get())
+		require.True(t, db.negCache.absent(key, 0))
+		require.Equal(t, ErrKeyNotFound, get())
+
+		// Writes invalidate the cache.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("val"))
+		}))
+		require.False(t, db.negCache.absent(key, math.MaxUint64))
+		require.NoError(t, get())
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete(key)
+		}))
+		require.Equal(t, ErrKeyNotFound, get())
+		// The key is only absent from the version of the deletion onwards.
+		require.True(t, db.negCache.absent(key, db.orc.readTs()))
+		require.False(t, db.negCache.absent(key, 1))
+	})
+}
+
+func TestNegativeCacheManaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := OpenManaged(getTestOptions(dir).WithNegativeCacheSize(16))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	key := []byte("key")
+	write := func(ts uint64, del bool) {
+		txn := db.NewTransactionAt(ts, true)
+		defer txn.Discard()
+		if del {
+			require.NoError(t, txn.Delete(key))
+		} else {
+			require.NoError(t, txn.Set(key, []byte("val")))
+		}
+		require.NoError(t, txn.CommitAt(ts, nil))
+	}
+	get := func(ts uint64) error {
+		txn := db.NewTransactionAt(ts, false)
+		defer txn.Discard()
+		_, err := txn.Get(key)
+		return err
+	}
+
+	write(10, false)
+	write(20, true)
+	require.Equal(t, ErrKeyNotFound, get(30))
+	require.Equal(t, ErrKeyNotFound, get(30))
+	require.NoError(t, get(15))
+	require.Equal(t, ErrKeyNotFound, get(5))
+
+	// A version newer than the read timestamp keeps the key out of the cache.
+	write(40, false)
+	require.Equal(t, ErrKeyNotFound, get(30))
+	require.False(t, db.negCache.absent(key, 50))
+	require.NoError(t, get(50))
+}
+
+func TestNegativeCacheConcurrentWrites(t *testing.T) {
+	opt := getTestOptions("").WithNegativeCacheSize(1 << 10)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		const n = 1000
+		keyFor := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+
+		// Misses keep getting recorded for the key being written.
+		var cur, done int32
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for atomic.LoadInt32(&done) == 0 {
+					db.recordMiss(keyFor(int(atomic.LoadInt32(&cur))))
+				}
+			}()
+		}
+		for i := 0; i < n; i++ {
+			atomic.StoreInt32(&cur, int32(i))
+			txnSet(t, db, keyFor(i), []byte("val"), 0)
+		}
+		atomic.StoreInt32(&done, 1)
+		wg.Wait()
+
+		for i := 0; i < n; i++ {
+			require.False(t, db.negCache.absent(keyFor(i), math.MaxUint64), "key %d", i)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+// +build linux
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setThreadNiceness sets the nice value of the calling thread. On Linux, the nice value is a
+// per-thread attribute, which setpriority sets when given a thread ID.
+func setThreadNiceness(niceness int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, unix.Gettid(), niceness)
+}
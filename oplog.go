@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The kinds of operations recorded by an opLog.
+const (
+	opFlush   = "flush"
+	opCompact = "compact"
+	opStall   = "stall"
+	opGC      = "vlog-gc"
+)
+
+// opLog is a ring buffer of the last internal operations of the DB: memtable flushes, compaction
+// picks, write stalls and value log GC decisions. It's cheap enough to be always on, so that
+// DB.DebugDump can tell what the DB was up to when something went wrong, whatever the log level.
+type opLog struct {
+	sync.Mutex
+	ops  []opRecord
+	next int  // index of the slot the next operation is recorded in.
+	full bool // true once ops wrapped around.
+}
+
+type opRecord struct {
+	at   time.Time
+	kind string
+	msg  string
+}
+
+// newOpLog returns an opLog remembering the last size operations. It returns nil if size isn't
+// positive, which is a valid, disabled, opLog.
+func newOpLog(size int) *opLog {
+	if size <= 0 {
+		return nil
+	}
+	return &opLog{ops: make([]opRecord, size)}
+}
+
+// record adds an operation of the given kind to the log, described by format and args.
+func (l *opLog) record(kind, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	rec := opRecord{at: time.Now(), kind: kind, msg: fmt.Sprintf(format, args...)}
+	l.Lock()
+	defer l.Unlock()
+	l.ops[l.next] = rec
+	l.next++
+	if l.next == len(l.ops) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// records returns the operations in the log, oldest first.
+func (l *opLog) records() []opRecord {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	if !l.full {
+		return append([]opRecord(nil), l.ops[:l.next]...)
+	}
+	out := make([]opRecord, 0, len(l.ops))
+	out = append(out, l.ops[l.next:]...)
+	return append(out, l.ops[:l.next]...)
+}
+
+// DebugDump writes the last internal operations of the DB to w, oldest first, one per line: the
+// memtable flushes, the compactions picked and their outcome, the write stalls and the value log
+// GC decisions. They're recorded in memory whatever the logger and its level, up to
+// Options.OpLogSize operations, so that they can be looked at after the fact, e.g. from a signal
+// handler or a debug endpoint when writes got slow.
+func (db *DB) DebugDump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if db.ops == nil {
+		fmt.Fprintln(bw, "Operation log disabled, see Options.OpLogSize.")
+		return bw.Flush()
+	}
+	for _, rec := range db.ops.records() {
+		fmt.Fprintf(bw, "%s %-8s %s\n", rec.at.Format(time.RFC3339Nano), rec.kind, rec.msg)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpLogRing(t *testing.T) {
+	require.Nil(t, newOpLog(0))
+	var disabled *opLog
+	disabled.record(opFlush, "ignored")
+	require.Empty(t, disabled.records())
+
+	l := newOpLog(3)
+	msgs := func() []string {
+		var out []string
+		for _, rec := range l.records() {
+			out = append(out, rec.kind+" "+rec.msg)
+		}
+		return out
+	}
+	l.record(opFlush, "op %d", 1)
+	l.record(opGC, "op %d", 2)
+	require.Equal(t, []string{"flush op 1", "vlog-gc op 2"}, msgs())
+
+	l.record(opCompact, "op %d", 3)
+	l.record(opStall, "op %d", 4)
+	l.record(opFlush, "op %d", 5)
+	require.Equal(t, []string{"compact op 3", "stall op 4", "flush op 5"}, msgs())
+}
+
+func TestDebugDump(t *testing.T) {
+	opt := getTestOptions("").WithKeepL0InMemory(false)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(fmt.Sprintf("key%d", i)), []byte("val"))
+			}))
+		}
+		require.NoError(t, db.FlushMemtable(context.Background()))
+		require.Equal(t, ErrNoRewrite, db.RunValueLogGC(0.5))
+
+		var buf bytes.Buffer
+		require.NoError(t, db.DebugDump(&buf))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		require.Contains(t, lines[0], " flush ")
+		require.Contains(t, lines[0], "to 1 L0 table(s)")
+		require.Contains(t, lines[1], " vlog-gc ")
+		require.Contains(t, lines[1], "no value log file to rewrite")
+	})
+
+	opt = getTestOptions("").WithOpLogSize(0)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		var buf bytes.Buffer
+		require.NoError(t, db.DebugDump(&buf))
+		require.Contains(t, buf.String(), "disabled")
+	})
+}
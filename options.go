@@ -58,6 +58,7 @@ type Options struct {
 	LevelSizeMultiplier int
 	MaxLevels           int
 	ValueThreshold      int
+	MaxKeySize          int
 	NumMemtables        int
 	// Changing BlockSize across DB runs will not break badger. The block size is
 	// read from the block index stored at the end of the table.
@@ -84,15 +85,123 @@ type Options struct {
 	// Encryption related options.
 	EncryptionKey                 []byte        // encryption key
 	EncryptionKeyRotationDuration time.Duration // key rotation duration
+	KeyProvider                   KeyProvider   // supplies the encryption key instead
+	KeyWrapper                    KeyWrapper    // wraps the data keys instead
+	EncryptionPassphrase          string        // derives the encryption key
+	EncryptionAlgorithm           options.EncryptionAlgorithm
+
+	// Value log specific encryption options.
+	ValueLogEncryptionKeyRotationDuration time.Duration
+	DisableValueLogEncryption             bool
+	DisableTableEncryption                bool
+	EncryptionKeyPrefixes                 [][]byte
+	EncryptPlaintextFiles                 bool
+	EncryptValuesOnly                     bool
 
 	// ChecksumVerificationMode decides when db should verify checksums for SSTable blocks.
 	ChecksumVerificationMode options.ChecksumVerificationMode
 
+	// Checksum algorithms of new SSTables and value log files.
+	TableChecksumAlgorithm    options.ChecksumAlgorithm
+	ValueLogChecksumAlgorithm options.ChecksumAlgorithm
+
+	// AccessTracePath is the file anonymized operation traces are recorded to.
+	AccessTracePath string
+
+	// Comparator defines the order of keys. Nil means byte-wise ordering.
+	Comparator Comparator
+
+	// SingleVersion keeps a single version of each key, dropping older ones on flush.
+	SingleVersion bool
+
+	// VersionCountThreshold is the number of versions above which a key gets reported.
+	VersionCountThreshold int
+
+	// NegativeCacheSize is the number of recently missed keys remembered by Txn.Get.
+	NegativeCacheSize int
+
+	// RetentionPolicies override NumVersionsToKeep for the keys under their prefixes.
+	RetentionPolicies []RetentionPolicy
+
+	// SoftDeleteWindow is how long keys deleted by Txn.SoftDelete can be undeleted.
+	SoftDeleteWindow time.Duration
+
+	// TableListener is called with the tables added to and removed from the LSM tree.
+	TableListener TableListener
+
+	// FileDigests records the SHA-256 of every SST and value log file in the MANIFEST.
+	FileDigests bool
+
+	// AppendOnly forbids deleting and overwriting keys.
+	AppendOnly bool
+
+	// ReplayFilter observes, and may skip, the value log entries replayed by Open.
+	ReplayFilter ReplayFilter
+
+	// MaintenanceWindows restrict compactions, Flatten and value log GC to the given times.
+	MaintenanceWindows []MaintenanceWindow
+
+	// CompactionCPUs is the number of goroutines merging and building tables at a time.
+	CompactionCPUs int
+	// CompactionNiceness is the nice value of the threads running compactions, on Linux.
+	CompactionNiceness int
+
+	// Runtime holds resources shared with other DBs.
+	Runtime *Runtime
+
+	// InstanceLabel tells the DB apart from the other DBs of the process.
+	InstanceLabel string
+
+	// OpLogSize is the number of internal operations remembered for DB.DebugDump.
+	OpLogSize int
+
+	// PreCommitHook validates or transforms the entries of every commit.
+	PreCommitHook PreCommitHook
+	// PostCommitHook is called with the commit timestamp of every commit once written.
+	PostCommitHook PostCommitHook
+
+	// ValueTransformers encode and decode the values of the keys under their prefixes.
+	ValueTransformers []PrefixTransformer
+
+	// KeyHashSecret stores the keys as keyed hashes, for privacy-sensitive keys.
+	KeyHashSecret []byte
+	// LockKeyMemory keeps the encryption keys in locked memory, zeroized on close.
+	LockKeyMemory bool
+	// SecondaryEncryptionKeys are previous master keys, accepted during a rotation.
+	SecondaryEncryptionKeys [][]byte
+	// ManualCompactions leaves the compactions to DB.CompactOnce.
+	ManualCompactions bool
+	// FIPSMode restricts the encryption to FIPS-approved primitives.
+	FIPSMode bool
+	// ReservedKeyMigrationPrefix is where the keys using InternalKeyPrefix get moved on Open.
+	ReservedKeyMigrationPrefix []byte
+	// KeyAuditLog records the events of the data keys in the KEYAUDIT file.
+	KeyAuditLog bool
+	// ValueLogArchiver archives the value log files before value log GC deletes them.
+	ValueLogArchiver ValueLogArchiver
+	// JobTimelineSize is the number of background jobs remembered for DB.BackgroundJobs.
+	JobTimelineSize int
+	// RangeDigests computes the digests of the tables for DB.RangeDigest as compactions build them.
+	RangeDigests bool
+	// ConflictDiagnostics makes the transactions fail with a *ConflictError instead of ErrConflict.
+	ConflictDiagnostics bool
+	// MaxDatabaseSize is the size of the DB beyond which setting keys fails. 0 means no limit.
+	MaxDatabaseSize int64
+	// PrefixLimits limit the sizes and number of the keys written under their prefixes.
+	PrefixLimits []PrefixLimit
+	// TTLJitter is the percentage of their TTL by which the expiration of entries gets spread.
+	TTLJitter float64
+
 	// Transaction start and commit timestamps are managed by end-user.
 	// This is only useful for databases built on top of Badger (like Dgraph).
 	// Not recommended for most users.
 	managedTxns bool
 
+	// counters count the operations of the DB, set by Open.
+	counters *y.Counters
+	// cacheID is mixed into the cache keys of the blocks of the DB, set by Open.
+	cacheID uint64
+
 	// 4. Flags for testing purposes
 	// ------------------------------
 	maxBatchCount int64 // max entries in batch
@@ -126,6 +235,7 @@ func DefaultOptions(path string) Options {
 		BlockSize:               4 * 1024,
 		SyncWrites:              true,
 		NumVersionsToKeep:       1,
+		SoftDeleteWindow:        24 * time.Hour,
 		CompactL0OnClose:        true,
 		KeepL0InMemory:          true,
 		VerifyValueChecksum:     false,
@@ -147,12 +257,15 @@ func DefaultOptions(path string) Options {
 
 		ValueLogMaxEntries:            1000000,
 		ValueThreshold:                32,
+		MaxKeySize:                    defaultMaxKeySize,
 		Truncate:                      false,
 		Logger:                        defaultLogger,
 		LogRotatesToFlush:             2,
 		EventLogging:                  true,
 		EncryptionKey:                 []byte{},
 		EncryptionKeyRotationDuration: 10 * 24 * time.Hour, // Default 10 days.
+		OpLogSize:                     1000,
+		JobTimelineSize:               1000,
 	}
 }
 
@@ -164,11 +277,22 @@ func buildTableOptions(opt Options) table.Options {
 		ChkMode:              opt.ChecksumVerificationMode,
 		Compression:          opt.Compression,
 		ZSTDCompressionLevel: opt.ZSTDCompressionLevel,
+		ChecksumAlgorithm:    opt.TableChecksumAlgorithm,
+		Comparator:           opt.Comparator,
+		Counters:             opt.counters,
+		CacheID:              opt.cacheID,
+		EncryptValuesOnly:    opt.EncryptValuesOnly,
 	}
 }
 
 const (
 	maxValueThreshold = (1 << 20) // 1 MB
+
+	// Key length can't be more than uint16 in tables readable by every version of Badger. To keep
+	// things safe and allow badger move prefix and a timestamp suffix, let's cut it down to 65000,
+	// instead of using 65536.
+	defaultMaxKeySize = 65000
+	maxKeySizeLimit   = (1 << 20) // 1 MB
 )
 
 // LSMOnlyOptions follows from DefaultOptions, but sets a higher ValueThreshold
@@ -565,3 +689,718 @@ func (opt Options) WithZSTDCompressionLevel(cLevel int) Options {
 	opt.ZSTDCompressionLevel = cLevel
 	return opt
 }
+
+// WithAccessTracePath returns a new Options value with AccessTracePath set to the given value.
+//
+// When AccessTracePath is set, Badger records every Get, Set, Delete and Commit done through
+// transactions to the given file. Only a hash of the key, the sizes involved and the timing of
+// each operation are recorded, so traces can be shared without leaking data. A trace can be
+// re-executed against another instance with the `badger replay` tool to reproduce performance
+// issues offline. The file is truncated on open.
+//
+// The default value of AccessTracePath is "", which disables recording.
+func (opt Options) WithAccessTracePath(path string) Options {
+	opt.AccessTracePath = path
+	return opt
+}
+
+// WithComparator returns a new Options value with Comparator set to the given value.
+//
+// Comparator replaces the default byte-wise ordering of keys, for applications which need keys
+// sorted differently, e.g. case-insensitively. It affects iteration order as well as the layout
+// of the LSM tree, so a DB must always be opened with the comparator it was created with. The
+// name of the comparator is stored in the COMPARATOR file on creation, and Open fails with
+// ErrComparatorMismatch if it doesn't match. Compare must only return 0 for byte-wise equal keys.
+//
+// Prefix iteration and DropPrefix assume that all the keys sharing a prefix are adjacent. A
+// comparator which doesn't preserve that can only be used with full range iteration.
+//
+// The default value of Comparator is nil, which orders keys byte-wise.
+func (opt Options) WithComparator(c Comparator) Options {
+	opt.Comparator = c
+	return opt
+}
+
+// WithSingleVersion returns a new Options value with SingleVersion set to the given value.
+//
+// When SingleVersion is true, Badger keeps exactly one version per key. Versions overwritten by a
+// newer one are dropped as soon as the memtable holding them is flushed, instead of waiting for
+// compaction to reach them, which saves space for cache-like workloads overwriting the same keys
+// over and over. Versions still visible to running transactions are kept until these finish.
+// This option forces NumVersionsToKeep to 1.
+//
+// The default value of SingleVersion is false.
+func (opt Options) WithSingleVersion(val bool) Options {
+	opt.SingleVersion = val
+	return opt
+}
+
+// WithVersionCountThreshold returns a new Options value with VersionCountThreshold set to the
+// given value.
+//
+// Old versions of a key are only dropped once compactions reach them, so keys updated at a very
+// high rate can pile up lots of versions, slowing down reads and iterations over them. When
+// VersionCountThreshold is greater than zero, flushes and compactions log a warning for every key
+// found with more versions than this. The worst offenders are returned by
+// DB.KeysWithManyVersions, and can be compacted right away with DB.CompactRange.
+//
+// The default value of VersionCountThreshold is 0, which disables tracking.
+func (opt Options) WithVersionCountThreshold(val int) Options {
+	opt.VersionCountThreshold = val
+	return opt
+}
+
+// WithValueLogEncryptionKeyRotationDuration returns a new Options value with
+// ValueLogEncryptionKeyRotationDuration set to the given value.
+//
+// When ValueLogEncryptionKeyRotationDuration is greater than zero, value log files get data keys
+// of their own, rotated after the given duration, while tables keep using keys rotated after
+// EncryptionKeyRotationDuration. Value log files are usually short-lived, so their keys can be
+// rotated much more often.
+//
+// The default value of ValueLogEncryptionKeyRotationDuration is 0, which means value log files
+// and tables share the same data keys.
+func (opt Options) WithValueLogEncryptionKeyRotationDuration(d time.Duration) Options {
+	opt.ValueLogEncryptionKeyRotationDuration = d
+	return opt
+}
+
+// WithDisableValueLogEncryption returns a new Options value with DisableValueLogEncryption set to
+// the given value.
+//
+// When DisableValueLogEncryption is true, new value log files are written in plain text even if
+// an EncryptionKey is set. Existing encrypted files can still be read.
+//
+// The default value of DisableValueLogEncryption is false.
+func (opt Options) WithDisableValueLogEncryption(val bool) Options {
+	opt.DisableValueLogEncryption = val
+	return opt
+}
+
+// WithDisableTableEncryption returns a new Options value with DisableTableEncryption set to the
+// given value.
+//
+// When DisableTableEncryption is true, new tables are written in plain text even if an
+// EncryptionKey is set. Existing encrypted tables can still be read.
+//
+// The default value of DisableTableEncryption is false.
+func (opt Options) WithDisableTableEncryption(val bool) Options {
+	opt.DisableTableEncryption = val
+	return opt
+}
+
+// WithNegativeCacheSize returns a new Options value with NegativeCacheSize set to the given value.
+//
+// Looking up a key which doesn't exist goes through the memtables and the bloom filters of every
+// level, which adds up for workloads looking up lots of missing keys. When NegativeCacheSize is
+// greater than zero, Txn.Get remembers up to this many keys it didn't find, rounded up to a power
+// of two, and answers further lookups of these keys right away until they get written to. Keys
+// written by a StreamWriter clear the whole cache.
+//
+// The default value of NegativeCacheSize is 0, which disables the cache.
+func (opt Options) WithNegativeCacheSize(size int) Options {
+	opt.NegativeCacheSize = size
+	return opt
+}
+
+// WithRetentionPolicies returns a new Options value with RetentionPolicies set to the given value.
+//
+// NumVersionsToKeep applies to every key. RetentionPolicies enforce other rules for the keys
+// under their prefixes during compactions, e.g. to keep the whole history of audit records while
+// keeping only the latest version of cached data. The policy with the longest prefix matching a
+// key applies to it. Keys without a policy keep following NumVersionsToKeep.
+//
+// Policies with a KeepFor duration relate timestamps to time using samples persisted in the
+// TSCLOCK file, taken when the DB opens and when memtables get flushed, at most once a minute.
+// The versions kept can thus exceed KeepFor by a minute or two, and by the time the DB stays idle.
+//
+// The default value of RetentionPolicies is nil.
+func (opt Options) WithRetentionPolicies(val []RetentionPolicy) Options {
+	opt.RetentionPolicies = val
+	return opt
+}
+
+// WithSoftDeleteWindow returns a new Options value with SoftDeleteWindow set to the given value.
+//
+// Txn.SoftDelete keeps the value of the keys it deletes for SoftDeleteWindow, so that Txn.Undelete
+// can restore them, whatever NumVersionsToKeep is. Once the window is over, soft deleted keys are
+// garbage collected like deleted ones. Badger stores expiration times with a precision of one
+// second, so the window is rounded down to a whole number of seconds.
+//
+// The default value of SoftDeleteWindow is 24 hours.
+func (opt Options) WithSoftDeleteWindow(val time.Duration) Options {
+	opt.SoftDeleteWindow = val
+	return opt
+}
+
+// WithTableListener returns a new Options value with TableListener set to the given value.
+//
+// TableListener is called with the tables added and removed by every change to the LSM tree, along
+// with their levels, key ranges and version bounds, in the order the changes were written to the
+// MANIFEST. Systems indexing the data stored in Badger can use it to only reindex the key ranges
+// of new tables. The listener is called from a single goroutine, and changes are queued while it
+// runs, keeping their tables open. Tables don't record their version bounds, so the keys of every
+// table get read before the listener is called with it.
+//
+// The default value of TableListener is nil.
+func (opt Options) WithTableListener(val TableListener) Options {
+	opt.TableListener = val
+	return opt
+}
+
+// WithFileDigests returns a new Options value with FileDigests set to the given value.
+//
+// When FileDigests is true, the SHA-256 of every SST file written and of every value log file
+// rotated is recorded in the MANIFEST, and DB.VerifyFiles can check that files weren't modified
+// since. Blocks and entries already carry checksums, but these only catch accidental corruption:
+// without encryption, a file can be rewritten with matching checksums. Value log files get hashed
+// as they're written, and their digest gets recorded once they're rotated. Versions of Badger
+// without this option can't open a MANIFEST holding the digests of value log files.
+//
+// The default value of FileDigests is false.
+func (opt Options) WithFileDigests(val bool) Options {
+	opt.FileDigests = val
+	return opt
+}
+
+// WithAppendOnly returns a new Options value with AppendOnly set to the given value.
+//
+// When AppendOnly is true, keys can only be written once: writing a key which exists fails with
+// ErrKeyExists, and deleting a key, writing a key with an expiration time, DropAll and DropPrefix
+// fail with ErrAppendOnly. This covers anything built on top of these operations, like Rename,
+// SoftDelete, sequences and merge operators, which overwrite their key. Along with FileDigests, it
+// gives write-once storage for audit trails and the like. Every write looks its key up first, and
+// this goes for write batches and DB.Load too. A new key is claimed by the first write of it until
+// that write is committed or discarded, the other writes failing with ErrKeyExists in the meantime.
+//
+// The default value of AppendOnly is false.
+func (opt Options) WithAppendOnly(val bool) Options {
+	opt.AppendOnly = val
+	return opt
+}
+
+// WithMaintenanceWindows returns a new Options value with MaintenanceWindows set to the given
+// value.
+//
+// Compactions and value log GC compete with reads and writes for disk and CPU. When
+// MaintenanceWindows is set, background compactions only run within the windows, e.g. at night,
+// and DB.Flatten and DB.RunValueLogGC return ErrOutsideMaintenanceWindow outside of them. As an
+// emergency override, compactions run regardless of the windows while level 0 holds at least
+// NumLevelZeroTablesStall-1 tables, or writes are stalled. Otherwise, the tables written in
+// between pile up in level 0 and reads get slower until the next window.
+//
+// The default value of MaintenanceWindows is nil, which lets the work run at any time.
+func (opt Options) WithMaintenanceWindows(val []MaintenanceWindow) Options {
+	opt.MaintenanceWindows = val
+	return opt
+}
+
+// WithCompactionCPUs returns a new Options value with CompactionCPUs set to the given value.
+//
+// Every compaction merges its tables in one goroutine and builds every new table in a goroutine of
+// its own, compressing and encrypting its blocks. On small machines, this can take all the CPUs
+// and hurt the latency of reads. When CompactionCPUs is greater than zero, at most this many of
+// these goroutines run at a time, and no more than GOMAXPROCS-1 unless GOMAXPROCS is 1. Flushes of
+// memtables aren't limited, since writes wait for them.
+//
+// The default value of CompactionCPUs is 0, which doesn't limit compactions.
+func (opt Options) WithCompactionCPUs(val int) Options {
+	opt.CompactionCPUs = val
+	return opt
+}
+
+// WithCompactionNiceness returns a new Options value with CompactionNiceness set to the given
+// value.
+//
+// When CompactionNiceness is greater than zero, the background compactions run on threads of
+// their own with this nice value, up to 19, so that the kernel favors the threads serving reads
+// and writes. Lowering the nice value needs privileges. The threads are discarded once done, since
+// they can't get back to the nice value of the process. CompactionNiceness is only supported on
+// Linux, and ignored elsewhere.
+//
+// The default value of CompactionNiceness is 0, which leaves the nice value of the process.
+func (opt Options) WithCompactionNiceness(val int) Options {
+	opt.CompactionNiceness = val
+	return opt
+}
+
+// WithRuntime returns a new Options value with Runtime set to the given value.
+//
+// Every DB has a block cache of its own, and runs its compactions regardless of the other DBs of
+// the process. When Runtime is set, the DB uses the block cache of the runtime instead, and its
+// compactions share the CPUs and the write rate allowed by the runtime with the other DBs using
+// it. MaxCacheSize and CompactionCPUs are then ignored. DropAll clears the whole shared cache.
+//
+// The default value of Runtime is nil.
+func (opt Options) WithRuntime(val *Runtime) Options {
+	opt.Runtime = val
+	return opt
+}
+
+// WithInstanceLabel returns a new Options value with InstanceLabel set to the given value.
+//
+// Processes opening several DBs need to tell their logs and metrics apart. InstanceLabel prefixes
+// the messages logged by the DB, within brackets, and gets appended to the titles of its traces.
+// It's returned as the Instance of DB.Metrics and the Label of OpenInstances.
+//
+// The default value of InstanceLabel is "".
+func (opt Options) WithInstanceLabel(val string) Options {
+	opt.InstanceLabel = val
+	return opt
+}
+
+// WithKeyProvider returns a new Options value with KeyProvider set to the given value.
+//
+// KeyProvider supplies the master encryption key in place of EncryptionKey, which must then be
+// left empty. The key registry gets the key from it when the DB is opened and whenever a data key
+// is generated, so that it can be kept in a key management service such as AWS KMS, GCP KMS or
+// Azure Key Vault rather than handed over at Open time. If it implements KeyRewrapper, the master
+// key can be rotated with DB.RewrapMasterKey. NewVaultKeyProvider returns one for HashiCorp Vault.
+//
+// The default value of KeyProvider is nil.
+func (opt Options) WithKeyProvider(val KeyProvider) Options {
+	opt.KeyProvider = val
+	return opt
+}
+
+// WithEncryptionAlgorithm returns a new Options value with EncryptionAlgorithm set to the given
+// value.
+//
+// EncryptionAlgorithm is the algorithm encrypting the data keys with the master key, and the SST
+// blocks with the data keys. options.AESCTR doesn't detect tampering, a flipped bit silently
+// decrypts to garbage, while options.AESGCM stores an authentication tag with every data key and
+// block, and fails reading them if they don't match. The algorithm is recorded with every data
+// key, so existing files remain readable: changing it generates a new data key for the new
+// tables, and DB.ReencryptAll rewrites the tables using the older ones. Value log files are
+// encrypted with AES-CTR regardless, their entries being protected by checksums.
+//
+// The default value of EncryptionAlgorithm is options.AESCTR.
+func (opt Options) WithEncryptionAlgorithm(val options.EncryptionAlgorithm) Options {
+	opt.EncryptionAlgorithm = val
+	return opt
+}
+
+// WithReplayFilter returns a new Options value with ReplayFilter set to the given value.
+//
+// When a DB isn't closed cleanly, the writes which didn't make it to the LSM tree are replayed
+// from the value log by Open. ReplayFilter is called with every one of them, once its transaction
+// is known to be committed, and the entry is skipped if it returns false. It can be used to audit
+// what got recovered after a crash, or to implement custom recovery logic, e.g. dropping writes
+// newer than a version known to be replicated. Skipped entries remain in the value log, where
+// they're reclaimed by value log GC. The internal keys of Badger are always replayed.
+//
+// The default value of ReplayFilter is nil.
+func (opt Options) WithReplayFilter(val ReplayFilter) Options {
+	opt.ReplayFilter = val
+	return opt
+}
+
+// WithMaxKeySize returns a new Options value with MaxKeySize set to the given value.
+//
+// MaxKeySize is the size of the largest key that can be written, up to 1MB. Keys of 64KB or more
+// are stored in tables of a newer format, which older versions of Badger refuse to open, so it
+// shouldn't be raised above the default before every process opening the DB is upgraded. Large
+// keys are also slow: they're kept in full in memtables, in the block index of tables, and in
+// every entry of the value log, and compared in full on every lookup. Storing a hash of the long
+// part of the key, with the key itself in the value, is usually faster. Like any entry, a large
+// key must also fit in a transaction, which can hold up to 15% of MaxTableSize.
+//
+// The default value of MaxKeySize is 65000.
+func (opt Options) WithMaxKeySize(val int) Options {
+	opt.MaxKeySize = val
+	return opt
+}
+
+// WithEncryptionKeyPrefixes returns a new Options value with EncryptionKeyPrefixes set to the given
+// value.
+//
+// EncryptionKeyPrefixes are the key prefixes, e.g. one per tenant, whose keys are encrypted with
+// data keys of their own, generated and rotated like the others. Tables are split at the
+// boundaries of the prefixes, so that every table holds the keys of a single prefix, or only keys
+// without one of the prefixes. A prefix can't contain another. Values stored in the value log are
+// still encrypted with the data keys of the value log, so isolating the values too requires
+// ValueThreshold to be large enough to keep them in the tables. It requires an encryption key.
+//
+// The default value of EncryptionKeyPrefixes is nil.
+func (opt Options) WithEncryptionKeyPrefixes(val [][]byte) Options {
+	opt.EncryptionKeyPrefixes = val
+	return opt
+}
+
+// WithTableChecksumAlgorithm returns a new Options value with TableChecksumAlgorithm set to the
+// given value.
+//
+// TableChecksumAlgorithm is the checksum of the blocks of new SSTables, verified as set with
+// WithChecksumVerificationMode. XXHash64 is faster on CPUs without CRC-32 instructions, and
+// NoChecksum saves the cost of checksums altogether, leaving corruptions undetected. The algorithm
+// is stored in every table, so it can be changed at any time: existing tables keep theirs until
+// they're compacted. Tables written with NoChecksum can't be opened by older versions of Badger.
+//
+// The default value of TableChecksumAlgorithm is options.CRC32C.
+func (opt Options) WithTableChecksumAlgorithm(val options.ChecksumAlgorithm) Options {
+	opt.TableChecksumAlgorithm = val
+	return opt
+}
+
+// WithValueLogChecksumAlgorithm returns a new Options value with ValueLogChecksumAlgorithm set to
+// the given value.
+//
+// ValueLogChecksumAlgorithm is the checksum of the entries of new value log files, verified when
+// the value log is replayed, and on every read if VerifyValueChecksum is set. It's truncated to
+// 32 bits, the size of the checksum of every entry. The algorithm is stored in the header of every
+// file, so it can be changed at any time. With NoChecksum, entries partially written before a
+// crash aren't detected on replay, unless they're truncated, which can surface garbage at the end
+// of the value log. The value log files written with another algorithm than CRC32C start with a
+// versioned header holding the algorithm, which older versions of Badger can't open.
+//
+// The default value of ValueLogChecksumAlgorithm is options.CRC32C.
+func (opt Options) WithValueLogChecksumAlgorithm(val options.ChecksumAlgorithm) Options {
+	opt.ValueLogChecksumAlgorithm = val
+	return opt
+}
+
+// WithEncryptPlaintextFiles returns a new Options value with EncryptPlaintextFiles set to the
+// given value.
+//
+// EncryptPlaintextFiles allows setting an EncryptionKey, KeyProvider or KeyWrapper on a DB created
+// without encryption, which otherwise fails to open with ErrEncryptionKeyMismatch. Open encrypts
+// the key registry with the master key, and the tables and value log files written in plain text
+// are rewritten under data keys in the background, along with the regular compactions. See
+// DB.PlaintextEncryptionProgress to follow it. DB.Freeze waits for the encryption to finish, and
+// it doesn't start while the DB is frozen. The DB must keep being opened with the master key
+// afterwards.
+//
+// The default value of EncryptPlaintextFiles is false.
+func (opt Options) WithEncryptPlaintextFiles(val bool) Options {
+	opt.EncryptPlaintextFiles = val
+	return opt
+}
+
+// WithKeyWrapper returns a new Options value with KeyWrapper set to the given value.
+//
+// KeyWrapper wraps the data keys in place of a master key, so that EncryptionKey and KeyProvider
+// must be left empty. It's meant for master keys which must not leave an HSM, used through
+// PKCS#11: the key registry stores the data keys as wrapped by the HSM, along with the label of
+// the key they're wrapped with, and has them unwrapped when the DB is opened. The data keys are
+// 32 bytes long. DB.RotateMasterKey and DB.RewrapMasterKey don't apply, new data keys get wrapped
+// with whichever key the KeyWrapper picks.
+//
+// The default value of KeyWrapper is nil.
+func (opt Options) WithKeyWrapper(val KeyWrapper) Options {
+	opt.KeyWrapper = val
+	return opt
+}
+
+// WithOpLogSize returns a new Options value with OpLogSize set to the given value.
+//
+// OpLogSize is the number of internal operations the DB keeps in memory for DB.DebugDump: memtable
+// flushes, compaction picks, write stalls and value log GC decisions. Once it's reached, every new
+// operation replaces the oldest one. They're recorded whatever the logger, so that postmortems
+// don't depend on verbose logging having been enabled beforehand. 0 disables the operation log.
+//
+// The default value of OpLogSize is 1000.
+func (opt Options) WithOpLogSize(val int) Options {
+	opt.OpLogSize = val
+	return opt
+}
+
+// WithEncryptionPassphrase returns a new Options value with EncryptionPassphrase set to the given
+// value.
+//
+// EncryptionPassphrase derives the master key, which encrypts the data keys, from a passphrase
+// with Argon2id, in place of EncryptionKey, KeyProvider and KeyWrapper which must be left empty.
+// The salt and the parameters of the derivation are stored at the start of the key registry, and
+// the derived key is checked against its sanity text like an EncryptionKey, so that opening the
+// DB with a wrong passphrase fails with ErrEncryptionKeyMismatch. The derivation takes 64MB of
+// memory and a fraction of a second, once per Open. DB.RotateMasterKey doesn't apply.
+//
+// The default value of EncryptionPassphrase is "".
+func (opt Options) WithEncryptionPassphrase(val string) Options {
+	opt.EncryptionPassphrase = val
+	return opt
+}
+
+// WithEncryptValuesOnly returns a new Options value with EncryptValuesOnly set to the given value.
+//
+// EncryptValuesOnly encrypts the values of new SSTables one by one, leaving their keys, block
+// index and bloom filter in plain text, for the workloads where keys are non-sensitive
+// identifiers. Seeks and compactions then don't decrypt whole blocks, at the cost of an IV, or a
+// nonce and a tag with AES-GCM, per value. Whether a table encrypts its values only is recorded
+// in the MANIFEST, so the option can be changed between runs, tables being rewritten in the new
+// mode by the compactions. The value log stays encrypted as a whole. It has no effect without
+// encryption, or with DisableTableEncryption.
+//
+// The default value of EncryptValuesOnly is false.
+func (opt Options) WithEncryptValuesOnly(val bool) Options {
+	opt.EncryptValuesOnly = val
+	return opt
+}
+
+// WithPreCommitHook returns a new Options value with PreCommitHook set to the given value.
+//
+// PreCommitHook is called with the entries of every transaction and write batch being committed,
+// before conflicts are detected, and returns the entries to write in their place, or an error
+// which Commit returns without writing anything. It can enforce key naming rules, inject tenant
+// prefixes into the keys or strip personal data from the values. The entries it returns are
+// checked like the ones passed to Txn.SetEntry, and conflicts are detected on their keys. Keys
+// moved by Txn.Rename can be dropped but not altered. It runs in the goroutine calling Commit, so
+// it must be safe for concurrent use. StreamWriter and the internal writes of Badger bypass it.
+//
+// The default value of PreCommitHook is nil.
+func (opt Options) WithPreCommitHook(val PreCommitHook) Options {
+	opt.PreCommitHook = val
+	return opt
+}
+
+// WithPostCommitHook returns a new Options value with PostCommitHook set to the given value.
+//
+// PostCommitHook is called with the commit timestamp of every transaction and write batch once its
+// entries have been written to the value log, synced if SyncWrites is set, and are visible to the
+// transactions reading at that timestamp. It isn't called for the commits which fail. It runs
+// before Commit returns, or before the callback of CommitWith is called, so it should be quick.
+//
+// The default value of PostCommitHook is nil.
+func (opt Options) WithPostCommitHook(val PostCommitHook) Options {
+	opt.PostCommitHook = val
+	return opt
+}
+
+// WithValueTransformers returns a new Options value with ValueTransformers set to the given value.
+//
+// ValueTransformers encode the values of the keys under their prefixes when they're written, and
+// decode them when they're read, so that applications can layer their own envelope encryption or
+// domain specific compression under every call site. The longest prefix of a key decides which
+// transformer applies. Values are encoded as they get committed, or loaded by KVLoader and
+// StreamWriter, and decoded by Item.Value and Item.ValueCopy, subscriptions and backups. The
+// reads of a transaction return its own pending writes as they were set. Empty values and
+// deletions aren't transformed, nor are the internal keys of Badger.
+//
+// The transformers must stay the same for the keys already written: the stored values aren't
+// rewritten when they change, and the ones written with no transformer get decoded all the same.
+// Item.ValueSize and Item.EstimatedSize return the sizes of the stored values.
+//
+// The default value of ValueTransformers is nil.
+func (opt Options) WithValueTransformers(val []PrefixTransformer) Options {
+	opt.ValueTransformers = val
+	return opt
+}
+
+// WithKeyHashSecret returns a new Options value with KeyHashSecret set to the given value.
+//
+// KeyHashSecret stores every key as its HMAC-SHA256 keyed by a key derived from the secret, for
+// privacy-sensitive keys which must not appear in the files of the DB, and encrypts the original
+// key into the start of the value with another key derived from the secret. It must be at least
+// 16 bytes long, and set when the DB gets created: keys written without it can't be read with it,
+// nor the other way around.
+//
+// Point lookups work as usual: Txn.Get, the writes, the conditional writes, Txn.Rename and
+// NewKeyIterator, whose items return the original key. The other iterators traverse the keys in
+// the order of their hashes, their items return the hashes from Item.Key, and Item.OriginalKey
+// decrypts the original keys, except for deletions. NewIterator panics with a Prefix, and Seek
+// takes hashes. Backups, Stream and subscriptions output the original keys, and KVLoader and
+// StreamWriter hash them. The trade-offs: range and prefix scans aren't possible, every value is
+// larger by the encrypted key, renamed keys get their value copied, and the prefixes of
+// RetentionPolicies, EncryptionKeyPrefixes, subscriptions and DropPrefix are matched against the
+// hashes, which limits them to the empty prefix. Deletions don't store the original key, so
+// incremental backups leave them out. ValueTransformers still match the original keys.
+//
+// The default value of KeyHashSecret is nil.
+func (opt Options) WithKeyHashSecret(val []byte) Options {
+	opt.KeyHashSecret = val
+	return opt
+}
+
+// WithLockKeyMemory returns a new Options value with LockKeyMemory set to the given value.
+//
+// LockKeyMemory keeps the master key and the key material of the data keys in memory locked with
+// mlock (VirtualLock on Windows), so that they don't get swapped to disk, and zeroizes them when
+// the DB is closed, and the master key replaced by KeyRegistry.RotateMasterKey, to shorten the
+// time they can be found in memory dumps. Open fails if the memory can't be locked, e.g. because
+// of RLIMIT_MEMLOCK. The keys take a few bytes each, locked by pages of 4KB.
+//
+// Badger copies EncryptionKey, so the caller can zeroize its own copy once the DB is open. The keys
+// of a KeyProvider, fetched whenever they're needed, are left to the KeyProvider. The keys expanded
+// by the AES ciphers of the open files remain in the regular heap.
+//
+// The default value of LockKeyMemory is false.
+func (opt Options) WithLockKeyMemory(val bool) Options {
+	opt.LockKeyMemory = val
+	return opt
+}
+
+// WithSecondaryEncryptionKeys returns a new Options value with SecondaryEncryptionKeys set to the
+// given value.
+//
+// SecondaryEncryptionKeys are master keys tried in turn if EncryptionKey, the primary key, doesn't
+// match the key registry, for rolling rotations of the master key across a fleet: every instance
+// gets the new key as EncryptionKey and the previous one as a secondary key, and can restart on
+// its own schedule. A key registry opened with a secondary key is encrypted with EncryptionKey
+// right away, which is always used for new data keys, except in read-only mode where it stays as
+// it is. The secondary keys can be removed once every instance has been restarted. They require
+// EncryptionKey, and must be 16, 24 or 32 bytes long each.
+//
+// The default value of SecondaryEncryptionKeys is nil.
+func (opt Options) WithSecondaryEncryptionKeys(val [][]byte) Options {
+	opt.SecondaryEncryptionKeys = val
+	return opt
+}
+
+// WithManualCompactions returns a new Options value with ManualCompactions set to the given value.
+//
+// ManualCompactions runs no compactors: the LSM tree only gets compacted by DB.CompactOnce, one
+// compaction at a time, and by Flatten, CompactRange and CompactL0OnClose, so that it goes through
+// the same states on every run given the same writes and calls, e.g. in integration tests sensitive
+// to it. Flushes remain in the background, DB.FlushMemtable waits for them. A flush which would
+// stall on level zero runs the compactions needed itself instead, which keeps writes going if
+// CompactOnce doesn't get called, at a point which is reproducible too.
+//
+// The default value of ManualCompactions is false.
+func (opt Options) WithManualCompactions(val bool) Options {
+	opt.ManualCompactions = val
+	return opt
+}
+
+// WithFIPSMode returns a new Options value with FIPSMode set to the given value.
+//
+// FIPSMode restricts the encryption to FIPS-approved primitives: the DB must be encrypted, the
+// master keys, including the secondary ones and those of a KeyProvider, must be 32 bytes long, the
+// data keys always use AES-GCM whatever EncryptionAlgorithm, and a passphrase is derived with
+// PBKDF2-HMAC-SHA256 rather than Argon2id. The value log and the hashed keys of KeyHashSecret
+// remain on AES-CTR, an approved mode (SP 800-38A) and the only one allowing to read value log
+// entries by their offset, HMAC-SHA256 hashing the keys. The key registry records the mode: it
+// can't be opened with the other mode, which gives ErrFIPSModeMismatch, so an existing DB has to be
+// recreated, e.g. with a backup, to switch. Building with the badger_fips tag forces FIPSMode on.
+// Badger uses the crypto primitives of the Go standard library, a FIPS validated build of Go is
+// needed on top of it for compliance.
+//
+// The default value of FIPSMode is false.
+func (opt Options) WithFIPSMode(val bool) Options {
+	opt.FIPSMode = val
+	return opt
+}
+
+// WithReservedKeyMigrationPrefix returns a new Options value with ReservedKeyMigrationPrefix set to
+// the given value.
+//
+// ReservedKeyMigrationPrefix is the prefix the keys starting with InternalKeyPrefix, written
+// before it got reserved, are moved under when opening the DB, with DB.MigrateReservedKeys. If
+// it's nil, or the DB is opened in managed or read-only mode, Open only logs a warning about such
+// keys.
+//
+// The default value of ReservedKeyMigrationPrefix is nil.
+func (opt Options) WithReservedKeyMigrationPrefix(val []byte) Options {
+	opt.ReservedKeyMigrationPrefix = val
+	return opt
+}
+
+// WithKeyAuditLog returns a new Options value with KeyAuditLog set to the given value.
+//
+// KeyAuditLog records the life of the data keys of an encrypted DB in an append-only audit log,
+// the KeyAuditFileName file in the DB directory: their creation, their rotation, the first time
+// they encrypt a table, a value log file or the MANIFEST, their retirement by
+// DB.DropUnusedDataKeys, as well as the rotations of the master key, so that one can prove that
+// the rotation policy is followed. Every event is checksummed and synced to disk as it happens. The
+// log holds no key material, and can be read with DB.KeyAuditLog, or ReadKeyAuditLog without
+// opening the DB. It keeps growing, by a few dozen bytes per event, and isn't removed when
+// KeyAuditLog gets unset. It has no effect on a DB which isn't encrypted, or in InMemory mode.
+//
+// The default value of KeyAuditLog is false.
+func (opt Options) WithKeyAuditLog(val bool) Options {
+	opt.KeyAuditLog = val
+	return opt
+}
+
+// WithValueLogArchiver returns a new Options value with ValueLogArchiver set to the given value.
+//
+// ValueLogArchiver is called with every value log file before value log GC deletes it, and the
+// file is only deleted once it returns nil, so that continuous backup and replication systems
+// never miss a segment of the value log. When it fails, value log GC returns its error and keeps
+// the file until a later run. The files deleted by DB.DropAll aren't archived.
+//
+// The default value of ValueLogArchiver is nil.
+func (opt Options) WithValueLogArchiver(val ValueLogArchiver) Options {
+	opt.ValueLogArchiver = val
+	return opt
+}
+
+// WithJobTimelineSize returns a new Options value with JobTimelineSize set to the given value.
+//
+// JobTimelineSize is the number of background jobs the DB keeps in memory for DB.BackgroundJobs
+// and DB.WriteJobTrace: memtable flushes, compactions and value log GC runs, with their durations
+// and the bytes they read and wrote. Once it's reached, every new job replaces the oldest one. 0
+// disables the timeline, the background jobs still having the JobLabel pprof label.
+//
+// The default value of JobTimelineSize is 1000.
+func (opt Options) WithJobTimelineSize(val int) Options {
+	opt.JobTimelineSize = val
+	return opt
+}
+
+// WithRangeDigests returns a new Options value with RangeDigests set to the given value.
+//
+// When RangeDigests is true, compactions compute the digests of the tables they build, which
+// DB.RangeDigest combines instead of reading the tables. The tables having values in the value log
+// don't get one, DB.RangeDigest computing their digests the first time it needs them, as it
+// always does otherwise, and caching them.
+//
+// The default value of RangeDigests is false.
+func (opt Options) WithRangeDigests(val bool) Options {
+	opt.RangeDigests = val
+	return opt
+}
+
+// WithConflictDiagnostics returns a new Options value with ConflictDiagnostics set to the given
+// value.
+//
+// When ConflictDiagnostics is true, the read-write transactions record the keys they read, and the
+// ones failing to commit because of a conflict return a *ConflictError telling which key was
+// written by another transaction in the meantime. Its cause is ErrConflict, so that errors.Cause
+// or errors.Is have to be used to detect the conflicts. It's meant for debugging, as recording the
+// keys costs memory.
+//
+// The default value of ConflictDiagnostics is false.
+func (opt Options) WithConflictDiagnostics(val bool) Options {
+	opt.ConflictDiagnostics = val
+	return opt
+}
+
+// WithMaxDatabaseSize returns a new Options value with MaxDatabaseSize set to the given value.
+//
+// MaxDatabaseSize is a soft quota on the size of the tables and value log files of the DB, in
+// bytes. Once the DB is bigger, setting keys fails with a *DatabaseFullError, whose cause is
+// ErrDatabaseFull, while deleting keys, compactions and value log GC go on, so that the DB can get
+// back under the quota. The writes already accepted still get written, so the DB can exceed the
+// quota by the size of the pending writes and memtables. 0 means no limit.
+//
+// The default value of MaxDatabaseSize is 0.
+func (opt Options) WithMaxDatabaseSize(val int64) Options {
+	opt.MaxDatabaseSize = val
+	return opt
+}
+
+// WithPrefixLimits returns a new Options value with PrefixLimits set to the given value.
+//
+// PrefixLimits limit the sizes of the keys under their prefixes and of their values, and the
+// number of them a transaction can write, beyond MaxKeySize and ValueLogFileSize which apply to
+// every key. They're checked when committing, after PreCommitHook, the transactions breaking one
+// failing with a *PrefixLimitError, whose cause is ErrPrefixLimit.
+//
+// The default value of PrefixLimits is nil.
+func (opt Options) WithPrefixLimits(val []PrefixLimit) Options {
+	opt.PrefixLimits = val
+	return opt
+}
+
+// WithTTLJitter returns a new Options value with TTLJitter set to the given value.
+//
+// TTLJitter pushes back the expiration time of the entries set with one by a random duration of
+// up to TTLJitter percent of their time to live, so that entries mass-inserted with the same TTL
+// don't all expire at once, with the compactions and value log GC they lead to. With a TTLJitter
+// of 10, an entry set with a TTL of an hour expires within 66 minutes. The entries never expire
+// before their TTL, and the expiration time of an Entry gets updated when it's set.
+//
+// The default value of TTLJitter is 0.
+func (opt Options) WithTTLJitter(val float64) Options {
+	opt.TTLJitter = val
+	return opt
+}
@@ -55,3 +55,27 @@ const (
 	// ZSTD mode indicates that a block is compressed using ZSTD algorithm.
 	ZSTD CompressionType = 2
 )
+
+// EncryptionAlgorithm specifies how data keys and SSTable blocks are encrypted.
+type EncryptionAlgorithm uint32
+
+const (
+	// AESCTR encrypts with AES in counter mode. Tampered data decrypts to garbage, undetected.
+	AESCTR EncryptionAlgorithm = 0
+	// AESGCM encrypts with AES-GCM, storing an authentication tag along with the data, which is
+	// verified when decrypting it.
+	AESGCM EncryptionAlgorithm = 1
+)
+
+// ChecksumAlgorithm specifies how SSTable blocks and value log entries are checksummed.
+type ChecksumAlgorithm uint32
+
+const (
+	// CRC32C checksums with CRC-32 using the Castagnoli polynomial, which is hardware accelerated
+	// on most CPUs.
+	CRC32C ChecksumAlgorithm = 0
+	// XXHash64 checksums with xxHash64, which is faster where CRC-32 isn't hardware accelerated.
+	XXHash64 ChecksumAlgorithm = 1
+	// NoChecksum doesn't checksum the data, so corruptions go undetected.
+	NoChecksum ChecksumAlgorithm = 2
+)
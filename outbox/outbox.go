@@ -0,0 +1,313 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package outbox implements the transactional outbox pattern on top of Badger.
+//
+// Records are added to the outbox in the same transaction as the application data they describe,
+// so that either both or none of them get committed. Consumers then read the records in order
+// and get every record delivered at least once, each consumer keeping track of the last record
+// it acknowledged:
+//
+//	ob := outbox.New(db, []byte("outbox/"))
+//	err := db.Update(func(txn *badger.Txn) error {
+//		if err := txn.Set(orderKey, order); err != nil {
+//			return err
+//		}
+//		_, err := ob.Put(txn, orderCreatedEvent)
+//		return err
+//	})
+//	...
+//	err = ob.Consume(ctx, "publisher", func(rec outbox.Record) error {
+//		return publish(rec.Payload)
+//	})
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// Key layout, under the outbox prefix.
+const (
+	seqTag    = 's' // Sequence number of the last record added.
+	recordTag = 'r' // Records, followed by their big-endian sequence number.
+	ackTag    = 'a' // Sequence number of the last record acknowledged, followed by the consumer.
+	probeTag  = 'p' // Written by Consume to tell when it's subscribed, followed by the consumer.
+)
+
+// Record is a record of an outbox.
+type Record struct {
+	// Seq is the sequence number of the record. Records are numbered from 1, in commit order.
+	Seq     uint64
+	Payload []byte
+}
+
+// Outbox is an outbox keeping its records under a key prefix.
+type Outbox struct {
+	db     *badger.DB
+	prefix []byte
+}
+
+// New returns the outbox keeping its records in db under prefix. The prefix must not be used for
+// anything else, and must not be a prefix of another outbox's prefix.
+func New(db *badger.DB, prefix []byte) *Outbox {
+	return &Outbox{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+func (o *Outbox) key(tag byte, suffix ...byte) []byte {
+	key := make([]byte, 0, len(o.prefix)+1+len(suffix))
+	key = append(key, o.prefix...)
+	key = append(key, tag)
+	return append(key, suffix...)
+}
+
+func (o *Outbox) recordKey(seq uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	return o.key(recordTag, buf[:]...)
+}
+
+// getUint64 reads the big-endian uint64 stored at key, 0 if there's none.
+func getUint64(txn *badger.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return errors.Errorf("Invalid value of size %d for key %q", len(val), key)
+		}
+		v = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return v, err
+}
+
+func setUint64(txn *badger.Txn, key []byte, v uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return txn.Set(key, buf)
+}
+
+// Put adds a record with the given payload to the outbox, as part of txn. The record only becomes
+// visible to consumers once txn is committed. It returns the sequence number of the record.
+//
+// Records get their sequence numbers from a counter updated by every Put, so that consumers never
+// see a record committed after one with a higher sequence number. The downside is that concurrent
+// transactions adding records conflict with each other, and all but one of them fail to commit
+// with badger.ErrConflict. They should be retried.
+func (o *Outbox) Put(txn *badger.Txn, payload []byte) (uint64, error) {
+	seqKey := o.key(seqTag)
+	seq, err := getUint64(txn, seqKey)
+	if err != nil {
+		return 0, errors.Wrap(err, "while reading outbox sequence")
+	}
+	seq++
+	if err := setUint64(txn, seqKey, seq); err != nil {
+		return 0, err
+	}
+	if err := txn.Set(o.recordKey(seq), payload); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Acked returns the sequence number of the last record acknowledged by consumer, or 0 if it
+// didn't acknowledge any record yet.
+func (o *Outbox) Acked(consumer string) (uint64, error) {
+	var seq uint64
+	err := o.db.View(func(txn *badger.Txn) error {
+		var err error
+		seq, err = getUint64(txn, o.key(ackTag, []byte(consumer)...))
+		return err
+	})
+	return seq, err
+}
+
+// Ack records that consumer is done with all the records up to seq. Consume acknowledges the
+// records it delivers by itself, Ack is useful for consumers reading records with Next.
+func (o *Outbox) Ack(consumer string, seq uint64) error {
+	return o.db.Update(func(txn *badger.Txn) error {
+		return setUint64(txn, o.key(ackTag, []byte(consumer)...), seq)
+	})
+}
+
+// Next returns up to max records following the last record acknowledged by consumer, in order.
+func (o *Outbox) Next(consumer string, max int) ([]Record, error) {
+	var recs []Record
+	err := o.db.View(func(txn *badger.Txn) error {
+		acked, err := getUint64(txn, o.key(ackTag, []byte(consumer)...))
+		if err != nil {
+			return err
+		}
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = o.key(recordTag)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(o.recordKey(acked + 1)); it.Valid() && len(recs) < max; it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			seq := binary.BigEndian.Uint64(item.Key()[len(opts.Prefix):])
+			recs = append(recs, Record{Seq: seq, Payload: val})
+		}
+		return nil
+	})
+	return recs, err
+}
+
+// consumeBatchSize is the number of records read at once by Consume.
+const consumeBatchSize = 100
+
+// Consume calls fn with every record following the last one acknowledged by consumer, in order,
+// and then with every new record as they get committed, until ctx is done or fn returns an error.
+// A record is acknowledged once fn returns nil for it. Consume returns the error of fn, and the
+// record it failed on is delivered again by the next call.
+//
+// Delivery is at least once: if the process stops after fn returned but before the record got
+// acknowledged, the record is delivered again. Only one Consume call per consumer should run at
+// a time.
+func (o *Outbox) Consume(ctx context.Context, consumer string, fn func(Record) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribe before reading the records, so that no record committed in between is missed.
+	// The subscription only gets registered once Subscribe runs, so Consume writes a probe key
+	// and keeps reading the records until the subscription sees the probe.
+	probe := o.key(probeTag, []byte(consumer)...)
+	wake := make(chan struct{}, 1)
+	subscribed := make(chan struct{})
+	var once sync.Once
+	subErr := make(chan error, 1)
+	go func() {
+		subErr <- o.db.Subscribe(ctx, func(kvs *badger.KVList) error {
+			for _, kv := range kvs.Kv {
+				if bytes.Equal(kv.Key, probe) {
+					once.Do(func() { close(subscribed) })
+				}
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+			return nil
+		}, o.key(recordTag), probe)
+	}()
+
+	for {
+		recs, err := o.Next(consumer, consumeBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err := fn(rec); err != nil {
+				return err
+			}
+			if err := o.Ack(consumer, rec.Seq); err != nil {
+				return errors.Wrapf(err, "while acknowledging record %d", rec.Seq)
+			}
+		}
+		if len(recs) == consumeBatchSize {
+			continue
+		}
+		var retry <-chan time.Time
+		if subscribed != nil {
+			err := o.db.Update(func(txn *badger.Txn) error {
+				return txn.Set(probe, nil)
+			})
+			if err != nil {
+				return errors.Wrap(err, "while writing outbox probe")
+			}
+			retry = time.After(10 * time.Millisecond)
+		}
+		select {
+		case <-wake:
+		case <-subscribed:
+			// Records committed from now on wake Consume up, read the ones committed before.
+			subscribed = nil
+		case <-retry:
+		case err := <-subErr:
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return errors.Wrap(err, "while subscribing to outbox records")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Trim deletes the records acknowledged by all the given consumers, and returns the number of
+// records deleted.
+func (o *Outbox) Trim(consumers ...string) (int, error) {
+	if len(consumers) == 0 {
+		return 0, errors.New("At least one consumer is needed to trim an outbox")
+	}
+	var upTo uint64
+	for i, consumer := range consumers {
+		acked, err := o.Acked(consumer)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || acked < upTo {
+			upTo = acked
+		}
+	}
+
+	var keys [][]byte
+	err := o.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = o.key(recordTag)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if binary.BigEndian.Uint64(key[len(opts.Prefix):]) > upTo {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := o.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
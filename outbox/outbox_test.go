@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, db *badger.DB, ob *Outbox, payload string) uint64 {
+	var seq uint64
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("data/"+payload), nil); err != nil {
+			return err
+		}
+		var err error
+		seq, err = ob.Put(txn, []byte(payload))
+		return err
+	}))
+	return seq
+}
+
+func TestOutboxPutNextAck(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ob := New(db, []byte("outbox/"))
+
+	for i := 1; i <= 5; i++ {
+		require.Equal(t, uint64(i), put(t, db, ob, fmt.Sprintf("rec%d", i)))
+	}
+	// Records of a discarded transaction don't exist.
+	txn := db.NewTransaction(true)
+	_, err := ob.Put(txn, []byte("discarded"))
+	require.NoError(t, err)
+	txn.Discard()
+
+	recs, err := ob.Next("c", 3)
+	require.NoError(t, err)
+	require.Equal(t, []Record{
+		{Seq: 1, Payload: []byte("rec1")},
+		{Seq: 2, Payload: []byte("rec2")},
+		{Seq: 3, Payload: []byte("rec3")},
+	}, recs)
+
+	require.NoError(t, ob.Ack("c", 3))
+	acked, err := ob.Acked("c")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), acked)
+	recs, err = ob.Next("c", 10)
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	require.Equal(t, uint64(4), recs[0].Seq)
+
+	// Records are only trimmed once acknowledged by all the consumers.
+	n, err := ob.Trim("c", "other")
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	n, err = ob.Trim("c")
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	recs, err = ob.Next("other", 10)
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	require.Equal(t, uint64(4), recs[0].Seq)
+}
+
+func TestOutboxConsume(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ob := New(db, []byte("outbox/"))
+	for i := 1; i <= 3; i++ {
+		put(t, db, ob, fmt.Sprintf("rec%d", i))
+	}
+
+	// A failing callback stops Consume, and the record is delivered again by the next call.
+	errFail := errors.New("fail")
+	var got []uint64
+	err := ob.Consume(context.Background(), "c", func(rec Record) error {
+		if rec.Seq == 2 {
+			return errFail
+		}
+		got = append(got, rec.Seq)
+		return nil
+	})
+	require.Equal(t, errFail, err)
+	require.Equal(t, []uint64{1}, got)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recCh := make(chan uint64, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ob.Consume(ctx, "c", func(rec Record) error {
+			recCh <- rec.Seq
+			return nil
+		})
+	}()
+	for seq := uint64(2); seq <= 3; seq++ {
+		require.Equal(t, seq, <-recCh)
+	}
+	// Records committed while consuming get delivered.
+	put(t, db, ob, "rec4")
+	select {
+	case seq := <-recCh:
+		require.Equal(t, uint64(4), seq)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Record not delivered")
+	}
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+
+	// Consume acknowledges a record before waiting for the next ones.
+	acked, err := ob.Acked("c")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), acked)
+}
+
+func TestOutboxConsumeRightAway(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	ob := New(db, []byte("outbox/"))
+
+	// Records committed while Consume subscribes get delivered.
+	for i := 1; i <= 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		recCh := make(chan uint64, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- ob.Consume(ctx, "c", func(rec Record) error {
+				recCh <- rec.Seq
+				return nil
+			})
+		}()
+		put(t, db, ob, fmt.Sprintf("rec%d", i))
+		select {
+		case seq := <-recCh:
+			require.Equal(t, uint64(i), seq)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Record %d not delivered", i)
+		}
+		cancel()
+		require.Equal(t, context.Canceled, <-errCh)
+	}
+}
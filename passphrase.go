@@ -0,0 +1,124 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The key registry of a DB encrypted with a passphrase starts with the parameters of the Argon2id
+// derivation of the master key:
+//
+// | Salt (16B) | Time (4B) | Memory (4B) | Threads (1B) | CRC (4B) |
+//
+// followed by the usual IV and sanity text, encrypted with the derived key. The parameters are
+// read back from the file, so they can be changed for new DBs without breaking the existing ones.
+//
+// In FIPS mode, the master key is derived with PBKDF2-HMAC-SHA256 instead, which is recorded as
+// a Memory and Threads of 0, Time being the number of iterations.
+const (
+	passphraseSaltSize   = 16
+	passphraseHeaderSize = 29
+	passphraseKeySize    = 32
+)
+
+// passphraseParams are the Argon2id parameters of new key registries, the ones recommended by
+// RFC 9106 for memory constrained environments. Tests lower them.
+var passphraseParams = passphraseKDF{time: 3, memory: 64 << 10, threads: 4}
+
+// fipsPassphraseParams are the PBKDF2 parameters of new key registries in FIPS mode, the number of
+// iterations recommended by OWASP for PBKDF2-HMAC-SHA256. Tests lower them.
+var fipsPassphraseParams = passphraseKDF{time: 600000}
+
+// passphraseKDF holds the parameters deriving the master key from a passphrase.
+type passphraseKDF struct {
+	salt    [passphraseSaltSize]byte
+	time    uint32
+	memory  uint32 // In KiB.
+	threads uint8
+}
+
+// newPassphraseKDF returns the parameters of a new key registry, with a random salt. fips selects
+// PBKDF2 rather than Argon2id.
+func newPassphraseKDF(fips bool) (*passphraseKDF, error) {
+	kdf := passphraseParams
+	if fips {
+		kdf = fipsPassphraseParams
+	}
+	if _, err := rand.Read(kdf.salt[:]); err != nil {
+		return nil, errors.Wrap(err, "Error while generating passphrase salt")
+	}
+	return &kdf, nil
+}
+
+// readPassphraseKDF reads the parameters at the start of a key registry.
+func readPassphraseKDF(r io.Reader) (*passphraseKDF, error) {
+	var buf [passphraseHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, y.Wrapf(err, "Error while reading passphrase parameters of key registry.")
+	}
+	if crc32.Checksum(buf[:25], y.CastagnoliCrcTable) != binary.BigEndian.Uint32(buf[25:]) {
+		// Likely a key registry which isn't encrypted with a passphrase.
+		return nil, y.Wrapf(ErrEncryptionKeyMismatch, "Key registry has no passphrase parameters")
+	}
+	kdf := &passphraseKDF{
+		time:    binary.BigEndian.Uint32(buf[16:20]),
+		memory:  binary.BigEndian.Uint32(buf[20:24]),
+		threads: buf[24],
+	}
+	copy(kdf.salt[:], buf[:16])
+	if kdf.time == 0 || (kdf.threads == 0) != (kdf.memory == 0) {
+		return nil, errors.Errorf("Invalid passphrase parameters in key registry: time %d, "+
+			"threads %d", kdf.time, kdf.threads)
+	}
+	return kdf, nil
+}
+
+// pbkdf2 tells whether the master key is derived with PBKDF2, the FIPS-approved KDF.
+func (kdf *passphraseKDF) pbkdf2() bool {
+	return kdf.threads == 0 && kdf.memory == 0
+}
+
+// encode returns the parameters as stored at the start of the key registry.
+func (kdf *passphraseKDF) encode() []byte {
+	buf := make([]byte, passphraseHeaderSize)
+	copy(buf, kdf.salt[:])
+	binary.BigEndian.PutUint32(buf[16:20], kdf.time)
+	binary.BigEndian.PutUint32(buf[20:24], kdf.memory)
+	buf[24] = kdf.threads
+	binary.BigEndian.PutUint32(buf[25:], crc32.Checksum(buf[:25], y.CastagnoliCrcTable))
+	return buf
+}
+
+// deriveKey returns the 32 bytes master key derived from passphrase.
+func (kdf *passphraseKDF) deriveKey(passphrase string) []byte {
+	if kdf.pbkdf2() {
+		return pbkdf2.Key([]byte(passphrase), kdf.salt[:], int(kdf.time), passphraseKeySize,
+			sha256.New)
+	}
+	return argon2.IDKey([]byte(passphrase), kdf.salt[:], kdf.time, kdf.memory, kdf.threads,
+		passphraseKeySize)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionPassphrase(t *testing.T) {
+	// Keep the derivation cheap, the parameters are read back from the key registry anyway.
+	defer func(p passphraseKDF) { passphraseParams = p }(passphraseParams)
+	passphraseParams = passphraseKDF{time: 1, memory: 64, threads: 1}
+
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithEncryptionPassphrase("correct horse battery staple")
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.True(t, db.EncryptionInfo().Encrypted)
+	txnSet(t, db, []byte("key"), []byte("value"), 0)
+	require.NoError(t, db.Close())
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, KeyRegistryFileName))
+	require.NoError(t, err)
+	kdf, err := readPassphraseKDF(bytes.NewReader(buf))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), kdf.time)
+	require.Equal(t, uint32(64), kdf.memory)
+
+	// New parameters only apply to new key registries.
+	passphraseParams = passphraseKDF{time: 2, memory: 128, threads: 1}
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("key"))
+		if err != nil {
+			return err
+		}
+		require.Equal(t, []byte("value"), getItemValue(t, item))
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	for _, other := range []Options{
+		opt.WithEncryptionPassphrase("wrong passphrase"),
+		opt.WithEncryptionPassphrase("").WithEncryptionKey(bytes.Repeat([]byte("k"), 32)),
+		opt.WithEncryptionPassphrase(""),
+	} {
+		_, err = Open(other)
+		require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+	}
+
+	_, err = Open(opt.WithEncryptionKey(bytes.Repeat([]byte("k"), 32)))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "EncryptionPassphrase cannot be set")
+
+	err = VerifyEncryptionKey(dir, bytes.Repeat([]byte("k"), 32))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "protected by a passphrase")
+
+	// The read-only mode reads the parameters as well.
+	db, err = Open(opt.WithReadOnly(true))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestEncryptionPassphraseInMemory(t *testing.T) {
+	defer func(p passphraseKDF) { passphraseParams = p }(passphraseParams)
+	passphraseParams = passphraseKDF{time: 1, memory: 64, threads: 1}
+
+	opt := DefaultOptions("").WithInMemory(true).WithEncryptionPassphrase("passphrase")
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	txnSet(t, db, []byte("key"), []byte("value"), 0)
+	require.Len(t, db.registry.opt.EncryptionKey, passphraseKeySize)
+}
@@ -25,15 +25,18 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type EncryptionAlgo int32
 
 const (
-	EncryptionAlgo_aes EncryptionAlgo = 0
+	EncryptionAlgo_aes     EncryptionAlgo = 0
+	EncryptionAlgo_aes_gcm EncryptionAlgo = 1
 )
 
 var EncryptionAlgo_name = map[int32]string{
 	0: "aes",
+	1: "aes_gcm",
 }
 
 var EncryptionAlgo_value = map[string]int32{
-	"aes": 0,
+	"aes":     0,
+	"aes_gcm": 1,
 }
 
 func (x EncryptionAlgo) String() string {
@@ -47,18 +50,24 @@ func (EncryptionAlgo) EnumDescriptor() ([]byte, []int) {
 type ManifestChange_Operation int32
 
 const (
-	ManifestChange_CREATE ManifestChange_Operation = 0
-	ManifestChange_DELETE ManifestChange_Operation = 1
+	ManifestChange_CREATE      ManifestChange_Operation = 0
+	ManifestChange_DELETE      ManifestChange_Operation = 1
+	ManifestChange_CREATE_VLOG ManifestChange_Operation = 2
+	ManifestChange_DELETE_VLOG ManifestChange_Operation = 3
 )
 
 var ManifestChange_Operation_name = map[int32]string{
 	0: "CREATE",
 	1: "DELETE",
+	2: "CREATE_VLOG",
+	3: "DELETE_VLOG",
 }
 
 var ManifestChange_Operation_value = map[string]int32{
-	"CREATE": 0,
-	"DELETE": 1,
+	"CREATE":      0,
+	"DELETE":      1,
+	"CREATE_VLOG": 2,
+	"DELETE_VLOG": 3,
 }
 
 func (x ManifestChange_Operation) String() string {
@@ -74,16 +83,19 @@ type Checksum_Algorithm int32
 const (
 	Checksum_CRC32C   Checksum_Algorithm = 0
 	Checksum_XXHash64 Checksum_Algorithm = 1
+	Checksum_NONE     Checksum_Algorithm = 2
 )
 
 var Checksum_Algorithm_name = map[int32]string{
 	0: "CRC32C",
 	1: "XXHash64",
+	2: "NONE",
 }
 
 var Checksum_Algorithm_value = map[string]int32{
 	"CRC32C":   0,
 	"XXHash64": 1,
+	"NONE":     2,
 }
 
 func (x Checksum_Algorithm) String() string {
@@ -94,6 +106,34 @@ func (Checksum_Algorithm) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_f80abaa17e25ccc8, []int{6, 0}
 }
 
+type DataKey_Purpose int32
+
+const (
+	DataKey_ANY   DataKey_Purpose = 0
+	DataKey_TABLE DataKey_Purpose = 1
+	DataKey_VLOG  DataKey_Purpose = 2
+)
+
+var DataKey_Purpose_name = map[int32]string{
+	0: "ANY",
+	1: "TABLE",
+	2: "VLOG",
+}
+
+var DataKey_Purpose_value = map[string]int32{
+	"ANY":   0,
+	"TABLE": 1,
+	"VLOG":  2,
+}
+
+func (x DataKey_Purpose) String() string {
+	return proto.EnumName(DataKey_Purpose_name, int32(x))
+}
+
+func (DataKey_Purpose) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_f80abaa17e25ccc8, []int{7, 0}
+}
+
 type KV struct {
 	Key       []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
 	Value     []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
@@ -301,6 +341,8 @@ type ManifestChange struct {
 	KeyId                uint64                   `protobuf:"varint,4,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
 	EncryptionAlgo       EncryptionAlgo           `protobuf:"varint,5,opt,name=encryption_algo,json=encryptionAlgo,proto3,enum=pb.EncryptionAlgo" json:"encryption_algo,omitempty"`
 	Compression          uint32                   `protobuf:"varint,6,opt,name=compression,proto3" json:"compression,omitempty"`
+	Sha256               []byte                   `protobuf:"bytes,7,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	ValuesOnly           bool                     `protobuf:"varint,8,opt,name=values_only,json=valuesOnly,proto3" json:"values_only,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}                 `json:"-"`
 	XXX_unrecognized     []byte                   `json:"-"`
 	XXX_sizecache        int32                    `json:"-"`
@@ -381,6 +423,20 @@ func (m *ManifestChange) GetCompression() uint32 {
 	return 0
 }
 
+func (m *ManifestChange) GetSha256() []byte {
+	if m != nil {
+		return m.Sha256
+	}
+	return nil
+}
+
+func (m *ManifestChange) GetValuesOnly() bool {
+	if m != nil {
+		return m.ValuesOnly
+	}
+	return false
+}
+
 type BlockOffset struct {
 	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
 	Offset               uint32   `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
@@ -448,6 +504,7 @@ type TableIndex struct {
 	Offsets              []*BlockOffset `protobuf:"bytes,1,rep,name=offsets,proto3" json:"offsets,omitempty"`
 	BloomFilter          []byte         `protobuf:"bytes,2,opt,name=bloom_filter,json=bloomFilter,proto3" json:"bloom_filter,omitempty"`
 	EstimatedSize        uint64         `protobuf:"varint,3,opt,name=estimated_size,json=estimatedSize,proto3" json:"estimated_size,omitempty"`
+	FormatVersion        uint32         `protobuf:"varint,4,opt,name=format_version,json=formatVersion,proto3" json:"format_version,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
 	XXX_unrecognized     []byte         `json:"-"`
 	XXX_sizecache        int32          `json:"-"`
@@ -507,6 +564,13 @@ func (m *TableIndex) GetEstimatedSize() uint64 {
 	return 0
 }
 
+func (m *TableIndex) GetFormatVersion() uint32 {
+	if m != nil {
+		return m.FormatVersion
+	}
+	return 0
+}
+
 type Checksum struct {
 	Algo                 Checksum_Algorithm `protobuf:"varint,1,opt,name=algo,proto3,enum=pb.Checksum_Algorithm" json:"algo,omitempty"`
 	Sum                  uint64             `protobuf:"varint,2,opt,name=sum,proto3" json:"sum,omitempty"`
@@ -563,13 +627,17 @@ func (m *Checksum) GetSum() uint64 {
 }
 
 type DataKey struct {
-	KeyId                uint64   `protobuf:"varint,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
-	Data                 []byte   `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
-	Iv                   []byte   `protobuf:"bytes,3,opt,name=iv,proto3" json:"iv,omitempty"`
-	CreatedAt            int64    `protobuf:"varint,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	KeyId                uint64          `protobuf:"varint,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Data                 []byte          `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Iv                   []byte          `protobuf:"bytes,3,opt,name=iv,proto3" json:"iv,omitempty"`
+	CreatedAt            int64           `protobuf:"varint,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Purpose              DataKey_Purpose `protobuf:"varint,5,opt,name=purpose,proto3,enum=pb.DataKey_Purpose" json:"purpose,omitempty"`
+	Algo                 EncryptionAlgo  `protobuf:"varint,6,opt,name=algo,proto3,enum=pb.EncryptionAlgo" json:"algo,omitempty"`
+	Prefix               []byte          `protobuf:"bytes,7,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	WrappingKey          string          `protobuf:"bytes,8,opt,name=wrapping_key,json=wrappingKey,proto3" json:"wrapping_key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
 }
 
 func (m *DataKey) Reset()         { *m = DataKey{} }
@@ -633,10 +701,39 @@ func (m *DataKey) GetCreatedAt() int64 {
 	return 0
 }
 
+func (m *DataKey) GetPurpose() DataKey_Purpose {
+	if m != nil {
+		return m.Purpose
+	}
+	return DataKey_ANY
+}
+
+func (m *DataKey) GetAlgo() EncryptionAlgo {
+	if m != nil {
+		return m.Algo
+	}
+	return EncryptionAlgo_aes
+}
+
+func (m *DataKey) GetPrefix() []byte {
+	if m != nil {
+		return m.Prefix
+	}
+	return nil
+}
+
+func (m *DataKey) GetWrappingKey() string {
+	if m != nil {
+		return m.WrappingKey
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("pb.EncryptionAlgo", EncryptionAlgo_name, EncryptionAlgo_value)
 	proto.RegisterEnum("pb.ManifestChange_Operation", ManifestChange_Operation_name, ManifestChange_Operation_value)
 	proto.RegisterEnum("pb.Checksum_Algorithm", Checksum_Algorithm_name, Checksum_Algorithm_value)
+	proto.RegisterEnum("pb.DataKey_Purpose", DataKey_Purpose_name, DataKey_Purpose_value)
 	proto.RegisterType((*KV)(nil), "pb.KV")
 	proto.RegisterType((*KVList)(nil), "pb.KVList")
 	proto.RegisterType((*ManifestChangeSet)(nil), "pb.ManifestChangeSet")
@@ -650,48 +747,59 @@ func init() {
 func init() { proto.RegisterFile("pb.proto", fileDescriptor_f80abaa17e25ccc8) }
 
 var fileDescriptor_f80abaa17e25ccc8 = []byte{
-	// 653 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0xcf, 0x6e, 0xda, 0x4e,
-	0x10, 0x66, 0x8d, 0x63, 0x60, 0x08, 0x84, 0xdf, 0xea, 0xd7, 0xc8, 0x55, 0x5b, 0x4a, 0x5d, 0x45,
-	0xa2, 0x51, 0xc4, 0x21, 0xa9, 0x7a, 0xe9, 0x89, 0x10, 0xaa, 0x22, 0x12, 0x21, 0x6d, 0xa2, 0x28,
-	0x37, 0xb4, 0xe0, 0x21, 0x58, 0xf8, 0x9f, 0xbc, 0x8b, 0x15, 0x72, 0xeb, 0x5b, 0xf4, 0x91, 0x7a,
-	0xec, 0xa1, 0x0f, 0x50, 0xa5, 0x0f, 0xd2, 0x6a, 0xd7, 0x06, 0x81, 0xda, 0xdb, 0xcc, 0xf7, 0xcd,
-	0xee, 0xe7, 0xf9, 0x66, 0xd6, 0x50, 0x8e, 0x27, 0x9d, 0x38, 0x89, 0x64, 0x44, 0x8d, 0x78, 0xe2,
-	0xfc, 0x20, 0x60, 0x0c, 0x6f, 0x69, 0x03, 0x8a, 0x0b, 0x5c, 0xd9, 0xa4, 0x45, 0xda, 0xfb, 0x4c,
-	0x85, 0xf4, 0x7f, 0xd8, 0x4b, 0xb9, 0xbf, 0x44, 0xdb, 0xd0, 0x58, 0x96, 0xd0, 0x17, 0x50, 0x59,
-	0x0a, 0x4c, 0xc6, 0x01, 0x4a, 0x6e, 0x17, 0x35, 0x53, 0x56, 0xc0, 0x15, 0x4a, 0x4e, 0x6d, 0x28,
-	0xa5, 0x98, 0x08, 0x2f, 0x0a, 0x6d, 0xb3, 0x45, 0xda, 0x26, 0x5b, 0xa7, 0xf4, 0x15, 0x00, 0x3e,
-	0xc4, 0x5e, 0x82, 0x62, 0xcc, 0xa5, 0xbd, 0xa7, 0xc9, 0x4a, 0x8e, 0x74, 0x25, 0xa5, 0x60, 0xea,
-	0x0b, 0x2d, 0x7d, 0xa1, 0x8e, 0x95, 0x92, 0x90, 0x09, 0xf2, 0x60, 0xec, 0xb9, 0x36, 0xb4, 0x48,
-	0xbb, 0xc6, 0xca, 0x19, 0x30, 0x70, 0xe9, 0x6b, 0xa8, 0xe6, 0xa4, 0x1b, 0x85, 0x68, 0x57, 0x5b,
-	0xa4, 0x5d, 0x66, 0x90, 0x41, 0x17, 0x51, 0x88, 0x4e, 0x0b, 0xac, 0xe1, 0xed, 0xa5, 0x27, 0x24,
-	0x3d, 0x04, 0x63, 0x91, 0xda, 0xa4, 0x55, 0x6c, 0x57, 0x4f, 0xad, 0x4e, 0x3c, 0xe9, 0x0c, 0x6f,
-	0x99, 0xb1, 0x48, 0x9d, 0x2e, 0xfc, 0x77, 0xc5, 0x43, 0x6f, 0x86, 0x42, 0xf6, 0xe6, 0x3c, 0xbc,
-	0xc7, 0x6b, 0x94, 0xf4, 0x04, 0x4a, 0x53, 0x9d, 0x88, 0xfc, 0x04, 0x55, 0x27, 0x76, 0xeb, 0xd8,
-	0xba, 0xc4, 0xf9, 0x4d, 0xa0, 0xbe, 0xcb, 0xd1, 0x3a, 0x18, 0x03, 0x57, 0xdb, 0x68, 0x32, 0x63,
-	0xe0, 0xd2, 0x13, 0x30, 0x46, 0xb1, 0xb6, 0xb0, 0x7e, 0xfa, 0xf2, 0xef, 0xbb, 0x3a, 0xa3, 0x18,
-	0x13, 0x2e, 0xbd, 0x28, 0x64, 0xc6, 0x28, 0x56, 0x9e, 0x5f, 0x62, 0x8a, 0xbe, 0x76, 0xb6, 0xc6,
-	0xb2, 0x84, 0x3e, 0x03, 0x6b, 0x81, 0x2b, 0x65, 0x43, 0xe6, 0xea, 0xde, 0x02, 0x57, 0x03, 0x97,
-	0x7e, 0x84, 0x03, 0x0c, 0xa7, 0xc9, 0x2a, 0x56, 0xc7, 0xc7, 0xdc, 0xbf, 0x8f, 0xb4, 0xb1, 0xf5,
-	0xec, 0x9b, 0xfb, 0x1b, 0xaa, 0xeb, 0xdf, 0x47, 0xac, 0x8e, 0x3b, 0x39, 0x6d, 0x41, 0x75, 0x1a,
-	0x05, 0x71, 0x82, 0x42, 0x8f, 0xcb, 0xd2, 0x7a, 0xdb, 0x90, 0xf3, 0x16, 0x2a, 0x9b, 0x8f, 0xa3,
-	0x00, 0x56, 0x8f, 0xf5, 0xbb, 0x37, 0xfd, 0x46, 0x41, 0xc5, 0x17, 0xfd, 0xcb, 0xfe, 0x4d, 0xbf,
-	0x41, 0x9c, 0x01, 0x54, 0xcf, 0xfd, 0x68, 0xba, 0x18, 0xcd, 0x66, 0x02, 0xe5, 0x3f, 0xb6, 0xe8,
-	0x10, 0xac, 0x48, 0x73, 0xda, 0x83, 0x1a, 0xcb, 0x33, 0x55, 0xe9, 0x63, 0x98, 0xf7, 0xa9, 0x42,
-	0xe7, 0x0b, 0x01, 0xb8, 0xe1, 0x13, 0x1f, 0x07, 0xa1, 0x8b, 0x0f, 0xf4, 0x1d, 0x94, 0xb2, 0xd2,
-	0xf5, 0x24, 0x0e, 0x54, 0x57, 0x5b, 0x62, 0x6c, 0xcd, 0xd3, 0x37, 0xb0, 0x3f, 0xf1, 0xa3, 0x28,
-	0x18, 0xcf, 0x3c, 0x5f, 0x62, 0x92, 0x2f, 0x6c, 0x55, 0x63, 0x9f, 0x34, 0x44, 0x8f, 0xa0, 0x8e,
-	0x42, 0x7a, 0x01, 0x97, 0xe8, 0x8e, 0x85, 0xf7, 0x88, 0x5a, 0xd9, 0x64, 0xb5, 0x0d, 0x7a, 0xed,
-	0x3d, 0xa2, 0x13, 0x41, 0xb9, 0x37, 0xc7, 0xe9, 0x42, 0x2c, 0x03, 0x7a, 0x0c, 0xa6, 0xf6, 0x94,
-	0x68, 0x4f, 0x0f, 0x95, 0xfa, 0x9a, 0xeb, 0x28, 0x0b, 0x13, 0x4f, 0xce, 0x03, 0xa6, 0x6b, 0x54,
-	0x37, 0x62, 0x19, 0x68, 0x61, 0x93, 0xa9, 0xd0, 0x39, 0x82, 0xca, 0xa6, 0x28, 0x73, 0xaf, 0x77,
-	0x76, 0xda, 0x6b, 0x14, 0xe8, 0x3e, 0x94, 0xef, 0xee, 0x3e, 0x73, 0x31, 0xff, 0xf0, 0xbe, 0x41,
-	0x9c, 0x29, 0x94, 0x2e, 0xb8, 0xe4, 0x43, 0x5c, 0x6d, 0x4d, 0x99, 0x6c, 0x4f, 0x99, 0x82, 0xe9,
-	0x72, 0xc9, 0xf3, 0xa6, 0x74, 0xac, 0x96, 0xcc, 0x4b, 0xf3, 0xd7, 0x67, 0x78, 0xa9, 0x7a, 0x5d,
-	0xd3, 0x04, 0x75, 0x6f, 0x5c, 0xea, 0x25, 0x29, 0xb2, 0x4a, 0x8e, 0x74, 0xe5, 0xf1, 0x73, 0xa8,
-	0xef, 0x6e, 0x03, 0x2d, 0x41, 0x91, 0xa3, 0x68, 0x14, 0xce, 0x1b, 0xdf, 0x9e, 0x9a, 0xe4, 0xfb,
-	0x53, 0x93, 0xfc, 0x7c, 0x6a, 0x92, 0xaf, 0xbf, 0x9a, 0x85, 0x89, 0xa5, 0x7f, 0x0d, 0x67, 0x7f,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x8f, 0xf6, 0xa8, 0xfe, 0x26, 0x04, 0x00, 0x00,
+	// 820 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x54, 0x4d, 0x8f, 0xe3, 0x44,
+	0x10, 0x8d, 0x9d, 0x8c, 0x93, 0x54, 0x26, 0x19, 0xd3, 0x40, 0x64, 0x09, 0x18, 0x82, 0x25, 0x96,
+	0xb0, 0x5a, 0x82, 0x34, 0x0b, 0x7b, 0xe1, 0x94, 0xc9, 0x18, 0x88, 0x92, 0x9d, 0xa0, 0xde, 0x51,
+	0xb4, 0x9c, 0xac, 0x4e, 0x5c, 0x49, 0xac, 0xf8, 0x4b, 0xee, 0x4e, 0x18, 0xcf, 0x2f, 0xe1, 0xc4,
+	0x99, 0x9f, 0xc2, 0x91, 0x03, 0x27, 0x4e, 0x68, 0xf8, 0x23, 0xa8, 0xbb, 0x9d, 0x28, 0x23, 0xe0,
+	0x56, 0xf5, 0x5e, 0xb9, 0xab, 0xeb, 0xd5, 0x6b, 0x43, 0x23, 0x5b, 0x0c, 0xb2, 0x3c, 0x15, 0x29,
+	0x31, 0xb3, 0x85, 0xfb, 0x87, 0x01, 0xe6, 0x64, 0x4e, 0x6c, 0xa8, 0x6e, 0xb1, 0x70, 0x8c, 0x9e,
+	0xd1, 0x3f, 0xa7, 0x32, 0x24, 0xef, 0xc1, 0xd9, 0x9e, 0x45, 0x3b, 0x74, 0x4c, 0x85, 0xe9, 0x84,
+	0x7c, 0x00, 0xcd, 0x1d, 0xc7, 0xdc, 0x8f, 0x51, 0x30, 0xa7, 0xaa, 0x98, 0x86, 0x04, 0x5e, 0xa3,
+	0x60, 0xc4, 0x81, 0xfa, 0x1e, 0x73, 0x1e, 0xa6, 0x89, 0x53, 0xeb, 0x19, 0xfd, 0x1a, 0x3d, 0xa4,
+	0xe4, 0x23, 0x00, 0xbc, 0xcf, 0xc2, 0x1c, 0xb9, 0xcf, 0x84, 0x73, 0xa6, 0xc8, 0x66, 0x89, 0x0c,
+	0x05, 0x21, 0x50, 0x53, 0x07, 0x5a, 0xea, 0x40, 0x15, 0xcb, 0x4e, 0x5c, 0xe4, 0xc8, 0x62, 0x3f,
+	0x0c, 0x1c, 0xe8, 0x19, 0xfd, 0x36, 0x6d, 0x68, 0x60, 0x1c, 0x90, 0x8f, 0xa1, 0x55, 0x92, 0x41,
+	0x9a, 0xa0, 0xd3, 0xea, 0x19, 0xfd, 0x06, 0x05, 0x0d, 0xdd, 0xa4, 0x09, 0xba, 0x3d, 0xb0, 0x26,
+	0xf3, 0x69, 0xc8, 0x05, 0xe9, 0x82, 0xb9, 0xdd, 0x3b, 0x46, 0xaf, 0xda, 0x6f, 0x5d, 0x59, 0x83,
+	0x6c, 0x31, 0x98, 0xcc, 0xa9, 0xb9, 0xdd, 0xbb, 0x43, 0x78, 0xe7, 0x35, 0x4b, 0xc2, 0x15, 0x72,
+	0x31, 0xda, 0xb0, 0x64, 0x8d, 0x6f, 0x50, 0x90, 0x17, 0x50, 0x5f, 0xaa, 0x84, 0x97, 0x5f, 0x10,
+	0xf9, 0xc5, 0xd3, 0x3a, 0x7a, 0x28, 0x71, 0xff, 0x34, 0xa1, 0xf3, 0x94, 0x23, 0x1d, 0x30, 0xc7,
+	0x81, 0x92, 0xb1, 0x46, 0xcd, 0x71, 0x40, 0x5e, 0x80, 0x39, 0xcb, 0x94, 0x84, 0x9d, 0xab, 0x0f,
+	0xff, 0x7d, 0xd6, 0x60, 0x96, 0x61, 0xce, 0x44, 0x98, 0x26, 0xd4, 0x9c, 0x65, 0x52, 0xf3, 0x29,
+	0xee, 0x31, 0x52, 0xca, 0xb6, 0xa9, 0x4e, 0xc8, 0xfb, 0x60, 0x6d, 0xb1, 0x90, 0x32, 0x68, 0x55,
+	0xcf, 0xb6, 0x58, 0x8c, 0x03, 0xf2, 0x0d, 0x5c, 0x60, 0xb2, 0xcc, 0x8b, 0x4c, 0x7e, 0xee, 0xb3,
+	0x68, 0x9d, 0x2a, 0x61, 0x3b, 0xfa, 0xce, 0xde, 0x91, 0x1a, 0x46, 0xeb, 0x94, 0x76, 0xf0, 0x49,
+	0x4e, 0x7a, 0xd0, 0x5a, 0xa6, 0x71, 0x96, 0x23, 0x57, 0xeb, 0xb2, 0x54, 0xbf, 0x53, 0x88, 0x74,
+	0xc1, 0xe2, 0x1b, 0x76, 0xf5, 0xf5, 0x2b, 0xa7, 0xae, 0xb6, 0x52, 0x66, 0x52, 0x7a, 0x65, 0x05,
+	0xee, 0xa7, 0x49, 0x54, 0x38, 0x0d, 0x2d, 0xbd, 0x86, 0x66, 0x49, 0x54, 0xb8, 0x1e, 0x34, 0x8f,
+	0x53, 0x11, 0x00, 0x6b, 0x44, 0xbd, 0xe1, 0x9d, 0x67, 0x57, 0x64, 0x7c, 0xe3, 0x4d, 0xbd, 0x3b,
+	0xcf, 0x36, 0xc8, 0x05, 0xb4, 0x34, 0xee, 0xcf, 0xa7, 0xb3, 0xef, 0x6c, 0x53, 0x02, 0x9a, 0xd4,
+	0x40, 0xd5, 0x1d, 0x43, 0xeb, 0x3a, 0x4a, 0x97, 0xdb, 0xd9, 0x6a, 0xc5, 0x51, 0xfc, 0x87, 0x41,
+	0xbb, 0x60, 0xa5, 0x8a, 0x53, 0xf2, 0xb6, 0xa9, 0x95, 0x1e, 0x2b, 0x23, 0x4c, 0x4a, 0x09, 0x65,
+	0xe8, 0xfe, 0x6a, 0x00, 0xdc, 0xb1, 0x45, 0x84, 0xe3, 0x24, 0xc0, 0x7b, 0xf2, 0x39, 0xd4, 0x75,
+	0xe9, 0x61, 0xc9, 0x17, 0x52, 0xb0, 0x93, 0x66, 0xf4, 0xc0, 0x93, 0x4f, 0xe0, 0x7c, 0x11, 0xa5,
+	0x69, 0xec, 0xaf, 0xc2, 0x48, 0x60, 0x5e, 0xbe, 0x85, 0x96, 0xc2, 0xbe, 0x55, 0x10, 0xf9, 0x14,
+	0x3a, 0xc8, 0x45, 0x18, 0x33, 0x81, 0x81, 0xcf, 0xc3, 0x07, 0x54, 0x9d, 0x6b, 0xb4, 0x7d, 0x44,
+	0xdf, 0x84, 0x0f, 0x28, 0xcb, 0x56, 0x69, 0x1e, 0x33, 0xe1, 0x9f, 0x3e, 0x91, 0x36, 0x6d, 0x6b,
+	0x74, 0xae, 0x41, 0xb7, 0x80, 0xc6, 0x68, 0x83, 0xcb, 0x2d, 0xdf, 0xc5, 0xe4, 0x39, 0xd4, 0xd4,
+	0x56, 0x0d, 0xb5, 0xd5, 0xae, 0xbc, 0xe4, 0x81, 0x1b, 0xc8, 0x25, 0xe6, 0xa1, 0xd8, 0xc4, 0x54,
+	0xd5, 0xc8, 0xa1, 0xf9, 0x2e, 0x56, 0xf7, 0xab, 0x51, 0x19, 0xba, 0x5f, 0x42, 0xf3, 0x58, 0xa4,
+	0xd7, 0x30, 0x7a, 0x79, 0x35, 0xb2, 0x2b, 0xe4, 0x1c, 0x1a, 0x6f, 0xdf, 0x7e, 0xcf, 0xf8, 0xe6,
+	0xd5, 0x57, 0xb6, 0x41, 0x1a, 0x50, 0xbb, 0x9d, 0xdd, 0x7a, 0xb6, 0xe9, 0xfe, 0x62, 0x42, 0xfd,
+	0x86, 0x09, 0x36, 0xc1, 0xe2, 0xc4, 0x72, 0xc6, 0xa9, 0xe5, 0x08, 0xd4, 0x02, 0x26, 0x58, 0x29,
+	0x83, 0x8a, 0xa5, 0xe3, 0xc3, 0x7d, 0xf9, 0x2b, 0x30, 0xc3, 0xbd, 0x7c, 0xea, 0xcb, 0x1c, 0x95,
+	0x1a, 0x4c, 0xa8, 0x21, 0xab, 0xb4, 0x59, 0x22, 0x43, 0x41, 0xbe, 0x80, 0x7a, 0xb6, 0xcb, 0xb3,
+	0x94, 0x63, 0xe9, 0xd6, 0x77, 0xe5, 0x5c, 0x65, 0xdf, 0xc1, 0x0f, 0x9a, 0xa2, 0x87, 0x1a, 0xf2,
+	0xac, 0xd4, 0xc0, 0xfa, 0x5f, 0x67, 0xeb, 0xf9, 0xbb, 0x60, 0x65, 0x39, 0xae, 0xc2, 0xfb, 0x83,
+	0x5b, 0x75, 0x26, 0x17, 0xf8, 0x53, 0xce, 0xb2, 0x2c, 0x4c, 0xd6, 0xfe, 0x16, 0xb5, 0x5d, 0x9b,
+	0xb4, 0x75, 0xc0, 0x26, 0x58, 0xb8, 0x9f, 0x41, 0xbd, 0x6c, 0x4b, 0xea, 0x50, 0x1d, 0xde, 0xfe,
+	0x68, 0x57, 0x48, 0x13, 0xce, 0xee, 0x86, 0xd7, 0x53, 0x4f, 0x0b, 0xa4, 0x2d, 0xfa, 0xfc, 0x19,
+	0x74, 0x9e, 0xf6, 0x96, 0xf5, 0x0c, 0xb9, 0x5d, 0x21, 0x2d, 0xa8, 0x33, 0xe4, 0xfe, 0x7a, 0x19,
+	0xdb, 0xc6, 0xb5, 0xfd, 0xdb, 0xe3, 0xa5, 0xf1, 0xfb, 0xe3, 0xa5, 0xf1, 0xd7, 0xe3, 0xa5, 0xf1,
+	0xf3, 0xdf, 0x97, 0x95, 0x85, 0xa5, 0xfe, 0xb7, 0x2f, 0xff, 0x19, 0x00, 0xbb, 0x5a, 0xa9, 0xe5,
+	0x7b, 0x05, 0x00, 0x00,
 }
 
 func (m *KV) Marshal() (dAtA []byte, err error) {
@@ -880,6 +988,23 @@ func (m *ManifestChange) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.ValuesOnly {
+		i--
+		if m.ValuesOnly {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x40
+	}
+	if len(m.Sha256) > 0 {
+		i -= len(m.Sha256)
+		copy(dAtA[i:], m.Sha256)
+		i = encodeVarintPb(dAtA, i, uint64(len(m.Sha256)))
+		i--
+		dAtA[i] = 0x3a
+	}
 	if m.Compression != 0 {
 		i = encodeVarintPb(dAtA, i, uint64(m.Compression))
 		i--
@@ -981,6 +1106,11 @@ func (m *TableIndex) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.FormatVersion != 0 {
+		i = encodeVarintPb(dAtA, i, uint64(m.FormatVersion))
+		i--
+		dAtA[i] = 0x20
+	}
 	if m.EstimatedSize != 0 {
 		i = encodeVarintPb(dAtA, i, uint64(m.EstimatedSize))
 		i--
@@ -1071,6 +1201,30 @@ func (m *DataKey) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.WrappingKey) > 0 {
+		i -= len(m.WrappingKey)
+		copy(dAtA[i:], m.WrappingKey)
+		i = encodeVarintPb(dAtA, i, uint64(len(m.WrappingKey)))
+		i--
+		dAtA[i] = 0x42
+	}
+	if len(m.Prefix) > 0 {
+		i -= len(m.Prefix)
+		copy(dAtA[i:], m.Prefix)
+		i = encodeVarintPb(dAtA, i, uint64(len(m.Prefix)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if m.Algo != 0 {
+		i = encodeVarintPb(dAtA, i, uint64(m.Algo))
+		i--
+		dAtA[i] = 0x30
+	}
+	if m.Purpose != 0 {
+		i = encodeVarintPb(dAtA, i, uint64(m.Purpose))
+		i--
+		dAtA[i] = 0x28
+	}
 	if m.CreatedAt != 0 {
 		i = encodeVarintPb(dAtA, i, uint64(m.CreatedAt))
 		i--
@@ -1209,6 +1363,13 @@ func (m *ManifestChange) Size() (n int) {
 	if m.Compression != 0 {
 		n += 1 + sovPb(uint64(m.Compression))
 	}
+	l = len(m.Sha256)
+	if l > 0 {
+		n += 1 + l + sovPb(uint64(l))
+	}
+	if m.ValuesOnly {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1256,6 +1417,9 @@ func (m *TableIndex) Size() (n int) {
 	if m.EstimatedSize != 0 {
 		n += 1 + sovPb(uint64(m.EstimatedSize))
 	}
+	if m.FormatVersion != 0 {
+		n += 1 + sovPb(uint64(m.FormatVersion))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1300,6 +1464,20 @@ func (m *DataKey) Size() (n int) {
 	if m.CreatedAt != 0 {
 		n += 1 + sovPb(uint64(m.CreatedAt))
 	}
+	if m.Purpose != 0 {
+		n += 1 + sovPb(uint64(m.Purpose))
+	}
+	if m.Algo != 0 {
+		n += 1 + sovPb(uint64(m.Algo))
+	}
+	l = len(m.Prefix)
+	if l > 0 {
+		n += 1 + l + sovPb(uint64(l))
+	}
+	l = len(m.WrappingKey)
+	if l > 0 {
+		n += 1 + l + sovPb(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1898,6 +2076,60 @@ func (m *ManifestChange) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Sha256", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPb
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Sha256 = append(m.Sha256[:0], dAtA[iNdEx:postIndex]...)
+			if m.Sha256 == nil {
+				m.Sha256 = []byte{}
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValuesOnly", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ValuesOnly = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPb(dAtA[iNdEx:])
@@ -2165,6 +2397,25 @@ func (m *TableIndex) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FormatVersion", wireType)
+			}
+			m.FormatVersion = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FormatVersion |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPb(dAtA[iNdEx:])
@@ -2417,6 +2668,110 @@ func (m *DataKey) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Purpose", wireType)
+			}
+			m.Purpose = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Purpose |= DataKey_Purpose(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Algo", wireType)
+			}
+			m.Algo = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Algo |= EncryptionAlgo(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Prefix", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPb
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Prefix = append(m.Prefix[:0], dAtA[iNdEx:postIndex]...)
+			if m.Prefix == nil {
+				m.Prefix = []byte{}
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WrappingKey", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.WrappingKey = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPb(dAtA[iNdEx:])
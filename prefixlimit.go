@@ -0,0 +1,123 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// PrefixLimit limits the keys under Prefix, so that keys meant for small metadata can't get large
+// values. A limit of 0 means no limit. An empty Prefix applies to every key, and the longest
+// prefix of a key wins.
+type PrefixLimit struct {
+	Prefix []byte
+	// MaxKeySize and MaxValueSize are the maximum sizes of the keys and of their values.
+	MaxKeySize   int
+	MaxValueSize int
+	// MaxEntries is the maximum number of keys under Prefix a transaction can write.
+	MaxEntries int
+}
+
+// PrefixLimitError is the error of committing a transaction breaking a PrefixLimit. Its cause, as
+// given by errors.Cause, is ErrPrefixLimit.
+type PrefixLimitError struct {
+	Prefix []byte
+	// Key is the key breaking the limit, the last one counted for MaxEntries.
+	Key []byte
+	// Limit is the name of the limit of the PrefixLimit: MaxKeySize, MaxValueSize or MaxEntries.
+	Limit string
+	Size  int
+	Max   int
+}
+
+func (e *PrefixLimitError) Error() string {
+	return fmt.Sprintf("%s: key %q under prefix %q has %s %d, over %d",
+		ErrPrefixLimit, e.Key, e.Prefix, e.Limit, e.Size, e.Max)
+}
+
+// Cause returns ErrPrefixLimit.
+func (e *PrefixLimitError) Cause() error {
+	return ErrPrefixLimit
+}
+
+// Unwrap returns ErrPrefixLimit.
+func (e *PrefixLimitError) Unwrap() error {
+	return ErrPrefixLimit
+}
+
+// prefixLimits holds the limits of a DB, sorted by decreasing prefix length so that the longest
+// prefix of a key wins.
+type prefixLimits []PrefixLimit
+
+func newPrefixLimits(pls []PrefixLimit) prefixLimits {
+	if len(pls) == 0 {
+		return nil
+	}
+	limits := make(prefixLimits, len(pls))
+	copy(limits, pls)
+	sort.SliceStable(limits, func(i, j int) bool {
+		return len(limits[i].Prefix) > len(limits[j].Prefix)
+	})
+	return limits
+}
+
+// index returns the index of the limit of key, or -1 if it has none. The internal keys of Badger
+// have no limits.
+func (limits prefixLimits) index(key []byte) int {
+	if len(limits) == 0 || bytes.HasPrefix(key, badgerPrefix) {
+		return -1
+	}
+	for i, pl := range limits {
+		if bytes.HasPrefix(key, pl.Prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkPrefixLimits returns a *PrefixLimitError if the pending writes of txn break a PrefixLimit.
+// The values shared by renamed keys are stored already, so only their keys are checked.
+func (txn *Txn) checkPrefixLimits() error {
+	limits := txn.db.limits
+	if len(limits) == 0 {
+		return nil
+	}
+	counts := make([]int, len(limits))
+	for _, e := range txn.pendingWrites {
+		i := limits.index(e.Key)
+		if i < 0 {
+			continue
+		}
+		pl := &limits[i]
+		fail := func(limit string, size, max int) error {
+			return &PrefixLimitError{Prefix: pl.Prefix, Key: append([]byte{}, e.Key...), Limit: limit,
+				Size: size, Max: max}
+		}
+		counts[i]++
+		switch {
+		case pl.MaxKeySize > 0 && len(e.Key) > pl.MaxKeySize:
+			return fail("MaxKeySize", len(e.Key), pl.MaxKeySize)
+		case pl.MaxValueSize > 0 && e.meta&bitValuePointer == 0 && len(e.Value) > pl.MaxValueSize:
+			return fail("MaxValueSize", len(e.Value), pl.MaxValueSize)
+		case pl.MaxEntries > 0 && counts[i] > pl.MaxEntries:
+			return fail("MaxEntries", counts[i], pl.MaxEntries)
+		}
+	}
+	return nil
+}
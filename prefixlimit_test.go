@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixLimits(t *testing.T) {
+	opt := getTestOptions("").WithPrefixLimits([]PrefixLimit{
+		{Prefix: []byte("meta/"), MaxValueSize: 16, MaxEntries: 3},
+		{Prefix: []byte("meta/keys/"), MaxKeySize: 16},
+	})
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(kvs ...string) error {
+			return db.Update(func(txn *Txn) error {
+				for i := 0; i < len(kvs); i += 2 {
+					if err := txn.Set([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		limitErr := func(err error) *PrefixLimitError {
+			require.Equal(t, ErrPrefixLimit, errors.Cause(err))
+			lerr, ok := err.(*PrefixLimitError)
+			require.True(t, ok)
+			return lerr
+		}
+
+		require.NoError(t, set("meta/a", "small", "meta/b", "small", "meta/c", "small"))
+		require.NoError(t, set("data/a", string(bytes.Repeat([]byte("x"), 1<<10))))
+		require.NoError(t, set("meta/keys/aaaaaa", string(bytes.Repeat([]byte("x"), 1<<10))))
+
+		lerr := limitErr(set("meta/a", "too large for metadata"))
+		require.Equal(t, &PrefixLimitError{Prefix: []byte("meta/"), Key: []byte("meta/a"),
+			Limit: "MaxValueSize", Size: 22, Max: 16}, lerr)
+		require.Contains(t, lerr.Error(), `key "meta/a" under prefix "meta/"`)
+
+		lerr = limitErr(set("meta/keys/too-long-key", "x"))
+		require.Equal(t, "MaxKeySize", lerr.Limit)
+
+		var kvs []string
+		for i := 0; i < 4; i++ {
+			kvs = append(kvs, fmt.Sprintf("meta/%d", i), "small")
+		}
+		lerr = limitErr(set(kvs...))
+		require.Equal(t, "MaxEntries", lerr.Limit)
+		require.Equal(t, 4, lerr.Size)
+
+		// Nothing got written by the failed transactions.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("meta/a"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("small"), getItemValue(t, item))
+			_, err = txn.Get([]byte("meta/0"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
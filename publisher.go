@@ -17,31 +17,104 @@
 package badger
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/trie"
 	"github.com/dgraph-io/badger/v2/y"
 )
 
+// SubscriptionPolicy decides what happens to the updates of a subscription whose callback doesn't
+// keep up with the writes, once its buffer is full.
+type SubscriptionPolicy int
+
+const (
+	// SubscriptionBlock waits for the callback to make room in the buffer, or for
+	// SubscribeOptions.BlockTimeout to expire, after which the subscription is canceled. Waiting
+	// holds back the updates of all the subscriptions, and eventually the writes to the DB.
+	SubscriptionBlock SubscriptionPolicy = iota
+	// SubscriptionDropOldest drops the oldest updates of the buffer to make room for new ones.
+	SubscriptionDropOldest
+	// SubscriptionCancel cancels the subscription right away, Subscribe returning
+	// ErrSubscriptionLagging.
+	SubscriptionCancel
+)
+
+// defaultSubscriptionBuffer is the number of batches of updates buffered per subscription.
+const defaultSubscriptionBuffer = 1000
+
+// SubscribeOptions are the options of DB.SubscribeWithOptions.
+type SubscribeOptions struct {
+	// Prefixes are the key prefixes watched. At least one is required, the empty prefix watches
+	// all the keys.
+	Prefixes [][]byte
+	// BufferSize is the number of batches of updates buffered until the callback gets them. It's
+	// 1000 if zero.
+	BufferSize int
+	// Policy applies once the buffer is full.
+	Policy SubscriptionPolicy
+	// BlockTimeout bounds the time SubscriptionBlock waits for room in the buffer before
+	// canceling the subscription. Zero waits for as long as needed.
+	BlockTimeout time.Duration
+	// Started, if set, is called once the subscription is registered. The writes a transaction
+	// created after that doesn't see are passed to the callback, so that the DB can be read from
+	// there without missing any update.
+	Started func()
+}
+
+// SubscriptionStats describes a subscription, see DB.Subscriptions.
+type SubscriptionStats struct {
+	ID       uint64
+	Prefixes [][]byte
+	Policy   SubscriptionPolicy
+	// Buffered is the number of batches of updates waiting for the callback.
+	Buffered int
+	// Delivered is the number of updates passed to the callback, and Dropped the number of
+	// updates dropped by SubscriptionDropOldest.
+	Delivered uint64
+	Dropped   uint64
+	// Lag is how long the last updates passed to the callback waited for it.
+	Lag time.Duration
+}
+
 type subscriber struct {
-	prefixes  [][]byte
-	sendCh    chan<- *pb.KVList
+	id        uint64
+	opt       SubscribeOptions
+	sendCh    chan subscriberUpdate
 	subCloser *y.Closer
+	// done is closed when Subscribe returns, so that publishing doesn't wait on the subscriber
+	// anymore. lagging is closed when the subscription gets canceled by its policy.
+	done    chan struct{}
+	lagging chan struct{}
+
+	delivered uint64 // Atomic.
+	dropped   uint64 // Atomic.
+	lag       int64  // Atomic. In nanoseconds.
+}
+
+// subscriberUpdate is a batch of updates for a subscriber, along with the time it got published.
+type subscriberUpdate struct {
+	kvs *pb.KVList
+	at  time.Time
 }
 
 type publisher struct {
 	sync.Mutex
 	pubCh       chan requests
-	subscribers map[uint64]subscriber
+	subscribers map[uint64]*subscriber
 	nextID      uint64
 	indexer     *trie.Trie
+	// decode decodes the keys and values stored by the writes, see DB.decodeValue.
+	decode func(key, val []byte) ([]byte, []byte, error)
 }
 
 func newPublisher() *publisher {
 	return &publisher{
 		pubCh:       make(chan requests, 1000),
-		subscribers: make(map[uint64]subscriber),
+		subscribers: make(map[uint64]*subscriber),
 		nextID:      0,
 		indexer:     trie.NewTrie(),
 	}
@@ -86,9 +159,17 @@ func (p *publisher) publishUpdates(reqs requests) {
 			ids := p.indexer.Get(e.Key)
 			if len(ids) > 0 {
 				k := y.SafeCopy(nil, e.Key)
+				key, val := y.ParseKey(k), e.Value
+				if e.meta&bitValuePointer == 0 && p.decode != nil {
+					var err error
+					if key, val, err = p.decode(key, val); err != nil {
+						// The value was encoded by the same DB just before.
+						continue
+					}
+				}
 				kv := &pb.KV{
-					Key:       y.ParseKey(k),
-					Value:     y.SafeCopy(nil, e.Value),
+					Key:       key,
+					Value:     y.SafeCopy(nil, val),
 					Meta:      []byte{e.UserMeta},
 					ExpiresAt: e.ExpiresAt,
 					Version:   y.ParseTs(k),
@@ -103,27 +184,84 @@ func (p *publisher) publishUpdates(reqs requests) {
 		}
 	}
 
+	now := time.Now()
 	for id, kvs := range batchedUpdates {
-		p.subscribers[id].sendCh <- kvs
+		p.send(p.subscribers[id], subscriberUpdate{kvs: kvs, at: now})
+	}
+}
+
+// send passes u to the subscriber s, applying its policy if its buffer is full. p must be locked.
+func (p *publisher) send(s *subscriber, u subscriberUpdate) {
+	select {
+	case s.sendCh <- u:
+		return
+	case <-s.done:
+		return
+	default:
+	}
+	switch s.opt.Policy {
+	case SubscriptionDropOldest:
+		for {
+			select {
+			case old := <-s.sendCh:
+				atomic.AddUint64(&s.dropped, uint64(len(old.kvs.Kv)))
+			default:
+			}
+			select {
+			case s.sendCh <- u:
+				return
+			default:
+			}
+		}
+	case SubscriptionCancel:
+		p.cancel(s)
+	default:
+		var timeout <-chan time.Time
+		if s.opt.BlockTimeout > 0 {
+			timer := time.NewTimer(s.opt.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case s.sendCh <- u:
+		case <-s.done:
+		case <-timeout:
+			p.cancel(s)
+		}
+	}
+}
+
+// cancel removes the subscriber s, whose buffer is full, and tells it it's lagging. p must be
+// locked.
+func (p *publisher) cancel(s *subscriber) {
+	for _, prefix := range s.opt.Prefixes {
+		p.indexer.Delete(prefix, s.id)
 	}
+	delete(p.subscribers, s.id)
+	close(s.lagging)
 }
 
-func (p *publisher) newSubscriber(c *y.Closer, prefixes ...[]byte) (<-chan *pb.KVList, uint64) {
+func (p *publisher) newSubscriber(c *y.Closer, opt SubscribeOptions) *subscriber {
 	p.Lock()
 	defer p.Unlock()
-	ch := make(chan *pb.KVList, 1000)
-	id := p.nextID
-	// Increment next ID.
-	p.nextID++
-	p.subscribers[id] = subscriber{
-		prefixes:  prefixes,
-		sendCh:    ch,
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = defaultSubscriptionBuffer
+	}
+	s := &subscriber{
+		id:        p.nextID,
+		opt:       opt,
+		sendCh:    make(chan subscriberUpdate, opt.BufferSize),
 		subCloser: c,
+		done:      make(chan struct{}),
+		lagging:   make(chan struct{}),
 	}
-	for _, prefix := range prefixes {
-		p.indexer.Add(prefix, id)
+	// Increment next ID.
+	p.nextID++
+	p.subscribers[s.id] = s
+	for _, prefix := range opt.Prefixes {
+		p.indexer.Add(prefix, s.id)
 	}
-	return ch, id
+	return s
 }
 
 // cleanSubscribers stops all the subscribers. Ideally, It should be called while closing DB.
@@ -131,7 +269,7 @@ func (p *publisher) cleanSubscribers() {
 	p.Lock()
 	defer p.Unlock()
 	for id, s := range p.subscribers {
-		for _, prefix := range s.prefixes {
+		for _, prefix := range s.opt.Prefixes {
 			p.indexer.Delete(prefix, id)
 		}
 		delete(p.subscribers, id)
@@ -143,7 +281,7 @@ func (p *publisher) deleteSubscriber(id uint64) {
 	p.Lock()
 	defer p.Unlock()
 	if s, ok := p.subscribers[id]; ok {
-		for _, prefix := range s.prefixes {
+		for _, prefix := range s.opt.Prefixes {
 			p.indexer.Delete(prefix, id)
 		}
 	}
@@ -162,3 +300,23 @@ func (p *publisher) noOfSubscribers() int {
 	defer p.Unlock()
 	return len(p.subscribers)
 }
+
+// stats returns the description of the subscriptions, sorted by ID.
+func (p *publisher) stats() []SubscriptionStats {
+	p.Lock()
+	defer p.Unlock()
+	stats := make([]SubscriptionStats, 0, len(p.subscribers))
+	for _, s := range p.subscribers {
+		stats = append(stats, SubscriptionStats{
+			ID:        s.id,
+			Prefixes:  s.opt.Prefixes,
+			Policy:    s.opt.Policy,
+			Buffered:  len(s.sendCh),
+			Delivered: atomic.LoadUint64(&s.delivered),
+			Dropped:   atomic.LoadUint64(&s.dropped),
+			Lag:       time.Duration(atomic.LoadInt64(&s.lag)),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -101,3 +102,144 @@ func TestMultiplePrefix(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+// slowSubscriber subscribes with opt, with a callback which blocks after the first update until
+// release is closed.
+type slowSubscriber struct {
+	release chan struct{}
+	started chan struct{}
+	errCh   chan error
+	cancel  context.CancelFunc
+
+	sync.Mutex
+	keys []string
+}
+
+func newSlowSubscriber(t *testing.T, db *DB, opt SubscribeOptions) *slowSubscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &slowSubscriber{
+		release: make(chan struct{}),
+		started: make(chan struct{}),
+		errCh:   make(chan error, 1),
+		cancel:  cancel,
+	}
+	go func() {
+		first := true
+		s.errCh <- db.SubscribeWithOptions(ctx, func(kvs *KVList) error {
+			s.Lock()
+			for _, kv := range kvs.Kv {
+				s.keys = append(s.keys, string(kv.Key))
+			}
+			s.Unlock()
+			if first {
+				first = false
+				close(s.started)
+				<-s.release
+			}
+			return nil
+		}, opt)
+	}()
+	waitFor(t, func() bool { return len(db.Subscriptions()) == 1 })
+	return s
+}
+
+// fill writes the keys one at a time, waiting for each to be buffered, the first one blocking
+// the callback. The last key isn't waited for.
+func (s *slowSubscriber) fill(t *testing.T, db *DB, keys ...string) {
+	for i, key := range keys {
+		txnSet(t, db, []byte(key), []byte("value"), 0)
+		if i == 0 {
+			<-s.started
+		} else if i < len(keys)-1 {
+			waitFor(t, func() bool {
+				subs := db.Subscriptions()
+				return len(subs) == 1 && subs[0].Buffered == i
+			})
+		}
+	}
+}
+
+func TestSubscriptionDropOldest(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		s := newSlowSubscriber(t, db, SubscribeOptions{
+			Prefixes:   [][]byte{[]byte("key")},
+			BufferSize: 2,
+			Policy:     SubscriptionDropOldest,
+		})
+		s.fill(t, db, "key0", "key1", "key2", "key3")
+		waitFor(t, func() bool { return db.Subscriptions()[0].Dropped == 1 })
+		require.Equal(t, 2, db.Subscriptions()[0].Buffered)
+
+		close(s.release)
+		waitFor(t, func() bool { return db.Subscriptions()[0].Delivered == 3 })
+		stats := db.Subscriptions()[0]
+		require.Equal(t, SubscriptionDropOldest, stats.Policy)
+		require.NotZero(t, stats.Lag)
+		s.Lock()
+		require.Equal(t, []string{"key0", "key2", "key3"}, s.keys)
+		s.Unlock()
+
+		s.cancel()
+		require.Equal(t, context.Canceled, <-s.errCh)
+		require.Empty(t, db.Subscriptions())
+	})
+}
+
+func TestSubscriptionCancel(t *testing.T) {
+	for _, opt := range []SubscribeOptions{
+		{Policy: SubscriptionCancel},
+		{Policy: SubscriptionBlock, BlockTimeout: 50 * time.Millisecond},
+	} {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			opt.Prefixes = [][]byte{[]byte("key")}
+			opt.BufferSize = 1
+			s := newSlowSubscriber(t, db, opt)
+			s.fill(t, db, "key0", "key1", "key2")
+			waitFor(t, func() bool { return len(db.Subscriptions()) == 0 })
+			// Writes go on.
+			txnSet(t, db, []byte("key3"), []byte("value"), 0)
+
+			close(s.release)
+			require.Equal(t, ErrSubscriptionLagging, <-s.errCh)
+			s.cancel()
+		})
+	}
+}
+
+func TestSubscriptionBlockCanceled(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		s := newSlowSubscriber(t, db, SubscribeOptions{
+			Prefixes:   [][]byte{[]byte("key")},
+			BufferSize: 1,
+		})
+		s.fill(t, db, "key0", "key1", "key2")
+		// Canceling the subscription unblocks the publisher waiting on it.
+		s.cancel()
+		close(s.release)
+		require.Equal(t, context.Canceled, <-s.errCh)
+		require.Empty(t, db.Subscriptions())
+		txnSet(t, db, []byte("key3"), []byte("value"), 0)
+	})
+}
+
+func TestSubscriptionStarted(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan struct{})
+		updates := make(chan *KVList, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- db.SubscribeWithOptions(ctx, func(kvs *KVList) error {
+				updates <- kvs
+				return nil
+			}, SubscribeOptions{Prefixes: [][]byte{[]byte("k")}, Started: func() { close(started) }})
+		}()
+		<-started
+		// No need to wait for the subscription to be scheduled.
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		kvs := <-updates
+		require.Equal(t, "key", string(kvs.Kv[0].Key))
+		cancel()
+		require.Equal(t, context.Canceled, <-errCh)
+	})
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/dgryski/go-farm"
+	"github.com/pkg/errors"
+)
+
+// PurgeReport reports what DB.PurgeKey rewrote, and what it found of the key once done.
+type PurgeReport struct {
+	// Versions is the number of versions of the key found in the memtables and the LSM tree
+	// before the purge, deletion markers included.
+	Versions int
+	// TablesRewritten is the number of tables rewritten without the key.
+	TablesRewritten int
+	// ValueLogFilesRewritten are the IDs of the value log files rewritten without the key.
+	ValueLogFilesRewritten []uint32
+
+	// RemainingVersions is the number of versions of the key found in the memtables and the LSM
+	// tree after the purge. They're the versions written while PurgeKey ran.
+	RemainingVersions int
+	// RemainingValueLogFiles are the IDs of the value log files still holding entries of the key
+	// after the purge, not counting the file being written to. They're the files whose deletion
+	// waits for the iterators open during the purge to be closed, and the files holding values
+	// renamed by pending transactions, which PurgeKey can be called again for.
+	RemainingValueLogFiles []uint32
+}
+
+// Verified returns true if no trace of the key was found after the purge.
+func (r PurgeReport) Verified() bool {
+	return r.RemainingVersions == 0 && len(r.RemainingValueLogFiles) == 0
+}
+
+// PurgeKey erases all the versions of key from the disk right away, for compliance cases where
+// deleting the key, and waiting for compactions and value log GC to reclaim its versions, isn't
+// acceptable. It flushes the memtables, rewrites the tables and the value log files holding
+// versions of the key without them, and returns a report checked against the files once done.
+//
+// Only the files holding the key get rewritten, but finding the value log files means reading
+// the whole value log, so PurgeKey is slow on large DBs. Writes to the key while it runs may
+// survive the purge, see PurgeReport.RemainingVersions. Note that the value log files get passed
+// to the Options.ValueLogArchiver before being deleted, and that the backups and snapshots taken
+// before still hold the key. PurgeKey returns ErrAppendOnly on an append-only DB, and ErrFrozen
+// while the DB is frozen.
+func (db *DB) PurgeKey(key []byte) (PurgeReport, error) {
+	var r PurgeReport
+	switch {
+	case db.opt.ReadOnly:
+		return r, errors.New("PurgeKey cannot be called in read-only mode")
+	case db.opt.InMemory:
+		return r, errors.New("PurgeKey cannot be called in InMemory mode")
+	case db.opt.AppendOnly:
+		return r, ErrAppendOnly
+	case len(key) == 0:
+		return r, ErrEmptyKey
+	case bytes.HasPrefix(key, badgerPrefix):
+		return r, checkReservedKey(key)
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return r, err
+	}
+	defer done()
+	k := db.storedKey(key)
+	// The versions moved by value log GC live under the badgerMove prefix.
+	keys := [][]byte{k, append(append([]byte{}, badgerMove...), k...)}
+	for _, k := range keys {
+		r.Versions += db.lsmVersions(k)
+	}
+
+	// Get the memtables and the value log head out of the way, so that every file holding
+	// versions of the key can be rewritten.
+	if err := db.flushHead(); err != nil {
+		return r, errors.Wrap(err, "while flushing memtables")
+	}
+	n, err := db.lc.purgeKeys(keys)
+	r.TablesRewritten = n
+	if err != nil {
+		return r, err
+	}
+	fids, err := db.vlog.fidsWithEntries(keys)
+	if err != nil {
+		return r, err
+	}
+	for _, fid := range fids {
+		// The entries of the key aren't in the LSM tree anymore, so they don't get moved.
+		err := db.vlog.reencrypt(context.Background(), fid)
+		switch errors.Cause(err) {
+		case nil:
+			r.ValueLogFilesRewritten = append(r.ValueLogFilesRewritten, fid)
+		case ErrNoRewrite:
+		default:
+			return r, err
+		}
+	}
+
+	for _, k := range keys {
+		r.RemainingVersions += db.lsmVersions(k)
+	}
+	r.RemainingValueLogFiles, err = db.vlog.fidsWithEntries(keys)
+	return r, err
+}
+
+// lsmVersions returns the number of versions of the stored key k in the memtables and the LSM
+// tree.
+func (db *DB) lsmVersions(k []byte) int {
+	tables, decr := db.getMemTables()
+	defer decr()
+	var iters []y.Iterator
+	for _, t := range tables {
+		iters = append(iters, t.NewUniIterator(false))
+	}
+	opt := IteratorOptions{Prefix: k, prefixIsKey: true, cmp: db.opt.Comparator}
+	iters = db.lc.appendIterators(iters, &opt)
+	it := table.NewMergeIteratorWithComparator(iters, false, db.opt.Comparator)
+	defer it.Close()
+	var n int
+	for it.Seek(y.KeyWithTs(k, math.MaxUint64)); it.Valid(); it.Next() {
+		if !bytes.Equal(y.ParseKey(it.Key()), k) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// hasKey returns true if keys holds k.
+func hasKey(keys [][]byte, k []byte) bool {
+	for _, key := range keys {
+		if bytes.Equal(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeKeys rewrites the tables holding versions of the keys without them, and returns the number
+// of tables rewritten. Compactions only move keys down, so going through the levels from the top
+// one finds all the versions.
+func (s *levelsController) purgeKeys(keys [][]byte) (int, error) {
+	var n int
+	for l, lh := range s.levels {
+		for {
+			t := lh.tableWithKeys(keys)
+			if t == nil {
+				break
+			}
+			if l == 0 {
+				// Tables in level 0 can only be compacted away all at once.
+				if err := s.compactLevelRange(0, infRange); err != nil {
+					return n, err
+				}
+				continue
+			}
+			done, err := s.rewriteTable(l, t, keys)
+			if err != nil {
+				return n, err
+			}
+			if !done {
+				// A compaction is running on the table.
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// tableWithKeys returns a table of the level holding versions of one of the keys, nil if none
+// does.
+func (s *levelHandler) tableWithKeys(keys [][]byte) *table.Table {
+	for _, k := range keys {
+		tables, decr := s.getTableForKey(y.KeyWithTs(k, math.MaxUint64))
+		var found *table.Table
+		for _, t := range tables {
+			if found == nil && tableHasKey(t, k) {
+				found = t
+			}
+		}
+		_ = decr()
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// tableHasKey returns true if table t holds versions of the key k.
+func tableHasKey(t *table.Table, k []byte) bool {
+	if t.DoesNotHave(farm.Fingerprint64(k)) {
+		return false
+	}
+	it := t.NewIterator(false)
+	defer it.Close()
+	it.Seek(y.KeyWithTs(k, math.MaxUint64))
+	return it.Valid() && bytes.Equal(y.ParseKey(it.Key()), k)
+}
+
+// fidsWithEntries returns the IDs of the value log files holding entries of the keys, excluding
+// the file being written to. It waits for any running value log GC to finish first.
+func (vlog *valueLog) fidsWithEntries(keys [][]byte) ([]uint32, error) {
+	vlog.garbageCh <- struct{}{}
+	defer func() { <-vlog.garbageCh }()
+
+	vlog.filesLock.RLock()
+	maxFid := atomic.LoadUint32(&vlog.maxFid)
+	var files []*logFile
+	for _, fid := range vlog.sortedFids() {
+		if fid < maxFid {
+			files = append(files, vlog.filesMap[fid])
+		}
+	}
+	vlog.filesLock.RUnlock()
+
+	var fids []uint32
+	for _, lf := range files {
+		var found bool
+		_, err := vlog.iterate(lf, 0, func(e Entry, vp valuePointer) error {
+			if hasKey(keys, y.ParseKey(e.Key)) {
+				found = true
+				return errStop
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading value log file %d", lf.fid)
+		}
+		if found {
+			fids = append(fids, lf.fid)
+		}
+	}
+	return fids, nil
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithKeepL0InMemory(false).WithValueThreshold(32).
+		WithNumVersionsToKeep(10)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	secret := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("secret-value-%d;", i)), 4)
+	}
+	// Versions of the key in the tables of several levels, in the value log and in the memtable.
+	for i := 0; i < 6; i++ {
+		txnSet(t, db, []byte("key"), secret(i), 0)
+		txnSet(t, db, []byte(fmt.Sprintf("other%d", i)), bytes.Repeat([]byte("v"), 64), 0)
+		if i < 4 {
+			require.NoError(t, db.FlushMemtable(context.Background()))
+		}
+		if i == 1 {
+			require.NoError(t, db.Flatten(1))
+		}
+	}
+
+	r, err := db.PurgeKey([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, 6, r.Versions)
+	require.True(t, r.TablesRewritten > 0)
+	require.NotEmpty(t, r.ValueLogFilesRewritten)
+	require.True(t, r.Verified(), "%+v", r)
+
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("key"))
+			require.Equal(t, ErrKeyNotFound, err)
+			for i := 0; i < 6; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("other%d", i)))
+				require.NoError(t, err)
+				require.Equal(t, bytes.Repeat([]byte("v"), 64), getItemValue(t, item))
+			}
+			return nil
+		}))
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			require.NoError(t, err)
+			require.False(t, bytes.Contains(data, []byte("secret-value-")), f)
+		}
+	}
+	check()
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check()
+
+	_, err = db.PurgeKey([]byte("!badger!head"))
+	require.Error(t, err)
+	_, err = db.PurgeKey(nil)
+	require.Equal(t, ErrEmptyKey, err)
+}
+
+func TestPurgeKeyFrozen(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		require.NoError(t, db.Freeze())
+		_, err := db.PurgeKey([]byte("key"))
+		require.Equal(t, ErrFrozen, err)
+		require.NoError(t, db.Thaw())
+		r, err := db.PurgeKey([]byte("key"))
+		require.NoError(t, err)
+		require.True(t, r.Verified())
+	})
+}
@@ -0,0 +1,362 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package queue implements durable priority queues on top of Badger.
+//
+// Messages are enqueued as part of a transaction, and dequeued in order of priority, oldest first
+// among messages of the same priority. A dequeued message stays invisible to other consumers for
+// the visibility timeout of the queue, and gets delivered again unless it's acknowledged before
+// the timeout runs out. Messages delivered too many times without being acknowledged are moved to
+// the dead letters of the queue:
+//
+//	q, err := queue.Open(db, []byte("jobs/"), queue.DefaultOptions)
+//	...
+//	err = db.Update(func(txn *badger.Txn) error {
+//		_, err := q.Enqueue(txn, job, 0)
+//		return err
+//	})
+//	...
+//	msg, err := q.Dequeue()
+//	if err == queue.ErrEmpty {
+//		// Nothing to do.
+//	}
+//	if err := process(msg.Payload); err != nil {
+//		return q.Nack(msg)
+//	}
+//	return q.Ack(msg)
+package queue
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrEmpty is returned by Dequeue when no message is ready.
+	ErrEmpty = errors.New("Queue is empty")
+
+	// ErrNotInFlight is returned when acknowledging a message whose visibility timeout ran out. The
+	// message might have been delivered to another consumer since.
+	ErrNotInFlight = errors.New("Message is not in flight anymore")
+)
+
+// Key layout, under the prefix of the queue. Messages are identified by their inverted priority
+// followed by their big-endian sequence number, so that they sort in the order they're dequeued.
+const (
+	seqTag      = 's' // Sequence used to number messages.
+	readyTag    = 'r' // Messages ready to be dequeued, followed by their ID.
+	inFlightTag = 'i' // Dequeued messages, followed by their big-endian deadline and their ID.
+	deadTag     = 'd' // Dead letters, followed by their ID.
+)
+
+// idSize is the size of a message ID: one byte of priority followed by the sequence number.
+const idSize = 9
+
+// maxConflictRetries is the number of times an operation is retried when its transaction
+// conflicts with one of another consumer.
+const maxConflictRetries = 10
+
+// Options are the options of a queue.
+type Options struct {
+	// VisibilityTimeout is how long a dequeued message stays invisible to other consumers.
+	VisibilityTimeout time.Duration
+	// MaxDeliveries is the number of times a message is delivered before it's moved to the dead
+	// letters. Zero means messages are delivered until they're acknowledged.
+	MaxDeliveries int
+	// MessageTTL is how long messages stay in the queue, dead letters included. Zero means they
+	// never expire.
+	MessageTTL time.Duration
+}
+
+// DefaultOptions are the default options of a queue.
+var DefaultOptions = Options{
+	VisibilityTimeout: 30 * time.Second,
+	MaxDeliveries:     5,
+}
+
+// Message is a message of a queue.
+type Message struct {
+	// ID is the sequence number of the message, unique within its queue.
+	ID       uint64
+	Priority uint8
+	Payload  []byte
+	// Deliveries is the number of times the message was delivered, this delivery included.
+	Deliveries int
+
+	key       []byte // Key of the message in the in-flight or dead letter messages.
+	expiresAt uint64
+}
+
+// Queue is a queue keeping its messages under a key prefix.
+type Queue struct {
+	db     *badger.DB
+	prefix []byte
+	opt    Options
+	seq    *badger.Sequence
+}
+
+// Open returns the queue keeping its messages in db under prefix. The prefix must not be used for
+// anything else. The queue must be closed once done with it.
+func Open(db *badger.DB, prefix []byte, opt Options) (*Queue, error) {
+	q := &Queue{db: db, prefix: append([]byte{}, prefix...), opt: opt}
+	seq, err := db.GetSequence(q.key(seqTag), 100)
+	if err != nil {
+		return nil, errors.Wrap(err, "while opening queue sequence")
+	}
+	q.seq = seq
+	return q, nil
+}
+
+// Close releases the message IDs reserved by the queue.
+func (q *Queue) Close() error {
+	return q.seq.Release()
+}
+
+func (q *Queue) key(tag byte, parts ...[]byte) []byte {
+	key := append([]byte{}, q.prefix...)
+	key = append(key, tag)
+	for _, p := range parts {
+		key = append(key, p...)
+	}
+	return key
+}
+
+func messageID(priority uint8, seq uint64) []byte {
+	id := make([]byte, idSize)
+	// Invert the priority, so that higher priorities sort first.
+	id[0] = ^priority
+	binary.BigEndian.PutUint64(id[1:], seq)
+	return id
+}
+
+func uint64Bytes(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// The value of a message key is the number of deliveries, followed by the payload.
+func encodeMessage(msg *Message) []byte {
+	buf := make([]byte, 4+len(msg.Payload))
+	binary.BigEndian.PutUint32(buf, uint32(msg.Deliveries))
+	copy(buf[4:], msg.Payload)
+	return buf
+}
+
+// readMessage reads the message of item, whose key ends with the message ID.
+func readMessage(item *badger.Item) (*Message, error) {
+	key := item.KeyCopy(nil)
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < idSize || len(val) < 4 {
+		return nil, errors.Errorf("Invalid queue message with key %q", key)
+	}
+	id := key[len(key)-idSize:]
+	return &Message{
+		ID:         binary.BigEndian.Uint64(id[1:]),
+		Priority:   ^id[0],
+		Payload:    val[4:],
+		Deliveries: int(binary.BigEndian.Uint32(val)),
+		key:        key,
+		expiresAt:  item.ExpiresAt(),
+	}, nil
+}
+
+// setMessage writes msg under key, keeping its expiration time.
+func setMessage(txn *badger.Txn, key []byte, msg *Message) error {
+	e := badger.NewEntry(key, encodeMessage(msg))
+	e.ExpiresAt = msg.expiresAt
+	return txn.SetEntry(e)
+}
+
+func (q *Queue) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = q.db.Update(fn); errors.Cause(err) != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// Enqueue adds a message with the given payload and priority to the queue, as part of txn.
+// Messages with a higher priority are dequeued first. It returns the ID of the message.
+func (q *Queue) Enqueue(txn *badger.Txn, payload []byte, priority uint8) (uint64, error) {
+	seq, err := q.seq.Next()
+	if err != nil {
+		return 0, errors.Wrap(err, "while numbering queue message")
+	}
+	e := badger.NewEntry(q.key(readyTag, messageID(priority, seq)), encodeMessage(&Message{
+		Payload: payload,
+	}))
+	if q.opt.MessageTTL > 0 {
+		e = e.WithTTL(q.opt.MessageTTL)
+	}
+	return seq, txn.SetEntry(e)
+}
+
+// first returns the first message under prefix, nil if there's none.
+func first(txn *badger.Txn, prefix []byte) (*Message, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Rewind()
+	if !it.Valid() {
+		return nil, nil
+	}
+	return readMessage(it.Item())
+}
+
+// Dequeue returns the next message of the queue, and hides it from other consumers for the
+// visibility timeout of the queue. Messages whose visibility timeout ran out come first. It
+// returns ErrEmpty if no message is ready.
+func (q *Queue) Dequeue() (*Message, error) {
+	var msg *Message
+	err := q.update(func(txn *badger.Txn) error {
+		msg = nil
+		now := time.Now()
+		for {
+			m, err := first(txn, q.key(inFlightTag))
+			if err != nil {
+				return err
+			}
+			deadline := int64(0)
+			if m != nil {
+				deadline = int64(binary.BigEndian.Uint64(m.key[len(q.prefix)+1:]))
+			}
+			if m == nil || deadline > now.UnixNano() {
+				if m, err = first(txn, q.key(readyTag)); err != nil {
+					return err
+				}
+			}
+			if m == nil {
+				// Commit the messages moved to the dead letters anyway.
+				return nil
+			}
+			if err := txn.Delete(m.key); err != nil {
+				return err
+			}
+			id := m.key[len(m.key)-idSize:]
+			if q.opt.MaxDeliveries > 0 && m.Deliveries >= q.opt.MaxDeliveries {
+				m.key = q.key(deadTag, id)
+				if err := setMessage(txn, m.key, m); err != nil {
+					return err
+				}
+				continue
+			}
+			m.Deliveries++
+			deadline = now.Add(q.opt.VisibilityTimeout).UnixNano()
+			m.key = q.key(inFlightTag, uint64Bytes(uint64(deadline)), id)
+			msg = m
+			return setMessage(txn, m.key, m)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, ErrEmpty
+	}
+	return msg, nil
+}
+
+// Ack removes msg from the queue. It returns ErrNotInFlight if the visibility timeout of msg ran
+// out before.
+func (q *Queue) Ack(msg *Message) error {
+	return q.update(func(txn *badger.Txn) error {
+		if err := q.checkInFlight(txn, msg); err != nil {
+			return err
+		}
+		return txn.Delete(msg.key)
+	})
+}
+
+// Nack makes msg visible to other consumers again right away. It returns ErrNotInFlight if the
+// visibility timeout of msg ran out before.
+func (q *Queue) Nack(msg *Message) error {
+	return q.update(func(txn *badger.Txn) error {
+		if err := q.checkInFlight(txn, msg); err != nil {
+			return err
+		}
+		if err := txn.Delete(msg.key); err != nil {
+			return err
+		}
+		return setMessage(txn, q.key(readyTag, msg.key[len(msg.key)-idSize:]), msg)
+	})
+}
+
+func (q *Queue) checkInFlight(txn *badger.Txn, msg *Message) error {
+	if len(msg.key) <= len(q.prefix) || msg.key[len(q.prefix)] != inFlightTag {
+		return ErrNotInFlight
+	}
+	deadline := int64(binary.BigEndian.Uint64(msg.key[len(q.prefix)+1:]))
+	if deadline <= time.Now().UnixNano() {
+		return ErrNotInFlight
+	}
+	_, err := txn.Get(msg.key)
+	if err == badger.ErrKeyNotFound {
+		return ErrNotInFlight
+	}
+	return err
+}
+
+// DeadLetters returns up to max messages moved to the dead letters, oldest first among messages
+// of the same priority.
+func (q *Queue) DeadLetters(max int) ([]*Message, error) {
+	var msgs []*Message
+	err := q.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = q.key(deadTag)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid() && len(msgs) < max; it.Next() {
+			msg, err := readMessage(it.Item())
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, msg)
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+// Requeue moves msg from the dead letters back to the queue, resetting its number of deliveries.
+func (q *Queue) Requeue(msg *Message) error {
+	return q.update(func(txn *badger.Txn) error {
+		if err := q.DeleteDeadLetter(txn, msg); err != nil {
+			return err
+		}
+		m := *msg
+		m.Deliveries = 0
+		return setMessage(txn, q.key(readyTag, msg.key[len(msg.key)-idSize:]), &m)
+	})
+}
+
+// DeleteDeadLetter removes msg from the dead letters, as part of txn.
+func (q *Queue) DeleteDeadLetter(txn *badger.Txn, msg *Message) error {
+	if len(msg.key) <= len(q.prefix) || msg.key[len(q.prefix)] != deadTag {
+		return errors.Errorf("Message %d is not a dead letter", msg.ID)
+	}
+	return txn.Delete(msg.key)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func openQueue(t *testing.T, opt Options) (*badger.DB, *Queue) {
+	db := kvtest.OpenInMemory(t)
+	q, err := Open(db, []byte("q/"), opt)
+	require.NoError(t, err)
+	return db, q
+}
+
+func enqueue(t *testing.T, db *badger.DB, q *Queue, payload string, priority uint8) {
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		_, err := q.Enqueue(txn, []byte(payload), priority)
+		return err
+	}))
+}
+
+func TestQueueOrder(t *testing.T) {
+	db, q := openQueue(t, DefaultOptions)
+	defer db.Close()
+	defer q.Close()
+
+	enqueue(t, db, q, "low1", 0)
+	enqueue(t, db, q, "high1", 5)
+	enqueue(t, db, q, "low2", 0)
+	enqueue(t, db, q, "high2", 5)
+
+	for _, want := range []string{"high1", "high2", "low1", "low2"} {
+		msg, err := q.Dequeue()
+		require.NoError(t, err)
+		require.Equal(t, want, string(msg.Payload))
+		require.Equal(t, 1, msg.Deliveries)
+		require.NoError(t, q.Ack(msg))
+	}
+	_, err := q.Dequeue()
+	require.Equal(t, ErrEmpty, err)
+}
+
+func TestQueueRedelivery(t *testing.T) {
+	db, q := openQueue(t, Options{VisibilityTimeout: 100 * time.Millisecond, MaxDeliveries: 2})
+	defer db.Close()
+	defer q.Close()
+
+	enqueue(t, db, q, "msg", 0)
+	msg, err := q.Dequeue()
+	require.NoError(t, err)
+	// The message is invisible until its visibility timeout runs out.
+	_, err = q.Dequeue()
+	require.Equal(t, ErrEmpty, err)
+	time.Sleep(150 * time.Millisecond)
+	require.Equal(t, ErrNotInFlight, q.Ack(msg))
+
+	msg, err = q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, 2, msg.Deliveries)
+	require.NoError(t, q.Nack(msg))
+
+	// Delivered twice already, the message goes to the dead letters.
+	_, err = q.Dequeue()
+	require.Equal(t, ErrEmpty, err)
+	dead, err := q.DeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	require.Equal(t, "msg", string(dead[0].Payload))
+
+	require.NoError(t, q.Requeue(dead[0]))
+	dead, err = q.DeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, dead, 0)
+	msg, err = q.Dequeue()
+	require.NoError(t, err)
+	require.Equal(t, 1, msg.Deliveries)
+	require.NoError(t, q.Ack(msg))
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// DatabaseFullError is the error of setting keys while the DB is bigger than
+// Options.MaxDatabaseSize. Its cause, as given by errors.Cause, is ErrDatabaseFull.
+type DatabaseFullError struct {
+	// Size is the size of the tables and value log files of the DB, in bytes.
+	Size    int64
+	MaxSize int64
+}
+
+func (e *DatabaseFullError) Error() string {
+	return fmt.Sprintf("%s: %d bytes, over the maximum of %d", ErrDatabaseFull, e.Size, e.MaxSize)
+}
+
+// Cause returns ErrDatabaseFull.
+func (e *DatabaseFullError) Cause() error {
+	return ErrDatabaseFull
+}
+
+// Unwrap returns ErrDatabaseFull.
+func (e *DatabaseFullError) Unwrap() error {
+	return ErrDatabaseFull
+}
+
+// diskSize returns the current size of the tables and value log files of the DB. Unlike Size, it
+// isn't updated once a minute but kept up to date as files get written and deleted.
+func (db *DB) diskSize() int64 {
+	size := atomic.LoadInt64(&db.tablesSize)
+	if db.opt.InMemory {
+		return size
+	}
+	// The writable file may be preallocated beyond what was written.
+	return size + atomic.LoadInt64(&db.vlog.doneSize) + int64(db.vlog.woffset())
+}
+
+// checkDatabaseSize returns a *DatabaseFullError if the DB is bigger than
+// Options.MaxDatabaseSize.
+func (db *DB) checkDatabaseSize() error {
+	if db.opt.MaxDatabaseSize <= 0 {
+		return nil
+	}
+	if size := db.diskSize(); size > db.opt.MaxDatabaseSize {
+		return &DatabaseFullError{Size: size, MaxSize: db.opt.MaxDatabaseSize}
+	}
+	return nil
+}
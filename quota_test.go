@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxDatabaseSize(t *testing.T) {
+	opt := getTestOptions("").WithMaxDatabaseSize(1 << 20)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 10<<10)
+		var err error
+		var n int
+		for ; n < 1000 && err == nil; n++ {
+			err = db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(fmt.Sprintf("key%d", n)), val)
+			})
+		}
+		require.Equal(t, ErrDatabaseFull, errors.Cause(err))
+		derr, ok := err.(*DatabaseFullError)
+		require.True(t, ok)
+		require.True(t, derr.Size > 1<<20)
+		require.Equal(t, int64(1<<20), derr.MaxSize)
+		require.True(t, n > 90 && n < 110, "%d keys set", n)
+
+		// Deleting keys still works.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("key0"))
+		}))
+		wb := db.NewWriteBatch()
+		require.Equal(t, ErrDatabaseFull, errors.Cause(wb.Set([]byte("key"), val)))
+		wb.Cancel()
+	})
+}
+
+// walkDiskSize computes the size diskSize keeps track of from the tables and value log files.
+func walkDiskSize(db *DB) int64 {
+	var size int64
+	for _, lh := range db.lc.levels {
+		size += lh.getTotalSize()
+	}
+	db.vlog.filesLock.RLock()
+	defer db.vlog.filesLock.RUnlock()
+	for fid, lf := range db.vlog.filesMap {
+		if fid == db.vlog.maxFid {
+			size += int64(db.vlog.woffset())
+		} else {
+			size += int64(lf.size)
+		}
+	}
+	return size
+}
+
+func TestDiskSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithValueLogFileSize(1 << 20)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	val := make([]byte, 10<<10)
+	for i := 0; i < 500; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), val, 0)
+	}
+	require.True(t, len(db.vlog.filesMap) > 1)
+	require.Equal(t, walkDiskSize(db), db.diskSize())
+	require.NoError(t, db.Flatten(1))
+	require.Equal(t, walkDiskSize(db), db.diskSize())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, walkDiskSize(db), db.diskSize())
+	for i := 0; i < 500; i++ {
+		txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, db.DropAll())
+	require.Equal(t, walkDiskSize(db), db.diskSize())
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/skl"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// rangeDigest is an order-independent digest of key-value pairs, the lane-wise sum of their
+// SHA-256 hashes, so that the digests of disjoint sets of pairs add up.
+type rangeDigest [4]uint64
+
+func (d *rangeDigest) add(key []byte, userMeta byte, val []byte) {
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	_, _ = h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(key)))])
+	_, _ = h.Write(key)
+	_, _ = h.Write([]byte{userMeta})
+	_, _ = h.Write(val)
+	sum := h.Sum(nil)
+	for i := range d {
+		d[i] += binary.BigEndian.Uint64(sum[8*i:])
+	}
+}
+
+func (d *rangeDigest) merge(o rangeDigest) {
+	for i := range d {
+		d[i] += o[i]
+	}
+}
+
+func (d *rangeDigest) sub(o rangeDigest) {
+	for i := range d {
+		d[i] -= o[i]
+	}
+}
+
+func (d rangeDigest) bytes() []byte {
+	b := make([]byte, 8*len(d))
+	for i, v := range d {
+		binary.BigEndian.PutUint64(b[8*i:], v)
+	}
+	return b
+}
+
+// tableRangeDigest is the digest of the latest version of the keys of a table, which is the digest
+// of its key range when no other table or memtable holds keys in it.
+type tableRangeDigest struct {
+	sum rangeDigest
+	// expiring is set if some of the keys expire, the digest depending on the time then.
+	expiring bool
+}
+
+// tableRangeDigests caches the digests of the tables, by table ID. Tables are immutable, so they
+// remain valid until the tables get deleted.
+type tableRangeDigests struct {
+	sync.Mutex
+	m map[uint64]tableRangeDigest
+}
+
+func (td *tableRangeDigests) get(id uint64) (tableRangeDigest, bool) {
+	td.Lock()
+	defer td.Unlock()
+	d, ok := td.m[id]
+	return d, ok
+}
+
+func (td *tableRangeDigests) set(id uint64, d tableRangeDigest) {
+	td.Lock()
+	defer td.Unlock()
+	if td.m == nil {
+		td.m = make(map[uint64]tableRangeDigest)
+	}
+	td.m[id] = d
+}
+
+// retain drops the digests of the tables which aren't in ids anymore.
+func (td *tableRangeDigests) retain(ids map[uint64]bool) {
+	td.Lock()
+	defer td.Unlock()
+	for id := range td.m {
+		if !ids[id] {
+			delete(td.m, id)
+		}
+	}
+}
+
+// tableDigestBuilder computes the digest of a table from its entries, added in order.
+type tableDigestBuilder struct {
+	db *DB
+	// inline makes add fail with errNotInline on the values in the value log, instead of reading
+	// them. Compactions can't read the value log, which gets closed before the last ones run.
+	inline  bool
+	d       tableRangeDigest
+	lastKey []byte
+}
+
+var errNotInline = errors.New("Value is in the value log")
+
+// add adds the entry of key, with timestamp, to the digest. Only the first version of every key
+// counts, the versions being added from the latest.
+func (b *tableDigestBuilder) add(key []byte, vs y.ValueStruct) error {
+	k := y.ParseKey(key)
+	if b.lastKey != nil && bytes.Equal(k, b.lastKey) {
+		return nil
+	}
+	b.lastKey = append(b.lastKey[:0], k...)
+	if bytes.HasPrefix(k, badgerPrefix) {
+		return nil
+	}
+	if vs.ExpiresAt > 0 {
+		b.d.expiring = true
+	}
+	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+		return nil
+	}
+	if vs.Meta&bitValuePointer > 0 {
+		if b.inline {
+			return errNotInline
+		}
+		// Keep the value log files from being deleted by value log GC meanwhile.
+		b.db.vlog.incrIteratorCount()
+		defer func() { _ = b.db.vlog.decrIteratorCount() }()
+	}
+	item := &Item{db: b.db, key: k, version: y.ParseTs(key), meta: vs.Meta, vptr: vs.Value}
+	val, cb, err := item.yieldItemValue()
+	defer runCallback(cb)
+	if err != nil {
+		return err
+	}
+	b.d.sum.add(k, vs.UserMeta, val)
+	return nil
+}
+
+// digestOf returns the digest of table t, computing it if it isn't cached.
+func (db *DB) digestOf(t *table.Table) (tableRangeDigest, error) {
+	if d, ok := db.rangeDigests.get(t.ID()); ok {
+		return d, nil
+	}
+	b := tableDigestBuilder{db: db}
+	it := t.NewIterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := b.add(it.Key(), it.Value()); err != nil {
+			return tableRangeDigest{}, err
+		}
+	}
+	db.rangeDigests.set(t.ID(), b.d)
+	return b.d, nil
+}
+
+// RangeDigest returns a digest of the latest version of the keys in the range [start, end], which
+// is the same for DBs holding the same keys, values and user metadata whatever their history, so
+// that replicas can be compared, or a restored DB checked against the original. If either start or
+// end is empty, it covers all the keys. Deleted and expired keys, the versions and the internal
+// keys of Badger don't count. With Options.KeyHashSecret, the range bounds the hashes the keys are
+// stored under.
+//
+// The digests of the tables get cached, see Options.RangeDigests, so that the key ranges of the
+// tables which don't overlap with other tables or the memtables don't have to be read again. The
+// rest of the range is read. Writes concurrent to RangeDigest may or may not be counted.
+func (db *DB) RangeDigest(start, end []byte) ([]byte, error) {
+	if len(start) == 0 || len(end) == 0 {
+		start, end = nil, nil
+	}
+	d, err := db.digestRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return d.bytes(), nil
+}
+
+// latestTxn returns a read-only transaction reading the latest version of the keys, in managed
+// mode too.
+func (db *DB) latestTxn() *Txn {
+	if db.opt.managedTxns {
+		return db.NewTransactionAt(math.MaxUint64, false)
+	}
+	return db.NewTransaction(false)
+}
+
+// digestRange returns the digest of the keys in [start, end], the range being unbounded on the
+// side of an empty bound.
+func (db *DB) digestRange(start, end []byte) (rangeDigest, error) {
+	txn := db.latestTxn()
+	defer txn.Discard()
+	inRange := func(k []byte) bool {
+		return (len(start) == 0 || db.opt.compareKeys(k, start) >= 0) &&
+			(len(end) == 0 || db.opt.compareKeys(k, end) <= 0)
+	}
+
+	it := txn.NewIterator(IteratorOptions{})
+	defer it.Close()
+	// The iterator holds a reference to the tables.
+	tables, err := db.digestedTables(inRange)
+	if err != nil {
+		return rangeDigest{}, err
+	}
+
+	var sum rangeDigest
+	for _, t := range tables {
+		sum.merge(t.d.sum)
+	}
+	it.Seek(start)
+	for it.Valid() {
+		item := it.Item()
+		if len(end) > 0 && db.opt.compareKeys(item.key, end) > 0 {
+			break
+		}
+		// Skip the key ranges of the tables having a digest.
+		if len(tables) > 0 && db.opt.compareKeys(item.key, tables[0].smallest) >= 0 {
+			if db.opt.compareKeys(item.key, tables[0].biggest) <= 0 {
+				it.Seek(tables[0].biggest)
+				if it.Valid() && bytes.Equal(it.Item().key, tables[0].biggest) {
+					it.Next()
+				}
+			}
+			tables = tables[1:]
+			continue
+		}
+		val, cb, err := item.yieldItemValue()
+		if err != nil {
+			runCallback(cb)
+			return rangeDigest{}, err
+		}
+		sum.add(item.key, item.userMeta, val)
+		runCallback(cb)
+		it.Next()
+	}
+	return sum, nil
+}
+
+// digestedTable is a table whose digest is the digest of its key range.
+type digestedTable struct {
+	smallest, biggest []byte // Without timestamps.
+	d                 tableRangeDigest
+}
+
+// digestedTables returns the tables in range whose digest is the digest of their key range, in
+// key order. Their key ranges don't overlap with the other tables and the memtables, and none of
+// their keys expire.
+func (db *DB) digestedTables(inRange func(k []byte) bool) ([]digestedTable, error) {
+	mts, decr := db.getMemTables()
+	defer decr()
+	levels := make([][]*table.Table, len(db.lc.levels))
+	ids := make(map[uint64]bool)
+	for l, lh := range db.lc.levels {
+		lh.RLock()
+		levels[l] = append([]*table.Table(nil), lh.tables...)
+		lh.RUnlock()
+		for _, t := range levels[l] {
+			ids[t.ID()] = true
+		}
+	}
+	db.rangeDigests.retain(ids)
+
+	var out []digestedTable
+	for l, tables := range levels {
+		for _, t := range tables {
+			smallest, biggest := y.ParseKey(t.Smallest()), y.ParseKey(t.Biggest())
+			if !inRange(smallest) || !inRange(biggest) ||
+				db.overlapsOthers(levels, l, t) || memtablesHold(mts, smallest, biggest, &db.opt) {
+				continue
+			}
+			d, err := db.digestOf(t)
+			if err != nil {
+				return nil, err
+			}
+			if !d.expiring {
+				out = append(out, digestedTable{smallest: smallest, biggest: biggest, d: d})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return db.opt.compareKeys(out[i].smallest, out[j].smallest) < 0
+	})
+	return out, nil
+}
+
+// overlapsOthers returns true if the key range of table t of level l overlaps with another table.
+func (db *DB) overlapsOthers(levels [][]*table.Table, l int, t *table.Table) bool {
+	kr := getKeyRange(t)
+	for ol, tables := range levels {
+		if ol == 0 {
+			for _, o := range tables {
+				if o != t && kr.overlapsWith(getKeyRange(o), db.opt.Comparator) {
+					return true
+				}
+			}
+			continue
+		}
+		// The tables of the other levels are sorted, and don't overlap each other.
+		i := sort.Search(len(tables), func(i int) bool {
+			return y.CompareKeysWith(db.opt.Comparator, tables[i].Biggest(), kr.left) >= 0
+		})
+		for ; i < len(tables); i++ {
+			o := tables[i]
+			if !kr.overlapsWith(getKeyRange(o), db.opt.Comparator) {
+				break
+			}
+			if o != t || ol != l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memtablesHold returns true if one of the memtables holds a key in [smallest, biggest].
+func memtablesHold(mts []*skl.Skiplist, smallest, biggest []byte, opt *Options) bool {
+	for _, mt := range mts {
+		it := mt.NewIterator()
+		it.Seek(y.KeyWithTs(smallest, math.MaxUint64))
+		hold := it.Valid() && opt.compareKeys(y.ParseKey(it.Key()), biggest) <= 0
+		_ = it.Close()
+		if hold {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeDigest(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	val := func(i int) []byte {
+		if i%10 == 0 {
+			// In the value log.
+			return bytes.Repeat([]byte{byte(i)}, 2<<10)
+		}
+		return bytes.Repeat([]byte{byte(i)}, 64)
+	}
+	const n = 2000
+
+	opt := getTestOptions("").WithRangeDigests(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		// Write older versions and deleted keys first, and flatten the tables.
+		for _, v := range []string{"old", "older"} {
+			wb := db.NewWriteBatch()
+			for i := 0; i < n; i++ {
+				require.NoError(t, wb.Set(key(i), []byte(v)))
+			}
+			require.NoError(t, wb.Set([]byte("deleted"), []byte(v)))
+			require.NoError(t, wb.Flush())
+		}
+		wb := db.NewWriteBatch()
+		for i := 0; i < n; i++ {
+			require.NoError(t, wb.SetEntry(NewEntry(key(i), val(i)).WithMeta(byte(i))))
+		}
+		require.NoError(t, wb.Delete([]byte("deleted")))
+		require.NoError(t, wb.Flush())
+		// Flush the memtables, so that the tables hold all the keys.
+		require.NoError(t, db.Close())
+		db, err := Open(db.opt)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, db.Close()) }()
+		require.NoError(t, db.Flatten(1))
+
+		runBadgerTest(t, nil, func(t *testing.T, other *DB) {
+			// Write the same keys in reverse order, without flattening.
+			wb := other.NewWriteBatch()
+			for i := n - 1; i >= 0; i-- {
+				require.NoError(t, wb.SetEntry(NewEntry(key(i), val(i)).WithMeta(byte(i))))
+			}
+			require.NoError(t, wb.Flush())
+
+			digests := func(db *DB) (all, sub []byte) {
+				all, err := db.RangeDigest(nil, nil)
+				require.NoError(t, err)
+				sub, err = db.RangeDigest(key(100), key(1499))
+				require.NoError(t, err)
+				return all, sub
+			}
+			all, sub := digests(db)
+			require.Len(t, all, 32)
+			require.NotEqual(t, all, sub)
+			otherAll, otherSub := digests(other)
+			require.Equal(t, all, otherAll)
+			require.Equal(t, sub, otherSub)
+			// The digests of the tables got cached.
+			require.NotEmpty(t, db.rangeDigests.m)
+			tables, err := db.digestedTables(func([]byte) bool { return true })
+			require.NoError(t, err)
+			require.NotEmpty(t, tables)
+			again, _ := digests(db)
+			require.Equal(t, all, again)
+
+			// A change outside of the subrange only changes the digest of everything.
+			require.NoError(t, other.Update(func(txn *Txn) error {
+				return txn.Set(key(n-1), []byte("changed"))
+			}))
+			otherAll, otherSub = digests(other)
+			require.NotEqual(t, all, otherAll)
+			require.Equal(t, sub, otherSub)
+		})
+	})
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ratelimit implements persistent rate limiters on top of Badger, keeping one counter per
+// key, e.g. per client or per API token.
+//
+// Counters are stored as small fixed-size values, with a TTL set to the time after which they
+// don't matter anymore, so that Badger gets rid of the counters of idle keys by itself. Updates of
+// the same counter are serialized within the process instead of relying on transaction conflicts,
+// so that heavy contention on a key doesn't turn into a storm of retried transactions.
+package ratelimit
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// numLockStripes is the number of locks used to serialize counter updates.
+const numLockStripes = 256
+
+// maxConflictRetries is the number of times a counter update is retried when its transaction
+// conflicts with a write done outside of the limiter.
+const maxConflictRetries = 10
+
+// store keeps the counters of a limiter under a key prefix.
+type store struct {
+	db     *badger.DB
+	prefix []byte
+	locks  [numLockStripes]sync.Mutex
+}
+
+func (s *store) key(key []byte) []byte {
+	k := make([]byte, 0, len(s.prefix)+len(key))
+	k = append(k, s.prefix...)
+	return append(k, key...)
+}
+
+// update calls fn with the current counter of key, nil if there's none, and sets the counter to
+// the value returned by fn, expiring at expiresAt.
+func (s *store) update(key []byte,
+	fn func(val []byte) (newVal []byte, expiresAt time.Time)) error {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	mu := &s.locks[h.Sum32()%numLockStripes]
+	mu.Lock()
+	defer mu.Unlock()
+
+	k := s.key(key)
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			var val []byte
+			item, err := txn.Get(k)
+			switch {
+			case err == badger.ErrKeyNotFound:
+			case err != nil:
+				return err
+			default:
+				if val, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+			}
+			newVal, expiresAt := fn(val)
+			e := badger.NewEntry(k, newVal)
+			// Badger expires keys with a precision of one second, round up.
+			e.ExpiresAt = uint64(expiresAt.Unix()) + 1
+			return txn.SetEntry(e)
+		})
+		if errors.Cause(err) != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// TokenBucket is a token bucket rate limiter. Every key has a bucket holding up to burst tokens,
+// refilled at a given rate, and every event takes tokens from the bucket of its key.
+type TokenBucket struct {
+	store
+	rate  float64
+	burst float64
+}
+
+// NewTokenBucket returns a token bucket rate limiter keeping its buckets in db under prefix, with
+// buckets holding up to burst tokens and refilled with rate tokens per second. The prefix must
+// not be used for anything else.
+func NewTokenBucket(db *badger.DB, prefix []byte, rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		store: store{db: db, prefix: append([]byte{}, prefix...)},
+		rate:  rate,
+		burst: float64(burst),
+	}
+}
+
+// Allow is shorthand for AllowN(key, time.Now(), 1).
+func (tb *TokenBucket) Allow(key []byte) (bool, error) {
+	return tb.AllowN(key, time.Now(), 1)
+}
+
+// AllowN reports whether n events may happen for key at time now, taking n tokens from its bucket
+// if so.
+func (tb *TokenBucket) AllowN(key []byte, now time.Time, n int) (bool, error) {
+	var allowed bool
+	err := tb.update(key, func(val []byte) ([]byte, time.Time) {
+		// The value is the number of tokens, as a float64, followed by the time they were counted
+		// at, in nanoseconds since the epoch. A missing bucket is full.
+		tokens, last := tb.burst, now
+		if len(val) == 16 {
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(val[0:8]))
+			last = time.Unix(0, int64(binary.BigEndian.Uint64(val[8:16])))
+		}
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens = math.Min(tb.burst, tokens+elapsed.Seconds()*tb.rate)
+			last = now
+		}
+		allowed = tokens >= float64(n)
+		if allowed {
+			tokens -= float64(n)
+		}
+
+		newVal := make([]byte, 16)
+		binary.BigEndian.PutUint64(newVal[0:8], math.Float64bits(tokens))
+		binary.BigEndian.PutUint64(newVal[8:16], uint64(last.UnixNano()))
+		// Once full again, the bucket is the same as a missing one.
+		full := last.Add(100 * 365 * 24 * time.Hour)
+		if tb.rate > 0 {
+			full = last.Add(time.Duration((tb.burst - tokens) / tb.rate * float64(time.Second)))
+		}
+		return newVal, full
+	})
+	return allowed, err
+}
+
+// SlidingWindow is a sliding window rate limiter, allowing up to limit events per key in any
+// window of time. The number of events in the sliding window is estimated from the counts of the
+// current and the previous fixed windows.
+type SlidingWindow struct {
+	store
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindow returns a sliding window rate limiter keeping its counters in db under prefix,
+// allowing limit events per key in any window of time. The prefix must not be used for anything
+// else.
+func NewSlidingWindow(db *badger.DB, prefix []byte, limit int,
+	window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		store:  store{db: db, prefix: append([]byte{}, prefix...)},
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow is shorthand for AllowN(key, time.Now(), 1).
+func (sw *SlidingWindow) Allow(key []byte) (bool, error) {
+	return sw.AllowN(key, time.Now(), 1)
+}
+
+// AllowN reports whether n events may happen for key at time now, counting them if so.
+func (sw *SlidingWindow) AllowN(key []byte, now time.Time, n int) (bool, error) {
+	var allowed bool
+	start := now.Truncate(sw.window)
+	err := sw.update(key, func(val []byte) ([]byte, time.Time) {
+		// The value is the start of the current fixed window, in nanoseconds since the epoch,
+		// followed by the counts of the current and the previous windows.
+		var cur, prev uint64
+		if len(val) == 24 {
+			valStart := time.Unix(0, int64(binary.BigEndian.Uint64(val[0:8])))
+			switch {
+			case valStart.Equal(start):
+				cur = binary.BigEndian.Uint64(val[8:16])
+				prev = binary.BigEndian.Uint64(val[16:24])
+			case valStart.Add(sw.window).Equal(start):
+				prev = binary.BigEndian.Uint64(val[8:16])
+			}
+		}
+		weight := 1 - float64(now.Sub(start))/float64(sw.window)
+		allowed = float64(prev)*weight+float64(cur)+float64(n) <= float64(sw.limit)
+		if allowed {
+			cur += uint64(n)
+		}
+
+		newVal := make([]byte, 24)
+		binary.BigEndian.PutUint64(newVal[0:8], uint64(start.UnixNano()))
+		binary.BigEndian.PutUint64(newVal[8:16], cur)
+		binary.BigEndian.PutUint64(newVal[16:24], prev)
+		// Counts older than the previous window don't matter.
+		return newVal, start.Add(2 * sw.window)
+	})
+	return allowed, err
+}
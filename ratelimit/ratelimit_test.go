@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	tb := NewTokenBucket(db, []byte("tb/"), 10, 5)
+
+	now := time.Now()
+	allow := func(key string, at time.Time, n int) bool {
+		ok, err := tb.AllowN([]byte(key), at, n)
+		require.NoError(t, err)
+		return ok
+	}
+	for i := 0; i < 5; i++ {
+		require.True(t, allow("a", now, 1))
+	}
+	require.False(t, allow("a", now, 1))
+	// Other keys have their own bucket.
+	require.True(t, allow("b", now, 5))
+	// 10 tokens per second, 2 tokens are back after 200ms.
+	require.False(t, allow("a", now.Add(200*time.Millisecond), 3))
+	require.True(t, allow("a", now.Add(200*time.Millisecond), 2))
+	// Buckets never hold more than burst tokens.
+	require.False(t, allow("a", now.Add(time.Hour), 6))
+	require.True(t, allow("a", now.Add(time.Hour), 5))
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	tb := NewTokenBucket(db, []byte("tb/"), 0.001, 100)
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				ok, err := tb.Allow([]byte("key"))
+				require.NoError(t, err)
+				if ok {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(100), allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+	sw := NewSlidingWindow(db, []byte("sw/"), 10, time.Minute)
+
+	start := time.Now().Truncate(time.Minute)
+	allow := func(at time.Time, n int) bool {
+		ok, err := sw.AllowN([]byte("key"), at, n)
+		require.NoError(t, err)
+		return ok
+	}
+	require.True(t, allow(start, 10))
+	require.False(t, allow(start.Add(30*time.Second), 1))
+	// Half way through the next window, half of the previous one still counts.
+	require.True(t, allow(start.Add(90*time.Second), 5))
+	require.False(t, allow(start.Add(90*time.Second), 1))
+	// Two windows later, nothing counts anymore.
+	require.True(t, allow(start.Add(3*time.Minute), 10))
+}
@@ -0,0 +1,452 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"golang.org/x/net/trace"
+)
+
+// ReencryptProgress reports the progress of DB.ReencryptAll.
+type ReencryptProgress struct {
+	TablesDone, TablesTotal               int
+	ValueLogFilesDone, ValueLogFilesTotal int
+}
+
+// ReencryptAll rewrites every table and value log file encrypted with a data key whose ID is lower
+// than keyID, so that these data keys aren't needed anymore, e.g. after one of them got
+// compromised. The data keys used for new files are rotated first if they are older than keyID,
+// so keyID can be at most one more than the ID of the latest data key. Files get rewritten one at
+// a time, along with the regular compactions and value log GC, and progress is called after every
+// file if it isn't nil.
+//
+// ReencryptAll returns early with the error of ctx if it gets canceled. The files rewritten up to
+// that point remain rewritten, so calling it again picks up where it stopped. It returns
+// ErrFrozen while the DB is frozen.
+func (db *DB) ReencryptAll(ctx context.Context, keyID uint64,
+	progress func(ReencryptProgress)) error {
+	if db.opt.ReadOnly {
+		return errors.New("ReencryptAll cannot be called in read-only mode")
+	}
+	if db.opt.InMemory || !db.shouldEncrypt() {
+		return nil
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := db.registry.rotateOlderThan(keyID); err != nil {
+		return err
+	}
+	oldKey := func(id uint64) bool { return id > 0 && id < keyID }
+	return db.reencrypt(ctx, oldKey, oldKey, progress)
+}
+
+// reencrypt rewrites every table whose data key ID satisfies tableKey, and every value log file
+// whose data key ID satisfies vlogKey, with the latest data keys. See ReencryptAll.
+func (db *DB) reencrypt(ctx context.Context, tableKey, vlogKey func(id uint64) bool,
+	progress func(ReencryptProgress)) error {
+	// Get all the data in memory and in the value log head out of the way first.
+	if err := db.flushHead(); err != nil {
+		return errors.Wrap(err, "while flushing memtables")
+	}
+
+	var p ReencryptProgress
+	tables := make([][]*table.Table, len(db.lc.levels))
+	for l, lh := range db.lc.levels {
+		lh.RLock()
+		for _, t := range lh.tables {
+			if tableKey(t.KeyID()) {
+				tables[l] = append(tables[l], t)
+			}
+		}
+		lh.RUnlock()
+		p.TablesTotal += len(tables[l])
+	}
+	fids := db.vlog.fidsWithKeys(vlogKey)
+	p.ValueLogFilesTotal = len(fids)
+	report := func() {
+		if progress != nil {
+			progress(p)
+		}
+	}
+	report()
+
+	for l, tbls := range tables {
+		if len(tbls) == 0 {
+			continue
+		}
+		if l == 0 {
+			// Tables in level 0 can only be compacted away all at once.
+			if err := db.lc.compactLevelRange(0, infRange); err != nil {
+				return err
+			}
+			p.TablesDone += len(tbls)
+			report()
+			continue
+		}
+		for _, t := range tbls {
+			for {
+				done, err := db.lc.rewriteTable(l, t, nil)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+				// A compaction is running on the table. Once done, the table is either gone or
+				// free to be rewritten.
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			p.TablesDone++
+			report()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The MANIFEST holds the key ranges of the tables, so it follows them.
+	if err := db.manifest.rekey(tableKey); err != nil {
+		return err
+	}
+	for _, fid := range fids {
+		if err := db.vlog.reencrypt(ctx, fid); err != nil {
+			return err
+		}
+		p.ValueLogFilesDone++
+		report()
+	}
+	return nil
+}
+
+// flushHead starts a new value log file and flushes all the memtables, persisting a value log
+// head pointing to the new file. All the value log files written before are behind the head
+// once done, so they can be rewritten.
+func (db *DB) flushHead() error {
+	f := db.prepareToDrop()
+	defer f()
+	// Block all foreign interactions with memory tables.
+	db.Lock()
+	defer db.Unlock()
+
+	atomic.StoreInt32(&db.vlog.rotateHead, 1)
+	if err := db.vlog.write(nil); err != nil {
+		return err
+	}
+	vptr := valuePointer{Fid: atomic.LoadUint32(&db.vlog.maxFid), Offset: db.vlog.woffset()}
+	y.AssertTrue(!vptr.Less(db.vhead))
+	db.vhead = vptr
+	// An empty memtable doesn't get flushed, make sure the head gets persisted anyway.
+	db.mt.Put(y.KeyWithTs(head, db.orc.nextTs()), y.ValueStruct{Value: vptr.Encode()})
+
+	db.imm = append(db.imm, db.mt)
+	for _, memtable := range db.imm {
+		if err := db.handleFlushTask(flushTask{mt: memtable, vptr: vptr}); err != nil {
+			return err
+		}
+		memtable.DecrRef()
+	}
+	db.imm = db.imm[:0]
+	db.mt = newSkiplist(db.opt)
+	return nil
+}
+
+// rewriteTable rewrites table t of level l, encrypting it with the latest data key, and leaving out
+// the versions of the keys in drop. It returns false if t is being compacted, in which case it
+// should be called again later.
+func (s *levelsController) rewriteTable(l int, t *table.Table, drop [][]byte) (bool, error) {
+	lh := s.levels[l]
+	kr := getKeyRange(t)
+	lh.RLock()
+	var found bool
+	for _, tbl := range lh.tables {
+		if tbl == t {
+			found = true
+			break
+		}
+	}
+	if !found {
+		// The table got compacted away, using the latest data key.
+		lh.RUnlock()
+		return true, nil
+	}
+	ok := s.cstatus.compareAndAddLevel(levelHandlerRLocked{}, l, kr)
+	if ok {
+		t.IncrRef()
+	}
+	lh.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	defer s.cstatus.deleteLevel(l, kr)
+	defer func() { _ = t.DecrRef() }()
+
+	it := t.NewIterator(false)
+	defer it.Close()
+	var newTables []*table.Table
+	defer func() {
+		for _, nt := range newTables {
+			_ = nt.DecrRef()
+		}
+	}()
+	it.Rewind()
+	for it.Valid() {
+		// Keys of a prefix having its own data keys get a table of their own.
+		scope := s.kv.registry.keyScope(y.ParseKey(it.Key()))
+		dk, err := s.kv.registry.latestDataKey(scope)
+		if err != nil {
+			return false,
+				y.Wrapf(err, "Error while retrieving datakey in levelsController.rewriteTable")
+		}
+		bopts := buildTableOptions(s.kv.opt)
+		bopts.DataKey = dk
+		// Builder does not need cache but the same options are used for opening table.
+		bopts.Cache = s.kv.blockCache
+		builder := table.NewTableBuilder(bopts)
+		for ; it.Valid(); it.Next() {
+			if !bytes.Equal(s.kv.registry.keyScope(y.ParseKey(it.Key())), scope) {
+				break
+			}
+			if hasKey(drop, y.ParseKey(it.Key())) {
+				continue
+			}
+			vs := it.Value()
+			var vp valuePointer
+			if vs.Meta&bitValuePointer > 0 {
+				vp.Decode(vs.Value)
+			}
+			builder.Add(it.Key(), vs, vp.Len)
+		}
+		if builder.Empty() {
+			builder.Close()
+			continue
+		}
+		newTable, err := s.writeTable(builder, bopts)
+		builder.Close()
+		if err != nil {
+			return false, err
+		}
+		newTables = append(newTables, newTable)
+	}
+	if err := s.kv.syncDir(s.kv.opt.Dir); err != nil {
+		return false, err
+	}
+
+	var changes []*pb.ManifestChange
+	var ids []uint64
+	for _, nt := range newTables {
+		changes = append(changes, newTableCreateChange(nt, l))
+		ids = append(ids, nt.ID())
+	}
+	changes = append(changes, newDeleteChange(t.ID()))
+	if err := s.kv.manifest.addChanges(changes); err != nil {
+		return false, err
+	}
+	s.kv.tableEvents.send(append(tableChanges(l, false, t), tableChanges(l, true, newTables...)...))
+	if err := lh.replaceTables([]*table.Table{t}, newTables); err != nil {
+		return false, err
+	}
+	s.kv.opt.Infof("Rewrote table %d at level %d as tables %v", t.ID(), l, ids)
+	return true, nil
+}
+
+// writeTable writes the table built by builder to a new file, and opens it with bopts.
+func (s *levelsController) writeTable(builder *table.Builder, bopts table.Options) (
+	*table.Table, error) {
+	fileID := s.reserveFileID()
+	fd, err := y.CreateSyncedFile(table.NewFilename(fileID, s.kv.opt.Dir), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "While opening new table: %d", fileID)
+	}
+	data := builder.Finish()
+	if _, err := fd.Write(data); err != nil {
+		return nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)
+	}
+	newTable, err := table.OpenTable(fd, bopts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open table: %q", fd.Name())
+	}
+	newTable.Digest = s.kv.tableDigest(data)
+	return newTable, nil
+}
+
+// fidsWithKeys returns the IDs of the value log files encrypted with a data key whose ID
+// satisfies keyID, excluding the file being written to.
+func (vlog *valueLog) fidsWithKeys(keyID func(id uint64) bool) []uint32 {
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	maxFid := atomic.LoadUint32(&vlog.maxFid)
+	var fids []uint32
+	for _, fid := range vlog.sortedFids() {
+		if fid < maxFid && keyID(vlog.filesMap[fid].keyID()) {
+			fids = append(fids, fid)
+		}
+	}
+	return fids
+}
+
+// reencrypt rewrites the value log file fid, moving the entries still in use to the file being
+// written to. It waits for any running value log GC to finish first.
+func (vlog *valueLog) reencrypt(ctx context.Context, fid uint32) error {
+	select {
+	case vlog.garbageCh <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-vlog.garbageCh }()
+
+	vlog.filesLock.RLock()
+	lf, ok := vlog.filesMap[fid]
+	vlog.filesLock.RUnlock()
+	if !ok {
+		// The file got garbage collected already.
+		return nil
+	}
+	tr := trace.New("Badger.ValueLog", vlog.opt.traceTitle("Reencrypt"))
+	tr.SetMaxEvents(100)
+	defer tr.Finish()
+	if err := vlog.rewrite(lf, tr); err != nil {
+		return errors.Wrapf(err, "while rewriting value log file %d", fid)
+	}
+	return vlog.deleteMoveKeysFor(fid, tr)
+}
+
+// plaintextEncryption tracks the encryption of the files written in plain text, started by Open
+// with Options.EncryptPlaintextFiles.
+type plaintextEncryption struct {
+	sync.Mutex
+	running  bool
+	progress ReencryptProgress
+}
+
+// PlaintextEncryptionProgress returns the progress of the encryption of the tables and value log
+// files written in plain text, and whether it's still running. See
+// Options.WithEncryptPlaintextFiles.
+func (db *DB) PlaintextEncryptionProgress() (ReencryptProgress, bool) {
+	db.plaintext.Lock()
+	defer db.plaintext.Unlock()
+	return db.plaintext.progress, db.plaintext.running
+}
+
+// encryptKeyRegistry encrypts the key registry in opt.Dir with the master key of opt, if it was
+// written in plain text by a DB opened without encryption.
+func encryptKeyRegistry(opt KeyRegistryOptions) error {
+	if _, err := os.Stat(filepath.Join(opt.Dir, KeyRegistryFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	kr, err := OpenKeyRegistry(KeyRegistryOptions{Dir: opt.Dir, ReadOnly: true})
+	if errors.Cause(err) == ErrEncryptionKeyMismatch {
+		// The key registry is encrypted already.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer kr.Close()
+	return errors.Wrap(WriteKeyRegistry(kr, opt), "while encrypting key registry")
+}
+
+// startPlaintextEncryption starts rewriting the tables and value log files written in plain text
+// under data keys, if there are any.
+func (db *DB) startPlaintextEncryption() {
+	tableKey := func(id uint64) bool { return id == 0 && !db.opt.DisableTableEncryption }
+	vlogKey := func(id uint64) bool { return id == 0 && !db.opt.DisableValueLogEncryption }
+	var found bool
+	for _, lh := range db.lc.levels {
+		lh.RLock()
+		for _, t := range lh.tables {
+			found = found || tableKey(t.KeyID())
+		}
+		lh.RUnlock()
+	}
+	db.vlog.filesLock.RLock()
+	// The file being written to counts too, it gets rotated first.
+	for _, lf := range db.vlog.filesMap {
+		found = found || vlogKey(lf.keyID())
+	}
+	db.vlog.filesLock.RUnlock()
+	if !found {
+		return
+	}
+
+	db.plaintext.running = true
+	db.closers.plaintext = y.NewCloser(1)
+	go func(lc *y.Closer) {
+		defer lc.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-lc.HasBeenClosed():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// Like ReencryptAll, the encryption can't run while the DB is frozen.
+		done, err := db.startRewrite()
+		for err == ErrFrozen {
+			select {
+			case <-time.After(time.Second):
+				done, err = db.startRewrite()
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if err == nil {
+			db.opt.Infof("Encrypting the files written in plain text")
+			err = db.reencrypt(ctx, tableKey, vlogKey, func(p ReencryptProgress) {
+				db.plaintext.Lock()
+				db.plaintext.progress = p
+				db.plaintext.Unlock()
+			})
+			done()
+		}
+		p, _ := db.PlaintextEncryptionProgress()
+		switch {
+		case err != nil && err == ctx.Err():
+			db.opt.Infof("Stopped encrypting the files written in plain text after %d tables and "+
+				"%d value log files, it resumes when the DB gets opened again",
+				p.TablesDone, p.ValueLogFilesDone)
+		case err != nil:
+			db.opt.Errorf("While encrypting the files written in plain text: %v", err)
+		default:
+			db.opt.Infof("Encrypted the files written in plain text: %d tables and %d value "+
+				"log files", p.TablesDone, p.ValueLogFilesDone)
+		}
+		db.plaintext.Lock()
+		db.plaintext.running = false
+		db.plaintext.Unlock()
+	}(db.closers.plaintext)
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReencryptAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	opt := getTestOptions(dir).WithEncryptionKey(key).WithValueThreshold(64).
+		WithValueLogMaxEntries(200)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	const n = 2000
+	keyFor := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	val := make([]byte, 128)
+	rand.Read(val)
+	for i := 0; i < n; i += 10 {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for j := i; j < i+10; j++ {
+				if err := txn.Set(keyFor(j), val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	oldKeyID := db.registry.nextKeyID
+	var last ReencryptProgress
+	err = db.ReencryptAll(context.Background(), oldKeyID+1, func(p ReencryptProgress) {
+		last = p
+	})
+	require.NoError(t, err)
+	require.True(t, last.TablesTotal > 0)
+	require.True(t, last.ValueLogFilesTotal > 0)
+	require.Equal(t, last.TablesTotal, last.TablesDone)
+	require.Equal(t, last.ValueLogFilesTotal, last.ValueLogFilesDone)
+
+	for _, lh := range db.lc.levels {
+		for _, tbl := range lh.tables {
+			require.True(t, tbl.KeyID() > oldKeyID, "table %d", tbl.ID())
+		}
+	}
+	for fid, lf := range db.vlog.filesMap {
+		require.True(t, lf.keyID() > oldKeyID, "vlog file %d", fid)
+	}
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get(keyFor(i))
+				require.NoError(t, err)
+				require.Equal(t, val, getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestReencryptAllFrozen(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	opt := getTestOptions("").WithEncryptionKey(key)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		require.NoError(t, db.Freeze())
+		keyID := db.registry.nextKeyID + 1
+		require.Equal(t, ErrFrozen, db.ReencryptAll(context.Background(), keyID, nil))
+		require.NoError(t, db.Thaw())
+		require.NoError(t, db.ReencryptAll(context.Background(), keyID, nil))
+	})
+}
+
+func TestEncryptPlaintextFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithValueThreshold(64).WithValueLogMaxEntries(200)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	const n = 2000
+	keyFor := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	val := make([]byte, 128)
+	rand.Read(val)
+	for i := 0; i < n; i += 10 {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for j := i; j < i+10; j++ {
+				if err := txn.Set(keyFor(j), val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	_, err = Open(opt.WithEncryptionKey(key))
+	require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+
+	opt = opt.WithEncryptionKey(key).WithEncryptPlaintextFiles(true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	var p ReencryptProgress
+	for running := true; running; {
+		time.Sleep(10 * time.Millisecond)
+		p, running = db.PlaintextEncryptionProgress()
+	}
+	require.True(t, p.TablesTotal > 0)
+	require.True(t, p.ValueLogFilesTotal > 0)
+	require.Equal(t, p.TablesTotal, p.TablesDone)
+	require.Equal(t, p.ValueLogFilesTotal, p.ValueLogFilesDone)
+	for _, lh := range db.lc.levels {
+		for _, tbl := range lh.tables {
+			require.NotZero(t, tbl.KeyID(), "table %d", tbl.ID())
+		}
+	}
+	for fid, lf := range db.vlog.filesMap {
+		require.NotZero(t, lf.keyID(), "vlog file %d", fid)
+	}
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get(keyFor(i))
+				require.NoError(t, err)
+				require.Equal(t, val, getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// There's nothing left to encrypt, and the key registry can only be opened with the key.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	_, running := db.PlaintextEncryptionProgress()
+	require.False(t, running)
+	check(db)
+	require.NoError(t, db.Close())
+	_, err = Open(opt.WithEncryptionKey(nil))
+	require.Equal(t, ErrEncryptionKeyMismatch, errors.Cause(err))
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// Rename moves the value of oldKey to newKey, along with its user metadata and expiration time,
+// and deletes oldKey, as part of txn. It returns ErrKeyNotFound if oldKey doesn't exist.
+//
+// Values stored in the value log aren't read: newKey gets a pointer to the value of oldKey, so
+// renaming keys with large values costs about as much as deleting them. Value log GC keeps the
+// value around for as long as newKey refers to it.
+func (txn *Txn) Rename(oldKey, newKey []byte) error {
+	item, err := txn.Get(oldKey)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	e := &Entry{Key: newKey, UserMeta: item.UserMeta(), ExpiresAt: item.ExpiresAt()}
+	var vp valuePointer
+	if item.meta&bitValuePointer > 0 {
+		vp.Decode(item.vptr)
+	}
+	// The value is shared if it's stored in the same form for both keys, which it isn't with
+	// hashed keys since the key is stored along with it.
+	vt := txn.db.transformers
+	if item.meta&bitValuePointer > 0 && vt.index(oldKey) == vt.index(newKey) &&
+		txn.db.hasher == nil &&
+		txn.db.vlog.pins.pin(&txn.db.vlog, vp.Fid) {
+		e.Value = y.SafeCopy(nil, item.vptr)
+		e.meta = bitValuePointer
+		txn.pinnedFids = append(txn.pinnedFids, vp.Fid)
+	} else if e.Value, err = item.ValueCopy(nil); err != nil {
+		return err
+	}
+	if err := txn.modify(e); err != nil {
+		return err
+	}
+	if e.meta&bitValuePointer > 0 {
+		// Record that newKey shares the value, so that value log GC moves it for newKey too.
+		alias := &Entry{Key: aliasKey(vp, newKey)}
+		if err := txn.checkSize(alias); err != nil {
+			return err
+		}
+		txn.writes = append(txn.writes, z.MemHash(alias.Key))
+		txn.setPendingWrite(string(alias.Key), alias)
+	}
+	return txn.Delete(oldKey)
+}
+
+// aliasKey returns the internal key recording that key refers to the value at vp.
+func aliasKey(vp valuePointer, key []byte) []byte {
+	k := make([]byte, len(badgerAlias)+8+len(key))
+	n := copy(k, badgerAlias)
+	binary.BigEndian.PutUint32(k[n:], vp.Fid)
+	binary.BigEndian.PutUint32(k[n+4:], vp.Offset)
+	copy(k[n+8:], key)
+	return k
+}
+
+// valuePins keeps value log GC away from the files holding values which renamed keys are about to
+// point to, until the renames are committed.
+type valuePins struct {
+	sync.Mutex
+	pins      map[uint32]int
+	rewriting map[uint32]bool
+}
+
+// pin returns false if the file fid is gone or being rewritten, in which case the value can't
+// be shared.
+func (vp *valuePins) pin(vlog *valueLog, fid uint32) bool {
+	vp.Lock()
+	defer vp.Unlock()
+	vlog.filesLock.RLock()
+	_, ok := vlog.filesMap[fid]
+	vlog.filesLock.RUnlock()
+	if !ok || vp.rewriting[fid] {
+		return false
+	}
+	if vp.pins == nil {
+		vp.pins = make(map[uint32]int)
+	}
+	vp.pins[fid]++
+	return true
+}
+
+func (vp *valuePins) unpin(fids []uint32) {
+	if len(fids) == 0 {
+		return
+	}
+	vp.Lock()
+	defer vp.Unlock()
+	for _, fid := range fids {
+		if vp.pins[fid]--; vp.pins[fid] == 0 {
+			delete(vp.pins, fid)
+		}
+	}
+}
+
+// startRewrite returns false if the file fid can't be rewritten because of pending renames.
+func (vp *valuePins) startRewrite(fid uint32) bool {
+	vp.Lock()
+	defer vp.Unlock()
+	if vp.pins[fid] > 0 {
+		return false
+	}
+	if vp.rewriting == nil {
+		vp.rewriting = make(map[uint32]bool)
+	}
+	vp.rewriting[fid] = true
+	return true
+}
+
+func (vp *valuePins) endRewrite(fid uint32) {
+	vp.Lock()
+	defer vp.Unlock()
+	delete(vp.rewriting, fid)
+}
+
+// aliasesFor returns the keys, with their timestamp, sharing the values of the value log file fid,
+// by offset.
+func (vlog *valueLog) aliasesFor(fid uint32) (map[uint32][][]byte, error) {
+	prefix := make([]byte, len(badgerAlias)+4)
+	n := copy(prefix, badgerAlias)
+	binary.BigEndian.PutUint32(prefix[n:], fid)
+	aliases := make(map[uint32][][]byte)
+	err := vlog.db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.InternalAccess = true
+		opt.PrefetchValues = false
+		opt.Prefix = prefix
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			k := itr.Item().Key()[len(prefix):]
+			offset := binary.BigEndian.Uint32(k)
+			aliases[offset] = append(aliases[offset], y.KeyWithTs(k[4:], itr.Item().Version()))
+		}
+		return nil
+	})
+	return aliases, err
+}
+
+// moveAliases returns the entries moving the value of e for the keys sharing it, and deleting
+// their alias keys.
+func (vlog *valueLog) moveAliases(fid uint32, e Entry, aliases [][]byte) ([]*Entry, error) {
+	var entries []*Entry
+	for _, key := range aliases {
+		vs, err := vlog.db.get(key)
+		if err != nil {
+			return nil, err
+		}
+		var vp valuePointer
+		if vs.Meta&bitValuePointer > 0 {
+			vp.Decode(vs.Value)
+		}
+		if vs.Version == y.ParseTs(key) && !isDeletedOrExpired(vs.Meta, vs.ExpiresAt) &&
+			vp.Fid == fid && vp.Offset == e.offset {
+			ne := &Entry{
+				Key:       append(append([]byte{}, badgerMove...), key...),
+				Value:     append([]byte{}, e.Value...),
+				UserMeta:  vs.UserMeta,
+				ExpiresAt: vs.ExpiresAt,
+			}
+			entries = append(entries, ne)
+		}
+		vp = valuePointer{Fid: fid, Offset: e.offset}
+		ak := y.KeyWithTs(aliasKey(vp, y.ParseKey(key)), y.ParseTs(key))
+		entries = append(entries, &Entry{Key: ak, meta: bitDelete})
+	}
+	return entries, nil
+}
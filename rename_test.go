@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/trace"
+)
+
+func TestRename(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		small, big := []byte("small"), make([]byte, db.opt.ValueThreshold+100)
+		rand.Read(big)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.SetEntry(NewEntry([]byte("a"), small).WithMeta(1)); err != nil {
+				return err
+			}
+			return txn.SetEntry(NewEntry([]byte("b"), big).WithMeta(2))
+		}))
+		oldVs, err := db.get(y.KeyWithTs([]byte("b"), math.MaxUint64))
+		require.NoError(t, err)
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.Equal(t, ErrKeyNotFound, txn.Rename([]byte("missing"), []byte("x")))
+			require.NoError(t, txn.Rename([]byte("a"), []byte("c")))
+			require.NoError(t, txn.Rename([]byte("b"), []byte("d")))
+			// Renamed keys can be read and renamed again before being committed.
+			item, err := txn.Get([]byte("d"))
+			require.NoError(t, err)
+			require.Equal(t, big, getItemValue(t, item))
+			return txn.Rename([]byte("d"), []byte("e"))
+		}))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, key := range []string{"a", "b", "d"} {
+				_, err := txn.Get([]byte(key))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+			item, err := txn.Get([]byte("c"))
+			require.NoError(t, err)
+			require.Equal(t, small, getItemValue(t, item))
+			require.Equal(t, byte(1), item.UserMeta())
+			item, err = txn.Get([]byte("e"))
+			require.NoError(t, err)
+			require.Equal(t, big, getItemValue(t, item))
+			require.Equal(t, byte(2), item.UserMeta())
+			// The value wasn't copied.
+			require.Equal(t, oldVs.Value, item.vptr)
+			return nil
+		}))
+	})
+}
+
+func TestRenameValueGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	sz := 32 << 10
+	vals := make([][]byte, 100)
+	for i := range vals {
+		vals[i] = make([]byte, sz)
+		rand.Read(vals[i])
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(fmt.Sprintf("key%d", i)), vals[i])
+		}))
+	}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Rename([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("new%d", i)))
+		}))
+	}
+
+	db.vlog.filesLock.RLock()
+	lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+	db.vlog.filesLock.RUnlock()
+	tr := trace.New("Test", "Test")
+	defer tr.Finish()
+
+	// Pending renames keep the file from being rewritten.
+	txn := db.NewTransaction(true)
+	require.NoError(t, txn.Rename([]byte("key10"), []byte("new10")))
+	require.Equal(t, ErrNoRewrite, db.vlog.rewrite(lf, tr))
+	txn.Discard()
+
+	require.NoError(t, db.vlog.rewrite(lf, tr))
+	db.vlog.filesLock.RLock()
+	_, ok := db.vlog.filesMap[lf.fid]
+	db.vlog.filesLock.RUnlock()
+	require.False(t, ok)
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 10; i++ {
+			item, err := txn.Get([]byte(fmt.Sprintf("new%d", i)))
+			require.NoError(t, err)
+			require.Equal(t, vals[i], getItemValue(t, item))
+		}
+		item, err := txn.Get([]byte("key10"))
+		require.NoError(t, err)
+		require.Equal(t, vals[10], getItemValue(t, item))
+		return nil
+	}))
+}
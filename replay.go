@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// ReplayedEntry is an entry of the value log that Open replays into the memtable, because it
+// wasn't flushed to the LSM tree before the DB was closed.
+type ReplayedEntry struct {
+	Key       []byte // Without timestamp.
+	Version   uint64
+	Value     []byte
+	UserMeta  byte
+	ExpiresAt uint64
+	Deleted   bool
+	// Fid and Offset locate the entry in the value log.
+	Fid    uint32
+	Offset uint32
+}
+
+// ReplayFilter is called by Open with every committed entry it replays from the value log, in
+// order. The entry is skipped if it returns false. Entries of transactions which didn't commit
+// before a crash are discarded without being passed to it.
+type ReplayFilter func(e ReplayedEntry) bool
+
+// ReplayState locates the positions of the value log which matter to the systems mirroring it,
+// like a custom replication. The positions are ordered: Head <= Applied <= End.
+type ReplayState struct {
+	// HeadFid and HeadOffset locate the durable head. The entries before it are in the tables of
+	// the LSM tree on disk and never get replayed again, so the mirror of the value log can be
+	// truncated up to it. Note that value log GC still reads the values stored before it.
+	HeadFid    uint32
+	HeadOffset uint32
+	// AppliedFid and AppliedOffset locate the end of the entries applied to the memtables. The
+	// entries from Head up to it get replayed by Open after a crash.
+	AppliedFid    uint32
+	AppliedOffset uint32
+	// EndFid and EndOffset locate the end of the entries written to the value log. They can be
+	// replayed once they're synced to disk, see Options.SyncWrites and DB.Sync.
+	EndFid    uint32
+	EndOffset uint32
+	// CommitTs is the commit watermark, every transaction committed at or before it is applied.
+	// It's 0 in the managed mode, where the commit timestamps are set by the user.
+	CommitTs uint64
+}
+
+// ReplayState returns the replay state of the value log. It returns ErrNoValueLog when the DB is
+// opened in InMemory mode.
+func (db *DB) ReplayState() (ReplayState, error) {
+	if db.opt.InMemory {
+		return ReplayState{}, ErrNoValueLog
+	}
+	// Read the positions from the oldest to the newest, so that they're ordered.
+	head, err := db.durableHead()
+	if err != nil {
+		return ReplayState{}, err
+	}
+	db.RLock()
+	applied := db.vhead
+	db.RUnlock()
+	end := db.vlog.end()
+
+	st := ReplayState{
+		HeadFid:       head.Fid,
+		HeadOffset:    head.Offset,
+		AppliedFid:    applied.Fid,
+		AppliedOffset: applied.Offset,
+		EndFid:        end.Fid,
+		EndOffset:     end.Offset,
+	}
+	if !db.orc.isManaged {
+		st.CommitTs = db.orc.txnMark.DoneUntil()
+	}
+	return st, nil
+}
+
+// newReplayedEntry returns nil for the internal keys of Badger, which are always replayed. The
+// entry holds copies of the key and value of e.
+func newReplayedEntry(e Entry, vp valuePointer) *ReplayedEntry {
+	if bytes.HasPrefix(e.Key, badgerPrefix) {
+		return nil
+	}
+	return &ReplayedEntry{
+		Key:       y.SafeCopy(nil, y.ParseKey(e.Key)),
+		Version:   y.ParseTs(e.Key),
+		Value:     y.SafeCopy(nil, e.Value),
+		UserMeta:  e.UserMeta,
+		ExpiresAt: e.ExpiresAt,
+		Deleted:   e.meta&bitDelete > 0,
+		Fid:       vp.Fid,
+		Offset:    vp.Offset,
+	}
+}
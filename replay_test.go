@@ -0,0 +1,128 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db0, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db0.Update(func(txn *Txn) error {
+			return txn.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)))
+		}))
+	}
+	require.NoError(t, db0.Update(func(txn *Txn) error {
+		return txn.Delete([]byte("key0"))
+	}))
+	// Simulate a crash by not closing db0, but releasing the locks.
+	if db0.dirLockGuard != nil {
+		require.NoError(t, db0.dirLockGuard.release())
+	}
+	if db0.valueDirGuard != nil {
+		require.NoError(t, db0.valueDirGuard.release())
+	}
+	require.NoError(t, db0.vlog.Close())
+
+	var replayed []ReplayedEntry
+	filter := func(e ReplayedEntry) bool {
+		replayed = append(replayed, e)
+		// Skip the odd keys.
+		return e.Key[len(e.Key)-1]%2 == 0
+	}
+	db1, err := Open(getTestOptions(dir).WithReplayFilter(filter))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db1.Close()) }()
+
+	require.Len(t, replayed, 11)
+	for i, e := range replayed[:10] {
+		require.Equal(t, fmt.Sprintf("key%d", i), string(e.Key))
+		require.Equal(t, fmt.Sprintf("val%d", i), string(e.Value))
+		require.False(t, e.Deleted)
+		require.Equal(t, uint64(i+1), e.Version)
+	}
+	require.Equal(t, "key0", string(replayed[10].Key))
+	require.True(t, replayed[10].Deleted)
+
+	require.NoError(t, db1.View(func(txn *Txn) error {
+		for i := 0; i < 10; i++ {
+			_, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+			// key0 got deleted, and the deletion got replayed.
+			if i%2 == 0 && i > 0 {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+		return nil
+	}))
+}
+
+func TestReplayState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir).WithKeepL0InMemory(false))
+	require.NoError(t, err)
+	defer db.Close()
+
+	less := func(fid, offset, fid2, offset2 uint32) bool {
+		return valuePointer{Fid: fid, Offset: offset}.Less(valuePointer{Fid: fid2, Offset: offset2})
+	}
+	check := func() ReplayState {
+		st, err := db.ReplayState()
+		require.NoError(t, err)
+		require.False(t, less(st.AppliedFid, st.AppliedOffset, st.HeadFid, st.HeadOffset))
+		require.False(t, less(st.EndFid, st.EndOffset, st.AppliedFid, st.AppliedOffset))
+		return st
+	}
+
+	st0 := check()
+	for i := 0; i < 10; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	st1 := check()
+	require.True(t, st1.CommitTs > st0.CommitTs)
+	require.True(t, less(st0.EndFid, st0.EndOffset, st1.EndFid, st1.EndOffset))
+	// Nothing is flushed yet, the writes would be replayed.
+	require.Equal(t, st0.HeadOffset, st1.HeadOffset)
+	require.True(t, less(st1.HeadFid, st1.HeadOffset, st1.AppliedFid, st1.AppliedOffset))
+
+	// Once flushed, the head moves up to the applied writes.
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	st2 := check()
+	require.Equal(t, st1.AppliedFid, st2.HeadFid)
+	require.Equal(t, st1.AppliedOffset, st2.HeadOffset)
+
+	mdb, err := Open(getTestOptions("").WithInMemory(true))
+	require.NoError(t, err)
+	defer mdb.Close()
+	_, err = mdb.ReplayState()
+	require.Equal(t, ErrNoValueLog, err)
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2/table"
+)
+
+// StorageReport describes how efficiently a DB uses its storage, as returned by
+// DB.StorageReport.
+type StorageReport struct {
+	Prefixes []PrefixUsage `json:"prefixes"`
+	Tables   TableUsage    `json:"tables"`
+	ValueLog ValueLogUsage `json:"value_log"`
+}
+
+// PrefixUsage describes the space used by the keys under a prefix. Sizes include the keys, and the
+// headers of the entries stored in the value log.
+type PrefixUsage struct {
+	Prefix string `json:"prefix"`
+	// Keys is the number of keys whose latest version is neither deleted nor expired, and
+	// LiveBytes the size of these versions.
+	Keys      int64 `json:"keys"`
+	LiveBytes int64 `json:"live_bytes"`
+	// DeadVersions counts the older, deleted and expired versions not yet removed by compactions,
+	// and DeadBytes their size.
+	DeadVersions int64 `json:"dead_versions"`
+	DeadBytes    int64 `json:"dead_bytes"`
+	// ValueLogBytes is the part of LiveBytes stored in the value log.
+	ValueLogBytes int64 `json:"value_log_bytes"`
+}
+
+// TableUsage describes the space used by the SST files.
+type TableUsage struct {
+	Tables int `json:"tables"`
+	Blocks int `json:"blocks"`
+	// FileBytes is the size of the blocks in the files, and RawBytes their size once decrypted and
+	// decompressed. CompressionRatio is RawBytes divided by FileBytes.
+	FileBytes        int64   `json:"file_bytes"`
+	RawBytes         int64   `json:"raw_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	// EncryptedTables is the number of encrypted tables, and EncryptionOverheadBytes the space
+	// taken by the IVs, or nonces and tags, stored along with their blocks.
+	EncryptedTables         int   `json:"encrypted_tables"`
+	EncryptionOverheadBytes int64 `json:"encryption_overhead_bytes"`
+}
+
+// ValueLogUsage describes the space used by the value log files.
+type ValueLogUsage struct {
+	FileBytes int64 `json:"file_bytes"`
+	// LiveBytes is the size of the entries of the live versions, GarbageBytes the rest. Value log
+	// GC can reclaim the garbage of the files holding a large part of it.
+	LiveBytes    int64   `json:"live_bytes"`
+	GarbageBytes int64   `json:"garbage_bytes"`
+	GarbageRatio float64 `json:"garbage_ratio"`
+}
+
+// StorageReport reads every version of every key, and every block of every table, to report the
+// space used by the keys under each prefix, the compression ratio of the tables, the overhead of
+// encryption and the garbage in the value log. prefixOf returns the prefix keys get grouped by,
+// and must return a prefix of the key it's given, e.g. its first bytes. This can take a while on
+// large databases.
+func (db *DB) StorageReport(prefixOf func(key []byte) []byte) (*StorageReport, error) {
+	report := &StorageReport{}
+	usages := make(map[string]*PrefixUsage)
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.AllVersions = true
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		var lastKey []byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			prefix := string(prefixOf(item.Key()))
+			u, ok := usages[prefix]
+			if !ok {
+				u = &PrefixUsage{Prefix: prefix}
+				usages[prefix] = u
+			}
+			size := item.EstimatedSize()
+			if !item.hasValue() {
+				size = item.KeySize()
+			}
+			// The latest version of a key comes first.
+			latest := !bytes.Equal(item.Key(), lastKey)
+			lastKey = append(lastKey[:0], item.Key()...)
+			if !latest || item.IsDeletedOrExpired() {
+				u.DeadVersions++
+				u.DeadBytes += size
+				continue
+			}
+			u.Keys++
+			u.LiveBytes += size
+			if item.meta&bitValuePointer > 0 {
+				u.ValueLogBytes += size
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range usages {
+		report.Prefixes = append(report.Prefixes, *u)
+		report.ValueLog.LiveBytes += u.ValueLogBytes
+	}
+	sort.Slice(report.Prefixes, func(i, j int) bool {
+		return report.Prefixes[i].Prefix < report.Prefixes[j].Prefix
+	})
+
+	var tables []*table.Table
+	for _, l := range db.lc.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			t.IncrRef()
+			tables = append(tables, t)
+		}
+		l.RUnlock()
+	}
+	defer func() { _ = decrRefs(tables) }()
+	tu := &report.Tables
+	for _, t := range tables {
+		blocks, fileSize, rawSize, err := t.BlockSizes()
+		if err != nil {
+			return nil, err
+		}
+		tu.Tables++
+		tu.Blocks += blocks
+		tu.FileBytes += fileSize
+		tu.RawBytes += rawSize
+		if t.KeyID() != 0 {
+			tu.EncryptedTables++
+			tu.EncryptionOverheadBytes += int64(blocks * t.EncryptionOverhead())
+		}
+	}
+	if tu.FileBytes > 0 {
+		tu.CompressionRatio = float64(tu.RawBytes) / float64(tu.FileBytes)
+	}
+
+	vu := &report.ValueLog
+	_, vu.FileBytes = db.Size()
+	if vu.FileBytes > vu.LiveBytes {
+		vu.GarbageBytes = vu.FileBytes - vu.LiveBytes
+	}
+	if vu.FileBytes > 0 {
+		vu.GarbageRatio = float64(vu.GarbageBytes) / float64(vu.FileBytes)
+	}
+	return report, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// Compactions would drop the deleted keys.
+	opt := getTestOptions(dir).WithNumVersionsToKeep(10).
+		WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("a/%03d", i)), make([]byte, 10), 0)
+		txnSet(t, db, []byte(fmt.Sprintf("b/%03d", i)), make([]byte, 100), 0)
+	}
+	// Overwrite and delete half of the keys of b/.
+	for i := 0; i < 50; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("b/%03d", i)), make([]byte, 100), 0)
+		txnDelete(t, db, []byte(fmt.Sprintf("b/%03d", i)))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	report, err := db.StorageReport(func(key []byte) []byte { return key[:2] })
+	require.NoError(t, err)
+
+	require.Len(t, report.Prefixes, 2)
+	a, b := report.Prefixes[0], report.Prefixes[1]
+	require.Equal(t, "a/", a.Prefix)
+	require.Equal(t, int64(100), a.Keys)
+	require.Zero(t, a.DeadVersions)
+	require.Zero(t, a.ValueLogBytes)
+	require.Equal(t, "b/", b.Prefix)
+	require.Equal(t, int64(50), b.Keys)
+	require.Equal(t, int64(150), b.DeadVersions)
+	require.Equal(t, b.LiveBytes, b.ValueLogBytes)
+	require.True(t, b.DeadBytes > b.LiveBytes)
+
+	require.NotZero(t, report.Tables.Tables)
+	require.True(t, report.Tables.RawBytes > 0)
+	require.True(t, report.Tables.FileBytes > 0)
+	require.Zero(t, report.Tables.EncryptedTables)
+	require.Equal(t, b.ValueLogBytes, report.ValueLog.LiveBytes)
+	require.True(t, report.ValueLog.GarbageBytes > 0)
+}
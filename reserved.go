@@ -0,0 +1,188 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// InternalKeyPrefix is the prefix of the keys Badger stores along with the keys of the DB, such as
+// the discard stats of the value log, or the keys moved by value log GC. It's reserved: writing a
+// key starting with it gives a *ReservedKeyError, and iterators skip such keys.
+//
+// Versions of Badger older than the reservation, StreamWriter and Load let such keys in all the
+// same. They can be listed with DB.ReservedKeyCollisions, and moved out of the prefix with
+// DB.MigrateReservedKeys or Options.WithReservedKeyMigrationPrefix.
+const InternalKeyPrefix = "!badger!"
+
+// ReservedKeyError is the error of writing Key, which starts with InternalKeyPrefix. Its cause, as
+// given by errors.Cause, is ErrInvalidKey.
+type ReservedKeyError struct {
+	Key []byte
+}
+
+func (e *ReservedKeyError) Error() string {
+	return fmt.Sprintf("Key %q is using the reserved %s prefix", e.Key, InternalKeyPrefix)
+}
+
+// Cause returns ErrInvalidKey.
+func (e *ReservedKeyError) Cause() error {
+	return ErrInvalidKey
+}
+
+// Unwrap returns ErrInvalidKey.
+func (e *ReservedKeyError) Unwrap() error {
+	return ErrInvalidKey
+}
+
+// checkReservedKey returns a *ReservedKeyError if key starts with InternalKeyPrefix.
+func checkReservedKey(key []byte) error {
+	if bytes.HasPrefix(key, badgerPrefix) {
+		return &ReservedKeyError{Key: append([]byte{}, key...)}
+	}
+	return nil
+}
+
+// internalKeyRanges are the prefixes of the keys written by Badger itself, for many keys.
+var internalKeyRanges = [][]byte{badgerMove, badgerAlias}
+
+// isInternalKey tells whether key, within InternalKeyPrefix, is one of the keys written by Badger
+// itself. The keys of users starting with the prefix of such a range can't be told apart.
+func isInternalKey(key []byte) bool {
+	if bytes.Equal(key, head) || bytes.Equal(key, txnKey) || bytes.Equal(key, lfDiscardStatsKey) {
+		return true
+	}
+	for _, prefix := range internalKeyRanges {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservedKeyCollisions returns the keys starting with InternalKeyPrefix which weren't written by
+// Badger itself. Such keys can still be read with Txn.Get, but iterators skip them, and they
+// could clash with the internal keys of future versions.
+func (db *DB) ReservedKeyCollisions() ([][]byte, error) {
+	var keys [][]byte
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.InternalAccess = true
+		opt.PrefetchValues = false
+		opt.Prefix = badgerPrefix
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		itr.Rewind()
+	outer:
+		for itr.Valid() {
+			key := itr.Item().Key()
+			// Skip over the ranges of internal keys rather than going through them.
+			for _, prefix := range internalKeyRanges {
+				if bytes.HasPrefix(key, prefix) {
+					itr.Seek(prefixEnd(prefix))
+					continue outer
+				}
+			}
+			if !isInternalKey(key) {
+				keys = append(keys, itr.Item().KeyCopy(nil))
+			}
+			itr.Next()
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// prefixEnd returns the smallest key greater than all the keys starting with prefix, which mustn't
+// be all 0xff.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	panic("prefixEnd of a prefix made of 0xff")
+}
+
+// MigrateReservedKeys renames every key returned by ReservedKeyCollisions to prefix followed by
+// the key, see Txn.Rename, and returns how many got renamed. It fails if one of the new keys
+// exists already, leaving the remaining keys as they are. MigrateReservedKeys can't be used in
+// managed mode or read-only mode.
+func (db *DB) MigrateReservedKeys(prefix []byte) (int, error) {
+	switch {
+	case db.opt.managedTxns:
+		return 0, errors.New("MigrateReservedKeys cannot be used with managedDB=true")
+	case db.opt.ReadOnly:
+		return 0, errors.New("MigrateReservedKeys cannot be called in read-only mode")
+	case len(prefix) == 0:
+		return 0, errors.New("MigrateReservedKeys requires a prefix")
+	}
+	if err := checkReservedKey(prefix); err != nil {
+		return 0, err
+	}
+	keys, err := db.ReservedKeyCollisions()
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		newKey := append(append([]byte{}, prefix...), key...)
+		txn := db.NewTransaction(true)
+		// The txn deletes key, within InternalKeyPrefix.
+		txn.internal = true
+		_, err := txn.Get(newKey)
+		switch {
+		case err == nil:
+			err = errors.Errorf("Cannot migrate key %q, %q exists already", key, newKey)
+		case err == ErrKeyNotFound:
+			if err = txn.Rename(key, newKey); err == nil {
+				err = txn.Commit()
+			}
+		}
+		txn.Discard()
+		if err != nil {
+			return i, errors.Wrapf(err, "While migrating key %q", key)
+		}
+	}
+	return len(keys), nil
+}
+
+// checkReservedKeys migrates the keys colliding with InternalKeyPrefix when opening the DB, if
+// Options.ReservedKeyMigrationPrefix is set, or warns about them.
+func (db *DB) checkReservedKeys() error {
+	keys, err := db.ReservedKeyCollisions()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	prefix := db.opt.ReservedKeyMigrationPrefix
+	if len(prefix) == 0 || db.opt.ReadOnly || db.opt.managedTxns {
+		db.opt.Warningf("Found %d keys using the reserved %s prefix, e.g. %q. See "+
+			"DB.MigrateReservedKeys.", len(keys), InternalKeyPrefix, keys[0])
+		return nil
+	}
+	n, err := db.MigrateReservedKeys(prefix)
+	if err != nil {
+		return errors.Wrap(err, "While migrating reserved keys")
+	}
+	db.opt.Infof("Migrated %d keys using the reserved %s prefix to the %q prefix", n,
+		InternalKeyPrefix, prefix)
+	return nil
+}
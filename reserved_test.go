@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error { return txn.Set([]byte("!badger!foo"), nil) })
+	require.Equal(t, &ReservedKeyError{Key: []byte("!badger!foo")}, err)
+	require.Equal(t, ErrInvalidKey, errors.Cause(err))
+	sw := db.NewStreamWriter()
+	require.NoError(t, sw.Prepare())
+	err = sw.Write(&pb.KVList{Kv: []*pb.KV{{Key: []byte("!badger!foo"), Version: 1}}})
+	require.Equal(t, ErrInvalidKey, errors.Cause(err))
+	require.NoError(t, sw.Flush())
+
+	// Write keys colliding with the reserved prefix, as older versions allowed to.
+	txn := db.NewTransaction(true)
+	txn.internal = true
+	require.NoError(t, txn.Set([]byte("!badger!foo"), []byte("foo")))
+	require.NoError(t, txn.Set([]byte("!badger!zzz"), []byte("zzz")))
+	// Badger's own keys aren't collisions.
+	require.NoError(t, txn.Set(append(append([]byte{}, badgerMove...), "foo"...), nil))
+	require.NoError(t, txn.Commit())
+	keys, err := db.ReservedKeyCollisions()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("!badger!foo"), []byte("!badger!zzz")}, keys)
+
+	// The migration fails if a key exists under the new prefix already.
+	txnSet(t, db, []byte("old!badger!foo"), nil, 0)
+	n, err := db.MigrateReservedKeys([]byte("old"))
+	require.Error(t, err)
+	require.Equal(t, 0, n)
+	_, err = db.MigrateReservedKeys([]byte("!badger!old"))
+	require.Equal(t, ErrInvalidKey, errors.Cause(err))
+
+	n, err = db.MigrateReservedKeys([]byte("legacy"))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("legacy!badger!foo"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("foo"), getItemValue(t, item))
+		_, err = txn.Get([]byte("!badger!foo"))
+		require.Equal(t, ErrKeyNotFound, err)
+		return nil
+	}))
+	keys, err = db.ReservedKeyCollisions()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// Open migrates the keys, given a prefix.
+	txn = db.NewTransaction(true)
+	txn.internal = true
+	require.NoError(t, txn.Set([]byte("!badger!bar"), []byte("bar")))
+	require.NoError(t, txn.Commit())
+	require.NoError(t, db.Close())
+	db, err = Open(opt.WithReservedKeyMigrationPrefix([]byte("legacy")))
+	require.NoError(t, err)
+	defer db.Close()
+	keys, err = db.ReservedKeyCollisions()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("legacy!badger!bar"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), getItemValue(t, item))
+		return nil
+	}))
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+// Resources describes the resources held by a DB, returned by DB.Resources.
+type Resources struct {
+	// Instance is the InstanceLabel of the DB.
+	Instance string
+	// Goroutines is the number of long-running goroutines of the DB. GoroutinesByRole breaks it
+	// down into "compactor", "flusher", "writer", "vlog-gc", "publisher", "size-updater" and
+	// "plaintext-encryption". Goroutines started for the time of a call aren't counted.
+	Goroutines       int
+	GoroutinesByRole map[string]int
+	// Subscribers is the number of active DB.Subscribe calls.
+	Subscribers int
+	// OpenFiles is the number of files the DB keeps open: tables, value log files, MANIFEST,
+	// KEYREGISTRY, TSCLOCK, the access trace and the directory locks.
+	OpenFiles int
+	// MmapBytes is the number of bytes of the tables and value log files memory mapped.
+	MmapBytes int64
+	// TableBytesInRAM is the number of bytes of the tables loaded in RAM, with options.LoadToRAM
+	// or by KeepL0InMemory.
+	TableBytesInRAM int64
+	// MemtableBytes is the size of the arenas of the memtables, the active one included.
+	MemtableBytes int64
+	// BlockCacheBytes is the cost of the blocks held by the block cache. The cache of a Runtime is
+	// shared by its DBs, and counted in full by each of them.
+	BlockCacheBytes int64
+}
+
+// Resources returns the resources the DB currently holds, so that processes running many DBs can
+// account for them per DB, and tell whether a DB leaks goroutines or files. The goroutines and
+// files all get released by DB.Close. Resources must not be called after DB.Close.
+func (db *DB) Resources() Resources {
+	r := Resources{
+		Instance:         db.opt.InstanceLabel,
+		GoroutinesByRole: db.goroutines(),
+		Subscribers:      db.pub.noOfSubscribers(),
+	}
+	for _, n := range r.GoroutinesByRole {
+		r.Goroutines += n
+	}
+
+	if db.lc != nil {
+		for _, l := range db.lc.levels {
+			l.RLock()
+			for _, t := range l.tables {
+				if !t.IsInmemory {
+					r.OpenFiles++
+				}
+				r.MmapBytes += t.MappedSize()
+				r.TableBytesInRAM += t.LoadedSize()
+			}
+			l.RUnlock()
+		}
+	}
+	db.vlog.filesLock.RLock()
+	for _, lf := range db.vlog.filesMap {
+		lf.lock.RLock()
+		if lf.fd != nil {
+			r.OpenFiles++
+		}
+		r.MmapBytes += int64(len(lf.fmap))
+		lf.lock.RUnlock()
+	}
+	db.vlog.filesLock.RUnlock()
+
+	if mf := db.manifest; mf != nil {
+		mf.appendLock.Lock()
+		if mf.fp != nil {
+			r.OpenFiles++
+		}
+		mf.appendLock.Unlock()
+	}
+	if kr := db.registry; kr != nil {
+		kr.RLock()
+		if kr.fp != nil {
+			r.OpenFiles++
+		}
+		kr.RUnlock()
+	}
+	if vr := db.retention; vr != nil {
+		vr.Lock()
+		if vr.fp != nil {
+			r.OpenFiles++
+		}
+		vr.Unlock()
+	}
+	if db.recorder != nil {
+		r.OpenFiles++
+	}
+	if db.dirLockGuard != nil {
+		r.OpenFiles++
+	}
+	if db.valueDirGuard != nil {
+		r.OpenFiles++
+	}
+
+	db.RLock()
+	if db.mt != nil {
+		r.MemtableBytes += db.mt.MemSize()
+	}
+	for _, mt := range db.imm {
+		r.MemtableBytes += mt.MemSize()
+	}
+	db.RUnlock()
+
+	if db.blockCache != nil && db.blockCache.Metrics != nil {
+		m := db.blockCache.Metrics
+		r.BlockCacheBytes = int64(m.CostAdded() - m.CostEvicted())
+	}
+	return r
+}
+
+// goroutines returns the number of long-running goroutines of the DB by role.
+func (db *DB) goroutines() map[string]int {
+	return map[string]int{
+		"compactor":            db.closers.compactors.Running(),
+		"flusher":              db.closers.memtable.Running(),
+		"writer":               db.closers.writes.Running(),
+		"vlog-gc":              db.closers.valueGC.Running(),
+		"publisher":            db.closers.pub.Running(),
+		"size-updater":         db.closers.updateSize.Running(),
+		"plaintext-encryption": db.closers.plaintext.Running(),
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithKeepL0InMemory(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	r := db.Resources()
+	require.Equal(t, opt.NumCompactors, r.GoroutinesByRole["compactor"])
+	require.Equal(t, 1, r.GoroutinesByRole["flusher"])
+	require.Equal(t, 1, r.GoroutinesByRole["writer"])
+	require.Equal(t, 0, r.GoroutinesByRole["plaintext-encryption"])
+	require.Equal(t, opt.NumCompactors+5, r.Goroutines)
+	require.Zero(t, r.Subscribers)
+	// MANIFEST, KEYREGISTRY, the value log file and the directory lock.
+	require.Equal(t, 4, r.OpenFiles)
+
+	for i := 0; i < 10; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	require.NotZero(t, db.Resources().MemtableBytes)
+	require.NoError(t, db.FlushMemtable(context.Background()))
+	r = db.Resources()
+	require.Equal(t, 5, r.OpenFiles)
+	if *mmap {
+		require.NotZero(t, r.MmapBytes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = db.Subscribe(ctx, func(kvs *KVList) error { return nil }, []byte("key"))
+	}()
+	waitFor(t, func() bool { return db.Resources().Subscribers == 1 })
+	cancel()
+	<-done
+	require.Zero(t, db.Resources().Subscribers)
+
+	require.NoError(t, db.Close())
+	for role, n := range db.goroutines() {
+		require.Zero(t, n, role)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy decides how many versions of the keys under a prefix are kept by compactions,
+// instead of Options.NumVersionsToKeep. Like NumVersionsToKeep, it only affects the versions
+// which can't be read by any running transaction or tagged snapshot anymore.
+type RetentionPolicy struct {
+	Prefix []byte
+	// NumVersionsToKeep is the number of versions kept per key. Zero means
+	// Options.NumVersionsToKeep.
+	NumVersionsToKeep int
+	// KeepFor keeps all the versions which got superseded by a newer version, or deleted, less
+	// than this long ago, so that the keys can be read as they were at any point in this window.
+	// KeepFor can't be used in managed mode, where timestamps aren't related to time.
+	KeepFor time.Duration
+	// KeepAll keeps all the versions, deleted and expired ones included.
+	KeepAll bool
+}
+
+// tsClockFilename is the file timestamp samples are persisted to, to relate timestamps to time.
+const tsClockFilename = "TSCLOCK"
+
+// tsClockInterval is the minimum time between two timestamp samples.
+const tsClockInterval = time.Minute
+
+// tsSample records that all the timestamps up to ts were assigned at or before time at.
+type tsSample struct {
+	ts uint64
+	at int64 // Unix nanoseconds.
+}
+
+// versionRetention holds the retention policies of a DB, and the samples needed to enforce their
+// KeepFor durations.
+type versionRetention struct {
+	policies []RetentionPolicy // Sorted by decreasing prefix length.
+	maxKeep  time.Duration
+
+	sync.Mutex
+	samples []tsSample
+	fp      *os.File // Nil if samples aren't persisted.
+}
+
+func openVersionRetention(opt Options) (*versionRetention, error) {
+	vr := &versionRetention{policies: make([]RetentionPolicy, len(opt.RetentionPolicies))}
+	copy(vr.policies, opt.RetentionPolicies)
+	sort.SliceStable(vr.policies, func(i, j int) bool {
+		return len(vr.policies[i].Prefix) > len(vr.policies[j].Prefix)
+	})
+	for _, p := range vr.policies {
+		if p.KeepFor > vr.maxKeep {
+			vr.maxKeep = p.KeepFor
+		}
+	}
+	if vr.maxKeep == 0 {
+		return vr, nil
+	}
+	if opt.managedTxns {
+		return nil, errors.New("RetentionPolicy.KeepFor can't be used in managed mode")
+	}
+	if opt.InMemory || opt.ReadOnly {
+		return vr, nil
+	}
+
+	path := filepath.Join(opt.Dir, tsClockFilename)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, y.Wrapf(err, "Error while reading timestamp samples")
+	}
+	// A partially written sample at the end gets dropped.
+	for ; len(buf) >= 16; buf = buf[16:] {
+		vr.samples = append(vr.samples, tsSample{
+			ts: binary.BigEndian.Uint64(buf[0:8]),
+			at: int64(binary.BigEndian.Uint64(buf[8:16])),
+		})
+	}
+	// Samples older than the longest KeepFor are only needed as the latest one before it.
+	cutoff := time.Now().Add(-vr.maxKeep).UnixNano()
+	var first int
+	for first+1 < len(vr.samples) && vr.samples[first+1].at <= cutoff {
+		first++
+	}
+	vr.samples = vr.samples[first:]
+
+	// Rewrite the file with the samples left.
+	var out []byte
+	for _, s := range vr.samples {
+		out = appendTsSample(out, s)
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, out, 0666); err != nil {
+		return nil, y.Wrapf(err, "Error while rewriting timestamp samples")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, y.Wrapf(err, "Error while renaming timestamp samples file")
+	}
+	if vr.fp, err = y.OpenExistingFile(path, 0); err != nil {
+		return nil, y.Wrapf(err, "Error while opening timestamp samples file")
+	}
+	if _, err := vr.fp.Seek(0, os.SEEK_END); err != nil {
+		vr.fp.Close()
+		return nil, y.Wrapf(err, "Error while seeking timestamp samples file")
+	}
+	return vr, nil
+}
+
+func appendTsSample(buf []byte, s tsSample) []byte {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], s.ts)
+	binary.BigEndian.PutUint64(b[8:16], uint64(s.at))
+	return append(buf, b[:]...)
+}
+
+// policyFor returns the retention policy of key, nil if there's none. key must not have a
+// timestamp.
+func (vr *versionRetention) policyFor(key []byte) *RetentionPolicy {
+	for i := range vr.policies {
+		if bytes.HasPrefix(key, vr.policies[i].Prefix) {
+			return &vr.policies[i]
+		}
+	}
+	return nil
+}
+
+// recordTs records that all the timestamps up to ts have been assigned by now.
+func (vr *versionRetention) recordTs(ts uint64) {
+	if vr.maxKeep == 0 {
+		return
+	}
+	vr.Lock()
+	defer vr.Unlock()
+	now := time.Now().UnixNano()
+	if n := len(vr.samples); n > 0 && now-vr.samples[n-1].at < int64(tsClockInterval) {
+		return
+	}
+	s := tsSample{ts: ts, at: now}
+	vr.samples = append(vr.samples, s)
+	if vr.fp != nil {
+		// Losing samples only makes KeepFor keep versions longer, no need to sync.
+		if _, err := vr.fp.Write(appendTsSample(nil, s)); err != nil {
+			vr.fp.Close()
+			vr.fp = nil
+		}
+	}
+}
+
+// keptSince returns the timestamp after which versions are kept by a KeepFor of d: any version
+// superseded by a version above it might have been superseded less than d ago.
+func (vr *versionRetention) keptSince(d time.Duration) uint64 {
+	vr.Lock()
+	defer vr.Unlock()
+	cutoff := time.Now().Add(-d).UnixNano()
+	var ts uint64
+	for _, s := range vr.samples {
+		if s.at > cutoff {
+			break
+		}
+		ts = s.ts
+	}
+	return ts
+}
+
+func (vr *versionRetention) close() error {
+	vr.Lock()
+	defer vr.Unlock()
+	if vr.fp == nil {
+		return nil
+	}
+	err := vr.fp.Close()
+	vr.fp = nil
+	return err
+}
+
+// retentionCursor applies the retention policies to the versions of the keys iterated over by a
+// compaction, newest version first.
+type retentionCursor struct {
+	vr *versionRetention
+	// keptSince caches versionRetention.keptSince for the KeepFor durations of the compaction.
+	keptSince map[time.Duration]uint64
+
+	policy    *RetentionPolicy
+	newerTs   uint64 // Version of the previous version of the key, 0 if none.
+	keepAbove uint64 // Versions superseded by a version above this are kept.
+}
+
+// nextKey must be called when moving to a new key, which must not have a timestamp.
+func (rc *retentionCursor) nextKey(key []byte) {
+	rc.policy = rc.vr.policyFor(key)
+	rc.newerTs = 0
+	if rc.policy != nil && rc.policy.KeepFor > 0 {
+		ts, ok := rc.keptSince[rc.policy.KeepFor]
+		if !ok {
+			ts = rc.vr.keptSince(rc.policy.KeepFor)
+			if rc.keptSince == nil {
+				rc.keptSince = make(map[time.Duration]uint64)
+			}
+			rc.keptSince[rc.policy.KeepFor] = ts
+		}
+		rc.keepAbove = ts
+	}
+}
+
+// keep returns true if the version with the given timestamp must be kept regardless of the
+// number of versions kept and of deletions. It must be called for every version of the key.
+func (rc *retentionCursor) keep(version uint64) bool {
+	newer := rc.newerTs
+	rc.newerTs = version
+	switch {
+	case rc.policy == nil:
+		return false
+	case rc.policy.KeepAll:
+		return true
+	case rc.policy.KeepFor > 0:
+		// The latest version is treated as being superseded right now.
+		return newer == 0 && version > rc.keepAbove || newer > rc.keepAbove
+	}
+	return false
+}
+
+// numVersionsToKeep returns the number of versions to keep for the key.
+func (rc *retentionCursor) numVersionsToKeep(opt *Options) int {
+	if rc.policy != nil && rc.policy.NumVersionsToKeep > 0 {
+		return rc.policy.NumVersionsToKeep
+	}
+	return opt.NumVersionsToKeep
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithRetentionPolicies([]RetentionPolicy{
+		{Prefix: []byte("audit/"), NumVersionsToKeep: 3},
+		{Prefix: []byte("audit/all/"), KeepAll: true},
+		{Prefix: []byte("hist/"), KeepFor: time.Hour},
+	})
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	keys := []string{"cache/a", "audit/a", "audit/all/a", "hist/a", "hist/b"}
+	var lastTs uint64
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range keys {
+				if err := txn.Set([]byte(k), []byte(fmt.Sprintf("val%d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		if err := txn.Delete([]byte("audit/all/a")); err != nil {
+			return err
+		}
+		return txn.Delete([]byte("hist/b"))
+	}))
+
+	compact := func() {
+		txn := db.NewTransaction(false)
+		lastTs = txn.ReadTs()
+		txn.Discard()
+		waitFor(t, func() bool { return db.discardAtOrBelow() >= lastTs })
+		require.NoError(t, db.CompactRange(nil, nil))
+	}
+	countVersions := func(key string) int {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewKeyIterator([]byte(key), iopt)
+		defer it.Close()
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opt)
+		require.NoError(t, err)
+	}
+	reopen()
+	defer func() { require.NoError(t, db.Close()) }()
+	compact()
+	require.Equal(t, 1, countVersions("cache/a"))
+	require.Equal(t, 3, countVersions("audit/a"))
+	require.Equal(t, 6, countVersions("audit/all/a"))
+	// All the versions got superseded less than an hour ago.
+	require.Equal(t, 5, countVersions("hist/a"))
+	require.Equal(t, 6, countVersions("hist/b"))
+
+	// Pretend everything was written more than an hour ago. A new table has to go down the levels
+	// for the last one to get compacted again.
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("cache/b"), []byte("val"))
+	}))
+	reopen()
+	db.retention.Lock()
+	db.retention.samples = []tsSample{{ts: lastTs, at: time.Now().Add(-2 * time.Hour).UnixNano()}}
+	db.retention.Unlock()
+	compact()
+	// Compactions keep a version past the limit when lower levels might hold older versions.
+	require.True(t, countVersions("hist/a") <= 2)
+	require.True(t, countVersions("hist/b") <= 1)
+	require.Equal(t, 6, countVersions("audit/all/a"))
+}
+
+func TestRetentionPolicyKeepForManaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithRetentionPolicies([]RetentionPolicy{
+		{Prefix: []byte("hist/"), KeepFor: time.Hour},
+	})
+	_, err = OpenManaged(opt)
+	require.Error(t, err)
+}
+
+func TestTsClockPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithRetentionPolicies([]RetentionPolicy{
+		{Prefix: []byte("hist/"), KeepFor: time.Hour},
+	})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.Len(t, db.retention.samples, 1)
+	sample := db.retention.samples[0]
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	// The sample taken by the second open is too close to the first one to be recorded.
+	require.Equal(t, []tsSample{sample}, db.retention.samples)
+	require.Equal(t, uint64(0), db.retention.keptSince(time.Hour))
+	require.Equal(t, sample.ts, db.retention.keptSince(0))
+}
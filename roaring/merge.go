@@ -0,0 +1,187 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package roaring
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pkg/errors"
+)
+
+// Values written through a merge operator are changes to a bitmap: values to add and values to
+// remove, optionally after clearing the bitmap. Badger merges the changes of a key from the newest
+// to the oldest, so merging two changes gives a change again. Once merged with the oldest change
+// of a key, the values to add are the content of the bitmap.
+//
+// A change is encoded as a flag byte, the uvarint size of the serialized values to add, then the
+// serialized values to add and the serialized values to remove.
+
+const flagClear = 1 << 0
+
+type change struct {
+	clear       bool
+	add, remove *Bitmap
+}
+
+func (c *change) encode() []byte {
+	var buf [1 + binary.MaxVarintLen64]byte
+	if c.clear {
+		buf[0] = flagClear
+	}
+	add := c.add.AppendTo(nil)
+	n := 1 + binary.PutUvarint(buf[1:], uint64(len(add)))
+	res := make([]byte, 0, n+len(add))
+	res = append(append(res, buf[:n]...), add...)
+	return c.remove.AppendTo(res)
+}
+
+func decodeChange(data []byte) (*change, error) {
+	if len(data) < 2 {
+		return nil, errCorrupt
+	}
+	c := &change{clear: data[0]&flagClear != 0}
+	size, n := binary.Uvarint(data[1:])
+	if n <= 0 || uint64(len(data)-1-n) < size {
+		return nil, errCorrupt
+	}
+	data = data[1+n:]
+	var err error
+	if c.add, err = FromBytes(data[:size]); err != nil {
+		return nil, err
+	}
+	if c.remove, err = FromBytes(data[size:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// then returns the change made by c followed by next.
+func (c *change) then(next *change) *change {
+	if next.clear {
+		return next
+	}
+	add := c.add.Clone()
+	add.AndNot(next.remove)
+	add.Or(next.add)
+	remove := &Bitmap{}
+	if !c.clear {
+		// Removing values from a cleared bitmap is a no-op.
+		remove = c.remove.Clone()
+		remove.AndNot(next.add)
+		remove.Or(next.remove)
+	}
+	return &change{clear: c.clear, add: add, remove: remove}
+}
+
+// AddChange returns the value adding the given values to a bitmap written through a merge
+// operator using Merge.
+func AddChange(values ...uint32) []byte {
+	return (&change{add: New(values...), remove: &Bitmap{}}).encode()
+}
+
+// RemoveChange returns the value removing the given values from a bitmap written through a merge
+// operator using Merge.
+func RemoveChange(values ...uint32) []byte {
+	return (&change{add: &Bitmap{}, remove: New(values...)}).encode()
+}
+
+// UnionChange returns the value adding the values of b to a bitmap written through a merge
+// operator using Merge.
+func UnionChange(b *Bitmap) []byte {
+	return (&change{add: b, remove: &Bitmap{}}).encode()
+}
+
+// SetChange returns the value replacing the values of a bitmap written through a merge operator
+// using Merge with the values of b.
+func SetChange(b *Bitmap) []byte {
+	return (&change{clear: true, add: b, remove: &Bitmap{}}).encode()
+}
+
+// Merge is a badger.MergeFunc merging changes to a bitmap returned by AddChange, RemoveChange,
+// UnionChange and SetChange. Corrupt values are skipped.
+func Merge(existingVal, newVal []byte) []byte {
+	existing, err := decodeChange(existingVal)
+	if err != nil {
+		return newVal
+	}
+	next, err := decodeChange(newVal)
+	if err != nil {
+		return existingVal
+	}
+	return existing.then(next).encode()
+}
+
+// Decode returns the bitmap stored in val, the merged value of a key written through a merge
+// operator using Merge.
+func Decode(val []byte) (*Bitmap, error) {
+	c, err := decodeChange(val)
+	if err != nil {
+		return nil, errors.Wrap(err, "while decoding merged bitmap")
+	}
+	return c.add, nil
+}
+
+// MergeOperator is a bitmap stored under a key, which changes get written to without reading
+// the bitmap first, and are merged in the background.
+type MergeOperator struct {
+	op *badger.MergeOperator
+}
+
+// NewMergeOperator returns the merge operator for the bitmap stored in db under key. Changes get
+// merged every dur. Stop must be called once done with the operator.
+func NewMergeOperator(db *badger.DB, key []byte, dur time.Duration) *MergeOperator {
+	return &MergeOperator{op: db.GetMergeOperator(key, Merge, dur)}
+}
+
+// Add adds the given values to the bitmap.
+func (m *MergeOperator) Add(values ...uint32) error {
+	return m.op.Add(AddChange(values...))
+}
+
+// Remove removes the given values from the bitmap.
+func (m *MergeOperator) Remove(values ...uint32) error {
+	return m.op.Add(RemoveChange(values...))
+}
+
+// Union adds the values of b to the bitmap.
+func (m *MergeOperator) Union(b *Bitmap) error {
+	return m.op.Add(UnionChange(b))
+}
+
+// Set replaces the values of the bitmap with the values of b.
+func (m *MergeOperator) Set(b *Bitmap) error {
+	return m.op.Add(SetChange(b))
+}
+
+// Get returns the bitmap, with all the changes written so far. It's empty if nothing was written.
+func (m *MergeOperator) Get() (*Bitmap, error) {
+	val, err := m.op.Get()
+	if err == badger.ErrKeyNotFound {
+		return &Bitmap{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Decode(val)
+}
+
+// Stop waits for any pending merge to complete and then stops merging changes in the background.
+func (m *MergeOperator) Stop() {
+	m.op.Stop()
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package roaring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/kvtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeChanges(t *testing.T) {
+	changes := [][]byte{
+		AddChange(1, 2, 3),
+		RemoveChange(2, 7),
+		UnionChange(New(7, 8)),
+		RemoveChange(1),
+	}
+	// Changes are merged from the newest to the oldest.
+	val := changes[len(changes)-1]
+	for i := len(changes) - 2; i >= 0; i-- {
+		val = Merge(changes[i], val)
+	}
+	b, err := Decode(val)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{3, 7, 8}, b.ToArray())
+
+	// Removing values after clearing the bitmap doesn't remove them from older changes.
+	val = Merge(SetChange(New(5)), RemoveChange(3))
+	val = Merge(AddChange(3, 4), val)
+	b, err = Decode(val)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{5}, b.ToArray())
+
+	_, err = Decode([]byte{0})
+	require.Error(t, err)
+}
+
+func TestMergeOperator(t *testing.T) {
+	db := kvtest.OpenInMemory(t)
+	defer db.Close()
+
+	m := NewMergeOperator(db, []byte("bitmap"), 10*time.Millisecond)
+	b, err := m.Get()
+	require.NoError(t, err)
+	require.True(t, b.IsEmpty())
+
+	require.NoError(t, m.Add(1, 2, 1<<20))
+	require.NoError(t, m.Remove(2))
+	time.Sleep(30 * time.Millisecond) // Let some changes get merged in the background.
+	require.NoError(t, m.Union(New(3, 4)))
+	b, err = m.Get()
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 3, 4, 1 << 20}, b.ToArray())
+
+	require.NoError(t, m.Set(New(9)))
+	require.NoError(t, m.Add(10))
+	m.Stop()
+	b, err = m.Get()
+	require.NoError(t, err)
+	require.Equal(t, []uint32{9, 10}, b.ToArray())
+}
@@ -0,0 +1,437 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package roaring implements compressed bitmaps of uint32 values, also known as roaring bitmaps.
+//
+// Values are split in chunks of 65536 by their upper 16 bits. Each chunk is stored in a container,
+// either as a sorted array of the lower 16 bits of its values if it holds up to 4096 of them, or
+// as a bitmap otherwise. Bitmaps are serialized in the portable format shared by the other roaring
+// bitmap implementations, see https://github.com/RoaringBitmap/RoaringFormatSpec.
+//
+// Bitmaps stored in Badger can be changed without being read first through a MergeOperator,
+// which writes the changes and merges them in the background.
+package roaring
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// arrayMax is the maximum number of values in an array container.
+const arrayMax = 4096
+
+// bitmapWords is the number of words of a bitmap container.
+const bitmapWords = 1 << 16 / 64
+
+// container holds the values of a bitmap sharing their upper 16 bits.
+type container struct {
+	array []uint16 // Sorted values, if bits is nil.
+	bits  []uint64
+	n     int // Number of values.
+}
+
+func (c *container) contains(v uint16) bool {
+	if c.bits != nil {
+		return c.bits[v/64]&(1<<(v%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	return i < len(c.array) && c.array[i] == v
+}
+
+func (c *container) add(v uint16) bool {
+	if c.bits != nil {
+		w, mask := v/64, uint64(1)<<(v%64)
+		if c.bits[w]&mask != 0 {
+			return false
+		}
+		c.bits[w] |= mask
+		c.n++
+		return true
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	if i < len(c.array) && c.array[i] == v {
+		return false
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = v
+	c.n++
+	if c.n > arrayMax {
+		c.toBitmap()
+	}
+	return true
+}
+
+func (c *container) remove(v uint16) bool {
+	if c.bits != nil {
+		w, mask := v/64, uint64(1)<<(v%64)
+		if c.bits[w]&mask == 0 {
+			return false
+		}
+		c.bits[w] &^= mask
+		c.n--
+		if c.n <= arrayMax {
+			c.toArray()
+		}
+		return true
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	if i == len(c.array) || c.array[i] != v {
+		return false
+	}
+	c.array = append(c.array[:i], c.array[i+1:]...)
+	c.n--
+	return true
+}
+
+func (c *container) toBitmap() {
+	c.bits = make([]uint64, bitmapWords)
+	for _, v := range c.array {
+		c.bits[v/64] |= 1 << (v % 64)
+	}
+	c.array = nil
+}
+
+func (c *container) toArray() {
+	c.array = make([]uint16, 0, c.n)
+	c.forEach(func(v uint16) bool {
+		c.array = append(c.array, v)
+		return true
+	})
+	c.bits = nil
+}
+
+// fromBits returns the container holding the values set in words, which it takes ownership of.
+func fromBits(words []uint64) container {
+	c := container{bits: words}
+	for _, w := range words {
+		c.n += bits.OnesCount64(w)
+	}
+	if c.n <= arrayMax {
+		c.toArray()
+	}
+	return c
+}
+
+// bitmap returns the values of c as a bitmap, which may be shared with c.
+func (c *container) bitmap() []uint64 {
+	if c.bits != nil {
+		return c.bits
+	}
+	words := make([]uint64, bitmapWords)
+	for _, v := range c.array {
+		words[v/64] |= 1 << (v % 64)
+	}
+	return words
+}
+
+func (c *container) forEach(fn func(uint16) bool) bool {
+	if c.bits == nil {
+		for _, v := range c.array {
+			if !fn(v) {
+				return false
+			}
+		}
+		return true
+	}
+	for i, w := range c.bits {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+			if !fn(uint16(i*64 + t)) {
+				return false
+			}
+			w &= w - 1
+		}
+	}
+	return true
+}
+
+func (c *container) clone() container {
+	return container{
+		array: append([]uint16(nil), c.array...),
+		bits:  append([]uint64(nil), c.bits...),
+		n:     c.n,
+	}
+}
+
+func (c *container) or(o *container) container {
+	if c.bits == nil && o.bits == nil && c.n+o.n <= arrayMax {
+		res := make([]uint16, 0, c.n+o.n)
+		i, j := 0, 0
+		for i < len(c.array) && j < len(o.array) {
+			switch {
+			case c.array[i] < o.array[j]:
+				res = append(res, c.array[i])
+				i++
+			case c.array[i] > o.array[j]:
+				res = append(res, o.array[j])
+				j++
+			default:
+				res = append(res, c.array[i])
+				i++
+				j++
+			}
+		}
+		res = append(append(res, c.array[i:]...), o.array[j:]...)
+		return container{array: res, n: len(res)}
+	}
+	words := append([]uint64(nil), c.bitmap()...)
+	if o.bits == nil {
+		for _, v := range o.array {
+			words[v/64] |= 1 << (v % 64)
+		}
+	} else {
+		for i, w := range o.bits {
+			words[i] |= w
+		}
+	}
+	return fromBits(words)
+}
+
+func (c *container) and(o *container) container {
+	if c.bits != nil && o.bits != nil {
+		words := make([]uint64, bitmapWords)
+		for i := range words {
+			words[i] = c.bits[i] & o.bits[i]
+		}
+		return fromBits(words)
+	}
+	if c.bits != nil {
+		c, o = o, c
+	}
+	var res []uint16
+	for _, v := range c.array {
+		if o.contains(v) {
+			res = append(res, v)
+		}
+	}
+	return container{array: res, n: len(res)}
+}
+
+func (c *container) andNot(o *container) container {
+	if c.bits == nil {
+		var res []uint16
+		for _, v := range c.array {
+			if !o.contains(v) {
+				res = append(res, v)
+			}
+		}
+		return container{array: res, n: len(res)}
+	}
+	words := append([]uint64(nil), c.bits...)
+	if o.bits == nil {
+		for _, v := range o.array {
+			words[v/64] &^= 1 << (v % 64)
+		}
+	} else {
+		for i, w := range o.bits {
+			words[i] &^= w
+		}
+	}
+	return fromBits(words)
+}
+
+// Bitmap is a set of uint32 values. The zero value is an empty bitmap ready to use. Bitmaps
+// aren't safe for concurrent use.
+type Bitmap struct {
+	keys       []uint16 // Sorted upper 16 bits of the values of the containers.
+	containers []container
+}
+
+// New returns a bitmap holding the given values.
+func New(values ...uint32) *Bitmap {
+	b := &Bitmap{}
+	for _, v := range values {
+		b.Add(v)
+	}
+	return b
+}
+
+func (b *Bitmap) find(key uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= key })
+	return i, i < len(b.keys) && b.keys[i] == key
+}
+
+// Add adds v to b, and returns false if b held it already.
+func (b *Bitmap) Add(v uint32) bool {
+	i, ok := b.find(uint16(v >> 16))
+	if !ok {
+		b.keys = append(b.keys, 0)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = uint16(v >> 16)
+		b.containers = append(b.containers, container{})
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = container{}
+	}
+	return b.containers[i].add(uint16(v))
+}
+
+// Remove removes v from b, and returns false if b didn't hold it.
+func (b *Bitmap) Remove(v uint32) bool {
+	i, ok := b.find(uint16(v >> 16))
+	if !ok || !b.containers[i].remove(uint16(v)) {
+		return false
+	}
+	if b.containers[i].n == 0 {
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+		b.containers = append(b.containers[:i], b.containers[i+1:]...)
+	}
+	return true
+}
+
+// Contains returns whether b holds v.
+func (b *Bitmap) Contains(v uint32) bool {
+	i, ok := b.find(uint16(v >> 16))
+	return ok && b.containers[i].contains(uint16(v))
+}
+
+// Cardinality returns the number of values in b.
+func (b *Bitmap) Cardinality() int {
+	var n int
+	for i := range b.containers {
+		n += b.containers[i].n
+	}
+	return n
+}
+
+// IsEmpty returns whether b holds no value.
+func (b *Bitmap) IsEmpty() bool {
+	return len(b.keys) == 0
+}
+
+// ForEach calls fn with the values of b in increasing order, until fn returns false.
+func (b *Bitmap) ForEach(fn func(uint32) bool) {
+	for i := range b.containers {
+		high := uint32(b.keys[i]) << 16
+		if !b.containers[i].forEach(func(v uint16) bool { return fn(high | uint32(v)) }) {
+			return
+		}
+	}
+}
+
+// ToArray returns the values of b in increasing order.
+func (b *Bitmap) ToArray() []uint32 {
+	res := make([]uint32, 0, b.Cardinality())
+	b.ForEach(func(v uint32) bool {
+		res = append(res, v)
+		return true
+	})
+	return res
+}
+
+// Clone returns a copy of b.
+func (b *Bitmap) Clone() *Bitmap {
+	res := &Bitmap{
+		keys:       append([]uint16(nil), b.keys...),
+		containers: make([]container, len(b.containers)),
+	}
+	for i := range b.containers {
+		res.containers[i] = b.containers[i].clone()
+	}
+	return res
+}
+
+// Or adds the values of o to b.
+func (b *Bitmap) Or(o *Bitmap) {
+	var keys []uint16
+	var cs []container
+	i, j := 0, 0
+	for i < len(b.keys) || j < len(o.keys) {
+		switch {
+		case j == len(o.keys) || (i < len(b.keys) && b.keys[i] < o.keys[j]):
+			keys, cs = append(keys, b.keys[i]), append(cs, b.containers[i])
+			i++
+		case i == len(b.keys) || b.keys[i] > o.keys[j]:
+			keys, cs = append(keys, o.keys[j]), append(cs, o.containers[j].clone())
+			j++
+		default:
+			keys, cs = append(keys, b.keys[i]), append(cs, b.containers[i].or(&o.containers[j]))
+			i++
+			j++
+		}
+	}
+	b.keys, b.containers = keys, cs
+}
+
+// And removes the values of b which o doesn't hold.
+func (b *Bitmap) And(o *Bitmap) {
+	var keys []uint16
+	var cs []container
+	for i, key := range b.keys {
+		j, ok := o.find(key)
+		if !ok {
+			continue
+		}
+		if c := b.containers[i].and(&o.containers[j]); c.n > 0 {
+			keys, cs = append(keys, key), append(cs, c)
+		}
+	}
+	b.keys, b.containers = keys, cs
+}
+
+// AndNot removes the values of o from b.
+func (b *Bitmap) AndNot(o *Bitmap) {
+	var keys []uint16
+	var cs []container
+	for i, key := range b.keys {
+		c := b.containers[i]
+		if j, ok := o.find(key); ok {
+			c = c.andNot(&o.containers[j])
+		}
+		if c.n > 0 {
+			keys, cs = append(keys, key), append(cs, c)
+		}
+	}
+	b.keys, b.containers = keys, cs
+}
+
+// Equals returns whether b and o hold the same values.
+func (b *Bitmap) Equals(o *Bitmap) bool {
+	if len(b.keys) != len(o.keys) {
+		return false
+	}
+	for i, key := range b.keys {
+		if o.keys[i] != key || b.containers[i].n != o.containers[i].n {
+			return false
+		}
+		c := o.containers[i]
+		if !b.containers[i].forEach(c.contains) {
+			return false
+		}
+	}
+	return true
+}
+
+// Or returns the union of the given bitmaps.
+func Or(bms ...*Bitmap) *Bitmap {
+	res := &Bitmap{}
+	for _, bm := range bms {
+		res.Or(bm)
+	}
+	return res
+}
+
+// And returns the intersection of the given bitmaps, an empty bitmap if there's none.
+func And(bms ...*Bitmap) *Bitmap {
+	if len(bms) == 0 {
+		return &Bitmap{}
+	}
+	res := bms[0].Clone()
+	for _, bm := range bms[1:] {
+		res.And(bm)
+	}
+	return res
+}
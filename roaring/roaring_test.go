@@ -0,0 +1,146 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package roaring
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// randomSet returns n random values, dense in some containers and sparse in others.
+func randomSet(rng *rand.Rand, n int) map[uint32]bool {
+	set := make(map[uint32]bool)
+	for len(set) < n {
+		if rng.Intn(2) == 0 {
+			set[uint32(rng.Intn(3))<<16|uint32(rng.Intn(1<<16))] = true
+		} else {
+			set[rng.Uint32()] = true
+		}
+	}
+	return set
+}
+
+func sorted(set map[uint32]bool) []uint32 {
+	res := make([]uint32, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func appendUint16s(dst []byte, vs ...uint16) []byte {
+	var buf [2]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint16(buf[:], v)
+		dst = append(dst, buf[:]...)
+	}
+	return dst
+}
+
+func TestBitmapAddRemove(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := randomSet(rng, 20000)
+	b := &Bitmap{}
+	for v := range set {
+		require.True(t, b.Add(v))
+		require.False(t, b.Add(v))
+	}
+	require.Equal(t, len(set), b.Cardinality())
+	require.Equal(t, sorted(set), b.ToArray())
+
+	// Removing most values turns bitmap containers back into arrays.
+	for v := range set {
+		if rng.Intn(10) > 0 {
+			require.True(t, b.Remove(v))
+			require.False(t, b.Remove(v))
+			delete(set, v)
+		}
+	}
+	require.Equal(t, sorted(set), b.ToArray())
+	for v := range set {
+		require.True(t, b.Contains(v))
+	}
+	require.False(t, b.Contains(rng.Uint32()|1<<31))
+	for v := range set {
+		b.Remove(v)
+	}
+	require.True(t, b.IsEmpty())
+}
+
+func TestBitmapSetOperations(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, n := range []int{10, 5000, 30000} {
+		sa, sb := randomSet(rng, n), randomSet(rng, n)
+		a, b := New(sorted(sa)...), New(sorted(sb)...)
+		or, and, andNot := make(map[uint32]bool), make(map[uint32]bool), make(map[uint32]bool)
+		for v := range sa {
+			or[v] = true
+			if sb[v] {
+				and[v] = true
+			} else {
+				andNot[v] = true
+			}
+		}
+		for v := range sb {
+			or[v] = true
+		}
+		require.Equal(t, sorted(or), Or(a, b).ToArray())
+		require.Equal(t, sorted(and), And(a, b).ToArray())
+		c := a.Clone()
+		c.AndNot(b)
+		require.Equal(t, sorted(andNot), c.ToArray())
+		// The operands are left alone.
+		require.Equal(t, sorted(sa), a.ToArray())
+		require.Equal(t, sorted(sb), b.ToArray())
+		require.True(t, a.Equals(a.Clone()))
+		require.False(t, a.Equals(b))
+	}
+}
+
+func TestBitmapSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, n := range []int{0, 1, 5000, 30000} {
+		b := New(sorted(randomSet(rng, n))...)
+		data, err := b.MarshalBinary()
+		require.NoError(t, err)
+		got, err := FromBytes(data)
+		require.NoError(t, err)
+		require.True(t, b.Equals(got))
+
+		for i := 0; i < len(data); i += 1 + len(data)/50 {
+			_, err := FromBytes(data[:i])
+			require.Error(t, err)
+		}
+	}
+
+	// A bitmap with a run container holding 10 to 20, and an array container holding 1<<16.
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, cookieRuns|1<<16)
+	data = append(data, 1)                  // Only the first container is a run container.
+	data = appendUint16s(data, 0, 10, 1, 0) // Keys and cardinalities minus one.
+	data = appendUint16s(data, 1, 10, 10)   // One run, from 10 and of length 11.
+	data = appendUint16s(data, 0)           // Array container.
+	b, err := FromBytes(data)
+	require.NoError(t, err)
+	want := []uint32{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 1 << 16}
+	require.Equal(t, want, b.ToArray())
+}
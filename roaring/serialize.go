@@ -0,0 +1,195 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package roaring
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cookieNoRuns starts serialized bitmaps without run containers, followed by the number of
+	// containers.
+	cookieNoRuns = 12346
+	// cookieRuns starts serialized bitmaps with run containers, in its lower 16 bits. The upper 16
+	// bits hold the number of containers minus one.
+	cookieRuns = 12347
+	// noOffsetThreshold is the number of containers below which serialized bitmaps with run
+	// containers don't hold the offsets of the containers.
+	noOffsetThreshold = 4
+)
+
+var errCorrupt = errors.New("Corrupt roaring bitmap")
+
+// MarshalBinary returns b in the portable roaring bitmap format. Run containers aren't used.
+func (b *Bitmap) MarshalBinary() ([]byte, error) {
+	return b.AppendTo(nil), nil
+}
+
+// AppendTo appends b in the portable roaring bitmap format to dst and returns the result.
+func (b *Bitmap) AppendTo(dst []byte) []byte {
+	var buf [8]byte
+	le := binary.LittleEndian
+	le.PutUint32(buf[:], cookieNoRuns)
+	le.PutUint32(buf[4:], uint32(len(b.keys)))
+	dst = append(dst, buf[:8]...)
+	for i, key := range b.keys {
+		le.PutUint16(buf[:], key)
+		le.PutUint16(buf[2:], uint16(b.containers[i].n-1))
+		dst = append(dst, buf[:4]...)
+	}
+	// Offsets of the containers, from the start of the bitmap.
+	offset := 8 + 8*uint32(len(b.keys))
+	for i := range b.containers {
+		le.PutUint32(buf[:], offset)
+		dst = append(dst, buf[:4]...)
+		if c := &b.containers[i]; c.bits != nil {
+			offset += 8 * bitmapWords
+		} else {
+			offset += 2 * uint32(c.n)
+		}
+	}
+	for i := range b.containers {
+		c := &b.containers[i]
+		if c.bits != nil {
+			for _, w := range c.bits {
+				le.PutUint64(buf[:], w)
+				dst = append(dst, buf[:8]...)
+			}
+			continue
+		}
+		for _, v := range c.array {
+			le.PutUint16(buf[:], v)
+			dst = append(dst, buf[:2]...)
+		}
+	}
+	return dst
+}
+
+// UnmarshalBinary replaces the values of b with the ones of data, a bitmap in the portable roaring
+// bitmap format. Bitmaps with run containers are accepted.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	le := binary.LittleEndian
+	if len(data) < 4 {
+		return errCorrupt
+	}
+	var size int
+	var runs []byte
+	cookie := le.Uint32(data)
+	switch {
+	case cookie == cookieNoRuns:
+		if len(data) < 8 {
+			return errCorrupt
+		}
+		size = int(le.Uint32(data[4:]))
+		data = data[8:]
+	case cookie&0xFFFF == cookieRuns:
+		size = int(cookie>>16) + 1
+		data = data[4:]
+		if len(data) < (size+7)/8 {
+			return errCorrupt
+		}
+		runs, data = data[:(size+7)/8], data[(size+7)/8:]
+	default:
+		return errors.Errorf("Unknown roaring bitmap cookie %d", cookie)
+	}
+	if size > 1<<16 || len(data) < 4*size {
+		return errCorrupt
+	}
+	header := data[:4*size]
+	data = data[4*size:]
+	if runs == nil || size >= noOffsetThreshold {
+		// The offsets are redundant, containers are read in order.
+		if len(data) < 4*size {
+			return errCorrupt
+		}
+		data = data[4*size:]
+	}
+
+	keys := make([]uint16, size)
+	cs := make([]container, size)
+	for i := range cs {
+		keys[i] = le.Uint16(header[4*i:])
+		if i > 0 && keys[i] <= keys[i-1] {
+			return errCorrupt
+		}
+		n := int(le.Uint16(header[4*i+2:])) + 1
+		switch {
+		case runs != nil && runs[i/8]&(1<<uint(i%8)) != 0:
+			if len(data) < 2 {
+				return errCorrupt
+			}
+			nruns := int(le.Uint16(data))
+			data = data[2:]
+			if len(data) < 4*nruns {
+				return errCorrupt
+			}
+			words := make([]uint64, bitmapWords)
+			for r := 0; r < nruns; r++ {
+				start := int(le.Uint16(data[4*r:]))
+				end := start + int(le.Uint16(data[4*r+2:]))
+				if end >= 1<<16 {
+					return errCorrupt
+				}
+				for v := start; v <= end; v++ {
+					words[v/64] |= 1 << uint(v%64)
+				}
+			}
+			data = data[4*nruns:]
+			cs[i] = fromBits(words)
+		case n > arrayMax:
+			if len(data) < 8*bitmapWords {
+				return errCorrupt
+			}
+			words := make([]uint64, bitmapWords)
+			for j := range words {
+				words[j] = le.Uint64(data[8*j:])
+			}
+			data = data[8*bitmapWords:]
+			cs[i] = fromBits(words)
+		default:
+			if len(data) < 2*n {
+				return errCorrupt
+			}
+			array := make([]uint16, n)
+			for j := range array {
+				array[j] = le.Uint16(data[2*j:])
+				if j > 0 && array[j] <= array[j-1] {
+					return errCorrupt
+				}
+			}
+			data = data[2*n:]
+			cs[i] = container{array: array, n: n}
+		}
+		if cs[i].n != n {
+			return errCorrupt
+		}
+	}
+	b.keys, b.containers = keys, cs
+	return nil
+}
+
+// FromBytes returns the bitmap serialized in data by MarshalBinary or another roaring bitmap
+// implementation.
+func FromBytes(data []byte) (*Bitmap, error) {
+	b := &Bitmap{}
+	if err := b.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,306 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rocksdbsst writes SST files in the block-based table format of RocksDB, which RocksDB
+// based systems can bulk load with IngestExternalFile.
+//
+// Files are written the way RocksDB's SstFileWriter writes them: keys are sorted with the bytewise
+// comparator, all have sequence number 0, and the table properties mark the file as an external
+// file. Blocks aren't compressed, and there are no filter blocks.
+package rocksdbsst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// magic is the magic number ending block-based tables.
+	magic = 0x88e241b785f4cff7
+	// formatVersion is the version of the format. Version 2 only changes how compressed blocks
+	// are encoded, which aren't used.
+	formatVersion = 2
+	// footerSize is the size of the footer of versions 1 and above.
+	footerSize = 1 + 2*maxHandleSize + 4 + 8
+	// maxHandleSize is the maximum size of an encoded block handle.
+	maxHandleSize = 2 * binary.MaxVarintLen64
+	// checksumCRC32C is the checksum type of blocks.
+	checksumCRC32C = 1
+	// noCompression is the compression type of blocks.
+	noCompression = 0
+	// typeValue is the type of internal keys holding a value.
+	typeValue = 1
+	// unknownColumnFamily is the column family ID of external files.
+	unknownColumnFamily = 1<<31 - 1
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC returns the masked CRC32C of data, as stored by RocksDB.
+func maskedCRC(data ...[]byte) uint32 {
+	var crc uint32
+	for _, d := range data {
+		crc = crc32.Update(crc, crcTable, d)
+	}
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// blockBuilder builds a block of prefix-compressed entries.
+type blockBuilder struct {
+	restartInterval int
+	buf             []byte
+	restarts        []uint32
+	counter         int
+	lastKey         []byte
+}
+
+func (b *blockBuilder) add(key, value []byte) {
+	var shared int
+	if b.counter < b.restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.counter = 0
+	}
+	var tmp [binary.MaxVarintLen32]byte
+	for _, n := range []int{shared, len(key) - shared, len(value)} {
+		b.buf = append(b.buf, tmp[:binary.PutUvarint(tmp[:], uint64(n))]...)
+	}
+	b.buf = append(append(b.buf, key[shared:]...), value...)
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+}
+
+func (b *blockBuilder) empty() bool {
+	return len(b.buf) == 0
+}
+
+// estimatedSize returns the size of the block if it got finished now.
+func (b *blockBuilder) estimatedSize() int {
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+// finish returns the block, and resets the builder.
+func (b *blockBuilder) finish() []byte {
+	var tmp [4]byte
+	for _, r := range b.restarts {
+		binary.LittleEndian.PutUint32(tmp[:], r)
+		b.buf = append(b.buf, tmp[:]...)
+	}
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(b.restarts)))
+	block := append(b.buf, tmp[:]...)
+	b.buf, b.restarts, b.counter, b.lastKey = nil, []uint32{0}, 0, b.lastKey[:0]
+	return block
+}
+
+func newBlockBuilder(restartInterval int) *blockBuilder {
+	return &blockBuilder{restartInterval: restartInterval, restarts: []uint32{0}}
+}
+
+// blockHandle is the location of a block in the file, excluding its trailer.
+type blockHandle struct {
+	offset, size uint64
+}
+
+func (h blockHandle) encode(dst []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	dst = append(dst, tmp[:binary.PutUvarint(tmp[:], h.offset)]...)
+	return append(dst, tmp[:binary.PutUvarint(tmp[:], h.size)]...)
+}
+
+// Options are the options of a Writer.
+type Options struct {
+	// BlockSize is the approximate size of the data blocks, before their trailer.
+	BlockSize int
+	// RestartInterval is the number of keys between restart points of the data blocks.
+	RestartInterval int
+}
+
+// DefaultOptions are the default options of RocksDB.
+var DefaultOptions = Options{
+	BlockSize:       4 << 10,
+	RestartInterval: 16,
+}
+
+// Writer writes an SST file.
+type Writer struct {
+	w      io.Writer
+	opt    Options
+	offset uint64
+	err    error
+
+	data, index *blockBuilder
+	lastKey     []byte // Internal key.
+	numEntries  uint64
+	numBlocks   uint64
+	rawKeySize  uint64
+	rawValSize  uint64
+	dataSize    uint64
+}
+
+// NewWriter returns a Writer writing an SST file to w.
+func NewWriter(w io.Writer, opt Options) *Writer {
+	if opt.BlockSize <= 0 {
+		opt.BlockSize = DefaultOptions.BlockSize
+	}
+	if opt.RestartInterval <= 0 {
+		opt.RestartInterval = DefaultOptions.RestartInterval
+	}
+	return &Writer{
+		w:     w,
+		opt:   opt,
+		data:  newBlockBuilder(opt.RestartInterval),
+		index: newBlockBuilder(1),
+	}
+}
+
+// Add adds key with value to the file. Keys must be added in strictly increasing order.
+func (w *Writer) Add(key, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.numEntries > 0 && bytes.Compare(key, w.lastKey[:len(w.lastKey)-8]) <= 0 {
+		return errors.Errorf("Key %q isn't greater than the previous key", key)
+	}
+	ikey := make([]byte, len(key)+8)
+	copy(ikey, key)
+	// Sequence number 0, in the upper 56 bits.
+	binary.LittleEndian.PutUint64(ikey[len(key):], typeValue)
+	w.data.add(ikey, value)
+	w.lastKey = ikey
+	w.numEntries++
+	w.rawKeySize += uint64(len(ikey))
+	w.rawValSize += uint64(len(value))
+	if w.data.estimatedSize() >= w.opt.BlockSize {
+		w.flushData()
+	}
+	return w.err
+}
+
+// Size returns the number of bytes written so far.
+func (w *Writer) Size() uint64 {
+	return w.offset
+}
+
+// writeBlock writes block with its trailer, and returns its handle.
+func (w *Writer) writeBlock(block []byte) blockHandle {
+	h := blockHandle{offset: w.offset, size: uint64(len(block))}
+	if w.err != nil {
+		return h
+	}
+	trailer := make([]byte, 5)
+	trailer[0] = noCompression
+	binary.LittleEndian.PutUint32(trailer[1:], maskedCRC(block, trailer[:1]))
+	if _, err := w.w.Write(block); err != nil {
+		w.err = err
+		return h
+	}
+	if _, err := w.w.Write(trailer); err != nil {
+		w.err = err
+		return h
+	}
+	w.offset += uint64(len(block) + len(trailer))
+	return h
+}
+
+func (w *Writer) flushData() {
+	if w.data.empty() {
+		return
+	}
+	h := w.writeBlock(w.data.finish())
+	w.dataSize = w.offset
+	w.numBlocks++
+	// The last key of the block is a valid separator from the next block.
+	w.index.add(w.lastKey, h.encode(nil))
+}
+
+// properties returns the properties block.
+func (w *Writer) properties(indexSize uint64) []byte {
+	var tmp [8]byte
+	uvarint := func(v uint64) []byte {
+		buf := make([]byte, binary.MaxVarintLen64)
+		return buf[:binary.PutUvarint(buf, v)]
+	}
+	binary.LittleEndian.PutUint32(tmp[:], 2)
+	version := append([]byte{}, tmp[:4]...)
+	binary.LittleEndian.PutUint64(tmp[:], 0)
+	globalSeqno := append([]byte{}, tmp[:]...)
+	props := map[string][]byte{
+		"rocksdb.column.family.id":               uvarint(unknownColumnFamily),
+		"rocksdb.comparator":                     []byte("leveldb.BytewiseComparator"),
+		"rocksdb.data.size":                      uvarint(w.dataSize),
+		"rocksdb.external_sst_file.global_seqno": globalSeqno,
+		"rocksdb.external_sst_file.version":      version,
+		"rocksdb.filter.size":                    uvarint(0),
+		"rocksdb.fixed.key.length":               uvarint(0),
+		"rocksdb.index.size":                     uvarint(indexSize),
+		"rocksdb.num.data.blocks":                uvarint(w.numBlocks),
+		"rocksdb.num.entries":                    uvarint(w.numEntries),
+		"rocksdb.raw.key.size":                   uvarint(w.rawKeySize),
+		"rocksdb.raw.value.size":                 uvarint(w.rawValSize),
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := newBlockBuilder(1)
+	for _, name := range names {
+		b.add([]byte(name), props[name])
+	}
+	return b.finish()
+}
+
+// Finish writes the end of the file. The Writer can't be used anymore afterwards.
+func (w *Writer) Finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.numEntries == 0 {
+		return errors.New("Cannot write an SST file without keys")
+	}
+	w.flushData()
+	indexBlock := w.index.finish()
+	propsHandle := w.writeBlock(w.properties(uint64(len(indexBlock) + 5)))
+	meta := newBlockBuilder(1)
+	meta.add([]byte("rocksdb.properties"), propsHandle.encode(nil))
+	metaHandle := w.writeBlock(meta.finish())
+	indexHandle := w.writeBlock(indexBlock)
+	if w.err != nil {
+		return w.err
+	}
+
+	footer := make([]byte, 1, footerSize)
+	footer[0] = checksumCRC32C
+	footer = indexHandle.encode(metaHandle.encode(footer))
+	footer = footer[:footerSize]
+	binary.LittleEndian.PutUint32(footer[footerSize-12:], formatVersion)
+	binary.LittleEndian.PutUint64(footer[footerSize-8:], magic)
+	if _, err := w.w.Write(footer); err != nil {
+		w.err = err
+		return err
+	}
+	w.offset += footerSize
+	w.err = errors.New("Writer is finished")
+	return nil
+}
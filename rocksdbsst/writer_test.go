@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rocksdbsst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	key, value []byte
+}
+
+// readBlock returns the block at h in file, checking its trailer.
+func readBlock(t *testing.T, file []byte, h blockHandle) []byte {
+	block := file[h.offset : h.offset+h.size]
+	trailer := file[h.offset+h.size : h.offset+h.size+5]
+	require.Equal(t, byte(noCompression), trailer[0])
+	require.Equal(t, maskedCRC(block, trailer[:1]), binary.LittleEndian.Uint32(trailer[1:]))
+	return block
+}
+
+// readEntries decodes the entries of block.
+func readEntries(t *testing.T, block []byte) []entry {
+	numRestarts := int(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	data := block[:len(block)-4-4*numRestarts]
+	restarts := make(map[int]bool)
+	for i := 0; i < numRestarts; i++ {
+		restarts[int(binary.LittleEndian.Uint32(block[len(data)+4*i:]))] = true
+	}
+	var entries []entry
+	var key []byte
+	for off := 0; off < len(data); {
+		var fields [3]uint64
+		start := off
+		for i := range fields {
+			v, n := binary.Uvarint(data[off:])
+			require.True(t, n > 0)
+			fields[i] = v
+			off += n
+		}
+		if restarts[start] {
+			require.Zero(t, fields[0], "restart point with a shared prefix")
+		}
+		key = append(key[:fields[0]:fields[0]], data[off:off+int(fields[1])]...)
+		off += int(fields[1])
+		value := data[off : off+int(fields[2])]
+		off += int(fields[2])
+		entries = append(entries, entry{key, value})
+	}
+	return entries
+}
+
+func decodeHandle(t *testing.T, buf []byte) (blockHandle, int) {
+	offset, n := binary.Uvarint(buf)
+	require.True(t, n > 0)
+	size, m := binary.Uvarint(buf[n:])
+	require.True(t, m > 0)
+	return blockHandle{offset, size}, n + m
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{BlockSize: 512})
+	var want []entry
+	for i := 0; i < 1000; i++ {
+		e := entry{[]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%d", i))}
+		require.NoError(t, w.Add(e.key, e.value))
+		want = append(want, e)
+	}
+	require.Error(t, w.Add([]byte("key00010"), nil))
+	require.NoError(t, w.Finish())
+	require.Equal(t, uint64(buf.Len()), w.Size())
+	file := buf.Bytes()
+
+	footer := file[len(file)-footerSize:]
+	require.Equal(t, uint64(magic), binary.LittleEndian.Uint64(footer[footerSize-8:]))
+	require.Equal(t, uint32(formatVersion), binary.LittleEndian.Uint32(footer[footerSize-12:]))
+	require.Equal(t, byte(checksumCRC32C), footer[0])
+	metaHandle, n := decodeHandle(t, footer[1:])
+	indexHandle, _ := decodeHandle(t, footer[1+n:])
+
+	var got []entry
+	index := readEntries(t, readBlock(t, file, indexHandle))
+	require.True(t, len(index) > 1)
+	for _, ie := range index {
+		h, _ := decodeHandle(t, ie.value)
+		entries := readEntries(t, readBlock(t, file, h))
+		// Index keys are the last keys of their blocks.
+		require.Equal(t, entries[len(entries)-1].key, ie.key)
+		for _, e := range entries {
+			ukey := e.key[:len(e.key)-8]
+			require.Equal(t, uint64(typeValue), binary.LittleEndian.Uint64(e.key[len(ukey):]))
+			got = append(got, entry{ukey, e.value})
+		}
+	}
+	require.Equal(t, want, got)
+
+	meta := readEntries(t, readBlock(t, file, metaHandle))
+	require.Len(t, meta, 1)
+	require.Equal(t, "rocksdb.properties", string(meta[0].key))
+	propsHandle, _ := decodeHandle(t, meta[0].value)
+	props := make(map[string][]byte)
+	for _, e := range readEntries(t, readBlock(t, file, propsHandle)) {
+		props[string(e.key)] = e.value
+	}
+	numEntries, _ := binary.Uvarint(props["rocksdb.num.entries"])
+	require.Equal(t, uint64(1000), numEntries)
+	numBlocks, _ := binary.Uvarint(props["rocksdb.num.data.blocks"])
+	require.Equal(t, uint64(len(index)), numBlocks)
+	require.Equal(t, []byte{2, 0, 0, 0}, props["rocksdb.external_sst_file.version"])
+
+	require.Error(t, NewWriter(&buf, DefaultOptions).Finish())
+}
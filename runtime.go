@@ -0,0 +1,120 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/pkg/errors"
+)
+
+// RuntimeOptions are params for creating a Runtime.
+type RuntimeOptions struct {
+	// MaxCacheSize is the size of the block cache shared by the DBs.
+	MaxCacheSize int64
+	// CompactionCPUs is the number of goroutines merging and building tables at a time, across
+	// all the DBs. Zero means no limit. See Options.WithCompactionCPUs.
+	CompactionCPUs int
+	// CompactionWriteRate is the number of bytes per second the compactions of all the DBs may
+	// write. Zero means no limit.
+	CompactionWriteRate int64
+}
+
+// DefaultRuntimeOptions returns the RuntimeOptions matching DefaultOptions.
+func DefaultRuntimeOptions() RuntimeOptions {
+	return RuntimeOptions{MaxCacheSize: 1 << 30}
+}
+
+// Runtime holds the resources shared by the DBs opened with Options.WithRuntime: a block cache,
+// a budget of CPUs for the compactions and a limit on the rate they write at. Processes opening
+// many DBs, e.g. one per tenant, can bound the memory and CPU they use as a whole instead of
+// sizing them per DB.
+type Runtime struct {
+	blockCache   *ristretto.Cache
+	compactorCPU chan struct{} // Nil if the number of goroutines isn't limited.
+	writeLimiter *rateLimiter  // Nil if the write rate isn't limited.
+	numDBs       int32         // Atomic. Number of open DBs using the runtime.
+}
+
+// NewRuntime returns a new Runtime. It must be closed once all its DBs are closed.
+func NewRuntime(opt RuntimeOptions) (*Runtime, error) {
+	cache, err := newBlockCache(opt.MaxCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	rt := &Runtime{blockCache: cache}
+	if opt.CompactionCPUs > 0 {
+		rt.compactorCPU = newCPUBudget(Options{CompactionCPUs: opt.CompactionCPUs}).tokens
+	}
+	if opt.CompactionWriteRate > 0 {
+		rt.writeLimiter = &rateLimiter{rate: opt.CompactionWriteRate}
+	}
+	return rt, nil
+}
+
+// CacheMetrics returns the metrics of the shared block cache.
+func (rt *Runtime) CacheMetrics() *ristretto.Metrics {
+	return rt.blockCache.Metrics
+}
+
+// Close releases the resources of the runtime. It returns an error if DBs are still using it.
+func (rt *Runtime) Close() error {
+	if n := atomic.LoadInt32(&rt.numDBs); n > 0 {
+		return errors.Errorf("Runtime still used by %d DBs", n)
+	}
+	rt.blockCache.Close()
+	return nil
+}
+
+func newBlockCache(maxSize int64) (*ristretto.Cache, error) {
+	config := ristretto.Config{
+		// Use 5% of cache memory for storing counters.
+		NumCounters: int64(float64(maxSize) * 0.05 * 2),
+		MaxCost:     int64(float64(maxSize) * 0.95),
+		BufferItems: 64,
+		Metrics:     true,
+	}
+	cache, err := ristretto.NewCache(&config)
+	return cache, errors.Wrap(err, "failed to create cache")
+}
+
+// rateLimiter paces writes so that they don't exceed a number of bytes per second on average.
+type rateLimiter struct {
+	rate int64 // Bytes per second.
+
+	sync.Mutex
+	next time.Time // When the writes reserved so far are done.
+}
+
+// wait blocks until n bytes may be written.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.Unlock()
+	time.Sleep(time.Until(start))
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeSharedByDBs(t *testing.T) {
+	rtOpt := DefaultRuntimeOptions()
+	rtOpt.MaxCacheSize = 10 << 20
+	rtOpt.CompactionCPUs = 1
+	rt, err := NewRuntime(rtOpt)
+	require.NoError(t, err)
+
+	var dbs []*DB
+	for i := 0; i < 3; i++ {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		db, err := Open(getTestOptions(dir).WithRuntime(rt))
+		require.NoError(t, err)
+		require.Equal(t, rt.blockCache, db.blockCache)
+		dbs = append(dbs, db)
+	}
+	require.Error(t, rt.Close())
+
+	// The same keys in every DB, flattened into tables with the same IDs.
+	for i, db := range dbs {
+		for j := 0; j < 1000; j++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%04d", j)), []byte(fmt.Sprintf("db%d", i)), 0)
+		}
+		require.NoError(t, db.Flatten(1))
+	}
+	for round := 0; round < 2; round++ {
+		for i, db := range dbs {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				for j := 0; j < 1000; j += 37 {
+					item, err := txn.Get([]byte(fmt.Sprintf("key%04d", j)))
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("db%d", i), string(getItemValue(t, item)))
+				}
+				return nil
+			}))
+		}
+	}
+
+	for _, db := range dbs {
+		require.NoError(t, db.Close())
+	}
+	require.NoError(t, rt.Close())
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 1000}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait(50)
+	}
+	// The first write goes right away, and every other one waits for the one before.
+	require.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	var nilLimiter *rateLimiter
+	nilLimiter.wait(1 << 30)
+}
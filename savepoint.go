@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+// Savepoint marks a point in the writes of a transaction, see Txn.Savepoint.
+type Savepoint struct {
+	txn *Txn
+	idx int // idx is the index of the savepoint in txn.savepoints.
+
+	undo       int
+	writes     int
+	pinnedFids int
+	conditions int
+	size       int64
+	count      int64
+}
+
+// undoEntry records the pending write of key replaced by the txn, nil if there was none.
+type undoEntry struct {
+	key  string
+	prev *Entry
+}
+
+// Savepoint returns a savepoint marking the current point in the writes of txn. RollbackTo undoes
+// the writes done after it, leaving the earlier ones pending, so that a group of writes can be
+// tried out without discarding the whole transaction.
+//
+// The reads done after the savepoint are still checked for conflicts on commit.
+func (txn *Txn) Savepoint() *Savepoint {
+	sp := &Savepoint{
+		txn:        txn,
+		idx:        len(txn.savepoints),
+		undo:       len(txn.undo),
+		writes:     len(txn.writes),
+		pinnedFids: len(txn.pinnedFids),
+		conditions: len(txn.conditions),
+		size:       txn.size,
+		count:      txn.count,
+	}
+	txn.savepoints = append(txn.savepoints, sp)
+	return sp
+}
+
+// RollbackTo undoes the writes done by txn after the savepoint sp. The savepoint stays valid, but
+// the ones taken after it are released. It returns ErrInvalidSavepoint if sp wasn't taken by txn,
+// or got released.
+func (txn *Txn) RollbackTo(sp *Savepoint) error {
+	switch {
+	case txn.discarded:
+		return ErrDiscardedTxn
+	case sp == nil || sp.txn != txn || sp.idx >= len(txn.savepoints) ||
+		txn.savepoints[sp.idx] != sp:
+		return ErrInvalidSavepoint
+	}
+	for i := len(txn.undo) - 1; i >= sp.undo; i-- {
+		u := txn.undo[i]
+		if u.prev == nil {
+			delete(txn.pendingWrites, u.key)
+		} else {
+			txn.pendingWrites[u.key] = u.prev
+		}
+	}
+	txn.undo = txn.undo[:sp.undo]
+	txn.writes = txn.writes[:sp.writes]
+	txn.db.vlog.pins.unpin(txn.pinnedFids[sp.pinnedFids:])
+	txn.pinnedFids = txn.pinnedFids[:sp.pinnedFids]
+	txn.conditions = txn.conditions[:sp.conditions]
+	txn.size, txn.count = sp.size, sp.count
+	txn.savepoints = txn.savepoints[:sp.idx+1]
+	return nil
+}
+
+// setPendingWrite sets the pending write of key to e, deleting it if e is nil, and records the
+// previous one to undo the change if there's a savepoint.
+func (txn *Txn) setPendingWrite(key string, e *Entry) {
+	if len(txn.savepoints) > 0 {
+		txn.undo = append(txn.undo, undoEntry{key: key, prev: txn.pendingWrites[key]})
+	}
+	if e == nil {
+		delete(txn.pendingWrites, key)
+	} else {
+		txn.pendingWrites[key] = e
+	}
+}
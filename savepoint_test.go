@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnSavepoint(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("old"), []byte("v0"), 0)
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set([]byte("a"), []byte("v1")))
+		sp := txn.Savepoint()
+		size, count := txn.size, txn.count
+
+		require.NoError(t, txn.Set([]byte("a"), []byte("v2")))
+		require.NoError(t, txn.Set([]byte("b"), []byte("v2")))
+		require.NoError(t, txn.Delete([]byte("old")))
+		sp2 := txn.Savepoint()
+		require.NoError(t, txn.Set([]byte("c"), []byte("v3")))
+
+		require.NoError(t, txn.RollbackTo(sp))
+		require.Equal(t, size, txn.size)
+		require.Equal(t, count, txn.count)
+		// Later savepoints are released, the rolled back one can be used again.
+		require.Equal(t, ErrInvalidSavepoint, txn.RollbackTo(sp2))
+		require.NoError(t, txn.Set([]byte("d"), []byte("v4")))
+		require.NoError(t, txn.RollbackTo(sp))
+
+		other := db.NewTransaction(true)
+		require.Equal(t, ErrInvalidSavepoint, other.RollbackTo(sp))
+		other.Discard()
+
+		item, err := txn.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("v1"), getItemValue(t, item))
+		for _, key := range []string{"b", "c", "d"} {
+			_, err = txn.Get([]byte(key))
+			require.Equal(t, ErrKeyNotFound, err)
+		}
+		require.NoError(t, txn.Commit())
+		require.Equal(t, ErrDiscardedTxn, txn.RollbackTo(sp))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for key, val := range map[string]string{"a": "v1", "old": "v0"} {
+				item, err := txn.Get([]byte(key))
+				require.NoError(t, err)
+				require.Equal(t, []byte(val), getItemValue(t, item))
+			}
+			_, err := txn.Get([]byte("b"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
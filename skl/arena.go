@@ -114,8 +114,8 @@ func (s *Arena) getNode(offset uint32) *node {
 }
 
 // getKey returns byte slice at offset.
-func (s *Arena) getKey(offset uint32, size uint16) []byte {
-	return s.buf[offset : offset+uint32(size)]
+func (s *Arena) getKey(offset uint32, size uint32) []byte {
+	return s.buf[offset : offset+size]
 }
 
 // getVal returns byte slice at offset. The given size should be just the value
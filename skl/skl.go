@@ -58,7 +58,7 @@ type node struct {
 
 	// A byte slice is 24 bytes. We are trying to save space here.
 	keyOffset uint32 // Immutable. No need to lock to access key.
-	keySize   uint16 // Immutable. No need to lock to access key.
+	keySize   uint32 // Immutable. No need to lock to access key.
 
 	// Height of the tower.
 	height uint16
@@ -79,6 +79,7 @@ type Skiplist struct {
 	head   *node
 	ref    int32
 	arena  *Arena
+	cmp    y.Comparator // nil means byte-wise ordering.
 }
 
 // IncrRef increases the refcount
@@ -107,7 +108,7 @@ func newNode(arena *Arena, key []byte, v y.ValueStruct, height int) *node {
 	offset := arena.putNode(height)
 	node := arena.getNode(offset)
 	node.keyOffset = arena.putKey(key)
-	node.keySize = uint16(len(key))
+	node.keySize = uint32(len(key))
 	node.height = uint16(height)
 	node.value = encodeValue(arena.putVal(v), v.EncodedSize())
 	return node
@@ -125,6 +126,12 @@ func decodeValue(value uint64) (valOffset uint32, valSize uint32) {
 
 // NewSkiplist makes a new empty skiplist, with a given arena size
 func NewSkiplist(arenaSize int64) *Skiplist {
+	return NewSkiplistWithComparator(arenaSize, nil)
+}
+
+// NewSkiplistWithComparator makes a new empty skiplist, with a given arena size, ordering keys
+// with cmp. A nil cmp orders keys byte-wise.
+func NewSkiplistWithComparator(arenaSize int64, cmp y.Comparator) *Skiplist {
 	arena := newArena(arenaSize)
 	head := newNode(arena, nil, y.ValueStruct{}, maxHeight)
 	return &Skiplist{
@@ -132,6 +139,7 @@ func NewSkiplist(arenaSize int64) *Skiplist {
 		head:   head,
 		arena:  arena,
 		ref:    1,
+		cmp:    cmp,
 	}
 }
 
@@ -208,7 +216,7 @@ func (s *Skiplist) findNear(key []byte, less bool, allowEqual bool) (*node, bool
 		}
 
 		nextKey := next.key(s.arena)
-		cmp := y.CompareKeys(key, nextKey)
+		cmp := y.CompareKeysWith(s.cmp, key, nextKey)
 		if cmp > 0 {
 			// x.key < next.key < key. We can continue to move right.
 			x = next
@@ -263,7 +271,7 @@ func (s *Skiplist) findSpliceForLevel(key []byte, before *node, level int) (*nod
 			return before, next
 		}
 		nextKey := next.key(s.arena)
-		cmp := y.CompareKeys(key, nextKey)
+		cmp := y.CompareKeysWith(s.cmp, key, nextKey)
 		if cmp == 0 {
 			// Equality case.
 			return next, next
@@ -0,0 +1,321 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+const (
+	// snapshotTagsFilename is the file the snapshot tags are persisted to.
+	snapshotTagsFilename        = "SNAPSHOTS"
+	snapshotTagsRewriteFilename = "SNAPSHOTS-REWRITE"
+)
+
+// snapshotTags holds the read timestamps tagged with DB.TagSnapshot, and the ones pinned by the
+// snapshots of DB.NewSnapshot. The versions these read timestamps need are kept by compactions
+// until the tags and snapshots get released.
+type snapshotTags struct {
+	sync.Mutex
+	dir  string // Empty in InMemory mode, where tags aren't persisted.
+	tags map[string]uint64
+	// pinned counts the unreleased snapshots by read timestamp. They aren't persisted.
+	pinned map[uint64]int
+}
+
+// openSnapshotTags reads the snapshot tags persisted in dir.
+func openSnapshotTags(dir string) (*snapshotTags, error) {
+	st := &snapshotTags{dir: dir, tags: make(map[string]uint64)}
+	if dir == "" {
+		return st, nil
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, snapshotTagsFilename))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, y.Wrapf(err, "Error while reading snapshot tags")
+	}
+	// The file is a list of tags, each made of the length of its name, its name and its read
+	// timestamp, followed by the checksum of the list.
+	if len(buf) < 4 ||
+		crc32.Checksum(buf[:len(buf)-4], y.CastagnoliCrcTable) !=
+			binary.BigEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, errors.Errorf("Snapshot tags file %s is corrupted", snapshotTagsFilename)
+	}
+	buf = buf[:len(buf)-4]
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return nil, errors.Errorf("Snapshot tags file %s is corrupted", snapshotTagsFilename)
+		}
+		sz := int(binary.BigEndian.Uint32(buf))
+		if len(buf) < 4+sz+8 {
+			return nil, errors.Errorf("Snapshot tags file %s is corrupted", snapshotTagsFilename)
+		}
+		name := string(buf[4 : 4+sz])
+		st.tags[name] = binary.BigEndian.Uint64(buf[4+sz:])
+		buf = buf[4+sz+8:]
+	}
+	return st, nil
+}
+
+// persist writes the tags to disk. It must be called with the lock held.
+func (st *snapshotTags) persist() error {
+	if st.dir == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	for name, ts := range st.tags {
+		var b [8]byte
+		binary.BigEndian.PutUint32(b[:4], uint32(len(name)))
+		buf.Write(b[:4])
+		buf.WriteString(name)
+		binary.BigEndian.PutUint64(b[:], ts)
+		buf.Write(b[:])
+	}
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum(buf.Bytes(), y.CastagnoliCrcTable))
+	buf.Write(crc[:])
+
+	tmpPath := filepath.Join(st.dir, snapshotTagsRewriteFilename)
+	fp, err := y.OpenTruncFile(tmpPath, true)
+	if err != nil {
+		return y.Wrapf(err, "Error while opening snapshot tags file")
+	}
+	if _, err := fp.Write(buf.Bytes()); err != nil {
+		fp.Close()
+		return y.Wrapf(err, "Error while writing snapshot tags")
+	}
+	// In Windows the files should be closed before doing a Rename.
+	if err := fp.Close(); err != nil {
+		return y.Wrapf(err, "Error while closing snapshot tags file")
+	}
+	if err := os.Rename(tmpPath, filepath.Join(st.dir, snapshotTagsFilename)); err != nil {
+		return y.Wrapf(err, "Error while renaming snapshot tags file")
+	}
+	return syncDir(st.dir)
+}
+
+// minTs returns the lowest tagged or pinned read timestamp, if any.
+func (st *snapshotTags) minTs() (uint64, bool) {
+	st.Lock()
+	defer st.Unlock()
+	var min uint64
+	var found bool
+	for _, ts := range st.tags {
+		if !found || ts < min {
+			min, found = ts, true
+		}
+	}
+	for ts := range st.pinned {
+		if !found || ts < min {
+			min, found = ts, true
+		}
+	}
+	return min, found
+}
+
+// discardAtOrBelow returns the timestamp at or below which compactions can discard old versions,
+// leaving alone the versions needed by the tagged snapshots.
+func (db *DB) discardAtOrBelow() uint64 {
+	ts := db.orc.discardAtOrBelow()
+	if min, ok := db.snapshots.minTs(); ok && min < ts {
+		// The version read at min is the latest one at or below it, which compactions keep.
+		return min
+	}
+	return ts
+}
+
+// TagSnapshot tags the current read timestamp with name, and returns it. The versions needed to
+// read the DB as of this timestamp are kept until the tag is released with ReleaseSnapshot, so
+// that the snapshot can be read later on with NewTransactionAtTag, e.g. by batch jobs which must
+// see the data as it was at some point. Tags are persisted along with the DB. Tagging a snapshot
+// with the name of an existing tag moves the tag.
+//
+// Tagged snapshots keep old versions from being discarded, so they should be released as soon as
+// they're not needed anymore. TagSnapshot can't be used in managed mode, use TagSnapshotAt.
+func (db *DB) TagSnapshot(name string) (uint64, error) {
+	if db.opt.managedTxns {
+		panic("Cannot use TagSnapshot with managedDB=true. Use TagSnapshotAt instead.")
+	}
+	readTs := db.orc.readTs()
+	// Versions at readTs are protected until the tag is in place.
+	defer db.orc.readMark.Done(readTs)
+	return readTs, db.TagSnapshotAt(name, readTs)
+}
+
+// TagSnapshotAt tags the given read timestamp with name. See TagSnapshot. In managed mode, the
+// versions needed by readTs must not have been discarded already, i.e. readTs must be above the
+// timestamp passed to SetDiscardTs.
+func (db *DB) TagSnapshotAt(name string, readTs uint64) error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot tag snapshots in read-only mode")
+	}
+	st := db.snapshots
+	st.Lock()
+	defer st.Unlock()
+	old, existed := st.tags[name]
+	st.tags[name] = readTs
+	if err := st.persist(); err != nil {
+		if existed {
+			st.tags[name] = old
+		} else {
+			delete(st.tags, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReleaseSnapshot removes the tag with the given name, letting compactions discard the versions
+// only needed by its snapshot.
+func (db *DB) ReleaseSnapshot(name string) error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot release snapshots in read-only mode")
+	}
+	st := db.snapshots
+	st.Lock()
+	defer st.Unlock()
+	ts, ok := st.tags[name]
+	if !ok {
+		return errors.Errorf("No snapshot tagged %q", name)
+	}
+	delete(st.tags, name)
+	if err := st.persist(); err != nil {
+		st.tags[name] = ts
+		return err
+	}
+	return nil
+}
+
+// SnapshotTags returns the read timestamps of all the tagged snapshots, by name.
+func (db *DB) SnapshotTags() map[string]uint64 {
+	st := db.snapshots
+	st.Lock()
+	defer st.Unlock()
+	tags := make(map[string]uint64, len(st.tags))
+	for name, ts := range st.tags {
+		tags[name] = ts
+	}
+	return tags
+}
+
+// NewTransactionAtTag returns a read-only transaction reading the DB as of the snapshot tagged
+// with name. It can be used in both normal and managed modes. The tag must not be released before
+// the transaction is discarded.
+func (db *DB) NewTransactionAtTag(name string) (*Txn, error) {
+	st := db.snapshots
+	st.Lock()
+	readTs, ok := st.tags[name]
+	st.Unlock()
+	if !ok {
+		return nil, errors.Errorf("No snapshot tagged %q", name)
+	}
+	// In normal mode, the transaction registers the current read timestamp with the oracle, as
+	// the tagged one might be below what it already marked as done.
+	txn := db.newTransaction(false, db.opt.managedTxns)
+	txn.readTs = readTs
+	return txn, nil
+}
+
+// Snapshot is a read timestamp pinned by DB.NewSnapshot, from which any number of read-only
+// transactions can be created, all reading the DB as of that timestamp. Unlike a tagged snapshot,
+// it isn't persisted, and goes away with the DB.
+type Snapshot struct {
+	db       *DB
+	readTs   uint64
+	released int32
+}
+
+// NewSnapshot pins the current read timestamp, keeping the versions needed to read the DB as of it
+// until the snapshot gets released with Snapshot.Release, so that many short read transactions,
+// spread over minutes, can see the same data without having to keep a single transaction open.
+// NewSnapshot can't be used in managed mode, use NewSnapshotAt.
+func (db *DB) NewSnapshot() *Snapshot {
+	if db.opt.managedTxns {
+		panic("Cannot use NewSnapshot with managedDB=true. Use NewSnapshotAt instead.")
+	}
+	readTs := db.orc.readTs()
+	// Versions at readTs are protected until the snapshot pins them.
+	defer db.orc.readMark.Done(readTs)
+	return db.NewSnapshotAt(readTs)
+}
+
+// NewSnapshotAt pins the given read timestamp. See NewSnapshot. In managed mode, the versions
+// needed by readTs must not have been discarded already, i.e. readTs must be above the timestamp
+// passed to SetDiscardTs.
+func (db *DB) NewSnapshotAt(readTs uint64) *Snapshot {
+	st := db.snapshots
+	st.Lock()
+	defer st.Unlock()
+	if st.pinned == nil {
+		st.pinned = make(map[uint64]int)
+	}
+	st.pinned[readTs]++
+	return &Snapshot{db: db, readTs: readTs}
+}
+
+// ReadTs returns the read timestamp of the snapshot.
+func (s *Snapshot) ReadTs() uint64 {
+	return s.readTs
+}
+
+// NewTransaction returns a read-only transaction reading the DB as of the snapshot, or
+// ErrSnapshotReleased. The snapshot must not be released before the transaction is discarded.
+func (s *Snapshot) NewTransaction() (*Txn, error) {
+	if atomic.LoadInt32(&s.released) == 1 {
+		return nil, ErrSnapshotReleased
+	}
+	// See NewTransactionAtTag.
+	txn := s.db.newTransaction(false, s.db.opt.managedTxns)
+	txn.readTs = s.readTs
+	return txn, nil
+}
+
+// View runs fn in a read-only transaction reading the DB as of the snapshot. See DB.View.
+func (s *Snapshot) View(fn func(txn *Txn) error) error {
+	txn, err := s.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+	return fn(txn)
+}
+
+// Release releases the snapshot, letting compactions discard the versions only needed by it.
+// Releasing a snapshot more than once has no effect.
+func (s *Snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+	st := s.db.snapshots
+	st.Lock()
+	defer st.Unlock()
+	if st.pinned[s.readTs]--; st.pinned[s.readTs] == 0 {
+		delete(st.pinned, s.readTs)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	set := func(val string) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte(val))
+		}))
+	}
+	readAtTag := func(name string) string {
+		txn, err := db.NewTransactionAtTag(name)
+		require.NoError(t, err)
+		defer txn.Discard()
+		item, err := txn.Get(key)
+		require.NoError(t, err)
+		return string(getItemValue(t, item))
+	}
+	countVersions := func() int {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewKeyIterator(key, iopt)
+		defer it.Close()
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+
+	set("nightly")
+	ts, err := db.TagSnapshot("nightly")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		set(fmt.Sprintf("val%d", i))
+	}
+	require.Equal(t, map[string]uint64{"nightly": ts}, db.SnapshotTags())
+	require.NoError(t, db.CompactRange(nil, nil))
+	require.Equal(t, "nightly", readAtTag("nightly"))
+	require.Equal(t, 11, countVersions())
+
+	// Tags survive restarts.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Equal(t, map[string]uint64{"nightly": ts}, db.SnapshotTags())
+	require.NoError(t, db.CompactRange(nil, nil))
+	require.Equal(t, "nightly", readAtTag("nightly"))
+
+	require.True(t, db.discardAtOrBelow() <= ts)
+
+	// Once released, the old versions can be discarded.
+	require.NoError(t, db.ReleaseSnapshot("nightly"))
+	require.Error(t, db.ReleaseSnapshot("nightly"))
+	_, err = db.NewTransactionAtTag("nightly")
+	require.Error(t, err)
+	waitFor(t, func() bool { return db.discardAtOrBelow() > ts })
+}
+
+func TestNewSnapshot(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		set := func(val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key, []byte(val))
+			}))
+		}
+		read := func(s *Snapshot) string {
+			var val []byte
+			require.NoError(t, s.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				require.NoError(t, err)
+				val = getItemValue(t, item)
+				return nil
+			}))
+			return string(val)
+		}
+
+		set("first")
+		s := db.NewSnapshot()
+		other := db.NewSnapshot()
+		for i := 0; i < 10; i++ {
+			set(fmt.Sprintf("val%d", i))
+		}
+		require.NoError(t, db.CompactRange(nil, nil))
+		require.Equal(t, "first", read(s))
+		require.Equal(t, "first", read(s))
+		require.True(t, db.discardAtOrBelow() <= s.ReadTs())
+
+		// The read timestamp stays pinned until both snapshots are released.
+		s.Release()
+		s.Release()
+		_, err := s.NewTransaction()
+		require.Equal(t, ErrSnapshotReleased, err)
+		require.True(t, db.discardAtOrBelow() <= s.ReadTs())
+		require.Equal(t, "first", read(other))
+		other.Release()
+		waitFor(t, func() bool { return db.discardAtOrBelow() > s.ReadTs() })
+	})
+}
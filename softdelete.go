@@ -0,0 +1,94 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"time"
+)
+
+// isSoftDeleted returns true if meta and expiresAt belong to a soft delete marker which can still
+// be undeleted. The version deleted by such a marker must be kept by flushes and compactions.
+func isSoftDeleted(meta byte, expiresAt uint64) bool {
+	return meta&bitSoftDelete > 0 && expiresAt > uint64(time.Now().Unix())
+}
+
+// IsSoftDeleted returns true if item is a soft delete marker written by Txn.SoftDelete which can
+// still be undeleted. Such items are only returned by iterators with AllVersions or
+// IncludeSoftDeleted set.
+func (item *Item) IsSoftDeleted() bool {
+	return isSoftDeleted(item.meta, item.expiresAt)
+}
+
+// SoftDelete deletes a key like Delete, but keeps its current value around for
+// Options.SoftDeleteWindow, during which Undelete can bring it back. The key reads as deleted
+// in the meantime, except to iterators with IncludeSoftDeleted set.
+func (txn *Txn) SoftDelete(key []byte) error {
+	e := &Entry{
+		Key:       key,
+		meta:      bitDelete | bitSoftDelete,
+		ExpiresAt: uint64(time.Now().Add(txn.db.opt.SoftDeleteWindow).Unix()),
+	}
+	return txn.modify(e)
+}
+
+// Undelete restores the value a key had before it got soft deleted, along with its user metadata
+// and expiration time. It returns ErrNotSoftDeleted if the latest version of the key isn't a soft
+// delete marker, or if its SoftDeleteWindow is over. A key soft deleted by txn itself gets back
+// its last committed version.
+//
+// Undelete iterates over the versions of the key, so no other iterator can be open on txn.
+func (txn *Txn) Undelete(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
+		if !isSoftDeleted(e.meta, e.ExpiresAt) {
+			return ErrNotSoftDeleted
+		}
+		txn.setPendingWrite(string(key), nil)
+		return nil
+	}
+	txn.addReadKey(key)
+	opt := DefaultIteratorOptions
+	opt.PrefetchValues = false
+	it := txn.NewKeyIterator(key, opt)
+	var e *Entry
+	err := func() error {
+		defer it.Close()
+		it.Rewind()
+		if !it.Valid() || !it.Item().IsSoftDeleted() {
+			return ErrNotSoftDeleted
+		}
+		it.Next()
+		if !it.Valid() || it.Item().IsDeletedOrExpired() {
+			// The deleted version expired in the meantime.
+			return ErrNotSoftDeleted
+		}
+		item := it.Item()
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		e = &Entry{Key: key, Value: val, UserMeta: item.UserMeta(), ExpiresAt: item.ExpiresAt()}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+	return txn.SetEntry(e)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.SetEntry(NewEntry(key, []byte("old")).WithMeta(7))
+	}))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.SetEntry(NewEntry(key, []byte("val")).WithMeta(8))
+	}))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.Equal(t, ErrNotSoftDeleted, txn.Undelete(key))
+		return txn.SoftDelete(key)
+	}))
+
+	listKeys := func(opt IteratorOptions) []string {
+		var keys []string
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				k := string(it.Item().Key())
+				if it.Item().IsSoftDeleted() {
+					k += " (soft deleted)"
+				}
+				keys = append(keys, k)
+			}
+			return nil
+		}))
+		return keys
+	}
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get(key)
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+		require.Empty(t, listKeys(DefaultIteratorOptions))
+		iopt := DefaultIteratorOptions
+		iopt.IncludeSoftDeleted = true
+		require.Equal(t, []string{"key (soft deleted)"}, listKeys(iopt))
+	}
+	check()
+
+	// The deleted version survives compactions.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	txn := db.NewTransaction(false)
+	readTs := txn.ReadTs()
+	txn.Discard()
+	waitFor(t, func() bool { return db.discardAtOrBelow() >= readTs })
+	require.NoError(t, db.CompactRange(nil, nil))
+	check()
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Undelete(key)
+	}))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("val"), getItemValue(t, item))
+		require.Equal(t, byte(8), item.UserMeta())
+		return nil
+	}))
+	require.Equal(t, []string{"key"}, listKeys(DefaultIteratorOptions))
+}
+
+func TestSoftDeleteWindowOver(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		db.opt.SoftDeleteWindow = 0
+		key := []byte("key")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("val"))
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SoftDelete(key)
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.Equal(t, ErrNotSoftDeleted, txn.Undelete(key))
+			return nil
+		}))
+	})
+}
+
+func TestUndeleteInSameTxn(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("val"))
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.SoftDelete(key))
+			_, err := txn.Get(key)
+			require.Equal(t, ErrKeyNotFound, err)
+			return txn.Undelete(key)
+		}))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, []byte("val"), getItemValue(t, item))
+			return nil
+		}))
+	})
+}
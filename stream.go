@@ -94,15 +94,19 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 		if err != nil {
 			return nil, err
 		}
+		origKey, err := item.OriginalKey()
+		if err != nil {
+			return nil, err
+		}
 		kv := &pb.KV{
-			Key:       item.KeyCopy(nil),
+			Key:       y.SafeCopy(nil, origKey),
 			Value:     valCopy,
 			UserMeta:  []byte{item.UserMeta()},
 			Version:   item.Version(),
 			ExpiresAt: item.ExpiresAt(),
 		}
 		list.Kv = append(list.Kv, kv)
-		if st.db.opt.NumVersionsToKeep == 1 {
+		if st.db.opt.NumVersionsToKeep == 1 && st.db.retention.policyFor(key) == nil {
 			break
 		}
 
@@ -179,7 +183,7 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 			prevKey = append(prevKey[:0], item.Key()...)
 
 			// Check if we reached the end of the key range.
-			if len(kr.right) > 0 && bytes.Compare(item.Key(), kr.right) >= 0 {
+			if len(kr.right) > 0 && st.db.opt.compareKeys(item.Key(), kr.right) >= 0 {
 				break
 			}
 			// Check if we should pick this key.
@@ -17,6 +17,8 @@
 package badger
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"sync"
@@ -74,7 +76,7 @@ func (sw *StreamWriter) Prepare() error {
 	defer sw.writeLock.Unlock()
 
 	var err error
-	sw.done, err = sw.db.dropAll()
+	sw.done, err = sw.db.dropAll(context.Background(), nil)
 	return err
 }
 
@@ -109,6 +111,9 @@ func (sw *StreamWriter) Write(kvs *pb.KVList) error {
 		if len(kv.UserMeta) > 0 {
 			userMeta = kv.UserMeta[0]
 		}
+		if err := checkReservedKey(kv.Key); err != nil {
+			return err
+		}
 		if sw.maxVersion < kv.Version {
 			sw.maxVersion = kv.Version
 		}
@@ -119,6 +124,11 @@ func (sw *StreamWriter) Write(kvs *pb.KVList) error {
 			ExpiresAt: kv.ExpiresAt,
 			meta:      meta,
 		}
+		key, err := sw.db.encodeEntry(kv.Key, e)
+		if err != nil {
+			return err
+		}
+		e.Key = y.KeyWithTs(key, kv.Version)
 		// If the value can be collocated with the key in LSM tree, we can skip
 		// writing the value to value log.
 		e.skipVlog = sw.db.shouldWriteValueToLSM(*e)
@@ -247,6 +257,8 @@ func (sw *StreamWriter) Flush() error {
 	for _, l := range sw.db.lc.levels {
 		l.sortTables()
 	}
+	// The keys written didn't go through the memtables.
+	sw.db.negCache.clear()
 
 	// Now sync the directories, so all the files are registered.
 	if sw.db.opt.ValueDir != sw.db.opt.Dir {
@@ -265,6 +277,7 @@ type sortedWriter struct {
 	throttle *y.Throttle
 
 	builder  *table.Builder
+	scope    []byte // The key prefix of the keys in builder, see KeyRegistry.keyScope.
 	lastKey  []byte
 	streamID uint32
 	reqCh    chan *request
@@ -274,21 +287,16 @@ type sortedWriter struct {
 }
 
 func (sw *StreamWriter) newWriter(streamID uint32) (*sortedWriter, error) {
-	dk, err := sw.db.registry.latestDataKey()
-	if err != nil {
-		return nil, err
-	}
-
-	bopts := buildTableOptions(sw.db.opt)
-	bopts.DataKey = dk
 	w := &sortedWriter{
 		db:       sw.db,
 		streamID: streamID,
 		throttle: sw.throttle,
-		builder:  table.NewTableBuilder(bopts),
 		reqCh:    make(chan *request, 3),
 		closer:   y.NewCloser(1),
 	}
+	if err := w.newBuilder(nil); err != nil {
+		return nil, err
+	}
 
 	go w.handleRequests()
 	return w, nil
@@ -349,15 +357,28 @@ func (w *sortedWriter) handleRequests() {
 
 // Add adds key and vs to sortedWriter.
 func (w *sortedWriter) Add(key []byte, vs y.ValueStruct) error {
-	if len(w.lastKey) > 0 && y.CompareKeys(key, w.lastKey) <= 0 {
+	if len(w.lastKey) > 0 && y.CompareKeysWith(w.db.opt.Comparator, key, w.lastKey) <= 0 {
 		return ErrUnsortedKey
 	}
 
 	sameKey := y.SameKey(key, w.lastKey)
-	// Same keys should go into the same SSTable.
-	if !sameKey && w.builder.ReachedCapacity(w.db.opt.MaxTableSize) {
-		if err := w.send(false); err != nil {
-			return err
+	// Same keys should go into the same SSTable. Keys of a prefix having its own data keys get
+	// SSTables of their own.
+	if !sameKey {
+		scope := w.db.registry.keyScope(y.ParseKey(key))
+		if !bytes.Equal(scope, w.scope) && w.builder.Empty() {
+			w.builder.Close()
+			if err := w.newBuilder(scope); err != nil {
+				return err
+			}
+		} else if !bytes.Equal(scope, w.scope) ||
+			w.builder.ReachedCapacity(w.db.opt.MaxTableSize) {
+			if err := w.send(); err != nil {
+				return err
+			}
+			if err := w.newBuilder(scope); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -370,7 +391,9 @@ func (w *sortedWriter) Add(key []byte, vs y.ValueStruct) error {
 	return nil
 }
 
-func (w *sortedWriter) send(done bool) error {
+// send writes the table of w.builder in the background. Add allocates the next builder when
+// needed.
+func (w *sortedWriter) send() error {
 	if err := w.throttle.Do(); err != nil {
 		return err
 	}
@@ -378,20 +401,19 @@ func (w *sortedWriter) send(done bool) error {
 		err := w.createTable(builder)
 		w.throttle.Done(err)
 	}(w.builder)
-	// If done is true, this indicates we can close the writer.
-	// No need to allocate underlying TableBuilder now.
-	if done {
-		w.builder = nil
-		return nil
-	}
+	w.builder = nil
+	return nil
+}
 
-	dk, err := w.db.registry.latestDataKey()
+// newBuilder replaces the builder of w with a new one, for the keys having the given scope.
+func (w *sortedWriter) newBuilder(scope []byte) error {
+	dk, err := w.db.registry.latestDataKey(scope)
 	if err != nil {
-		return y.Wrapf(err, "Error while retriving datakey in sortedWriter.send")
+		return y.Wrapf(err, "Error while retriving datakey in sortedWriter.newBuilder")
 	}
 	bopts := buildTableOptions(w.db.opt)
 	bopts.DataKey = dk
-	w.builder = table.NewTableBuilder(bopts)
+	w.builder, w.scope = table.NewTableBuilder(bopts), scope
 	return nil
 }
 
@@ -404,7 +426,7 @@ func (w *sortedWriter) Done() error {
 		return nil
 	}
 
-	return w.send(true)
+	return w.send()
 }
 
 func (w *sortedWriter) createTable(builder *table.Builder) error {
@@ -433,6 +455,7 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 		if tbl, err = table.OpenTable(fd, opts); err != nil {
 			return err
 		}
+		tbl.Digest = w.db.tableDigest(data)
 	}
 	lc := w.db.lc
 
@@ -465,10 +488,13 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 		Op:          pb.ManifestChange_CREATE,
 		Level:       uint32(lhandler.level),
 		Compression: uint32(tbl.CompressionType()),
+		Sha256:      tbl.Digest,
+		ValuesOnly:  tbl.EncryptsValuesOnly(),
 	}
 	if err := w.db.manifest.addChanges([]*pb.ManifestChange{change}); err != nil {
 		return err
 	}
+	w.db.tableEvents.send(tableChanges(lhandler.level, true, tbl))
 
 	// We are not calling lhandler.replaceTables() here, as it sorts tables on every addition.
 	// We can sort all tables only once during Flush() call.
@@ -148,6 +148,9 @@ type Entry struct {
 	offset   uint32
 	skipVlog bool
 	hlen     int // Length of the header.
+	// origKey is the key a pending write was set with, on the copies iterated with hashed keys.
+	origKey []byte
+	noBloom bool // Set by WithoutBloom.
 }
 
 func (e *Entry) estimateSize(threshold int) int {
@@ -200,6 +203,28 @@ func (e *Entry) WithTTL(dur time.Duration) *Entry {
 	return e
 }
 
+// WithShortLived hints that Entry e will soon be deleted or expire, as locks, leases or heartbeats
+// do. When the memtable holding it gets flushed, short-lived entries are written to a level 0
+// table of their own, so that they don't grow the tables of the long-lived data flushed along with
+// them. Only flushes keep them apart: compacting level 0 merges them with the other entries into
+// the tables of level 1, whose key ranges can't overlap. Every flush holding short-lived entries
+// adds another table to level 0, which counts towards NumLevelZeroTables.
+func (e *Entry) WithShortLived() *Entry {
+	e.meta |= bitShortLived
+	return e
+}
+
+// WithoutBloom hints that the key of Entry e isn't worth adding to bloom filters, as for keys
+// which are scanned rather than looked up, or looked up only when they exist. When the memtable
+// holding it gets flushed, the entry is written to a level 0 table of its own without a bloom
+// filter, so that it doesn't grow the bloom filter of the other entries. Like WithShortLived, the
+// hint only applies to flushes, and it isn't kept in the value log either: the entries replayed
+// when opening the DB after a crash are indexed.
+func (e *Entry) WithoutBloom() *Entry {
+	e.noBloom = true
+	return e
+}
+
 // withMergeBit sets merge bit in entry's metadata. This
 // function is called by MergeOperator's Add method.
 func (e *Entry) withMergeBit() *Entry {
@@ -18,7 +18,7 @@ package table
 
 import (
 	"bytes"
-	"crypto/aes"
+	"crypto/cipher"
 	"math"
 	"unsafe"
 
@@ -61,6 +61,38 @@ func (h *header) Decode(buf []byte) {
 	copy(((*[headerSize]byte)(unsafe.Pointer(h))[:]), buf[:headerSize])
 }
 
+const (
+	// formatLargeKeys is the format version of tables holding keys of 64KB or more. The overlap
+	// and diff of their headers are set to largeKeyMarker, and followed by the actual values as
+	// uint32s. Smaller keys can't have such a header, so tables without large keys keep format
+	// version 0, readable by older versions of Badger.
+	formatLargeKeys = 1
+	largeKeyMarker  = math.MaxUint16
+	// maxFormatVersion is the latest format version tables can be read with.
+	maxFormatVersion = formatLargeKeys
+)
+
+// encodeHeader returns the header of an entry with the given key overlap and diff lengths.
+func encodeHeader(overlap, diff int) []byte {
+	if overlap < largeKeyMarker && diff < largeKeyMarker {
+		return header{overlap: uint16(overlap), diff: uint16(diff)}.Encode()
+	}
+	buf := header{overlap: largeKeyMarker, diff: largeKeyMarker}.Encode()
+	buf = append(buf, y.U32ToBytes(uint32(overlap))...)
+	return append(buf, y.U32ToBytes(uint32(diff))...)
+}
+
+// decodeHeader returns the key overlap and diff lengths of the entry in buf, along with the size
+// of its header.
+func decodeHeader(buf []byte) (overlap, diff uint32, size uint32) {
+	var h header
+	h.Decode(buf)
+	if h.overlap != largeKeyMarker || h.diff != largeKeyMarker {
+		return uint32(h.overlap), uint32(h.diff), uint32(headerSize)
+	}
+	return y.BytesToU32(buf[headerSize:]), y.BytesToU32(buf[headerSize+4:]), uint32(headerSize) + 8
+}
+
 // Builder is used in building a table.
 type Builder struct {
 	// Typically tens or hundreds of meg. This is for one single file.
@@ -72,6 +104,11 @@ type Builder struct {
 	tableIndex   *pb.TableIndex
 	keyHashes    []uint64 // Used for building the bloomfilter.
 	opt          *Options
+
+	// cipher and aead encrypt the values of the tables encrypting values only, set up on the
+	// first value.
+	cipher cipher.Block
+	aead   cipher.AEAD
 }
 
 // NewTableBuilder makes a new TableBuilder.
@@ -102,7 +139,9 @@ func (b *Builder) keyDiff(newKey []byte) []byte {
 }
 
 func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint64) {
-	b.keyHashes = append(b.keyHashes, farm.Fingerprint64(y.ParseKey(key)))
+	if !b.opt.NoBloom {
+		b.keyHashes = append(b.keyHashes, farm.Fingerprint64(y.ParseKey(key)))
+	}
 
 	// diffKey stores the difference of key with baseKey.
 	var diffKey []byte
@@ -115,9 +154,9 @@ func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint64) {
 		diffKey = b.keyDiff(key)
 	}
 
-	h := header{
-		overlap: uint16(len(key) - len(diffKey)),
-		diff:    uint16(len(diffKey)),
+	h := encodeHeader(len(key)-len(diffKey), len(diffKey))
+	if len(h) > int(headerSize) {
+		b.tableIndex.FormatVersion = formatLargeKeys
 	}
 
 	// store current entry's offset
@@ -125,12 +164,17 @@ func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint64) {
 	b.entryOffsets = append(b.entryOffsets, uint32(b.buf.Len())-b.baseOffset)
 
 	// Layout: header, diffKey, value.
-	b.buf.Write(h.Encode())
+	b.buf.Write(h)
 	b.buf.Write(diffKey) // We only need to store the key difference.
 
+	if b.encryptsValues() && len(v.Value) > 0 {
+		val, err := b.encrypt(v.Value)
+		y.Check(y.Wrapf(err, "Error while encrypting value in table builder."))
+		v.Value = val
+	}
 	v.EncodeTo(b.buf)
 	// Size of KV on SST.
-	sstSz := uint64(uint32(headerSize) + uint32(len(diffKey)) + v.EncodedSize())
+	sstSz := uint64(uint32(len(h)) + uint32(len(diffKey)) + v.EncodedSize())
 	// Total estimated size = size on SST + size on vlog (length of value pointer).
 	b.tableIndex.EstimatedSize += (sstSz + vpLen)
 }
@@ -203,10 +247,10 @@ func (b *Builder) shouldFinishBlock(key []byte, value y.ValueStruct) bool {
 	estimatedSize := uint32(b.buf.Len()) - b.baseOffset + uint32(6 /*header size for entry*/) +
 		uint32(len(key)) + uint32(value.EncodedSize()) + entriesOffsetsSize
 
-	if b.shouldEncrypt() {
-		// IV is added at the end of the block, while encrypting.
+	if b.shouldEncrypt() || (b.encryptsValues() && len(value.Value) > 0) {
+		// IV is added at the end of the block, or of the value, while encrypting.
 		// So, size of IV is added to estimatedSize.
-		estimatedSize += aes.BlockSize
+		estimatedSize += uint32(y.EncryptionOverhead(b.DataKey().Algo))
 	}
 	return estimatedSize > uint32(b.opt.BlockSize)
 }
@@ -257,12 +301,14 @@ The table structure looks like
 */
 // In case the data is encrypted, the "IV" is added to the end of the index.
 func (b *Builder) Finish() []byte {
-	bf := z.NewBloomFilter(float64(len(b.keyHashes)), b.opt.BloomFalsePositive)
-	for _, h := range b.keyHashes {
-		bf.Add(h)
+	if !b.opt.NoBloom {
+		bf := z.NewBloomFilter(float64(len(b.keyHashes)), b.opt.BloomFalsePositive)
+		for _, h := range b.keyHashes {
+			bf.Add(h)
+		}
+		// Add bloom filter to the index.
+		b.tableIndex.BloomFilter = bf.JSONMarshal()
 	}
-	// Add bloom filter to the index.
-	b.tableIndex.BloomFilter = bf.JSONMarshal()
 
 	b.finishBlock() // This will never start a new block.
 
@@ -288,17 +334,16 @@ func (b *Builder) Finish() []byte {
 
 func (b *Builder) writeChecksum(data []byte) {
 	// Build checksum for the index.
+	// CRC32 is the default option because it performed better compared to xxHash64, where
+	// it's hardware accelerated.
+	// See the BenchmarkChecksum in table_test.go file
+	// Size     =>   1024 B        2048 B
+	// CRC32    => 63.7 ns/op     112 ns/op
+	// xxHash64 => 87.5 ns/op     158 ns/op
+	algo := pb.Checksum_Algorithm(b.opt.ChecksumAlgorithm)
 	checksum := pb.Checksum{
-		// TODO: The checksum type should be configurable from the
-		// options.
-		// We chose to use CRC32 as the default option because
-		// it performed better compared to xxHash64.
-		// See the BenchmarkChecksum in table_test.go file
-		// Size     =>   1024 B        2048 B
-		// CRC32    => 63.7 ns/op     112 ns/op
-		// xxHash64 => 87.5 ns/op     158 ns/op
-		Sum:  y.CalculateChecksum(data, pb.Checksum_CRC32C),
-		Algo: pb.Checksum_CRC32C,
+		Sum:  y.CalculateChecksum(data, algo),
+		Algo: algo,
 	}
 
 	// Write checksum to the file.
@@ -321,22 +366,49 @@ func (b *Builder) DataKey() *pb.DataKey {
 // encrypt will encrypt the given data and appends IV to the end of the encrypted data.
 // This should be only called only after checking shouldEncrypt method.
 func (b *Builder) encrypt(data []byte) ([]byte, error) {
+	if b.DataKey().Algo == pb.EncryptionAlgo_aes_gcm {
+		return b.seal(data)
+	}
 	iv, err := y.GenerateIV()
 	if err != nil {
 		return data, y.Wrapf(err, "Error while generating IV in Builder.encrypt")
 	}
-	data, err = y.XORBlock(data, b.DataKey().Data, iv)
-	if err != nil {
-		return data, y.Wrapf(err, "Error while encrypting in Builder.encrypt")
+	if b.cipher == nil {
+		if b.cipher, err = y.NewCipher(b.DataKey().Data); err != nil {
+			return data, y.Wrapf(err, "Error while encrypting in Builder.encrypt")
+		}
 	}
+	data = y.XORBlockWithCipher(data, b.cipher, iv)
 	data = append(data, iv...)
 	return data, nil
 }
 
-// shouldEncrypt tells us whether to encrypt the data or not.
-// We encrypt only if the data key exist. Otherwise, not.
+// seal encrypts the given data with AES-GCM, and appends the nonce to the end of the encrypted
+// data, which is followed by its authentication tag.
+func (b *Builder) seal(data []byte) ([]byte, error) {
+	if b.aead == nil {
+		var err error
+		if b.aead, err = y.NewGCM(b.DataKey().Data); err != nil {
+			return data, y.Wrapf(err, "Error while setting up cipher in Builder.seal")
+		}
+	}
+	nonce, err := y.GenerateNonce()
+	if err != nil {
+		return data, y.Wrapf(err, "Error while generating nonce in Builder.seal")
+	}
+	data = b.aead.Seal(nil, nonce, data, nil)
+	return append(data, nonce...), nil
+}
+
+// shouldEncrypt tells us whether to encrypt the blocks and the index or not.
+// We encrypt only if the data key exist, and not only the values are encrypted. Otherwise, not.
 func (b *Builder) shouldEncrypt() bool {
-	return b.opt.DataKey != nil
+	return b.opt.DataKey != nil && !b.opt.EncryptValuesOnly
+}
+
+// encryptsValues tells us whether to encrypt the values one by one.
+func (b *Builder) encryptsValues() bool {
+	return b.opt.DataKey != nil && b.opt.EncryptValuesOnly
 }
 
 // compressData compresses the given data.
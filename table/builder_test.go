@@ -17,12 +17,15 @@
 package table
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/dgryski/go-farm"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dgraph-io/badger/v2/options"
@@ -51,6 +54,12 @@ func TestTableIndex(t *testing.T) {
 		require.NoError(t, err)
 		opts = append(opts, Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
 			DataKey: &pb.DataKey{Data: key}})
+		// Authenticated encryption mode.
+		opts = append(opts, Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+			DataKey: &pb.DataKey{Data: key, Algo: pb.EncryptionAlgo_aes_gcm}})
+		// Values only encryption mode.
+		opts = append(opts, Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+			DataKey: &pb.DataKey{Data: key}, EncryptValuesOnly: true})
 		// Compression mode.
 		opts = append(opts, Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
 			Compression: options.ZSTD})
@@ -97,6 +106,34 @@ func TestTableIndex(t *testing.T) {
 	})
 }
 
+func TestTamperedBlock(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	for _, algo := range []pb.EncryptionAlgo{pb.EncryptionAlgo_aes, pb.EncryptionAlgo_aes_gcm} {
+		opts := Options{DataKey: &pb.DataKey{Data: key, Algo: algo}}
+		f := buildTestTable(t, "key", 1000, opts)
+		// Flip a bit of the first block.
+		var b [1]byte
+		_, err := f.ReadAt(b[:], 10)
+		require.NoError(t, err)
+		b[0] ^= 1
+		_, err = f.WriteAt(b[:], 10)
+		require.NoError(t, err)
+
+		tbl, err := OpenTable(f, opts)
+		require.NoError(t, err)
+		_, err = tbl.block(0)
+		if algo == pb.EncryptionAlgo_aes_gcm {
+			require.Error(t, err)
+		} else {
+			// The block decrypts to garbage, which only the checksum can catch.
+			require.NoError(t, err)
+		}
+		require.NoError(t, tbl.DecrRef())
+	}
+}
+
 func TestInvalidCompression(t *testing.T) {
 	keyPrefix := "key"
 	opts := Options{Compression: options.ZSTD}
@@ -135,3 +172,61 @@ func BenchmarkBuilder(b *testing.B) {
 		_ = builder.Finish()
 	}
 }
+
+func TestEncryptValuesOnly(t *testing.T) {
+	dkey := make([]byte, 32)
+	_, err := rand.Read(dkey)
+	require.NoError(t, err)
+	for _, algo := range []pb.EncryptionAlgo{pb.EncryptionAlgo_aes, pb.EncryptionAlgo_aes_gcm} {
+		opts := Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+			DataKey: &pb.DataKey{Data: dkey, Algo: algo}, EncryptValuesOnly: true}
+		keyValues := make([][]string, 1000)
+		for i := range keyValues {
+			keyValues[i] = []string{key("key", i), fmt.Sprintf("secret-value-%04d", i)}
+		}
+		keyValues = append(keyValues, []string{key("key", 1000), ""})
+		f := buildTable(t, keyValues, opts)
+
+		// The keys are stored in plain text, the values aren't.
+		data, err := ioutil.ReadFile(f.Name())
+		require.NoError(t, err)
+		require.True(t, bytes.Contains(data, []byte(key("key", 0))))
+		require.False(t, bytes.Contains(data, []byte("secret-value")))
+
+		tbl, err := OpenTable(f, opts)
+		require.NoError(t, err)
+		require.True(t, tbl.EncryptsValuesOnly())
+		require.Zero(t, tbl.EncryptionOverhead())
+		require.False(t, tbl.DoesNotHave(farm.Fingerprint64([]byte(key("key", 500)))))
+
+		it := tbl.NewIterator(false)
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			require.Equal(t, keyValues[n][0], string(y.ParseKey(it.Key())))
+			require.Equal(t, keyValues[n][1], string(it.Value().Value))
+			require.Equal(t, keyValues[n][1], string(it.ValueCopy().Value))
+			n++
+		}
+		require.Equal(t, len(keyValues), n)
+		it.Seek(y.KeyWithTs([]byte(key("key", 42)), 0))
+		require.True(t, it.Valid())
+		require.Equal(t, "secret-value-0042", string(it.Value().Value))
+		require.NoError(t, it.Close())
+
+		// A wrong key fails the authentication of the values with AES-GCM.
+		if algo == pb.EncryptionAlgo_aes_gcm {
+			wrong := opts
+			wrong.DataKey = &pb.DataKey{Data: make([]byte, 32), Algo: algo}
+			tbl2, err := OpenTable(buildTable(t, keyValues, opts), wrong)
+			require.NoError(t, err)
+			it := tbl2.NewIterator(false)
+			it.Rewind()
+			it.Value()
+			require.False(t, it.Valid())
+			require.Error(t, it.err)
+			require.NoError(t, it.Close())
+			require.NoError(t, tbl2.DecrRef())
+		}
+		require.NoError(t, tbl.DecrRef())
+	}
+}
@@ -33,10 +33,11 @@ type blockIterator struct {
 	key          []byte
 	val          []byte
 	entryOffsets []uint32
+	cmp          y.Comparator
 
 	// prevOverlap stores the overlap of the previous key with the base key.
 	// This avoids unnecessary copy of base key when the overlap is same for multiple keys.
-	prevOverlap uint16
+	prevOverlap uint32
 }
 
 func (itr *blockIterator) setBlock(b *block) {
@@ -63,9 +64,8 @@ func (itr *blockIterator) setIdx(i int) {
 
 	// Set base key.
 	if len(itr.baseKey) == 0 {
-		var baseHeader header
-		baseHeader.Decode(itr.data)
-		itr.baseKey = itr.data[headerSize : headerSize+baseHeader.diff]
+		_, diff, hlen := decodeHeader(itr.data)
+		itr.baseKey = itr.data[hlen : hlen+diff]
 	}
 	var endOffset int
 	// idx points to the last entry in the block.
@@ -78,18 +78,17 @@ func (itr *blockIterator) setIdx(i int) {
 	}
 
 	entryData := itr.data[startOffset:endOffset]
-	var h header
-	h.Decode(entryData)
+	overlap, diff, hlen := decodeHeader(entryData)
 	// Header contains the length of key overlap and difference compared to the base key. If the key
 	// before this one had the same or better key overlap, we can avoid copying that part into
 	// itr.key. But, if the overlap was lesser, we could copy over just that portion.
-	if h.overlap > itr.prevOverlap {
-		itr.key = append(itr.key[:itr.prevOverlap], itr.baseKey[itr.prevOverlap:h.overlap]...)
+	if overlap > itr.prevOverlap {
+		itr.key = append(itr.key[:itr.prevOverlap], itr.baseKey[itr.prevOverlap:overlap]...)
 	}
-	itr.prevOverlap = h.overlap
-	valueOff := headerSize + h.diff
-	diffKey := entryData[headerSize:valueOff]
-	itr.key = append(itr.key[:h.overlap], diffKey...)
+	itr.prevOverlap = overlap
+	valueOff := hlen + diff
+	diffKey := entryData[hlen:valueOff]
+	itr.key = append(itr.key[:overlap], diffKey...)
 	itr.val = entryData[valueOff:]
 }
 
@@ -126,7 +125,7 @@ func (itr *blockIterator) seek(key []byte, whence int) {
 			return false
 		}
 		itr.setIdx(idx)
-		return y.CompareKeys(itr.key, key) >= 0
+		return y.CompareKeysWith(itr.cmp, itr.key, key) >= 0
 	})
 	itr.setIdx(foundEntryIdx)
 }
@@ -165,6 +164,7 @@ type Iterator struct {
 func (t *Table) NewIterator(reversed bool) *Iterator {
 	t.IncrRef() // Important.
 	ti := &Iterator{t: t, reversed: reversed}
+	ti.bi.cmp = t.opt.Comparator
 	ti.next()
 	return ti
 }
@@ -241,7 +241,7 @@ func (itr *Iterator) seekFrom(key []byte, whence int) {
 
 	idx := sort.Search(len(itr.t.blockIndex), func(idx int) bool {
 		ko := itr.t.blockIndex[idx]
-		return y.CompareKeys(ko.Key, key) > 0
+		return itr.t.CompareKeys(ko.Key, key) > 0
 	})
 	if idx == 0 {
 		// The smallest key in our table is already strictly > key. We can return that.
@@ -350,6 +350,7 @@ func (itr *Iterator) Key() []byte {
 // Value follows the y.Iterator interface
 func (itr *Iterator) Value() (ret y.ValueStruct) {
 	ret.Decode(itr.bi.val)
+	itr.decryptValue(&ret)
 	return
 }
 
@@ -358,9 +359,25 @@ func (itr *Iterator) Value() (ret y.ValueStruct) {
 func (itr *Iterator) ValueCopy() (ret y.ValueStruct) {
 	dst := y.Copy(itr.bi.val)
 	ret.Decode(dst)
+	itr.decryptValue(&ret)
 	return
 }
 
+// decryptValue decrypts the value of vs if the table encrypts its values only. The decrypted
+// value is a new slice. If it can't be decrypted, the iterator stops with the error.
+func (itr *Iterator) decryptValue(vs *y.ValueStruct) {
+	if !itr.t.EncryptsValuesOnly() || len(vs.Value) == 0 {
+		return
+	}
+	val, err := itr.t.decrypt(vs.Value)
+	if err != nil {
+		itr.err = y.Wrapf(err, "failed to decrypt value of key %q", y.ParseKey(itr.bi.key))
+		vs.Value = nil
+		return
+	}
+	vs.Value = val
+}
+
 // Next follows the y.Iterator interface
 func (itr *Iterator) Next() {
 	if !itr.reversed {
@@ -462,12 +479,12 @@ func (s *ConcatIterator) Seek(key []byte) {
 	var idx int
 	if !s.reversed {
 		idx = sort.Search(len(s.tables), func(i int) bool {
-			return y.CompareKeys(s.tables[i].Biggest(), key) >= 0
+			return s.tables[i].CompareKeys(s.tables[i].Biggest(), key) >= 0
 		})
 	} else {
 		n := len(s.tables)
 		idx = n - 1 - sort.Search(n, func(i int) bool {
-			return y.CompareKeys(s.tables[n-1-i].Smallest(), key) <= 0
+			return s.tables[n-1-i].CompareKeys(s.tables[n-1-i].Smallest(), key) <= 0
 		})
 	}
 	if idx >= len(s.tables) || idx < 0 {
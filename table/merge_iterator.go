@@ -32,6 +32,7 @@ type MergeIterator struct {
 
 	curKey  []byte
 	reverse bool
+	cmp     y.Comparator
 }
 
 type node struct {
@@ -102,7 +103,7 @@ func (mi *MergeIterator) fix() {
 		mi.swapSmall()
 		return
 	}
-	cmp := y.CompareKeys(mi.small.key, mi.bigger().key)
+	cmp := y.CompareKeysWith(mi.cmp, mi.small.key, mi.bigger().key)
 	// Both the keys are equal.
 	if cmp == 0 {
 		// In case of same keys, move the right iterator ahead.
@@ -206,6 +207,13 @@ func (mi *MergeIterator) Close() error {
 
 // NewMergeIterator creates a merge iterator.
 func NewMergeIterator(iters []y.Iterator, reverse bool) y.Iterator {
+	return NewMergeIteratorWithComparator(iters, reverse, nil)
+}
+
+// NewMergeIteratorWithComparator creates a merge iterator ordering keys with cmp. A nil cmp
+// orders keys byte-wise.
+func NewMergeIteratorWithComparator(iters []y.Iterator, reverse bool,
+	cmp y.Comparator) y.Iterator {
 	if len(iters) == 0 {
 		return nil
 	} else if len(iters) == 1 {
@@ -213,6 +221,7 @@ func NewMergeIterator(iters []y.Iterator, reverse bool) y.Iterator {
 	} else if len(iters) == 2 {
 		mi := &MergeIterator{
 			reverse: reverse,
+			cmp:     cmp,
 		}
 		mi.left.setIterator(iters[0])
 		mi.right.setIterator(iters[1])
@@ -221,9 +230,9 @@ func NewMergeIterator(iters []y.Iterator, reverse bool) y.Iterator {
 		return mi
 	}
 	mid := len(iters) / 2
-	return NewMergeIterator(
+	return NewMergeIteratorWithComparator(
 		[]y.Iterator{
-			NewMergeIterator(iters[:mid], reverse),
-			NewMergeIterator(iters[mid:], reverse),
-		}, reverse)
+			NewMergeIteratorWithComparator(iters[:mid], reverse, cmp),
+			NewMergeIteratorWithComparator(iters[mid:], reverse, cmp),
+		}, reverse, cmp)
 }
@@ -18,6 +18,7 @@ package table
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"fmt"
 	"io"
 	"math"
@@ -59,12 +60,19 @@ type Options struct {
 	// BloomFalsePositive is the false positive probabiltiy of bloom filter.
 	BloomFalsePositive float64
 
+	// NoBloom builds the table without a bloom filter, so every lookup searches it.
+	NoBloom bool
+
 	// BlockSize is the size of each block inside SSTable in bytes.
 	BlockSize int
 
 	// DataKey is the key used to decrypt the encrypted text.
 	DataKey *pb.DataKey
 
+	// EncryptValuesOnly encrypts the values one by one with DataKey, leaving the blocks and the
+	// index, bloom filter included, in plaintext. Seeks then don't decrypt anything.
+	EncryptValuesOnly bool
+
 	// Compression indicates the compression algorithm used for block compression.
 	Compression options.CompressionType
 
@@ -72,6 +80,20 @@ type Options struct {
 
 	// ZSTDCompressionLevel is the ZSTD compression level used for compressing blocks.
 	ZSTDCompressionLevel int
+
+	// ChecksumAlgorithm is the checksum of the blocks and index. It's stored along with every
+	// checksum, so tables are read whatever it is.
+	ChecksumAlgorithm options.ChecksumAlgorithm
+
+	// Comparator orders the keys of the table. Nil means byte-wise ordering.
+	Comparator y.Comparator
+
+	// Counters count the disk reads of the table, along with the global expvars. It can be nil.
+	Counters *y.Counters
+
+	// CacheID is mixed into the keys of the blocks in Cache, so that the tables of several DBs,
+	// whose IDs overlap, can share it.
+	CacheID uint64
 }
 
 // TableInterface is useful for testing.
@@ -104,6 +126,22 @@ type Table struct {
 
 	IsInmemory bool // Set to true if the table is on level 0 and opened in memory.
 	opt        *Options
+
+	// Digest is the SHA-256 of the file, set by the creator of the table if it records it. It's
+	// nil for the tables opened from existing files.
+	Digest []byte
+
+	// cipher decrypts the blocks and the index. It's set up once from opt.DataKey when the
+	// table is opened, so reads don't need to go through the key registry. Nil if the table
+	// isn't encrypted.
+	cipher cipher.Block
+	// aead replaces cipher for the tables encrypted with AES-GCM.
+	aead cipher.AEAD
+}
+
+// CompareKeys compares two keys with timestamps using the comparator the table was opened with.
+func (t *Table) CompareKeys(key1, key2 []byte) int {
+	return y.CompareKeysWith(t.opt.Comparator, key1, key2)
 }
 
 // CompressionType returns the compression algorithm used for block compression.
@@ -200,6 +238,10 @@ func OpenTable(fd *os.File, opts Options) (*Table, error) {
 	}
 
 	t.tableSize = int(fileInfo.Size())
+	if err := t.initCipher(); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
 
 	switch opts.LoadingMode {
 	case options.LoadToRAM:
@@ -255,12 +297,32 @@ func OpenInMemoryTable(data []byte, id uint64, opt *Options) (*Table, error) {
 		id:         id, // It is important that each table gets a unique ID.
 	}
 
+	if err := t.initCipher(); err != nil {
+		return nil, err
+	}
 	if err := t.initBiggestAndSmallest(); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
+// initCipher sets up the cipher used to decrypt the table, if it's encrypted.
+func (t *Table) initCipher() error {
+	if t.opt.DataKey == nil {
+		return nil
+	}
+	var err error
+	if t.opt.DataKey.Algo == pb.EncryptionAlgo_aes_gcm {
+		t.aead, err = y.NewGCM(t.opt.DataKey.Data)
+	} else {
+		t.cipher, err = y.NewCipher(t.opt.DataKey.Data)
+	}
+	if err != nil {
+		return y.Wrapf(err, "Error while setting up cipher for the table %d", t.id)
+	}
+	return nil
+}
+
 func (t *Table) initBiggestAndSmallest() error {
 	if err := t.readIndex(); err != nil {
 		return errors.Wrapf(err, "failed to read index.")
@@ -302,8 +364,7 @@ func (t *Table) read(off, sz int) ([]byte, error) {
 
 	res := make([]byte, sz)
 	nbr, err := t.fd.ReadAt(res, int64(off))
-	y.NumReads.Add(1)
-	y.NumBytesRead.Add(int64(nbr))
+	t.opt.Counters.AddRead(int64(nbr))
 	return res, err
 }
 
@@ -352,9 +413,15 @@ func (t *Table) readIndex() error {
 	}
 	err := proto.Unmarshal(data, &index)
 	y.Check(err)
+	if index.FormatVersion > maxFormatVersion {
+		return errors.Errorf("Table %d has format version %d, this version of Badger supports "+
+			"up to %d", t.id, index.FormatVersion, maxFormatVersion)
+	}
 
 	t.estimatedSize = index.EstimatedSize
-	t.bf = z.JSONUnmarshal(index.BloomFilter)
+	if len(index.BloomFilter) > 0 {
+		t.bf = z.JSONUnmarshal(index.BloomFilter)
+	}
 	t.blockIndex = index.Offsets
 	return nil
 }
@@ -439,7 +506,7 @@ func (t *Table) block(idx int) (*block, error) {
 func (t *Table) blockCacheKey(idx int) uint64 {
 	y.AssertTrue(t.ID() < math.MaxUint32)
 	y.AssertTrue(uint32(idx) < math.MaxUint32)
-	return (t.ID() << 32) | uint64(idx)
+	return ((t.ID() << 32) | uint64(idx)) ^ t.opt.CacheID
 }
 
 // EstimatedSize returns the total size of key-values stored in this table (including the
@@ -449,6 +516,24 @@ func (t *Table) EstimatedSize() uint64 { return t.estimatedSize }
 // Size is its file size in bytes
 func (t *Table) Size() int64 { return int64(t.tableSize) }
 
+// MappedSize returns the number of bytes of the table memory mapped, zero unless the table was
+// opened with options.MemoryMap.
+func (t *Table) MappedSize() int64 {
+	if t.opt.LoadingMode != options.MemoryMap {
+		return 0
+	}
+	return int64(len(t.mmap))
+}
+
+// LoadedSize returns the number of bytes of the table held in RAM, zero unless the table was
+// opened with options.LoadToRAM or in memory.
+func (t *Table) LoadedSize() int64 {
+	if t.opt.LoadingMode != options.LoadToRAM {
+		return 0
+	}
+	return int64(len(t.mmap))
+}
+
 // Smallest is its smallest key, or nil if there are none
 func (t *Table) Smallest() []byte { return t.smallest }
 
@@ -462,8 +547,8 @@ func (t *Table) Filename() string { return t.fd.Name() }
 func (t *Table) ID() uint64 { return t.id }
 
 // DoesNotHave returns true if (but not "only if") the table does not have the key hash.
-// It does a bloom filter lookup.
-func (t *Table) DoesNotHave(hash uint64) bool { return !t.bf.Has(hash) }
+// It does a bloom filter lookup, and returns false if the table has no bloom filter.
+func (t *Table) DoesNotHave(hash uint64) bool { return t.bf != nil && !t.bf.Has(hash) }
 
 // VerifyChecksum verifies checksum for all blocks of table. This function is called by
 // OpenTable() function. This function is also called inside levelsController.VerifyChecksum().
@@ -489,10 +574,48 @@ func (t *Table) VerifyChecksum() error {
 	return nil
 }
 
-// shouldDecrypt tells whether to decrypt or not. We decrypt only if the datakey exist
-// for the table.
+// shouldDecrypt tells whether to decrypt the blocks and the index or not. We decrypt only if the
+// datakey exist for the table, and it doesn't encrypt only the values.
 func (t *Table) shouldDecrypt() bool {
-	return t.opt.DataKey != nil
+	return t.opt.DataKey != nil && !t.opt.EncryptValuesOnly
+}
+
+// EncryptsValuesOnly tells whether only the values of the table are encrypted.
+func (t *Table) EncryptsValuesOnly() bool {
+	return t.opt.DataKey != nil && t.opt.EncryptValuesOnly
+}
+
+// BlockSizes returns the number of blocks of the table, their size in the file, and their size
+// once decrypted and decompressed. Every block gets read, bypassing the cache.
+func (t *Table) BlockSizes() (numBlocks int, fileSize, rawSize int64, err error) {
+	for _, ko := range t.blockIndex {
+		data, err := t.read(int(ko.Offset), int(ko.Len))
+		if err != nil {
+			return 0, 0, 0, errors.Wrapf(err, "failed to read block of table %d at offset %d",
+				t.id, ko.Offset)
+		}
+		if t.shouldDecrypt() {
+			if data, err = t.decrypt(data); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		if data, err = t.decompressData(data); err != nil {
+			return 0, 0, 0, errors.Wrapf(err, "failed to decompress block of table %d at offset %d",
+				t.id, ko.Offset)
+		}
+		fileSize += int64(ko.Len)
+		rawSize += int64(len(data))
+	}
+	return len(t.blockIndex), fileSize, rawSize, nil
+}
+
+// EncryptionOverhead returns the number of bytes added to every block by the encryption, zero
+// if the table isn't encrypted or only its values are.
+func (t *Table) EncryptionOverhead() int {
+	if !t.shouldDecrypt() {
+		return 0
+	}
+	return y.EncryptionOverhead(t.opt.DataKey.Algo)
 }
 
 // KeyID returns data key id.
@@ -506,11 +629,20 @@ func (t *Table) KeyID() uint64 {
 
 // decrypt decrypts the given data. It should be called only after checking shouldDecrypt.
 func (t *Table) decrypt(data []byte) ([]byte, error) {
+	if t.aead != nil {
+		// The data is followed by the nonce.
+		n := len(data) - t.aead.NonceSize()
+		if n < 0 {
+			return nil, errors.Errorf("Encrypted data of table %d is too short", t.id)
+		}
+		plain, err := t.aead.Open(nil, data[n:], data[:n], nil)
+		return plain, errors.Wrapf(err, "Authentication of table %d failed", t.id)
+	}
 	// Last BlockSize bytes of the data is the IV.
 	iv := data[len(data)-aes.BlockSize:]
 	// Rest all bytes are data.
 	data = data[:len(data)-aes.BlockSize]
-	return y.XORBlock(data, t.opt.DataKey.Data, iv)
+	return y.XORBlockWithCipher(data, t.cipher, iv), nil
 }
 
 // ParseFileID reads the file id out of a filename.
@@ -21,6 +21,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"math/rand"
 	"os"
 	"sort"
@@ -732,6 +733,55 @@ func TestTableBigValues(t *testing.T) {
 	require.Equal(t, n, count)
 }
 
+func TestTableBigKeys(t *testing.T) {
+	// The keys share a prefix longer than math.MaxUint16, and the first ones are even longer.
+	prefix := strings.Repeat("p", 100000)
+	keyValues := [][]string{
+		{prefix + strings.Repeat("a", 70000), "big"},
+		{prefix + "b", "overlap"},
+		{"small", "small"},
+	}
+	for i := 0; i < 100; i++ {
+		keyValues = append(keyValues, []string{key(prefix, i), fmt.Sprintf("%d", i)})
+	}
+	opts := Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01}
+	f := buildTable(t, keyValues, opts)
+	tbl, err := OpenTable(f, opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+
+	itr := tbl.NewIterator(false)
+	defer itr.Close()
+	var i int
+	for itr.Rewind(); itr.Valid(); itr.Next() {
+		require.Equal(t, keyValues[i][0], string(y.ParseKey(itr.Key())))
+		require.Equal(t, keyValues[i][1], string(itr.Value().Value))
+		i++
+	}
+	require.Equal(t, len(keyValues), i)
+
+	itr.Seek(y.KeyWithTs([]byte(key(prefix, 50)), 0))
+	require.True(t, itr.Valid())
+	require.Equal(t, "50", string(itr.Value().Value))
+	require.Equal(t, []byte(keyValues[0][0]), y.ParseKey(tbl.Smallest()))
+}
+
+func TestLargeKeyHeader(t *testing.T) {
+	for _, tc := range []struct{ overlap, diff, size int }{
+		{10, 20, 4},
+		{math.MaxUint16 - 1, 0, 4},
+		{math.MaxUint16, 0, 12},
+		{0, 1 << 20, 12},
+	} {
+		h := encodeHeader(tc.overlap, tc.diff)
+		require.Len(t, h, tc.size)
+		overlap, diff, size := decodeHeader(h)
+		require.Equal(t, uint32(tc.overlap), overlap)
+		require.Equal(t, uint32(tc.diff), diff)
+		require.Equal(t, uint32(tc.size), size)
+	}
+}
+
 // This test is for verifying checksum failure during table open.
 func TestTableChecksum(t *testing.T) {
 	rand.Seed(time.Now().Unix())
@@ -751,6 +801,29 @@ func TestTableChecksum(t *testing.T) {
 	}
 }
 
+func TestTableChecksumAlgorithm(t *testing.T) {
+	for _, algo := range []options.ChecksumAlgorithm{
+		options.CRC32C, options.XXHash64, options.NoChecksum} {
+		opts := getTestTableOptions()
+		opts.ChkMode = options.OnTableAndBlockRead
+		opts.ChecksumAlgorithm = algo
+		f := buildTestTable(t, "k", 10000, opts)
+		// The algorithm is read from the table.
+		opts.ChecksumAlgorithm = options.CRC32C
+		tbl, err := OpenTable(f, opts)
+		require.NoError(t, err, "algorithm %d", algo)
+		require.NoError(t, tbl.VerifyChecksum())
+		it := tbl.NewIterator(false)
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		it.Close()
+		require.Equal(t, 10000, n)
+		require.NoError(t, tbl.DecrRef())
+	}
+}
+
 var cacheConfig = ristretto.Config{
 	NumCounters: 1000000 * 10,
 	MaxCost:     1000000,
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// TableEvent reports a table added to or removed from the LSM tree.
+type TableEvent struct {
+	Added bool // False if the table got removed.
+	ID    uint64
+	Level int
+	// Smallest and Biggest are the smallest and biggest keys of the table, without timestamps.
+	Smallest, Biggest []byte
+	// MinVersion and MaxVersion are the smallest and biggest versions of the keys of the table.
+	MinVersion, MaxVersion uint64
+}
+
+// TableListener is called with the tables added and removed by a change to the LSM tree, e.g. a
+// flush or a compaction.
+type TableListener func(events []TableEvent)
+
+type tableChange struct {
+	t     *table.Table
+	level int
+	added bool
+}
+
+func tableChanges(level int, added bool, tables ...*table.Table) []tableChange {
+	changes := make([]tableChange, len(tables))
+	for i, t := range tables {
+		changes[i] = tableChange{t: t, level: level, added: added}
+	}
+	return changes
+}
+
+// tableEvents delivers the changes to the LSM tree to the table listener from a goroutine, in
+// order. Changes are queued without limit, so that flushes and compactions never wait for the
+// listener.
+type tableEvents struct {
+	sync.Mutex
+	cond    *sync.Cond
+	queue   [][]tableChange
+	closed  bool
+	done    chan struct{}
+	trigger TableListener
+}
+
+// newTableEvents returns nil if listener is nil.
+func newTableEvents(listener TableListener) *tableEvents {
+	if listener == nil {
+		return nil
+	}
+	te := &tableEvents{done: make(chan struct{}), trigger: listener}
+	te.cond = sync.NewCond(te)
+	go te.run()
+	return te
+}
+
+// send queues the given changes, which were just written to the MANIFEST. The tables are kept
+// open until the changes get delivered.
+func (te *tableEvents) send(changes []tableChange) {
+	if te == nil || len(changes) == 0 {
+		return
+	}
+	for _, c := range changes {
+		c.t.IncrRef()
+	}
+	te.Lock()
+	te.queue = append(te.queue, changes)
+	te.Unlock()
+	te.cond.Signal()
+}
+
+func (te *tableEvents) run() {
+	defer close(te.done)
+	for {
+		te.Lock()
+		for len(te.queue) == 0 && !te.closed {
+			te.cond.Wait()
+		}
+		if len(te.queue) == 0 {
+			te.Unlock()
+			return
+		}
+		changes := te.queue[0]
+		te.queue = te.queue[1:]
+		te.Unlock()
+
+		events := make([]TableEvent, len(changes))
+		for i, c := range changes {
+			events[i] = TableEvent{
+				Added:    c.added,
+				ID:       c.t.ID(),
+				Level:    c.level,
+				Smallest: y.ParseKey(c.t.Smallest()),
+				Biggest:  y.ParseKey(c.t.Biggest()),
+			}
+			events[i].MinVersion, events[i].MaxVersion = versionBounds(c.t)
+		}
+		te.trigger(events)
+		for _, c := range changes {
+			_ = c.t.DecrRef()
+		}
+	}
+}
+
+// close delivers the changes still queued, and stops the goroutine.
+func (te *tableEvents) close() {
+	if te == nil {
+		return
+	}
+	te.Lock()
+	te.closed = true
+	te.Unlock()
+	te.cond.Signal()
+	<-te.done
+}
+
+// versionBounds returns the smallest and biggest versions of the keys of t. Tables don't record
+// them, so all the keys get read.
+func versionBounds(t *table.Table) (uint64, uint64) {
+	min, max := uint64(math.MaxUint64), uint64(0)
+	it := t.NewIterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		v := y.ParseTs(it.Key())
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		min = 0
+	}
+	return min, max
+}
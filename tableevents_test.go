@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var mu sync.Mutex
+	var changes [][]TableEvent
+	opt := getTestOptions(dir).WithTableListener(func(events []TableEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, events)
+	})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	var first, last uint64
+	for _, k := range []string{"b", "a", "c"} {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(k), []byte("val"))
+		}))
+		txn := db.NewTransaction(false)
+		if first == 0 {
+			first = txn.ReadTs()
+		}
+		last = txn.ReadTs()
+		txn.Discard()
+	}
+	// Closing flushes the memtable to level 0, and compacts level 0 into level 1.
+	require.NoError(t, db.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, changes, 2)
+	require.Len(t, changes[0], 1)
+	flushed := changes[0][0]
+	require.True(t, flushed.Added)
+	require.Equal(t, 0, flushed.Level)
+	// The table also holds the value log head.
+	require.Equal(t, badgerPrefix, flushed.Smallest[:len(badgerPrefix)])
+	require.Equal(t, "c", string(flushed.Biggest))
+	require.Equal(t, first, flushed.MinVersion)
+	require.True(t, flushed.MaxVersion >= last)
+
+	var removed, added []TableEvent
+	for _, ev := range changes[1] {
+		if ev.Added {
+			added = append(added, ev)
+		} else {
+			removed = append(removed, ev)
+		}
+	}
+	require.Equal(t, []TableEvent{{
+		ID:         flushed.ID,
+		Smallest:   flushed.Smallest,
+		Biggest:    flushed.Biggest,
+		MinVersion: flushed.MinVersion,
+		MaxVersion: flushed.MaxVersion,
+	}}, removed)
+	require.Len(t, added, 1)
+	require.Equal(t, 1, added[0].Level)
+	require.Equal(t, "c", string(added[0].Biggest))
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/pkg/errors"
+)
+
+// traceMagic is written at the start of every access trace file, followed by
+// traceVersion. Records follow without any further framing.
+var traceMagic = []byte("BdgT")
+
+const traceVersion = 1
+
+// TraceOp identifies the kind of operation stored in a TraceRecord.
+type TraceOp byte
+
+const (
+	// TraceGet is recorded for every Txn.Get.
+	TraceGet TraceOp = iota + 1
+	// TraceSet is recorded for every Txn.Set and Txn.SetEntry.
+	TraceSet
+	// TraceDelete is recorded for every Txn.Delete.
+	TraceDelete
+	// TraceCommit is recorded for every transaction commit that had writes.
+	TraceCommit
+)
+
+func (op TraceOp) String() string {
+	switch op {
+	case TraceGet:
+		return "get"
+	case TraceSet:
+		return "set"
+	case TraceDelete:
+		return "delete"
+	case TraceCommit:
+		return "commit"
+	default:
+		return "unknown"
+	}
+}
+
+// TraceRecord is a single anonymized operation in an access trace. Keys and values are
+// never stored, only the hash of the key and the sizes involved.
+type TraceRecord struct {
+	Op TraceOp
+	// KeyHash is a hash of the user key. It is zero for commits.
+	KeyHash uint64
+	// KeySize is the length of the user key. For commits it holds the number of writes.
+	KeySize uint32
+	// ValueSize is the length of the value read or written. For commits it holds the
+	// estimated size of the transaction.
+	ValueSize uint32
+	// Offset is the time elapsed between opening the trace and the start of the operation.
+	Offset time.Duration
+	// Latency is the time the operation took.
+	Latency time.Duration
+}
+
+// encode appends the varint encoded record to buf.
+func (r *TraceRecord) encode(buf []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, byte(r.Op))
+	binary.BigEndian.PutUint64(tmp[:8], r.KeyHash)
+	buf = append(buf, tmp[:8]...)
+	for _, v := range []uint64{uint64(r.KeySize), uint64(r.ValueSize),
+		uint64(r.Offset / time.Microsecond), uint64(r.Latency)} {
+		n := binary.PutUvarint(tmp[:], v)
+		buf = append(buf, tmp[:n]...)
+	}
+	return buf
+}
+
+// accessRecorder appends TraceRecords to a trace file. It is safe for concurrent use.
+type accessRecorder struct {
+	sync.Mutex
+	fd    *os.File
+	w     *bufio.Writer
+	start time.Time
+	buf   []byte
+	err   error
+}
+
+func openAccessRecorder(path string) (*accessRecorder, error) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while opening access trace file: %s", path)
+	}
+	r := &accessRecorder{
+		fd:    fd,
+		w:     bufio.NewWriterSize(fd, 64<<10),
+		start: time.Now(),
+	}
+	hdr := make([]byte, 0, len(traceMagic)+1)
+	hdr = append(append(hdr, traceMagic...), traceVersion)
+	if _, err := r.w.Write(hdr); err != nil {
+		_ = fd.Close()
+		return nil, errors.Wrapf(err, "while writing access trace header: %s", path)
+	}
+	return r, nil
+}
+
+// record logs a single operation which started at the given time. It is a no-op on a nil
+// recorder, so callers don't need to check whether recording is enabled.
+func (r *accessRecorder) record(op TraceOp, key []byte, keySize, valSize int, start time.Time) {
+	if r == nil {
+		return
+	}
+	rec := TraceRecord{
+		Op:        op,
+		KeySize:   uint32(keySize),
+		ValueSize: uint32(valSize),
+		Latency:   time.Since(start),
+	}
+	if key != nil {
+		rec.KeyHash = z.MemHash(key)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil {
+		return
+	}
+	rec.Offset = start.Sub(r.start)
+	r.buf = rec.encode(r.buf[:0])
+	_, r.err = r.w.Write(r.buf)
+}
+
+func (r *accessRecorder) close() error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.fd.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// TraceReader reads access traces written by Badger when Options.AccessTracePath is set.
+type TraceReader struct {
+	r *bufio.Reader
+}
+
+// NewTraceReader returns a TraceReader reading from r. It returns an error if r doesn't start
+// with a valid trace header.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, errors.Wrap(err, "while reading trace header")
+	}
+	if !bytes.Equal(hdr[:len(traceMagic)], traceMagic) {
+		return nil, errors.New("invalid access trace: bad magic")
+	}
+	if hdr[len(traceMagic)] != traceVersion {
+		return nil, errors.Errorf("unsupported access trace version: %d", hdr[len(traceMagic)])
+	}
+	return &TraceReader{r: br}, nil
+}
+
+// Next returns the next record in the trace. It returns io.EOF once all the records have
+// been read.
+func (tr *TraceReader) Next() (TraceRecord, error) {
+	var rec TraceRecord
+	op, err := tr.r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.Op = TraceOp(op)
+
+	var hash [8]byte
+	if _, err := io.ReadFull(tr.r, hash[:]); err != nil {
+		return rec, errors.Wrap(unexpectedEOF(err), "while reading trace record")
+	}
+	rec.KeyHash = binary.BigEndian.Uint64(hash[:])
+
+	var vals [4]uint64
+	for i := range vals {
+		if vals[i], err = binary.ReadUvarint(tr.r); err != nil {
+			return rec, errors.Wrap(unexpectedEOF(err), "while reading trace record")
+		}
+	}
+	rec.KeySize = uint32(vals[0])
+	rec.ValueSize = uint32(vals[1])
+	rec.Offset = time.Duration(vals[2]) * time.Microsecond
+	rec.Latency = time.Duration(vals[3])
+	return rec, nil
+}
+
+// unexpectedEOF turns an io.EOF found in the middle of a record into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	traceDir, err := ioutil.TempDir("", "badger-trace")
+	require.NoError(t, err)
+	defer removeDir(traceDir)
+	tracePath := filepath.Join(traceDir, "access.trace")
+
+	db, err := Open(getTestOptions(dir).WithAccessTracePath(tracePath))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		if err := txn.Set([]byte("key1"), []byte("value1")); err != nil {
+			return err
+		}
+		return txn.Delete([]byte("key22"))
+	}))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("key1"))
+		return err
+	}))
+	require.NoError(t, db.Close())
+
+	f, err := os.Open(tracePath)
+	require.NoError(t, err)
+	defer f.Close()
+	tr, err := NewTraceReader(f)
+	require.NoError(t, err)
+
+	var recs []TraceRecord
+	for {
+		rec, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		recs = append(recs, rec)
+	}
+	require.Len(t, recs, 4)
+
+	require.Equal(t, TraceSet, recs[0].Op)
+	require.Equal(t, z.MemHash([]byte("key1")), recs[0].KeyHash)
+	require.Equal(t, uint32(4), recs[0].KeySize)
+	require.Equal(t, uint32(6), recs[0].ValueSize)
+
+	require.Equal(t, TraceDelete, recs[1].Op)
+	require.Equal(t, z.MemHash([]byte("key22")), recs[1].KeyHash)
+	require.Equal(t, uint32(5), recs[1].KeySize)
+
+	require.Equal(t, TraceCommit, recs[2].Op)
+	require.Equal(t, uint32(2), recs[2].KeySize)
+
+	require.Equal(t, TraceGet, recs[3].Op)
+	require.Equal(t, recs[0].KeyHash, recs[3].KeyHash)
+	require.Equal(t, uint32(6), recs[3].ValueSize)
+	require.True(t, recs[3].Offset >= recs[2].Offset)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ValueTransformer transforms the values of the keys under a prefix on their way to the DB and
+// back, e.g. to encrypt them with keys of the application, or to compress them in a way suited to
+// their domain. Both methods must be safe for concurrent use, and must not modify val.
+type ValueTransformer interface {
+	// Encode returns the value to store in place of val.
+	Encode(val []byte) ([]byte, error)
+	// Decode returns the value Encode was called with to get the stored val.
+	Decode(val []byte) ([]byte, error)
+}
+
+// PrefixTransformer registers a ValueTransformer for the values of the keys under Prefix. An empty
+// Prefix applies to every key.
+type PrefixTransformer struct {
+	Prefix      []byte
+	Transformer ValueTransformer
+}
+
+// valueTransformers holds the transformers of a DB, sorted by decreasing prefix length so that the
+// longest prefix of a key wins.
+type valueTransformers []PrefixTransformer
+
+func newValueTransformers(pts []PrefixTransformer) valueTransformers {
+	if len(pts) == 0 {
+		return nil
+	}
+	vt := make(valueTransformers, len(pts))
+	copy(vt, pts)
+	sort.SliceStable(vt, func(i, j int) bool {
+		return len(vt[i].Prefix) > len(vt[j].Prefix)
+	})
+	return vt
+}
+
+// index returns the index of the transformer of key, without timestamp, or -1 if it has none. The
+// internal keys of Badger are never transformed.
+func (vt valueTransformers) index(key []byte) int {
+	if len(vt) == 0 || bytes.HasPrefix(key, badgerPrefix) {
+		return -1
+	}
+	for i, pt := range vt {
+		if bytes.HasPrefix(key, pt.Prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup returns the transformer of key, without timestamp, or nil if it has none.
+func (vt valueTransformers) lookup(key []byte) ValueTransformer {
+	if i := vt.index(key); i >= 0 {
+		return vt[i].Transformer
+	}
+	return nil
+}
+
+// encode replaces the value of e by its encoded form, if key, the key of e without timestamp, has
+// a transformer. Deletions, empty values and the values shared by renamed keys, which are stored
+// already, are left as they are.
+func (vt valueTransformers) encode(key []byte, e *Entry) error {
+	if len(e.Value) == 0 || e.meta&(bitDelete|bitValuePointer) > 0 {
+		return nil
+	}
+	t := vt.lookup(key)
+	if t == nil {
+		return nil
+	}
+	val, err := t.Encode(e.Value)
+	if err != nil {
+		return errors.Wrapf(err, "while encoding the value of key %q", key)
+	}
+	e.Value = val
+	return nil
+}
+
+// decode returns the value that was encoded into the stored val of key, without timestamp.
+func (vt valueTransformers) decode(key, val []byte) ([]byte, error) {
+	if len(val) == 0 {
+		return val, nil
+	}
+	t := vt.lookup(key)
+	if t == nil {
+		return val, nil
+	}
+	val, err := t.Decode(val)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while decoding the value of key %q", key)
+	}
+	return val, nil
+}
+
+// encodeValues encodes the pending writes of txn for storage, right before they're committed, see
+// DB.encodeEntry. They're kept as they are until then, so that txn reads them back as they were
+// set.
+func (txn *Txn) encodeValues() error {
+	db := txn.db
+	if len(db.transformers) == 0 && db.hasher == nil {
+		return nil
+	}
+	for _, e := range txn.pendingWrites {
+		key, err := db.encodeEntry(e.Key, e)
+		if err != nil {
+			return err
+		}
+		e.Key = key
+	}
+	return nil
+}
+
+// yieldValue is like yieldItemValue, but returns the value decoded by DB.decodeValue. The values
+// written by the transaction of item, which aren't committed, aren't encoded yet, except those
+// shared by renamed keys.
+func (item *Item) yieldValue() ([]byte, func(), error) {
+	val, cb, err := item.yieldItemValue()
+	if err != nil || (len(item.db.transformers) == 0 && item.db.hasher == nil) ||
+		(item.pending && item.meta&bitValuePointer == 0) {
+		return val, cb, err
+	}
+	// The decoded value may refer to the stored one, so cb is left to the caller.
+	_, val, err = item.db.decodeValue(item.key, val)
+	return val, cb, err
+}
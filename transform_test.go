@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// tagTransformer stores the values with a tag in front of them, and fails to encode "bad".
+type tagTransformer string
+
+func (tt tagTransformer) Encode(val []byte) ([]byte, error) {
+	if string(val) == "bad" {
+		return nil, errors.New("bad value")
+	}
+	return append([]byte(tt), val...), nil
+}
+
+func (tt tagTransformer) Decode(val []byte) ([]byte, error) {
+	if !bytes.HasPrefix(val, []byte(tt)) {
+		return nil, errors.Errorf("missing tag %q", string(tt))
+	}
+	return val[len(tt):], nil
+}
+
+func getTransformerTestOptions() Options {
+	return getTestOptions("").WithValueTransformers([]PrefixTransformer{
+		{Prefix: []byte("a"), Transformer: tagTransformer("<a>")},
+		{Prefix: []byte("ab"), Transformer: tagTransformer("<ab>")},
+	})
+}
+
+func TestValueTransformers(t *testing.T) {
+	opt := getTransformerTestOptions()
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		big := bytes.Repeat([]byte("v"), db.opt.ValueThreshold+10)
+		txnSet(t, db, []byte("a1"), []byte("v1"), 0)
+		txnSet(t, db, []byte("ab1"), big, 0)
+		txnSet(t, db, []byte("b1"), []byte("v3"), 0)
+
+		// The values are stored encoded, by the transformer of the longest prefix.
+		stored := func(key string) []byte {
+			vs, err := db.get(y.KeyWithTs([]byte(key), math.MaxUint64))
+			require.NoError(t, err)
+			item := &Item{db: db, key: []byte(key), meta: vs.Meta, vptr: vs.Value}
+			val, cb, err := item.yieldItemValue()
+			defer runCallback(cb)
+			require.NoError(t, err)
+			return y.SafeCopy(nil, val)
+		}
+		require.Equal(t, []byte("<a>v1"), stored("a1"))
+		require.Equal(t, append([]byte("<ab>"), big...), stored("ab1"))
+		require.Equal(t, []byte("v3"), stored("b1"))
+
+		expected := map[string][]byte{"a1": []byte("v1"), "ab1": big, "b1": []byte("v3")}
+		check := func(txn *Txn, expected map[string][]byte) {
+			for key, val := range expected {
+				item, err := txn.Get([]byte(key))
+				require.NoError(t, err)
+				require.Equal(t, val, getItemValue(t, item), "key %s", key)
+			}
+			for _, prefetch := range []bool{false, true} {
+				iopt := DefaultIteratorOptions
+				iopt.PrefetchValues = prefetch
+				it := txn.NewIterator(iopt)
+				n := 0
+				for it.Rewind(); it.Valid(); it.Next() {
+					key := string(it.Item().Key())
+					val, err := it.Item().ValueCopy(nil)
+					require.NoError(t, err)
+					require.Equal(t, expected[key], val, "key %s", key)
+					n++
+				}
+				it.Close()
+				require.Equal(t, len(expected), n)
+			}
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			check(txn, expected)
+			return nil
+		}))
+
+		// The pending writes of a transaction are read as they were set, including the keys
+		// renamed, whose values get shared only if they're stored in the same form for both keys.
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte("a2"), []byte("v4")))
+		require.NoError(t, txn.Rename([]byte("ab1"), []byte("ab2")))
+		require.NoError(t, txn.Rename([]byte("a1"), []byte("b2")))
+		pending := map[string][]byte{"a2": []byte("v4"), "ab2": big, "b1": []byte("v3"),
+			"b2": []byte("v1")}
+		check(txn, pending)
+		require.NoError(t, txn.Commit())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			check(txn, pending)
+			return nil
+		}))
+		require.Equal(t, []byte("v1"), stored("b2"))
+
+		// A failing transformer fails the commit.
+		err := db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("a3"), []byte("bad"))
+		})
+		require.Error(t, err)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("a3"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
+
+func TestValueTransformersBackup(t *testing.T) {
+	opt := getTransformerTestOptions()
+	var buf bytes.Buffer
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("a1"), []byte("v1"), 0)
+		txnSet(t, db, []byte("b1"), []byte("v2"), 0)
+		_, err := db.Backup(&buf, 0)
+		require.NoError(t, err)
+	})
+
+	// Backups hold the decoded values, which get encoded again when loaded.
+	opt = getTransformerTestOptions()
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Load(&buf, 16))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("a1"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("v1"), getItemValue(t, item))
+			return nil
+		}))
+		vs, err := db.get(y.KeyWithTs([]byte("a1"), math.MaxUint64))
+		require.NoError(t, err)
+		require.Equal(t, []byte("<a>v1"), vs.Value)
+	})
+}
+
+func TestValueTransformersSubscribe(t *testing.T) {
+	opt := getTransformerTestOptions()
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var mu sync.Mutex
+		var values []string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := db.Subscribe(ctx, func(kvs *pb.KVList) error {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, kv := range kvs.GetKv() {
+					values = append(values, string(kv.Value))
+				}
+				return nil
+			}, []byte("a"))
+			require.Equal(t, context.Canceled, errors.Cause(err))
+		}()
+		waitFor(t, func() bool { return db.pub.noOfSubscribers() == 1 })
+
+		txnSet(t, db, []byte("a1"), []byte("v1"), 0)
+		txnSet(t, db, []byte("ab1"), []byte("v2"), 0)
+		waitFor(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(values) == 2
+		})
+		cancel()
+		<-done
+		require.Equal(t, []string{"v1", "v2"}, values)
+	})
+}
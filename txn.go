@@ -25,6 +25,7 @@ import (
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/dgraph-io/ristretto/z"
@@ -151,10 +152,16 @@ func (o *oracle) discardAtOrBelow() uint64 {
 
 // hasConflict must be called while having a lock.
 func (o *oracle) hasConflict(txn *Txn) bool {
-	if len(txn.reads) == 0 {
+	for _, c := range txn.conditions {
+		if ts, has := o.commits[c.fp]; has && ts > txn.readTs {
+			return true
+		}
+	}
+	keys := txn.conflictKeys()
+	if len(keys) == 0 {
 		return false
 	}
-	for _, ro := range txn.reads {
+	for _, ro := range keys {
 		// A commit at the read timestamp is expected.
 		// But, any commit after the read timestamp should cause a conflict.
 		if ts, has := o.commits[ro]; has && ts > txn.readTs {
@@ -202,8 +209,13 @@ func (o *oracle) doneCommit(cts uint64) {
 type Txn struct {
 	readTs   uint64
 	commitTs uint64
+	// markTs is the read timestamp registered with the oracle in normal mode. It's only different
+	// from readTs for transactions reading a tagged snapshot.
+	markTs uint64
 
 	update bool     // update is used to conditionally keep track of reads.
+	batch  bool     // Set for the transactions of write batches, which don't detect conflicts.
+	dedupe bool     // Set if writes replacing pending ones don't count towards the txn limits.
 	reads  []uint64 // contains fingerprints of keys read.
 	writes []uint64 // contains fingerprints of keys written.
 
@@ -215,6 +227,26 @@ type Txn struct {
 	size         int64
 	count        int64
 	numIterators int32
+
+	// pinnedFids are the value log files holding values shared by the keys renamed by the txn.
+	pinnedFids []uint32
+	// newKeys are the fingerprints of the keys claimed by the txn in an append-only DB, see
+	// DB.claimNewKey.
+	newKeys []uint64
+	// conditions are the conditions of the conditional writes of the txn.
+	conditions []writeCondition
+	// internal is set for the transactions of Badger itself, which may write internal keys.
+	internal bool
+	// savepoints are the savepoints of the txn, see Txn.Savepoint, and undo records the changes to
+	// the pending writes made since the first one.
+	savepoints []*Savepoint
+	undo       []undoEntry
+	// lockedKeys are the fingerprints of the keys locked by the txn, see Txn.Lock.
+	lockedKeys []uint64
+	// readKeys are the keys read by the txn by fingerprint, with Options.ConflictDiagnostics.
+	readKeys map[uint64][]byte
+	// isolation is the isolation level of the txn, see Txn.SetIsolation.
+	isolation Isolation
 }
 
 type pendingWritesIterator struct {
@@ -222,6 +254,7 @@ type pendingWritesIterator struct {
 	nextIdx  int
 	readTs   uint64
 	reversed bool
+	opt      *Options
 }
 
 func (pi *pendingWritesIterator) Next() {
@@ -235,7 +268,7 @@ func (pi *pendingWritesIterator) Rewind() {
 func (pi *pendingWritesIterator) Seek(key []byte) {
 	key = y.ParseKey(key)
 	pi.nextIdx = sort.Search(len(pi.entries), func(idx int) bool {
-		cmp := bytes.Compare(pi.entries[idx].Key, key)
+		cmp := pi.opt.compareKeys(pi.entries[idx].Key, key)
 		if !pi.reversed {
 			return cmp >= 0
 		}
@@ -275,11 +308,17 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 	}
 	entries := make([]*Entry, 0, len(txn.pendingWrites))
 	for _, e := range txn.pendingWrites {
+		if txn.db.hasher != nil {
+			// Merge the writes with the committed versions, stored under hashed keys.
+			hashed := *e
+			hashed.Key, hashed.origKey = txn.db.hasher.hash(e.Key), e.Key
+			e = &hashed
+		}
 		entries = append(entries, e)
 	}
 	// Number of pending writes per transaction shouldn't be too big in general.
 	sort.Slice(entries, func(i, j int) bool {
-		cmp := bytes.Compare(entries[i].Key, entries[j].Key)
+		cmp := txn.db.opt.compareKeys(entries[i].Key, entries[j].Key)
 		if !reversed {
 			return cmp < 0
 		}
@@ -289,6 +328,7 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 		readTs:   txn.readTs,
 		entries:  entries,
 		reversed: reversed,
+		opt:      &txn.db.opt,
 	}
 }
 
@@ -296,6 +336,11 @@ func (txn *Txn) checkSize(e *Entry) error {
 	count := txn.count + 1
 	// Extra bytes for the version in key.
 	size := txn.size + int64(e.estimateSize(txn.db.opt.ValueThreshold)) + 10
+	if old, ok := txn.pendingWrites[string(e.Key)]; ok && txn.dedupe {
+		// The entry replaces the pending one, which won't be written.
+		count--
+		size -= int64(old.estimateSize(txn.db.opt.ValueThreshold)) + 10
+	}
 	if count >= txn.db.opt.maxBatchCount || size >= txn.db.opt.maxBatchSize {
 		return ErrTxnTooBig
 	}
@@ -309,22 +354,32 @@ func exceedsSize(prefix string, max int64, key []byte) error {
 }
 
 func (txn *Txn) modify(e *Entry) error {
-	const maxKeySize = 65000
-
 	switch {
 	case !txn.update:
 		return ErrReadOnlyTxn
 	case txn.discarded:
 		return ErrDiscardedTxn
+	}
+	if err := txn.checkEntry(e); err != nil {
+		return err
+	}
+	if !txn.internal {
+		txn.db.jitterTTL(e)
+	}
+	txn.addPendingWrite(e)
+	return nil
+}
+
+// checkEntry checks that e can be written by txn, and accounts for it in the size of txn.
+func (txn *Txn) checkEntry(e *Entry) error {
+	switch {
 	case len(e.Key) == 0:
 		return ErrEmptyKey
-	case bytes.HasPrefix(e.Key, badgerPrefix):
-		return ErrInvalidKey
-	case len(e.Key) > maxKeySize:
-		// Key length can't be more than uint16, as determined by table::header.  To
-		// keep things safe and allow badger move prefix and a timestamp suffix, let's
-		// cut it down to 65000, instead of using 65536.
-		return exceedsSize("Key", maxKeySize, e.Key)
+	case !txn.internal && bytes.HasPrefix(e.Key, badgerPrefix):
+		return checkReservedKey(e.Key)
+	case len(e.Key) > txn.db.opt.MaxKeySize:
+		// See defaultMaxKeySize for the default limit.
+		return exceedsSize("Key", int64(txn.db.opt.MaxKeySize), e.Key)
 	case int64(len(e.Value)) > txn.db.opt.ValueLogFileSize:
 		return exceedsSize("Value", txn.db.opt.ValueLogFileSize, e.Value)
 	}
@@ -332,12 +387,29 @@ func (txn *Txn) modify(e *Entry) error {
 	if err := txn.checkSize(e); err != nil {
 		return err
 	}
-	fp := z.MemHash(e.Key) // Avoid dealing with byte arrays.
-	txn.writes = append(txn.writes, fp)
-	txn.pendingWrites[string(e.Key)] = e
+	if txn.db.opt.AppendOnly {
+		if err := txn.checkAppendOnly(e); err != nil {
+			return err
+		}
+	}
+	// Deletions are let in, so that space can be reclaimed.
+	if !txn.internal && e.meta&bitDelete == 0 {
+		if err := txn.db.checkDatabaseSize(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// addPendingWrite adds e to the writes of txn, replacing any pending write of the same key.
+func (txn *Txn) addPendingWrite(e *Entry) {
+	if _, ok := txn.pendingWrites[string(e.Key)]; !ok || !txn.dedupe {
+		fp := z.MemHash(e.Key) // Avoid dealing with byte arrays.
+		txn.writes = append(txn.writes, fp)
+	}
+	txn.setPendingWrite(string(e.Key), e)
+}
+
 // Set adds a key-value pair to the database.
 // It will return ErrReadOnlyTxn if update flag was set to false when creating the transaction.
 //
@@ -353,7 +425,15 @@ func (txn *Txn) Set(key, val []byte) error {
 // The current transaction keeps a reference to the entry passed in argument.
 // Users must not modify the entry until the end of the transaction.
 func (txn *Txn) SetEntry(e *Entry) error {
-	return txn.modify(e)
+	if txn.db.recorder == nil {
+		return txn.modify(e)
+	}
+	start := time.Now()
+	err := txn.modify(e)
+	if err == nil {
+		txn.db.recorder.record(TraceSet, e.Key, len(e.Key), len(e.Value), start)
+	}
+	return err
 }
 
 // Delete deletes a key.
@@ -369,19 +449,42 @@ func (txn *Txn) Delete(key []byte) error {
 		Key:  key,
 		meta: bitDelete,
 	}
-	return txn.modify(e)
+	if txn.db.recorder == nil {
+		return txn.modify(e)
+	}
+	start := time.Now()
+	err := txn.modify(e)
+	if err == nil {
+		txn.db.recorder.record(TraceDelete, key, len(key), 0, start)
+	}
+	return err
 }
 
 // Get looks for key and returns corresponding Item.
 // If key is not found, ErrKeyNotFound is returned.
 func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
+	if txn.db.recorder != nil {
+		start := time.Now()
+		defer func() {
+			var sz int
+			if item != nil {
+				sz = int(item.ValueSize())
+			}
+			txn.db.recorder.record(TraceGet, key, len(key), sz, start)
+		}()
+	}
+	return txn.get(key, txn.db.get)
+}
+
+// get looks for key like Get, looking up the LSM tree with lookup.
+func (txn *Txn) get(key []byte, lookup func(key []byte) (y.ValueStruct, error)) (*Item, error) {
 	if len(key) == 0 {
 		return nil, ErrEmptyKey
 	} else if txn.discarded {
 		return nil, ErrDiscardedTxn
 	}
 
-	item = new(Item)
+	item := new(Item)
 	if txn.update {
 		if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
 			if isDeletedOrExpired(e.meta, e.ExpiresAt) {
@@ -389,12 +492,22 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 			}
 			// Fulfill from cache.
 			item.meta = e.meta
-			item.val = e.Value
 			item.userMeta = e.UserMeta
 			item.key = key
-			item.status = prefetched
+			if txn.db.hasher != nil {
+				item.key, item.origKey = txn.db.hasher.hash(key), key
+			}
 			item.version = txn.readTs
 			item.expiresAt = e.ExpiresAt
+			item.pending = true
+			if e.meta&bitValuePointer > 0 {
+				// Written by Rename, the value is in the value log.
+				item.vptr = e.Value
+				item.db, item.txn = txn.db, txn
+				return item, nil
+			}
+			item.val = e.Value
+			item.status = prefetched
 			// We probably don't need to set db on item here.
 			return item, nil
 		}
@@ -402,20 +515,25 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 		// internally.
 		txn.addReadKey(key)
 	}
+	skey := txn.db.storedKey(key)
+	if txn.db.negCache.absent(skey, txn.readTs) {
+		return nil, ErrKeyNotFound
+	}
 
-	seek := y.KeyWithTs(key, txn.readTs)
-	vs, err := txn.db.get(seek)
+	seek := y.KeyWithTs(skey, txn.readTs)
+	vs, err := lookup(seek)
 	if err != nil {
 		return nil, errors.Wrapf(err, "DB::Get key: %q", key)
 	}
-	if vs.Value == nil && vs.Meta == 0 {
-		return nil, ErrKeyNotFound
-	}
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	if (vs.Value == nil && vs.Meta == 0) || isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+		txn.db.recordMiss(skey)
 		return nil, ErrKeyNotFound
 	}
 
-	item.key = key
+	item.key = skey
+	if txn.db.hasher != nil {
+		item.origKey = key
+	}
 	item.version = vs.Version
 	item.meta = vs.Meta
 	item.userMeta = vs.UserMeta
@@ -427,9 +545,10 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 }
 
 func (txn *Txn) addReadKey(key []byte) {
-	if txn.update {
+	if txn.update && txn.isolation != SnapshotIsolation {
 		fp := z.MemHash(key)
 		txn.reads = append(txn.reads, fp)
+		txn.recordReadKey(fp, key)
 	}
 }
 
@@ -446,8 +565,14 @@ func (txn *Txn) Discard() {
 		panic("Unclosed iterator at time of Txn.Discard.")
 	}
 	txn.discarded = true
+	txn.db.keyLocks.unlock(txn.lockedKeys)
+	txn.lockedKeys = nil
+	txn.db.vlog.pins.unpin(txn.pinnedFids)
+	txn.pinnedFids = nil
+	txn.db.releaseNewKeys(txn.newKeys)
+	txn.newKeys = nil
 	if !txn.db.orc.isManaged {
-		txn.db.orc.readMark.Done(txn.readTs)
+		txn.db.orc.readMark.Done(txn.markTs)
 	}
 	if txn.update {
 		txn.db.orc.decrRef()
@@ -465,8 +590,10 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 
 	commitTs := orc.newCommitTs(txn)
 	if commitTs == 0 {
-		return nil, ErrConflict
+		return nil, orc.conflictErr(txn)
 	}
+	// Record the commit timestamp of the txn in normal mode too, for AutoTxn.
+	txn.commitTs = commitTs
 
 	// The following debug information is what led to determining the cause of
 	// bank txn violation bug, and it took a whole bunch of effort to narrow it
@@ -497,12 +624,23 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 		orc.doneCommit(commitTs)
 		return nil, err
 	}
+	// The renamed keys point to their values once written.
+	pinnedFids := txn.pinnedFids
+	txn.pinnedFids = nil
+	// The new keys are claimed until they're in the memtable.
+	newKeys := txn.newKeys
+	txn.newKeys = nil
 	ret := func() error {
 		err := req.Wait()
 		// Wait before marking commitTs as done.
 		// We can't defer doneCommit above, because it is being called from a
 		// callback here.
 		orc.doneCommit(commitTs)
+		txn.db.vlog.pins.unpin(pinnedFids)
+		txn.db.releaseNewKeys(newKeys)
+		if err == nil && txn.db.opt.PostCommitHook != nil {
+			txn.db.opt.PostCommitHook(commitTs)
+		}
 		return err
 	}
 	return ret, nil
@@ -543,6 +681,16 @@ func (txn *Txn) Commit() error {
 		return nil // Nothing to do.
 	}
 
+	start := time.Now()
+	if err := txn.runPreCommitHook(); err != nil {
+		return err
+	}
+	if err := txn.checkPrefixLimits(); err != nil {
+		return err
+	}
+	if err := txn.encodeValues(); err != nil {
+		return err
+	}
 	txnCb, err := txn.commitAndSend()
 	if err != nil {
 		return err
@@ -551,7 +699,9 @@ func (txn *Txn) Commit() error {
 
 	// TODO: What if some of the txns successfully make it to value log, but others fail.
 	// Nothing gets updated to LSM, until a restart happens.
-	return txnCb()
+	err = txnCb()
+	txn.db.recorder.record(TraceCommit, nil, len(txn.writes), int(txn.size), start)
+	return err
 }
 
 type txnCb struct {
@@ -596,15 +746,72 @@ func (txn *Txn) CommitWith(cb func(error)) {
 		return
 	}
 
+	start := time.Now()
+	if err := txn.runPreCommitHook(); err != nil {
+		go runTxnCallback(&txnCb{user: cb, err: err})
+		return
+	}
+	if err := txn.checkPrefixLimits(); err != nil {
+		go runTxnCallback(&txnCb{user: cb, err: err})
+		return
+	}
+	if err := txn.encodeValues(); err != nil {
+		go runTxnCallback(&txnCb{user: cb, err: err})
+		return
+	}
 	commitCb, err := txn.commitAndSend()
 	if err != nil {
 		go runTxnCallback(&txnCb{user: cb, err: err})
 		return
 	}
 
+	if rec := txn.db.recorder; rec != nil {
+		numWrites, size := len(txn.writes), int(txn.size)
+		userCb := cb
+		cb = func(err error) {
+			rec.record(TraceCommit, nil, numWrites, size, start)
+			userCb(err)
+		}
+	}
 	go runTxnCallback(&txnCb{user: cb, commit: commitCb})
 }
 
+// Durability is the point at which Txn.CommitWithDurability acknowledges a commit.
+type Durability int
+
+const (
+	// DurabilityApplied acknowledges the commits once written to the value log and applied to the
+	// memtable, which is when CommitWith runs its callback. The value log is only synced to disk
+	// with Options.SyncWrites, so the latest commits may be lost on a crash otherwise.
+	DurabilityApplied Durability = iota
+	// DurabilitySynced acknowledges the commits once the value log got synced to disk too, even
+	// without Options.SyncWrites.
+	DurabilitySynced
+)
+
+// CommitWithDurability acts like CommitWith, but its callback also gets the commit timestamp of
+// txn, 0 if the commit failed or txn didn't write anything, and runs once the commit is as
+// durable as d tells. Writers can pipeline their commits with DurabilityApplied, syncing the value
+// log every now and then with DB.Sync to bound what a crash can lose, and commit the writes which
+// mustn't be lost with DurabilitySynced.
+func (txn *Txn) CommitWithDurability(d Durability, cb func(commitTs uint64, err error)) {
+	if cb == nil {
+		panic("Nil callback provided to CommitWithDurability")
+	}
+	db := txn.db
+	txn.CommitWith(func(err error) {
+		if err == nil && len(txn.writes) > 0 && d == DurabilitySynced &&
+			!db.opt.SyncWrites && !db.opt.InMemory {
+			err = db.Sync()
+		}
+		if err != nil || len(txn.writes) == 0 {
+			cb(0, err)
+			return
+		}
+		cb(txn.commitTs, nil)
+	})
+}
+
 // ReadTs returns the read timestamp of the transaction.
 func (txn *Txn) ReadTs() uint64 {
 	return txn.readTs
@@ -664,6 +871,7 @@ func (db *DB) newTransaction(update, isManaged bool) *Txn {
 	// See issue: https://github.com/dgraph-io/badger/issues/574
 	if !isManaged {
 		txn.readTs = db.orc.readTs()
+		txn.markTs = txn.readTs
 	}
 	return txn
 }
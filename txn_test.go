@@ -161,6 +161,52 @@ func TestTxnCommitAsync(t *testing.T) {
 	})
 }
 
+func TestTxnCommitWithDurability(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		commit := func(txn *Txn, d Durability) (uint64, error) {
+			type result struct {
+				ts  uint64
+				err error
+			}
+			ch := make(chan result, 1)
+			txn.CommitWithDurability(d, func(commitTs uint64, err error) {
+				ch <- result{commitTs, err}
+			})
+			r := <-ch
+			return r.ts, r.err
+		}
+
+		key := []byte("key")
+		for _, d := range []Durability{DurabilityApplied, DurabilitySynced} {
+			txn := db.NewTransaction(true)
+			require.NoError(t, txn.Set(key, []byte("val")))
+			ts, err := commit(txn, d)
+			require.NoError(t, err)
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				require.NoError(t, err)
+				require.Equal(t, item.Version(), ts)
+				return nil
+			}))
+		}
+
+		// Nothing gets committed without writes, or on conflicts.
+		ts, err := commit(db.NewTransaction(true), DurabilitySynced)
+		require.NoError(t, err)
+		require.Zero(t, ts)
+		txn := db.NewTransaction(true)
+		_, err = txn.Get(key)
+		require.NoError(t, err)
+		require.NoError(t, txn.Set(key, []byte("conflicting")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("other"))
+		}))
+		ts, err = commit(txn, DurabilitySynced)
+		require.Equal(t, ErrConflict, err)
+		require.Zero(t, ts)
+	})
+}
+
 func TestTxnVersions(t *testing.T) {
 	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
 		k := []byte("key")
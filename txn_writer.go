@@ -0,0 +1,214 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// TxnWriter batches writes at many commit timestamps in managed mode, like a WriteBatch per
+// timestamp. It keeps a transaction open for every timestamp written to, up to a limit, and
+// commits it once it's full, when too many timestamps are open, or on Flush. The transactions of
+// the lowest timestamps get committed first. Writing a key again at the same timestamp replaces
+// the write, as long as its transaction isn't committed yet, and a key written again after that
+// gets written once more, the last write winning.
+//
+// TxnWriter is safe for concurrent use.
+type TxnWriter struct {
+	sync.Mutex
+	db       *DB
+	txns     map[uint64]*Txn // txns holds the open transaction of every commit timestamp.
+	maxOpen  int
+	throttle *y.Throttle
+	err      error
+}
+
+// NewTxnWriter returns a TxnWriter. It's supposed to be used only in the managed mode.
+func (db *DB) NewTxnWriter() *TxnWriter {
+	if !db.opt.managedTxns {
+		panic("cannot use NewTxnWriter with managedDB=false. Use NewWriteBatch instead")
+	}
+	return &TxnWriter{
+		db:       db,
+		txns:     make(map[uint64]*Txn),
+		maxOpen:  16,
+		throttle: y.NewThrottle(16),
+	}
+}
+
+// SetMaxPendingTxns sets a limit on the number of transactions being committed, see
+// WriteBatch.SetMaxPendingTxns. This function should be called before using TxnWriter. The default
+// value of MaxPendingTxns is 16.
+func (tw *TxnWriter) SetMaxPendingTxns(max int) {
+	tw.throttle = y.NewThrottle(max)
+}
+
+// SetMaxOpenTimestamps sets a limit on the number of commit timestamps having a transaction open.
+// Writing at one more timestamp commits the transaction of the lowest one. This function should be
+// called before using TxnWriter. The default value of MaxOpenTimestamps is 16.
+func (tw *TxnWriter) SetMaxOpenTimestamps(max int) {
+	if max < 1 {
+		max = 1
+	}
+	tw.maxOpen = max
+}
+
+// SetEntryAt is the equivalent of Txn.SetEntry, at the commit timestamp commitTs.
+func (tw *TxnWriter) SetEntryAt(e *Entry, commitTs uint64) error {
+	return tw.write(commitTs, func(txn *Txn) error {
+		return txn.SetEntry(e)
+	})
+}
+
+// SetAt is the equivalent of Txn.Set, at the commit timestamp commitTs.
+func (tw *TxnWriter) SetAt(k, v []byte, commitTs uint64) error {
+	return tw.SetEntryAt(&Entry{Key: k, Value: v}, commitTs)
+}
+
+// DeleteAt is the equivalent of Txn.Delete, at the commit timestamp commitTs.
+func (tw *TxnWriter) DeleteAt(k []byte, commitTs uint64) error {
+	return tw.write(commitTs, func(txn *Txn) error {
+		return txn.Delete(k)
+	})
+}
+
+func (tw *TxnWriter) write(commitTs uint64, fn func(txn *Txn) error) error {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	txn, err := tw.txnAt(commitTs)
+	if err != nil {
+		return err
+	}
+	if err := fn(txn); err != ErrTxnTooBig {
+		return err
+	}
+	// The transaction is full, commit it and retry in a new one.
+	if err := tw.commit(commitTs); err != nil {
+		return err
+	}
+	if txn, err = tw.txnAt(commitTs); err != nil {
+		return err
+	}
+	// This time the error must not be ErrTxnTooBig, otherwise, we make the error permanent.
+	if err := fn(txn); err != nil {
+		tw.err = err
+		return err
+	}
+	return nil
+}
+
+// txnAt returns the open transaction of commitTs, opening one if needed. Caller must hold the lock.
+func (tw *TxnWriter) txnAt(commitTs uint64) (*Txn, error) {
+	if txn, ok := tw.txns[commitTs]; ok {
+		return txn, nil
+	}
+	if len(tw.txns) >= tw.maxOpen {
+		if err := tw.commit(tw.sortedTs()[0]); err != nil {
+			return nil, err
+		}
+	}
+	txn := tw.db.newTransaction(true, true)
+	txn.readTs = 0 // We're not reading anything.
+	txn.batch = true
+	txn.dedupe = true
+	txn.commitTs = commitTs
+	tw.txns[commitTs] = txn
+	return txn, nil
+}
+
+// sortedTs returns the timestamps having an open transaction, in increasing order. Caller must
+// hold the lock.
+func (tw *TxnWriter) sortedTs() []uint64 {
+	ts := make([]uint64, 0, len(tw.txns))
+	for commitTs := range tw.txns {
+		ts = append(ts, commitTs)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	return ts
+}
+
+// commit commits the open transaction of commitTs. Caller must hold the lock.
+func (tw *TxnWriter) commit(commitTs uint64) error {
+	txn := tw.txns[commitTs]
+	delete(tw.txns, commitTs)
+	if tw.err != nil {
+		txn.Discard()
+		return tw.err
+	}
+	if err := tw.throttle.Do(); err != nil {
+		txn.Discard()
+		return err
+	}
+	txn.CommitWith(tw.callback)
+	return tw.err
+}
+
+func (tw *TxnWriter) callback(err error) {
+	// Throttle is thread-safe, so it doesn't need to be run inside tw.Lock.
+	defer tw.throttle.Done(err)
+	if err == nil {
+		return
+	}
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err == nil {
+		tw.err = err
+	}
+}
+
+// Flush commits the open transactions, by increasing commit timestamp, and waits for them to be
+// committed. It must be called at the end to ensure that all the writes get committed. Flush
+// returns any error stored by TxnWriter.
+func (tw *TxnWriter) Flush() error {
+	tw.Lock()
+	for _, commitTs := range tw.sortedTs() {
+		_ = tw.commit(commitTs)
+	}
+	tw.Unlock()
+
+	if err := tw.throttle.Finish(); err != nil {
+		return err
+	}
+	return tw.Error()
+}
+
+// Cancel discards the open transactions, and waits for those being committed. It must be called if
+// there's a chance that Flush might not get called, see WriteBatch.Cancel.
+func (tw *TxnWriter) Cancel() {
+	tw.Lock()
+	for commitTs, txn := range tw.txns {
+		txn.Discard()
+		delete(tw.txns, commitTs)
+	}
+	tw.Unlock()
+	if err := tw.throttle.Finish(); err != nil {
+		tw.db.opt.Errorf("TxnWriter.Cancel error while finishing: %v", err)
+	}
+}
+
+// Error returns any errors encountered so far. No commits would be run once an error is detected.
+func (tw *TxnWriter) Error() error {
+	tw.Lock()
+	defer tw.Unlock()
+	return tw.err
+}
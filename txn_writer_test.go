@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnWriter(t *testing.T) {
+	opt := getTestOptions("")
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.Panics(t, func() {
+			(&DB{opt: DefaultOptions("")}).NewTxnWriter()
+		})
+
+		tw := db.NewTxnWriter()
+		defer tw.Cancel()
+		tw.SetMaxOpenTimestamps(3)
+		// More keys than fit in a transaction, at more timestamps than can be open at once, with
+		// every key written twice at every timestamp.
+		numKeys := int(db.opt.maxBatchCount) * 2
+		for pass := 0; pass < 2; pass++ {
+			for i := 0; i < numKeys; i++ {
+				for ts := uint64(1); ts <= 5; ts++ {
+					val := []byte(fmt.Sprintf("%d@%d", pass, ts))
+					require.NoError(t, tw.SetAt([]byte(fmt.Sprintf("key%d", i)), val, ts))
+				}
+			}
+		}
+		require.NoError(t, tw.DeleteAt([]byte("key0"), 6))
+		require.NoError(t, tw.Flush())
+
+		for ts := uint64(1); ts <= 6; ts++ {
+			txn := db.NewTransactionAt(ts, false)
+			for i := 0; i < numKeys; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+				if i == 0 && ts == 6 {
+					require.Equal(t, ErrKeyNotFound, err)
+					continue
+				}
+				require.NoError(t, err)
+				want := ts
+				if want > 5 {
+					want = 5
+				}
+				require.Equal(t, want, item.Version())
+				require.Equal(t, fmt.Sprintf("1@%d", want), string(getItemValue(t, item)))
+			}
+			txn.Discard()
+		}
+	})
+}
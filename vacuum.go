@@ -0,0 +1,167 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// VacuumStep is a step of DB.Vacuum.
+type VacuumStep int
+
+const (
+	// VacuumValueLogGC runs the value log GC.
+	VacuumValueLogGC VacuumStep = iota
+	// VacuumFlatten flattens the LSM tree.
+	VacuumFlatten
+	// VacuumManifest rewrites the MANIFEST.
+	VacuumManifest
+	// VacuumKeyRegistry compacts the key registry.
+	VacuumKeyRegistry
+)
+
+func (s VacuumStep) String() string {
+	switch s {
+	case VacuumValueLogGC:
+		return "value log GC"
+	case VacuumFlatten:
+		return "flatten"
+	case VacuumManifest:
+		return "manifest rewrite"
+	case VacuumKeyRegistry:
+		return "key registry compaction"
+	default:
+		return "unknown"
+	}
+}
+
+// VacuumOptions selects the steps of DB.Vacuum.
+type VacuumOptions struct {
+	// DiscardRatio is the ratio passed to DB.RunValueLogGC, which runs until no value log file
+	// can be rewritten at that ratio. Zero skips the value log GC.
+	DiscardRatio float64
+	// FlattenWorkers is the number of workers passed to DB.Flatten. Zero skips Flatten.
+	FlattenWorkers int
+	// MaxFlattenCompactions stops Flatten after as many compactions, leaving the tree partially
+	// flattened, so that Vacuum fits in a time budget. Zero flattens the tree entirely.
+	MaxFlattenCompactions int
+	// RewriteManifest rewrites the MANIFEST with the tables and value log files alive only.
+	RewriteManifest bool
+	// CompactKeyRegistry rewrites the key registry with a single record for every data key, see
+	// KeyRegistry.Compact.
+	CompactKeyRegistry bool
+	// Progress is called after every value log file rewritten, every compaction of Flatten and
+	// every step completed, if it isn't nil.
+	Progress func(VacuumProgress)
+}
+
+// DefaultVacuumOptions runs every step of DB.Vacuum.
+var DefaultVacuumOptions = VacuumOptions{
+	DiscardRatio:       0.5,
+	FlattenWorkers:     1,
+	RewriteManifest:    true,
+	CompactKeyRegistry: true,
+}
+
+// VacuumProgress reports the progress of DB.Vacuum.
+type VacuumProgress struct {
+	// Step is the step running, or completed if Done is set.
+	Step VacuumStep
+	Done bool
+	// ValueLogFilesRewritten and Compactions count the value log files rewritten by the value
+	// log GC and the compactions run by Flatten so far.
+	ValueLogFilesRewritten int
+	Compactions            int
+}
+
+// Vacuum reclaims the space of the DB in one call, running the steps selected by opt in order: the
+// value log GC, Flatten, the rewrite of the MANIFEST and the compaction of the key registry. The
+// steps skip what doesn't apply to the DB, e.g. the value log GC in the InMemory mode.
+//
+// Vacuum returns early with the error of ctx if it gets canceled, between value log files or
+// compactions. The work done up to that point is kept. It returns ErrFrozen while the DB is
+// frozen, before running any step.
+func (db *DB) Vacuum(ctx context.Context, opt VacuumOptions) error {
+	if db.opt.ReadOnly {
+		return errors.New("Vacuum cannot be called in read-only mode")
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	var p VacuumProgress
+	report := func(step VacuumStep, done bool) {
+		p.Step, p.Done = step, done
+		if opt.Progress != nil {
+			opt.Progress(p)
+		}
+	}
+
+	if opt.DiscardRatio > 0 && !db.opt.InMemory {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := db.RunValueLogGC(opt.DiscardRatio)
+			if err == ErrNoRewrite {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "During Vacuum")
+			}
+			p.ValueLogFilesRewritten++
+			report(VacuumValueLogGC, false)
+		}
+		report(VacuumValueLogGC, true)
+	}
+
+	if opt.FlattenWorkers > 0 {
+		err := db.flatten(ctx, opt.FlattenWorkers, opt.MaxFlattenCompactions, func() {
+			p.Compactions++
+			report(VacuumFlatten, false)
+		})
+		if err == ctx.Err() && err != nil {
+			return err
+		} else if err != nil {
+			return errors.Wrap(err, "During Vacuum")
+		}
+		report(VacuumFlatten, true)
+	}
+
+	if opt.RewriteManifest {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := db.manifest.rekey(func(uint64) bool { return true }); err != nil {
+			return errors.Wrap(err, "While rewriting MANIFEST during Vacuum")
+		}
+		report(VacuumManifest, true)
+	}
+
+	if opt.CompactKeyRegistry {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := db.registry.Compact(); err != nil {
+			return errors.Wrap(err, "While compacting key registry during Vacuum")
+		}
+		report(VacuumKeyRegistry, true)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVacuum(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueLogFileSize = 1 << 20
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 32<<10)
+		for i := 0; i < 100; i++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), val, 0)
+		}
+		for i := 0; i < 50; i++ {
+			txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+		}
+
+		var done []VacuumStep
+		var last VacuumProgress
+		vopt := DefaultVacuumOptions
+		vopt.Progress = func(p VacuumProgress) {
+			require.True(t, p.ValueLogFilesRewritten >= last.ValueLogFilesRewritten)
+			require.True(t, p.Compactions >= last.Compactions)
+			if p.Done {
+				done = append(done, p.Step)
+			}
+			last = p
+		}
+		require.NoError(t, db.Vacuum(context.Background(), vopt))
+		require.Equal(t, []VacuumStep{VacuumValueLogGC, VacuumFlatten, VacuumManifest,
+			VacuumKeyRegistry}, done)
+		require.Equal(t, 0, db.manifest.manifest.Deletions)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				_, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+				if i < 50 {
+					require.Equal(t, ErrKeyNotFound, err)
+				} else {
+					require.NoError(t, err)
+				}
+			}
+			return nil
+		}))
+
+		// The steps can be picked, and a canceled context stops Vacuum.
+		done = nil
+		require.NoError(t, db.Vacuum(context.Background(), VacuumOptions{
+			CompactKeyRegistry: true,
+			Progress:           vopt.Progress,
+		}))
+		require.Equal(t, []VacuumStep{VacuumKeyRegistry}, done)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Equal(t, context.Canceled, db.Vacuum(ctx, DefaultVacuumOptions))
+	})
+}
+
+func TestVacuumFrozen(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		require.NoError(t, db.Freeze())
+		var steps int
+		vopt := DefaultVacuumOptions
+		vopt.Progress = func(VacuumProgress) { steps++ }
+		require.Equal(t, ErrFrozen, db.Vacuum(context.Background(), vopt))
+		require.Equal(t, 0, steps)
+		require.NoError(t, db.Thaw())
+		require.NoError(t, db.Vacuum(context.Background(), vopt))
+		require.True(t, steps > 0)
+	})
+}
@@ -20,7 +20,9 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/aes"
+	"crypto/cipher"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -53,6 +55,10 @@ const (
 	bitDiscardEarlierVersions byte = 1 << 2 // Set if earlier versions can be discarded.
 	// Set if item shouldn't be discarded via compactions (used by merge operator)
 	bitMergeEntry byte = 1 << 3
+	// Set along with bitDelete if the previous version can be undeleted until ExpiresAt.
+	bitSoftDelete byte = 1 << 4
+	// Set if the entry is expected to be deleted or to expire soon. See Entry.WithShortLived.
+	bitShortLived byte = 1 << 5
 	// The MSB 2 bits are for transactions.
 	bitTxn    byte = 1 << 6 // Set if the entry is part of a txn.
 	bitFinTxn byte = 1 << 7 // Set if the entry is to indicate end of txn in value log.
@@ -62,11 +68,22 @@ const (
 	// The number of updates after which discard map should be flushed into badger.
 	discardStatsFlushThreshold = 100
 
-	// size of vlog header.
+	// size of vlog header. The header starts with vlogMagic, followed by the version of the header
+	// and the checksum algorithm of the entries.
+	// +-----------------+------------------+-------------------+----------------+------------------+
+	// | magic (2 bytes) | version (1 byte) | checksum (1 byte) | keyID(8 bytes) | baseIV(12 bytes) |
+	// +-----------------+------------------+-------------------+----------------+------------------+
+	vlogHeaderSize = 24
+	// The files whose entries use CRC32C, the default, keep the header of older versions, with no
+	// magic and no version, so that older versions can still open them.
 	// +----------------+------------------+
 	// | keyID(8 bytes) |  baseIV(12 bytes)|
 	// +----------------+------------------+
-	vlogHeaderSize = 20
+	vlogHeaderSizeV0 = 20
+	// vlogMagic can't be mistaken for the start of an older header, whose key ID would need to be
+	// above 2^48.
+	vlogMagic         uint16 = 0xbadc
+	vlogHeaderVersion byte   = 1
 )
 
 type logFile struct {
@@ -83,15 +100,24 @@ type logFile struct {
 	size        uint32
 	loadingMode options.FileLoadingMode
 	dataKey     *pb.DataKey
+	cipher      cipher.Block // cipher is set up from dataKey, nil if encryption is disabled.
 	baseIV      []byte
 	registry    *KeyRegistry
+	counters    *y.Counters
+	// checksum is the checksum algorithm of the entries, truncated to 32 bits.
+	checksum pb.Checksum_Algorithm
+	// headerSize is the size of the header, which is smaller in the files of older versions.
+	headerSize uint32
+	// digest hashes the data written to the file. It's only set for the file being written to,
+	// when Options.FileDigests is set.
+	digest hash.Hash
 }
 
 // encodeEntry will encode entry to the buf
 // layout of entry
-// +--------+-----+-------+-------+
-// | header | key | value | crc32 |
-// +--------+-----+-------+-------+
+// +--------+-----+-------+----------+
+// | header | key | value | checksum |
+// +--------+-----+-------+----------+
 func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int, error) {
 	h := header{
 		klen:      uint32(len(e.Key)),
@@ -106,7 +132,7 @@ func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int,
 	sz := h.Encode(headerEnc[:])
 	y.Check2(buf.Write(headerEnc[:sz]))
 	// write hash.
-	hash := crc32.New(y.CastagnoliCrcTable)
+	hash := y.NewHash32(lf.checksum)
 	y.Check2(hash.Write(headerEnc[:sz]))
 	// we'll encrypt only key and value.
 	if lf.encryptionEnabled() {
@@ -116,11 +142,7 @@ func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int,
 		eBuf := make([]byte, 0, len(e.Key)+len(e.Value))
 		eBuf = append(eBuf, e.Key...)
 		eBuf = append(eBuf, e.Value...)
-		var err error
-		eBuf, err = y.XORBlock(eBuf, lf.dataKey.Data, lf.generateIV(offset))
-		if err != nil {
-			return 0, y.Wrapf(err, "Error while encoding entry for vlog.")
-		}
+		eBuf = y.XORBlockWithCipher(eBuf, lf.cipher, lf.generateIV(offset))
 		// write encrypted buf.
 		y.Check2(buf.Write(eBuf))
 		// write the hash.
@@ -136,7 +158,7 @@ func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int,
 		// write value hash.
 		y.Check2(hash.Write(e.Value))
 	}
-	// write crc32 hash.
+	// write the checksum.
 	var crcBuf [crc32.Size]byte
 	binary.BigEndian.PutUint32(crcBuf[:], hash.Sum32())
 	y.Check2(buf.Write(crcBuf[:]))
@@ -168,7 +190,20 @@ func (lf *logFile) decodeEntry(buf []byte, offset uint32) (*Entry, error) {
 }
 
 func (lf *logFile) decryptKV(buf []byte, offset uint32) ([]byte, error) {
-	return y.XORBlock(buf, lf.dataKey.Data, lf.generateIV(offset))
+	return y.XORBlockWithCipher(buf, lf.cipher, lf.generateIV(offset)), nil
+}
+
+// setDataKey sets the data key of the log file along with the cipher for it.
+func (lf *logFile) setDataKey(dk *pb.DataKey) error {
+	lf.dataKey, lf.cipher = dk, nil
+	if dk == nil {
+		return nil
+	}
+	var err error
+	if lf.cipher, err = y.NewCipher(dk.Data); err != nil {
+		return y.Wrapf(err, "Error while setting up cipher for the logfile %d", lf.fid)
+	}
+	return nil
 }
 
 // KeyID returns datakey's ID.
@@ -238,8 +273,7 @@ func (lf *logFile) read(p valuePointer, s *y.Slice) (buf []byte, err error) {
 			nbr = int64(valsz)
 		}
 	}
-	y.NumReads.Add(1)
-	y.NumBytesRead.Add(nbr)
+	lf.counters.AddRead(nbr)
 	return buf, err
 }
 
@@ -315,8 +349,8 @@ type hashReader struct {
 	bytesRead int // Number of bytes read.
 }
 
-func newHashReader(r io.Reader) *hashReader {
-	hash := crc32.New(y.CastagnoliCrcTable)
+func newHashReader(r io.Reader, algo pb.Checksum_Algorithm) *hashReader {
+	hash := y.NewHash32(algo)
 	return &hashReader{
 		r: r,
 		h: hash,
@@ -348,13 +382,14 @@ func (t *hashReader) Sum32() uint32 {
 // Entry reads an entry from the provided reader. It also validates the checksum for every entry
 // read. Returns error on failure.
 func (r *safeRead) Entry(reader io.Reader) (*Entry, error) {
-	tee := newHashReader(reader)
+	tee := newHashReader(reader, r.lf.checksum)
 	var h header
 	hlen, err := h.DecodeFrom(tee)
 	if err != nil {
 		return nil, err
 	}
-	if h.klen > uint32(1<<16) { // Key length must be below uint16.
+	// Key length must be below maxKeySizeLimit, leaving room for badgerMove and the timestamp.
+	if h.klen > uint32(maxKeySizeLimit+1<<10) {
 		return nil, errTruncate
 	}
 	kl := int(h.klen)
@@ -409,7 +444,7 @@ func (vlog *valueLog) iterate(lf *logFile, offset uint32, fn logEntry) (uint32,
 	}
 	if offset == 0 {
 		// If offset is set to zero, let's advance past the encryption key header.
-		offset = vlogHeaderSize
+		offset = lf.headerSize
 	}
 	if int64(offset) == fi.Size() {
 		// We're at the end of the file already. No need to do anything.
@@ -497,6 +532,16 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 	y.AssertTruef(uint32(f.fid) < maxFid, "fid to move: %d. Current max fid: %d", f.fid, maxFid)
 	tr.LazyPrintf("Rewriting fid: %d", f.fid)
 
+	if !vlog.pins.startRewrite(f.fid) {
+		tr.LazyPrintf("Values of fid %d are being renamed", f.fid)
+		return ErrNoRewrite
+	}
+	defer vlog.pins.endRewrite(f.fid)
+	aliases, err := vlog.aliasesFor(f.fid)
+	if err != nil {
+		return err
+	}
+
 	wb := make([]*Entry, 0, 1000)
 	var size int64
 
@@ -569,8 +614,16 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 		return nil
 	}
 
-	_, err := vlog.iterate(f, 0, func(e Entry, vp valuePointer) error {
-		return fe(e)
+	_, err = vlog.iterate(f, 0, func(e Entry, vp valuePointer) error {
+		if err := fe(e); err != nil {
+			return err
+		}
+		if len(aliases[e.offset]) == 0 {
+			return nil
+		}
+		entries, err := vlog.moveAliases(f.fid, e, aliases[e.offset])
+		wb = append(wb, entries...)
+		return err
 	})
 	if err != nil {
 		return err
@@ -602,6 +655,9 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 	}
 	tr.LazyPrintf("Processed %d entries in %d loops", len(wb), loops)
 	tr.LazyPrintf("Total entries: %d. Moved: %d", count, moved)
+	if err := vlog.archive(f); err != nil {
+		return err
+	}
 	tr.LazyPrintf("Removing fid: %d", f.fid)
 	var deleteFileNow bool
 	// Entries written to LSM. Remove the older file now.
@@ -734,39 +790,28 @@ func (vlog *valueLog) deleteLogFile(lf *logFile) error {
 	if err := lf.fd.Close(); err != nil {
 		return err
 	}
-	return os.Remove(path)
-}
-
-func (vlog *valueLog) dropAll() (int, error) {
-	// If db is opened in InMemory mode, we don't need to do anything since there are no vlog files.
-	if vlog.db.opt.InMemory {
-		return 0, nil
+	if err := os.Remove(path); err != nil {
+		return err
 	}
-	// We don't want to block dropAll on any pending transactions. So, don't worry about iterator
-	// count.
-	var count int
-	deleteAll := func() error {
-		vlog.filesLock.Lock()
-		defer vlog.filesLock.Unlock()
-		for _, lf := range vlog.filesMap {
-			if err := vlog.deleteLogFile(lf); err != nil {
-				return err
-			}
-			count++
-		}
-		vlog.filesMap = make(map[uint32]*logFile)
-		return nil
+	if lf.fid != atomic.LoadUint32(&vlog.maxFid) {
+		atomic.AddInt64(&vlog.doneSize, -int64(atomic.LoadUint32(&lf.size)))
 	}
-	if err := deleteAll(); err != nil {
-		return count, err
+	if !vlog.db.manifest.hasVlogDigest(lf.fid) {
+		return nil
 	}
+	return vlog.db.manifest.addChanges([]*pb.ManifestChange{newDeleteVlogChange(lf.fid)})
+}
 
-	vlog.db.opt.Infof("Value logs deleted. Creating value log file: 0")
-	if _, err := vlog.createVlogFile(0); err != nil {
-		return count, err
+// dropFile deletes the value log file fid, for DropAll. We don't want to block DropAll on any
+// pending transactions. So, don't worry about iterator count.
+func (vlog *valueLog) dropFile(fid uint32) error {
+	vlog.filesLock.Lock()
+	defer vlog.filesLock.Unlock()
+	if err := vlog.deleteLogFile(vlog.filesMap[fid]); err != nil {
+		return err
 	}
-	atomic.StoreUint32(&vlog.maxFid, 0)
-	return count, nil
+	delete(vlog.filesMap, fid)
+	return nil
 }
 
 // lfDiscardStats keeps track of the amount of data that could be discarded for
@@ -798,6 +843,14 @@ type valueLog struct {
 
 	garbageCh      chan struct{}
 	lfDiscardStats *lfDiscardStats
+
+	// rotateHead is set to 1 to start a new log file on the next write. Must access via atomics.
+	rotateHead int32
+	// doneSize is the size of the files other than the one being written to. Must access via
+	// atomics.
+	doneSize int64
+
+	pins valuePins
 }
 
 func vlogFilePath(dirPath string, fid uint32) string {
@@ -836,6 +889,8 @@ func (vlog *valueLog) populateFilesMap() error {
 			path:        vlog.fpath(uint32(fid)),
 			loadingMode: vlog.opt.ValueLogLoadingMode,
 			registry:    vlog.db.registry,
+			counters:    vlog.opt.counters,
+			checksum:    pb.Checksum_Algorithm(vlog.opt.ValueLogChecksumAlgorithm),
 		}
 		vlog.filesMap[uint32(fid)] = lf
 		if vlog.maxFid < uint32(fid) {
@@ -858,33 +913,56 @@ func (lf *logFile) open(path string, flags uint32) error {
 	sz := fi.Size()
 	y.AssertTruef(sz <= math.MaxUint32, "file size: %d greater than %d", sz, math.MaxUint32)
 	lf.size = uint32(sz)
-	if sz < vlogHeaderSize {
+	lf.headerSize = vlogHeaderSize
+	if sz < vlogHeaderSizeV0 {
 		// Every vlog file should have at least vlogHeaderSize. If it is less than vlogHeaderSize
 		// then it must have been corrupted. But no need to handle here. log replayer will truncate
 		// and bootstrap the logfile. So ignoring here.
 		return nil
 	}
 	buf := make([]byte, vlogHeaderSize)
-	if _, err = lf.fd.Read(buf); err != nil {
+	n, err := io.ReadFull(lf.fd, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return y.Wrapf(err, "Error while reading vlog file %d", lf.fid)
 	}
-	keyID := binary.BigEndian.Uint64(buf[:8])
+	var keyID uint64
+	switch {
+	case binary.BigEndian.Uint16(buf) != vlogMagic:
+		lf.headerSize, lf.checksum = vlogHeaderSizeV0, pb.Checksum_CRC32C
+		keyID = binary.BigEndian.Uint64(buf[:8])
+		buf = buf[:vlogHeaderSizeV0]
+	case n < vlogHeaderSize:
+		// Truncated header, see above.
+		return nil
+	case buf[2] != vlogHeaderVersion:
+		return errors.Errorf("Unknown header version %d of vlog file %d", buf[2], lf.fid)
+	default:
+		if lf.checksum = pb.Checksum_Algorithm(buf[3]); lf.checksum > pb.Checksum_NONE {
+			return errors.Errorf("Unknown checksum algorithm %d of vlog file %d", buf[3], lf.fid)
+		}
+		keyID = binary.BigEndian.Uint64(buf[4:12])
+	}
 	var dk *pb.DataKey
 	// retrieve datakey.
 	if dk, err = lf.registry.dataKey(keyID); err != nil {
 		return y.Wrapf(err, "While opening vlog file %d", lf.fid)
 	}
-	lf.dataKey = dk
-	lf.baseIV = buf[8:]
+	if err = lf.setDataKey(dk); err != nil {
+		return err
+	}
+	lf.baseIV = buf[lf.headerSize-12:]
 	y.AssertTrue(len(lf.baseIV) == 12)
 	return nil
 }
 
-// bootstrap will initialize the log file with key id and baseIV.
-// The below figure shows the layout of log file.
-// +----------------+------------------+------------------+
-// | keyID(8 bytes) |  baseIV(12 bytes)|	 entry...     |
-// +----------------+------------------+------------------+
+// bootstrap will initialize the log file with the header version, checksum algorithm, key id and
+// baseIV. The below figure shows the layout of log file.
+// +---------+---------+----------+---------+----------+----------+
+// | magic   | version | checksum | keyID   | baseIV   | entry... |
+// | 2 bytes | 1 byte  | 1 byte   | 8 bytes | 12 bytes |          |
+// +---------+---------+----------+---------+----------+----------+
+// If the checksum algorithm is CRC32C, the header only holds the key id and baseIV, as in older
+// versions.
 func (lf *logFile) bootstrap() error {
 	var err error
 	// delete all the data. because bootstrap is been called while creating vlog and as well
@@ -899,25 +977,44 @@ func (lf *logFile) bootstrap() error {
 	}
 	// generate data key for the log file.
 	var dk *pb.DataKey
-	if dk, err = lf.registry.latestDataKey(); err != nil {
+	if dk, err = lf.registry.vlogDataKey(); err != nil {
 		return y.Wrapf(err, "Error while retrieving datakey in logFile.bootstarp")
 	}
-	lf.dataKey = dk
-	// We'll always preserve vlogHeaderSize for key id and baseIV.
-	buf := make([]byte, vlogHeaderSize)
+	if err = lf.setDataKey(dk); err != nil {
+		return err
+	}
+	if err = lf.registry.audit.use(lf.keyID(), ValueLogFile, uint64(lf.fid)); err != nil {
+		return err
+	}
+	lf.headerSize = vlogHeaderSize
+	if lf.checksum == pb.Checksum_CRC32C {
+		lf.headerSize = vlogHeaderSizeV0
+	}
+	buf := make([]byte, lf.headerSize)
+	if lf.headerSize == vlogHeaderSize {
+		binary.BigEndian.PutUint16(buf[:2], vlogMagic)
+		buf[2] = vlogHeaderVersion
+		buf[3] = byte(lf.checksum)
+	}
 	// write key id to the buf.
 	// key id will be zero if the logfile is in plain text.
-	binary.BigEndian.PutUint64(buf[:8], lf.keyID())
+	binary.BigEndian.PutUint64(buf[lf.headerSize-20:], lf.keyID())
 	// generate base IV. It'll be used with offset of the vptr to encrypt the entry.
-	if _, err := cryptorand.Read(buf[8:]); err != nil {
+	if _, err := cryptorand.Read(buf[lf.headerSize-12:]); err != nil {
 		return y.Wrapf(err, "Error while creating base IV, while creating logfile")
 	}
 	// Initialize base IV.
-	lf.baseIV = buf[8:]
+	lf.baseIV = buf[lf.headerSize-12:]
 	y.AssertTrue(len(lf.baseIV) == 12)
 	// write the key id and base IV to the file.
-	_, err = lf.fd.Write(buf)
-	return err
+	if _, err = lf.fd.Write(buf); err != nil {
+		return err
+	}
+	if lf.digest != nil {
+		lf.digest.Reset()
+		lf.digest.Write(buf)
+	}
+	return nil
 }
 
 func (vlog *valueLog) createVlogFile(fid uint32) (*logFile, error) {
@@ -928,6 +1025,11 @@ func (vlog *valueLog) createVlogFile(fid uint32) (*logFile, error) {
 		path:        path,
 		loadingMode: vlog.opt.ValueLogLoadingMode,
 		registry:    vlog.db.registry,
+		counters:    vlog.opt.counters,
+		checksum:    pb.Checksum_Algorithm(vlog.opt.ValueLogChecksumAlgorithm),
+	}
+	if vlog.opt.FileDigests {
+		lf.digest = sha256.New()
 	}
 	// writableLogOffset is only written by write func, by read by Read func.
 	// To avoid a race condition, all reads and updates to this variable must be
@@ -950,13 +1052,18 @@ func (vlog *valueLog) createVlogFile(fid uint32) (*logFile, error) {
 	// writableLogOffset is only written by write func, by read by Read func.
 	// To avoid a race condition, all reads and updates to this variable must be
 	// done via atomics.
-	atomic.StoreUint32(&vlog.writableLogOffset, vlogHeaderSize)
+	atomic.StoreUint32(&vlog.writableLogOffset, lf.headerSize)
 	vlog.numEntriesWritten = 0
 
 	vlog.filesLock.Lock()
+	defer vlog.filesLock.Unlock()
 	vlog.filesMap[fid] = lf
-	vlog.filesLock.Unlock()
-
+	// Its data key might have been retired since lf got bootstrapped.
+	if lf.dataKey != nil {
+		if err := vlog.db.registry.keep(lf.dataKey.KeyId); err != nil {
+			return nil, err
+		}
+	}
 	return lf, nil
 }
 
@@ -989,9 +1096,9 @@ func (vlog *valueLog) replayLog(lf *logFile, offset uint32, replayFn logEntry) e
 	// If fid == maxFid then it's okay to truncate the entire file since it will be
 	// used for future additions. Also, it's okay if the last file has size zero.
 	// We mmap 2*opt.ValueLogSize for the last file. See vlog.Open() function
-	// if endOffset <= vlogHeaderSize && lf.fid != vlog.maxFid {
+	// if endOffset <= lf.headerSize && lf.fid != vlog.maxFid {
 
-	if endOffset <= vlogHeaderSize {
+	if endOffset <= lf.headerSize {
 		if lf.fid != vlog.maxFid {
 			return errDeleteVlogFile
 		}
@@ -1016,7 +1123,7 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 	vlog.dirPath = vlog.opt.ValueDir
 	vlog.elog = y.NoEventLog
 	if vlog.opt.EventLogging {
-		vlog.elog = trace.NewEventLog("Badger", "Valuelog")
+		vlog.elog = trace.NewEventLog("Badger", vlog.opt.traceTitle("Valuelog"))
 	}
 	vlog.garbageCh = make(chan struct{}, 1) // Only allow one GC at a time.
 	vlog.lfDiscardStats = &lfDiscardStats{
@@ -1105,6 +1212,10 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 	// plain text mode or vice versa. A single vlog file can't have both
 	// encrypted entries and plain text entries.
 	if last.encryptionEnabled() != vlog.db.shouldEncrypt() {
+		// The last file won't be written to anymore, map it like the other ones.
+		if err := last.init(); err != nil {
+			return err
+		}
 		newid := atomic.AddUint32(&vlog.maxFid, 1)
 		_, err := vlog.createVlogFile(newid)
 		if err != nil {
@@ -1118,6 +1229,16 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 		return errFile(err, last.path, "file.Seek to end")
 	}
 	vlog.writableLogOffset = uint32(lastOffset)
+	for fid, lf := range vlog.filesMap {
+		if fid != vlog.maxFid {
+			vlog.doneSize += int64(lf.size)
+		}
+	}
+	if vlog.opt.FileDigests && last.digest == nil {
+		if err := last.initDigest(lastOffset); err != nil {
+			return err
+		}
+	}
 
 	// Update the head to point to the updated tail. Otherwise, even after doing a successful
 	// replay and closing the DB, the value log head does not get updated, which causes the replay
@@ -1297,6 +1418,27 @@ func (vlog *valueLog) woffset() uint32 {
 	return atomic.LoadUint32(&vlog.writableLogOffset)
 }
 
+// end returns the end of the entries written to the value log.
+func (vlog *valueLog) end() valuePointer {
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	fid := atomic.LoadUint32(&vlog.maxFid)
+	lf, ok := vlog.filesMap[fid]
+	if !ok && fid > 0 {
+		// The next file is being created, the previous one is done.
+		fid--
+		lf, ok = vlog.filesMap[fid]
+	}
+	if !ok {
+		return valuePointer{}
+	}
+	offset := atomic.LoadUint32(&lf.size)
+	if offset < lf.headerSize {
+		offset = lf.headerSize
+	}
+	return valuePointer{Fid: fid, Offset: offset}
+}
+
 // write is thread-unsafe by design and should not be called concurrently.
 func (vlog *valueLog) write(reqs []*request) error {
 	if vlog.db.opt.InMemory {
@@ -1317,35 +1459,52 @@ func (vlog *valueLog) write(reqs []*request) error {
 		if err != nil {
 			return errors.Wrapf(err, "Unable to write to value log file: %q", curlf.path)
 		}
+		if curlf.digest != nil {
+			curlf.digest.Write(buf.Bytes())
+		}
 		buf.Reset()
-		y.NumWrites.Add(1)
-		y.NumBytesWritten.Add(int64(n))
+		vlog.opt.counters.AddWrite(int64(n))
 		vlog.elog.Printf("Done")
 		atomic.AddUint32(&vlog.writableLogOffset, uint32(n))
 		atomic.StoreUint32(&curlf.size, vlog.writableLogOffset)
 		return nil
 	}
+	rotate := func() error {
+		if err := curlf.doneWriting(vlog.woffset()); err != nil {
+			return err
+		}
+		atomic.AddInt64(&vlog.doneSize, int64(vlog.woffset()))
+		if vlog.opt.FileDigests {
+			if err := vlog.recordDigest(curlf); err != nil {
+				return err
+			}
+		}
+
+		newid := atomic.AddUint32(&vlog.maxFid, 1)
+		y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
+		newlf, err := vlog.createVlogFile(newid)
+		if err != nil {
+			return err
+		}
+		curlf = newlf
+		atomic.AddInt32(&vlog.db.logRotates, 1)
+		return nil
+	}
 	toDisk := func() error {
 		if err := flushWrites(); err != nil {
 			return err
 		}
 		if vlog.woffset() > uint32(vlog.opt.ValueLogFileSize) ||
 			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries {
-			if err := curlf.doneWriting(vlog.woffset()); err != nil {
-				return err
-			}
-
-			newid := atomic.AddUint32(&vlog.maxFid, 1)
-			y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
-			newlf, err := vlog.createVlogFile(newid)
-			if err != nil {
-				return err
-			}
-			curlf = newlf
-			atomic.AddInt32(&vlog.db.logRotates, 1)
+			return rotate()
 		}
 		return nil
 	}
+	if atomic.CompareAndSwapInt32(&vlog.rotateHead, 1, 0) {
+		if err := rotate(); err != nil {
+			return err
+		}
+	}
 	for i := range reqs {
 		b := reqs[i]
 		b.Ptrs = b.Ptrs[:0]
@@ -1427,7 +1586,7 @@ func (vlog *valueLog) Read(vp valuePointer, s *y.Slice) ([]byte, func(), error)
 	}
 
 	if vlog.opt.VerifyValueChecksum {
-		hash := crc32.New(y.CastagnoliCrcTable)
+		hash := y.NewHash32(lf.checksum)
 		if _, err := hash.Write(buf[:len(buf)-crc32.Size]); err != nil {
 			runCallback(cb)
 			return nil, nil, errors.Wrapf(err, "failed to write hash for vp %+v", vp)
@@ -1548,6 +1707,10 @@ func discardEntry(e Entry, vs y.ValueStruct) bool {
 		// Just a txn finish entry. Discard.
 		return true
 	}
+	if e.meta&bitValuePointer > 0 {
+		// The entry of a renamed key, pointing to a value elsewhere in the value log.
+		return true
+	}
 	return false
 }
 
@@ -1678,9 +1841,14 @@ func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace)
 	// and what we can discard is below the threshold, we should skip the rewrite.
 	if (r.count < countWindow && r.total < sizeWindowM*0.75) || r.discard < discardRatio*r.total {
 		tr.LazyPrintf("Skipping GC on fid: %d", lf.fid)
+		vlog.db.ops.record(opGC, "skipped fid %d, sampled %d entries, %.2fMB discardable of %.2fMB",
+			lf.fid, r.count, r.discard, r.total)
 		return ErrNoRewrite
 	}
+	vlog.db.ops.record(opGC, "rewriting fid %d, sampled %d entries, %.2fMB discardable of %.2fMB",
+		lf.fid, r.count, r.discard, r.total)
 	if err = vlog.rewrite(lf, tr); err != nil {
+		vlog.db.ops.record(opGC, "rewriting fid %d failed: %v", lf.fid, err)
 		return err
 	}
 	tr.LazyPrintf("Done rewriting.")
@@ -1701,7 +1869,7 @@ func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
 	select {
 	case vlog.garbageCh <- struct{}{}:
 		// Pick a log file for GC.
-		tr := trace.New("Badger.ValueLog", "GC")
+		tr := trace.New("Badger.ValueLog", vlog.opt.traceTitle("GC"))
 		tr.SetMaxEvents(100)
 		defer func() {
 			tr.Finish()
@@ -1712,6 +1880,7 @@ func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
 		files := vlog.pickLog(head, tr)
 		if len(files) == 0 {
 			tr.LazyPrintf("PickLog returned zero results.")
+			vlog.db.ops.record(opGC, "no value log file to rewrite")
 			return ErrNoRewrite
 		}
 		tried := make(map[uint32]bool)
@@ -1720,7 +1889,15 @@ func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
 				continue
 			}
 			tried[lf.fid] = true
-			err = vlog.doRunGC(lf, discardRatio, tr)
+			err = vlog.db.jobs.run(JobValueLogGC, func(job *BackgroundJob) error {
+				job.Detail = fmt.Sprintf("fid %d", lf.fid)
+				err := vlog.doRunGC(lf, discardRatio, tr)
+				if err == nil {
+					// The whole file got read by the rewrite.
+					job.BytesRead = int64(atomic.LoadUint32(&lf.size))
+				}
+				return err
+			})
 			if err == nil {
 				return vlog.deleteMoveKeysFor(lf.fid, tr)
 			}
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -30,6 +31,7 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/stretchr/testify/require"
@@ -989,7 +991,7 @@ func TestValueLogTruncate(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		require.Equal(t, 2*db.opt.ValueLogFileSize, fileStat.Size())
 	} else {
-		require.Equal(t, int64(vlogHeaderSize), fileStat.Size())
+		require.Equal(t, int64(vlogHeaderSizeV0), fileStat.Size())
 	}
 	fileCountAfterCorruption := len(db.vlog.filesMap)
 	// +1 because the file with id=2 will be completely truncated. It won't be deleted.
@@ -1221,3 +1223,101 @@ func TestValueEntryChecksum(t *testing.T) {
 		require.NoError(t, db.Close())
 	})
 }
+
+func TestValueLogChecksumAlgorithm(t *testing.T) {
+	for _, algo := range []options.ChecksumAlgorithm{
+		options.CRC32C, options.XXHash64, options.NoChecksum} {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+
+		opt := getTestOptions(dir).WithValueLogChecksumAlgorithm(algo).
+			WithTableChecksumAlgorithm(algo)
+		opt.VerifyValueChecksum = true
+		db, err := Open(opt)
+		require.NoError(t, err)
+		v := []byte(fmt.Sprintf("val%100d", 10))
+		require.Greater(t, len(v), db.opt.ValueThreshold)
+		for i := 0; i < 100; i++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), v, 0)
+		}
+		path := db.vlog.fpath(0)
+		// Simulate a crash, for the entries to be replayed.
+		require.NoError(t, db.dirLockGuard.release())
+		if db.valueDirGuard != nil {
+			require.NoError(t, db.valueDirGuard.release())
+		}
+		require.NoError(t, db.vlog.Close())
+
+		hdr := make([]byte, vlogHeaderSize)
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		_, err = f.Read(hdr)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		if algo == options.CRC32C {
+			// The header of older versions, starting with the zero key ID.
+			require.Equal(t, make([]byte, 8), hdr[:8])
+		} else {
+			require.Equal(t, vlogMagic, binary.BigEndian.Uint16(hdr))
+			require.Equal(t, vlogHeaderVersion, hdr[2])
+			require.Equal(t, byte(algo), hdr[3])
+		}
+
+		// The algorithm is read from the files.
+		db, err = Open(opt.WithValueLogChecksumAlgorithm(options.CRC32C))
+		require.NoError(t, err)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+				require.NoError(t, err)
+				x, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, v, x)
+			}
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestValueLogHeaderV0(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	path := db.vlog.fpath(0)
+	// Simulate a crash, for the entries to be replayed.
+	require.NoError(t, db.dirLockGuard.release())
+	if db.valueDirGuard != nil {
+		require.NoError(t, db.valueDirGuard.release())
+	}
+	require.NoError(t, db.vlog.Close())
+
+	// With CRC32C, the default, the files keep the header of older versions, which has no magic,
+	// no version and no checksum algorithm, for older versions to be able to open them.
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, 8), data[:8])
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	lf := db.vlog.filesMap[0]
+	require.Equal(t, uint32(vlogHeaderSizeV0), lf.headerSize)
+	require.Equal(t, pb.Checksum_CRC32C, lf.checksum)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			item, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), getItemValue(t, item))
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// VaultKeyProvider is a KeyRewrapper getting the master key from the transit secrets engine of
+// HashiCorp Vault. The master key is a data key generated by Vault, stored wrapped by the transit
+// key in a file, so that only Vault can unwrap it: the raw key is only ever kept in memory. The
+// file is created along with the master key, the first time it's needed.
+//
+// Rotating the transit key in Vault doesn't change the master key, call RewrapWrappedKey
+// afterwards to wrap it with the latest version of the transit key. DB.RewrapMasterKey replaces
+// the master key itself.
+type VaultKeyProvider struct {
+	// HTTPClient is the client sending the requests to Vault, http.DefaultClient if nil. It can
+	// be set up with the TLS configuration of the Vault server.
+	HTTPClient *http.Client
+	// MountPath is the path the transit secrets engine is mounted at, "transit" if empty.
+	MountPath string
+	// Bits is the size of the master key in bits: 128, 192 or 256, the default if it's 0.
+	Bits int
+
+	address string
+	token   string
+	keyName string
+	path    string
+
+	lock sync.Mutex
+	key  []byte // The unwrapped master key, nil until it's fetched.
+}
+
+var _ KeyRewrapper = (*VaultKeyProvider)(nil)
+
+// NewVaultKeyProvider returns a VaultKeyProvider using the transit key keyName of the Vault server
+// at address, e.g. "https://vault.example.com:8200", authenticating with token. The wrapped master
+// key is stored in the file at path, which should be kept along with the DB.
+func NewVaultKeyProvider(address, token, keyName, path string) *VaultKeyProvider {
+	return &VaultKeyProvider{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		keyName: keyName,
+		path:    path,
+	}
+}
+
+// GetMasterKey returns the master key, unwrapping it with Vault the first time it's called, or
+// generating it if the file at path doesn't exist.
+func (p *VaultKeyProvider) GetMasterKey(ctx context.Context) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.key != nil {
+		return p.key, nil
+	}
+	wrapped, err := ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		key, wrapped, err := p.generateKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.writeWrappedKey(p.path, wrapped); err != nil {
+			return nil, err
+		}
+		p.key = key
+		return key, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "While reading wrapped master key")
+	}
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	req := map[string]string{"ciphertext": strings.TrimSpace(string(wrapped))}
+	if err := p.call(ctx, "decrypt", req, &resp); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.StdEncoding.DecodeString(resp.Plaintext); err != nil {
+		return nil, errors.Wrap(err, "While decoding master key from Vault")
+	}
+	return p.key, nil
+}
+
+// NewMasterKey generates a new master key with Vault, storing it wrapped next to the file at
+// path, with the ".new" suffix, until MasterKeyRewrapped makes it current. If the process stops in
+// between, and the key registry can't be opened because of ErrEncryptionKeyMismatch, the ".new"
+// file should replace the other one.
+func (p *VaultKeyProvider) NewMasterKey(ctx context.Context) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	key, wrapped, err := p.generateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.writeWrappedKey(p.path+".new", wrapped); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// MasterKeyRewrapped makes the master key returned by NewMasterKey current.
+func (p *VaultKeyProvider) MasterKeyRewrapped(ctx context.Context, newKey []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	// Return the new key from now on, even if the rename fails.
+	p.key = newKey
+	if err := os.Rename(p.path+".new", p.path); err != nil {
+		return errors.Wrap(err, "While replacing wrapped master key")
+	}
+	return syncDir(filepath.Dir(p.path))
+}
+
+// RewrapWrappedKey wraps the master key again with the latest version of the transit key, once
+// it's rotated in Vault, without Vault revealing the master key. The older versions of the transit
+// key aren't needed anymore afterwards.
+func (p *VaultKeyProvider) RewrapWrappedKey(ctx context.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	wrapped, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return errors.Wrap(err, "While reading wrapped master key")
+	}
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	req := map[string]string{"ciphertext": strings.TrimSpace(string(wrapped))}
+	if err := p.call(ctx, "rewrap", req, &resp); err != nil {
+		return err
+	}
+	return p.writeWrappedKey(p.path, []byte(resp.Ciphertext))
+}
+
+// generateKey returns a new master key generated by Vault, and the same key wrapped by the
+// transit key.
+func (p *VaultKeyProvider) generateKey(ctx context.Context) ([]byte, []byte, error) {
+	bits := p.Bits
+	if bits == 0 {
+		bits = 256
+	}
+	var resp struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := p.call(ctx, "datakey/plaintext", map[string]int{"bits": bits}, &resp); err != nil {
+		return nil, nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "While decoding master key from Vault")
+	}
+	return key, []byte(resp.Ciphertext), nil
+}
+
+// writeWrappedKey atomically replaces the file at path with wrapped.
+func (p *VaultKeyProvider) writeWrappedKey(path string, wrapped []byte) error {
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, wrapped, 0600); err != nil {
+		return errors.Wrap(err, "While writing wrapped master key")
+	}
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "While syncing wrapped master key")
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "While syncing wrapped master key")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "While renaming wrapped master key")
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// call sends req to the given endpoint of the transit secrets engine, for the transit key, and
+// decodes the data of the response into resp.
+func (p *VaultKeyProvider) call(ctx context.Context, endpoint string, req, resp interface{}) error {
+	mount := p.MountPath
+	if mount == "" {
+		mount = "transit"
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.address, strings.Trim(mount, "/"), endpoint, p.keyName)
+	hreq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "While calling Vault %s", endpoint)
+	}
+	hreq = hreq.WithContext(ctx)
+	hreq.Header.Set("X-Vault-Token", p.token)
+	hreq.Header.Set("Content-Type", "application/json")
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return errors.Wrapf(err, "While calling Vault %s", endpoint)
+	}
+	defer hresp.Body.Close()
+	var out struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(hresp.Body).Decode(&out); err != nil {
+		return errors.Wrapf(err, "While decoding response of Vault %s, status %s", endpoint,
+			hresp.Status)
+	}
+	if hresp.StatusCode/100 != 2 {
+		return errors.Errorf("Vault %s failed with status %s: %s", endpoint, hresp.Status,
+			strings.Join(out.Errors, "; "))
+	}
+	return errors.Wrapf(json.Unmarshal(out.Data, resp), "While decoding response of Vault %s",
+		endpoint)
+}
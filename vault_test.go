@@ -0,0 +1,202 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransit emulates the transit secrets engine of Vault, wrapping keys by xoring them with the
+// version of its key.
+type fakeTransit struct {
+	sync.Mutex
+	versions [][]byte
+	calls    map[string]int
+}
+
+func (f *fakeTransit) rotate() {
+	f.Lock()
+	defer f.Unlock()
+	v := make([]byte, 64)
+	rand.Read(v)
+	f.versions = append(f.versions, v)
+}
+
+func (f *fakeTransit) xor(version int, key []byte) []byte {
+	res := make([]byte, len(key))
+	for i := range key {
+		res[i] = key[i] ^ f.versions[version-1][i]
+	}
+	return res
+}
+
+func (f *fakeTransit) wrap(version int, key []byte) string {
+	return fmt.Sprintf("vault:v%d:%s", version,
+		base64.StdEncoding.EncodeToString(f.xor(version, key)))
+}
+
+func (f *fakeTransit) unwrap(ciphertext string) ([]byte, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[1], "v%d", &version); err != nil {
+		return nil, err
+	}
+	if version < 1 || version > len(f.versions) {
+		return nil, fmt.Errorf("invalid key version")
+	}
+	w, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	return f.xor(version, w), nil
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	if r.Header.Get("X-Vault-Token") != "token" {
+		reply(http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	var req struct {
+		Bits       int    `json:"bits"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(http.StatusBadRequest, map[string][]string{"errors": {err.Error()}})
+		return
+	}
+	endpoint := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/badger")
+	f.calls[endpoint]++
+	latest := len(f.versions)
+	data := map[string]string{}
+	switch endpoint {
+	case "datakey/plaintext":
+		key := make([]byte, req.Bits/8)
+		rand.Read(key)
+		data["plaintext"] = base64.StdEncoding.EncodeToString(key)
+		data["ciphertext"] = f.wrap(latest, key)
+	case "decrypt", "rewrap":
+		key, err := f.unwrap(req.Ciphertext)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string][]string{"errors": {err.Error()}})
+			return
+		}
+		if endpoint == "decrypt" {
+			data["plaintext"] = base64.StdEncoding.EncodeToString(key)
+		} else {
+			data["ciphertext"] = f.wrap(latest, key)
+		}
+	default:
+		reply(http.StatusNotFound, map[string][]string{"errors": {"unsupported path"}})
+		return
+	}
+	reply(http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func TestVaultKeyProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	transit := &fakeTransit{calls: make(map[string]int)}
+	transit.rotate()
+	srv := httptest.NewServer(transit)
+	defer srv.Close()
+
+	path := filepath.Join(dir, "MASTERKEY")
+	newProvider := func() *VaultKeyProvider {
+		return NewVaultKeyProvider(srv.URL+"/", "token", "badger", path)
+	}
+	_, err = NewVaultKeyProvider(srv.URL, "wrong", "badger", path).GetMasterKey(
+		context.Background())
+	require.Error(t, err)
+
+	opt := getTestOptions(dir).WithKeyProvider(newProvider())
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.Close())
+	// The key was generated once, and then kept in memory.
+	require.Equal(t, 1, transit.calls["datakey/plaintext"])
+	require.Equal(t, 0, transit.calls["decrypt"])
+	wrapped, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(wrapped), "vault:v1:"))
+
+	check := func() {
+		db, err := Open(getTestOptions(dir).WithKeyProvider(newProvider()))
+		require.NoError(t, err)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("foo"))
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			require.Equal(t, []byte("bar"), val)
+			return err
+		}))
+		require.NoError(t, db.Close())
+	}
+	check()
+	require.Equal(t, 1, transit.calls["decrypt"])
+
+	// Rotating the transit key only rewraps the master key.
+	transit.rotate()
+	require.NoError(t, newProvider().RewrapWrappedKey(context.Background()))
+	wrapped, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(wrapped), "vault:v2:"))
+	check()
+
+	// Rotating the master key replaces the file.
+	kp := newProvider()
+	oldKey, err := kp.GetMasterKey(context.Background())
+	require.NoError(t, err)
+	db, err = Open(getTestOptions(dir).WithKeyProvider(kp))
+	require.NoError(t, err)
+	require.NoError(t, db.RewrapMasterKey(context.Background()))
+	require.NoError(t, db.Close())
+	newKey, err := kp.GetMasterKey(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, oldKey, newKey)
+	_, err = os.Stat(path + ".new")
+	require.True(t, os.IsNotExist(err))
+	check()
+}
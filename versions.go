@@ -0,0 +1,260 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"golang.org/x/net/trace"
+)
+
+// maxTrackedVersionKeys is the number of keys with the most versions kept by versionTracker.
+const maxTrackedVersionKeys = 64
+
+// KeyVersionCount is a key along with the number of versions seen for it.
+type KeyVersionCount struct {
+	Key      []byte
+	Versions int
+}
+
+// versionTracker remembers the keys found with more than threshold versions during flushes and
+// compactions. Only the maxTrackedVersionKeys keys with the most versions are kept.
+type versionTracker struct {
+	sync.Mutex
+	threshold int
+	keys      map[string]int
+	opt       *Options
+}
+
+func newVersionTracker(opt *Options) *versionTracker {
+	return &versionTracker{
+		threshold: opt.VersionCountThreshold,
+		keys:      make(map[string]int),
+		opt:       opt,
+	}
+}
+
+// observe records that versions versions of key were seen. key must not have a timestamp.
+func (vt *versionTracker) observe(key []byte, versions int) {
+	if vt == nil || vt.threshold <= 0 || versions <= vt.threshold {
+		return
+	}
+	vt.Lock()
+	defer vt.Unlock()
+
+	if old, ok := vt.keys[string(key)]; ok {
+		if versions > old {
+			vt.keys[string(key)] = versions
+		}
+		return
+	}
+	if len(vt.keys) >= maxTrackedVersionKeys {
+		minKey, minVersions := "", math.MaxInt64
+		for k, v := range vt.keys {
+			if v < minVersions {
+				minKey, minVersions = k, v
+			}
+		}
+		if versions <= minVersions {
+			return
+		}
+		delete(vt.keys, minKey)
+	}
+	vt.keys[string(key)] = versions
+	vt.opt.Warningf("Key %q has %d versions, more than the threshold of %d. "+
+		"Use DB.CompactRange to get rid of the old versions.", key, versions, vt.threshold)
+}
+
+func (vt *versionTracker) top() []KeyVersionCount {
+	vt.Lock()
+	defer vt.Unlock()
+	res := make([]KeyVersionCount, 0, len(vt.keys))
+	for k, v := range vt.keys {
+		res = append(res, KeyVersionCount{Key: []byte(k), Versions: v})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Versions != res[j].Versions {
+			return res[i].Versions > res[j].Versions
+		}
+		return bytes.Compare(res[i].Key, res[j].Key) < 0
+	})
+	return res
+}
+
+// versionCounter counts the consecutive versions of keys read in sorted order and reports the
+// count of every key to a versionTracker.
+type versionCounter struct {
+	vt   *versionTracker
+	key  []byte
+	seen int
+}
+
+// add counts key, which must have a timestamp.
+func (vc *versionCounter) add(key []byte) {
+	if vc.vt == nil || vc.vt.threshold <= 0 {
+		return
+	}
+	if len(vc.key) > 0 && y.SameKey(key, vc.key) {
+		vc.seen++
+		return
+	}
+	vc.done()
+	vc.key = y.SafeCopy(vc.key, key)
+	vc.seen = 1
+}
+
+// done reports the last key counted.
+func (vc *versionCounter) done() {
+	if len(vc.key) > 0 {
+		vc.vt.observe(y.ParseKey(vc.key), vc.seen)
+	}
+}
+
+// KeysWithManyVersions returns the keys found with more than Options.VersionCountThreshold
+// versions by flushes and compactions, the key with the most versions first. Versions above
+// NumVersionsToKeep are only dropped when compactions reach them, which can take a long time for
+// keys updated very frequently. Such keys can be compacted right away with DB.CompactRange.
+func (db *DB) KeysWithManyVersions() []KeyVersionCount {
+	return db.versions.top()
+}
+
+// CompactRange compacts all the tables holding keys in the range [start, end] down to the
+// lowest level, getting rid of the versions which are not needed anymore. It only affects data
+// that has been flushed out of the memtables. If either start or end is empty, all the tables
+// are compacted. It returns ErrFrozen while the DB is frozen.
+func (db *DB) CompactRange(start, end []byte) error {
+	if db.opt.ReadOnly {
+		return errors.New("CompactRange cannot be called in read-only mode")
+	}
+	done, err := db.startRewrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+	kr := infRange
+	if len(start) > 0 && len(end) > 0 {
+		kr = keyRange{left: y.KeyWithTs(start, math.MaxUint64), right: y.KeyWithTs(end, 0)}
+	}
+	if err := db.lc.compactRange(kr); err != nil {
+		return err
+	}
+	db.versions.Lock()
+	defer db.versions.Unlock()
+	for k := range db.versions.keys {
+		if kr.inf || (db.opt.compareKeys([]byte(k), start) >= 0 &&
+			db.opt.compareKeys([]byte(k), end) <= 0) {
+			delete(db.versions.keys, k)
+		}
+	}
+	return nil
+}
+
+// compactRange compacts the tables overlapping kr one level at a time, starting at level 0.
+func (s *levelsController) compactRange(kr keyRange) error {
+	for l := 0; l+1 < s.kv.opt.MaxLevels; l++ {
+		if err := s.compactLevelRange(l, kr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactLevelRange compacts the tables of level l overlapping kr into level l+1.
+func (s *levelsController) compactLevelRange(l int, kr keyRange) error {
+	cd := compactDef{
+		elog:      trace.New(fmt.Sprintf("Badger.L%d", l), s.kv.opt.traceTitle("CompactRange")),
+		thisLevel: s.levels[l],
+		nextLevel: s.levels[l+1],
+	}
+	defer cd.elog.Finish()
+
+	// Other compactions might be running on the same tables. Wait for them to finish.
+	for {
+		found, ok := s.fillTablesForRange(&cd, kr)
+		if !found {
+			return nil
+		}
+		if ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer s.cstatus.delete(cd)
+	if err := s.runCompactDef(l, cd); err != nil {
+		return errors.Wrapf(err, "while compacting range %s at level %d", kr, l)
+	}
+	return nil
+}
+
+// fillTablesForRange picks the tables of cd.thisLevel overlapping kr, along with the tables of
+// cd.nextLevel they overlap with. found is false if there's nothing to compact, ok is false if
+// the tables are being compacted already.
+func (s *levelsController) fillTablesForRange(cd *compactDef, kr keyRange) (found, ok bool) {
+	cd.lockLevels()
+	defer cd.unlockLevels()
+
+	cd.thisSize = 0
+	if cd.thisLevel.level == 0 {
+		// Tables in level 0 overlap each other, they can only be compacted all at once.
+		var overlap bool
+		for _, t := range cd.thisLevel.tables {
+			if kr.overlapsWith(getKeyRange(t), s.kv.opt.Comparator) {
+				overlap = true
+				break
+			}
+		}
+		if !overlap {
+			return false, false
+		}
+		cd.top = make([]*table.Table, len(cd.thisLevel.tables))
+		copy(cd.top, cd.thisLevel.tables)
+		cd.thisRange = infRange
+	} else {
+		left, right := cd.thisLevel.overlappingTables(levelHandlerRLocked{}, kr)
+		if kr.inf {
+			left, right = 0, len(cd.thisLevel.tables)
+		}
+		if left >= right {
+			return false, false
+		}
+		cd.top = make([]*table.Table, right-left)
+		copy(cd.top, cd.thisLevel.tables[left:right])
+		cd.thisRange = getKeyRange(cd.top...)
+	}
+	for _, t := range cd.top {
+		cd.thisSize += t.Size()
+	}
+
+	topRange := getKeyRange(cd.top...)
+	left, right := cd.nextLevel.overlappingTables(levelHandlerRLocked{}, topRange)
+	cd.bot = make([]*table.Table, right-left)
+	copy(cd.bot, cd.nextLevel.tables[left:right])
+	if len(cd.bot) == 0 {
+		cd.nextRange = topRange
+	} else {
+		cd.nextRange = getKeyRange(cd.bot...)
+	}
+	return true, s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd)
+}
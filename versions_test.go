@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionTracker(t *testing.T) {
+	vt := newVersionTracker(&Options{VersionCountThreshold: 10, Logger: defaultLogger})
+	vt.observe([]byte("a"), 5)
+	require.Empty(t, vt.top())
+
+	for i := 0; i < maxTrackedVersionKeys+10; i++ {
+		vt.observe([]byte(fmt.Sprintf("key%03d", i)), 11+i)
+	}
+	top := vt.top()
+	require.Len(t, top, maxTrackedVersionKeys)
+	require.Equal(t, []byte(fmt.Sprintf("key%03d", maxTrackedVersionKeys+9)), top[0].Key)
+	require.Equal(t, 11+maxTrackedVersionKeys+9, top[0].Versions)
+	// The keys with the fewest versions got evicted.
+	require.Equal(t, []byte("key010"), top[len(top)-1].Key)
+}
+
+func TestCompactRangeDropsVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := OpenManaged(getTestOptions(dir).WithVersionCountThreshold(10))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ts := uint64(1)
+	set := func(key, val []byte) {
+		txn := db.NewTransactionAt(ts, true)
+		require.NoError(t, txn.Set(key, val))
+		require.NoError(t, txn.CommitAt(ts, nil))
+		ts++
+	}
+	hot := []byte("hot")
+	for i := 0; i < 50; i++ {
+		set(hot, []byte(fmt.Sprintf("val%d", i)))
+	}
+	// Fill up the memtable to get it flushed.
+	val := make([]byte, 128)
+	for i := 0; i < 1000; i++ {
+		set([]byte(fmt.Sprintf("key%04d", i)), val)
+	}
+
+	waitFor(t, func() bool { return len(db.KeysWithManyVersions()) > 0 })
+	top := db.KeysWithManyVersions()
+	require.Equal(t, hot, top[0].Key)
+	require.Equal(t, 50, top[0].Versions)
+
+	db.SetDiscardTs(ts)
+	require.NoError(t, db.CompactRange(hot, hot))
+	require.Empty(t, db.KeysWithManyVersions())
+
+	opts := DefaultIteratorOptions
+	opts.AllVersions = true
+	txn := db.NewTransactionAt(ts, false)
+	defer txn.Discard()
+	it := txn.NewKeyIterator(hot, opts)
+	defer it.Close()
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	require.Equal(t, 1, count)
+}
+
+func TestCompactRangeFrozen(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Freeze())
+		require.Equal(t, ErrFrozen, db.CompactRange(nil, nil))
+		require.NoError(t, db.Thaw())
+		require.NoError(t, db.CompactRange(nil, nil))
+	})
+}
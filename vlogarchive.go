@@ -0,0 +1,38 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import "github.com/dgraph-io/badger/v2/y"
+
+// ValueLogArchiver is called with the id and the path of every value log file which value log GC
+// is about to delete, once its live entries have been moved. The file is complete and isn't
+// written to anymore, so it can be uploaded, copied or verified. It only gets deleted if the
+// archiver returns nil. Otherwise, value log GC fails with the error and keeps the file, which a
+// later run of value log GC picks again.
+type ValueLogArchiver func(fid uint32, path string) error
+
+// archive passes the value log file lf to the ValueLogArchiver of the DB.
+func (vlog *valueLog) archive(lf *logFile) error {
+	archiver := vlog.db.opt.ValueLogArchiver
+	if archiver == nil {
+		return nil
+	}
+	if err := archiver(lf.fid, vlog.fpath(lf.fid)); err != nil {
+		return y.Wrapf(err, "while archiving value log file %d", lf.fid)
+	}
+	return nil
+}
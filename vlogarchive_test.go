@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/trace"
+)
+
+func TestValueLogArchiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	archiveErr := errors.New("archive failed")
+	var fail bool
+	archived := make(map[uint32][]byte)
+	opt := getTestOptions(dir).WithValueLogFileSize(1 << 20).
+		WithValueLogArchiver(func(fid uint32, path string) error {
+			if fail {
+				return archiveErr
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			archived[fid] = data
+			return nil
+		})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	sz := 32 << 10
+	for i := 0; i < 100; i++ {
+		v := make([]byte, sz)
+		rand.Read(v)
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), v, 0)
+	}
+	for i := 0; i < 45; i++ {
+		txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+	}
+
+	db.vlog.filesLock.RLock()
+	lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+	db.vlog.filesLock.RUnlock()
+	path := db.vlog.fpath(lf.fid)
+	want, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	tr := trace.New("Test", "Test")
+	defer tr.Finish()
+	// The file is kept when it can't be archived.
+	fail = true
+	require.Equal(t, archiveErr, errors.Cause(db.vlog.rewrite(lf, tr)))
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Empty(t, archived)
+
+	fail = false
+	require.NoError(t, db.vlog.rewrite(lf, tr))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, want, archived[lf.fid])
+
+	for i := 45; i < 100; i++ {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte(fmt.Sprintf("key%d", i)))
+			require.NoError(t, err)
+			require.Len(t, getItemValue(t, item), sz)
+			return nil
+		}))
+	}
+}
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+# You might need to go get -v github.com/gogo/protobuf/...
+
+dir=${GOPATH-$HOME/go}/src/github.com/dgraph-io/badger/watch
+pushd $dir > /dev/null
+protoc --gofast_out=plugins=grpc,Mpb.proto=github.com/dgraph-io/badger/v2/pb:. -I=. -I=../pb watch.proto
@@ -0,0 +1,12 @@
+module github.com/dgraph-io/badger/v2/watch
+
+go 1.12
+
+require (
+	github.com/dgraph-io/badger/v2 v2.0.0
+	github.com/golang/protobuf v1.3.2
+	github.com/stretchr/testify v1.4.0
+	google.golang.org/grpc v1.26.0
+)
+
+replace github.com/dgraph-io/badger/v2 => ../
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package watch streams the updates of a Badger DB to other processes over gRPC, so that they can
+// follow the DB as the source of truth, e.g. to invalidate their caches:
+//
+//	s := grpc.NewServer()
+//	watch.RegisterWatchServer(s, watch.NewServer(db, watch.DefaultOptions))
+//
+// Clients subscribe to key prefixes, and get the keys written under them along with their values,
+// as DB.Subscribe passes them. Every response completing a batch of updates carries a resume token.
+// A client reconnecting with the last token it received first gets the latest version of every key
+// written in the meantime, and then the new updates, without missing any:
+//
+//	stream, err := watch.NewWatchClient(conn).Subscribe(ctx, &watch.SubscribeRequest{
+//		Prefixes:    [][]byte{[]byte("users/")},
+//		ResumeToken: token,
+//	})
+//
+// The keys deleted while a client was away are only reported while the DB keeps their deletion
+// markers, which compactions drop once no transaction reads below them, unless NumVersionsToKeep
+// keeps them. The deleted keys come with an empty value.
+//
+// The package is a module of its own, so that the badger module doesn't require gRPC.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options are the options of a Server.
+type Options struct {
+	// BufferSize is the number of batches of updates buffered per subscription, see
+	// badger.SubscribeOptions. The subscriptions of clients which don't keep up are canceled with
+	// codes.ResourceExhausted, and they have to resume.
+	BufferSize int
+	// BatchSize is the number of keys sent per response when catching up with a resume token.
+	BatchSize int
+}
+
+// DefaultOptions are the default options of a Server.
+var DefaultOptions = Options{
+	BufferSize: 1000,
+	BatchSize:  1000,
+}
+
+// Server is a WatchServer streaming the updates of a DB.
+type Server struct {
+	db  *badger.DB
+	opt Options
+}
+
+var _ WatchServer = (*Server)(nil)
+
+// NewServer returns a Server streaming the updates of db, which must be open as long as the
+// Server is registered. It can't be used with managedDB=true.
+func NewServer(db *badger.DB, opt Options) *Server {
+	return &Server{db: db, opt: opt}
+}
+
+// Subscribe streams the updates of the keys under req.Prefixes, starting with the updates
+// committed after req.ResumeToken if set.
+func (s *Server) Subscribe(req *SubscribeRequest, stream Watch_SubscribeServer) error {
+	if len(req.Prefixes) == 0 {
+		return status.Error(codes.InvalidArgument, "At least one prefix is needed")
+	}
+	sinceTs, err := parseToken(req.ResumeToken)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// The updates are held back until the client has caught up with the DB as of readTs, and the
+	// ones it got while catching up are skipped.
+	var readTs uint64
+	started, caughtUp := make(chan struct{}), make(chan struct{})
+	cb := func(kvs *badger.KVList) error {
+		select {
+		case <-caughtUp:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		var fresh []*pb.KV
+		var maxTs uint64
+		for _, kv := range kvs.Kv {
+			if kv.Version > readTs {
+				fresh = append(fresh, kv)
+			}
+			if kv.Version > maxTs {
+				maxTs = kv.Version
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		return stream.Send(&SubscribeResponse{
+			Kvs:         &pb.KVList{Kv: fresh},
+			ResumeToken: token(maxTs),
+		})
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.db.SubscribeWithOptions(ctx, cb, badger.SubscribeOptions{
+			Prefixes:   req.Prefixes,
+			BufferSize: s.opt.BufferSize,
+			Policy:     badger.SubscriptionCancel,
+			Started:    func() { close(started) },
+		})
+	}()
+	select {
+	case <-started:
+	case err := <-errCh:
+		return statusOf(err)
+	}
+
+	txn := s.db.NewTransaction(false)
+	readTs = txn.ReadTs()
+	err = s.catchUp(txn, stream, req.Prefixes, sinceTs)
+	txn.Discard()
+	if err != nil {
+		cancel()
+		<-errCh
+		return statusOf(err)
+	}
+	close(caughtUp)
+	return statusOf(<-errCh)
+}
+
+// catchUp sends the latest version of the keys under prefixes written after sinceTs, as of the
+// read timestamp of txn, followed by the resume token of the read timestamp. Without sinceTs, only
+// the token is sent.
+func (s *Server) catchUp(txn *badger.Txn, stream Watch_SubscribeServer, prefixes [][]byte,
+	sinceTs uint64) error {
+	batch := &pb.KVList{}
+	if sinceTs > 0 {
+		opt := badger.DefaultIteratorOptions
+		opt.AllVersions = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for _, prefix := range disjoint(prefixes) {
+			var lastKey []byte
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				item := it.Item()
+				if bytes.Equal(item.Key(), lastKey) {
+					continue
+				}
+				lastKey = item.KeyCopy(lastKey)
+				if item.Version() <= sinceTs {
+					continue
+				}
+				kv := &pb.KV{
+					Key:       item.KeyCopy(nil),
+					Meta:      []byte{item.UserMeta()},
+					ExpiresAt: item.ExpiresAt(),
+					Version:   item.Version(),
+				}
+				if !item.IsDeletedOrExpired() {
+					val, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					kv.Value = val
+				}
+				batch.Kv = append(batch.Kv, kv)
+				if len(batch.Kv) < s.opt.BatchSize {
+					continue
+				}
+				// The token only moves once all the keys are sent, as they aren't sent in the
+				// order they were written.
+				if err := stream.Send(&SubscribeResponse{Kvs: batch}); err != nil {
+					return err
+				}
+				batch = &pb.KVList{}
+			}
+		}
+	}
+	return stream.Send(&SubscribeResponse{Kvs: batch, ResumeToken: token(txn.ReadTs())})
+}
+
+// disjoint returns prefixes without the prefixes starting with another one, whose keys would be
+// sent twice.
+func disjoint(prefixes [][]byte) [][]byte {
+	sorted := append([][]byte{}, prefixes...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	var out [][]byte
+	for _, p := range sorted {
+		if len(out) > 0 && bytes.HasPrefix(p, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// token returns the resume token of the updates committed up to ts.
+func token(ts uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], ts)
+	return b[:]
+}
+
+func parseToken(tok []byte) (uint64, error) {
+	switch len(tok) {
+	case 0:
+		return 0, nil
+	case 8:
+		return binary.BigEndian.Uint64(tok), nil
+	}
+	return 0, status.Errorf(codes.InvalidArgument, "Invalid resume token %x", tok)
+}
+
+// statusOf returns err as a gRPC status error.
+func statusOf(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case badger.ErrSubscriptionLagging:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestSubscribe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterWatchServer(s, NewServer(db, DefaultOptions))
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := NewWatchClient(conn)
+
+	set := func(key, val string) {
+		require.NoError(t, db.Update(func(txn *badger.Txn) error {
+			if val == "" {
+				return txn.Delete([]byte(key))
+			}
+			return txn.Set([]byte(key), []byte(val))
+		}))
+	}
+	// recv returns the updates received until the next resume token, by key.
+	recv := func(stream Watch_SubscribeClient) (map[string]string, []byte) {
+		updates := make(map[string]string)
+		for {
+			resp, err := stream.Recv()
+			require.NoError(t, err)
+			for _, kv := range resp.Kvs.GetKv() {
+				updates[string(kv.Key)] = string(kv.Value)
+			}
+			if len(resp.ResumeToken) > 0 {
+				return updates, resp.ResumeToken
+			}
+		}
+	}
+
+	set("k0", "v0")
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Subscribe(ctx, &SubscribeRequest{Prefixes: [][]byte{[]byte("k")}})
+	require.NoError(t, err)
+	// A new subscription doesn't get the keys written before.
+	updates, _ := recv(stream)
+	require.Empty(t, updates)
+	set("k1", "v1")
+	updates, tok := recv(stream)
+	require.Equal(t, map[string]string{"k1": "v1"}, updates)
+	cancel()
+
+	// The client gets what it missed when resuming.
+	set("k2", "v2")
+	set("k1", "")
+	set("other", "v")
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream, err = client.Subscribe(ctx, &SubscribeRequest{
+		Prefixes:    [][]byte{[]byte("k"), []byte("k1")},
+		ResumeToken: tok,
+	})
+	require.NoError(t, err)
+	updates, _ = recv(stream)
+	require.Equal(t, map[string]string{"k1": "", "k2": "v2"}, updates)
+	set("k3", "v3")
+	updates, _ = recv(stream)
+	require.Equal(t, map[string]string{"k3": "v3"}, updates)
+
+	stream, err = client.Subscribe(ctx, &SubscribeRequest{
+		Prefixes:    [][]byte{[]byte("k")},
+		ResumeToken: []byte("bad"),
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
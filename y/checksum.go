@@ -17,6 +17,8 @@
 package y
 
 import (
+	"encoding/binary"
+	"hash"
 	"hash/crc32"
 
 	"github.com/dgraph-io/badger/v2/pb"
@@ -35,6 +37,8 @@ func CalculateChecksum(data []byte, ct pb.Checksum_Algorithm) uint64 {
 		return uint64(crc32.Checksum(data, CastagnoliCrcTable))
 	case pb.Checksum_XXHash64:
 		return xxhash.Sum64(data)
+	case pb.Checksum_NONE:
+		return 0
 	default:
 		panic("checksum type not supported")
 	}
@@ -48,3 +52,40 @@ func VerifyChecksum(data []byte, expected *pb.Checksum) error {
 	}
 	return nil
 }
+
+// NewHash32 returns a hash computing the checksums of the ct checksum type, truncated to 32 bits.
+func NewHash32(ct pb.Checksum_Algorithm) hash.Hash32 {
+	switch ct {
+	case pb.Checksum_CRC32C:
+		return crc32.New(CastagnoliCrcTable)
+	case pb.Checksum_XXHash64:
+		return xxHash32{xxhash.New()}
+	case pb.Checksum_NONE:
+		return noHash{}
+	default:
+		panic("checksum type not supported")
+	}
+}
+
+// xxHash32 is the lower 32 bits of XXHash64.
+type xxHash32 struct {
+	hash.Hash64
+}
+
+func (h xxHash32) Size() int     { return 4 }
+func (h xxHash32) Sum32() uint32 { return uint32(h.Sum64()) }
+func (h xxHash32) Sum(b []byte) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], h.Sum32())
+	return append(b, buf[:]...)
+}
+
+// noHash is the hash of pb.Checksum_NONE, whose sum is always 0.
+type noHash struct{}
+
+func (noHash) Write(p []byte) (int, error) { return len(p), nil }
+func (noHash) Sum(b []byte) []byte         { return append(b, 0, 0, 0, 0) }
+func (noHash) Reset()                      {}
+func (noHash) Size() int                   { return 4 }
+func (noHash) BlockSize() int              { return 1 }
+func (noHash) Sum32() uint32               { return 0 }
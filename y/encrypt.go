@@ -20,20 +20,33 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+
+	"github.com/dgraph-io/badger/v2/pb"
 )
 
 // XORBlock encrypts the given data with AES and XOR's with IV.
 // Can be used for both encryption and decryption. IV is of
 // AES block size.
 func XORBlock(src, key, iv []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+	block, err := NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	return XORBlockWithCipher(src, block, iv), nil
+}
+
+// NewCipher returns the AES block cipher for the given key. The cipher is safe for concurrent
+// use, so it can be set up once per key and used with XORBlockWithCipher.
+func NewCipher(key []byte) (cipher.Block, error) {
+	return aes.NewCipher(key)
+}
+
+// XORBlockWithCipher is like XORBlock, but uses a block cipher returned by NewCipher.
+func XORBlockWithCipher(src []byte, block cipher.Block, iv []byte) []byte {
 	stream := cipher.NewCTR(block, iv)
 	dst := make([]byte, len(src))
 	stream.XORKeyStream(dst, src)
-	return dst, nil
+	return dst
 }
 
 // GenerateIV generates IV.
@@ -42,3 +55,34 @@ func GenerateIV() ([]byte, error) {
 	_, err := rand.Read(iv)
 	return iv, err
 }
+
+// NewGCM returns the AES-GCM cipher for the given key. Like the block cipher, it's safe for
+// concurrent use.
+func NewGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateNonce generates a nonce for AES-GCM.
+func GenerateNonce() ([]byte, error) {
+	nonce := make([]byte, gcmNonceSize)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// EncryptionOverhead returns the number of bytes added to the data encrypted by the given
+// algorithm: the IV for AES-CTR, and the nonce and the authentication tag for AES-GCM.
+func EncryptionOverhead(algo pb.EncryptionAlgo) int {
+	if algo == pb.EncryptionAlgo_aes_gcm {
+		return gcmNonceSize + gcmTagSize
+	}
+	return aes.BlockSize
+}
@@ -16,7 +16,10 @@
 
 package y
 
-import "expvar"
+import (
+	"expvar"
+	"sync/atomic"
+)
 
 var (
 	// LSMSize has size of the LSM in bytes
@@ -66,3 +69,87 @@ func init() {
 	VlogSize = expvar.NewMap("badger_vlog_size_bytes")
 	PendingWrites = expvar.NewMap("badger_pending_writes_total")
 }
+
+// Counters holds the cumulative counters of a single DB. Every counter gets added to its global
+// expvar as well. The methods of Counters can be called on a nil pointer, in which case only the
+// expvars get updated.
+type Counters struct {
+	Reads        int64   // Atomic.
+	Writes       int64   // Atomic.
+	BytesRead    int64   // Atomic.
+	BytesWritten int64   // Atomic.
+	Gets         int64   // Atomic.
+	Puts         int64   // Atomic.
+	MemtableGets int64   // Atomic.
+	LSMGets      []int64 // Atomic. Indexed by level.
+	LSMBloomHits []int64 // Atomic. Indexed by level.
+}
+
+// NewCounters returns Counters for a DB with the given number of levels.
+func NewCounters(levels int) *Counters {
+	return &Counters{
+		LSMGets:      make([]int64, levels),
+		LSMBloomHits: make([]int64, levels),
+	}
+}
+
+// AddRead counts a disk read of n bytes.
+func (c *Counters) AddRead(n int64) {
+	NumReads.Add(1)
+	NumBytesRead.Add(n)
+	if c != nil {
+		atomic.AddInt64(&c.Reads, 1)
+		atomic.AddInt64(&c.BytesRead, n)
+	}
+}
+
+// AddWrite counts a disk write of n bytes.
+func (c *Counters) AddWrite(n int64) {
+	NumWrites.Add(1)
+	NumBytesWritten.Add(n)
+	if c != nil {
+		atomic.AddInt64(&c.Writes, 1)
+		atomic.AddInt64(&c.BytesWritten, n)
+	}
+}
+
+// AddGet counts a lookup of a key.
+func (c *Counters) AddGet() {
+	NumGets.Add(1)
+	if c != nil {
+		atomic.AddInt64(&c.Gets, 1)
+	}
+}
+
+// AddPuts counts n entries sent to be written.
+func (c *Counters) AddPuts(n int64) {
+	NumPuts.Add(n)
+	if c != nil {
+		atomic.AddInt64(&c.Puts, n)
+	}
+}
+
+// AddMemtableGet counts a lookup of a key in a memtable.
+func (c *Counters) AddMemtableGet() {
+	NumMemtableGets.Add(1)
+	if c != nil {
+		atomic.AddInt64(&c.MemtableGets, 1)
+	}
+}
+
+// AddLSMGet counts a lookup of a key in a table of the given level, named as in the expvar.
+func (c *Counters) AddLSMGet(level int, name string) {
+	NumLSMGets.Add(name, 1)
+	if c != nil {
+		atomic.AddInt64(&c.LSMGets[level], 1)
+	}
+}
+
+// AddLSMBloomHit counts a lookup of a key skipped thanks to the bloom filter of a table of the
+// given level, named as in the expvar.
+func (c *Counters) AddLSMBloomHit(level int, name string) {
+	NumLSMBloomHits.Add(name, 1)
+	if c != nil {
+		atomic.AddInt64(&c.LSMBloomHits[level], 1)
+	}
+}
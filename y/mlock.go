@@ -0,0 +1,28 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package y
+
+// Mlock locks the pages of b in memory, so that they don't get swapped to disk.
+func Mlock(b []byte) error {
+	return mlock(b)
+}
+
+// Munlock unlocks the pages of b, locked by Mlock.
+func Munlock(b []byte) error {
+	return munlock(b)
+}
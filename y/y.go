@@ -26,6 +26,7 @@ import (
 	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -108,9 +109,18 @@ func Copy(a []byte) []byte {
 	return b
 }
 
+// TsSize is the width of the version timestamp suffixed to every internal key. All the code
+// handling internal keys goes through the helpers below or refers to TsSize, so the suffix is
+// only defined here.
+//
+// The width is fixed. The commit timestamp of a transaction is recovered from the keys it wrote
+// on value log replay, and the oracle relies on versions to serve reads at a snapshot, so a
+// narrower (or empty) suffix would need a different on-disk format for the whole DB.
+const TsSize = 8
+
 // KeyWithTs generates a new key by appending ts to key.
 func KeyWithTs(key []byte, ts uint64) []byte {
-	out := make([]byte, len(key)+8)
+	out := make([]byte, len(key)+TsSize)
 	copy(out, key)
 	binary.BigEndian.PutUint64(out[len(key):], math.MaxUint64-ts)
 	return out
@@ -118,10 +128,10 @@ func KeyWithTs(key []byte, ts uint64) []byte {
 
 // ParseTs parses the timestamp from the key bytes.
 func ParseTs(key []byte) uint64 {
-	if len(key) <= 8 {
+	if len(key) <= TsSize {
 		return 0
 	}
-	return math.MaxUint64 - binary.BigEndian.Uint64(key[len(key)-8:])
+	return math.MaxUint64 - binary.BigEndian.Uint64(key[len(key)-TsSize:])
 }
 
 // CompareKeys checks the key without timestamp and checks the timestamp if keyNoTs
@@ -129,10 +139,32 @@ func ParseTs(key []byte) uint64 {
 // a<timestamp> would be sorted higher than aa<timestamp> if we use bytes.compare
 // All keys should have timestamp.
 func CompareKeys(key1, key2 []byte) int {
-	if cmp := bytes.Compare(key1[:len(key1)-8], key2[:len(key2)-8]); cmp != 0 {
+	if cmp := bytes.Compare(key1[:len(key1)-TsSize], key2[:len(key2)-TsSize]); cmp != 0 {
 		return cmp
 	}
-	return bytes.Compare(key1[len(key1)-8:], key2[len(key2)-8:])
+	return bytes.Compare(key1[len(key1)-TsSize:], key2[len(key2)-TsSize:])
+}
+
+// Comparator defines the order of keys. Keys passed to Compare never carry the timestamp suffix,
+// versions of the same key are always sorted by descending timestamp.
+type Comparator interface {
+	// Name identifies the ordering. Changing the ordering of existing keys requires a new name.
+	Name() string
+	// Compare returns an integer comparing two keys. The result is 0 if key1 == key2, -1 if
+	// key1 < key2 and +1 if key1 > key2. It must only return 0 for byte-wise equal keys.
+	Compare(key1, key2 []byte) int
+}
+
+// CompareKeysWith is like CompareKeys, but compares the part without timestamp using c. A nil c
+// compares byte-wise.
+func CompareKeysWith(c Comparator, key1, key2 []byte) int {
+	if c == nil {
+		return CompareKeys(key1, key2)
+	}
+	if cmp := c.Compare(key1[:len(key1)-TsSize], key2[:len(key2)-TsSize]); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(key1[len(key1)-TsSize:], key2[len(key2)-TsSize:])
 }
 
 // ParseKey parses the actual key from the key bytes.
@@ -141,7 +173,7 @@ func ParseKey(key []byte) []byte {
 		return nil
 	}
 
-	return key[:len(key)-8]
+	return key[:len(key)-TsSize]
 }
 
 // SameKey checks for key equality ignoring the version timestamp suffix.
@@ -186,20 +218,31 @@ func FixedDuration(d time.Duration) string {
 type Closer struct {
 	closed  chan struct{}
 	waiting sync.WaitGroup
+	running int32 // Atomic. The count on the WaitGroup.
 }
 
 // NewCloser constructs a new Closer, with an initial count on the WaitGroup.
 func NewCloser(initial int) *Closer {
-	ret := &Closer{closed: make(chan struct{})}
+	ret := &Closer{closed: make(chan struct{}), running: int32(initial)}
 	ret.waiting.Add(initial)
 	return ret
 }
 
 // AddRunning Add()'s delta to the WaitGroup.
 func (lc *Closer) AddRunning(delta int) {
+	atomic.AddInt32(&lc.running, int32(delta))
 	lc.waiting.Add(delta)
 }
 
+// Running returns the count on the WaitGroup, i.e. the number of goroutines which haven't called
+// Done yet. It returns 0 for a nil Closer.
+func (lc *Closer) Running() int {
+	if lc == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&lc.running))
+}
+
 // Signal signals the HasBeenClosed signal.
 func (lc *Closer) Signal() {
 	close(lc.closed)
@@ -218,6 +261,7 @@ func (lc *Closer) Done() {
 	if lc == nil {
 		return
 	}
+	atomic.AddInt32(&lc.running, -1)
 	lc.waiting.Done()
 }
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/stretchr/testify/require"
 )
 
@@ -254,3 +255,17 @@ func TestPagebufferReader4(t *testing.T) {
 	require.Equal(t, err, io.EOF, "should return EOF")
 	require.Equal(t, n, 0)
 }
+
+func TestNewHash32(t *testing.T) {
+	data := []byte("Hello Badger")
+	for _, algo := range []pb.Checksum_Algorithm{
+		pb.Checksum_CRC32C, pb.Checksum_XXHash64, pb.Checksum_NONE} {
+		h := NewHash32(algo)
+		_, err := h.Write(data[:5])
+		require.NoError(t, err)
+		_, err = h.Write(data[5:])
+		require.NoError(t, err)
+		require.Equal(t, uint32(CalculateChecksum(data, algo)), h.Sum32())
+		require.Equal(t, h.Size(), len(h.Sum(nil)))
+	}
+}